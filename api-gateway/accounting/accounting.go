@@ -0,0 +1,148 @@
+// Package accounting tracks the resources a request consumes (wall time,
+// bytes processed, upstream calls) and cancels it once a hard budget is
+// exceeded, so pathological requests cannot starve the gateway.
+package accounting
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+// ErrBudgetExceeded is the cancellation cause of requests over their budget
+var ErrBudgetExceeded = errors.New("request resource budget exceeded")
+
+// Reasons a budget was exceeded
+const (
+	ReasonWallTime      = "wall_time"
+	ReasonBytes         = "bytes"
+	ReasonUpstreamCalls = "upstream_calls"
+)
+
+// Limits are hard per-request budgets; zero means unlimited
+type Limits struct {
+	WallTime      time.Duration
+	Bytes         int64
+	UpstreamCalls int64
+}
+
+// Usage accumulates the resources consumed by one request. A nil Usage
+// records nothing, so callers need not check whether accounting is enabled.
+type Usage struct {
+	start  time.Time
+	limits Limits
+	cancel context.CancelCauseFunc
+	timer  *time.Timer
+
+	bytes    atomic.Int64
+	calls    atomic.Int64
+	exceeded atomic.Pointer[string]
+}
+
+// Snapshot is the usage of a request at a point in time
+type Snapshot struct {
+	WallTime      time.Duration
+	Bytes         int64
+	UpstreamCalls int64
+}
+
+type contextKey struct{}
+
+// Start begins accounting for a request. The returned context is cancelled
+// with ErrBudgetExceeded when any limit is exceeded; call Stop when done.
+func Start(ctx context.Context, limits Limits) (context.Context, *Usage) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	u := &Usage{
+		start:  time.Now(),
+		limits: limits,
+		cancel: cancel,
+	}
+	if limits.WallTime > 0 {
+		u.timer = time.AfterFunc(limits.WallTime, func() { u.exceed(ReasonWallTime) })
+	}
+	return context.WithValue(ctx, contextKey{}, u), u
+}
+
+// FromContext returns the usage being accounted for ctx, or nil
+func FromContext(ctx context.Context) *Usage {
+	u, _ := ctx.Value(contextKey{}).(*Usage)
+	return u
+}
+
+// Call records an upstream call about to be made, failing once the request
+// is over budget
+func (u *Usage) Call() error {
+	if u == nil {
+		return nil
+	}
+	if u.Exceeded() != "" {
+		return ErrBudgetExceeded
+	}
+	calls := u.calls.Add(1)
+	if u.limits.UpstreamCalls > 0 && calls > u.limits.UpstreamCalls {
+		u.exceed(ReasonUpstreamCalls)
+		return ErrBudgetExceeded
+	}
+	return nil
+}
+
+// AddBytes records request or response bytes processed for the request
+func (u *Usage) AddBytes(n int) {
+	if u == nil {
+		return
+	}
+	bytes := u.bytes.Add(int64(n))
+	if u.limits.Bytes > 0 && bytes > u.limits.Bytes {
+		u.exceed(ReasonBytes)
+	}
+}
+
+// Exceeded returns the first limit the request exceeded, or ""
+func (u *Usage) Exceeded() string {
+	if u == nil {
+		return ""
+	}
+	if reason := u.exceeded.Load(); reason != nil {
+		return *reason
+	}
+	return ""
+}
+
+// Snapshot returns the usage so far
+func (u *Usage) Snapshot() Snapshot {
+	if u == nil {
+		return Snapshot{}
+	}
+	return Snapshot{
+		WallTime:      time.Since(u.start),
+		Bytes:         u.bytes.Load(),
+		UpstreamCalls: u.calls.Load(),
+	}
+}
+
+// Limits returns the budget the request runs under
+func (u *Usage) Limits() Limits {
+	if u == nil {
+		return Limits{}
+	}
+	return u.limits
+}
+
+// Stop ends accounting and releases the request's context
+func (u *Usage) Stop() {
+	if u == nil {
+		return
+	}
+	if u.timer != nil {
+		u.timer.Stop()
+	}
+	u.cancel(context.Canceled)
+}
+
+// exceed marks the request over budget and cancels it; the first reason wins
+func (u *Usage) exceed(reason string) {
+	if u.exceeded.CompareAndSwap(nil, &reason) {
+		u.cancel(ErrBudgetExceeded)
+	}
+}
@@ -0,0 +1,161 @@
+// Package aggregate composes gateway responses from several upstream calls
+// made in parallel.
+package aggregate
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/YeonwooSung/instagram/api-gateway/metrics"
+	"github.com/YeonwooSung/instagram/api-gateway/proxy"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// Call is one upstream GET in an aggregation
+type Call struct {
+	Name     string
+	Upstream string
+	Path     string
+}
+
+// Result is the outcome of a Call
+type Result struct {
+	Status int
+	Body   []byte
+	Err    error
+
+	// Fallback is set when the call failed and Body is its configured fallback
+	Fallback bool
+}
+
+// Branch is the degradation policy of one call: a required call failing fails
+// the whole aggregation, an optional one is left out or replaced by Fallback
+type Branch struct {
+	Required bool
+	Fallback json.RawMessage
+}
+
+// Policy is the degradation matrix of one aggregation endpoint, by call name.
+// Calls without an entry are optional with no fallback.
+type Policy map[string]Branch
+
+// RequiredError reports that a required call of an aggregation failed
+type RequiredError struct {
+	Call string
+}
+
+func (e *RequiredError) Error() string {
+	return fmt.Sprintf("required call %s failed", e.Call)
+}
+
+// OK reports whether the call succeeded with a 2xx response
+func (r Result) OK() bool {
+	return r.Err == nil && r.Status >= 200 && r.Status < 300
+}
+
+// Decode unmarshals a successful JSON response into v
+func (r Result) Decode(v interface{}) error {
+	if !r.OK() {
+		if r.Err != nil {
+			return r.Err
+		}
+		return fmt.Errorf("upstream returned %d", r.Status)
+	}
+	return json.Unmarshal(r.Body, v)
+}
+
+// Aggregator runs upstream calls through the proxy's connection pool and
+// applies each endpoint's degradation policy to the results
+type Aggregator struct {
+	proxy    *proxy.ProxyHandler
+	policies map[string]Policy
+	logger   *zap.Logger
+}
+
+// New creates a new aggregator with degradation policies by endpoint name
+func New(proxyHandler *proxy.ProxyHandler, policies map[string]Policy, logger *zap.Logger) *Aggregator {
+	return &Aggregator{
+		proxy:    proxyHandler,
+		policies: policies,
+		logger:   logger,
+	}
+}
+
+// Fetch runs all calls of an endpoint concurrently and returns their results
+// by name. Failed calls with a fallback are replaced by it; a failed required
+// call returns a *RequiredError.
+func (a *Aggregator) Fetch(ctx context.Context, endpoint string, header http.Header, calls []Call) (map[string]Result, error) {
+	results := make(map[string]Result, len(calls))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	for _, call := range calls {
+		wg.Add(1)
+		go func(call Call) {
+			defer wg.Done()
+
+			start := time.Now()
+			status, body, err := a.proxy.Fetch(ctx, call.Upstream, call.Path, header)
+			result := Result{Status: status, Body: body, Err: err}
+
+			outcome := "ok"
+			if !result.OK() {
+				outcome = "error"
+				a.logger.Warn("Aggregation call failed",
+					zap.String("call", call.Name),
+					zap.Int("status", status),
+					zap.Duration("latency", time.Since(start)),
+					zap.Error(err),
+				)
+			}
+			metrics.Inc("gateway_aggregate_calls_total", "call", call.Name, "result", outcome)
+
+			mu.Lock()
+			results[call.Name] = result
+			mu.Unlock()
+		}(call)
+	}
+
+	wg.Wait()
+
+	policy := a.policies[endpoint]
+	for _, call := range calls {
+		result, branch := results[call.Name], policy[call.Name]
+		if result.OK() {
+			continue
+		}
+		if branch.Required {
+			metrics.Inc("gateway_aggregate_degraded_total", "endpoint", endpoint, "call", call.Name, "outcome", "failed")
+			return results, &RequiredError{Call: call.Name}
+		}
+		outcome := "omitted"
+		if branch.Fallback != nil {
+			results[call.Name] = Result{Status: http.StatusOK, Body: branch.Fallback, Fallback: true}
+			outcome = "fallback"
+		}
+		metrics.Inc("gateway_aggregate_degraded_total", "endpoint", endpoint, "call", call.Name, "outcome", outcome)
+	}
+	return results, nil
+}
+
+// ForwardHeaders returns the caller's headers that aggregated upstream calls carry
+func ForwardHeaders(c *gin.Context) http.Header {
+	header := http.Header{}
+	for _, name := range []string{"Authorization", "Accept-Language"} {
+		if value := c.GetHeader(name); value != "" {
+			header.Set(name, value)
+		}
+	}
+	header.Set("Accept", "application/json")
+	header.Set("X-Forwarded-For", c.ClientIP())
+	header.Set("X-Real-IP", c.ClientIP())
+	if userID, exists := c.Get("user_id"); exists {
+		header.Set("X-User-ID", fmt.Sprintf("%v", userID))
+	}
+	return header
+}
@@ -0,0 +1,269 @@
+// Package auditlog keeps a tamper-evident, append-only log of admin and
+// security-sensitive operations in Redis. Each entry carries the hash of the
+// entry before it, and its own hash covers that link, so altering, removing
+// or reordering any entry breaks the chain from there on; Verify finds the
+// first broken link. The log is never trimmed.
+package auditlog
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/YeonwooSung/instagram/api-gateway/metrics"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+const (
+	// entriesKey is the list of entries; entry n is at index n-1
+	entriesKey = "gateway:auditlog"
+
+	// headKey holds the hash of the last entry
+	headKey = "gateway:auditlog:head"
+
+	// configKey holds the fingerprint of the last configuration recorded
+	configKey = "gateway:auditlog:config"
+
+	// appendAttempts bounds retries of an append racing other instances
+	appendAttempts = 10
+
+	// verifyPage is how many entries Verify reads at a time
+	verifyPage = 500
+)
+
+// Entry categories
+const (
+	CategoryAdmin  = "admin_api"
+	CategoryRoute  = "route"
+	CategoryConfig = "config"
+	CategoryKey    = "key"
+)
+
+// ErrConflict is returned when an append kept losing races with other writers
+var ErrConflict = errors.New("audit log append conflict")
+
+// Entry is one audited operation. PrevHash links it to the entry before it;
+// Hash is the SHA-256 of the entry with Hash empty.
+type Entry struct {
+	Seq      int64             `json:"seq"`
+	At       time.Time         `json:"at"`
+	Category string            `json:"category"`
+	Action   string            `json:"action"`
+	Actor    string            `json:"actor,omitempty"`
+	ClientIP string            `json:"client_ip,omitempty"`
+	Target   string            `json:"target,omitempty"`
+	Status   int               `json:"status,omitempty"`
+	Details  map[string]string `json:"details,omitempty"`
+	PrevHash string            `json:"prev_hash"`
+	Hash     string            `json:"hash"`
+}
+
+// computeHash returns the hash the entry should carry
+func (e Entry) computeHash() (string, error) {
+	e.Hash = ""
+	body, err := json.Marshal(e)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Verification is the result of checking the chain
+type Verification struct {
+	Valid    bool   `json:"valid"`
+	Entries  int64  `json:"entries"`
+	Head     string `json:"head,omitempty"`
+	BrokenAt int64  `json:"broken_at,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+// Log appends to and reads the audit log
+type Log struct {
+	redis  *redis.Client
+	logger *zap.Logger
+}
+
+// New creates an audit log on the given Redis client
+func New(client *redis.Client, logger *zap.Logger) *Log {
+	return &Log{redis: client, logger: logger}
+}
+
+// Append adds an entry to the end of the chain, filling in its sequence
+// number, time and hashes. Appends from several instances are serialized
+// optimistically: the head is watched and the append retried if it moved.
+func (l *Log) Append(ctx context.Context, entry Entry) (Entry, error) {
+	if entry.At.IsZero() {
+		entry.At = time.Now().UTC()
+	}
+
+	for attempt := 0; attempt < appendAttempts; attempt++ {
+		err := l.redis.Watch(ctx, func(tx *redis.Tx) error {
+			head, err := tx.Get(ctx, headKey).Result()
+			if err != nil && !errors.Is(err, redis.Nil) {
+				return err
+			}
+			length, err := tx.LLen(ctx, entriesKey).Result()
+			if err != nil {
+				return err
+			}
+
+			entry.Seq = length + 1
+			entry.PrevHash = head
+			if entry.Hash, err = entry.computeHash(); err != nil {
+				return err
+			}
+			body, err := json.Marshal(entry)
+			if err != nil {
+				return err
+			}
+
+			_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				pipe.RPush(ctx, entriesKey, body)
+				pipe.Set(ctx, headKey, entry.Hash, 0)
+				return nil
+			})
+			return err
+		}, headKey, entriesKey)
+
+		if errors.Is(err, redis.TxFailedErr) {
+			continue
+		}
+		if err != nil {
+			metrics.Inc("gateway_audit_log_appends_total", "category", entry.Category, "result", "error")
+			return Entry{}, err
+		}
+		metrics.Inc("gateway_audit_log_appends_total", "category", entry.Category, "result", "ok")
+		return entry, nil
+	}
+	metrics.Inc("gateway_audit_log_appends_total", "category", entry.Category, "result", "conflict")
+	return Entry{}, ErrConflict
+}
+
+// Record appends an entry, logging instead of returning a failure. A nil Log
+// records nothing.
+func (l *Log) Record(ctx context.Context, entry Entry) {
+	if l == nil {
+		return
+	}
+	if _, err := l.Append(ctx, entry); err != nil {
+		l.logger.Error("Failed to append to audit log",
+			zap.String("category", entry.Category),
+			zap.String("action", entry.Action),
+			zap.Error(err),
+		)
+	}
+}
+
+// RecordConfig appends a config entry when the configuration fingerprint
+// differs from the last one recorded, so a fleet restarting on the same
+// configuration adds a single entry
+func (l *Log) RecordConfig(ctx context.Context, fingerprint string, details map[string]string) {
+	if l == nil {
+		return
+	}
+	previous, err := l.redis.SetArgs(ctx, configKey, fingerprint, redis.SetArgs{Get: true}).Result()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		l.logger.Error("Failed to read last audited configuration", zap.Error(err))
+		return
+	}
+	if previous == fingerprint {
+		return
+	}
+	if details == nil {
+		details = map[string]string{}
+	}
+	details["previous"] = previous
+	l.Record(ctx, Entry{
+		Category: CategoryConfig,
+		Action:   "config.changed",
+		Target:   fingerprint,
+		Details:  details,
+	})
+}
+
+// Entries returns up to limit entries with a sequence number below before
+// (0 for the newest), newest first
+func (l *Log) Entries(ctx context.Context, before, limit int64) ([]Entry, error) {
+	length, err := l.redis.LLen(ctx, entriesKey).Result()
+	if err != nil {
+		return nil, err
+	}
+	last := length
+	if before > 0 && before-1 < last {
+		last = before - 1
+	}
+	if last <= 0 {
+		return []Entry{}, nil
+	}
+	first := max(last-limit+1, 1)
+
+	raws, err := l.redis.LRange(ctx, entriesKey, first-1, last-1).Result()
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]Entry, 0, len(raws))
+	for i := len(raws) - 1; i >= 0; i-- {
+		var entry Entry
+		if err := json.Unmarshal([]byte(raws[i]), &entry); err != nil {
+			return nil, fmt.Errorf("entry %d: %w", first+int64(i), err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// Verify walks the whole chain and reports the first entry whose link or
+// hash does not match
+func (l *Log) Verify(ctx context.Context) (Verification, error) {
+	length, err := l.redis.LLen(ctx, entriesKey).Result()
+	if err != nil {
+		return Verification{}, err
+	}
+
+	prev := ""
+	for start := int64(0); start < length; start += verifyPage {
+		raws, err := l.redis.LRange(ctx, entriesKey, start, start+verifyPage-1).Result()
+		if err != nil {
+			return Verification{}, err
+		}
+		for i, raw := range raws {
+			seq := start + int64(i) + 1
+			broken := func(reason string) (Verification, error) {
+				metrics.Inc("gateway_audit_log_verifications_total", "result", "broken")
+				return Verification{Entries: length, BrokenAt: seq, Reason: reason}, nil
+			}
+
+			var entry Entry
+			if err := json.Unmarshal([]byte(raw), &entry); err != nil {
+				return broken("unreadable entry")
+			}
+			if entry.Seq != seq {
+				return broken("sequence number out of place")
+			}
+			if entry.PrevHash != prev {
+				return broken("link to previous entry does not match")
+			}
+			if hash, err := entry.computeHash(); err != nil || hash != entry.Hash {
+				return broken("entry hash does not match its content")
+			}
+			prev = entry.Hash
+		}
+	}
+
+	head, err := l.redis.Get(ctx, headKey).Result()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return Verification{}, err
+	}
+	if head != prev {
+		metrics.Inc("gateway_audit_log_verifications_total", "result", "broken")
+		return Verification{Entries: length, BrokenAt: length, Reason: "head does not match the last entry"}, nil
+	}
+	metrics.Inc("gateway_audit_log_verifications_total", "result", "valid")
+	return Verification{Valid: true, Entries: length, Head: head}, nil
+}
@@ -0,0 +1,101 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/YeonwooSung/instagram/api-gateway/state"
+	"github.com/redis/go-redis/v9"
+)
+
+// ErrMiss is returned when no usable entry exists for a key
+var ErrMiss = errors.New("cache miss")
+
+// entrySchema versions Entry as stored in Redis
+var entrySchema = state.NewSchema("cache_entry", 1)
+
+// Entry is a cached upstream response
+type Entry struct {
+	Status      int       `json:"status"`
+	ContentType string    `json:"content_type"`
+	Body        []byte    `json:"body"`
+	StoredAt    time.Time `json:"stored_at"`
+
+	// ETag validates the entry for conditional requests; entries stored
+	// without one are validated by a tag computed from Body
+	ETag string `json:"etag,omitempty"`
+}
+
+// Store keeps cached responses in Redis. Entries written with an owner are
+// encrypted at rest and can only be read back for the same owner.
+type Store struct {
+	client *redis.Client
+	cipher *Cipher
+}
+
+// NewStore creates a new response cache store. cipher may be nil, in which
+// case per-owner entries are refused rather than stored in plaintext.
+func NewStore(client *redis.Client, cipher *Cipher) *Store {
+	return &Store{
+		client: client,
+		cipher: cipher,
+	}
+}
+
+// CanStorePrivate reports whether per-owner (encrypted) entries are supported
+func (s *Store) CanStorePrivate() bool {
+	return s.cipher != nil
+}
+
+// Get returns the entry stored under key. owner must match the owner used in Set.
+func (s *Store) Get(ctx context.Context, key, owner string) (*Entry, error) {
+	data, err := s.client.Get(ctx, key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrMiss
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if owner != "" {
+		if s.cipher == nil {
+			return nil, ErrMiss
+		}
+		if data, err = s.cipher.Open(data, []byte(owner)); err != nil {
+			// Wrong owner, retired key or tampering: treat as a miss
+			return nil, ErrMiss
+		}
+	}
+
+	var entry Entry
+	if err := entrySchema.Unmarshal(data, &entry); err != nil {
+		return nil, ErrMiss
+	}
+	return &entry, nil
+}
+
+// Set stores entry under key for ttl. A non-empty owner encrypts the entry and binds it to that owner.
+func (s *Store) Set(ctx context.Context, key, owner string, entry *Entry, ttl time.Duration) error {
+	data, err := entrySchema.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	if owner != "" {
+		if s.cipher == nil {
+			return fmt.Errorf("per-owner cache entries require an encryption key")
+		}
+		if data, err = s.cipher.Seal(data, []byte(owner)); err != nil {
+			return err
+		}
+	}
+
+	return s.client.Set(ctx, key, data, ttl).Err()
+}
+
+// Delete removes the entries stored under keys
+func (s *Store) Delete(ctx context.Context, keys ...string) error {
+	return s.client.Del(ctx, keys...).Err()
+}
@@ -0,0 +1,95 @@
+package cache
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Cipher seals cache entries with AES-256-GCM. Each ciphertext is prefixed
+// with the ID of the key that produced it, so keys can be rotated: the first
+// configured key encrypts new entries while older keys still decrypt entries
+// written before the rotation until they expire.
+type Cipher struct {
+	activeID byte
+	keys     map[byte]cipher.AEAD
+}
+
+// NewCipher parses a key list of the form "2:<base64 key>,1:<base64 key>".
+// Keys must be 32 bytes; IDs are 0-255 and the first key is active.
+func NewCipher(spec string) (*Cipher, error) {
+	c := &Cipher{keys: make(map[byte]cipher.AEAD)}
+
+	for i, part := range strings.Split(spec, ",") {
+		idStr, keyStr, found := strings.Cut(strings.TrimSpace(part), ":")
+		if !found {
+			return nil, fmt.Errorf("cache key %d: expected <id>:<base64 key>", i)
+		}
+
+		id, err := strconv.ParseUint(idStr, 10, 8)
+		if err != nil {
+			return nil, fmt.Errorf("cache key %d: invalid id %q", i, idStr)
+		}
+		if _, exists := c.keys[byte(id)]; exists {
+			return nil, fmt.Errorf("cache key %d: duplicate id %d", i, id)
+		}
+
+		key, err := base64.StdEncoding.DecodeString(keyStr)
+		if err != nil || len(key) != 32 {
+			return nil, fmt.Errorf("cache key %d: must be 32 bytes, base64 encoded", id)
+		}
+
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+
+		if i == 0 {
+			c.activeID = byte(id)
+		}
+		c.keys[byte(id)] = aead
+	}
+
+	return c, nil
+}
+
+// Seal encrypts plaintext bound to aad (e.g. the owning user) with the active key
+func (c *Cipher) Seal(plaintext, aad []byte) ([]byte, error) {
+	aead := c.keys[c.activeID]
+
+	out := make([]byte, 1+aead.NonceSize(), 1+aead.NonceSize()+len(plaintext)+aead.Overhead())
+	out[0] = c.activeID
+	if _, err := rand.Read(out[1:]); err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(out, out[1:], plaintext, aad), nil
+}
+
+// Open decrypts data sealed by any configured key. It fails if aad differs
+// from the value used when sealing, so one user's entry can't be served to another.
+func (c *Cipher) Open(data, aad []byte) ([]byte, error) {
+	if len(data) < 1 {
+		return nil, fmt.Errorf("ciphertext too short")
+	}
+
+	aead, exists := c.keys[data[0]]
+	if !exists {
+		return nil, fmt.Errorf("unknown cache key id %d", data[0])
+	}
+
+	nonceSize := aead.NonceSize()
+	if len(data) < 1+nonceSize {
+		return nil, fmt.Errorf("ciphertext too short")
+	}
+
+	return aead.Open(nil, data[1:1+nonceSize], data[1+nonceSize:], aad)
+}
@@ -0,0 +1,54 @@
+package cache
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"testing"
+)
+
+// benchmarkEntrySize is about the size of a cached feed page
+const benchmarkEntrySize = 16 << 10
+
+func newBenchmarkCipher(b *testing.B) *Cipher {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		b.Fatal(err)
+	}
+	c, err := NewCipher("1:" + base64.StdEncoding.EncodeToString(key))
+	if err != nil {
+		b.Fatal(err)
+	}
+	return c
+}
+
+func BenchmarkSeal(b *testing.B) {
+	c := newBenchmarkCipher(b)
+	plaintext := bytes.Repeat([]byte("x"), benchmarkEntrySize)
+	owner := []byte("user:42")
+
+	b.SetBytes(int64(len(plaintext)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := c.Seal(plaintext, owner); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkOpen(b *testing.B) {
+	c := newBenchmarkCipher(b)
+	owner := []byte("user:42")
+	sealed, err := c.Seal(bytes.Repeat([]byte("x"), benchmarkEntrySize), owner)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.SetBytes(benchmarkEntrySize)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := c.Open(sealed, owner); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
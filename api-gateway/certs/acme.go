@@ -0,0 +1,100 @@
+package certs
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/YeonwooSung/instagram/api-gateway/metrics"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// acmeKeyPrefix namespaces the ACME cache in Redis
+const acmeKeyPrefix = "gateway:acme:"
+
+// ACMEOptions configures automatic certificates
+type ACMEOptions struct {
+	Domains      []string
+	Email        string
+	DirectoryURL string
+
+	// Cache is "redis" or a directory, typically a volume shared by the
+	// replicas
+	Cache string
+
+	// HTTPClient makes the calls to the CA
+	HTTPClient *http.Client
+}
+
+// NewACMEManager returns a manager that obtains certificates for the given
+// domains on their first TLS handshake and renews them before they expire.
+// Certificates and the account key live in the shared cache, so whichever
+// replica gets a certificate first, the others load it from there instead of
+// ordering their own.
+func NewACMEManager(opts ACMEOptions, client *redis.Client, logger *zap.Logger) *autocert.Manager {
+	var cache autocert.Cache = autocert.DirCache(opts.Cache)
+	if opts.Cache == "redis" {
+		cache = RedisCache{client: client}
+	}
+	return &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(opts.Domains...),
+		Email:      opts.Email,
+		Cache:      loggingCache{Cache: cache, logger: logger},
+		Client:     &acme.Client{DirectoryURL: opts.DirectoryURL, HTTPClient: opts.HTTPClient},
+	}
+}
+
+// RedisCache stores ACME certificates and keys in Redis. The values include
+// private keys, so the Redis instance must be as trusted as the gateway.
+type RedisCache struct {
+	client *redis.Client
+}
+
+// Get returns the cached value for key, or autocert.ErrCacheMiss
+func (c RedisCache) Get(ctx context.Context, key string) ([]byte, error) {
+	data, err := c.client.Get(ctx, acmeKeyPrefix+key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, autocert.ErrCacheMiss
+	}
+	return data, err
+}
+
+// Put stores data under key
+func (c RedisCache) Put(ctx context.Context, key string, data []byte) error {
+	return c.client.Set(ctx, acmeKeyPrefix+key, data, 0).Err()
+}
+
+// Delete removes key
+func (c RedisCache) Delete(ctx context.Context, key string) error {
+	return c.client.Del(ctx, acmeKeyPrefix+key).Err()
+}
+
+// loggingCache records certificates being stored, which is when one was
+// issued or renewed
+type loggingCache struct {
+	autocert.Cache
+	logger *zap.Logger
+}
+
+func (c loggingCache) Put(ctx context.Context, key string, data []byte) error {
+	err := c.Cache.Put(ctx, key, data)
+	// The other keys are the account key and HTTP-01 challenge responses;
+	// certificates are keyed by domain, with "+rsa" for RSA ones
+	if strings.HasPrefix(key, "acme_account") || strings.HasSuffix(key, "+http-01") {
+		return err
+	}
+	domain := strings.TrimSuffix(key, "+rsa")
+	if err != nil {
+		metrics.Inc("gateway_acme_certificates_total", "result", "failed")
+		c.logger.Error("Failed to store ACME certificate", zap.String("domain", domain), zap.Error(err))
+		return err
+	}
+	metrics.Inc("gateway_acme_certificates_total", "result", "ok")
+	c.logger.Info("Stored ACME certificate", zap.String("domain", domain))
+	return nil
+}
@@ -0,0 +1,114 @@
+// Package certs serves the gateway's TLS certificate, either from a
+// certificate and key file pair, reloading it when the files change so
+// renewed certificates are picked up without a restart or dropped
+// connections, or obtained and renewed automatically from an ACME CA.
+package certs
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/YeonwooSung/instagram/api-gateway/metrics"
+	"go.uber.org/zap"
+)
+
+// Reloader holds the current certificate for tls.Config.GetCertificate
+type Reloader struct {
+	certFile string
+	keyFile  string
+	logger   *zap.Logger
+
+	cert     atomic.Pointer[tls.Certificate]
+	modified time.Time
+}
+
+// NewReloader loads the certificate and key, failing if they don't make a
+// valid pair
+func NewReloader(certFile, keyFile string, logger *zap.Logger) (*Reloader, error) {
+	r := &Reloader{certFile: certFile, keyFile: keyFile, logger: logger}
+	modified, err := r.lastModified()
+	if err != nil {
+		return nil, err
+	}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	r.modified = modified
+	return r, nil
+}
+
+// GetCertificate returns the current certificate
+func (r *Reloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return r.cert.Load(), nil
+}
+
+// Watch checks the files every interval until ctx is cancelled and reloads
+// the certificate when either changed. A pair that fails to load, such as
+// one caught halfway through being replaced, is retried at the next check;
+// the previous certificate is served meanwhile.
+func (r *Reloader) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		modified, err := r.lastModified()
+		if err != nil || modified.Equal(r.modified) {
+			continue
+		}
+		if err := r.load(); err != nil {
+			metrics.Inc("gateway_tls_certificate_reloads_total", "result", "failed")
+			r.logger.Error("Failed to reload TLS certificate", zap.String("cert_file", r.certFile), zap.Error(err))
+			continue
+		}
+		r.modified = modified
+		metrics.Inc("gateway_tls_certificate_reloads_total", "result", "ok")
+	}
+}
+
+// lastModified returns the later modification time of the two files
+func (r *Reloader) lastModified() (time.Time, error) {
+	certInfo, err := os.Stat(r.certFile)
+	if err != nil {
+		return time.Time{}, err
+	}
+	keyInfo, err := os.Stat(r.keyFile)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if keyInfo.ModTime().After(certInfo.ModTime()) {
+		return keyInfo.ModTime(), nil
+	}
+	return certInfo.ModTime(), nil
+}
+
+func (r *Reloader) load() error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return fmt.Errorf("parse certificate: %w", err)
+	}
+	cert.Leaf = leaf
+	r.cert.Store(&cert)
+
+	metrics.Set("gateway_tls_certificate_expiry_timestamp_seconds", leaf.NotAfter.Unix())
+	r.logger.Info("Loaded TLS certificate",
+		zap.String("subject", leaf.Subject.CommonName),
+		zap.Strings("dns_names", leaf.DNSNames),
+		zap.Time("not_after", leaf.NotAfter),
+	)
+	return nil
+}
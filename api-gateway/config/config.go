@@ -1,9 +1,20 @@
 package config
 
 import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"net"
+	"net/http"
+	"net/url"
 	"os"
+	"path/filepath"
+	"regexp"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/joho/godotenv"
@@ -14,24 +25,58 @@ type Config struct {
 	Port        int
 
 	// Service URLs
-	AuthServiceURL     string
-	MediaServiceURL    string
-	PostServiceURL     string
-	GraphServiceURL    string
-	NewsfeedServiceURL string
+	AuthServiceURL         string
+	MediaServiceURL        string
+	PostServiceURL         string
+	GraphServiceURL        string
+	NewsfeedServiceURL     string
+	AdsServiceURL          string
+	BillingServiceURL      string
+	SettingsServiceURL     string
+	AnalyticsServiceURL    string
+	NotificationServiceURL string
+	MessagingServiceURL    string
+	StoryServiceURL        string
+	SearchServiceURL       string
+	ReelsServiceURL        string
+	LiveServiceURL         string
+	TrendsServiceURL       string
+	ModerationServiceURL   string
+	PresenceServiceURL     string
+	AccountServiceURL      string
+	LocationServiceURL     string
+	CommerceServiceURL     string
 
 	// JWT Configuration
-	JWTSecret string
+	JWTSecret string `json:"-"`
+
+	// Admin API token for gateway management endpoints
+	AdminToken string `json:"-"`
+
+	// Secrets provider (JWT_SECRET and REDIS_PASSWORD rotate at runtime)
+	SecretsProvider string
+	SecretsRefresh  time.Duration
+	VaultAddr       string
+	VaultToken      string `json:"-"`
+	VaultSecretPath string
+	AWSRegion       string
+	AWSSecretID     string
+	Secrets         *Secrets `json:"-"`
 
 	// Rate Limiting
-	RateLimitRPS   int
-	RateLimitBurst int
+	RateLimitRPS        int
+	RateLimitBurst      int
+	RateLimitIPv6Prefix int
 
 	// Redis Configuration
 	RedisAddr     string
-	RedisPassword string
+	RedisPassword string `json:"-"`
 	RedisDB       int
 
+	// Redis liveness check interval and degradation policy by feature
+	RedisCheckInterval time.Duration
+	RedisPolicies      map[string]string
+
 	// Timeouts
 	ReadTimeout  time.Duration
 	WriteTimeout time.Duration
@@ -39,40 +84,742 @@ type Config struct {
 
 	// Proxy Timeout
 	ProxyTimeout time.Duration
+
+	// Upstream connection prewarming
+	PrewarmInterval time.Duration
+	PrewarmConns    int
+
+	// Duplicate write absorption window
+	DedupWindow time.Duration
+
+	// Pagination scraping guard on follower lists and hashtag archives
+	ScrapeAnonMaxDepth int
+	ScrapeStreak       int
+	ScrapeStepInterval time.Duration
+	ScrapeDelay        time.Duration
+	ScrapeMaxDelay     time.Duration
+
+	// Replay protection for critical endpoints
+	ReplayWindow        time.Duration
+	ReplaySigningSecret string `json:"-"`
+
+	// HMAC secret signing the identity headers sent upstream
+	GatewaySigningSecret string `json:"-"`
+
+	// HMAC secret signing download URLs handed to clients
+	DownloadSigningSecret string `json:"-"`
+
+	// TLS termination on PORT: certificate and key files, reloaded when they
+	// change, the oldest TLS version accepted ("1.2" or "1.3") and the TLS 1.2
+	// cipher suites offered (Go's defaults when empty)
+	TLSCertFile       string
+	TLSKeyFile        string
+	TLSMinVersion     string
+	TLSCipherSuites   []string
+	TLSReloadInterval time.Duration
+
+	// Certificates for these hostnames obtained and renewed automatically
+	// from an ACME CA instead of the files above, cached in Redis ("redis")
+	// or a directory shared by the replicas; ACMEHTTPAddr answers HTTP-01
+	// challenges and redirects to HTTPS ("" relies on TLS-ALPN-01 on PORT)
+	ACMEDomains      []string
+	ACMEEmail        string
+	ACMEDirectoryURL string
+	ACMECache        string
+	ACMEHTTPAddr     string
+
+	// Experimental HTTP/3 (QUIC) listener on this UDP port, with the TLS
+	// settings above, advertised with Alt-Svc for HTTP3AltSvcMaxAge (0
+	// disables)
+	HTTP3Port         int
+	HTTP3AltSvcMaxAge time.Duration
+
+	// Internal service-to-service routing plane
+	InternalPort            int
+	ServiceTokens           string `json:"-"`
+	InternalTLSCertFile     string
+	InternalTLSKeyFile      string
+	InternalTLSClientCAFile string
+
+	// Listener for pprof and runtime diagnostics, as host:port on an
+	// internal interface ("" disables)
+	DebugAddr string
+
+	// Listener for the admin API, /metrics and /healthz, as host:port on an
+	// internal interface ("" serves them with the public API)
+	AdminAddr string
+
+	// Push notification throttling on the internal plane
+	PushMaxPerHour     int
+	PushCollapseWindow time.Duration
+
+	// Payment webhook ingestion
+	WebhookSecrets          string `json:"-"`
+	PaymentWebhookTolerance time.Duration
+	PaymentWebhookRetention time.Duration
+
+	// Response cache
+	CacheEncryptionKeys string `json:"-"`
+	FeedCacheTTL        time.Duration
+	StoryTrayCacheTTL   time.Duration
+	TrendsCacheTTL      time.Duration
+
+	// How long past their TTL cached responses are served when the upstream fails
+	CacheMaxStale time.Duration
+
+	// Header carrying the client's country, set by the CDN
+	RegionHeader string
+
+	// Keys sealing pagination cursors
+	CursorKeys string `json:"-"`
+
+	// Tracking consent: banner cookie name and whether missing categories count as granted
+	ConsentCookie  string
+	ConsentDefault string
+
+	// Ads traffic isolation
+	AdsRateLimitRPS    int
+	AdsRateLimitBurst  int
+	AdsMaxConns        int
+	AdsBreakerFailures int
+	AdsBreakerCooldown time.Duration
+
+	// Circuit breaker of upstreams with route fallbacks (the ads breaker
+	// settings apply to ads)
+	FallbackBreakerFailures int
+	FallbackBreakerCooldown time.Duration
+
+	// Direct messages sent per user within one conversation
+	MessageRateLimitRPS   int
+	MessageRateLimitBurst int
+
+	// Searches per user, and the longest query passed to the search cluster
+	SearchRateLimitRPS   int
+	SearchRateLimitBurst int
+	SearchQueryMaxLength int
+
+	// Geo queries per user, and the widest radius (meters) of a nearby query
+	LocationRateLimitRPS   int
+	LocationRateLimitBurst int
+	LocationMaxRadius      int
+
+	// Oldest TLS version shop requests may arrive over ("1.2", "1.3" or
+	// "off"), and the header a TLS terminating load balancer reports the
+	// negotiated version in
+	ShopTLSMinVersion string
+	TLSVersionHeader  string
+
+	// How long a reel's video may stream through the gateway
+	ReelsStreamTimeout time.Duration
+
+	// RTMP(S) ingest endpoints broadcasters are handed with their stream key
+	LiveIngestURLs []string
+
+	// Reports filed per user per hour, and how many may be filed at once
+	ReportsPerHour int
+	ReportsBurst   int
+
+	// Password reset and verification emails one address may be sent, and
+	// account recovery requests one client may make, per hour
+	RecoveryEmailsPerHour int
+	RecoveryPerIPHour     int
+
+	// Enabled social login providers and how long a login flow may take
+	OAuthProviders []string
+	OAuthStateTTL  time.Duration
+
+	// Account data exports: how long a status poll may wait for the job, how
+	// long a download URL is valid, and how long a download may run
+	ExportMaxWait         time.Duration
+	ExportDownloadURLTTL  time.Duration
+	ExportDownloadTimeout time.Duration
+
+	// How long an account deletion's status stays readable after it starts
+	AccountDeletionStatusTTL time.Duration
+
+	// Presence channels each user may hold open per instance, and how long
+	// one lasts before the client has to reconnect
+	PresenceMaxConnections int
+	PresenceStreamTimeout  time.Duration
+
+	// Push devices a user may have registered
+	MaxDevicesPerUser int
+
+	// Crawler verification ("off" or "enforce"), the verified crawler rate
+	// tier, verdict cache TTL, and crawlers added to the built-in ones
+	CrawlerVerification   string
+	CrawlerRateLimitRPS   int
+	CrawlerRateLimitBurst int
+	CrawlerVerifyTTL      time.Duration
+	Crawlers              []Crawler
+
+	// Settings and preferences cache
+	SettingsCacheTTL time.Duration
+
+	// Creator insights aggregation
+	InsightsCacheTTL time.Duration
+	InsightsRefresh  time.Duration
+
+	// Well-known endpoints
+	SecurityContact   string
+	SecurityPolicyURL string
+	ChangePasswordURL string
+	JWKSCacheTTL      time.Duration
+
+	// Aggregated API docs
+	DocsSpecPath string
+	DocsCacheTTL time.Duration
+
+	// Batch endpoint
+	BatchMaxRequests int
+	BatchConcurrency int
+
+	// Multipart upload limits on upload routes, the media types (or type
+	// prefixes) uploaded files are sniffed for (empty accepts any), and file
+	// size limits by type prefix
+	UploadMaxBytes     int64
+	UploadMaxPartBytes int64
+	UploadMaxParts     int
+	UploadMediaTypes   []string
+	UploadTypeMaxBytes map[string]int64
+
+	// Signed direct-to-storage upload URLs: validity and size limit
+	SignedUploadTTL      time.Duration
+	SignedUploadMaxBytes int64
+
+	// Resumable (tus) uploads: directory shared by all replicas (empty
+	// disables them), size limit and how long an unfinished upload is kept
+	TusDir      string
+	TusMaxBytes int64
+	TusExpiry   time.Duration
+
+	// Saga journal: lease before an unfinished orchestration is recovered,
+	// and recoveries before it is given up on
+	JournalLease         time.Duration
+	JournalMaxRecoveries int
+
+	// GraphQL facade ("off" or "on") and its query limits
+	GraphQLFacade      string
+	GraphQLMaxDepth    int
+	GraphQLMaxFields   int
+	GraphQLConcurrency int
+
+	// Response formats transcoded from JSON on request ("msgpack", "protobuf")
+	TranscodeFormats []string
+
+	// Response pruning by the ?fields= query parameter ("off" or "on")
+	FieldFiltering string
+
+	// Log scrubbing ("on" or "off"): keys whose values are masked, and
+	// redaction patterns applied after the built-in ones
+	LogRedaction      string
+	LogRedactKeys     []string
+	LogRedactionRules []RedactionRule
+
+	// Requests logged in full, with their timing breakdown, when slower or
+	// their response larger than these (0 disables each)
+	SlowRequestThreshold   time.Duration
+	LargeResponseThreshold int64
+
+	// Server-Timing response header with the latency breakdown ("off" or "on")
+	ServerTiming string
+
+	// Service discovery
+	DiscoveryMode string
+	K8sNamespace  string
+	K8sPortName   string
+	ConsulAddr    string
+	ConsulToken   string `json:"-"`
+	EtcdAddr      string
+	EtcdPrefix    string
+
+	// Egress proxy for external calls
+	EgressProxyURL string `json:"-"`
+	EgressRules    []EgressRule
+
+	// Config file settings (see CONFIG_FILE)
+	ConfigFile        string
+	Upstreams         map[string]string
+	RateLimitPolicies map[string]RateLimitPolicy
+	Routes            []Route
+	Synthetic         []Synthetic
+	Fallbacks         []Fallback
+
+	// Routes served under /api/v2, and deprecation notices for older routes
+	APIv2        []Route
+	Deprecations []Deprecation
+
+	// Middleware chains by name, and the chain applied to each route group path
+	MiddlewareChains map[string][]MiddlewareSpec
+	GroupChains      map[string]string
+
+	// Degradation matrix of aggregation endpoints: branch policies by endpoint and call
+	Degradation map[string]map[string]DegradationBranch
+
+	// Header transformations by route group path prefix
+	HeaderRules []HeaderRule
+
+	// OpenAPI spec files by upstream name, validated against before proxying
+	OpenAPISpecs map[string]string
+
+	// Error response format ("problem" or "legacy"), problem type URI prefix
+	// and backend error mappings
+	ErrorFormat     string
+	ProblemTypeBase string
+	ErrorMappings   []ErrorMapping
+
+	// Per-user request timelines: share of users captured, retention and cap
+	TimelineSamplePercent int
+	TimelineRetention     time.Duration
+	TimelineMaxEntries    int
+
+	// Outbox stream of gateway-originated events, its delivery workers and
+	// the upstream sink of each event kind
+	OutboxStream      string
+	OutboxMaxLen      int
+	OutboxMaxAttempts int
+	OutboxRetryAfter  time.Duration
+	OutboxWorkers     int
+	OutboxSinks       map[string]OutboxSink
+
+	// Timeout of one partner webhook request
+	WebhookTimeout time.Duration
+
+	// Kafka REST proxy events are published through (empty: no Kafka), how
+	// many events may wait in memory for it, and how they are batched
+	KafkaRESTURL       string
+	KafkaQueueSize     int
+	KafkaBatchSize     int
+	KafkaFlushInterval time.Duration
+
+	// Kafka topic of client analytics events, and the most events a client
+	// may send in one request
+	EventsTopic    string
+	EventsMaxBatch int
+
+	// Kafka topic of security events (logins, rate limit cut-offs, admin
+	// actions, circuit breakers); "none" disables them
+	SecurityEventsTopic string
+
+	// Header/cookie predicates that send requests to alternate upstream URLs
+	RoutingRules []RoutingRule
+
+	// Shadow traffic copies and the cap on concurrent shadow requests
+	Mirrors           []Mirror
+	MirrorMaxInflight int
+
+	// Blue-green URL sets by upstream name, and the automatic rollback policy
+	BlueGreen              map[string]BlueGreenSet
+	BlueGreenErrorPercent  int
+	BlueGreenMinRequests   int
+	BlueGreenMonitorWindow time.Duration
+
+	// Default message and Retry-After of services put into maintenance
+	MaintenanceMessage    string
+	MaintenanceRetryAfter time.Duration
+
+	// HTTP protocol spoken to upstreams by name ("http1", "h2" or "h2c");
+	// the others negotiate HTTP/2 over TLS
+	UpstreamProtocols map[string]string
+
+	// White-label tenants matched by Host header, and whether hosts matching
+	// none are served as the default deployment ("default") or refused
+	// ("reject")
+	Tenants            []Tenant
+	TenantUnknownHosts string
+}
+
+// builtinServices are the upstream names backed by dedicated *_SERVICE_URL settings
+var builtinServices = map[string]bool{
+	"auth":         true,
+	"media":        true,
+	"post":         true,
+	"graph":        true,
+	"newsfeed":     true,
+	"ads":          true,
+	"billing":      true,
+	"settings":     true,
+	"analytics":    true,
+	"notification": true,
+	"messaging":    true,
+	"story":        true,
+	"search":       true,
+	"reels":        true,
+	"live":         true,
+	"trends":       true,
+	"moderation":   true,
+	"presence":     true,
+	"account":      true,
+	"location":     true,
+	"commerce":     true,
 }
 
 func Load() (*Config, error) {
 	// Load .env file if exists (optional in production)
 	_ = godotenv.Load()
 
+	// Load structured config file if configured; env vars still take precedence
+	file := &File{}
+	configFile := getEnv("CONFIG_FILE", "")
+	if configFile != "" {
+		var err error
+		if file, err = LoadFile(configFile); err != nil {
+			return nil, err
+		}
+	}
+
 	cfg := &Config{
-		Environment: getEnv("ENVIRONMENT", "development"),
-		Port:        getEnvAsInt("PORT", 8080),
+		Environment: getEnv("ENVIRONMENT", orString(file.Environment, "development")),
+		Port:        getEnvAsInt("PORT", orInt(file.Port, 8080)),
 
 		// Service URLs
-		AuthServiceURL:     getEnv("AUTH_SERVICE_URL", "http://auth-service:8001"),
-		MediaServiceURL:    getEnv("MEDIA_SERVICE_URL", "http://media-service:8000"),
-		PostServiceURL:     getEnv("POST_SERVICE_URL", "http://post-service:8002"),
-		GraphServiceURL:    getEnv("GRAPH_SERVICE_URL", "http://graph-service:8003"),
-		NewsfeedServiceURL: getEnv("NEWSFEED_SERVICE_URL", "http://newsfeed-service:8004"),
+		AuthServiceURL:         getEnv("AUTH_SERVICE_URL", file.upstream("auth", "http://auth-service:8001")),
+		MediaServiceURL:        getEnv("MEDIA_SERVICE_URL", file.upstream("media", "http://media-service:8000")),
+		PostServiceURL:         getEnv("POST_SERVICE_URL", file.upstream("post", "http://post-service:8002")),
+		GraphServiceURL:        getEnv("GRAPH_SERVICE_URL", file.upstream("graph", "http://graph-service:8003")),
+		NewsfeedServiceURL:     getEnv("NEWSFEED_SERVICE_URL", file.upstream("newsfeed", "http://newsfeed-service:8004")),
+		AdsServiceURL:          getEnv("ADS_SERVICE_URL", file.upstream("ads", "http://ads-service:8005")),
+		BillingServiceURL:      getEnv("BILLING_SERVICE_URL", file.upstream("billing", "http://billing-service:8006")),
+		SettingsServiceURL:     getEnv("SETTINGS_SERVICE_URL", file.upstream("settings", "http://auth-service:8001")),
+		AnalyticsServiceURL:    getEnv("ANALYTICS_SERVICE_URL", file.upstream("analytics", "http://analytics-service:8007")),
+		NotificationServiceURL: getEnv("NOTIFICATION_SERVICE_URL", file.upstream("notification", "http://notification-service:8008")),
+		MessagingServiceURL:    getEnv("MESSAGING_SERVICE_URL", file.upstream("messaging", "http://messaging-service:8009")),
+		StoryServiceURL:        getEnv("STORY_SERVICE_URL", file.upstream("story", "http://story-service:8010")),
+		SearchServiceURL:       getEnv("SEARCH_SERVICE_URL", file.upstream("search", "http://search-service:8011")),
+		ReelsServiceURL:        getEnv("REELS_SERVICE_URL", file.upstream("reels", "http://reels-service:8012")),
+		LiveServiceURL:         getEnv("LIVE_SERVICE_URL", file.upstream("live", "http://live-service:8013")),
+		TrendsServiceURL:       getEnv("TRENDS_SERVICE_URL", file.upstream("trends", "http://analytics-service:8007")),
+		ModerationServiceURL:   getEnv("MODERATION_SERVICE_URL", file.upstream("moderation", "http://moderation-service:8014")),
+		PresenceServiceURL:     getEnv("PRESENCE_SERVICE_URL", file.upstream("presence", "http://presence-service:8015")),
+		AccountServiceURL:      getEnv("ACCOUNT_SERVICE_URL", file.upstream("account", "http://account-service:8016")),
+		LocationServiceURL:     getEnv("LOCATION_SERVICE_URL", file.upstream("location", "http://location-service:8017")),
+		CommerceServiceURL:     getEnv("COMMERCE_SERVICE_URL", file.upstream("commerce", "http://commerce-service:8018")),
 
 		// JWT Configuration
 		JWTSecret: getEnv("JWT_SECRET", "your-secret-key"),
 
+		// Admin API token
+		AdminToken: getEnv("ADMIN_API_TOKEN", ""),
+
+		// Secrets provider
+		SecretsProvider: getEnv("SECRETS_PROVIDER", ""),
+		SecretsRefresh:  time.Duration(getEnvAsInt("SECRETS_REFRESH_SEC", 300)) * time.Second,
+		VaultAddr:       getEnv("VAULT_ADDR", "http://vault:8200"),
+		VaultToken:      getEnv("VAULT_TOKEN", ""),
+		VaultSecretPath: getEnv("VAULT_SECRET_PATH", "secret/data/api-gateway"),
+		AWSRegion:       getEnv("AWS_REGION", ""),
+		AWSSecretID:     getEnv("AWS_SECRET_ID", ""),
+
 		// Rate Limiting
-		RateLimitRPS:   getEnvAsInt("RATE_LIMIT_RPS", 100),
-		RateLimitBurst: getEnvAsInt("RATE_LIMIT_BURST", 200),
+		RateLimitRPS:        getEnvAsInt("RATE_LIMIT_RPS", orInt(file.RateLimit.RPS, 100)),
+		RateLimitBurst:      getEnvAsInt("RATE_LIMIT_BURST", orInt(file.RateLimit.Burst, 200)),
+		RateLimitIPv6Prefix: getEnvAsInt("RATE_LIMIT_IPV6_PREFIX", orInt(file.RateLimit.IPv6Prefix, 64)),
 
 		// Redis Configuration
 		RedisAddr:     getEnv("REDIS_ADDR", "redis:6379"),
 		RedisPassword: getEnv("REDIS_PASSWORD", ""),
 		RedisDB:       getEnvAsInt("REDIS_DB", 0),
 
+		// Redis liveness check interval and degradation policy by feature
+		RedisCheckInterval: getEnvAsSeconds("REDIS_CHECK_INTERVAL_SEC", orDuration(file.Redis.CheckInterval, 5*time.Second)),
+		RedisPolicies:      file.redisPolicies(),
+
 		// Timeouts
-		ReadTimeout:  time.Duration(getEnvAsInt("READ_TIMEOUT_SEC", 30)) * time.Second,
-		WriteTimeout: time.Duration(getEnvAsInt("WRITE_TIMEOUT_SEC", 30)) * time.Second,
-		IdleTimeout:  time.Duration(getEnvAsInt("IDLE_TIMEOUT_SEC", 120)) * time.Second,
-		ProxyTimeout: time.Duration(getEnvAsInt("PROXY_TIMEOUT_SEC", 30)) * time.Second,
+		ReadTimeout:  getEnvAsSeconds("READ_TIMEOUT_SEC", orDuration(file.Timeouts.Read, 30*time.Second)),
+		WriteTimeout: getEnvAsSeconds("WRITE_TIMEOUT_SEC", orDuration(file.Timeouts.Write, 30*time.Second)),
+		IdleTimeout:  getEnvAsSeconds("IDLE_TIMEOUT_SEC", orDuration(file.Timeouts.Idle, 120*time.Second)),
+		ProxyTimeout: getEnvAsSeconds("PROXY_TIMEOUT_SEC", orDuration(file.Timeouts.Proxy, 30*time.Second)),
+
+		// Upstream connection prewarming
+		PrewarmInterval: time.Duration(getEnvAsInt("PREWARM_INTERVAL_SEC", 15)) * time.Second,
+		PrewarmConns:    getEnvAsInt("PREWARM_CONNS", 4),
+
+		// Duplicate write absorption window
+		DedupWindow: time.Duration(getEnvAsInt("DEDUP_WINDOW_SEC", 3)) * time.Second,
+
+		// Pagination scraping guard on follower lists and hashtag archives
+		ScrapeAnonMaxDepth: getEnvAsInt("SCRAPE_ANON_MAX_DEPTH", 10),
+		ScrapeStreak:       getEnvAsInt("SCRAPE_STREAK", 5),
+		ScrapeStepInterval: time.Duration(getEnvAsInt("SCRAPE_STEP_INTERVAL_SEC", 2)) * time.Second,
+		ScrapeDelay:        time.Duration(getEnvAsInt("SCRAPE_DELAY_MS", 250)) * time.Millisecond,
+		ScrapeMaxDelay:     time.Duration(getEnvAsInt("SCRAPE_MAX_DELAY_SEC", 5)) * time.Second,
+
+		// Replay protection for critical endpoints
+		ReplayWindow:        time.Duration(getEnvAsInt("REPLAY_WINDOW_SEC", 300)) * time.Second,
+		ReplaySigningSecret: getEnv("REPLAY_SIGNING_SECRET", ""),
+
+		// HMAC secret signing the identity headers sent upstream
+		GatewaySigningSecret: getEnv("GATEWAY_SIGNING_SECRET", ""),
+
+		// HMAC secret signing download URLs handed to clients
+		DownloadSigningSecret: getEnv("DOWNLOAD_SIGNING_SECRET", ""),
+
+		// TLS termination
+		TLSCertFile:       getEnv("TLS_CERT_FILE", ""),
+		TLSKeyFile:        getEnv("TLS_KEY_FILE", ""),
+		TLSMinVersion:     getEnv("TLS_MIN_VERSION", "1.2"),
+		TLSCipherSuites:   getEnvAsList("TLS_CIPHER_SUITES", ""),
+		TLSReloadInterval: getEnvAsSeconds("TLS_RELOAD_SEC", 30*time.Second),
+
+		// Automatic certificates
+		ACMEDomains:      getEnvAsList("ACME_DOMAINS", ""),
+		ACMEEmail:        getEnv("ACME_EMAIL", ""),
+		ACMEDirectoryURL: getEnv("ACME_DIRECTORY_URL", "https://acme-v02.api.letsencrypt.org/directory"),
+		ACMECache:        getEnv("ACME_CACHE", "redis"),
+		ACMEHTTPAddr:     getEnv("ACME_HTTP_ADDR", ""),
+
+		// HTTP/3
+		HTTP3Port:         getEnvAsInt("HTTP3_PORT", 0),
+		HTTP3AltSvcMaxAge: getEnvAsSeconds("HTTP3_ALT_SVC_MAX_AGE_SEC", 24*time.Hour),
+
+		// Internal service-to-service routing plane
+		InternalPort:            getEnvAsInt("INTERNAL_PORT", 0),
+		ServiceTokens:           getEnv("INTERNAL_SERVICE_TOKENS", ""),
+		InternalTLSCertFile:     getEnv("INTERNAL_TLS_CERT_FILE", ""),
+		InternalTLSKeyFile:      getEnv("INTERNAL_TLS_KEY_FILE", ""),
+		InternalTLSClientCAFile: getEnv("INTERNAL_TLS_CLIENT_CA_FILE", ""),
+
+		// Profiling and runtime diagnostics
+		DebugAddr: getEnv("DEBUG_ADDR", ""),
+
+		// Management listener
+		AdminAddr: getEnv("ADMIN_ADDR", ""),
+
+		// Push notification throttling on the internal plane
+		PushMaxPerHour:     getEnvAsInt("PUSH_DEVICE_MAX_PER_HOUR", 10),
+		PushCollapseWindow: time.Duration(getEnvAsInt("PUSH_COLLAPSE_WINDOW_SEC", 300)) * time.Second,
+
+		// Payment webhook ingestion
+		WebhookSecrets:          getEnv("PAYMENT_WEBHOOK_SECRETS", ""),
+		PaymentWebhookTolerance: time.Duration(getEnvAsInt("PAYMENT_WEBHOOK_TOLERANCE_SEC", 300)) * time.Second,
+		PaymentWebhookRetention: time.Duration(getEnvAsInt("PAYMENT_WEBHOOK_RETENTION_SEC", 604800)) * time.Second,
+
+		// Response cache
+		CacheEncryptionKeys: getEnv("CACHE_ENCRYPTION_KEYS", ""),
+		FeedCacheTTL:        time.Duration(getEnvAsInt("FEED_CACHE_TTL_SEC", 10)) * time.Second,
+		StoryTrayCacheTTL:   time.Duration(getEnvAsInt("STORY_TRAY_CACHE_TTL_SEC", 120)) * time.Second,
+		TrendsCacheTTL:      time.Duration(getEnvAsInt("TRENDS_CACHE_TTL_SEC", 300)) * time.Second,
+		CacheMaxStale:       getEnvAsSeconds("CACHE_MAX_STALE_SEC", 5*time.Minute),
+
+		// Client country from the CDN
+		RegionHeader: getEnv("REGION_HEADER", "CF-IPCountry"),
+
+		// Keys sealing pagination cursors
+		CursorKeys: getEnv("CURSOR_KEYS", ""),
+
+		// Tracking consent
+		ConsentCookie:  getEnv("CONSENT_COOKIE", "consent"),
+		ConsentDefault: getEnv("CONSENT_DEFAULT", "denied"),
+
+		// Ads traffic isolation
+		AdsRateLimitRPS:    getEnvAsInt("ADS_RATE_LIMIT_RPS", 20),
+		AdsRateLimitBurst:  getEnvAsInt("ADS_RATE_LIMIT_BURST", 40),
+		AdsMaxConns:        getEnvAsInt("ADS_MAX_CONNS", 32),
+		AdsBreakerFailures: getEnvAsInt("ADS_BREAKER_FAILURES", 5),
+		AdsBreakerCooldown: time.Duration(getEnvAsInt("ADS_BREAKER_COOLDOWN_SEC", 30)) * time.Second,
+
+		FallbackBreakerFailures: getEnvAsInt("FALLBACK_BREAKER_FAILURES", 5),
+		FallbackBreakerCooldown: getEnvAsSeconds("FALLBACK_BREAKER_COOLDOWN_SEC", 30*time.Second),
+
+		// Direct message flood protection
+		MessageRateLimitRPS:   getEnvAsInt("MESSAGE_RATE_LIMIT_RPS", 1),
+		MessageRateLimitBurst: getEnvAsInt("MESSAGE_RATE_LIMIT_BURST", 10),
+
+		// Search cluster protection
+		SearchRateLimitRPS:   getEnvAsInt("SEARCH_RATE_LIMIT_RPS", 5),
+		SearchRateLimitBurst: getEnvAsInt("SEARCH_RATE_LIMIT_BURST", 15),
+		SearchQueryMaxLength: getEnvAsInt("SEARCH_QUERY_MAX_LENGTH", 100),
+
+		// Location service protection
+		LocationRateLimitRPS:   getEnvAsInt("LOCATION_RATE_LIMIT_RPS", 2),
+		LocationRateLimitBurst: getEnvAsInt("LOCATION_RATE_LIMIT_BURST", 10),
+		LocationMaxRadius:      getEnvAsInt("LOCATION_MAX_RADIUS_M", 50000),
+
+		// Shop transport requirements
+		ShopTLSMinVersion: getEnv("SHOP_TLS_MIN_VERSION", "1.2"),
+		TLSVersionHeader:  getEnv("TLS_VERSION_HEADER", ""),
+
+		// Reels video streaming
+		ReelsStreamTimeout: getEnvAsSeconds("REELS_STREAM_TIMEOUT_SEC", 10*time.Minute),
+
+		// Live broadcast ingest
+		LiveIngestURLs: getEnvAsList("LIVE_INGEST_URLS", "rtmps://live-ingest:443/live"),
+
+		// Report abuse protection
+		ReportsPerHour: getEnvAsInt("REPORTS_PER_HOUR", 20),
+		ReportsBurst:   getEnvAsInt("REPORTS_BURST", 5),
+
+		// Reset-email bombing protection
+		RecoveryEmailsPerHour: getEnvAsInt("RECOVERY_EMAILS_PER_HOUR", 3),
+		RecoveryPerIPHour:     getEnvAsInt("RECOVERY_PER_IP_HOUR", 20),
+
+		// Social login
+		OAuthProviders: getEnvAsList("OAUTH_PROVIDERS", "google,apple,facebook"),
+		OAuthStateTTL:  getEnvAsSeconds("OAUTH_STATE_TTL_SEC", 10*time.Minute),
+
+		// Account data exports
+		ExportMaxWait:         getEnvAsSeconds("EXPORT_MAX_WAIT_SEC", 30*time.Second),
+		ExportDownloadURLTTL:  getEnvAsSeconds("EXPORT_DOWNLOAD_URL_TTL_SEC", 15*time.Minute),
+		ExportDownloadTimeout: getEnvAsSeconds("EXPORT_DOWNLOAD_TIMEOUT_SEC", 30*time.Minute),
+
+		// Account deletion
+		AccountDeletionStatusTTL: getEnvAsSeconds("ACCOUNT_DELETION_STATUS_TTL_SEC", 30*24*time.Hour),
+
+		// Presence channels
+		PresenceMaxConnections: getEnvAsInt("PRESENCE_MAX_CONNECTIONS", 5),
+		PresenceStreamTimeout:  getEnvAsSeconds("PRESENCE_STREAM_TIMEOUT_SEC", time.Hour),
+
+		// Push device registrations
+		MaxDevicesPerUser: getEnvAsInt("MAX_DEVICES_PER_USER", 10),
+
+		// Crawler verification
+		CrawlerVerification:   getEnv("CRAWLER_VERIFICATION", "off"),
+		CrawlerRateLimitRPS:   getEnvAsInt("CRAWLER_RATE_LIMIT_RPS", 20),
+		CrawlerRateLimitBurst: getEnvAsInt("CRAWLER_RATE_LIMIT_BURST", 40),
+		CrawlerVerifyTTL:      time.Duration(getEnvAsInt("CRAWLER_VERIFY_TTL_SEC", 86400)) * time.Second,
+		Crawlers:              file.Crawlers,
+
+		// Settings and preferences cache
+		SettingsCacheTTL: time.Duration(getEnvAsInt("SETTINGS_CACHE_TTL_SEC", 300)) * time.Second,
+
+		// Creator insights aggregation
+		InsightsCacheTTL: time.Duration(getEnvAsInt("INSIGHTS_CACHE_TTL_SEC", 3600)) * time.Second,
+		InsightsRefresh:  time.Duration(getEnvAsInt("INSIGHTS_REFRESH_SEC", 300)) * time.Second,
+
+		// Well-known endpoints
+		SecurityContact:   getEnv("SECURITY_CONTACT", ""),
+		SecurityPolicyURL: getEnv("SECURITY_POLICY_URL", ""),
+		ChangePasswordURL: getEnv("CHANGE_PASSWORD_URL", "/settings/password"),
+		JWKSCacheTTL:      time.Duration(getEnvAsInt("JWKS_CACHE_TTL_SEC", 300)) * time.Second,
+
+		// Aggregated API docs
+		DocsSpecPath: getEnv("DOCS_SPEC_PATH", "/openapi.json"),
+		DocsCacheTTL: time.Duration(getEnvAsInt("DOCS_CACHE_TTL_SEC", 60)) * time.Second,
+
+		// Batch endpoint
+		BatchMaxRequests: getEnvAsInt("BATCH_MAX_REQUESTS", 20),
+		BatchConcurrency: getEnvAsInt("BATCH_CONCURRENCY", 5),
+
+		// Multipart upload limits on upload routes
+		UploadMaxBytes:     int64(getEnvAsInt("UPLOAD_MAX_MB", 100)) << 20,
+		UploadMaxPartBytes: int64(getEnvAsInt("UPLOAD_MAX_PART_MB", 50)) << 20,
+		UploadMaxParts:     getEnvAsInt("UPLOAD_MAX_PARTS", 20),
+		UploadMediaTypes:   getEnvAsList("UPLOAD_MEDIA_TYPES", "image/,video/"),
+		UploadTypeMaxBytes: getEnvAsSizes("UPLOAD_TYPE_MAX_MB", "image/:30,video/:650"),
+
+		// Signed upload URLs
+		SignedUploadTTL:      time.Duration(getEnvAsInt("SIGNED_UPLOAD_TTL_SEC", 900)) * time.Second,
+		SignedUploadMaxBytes: int64(getEnvAsInt("SIGNED_UPLOAD_MAX_MB", 10240)) << 20,
+
+		// Resumable uploads
+		TusDir:      getEnv("TUS_DIR", ""),
+		TusMaxBytes: int64(getEnvAsInt("TUS_MAX_MB", 2048)) << 20,
+		TusExpiry:   time.Duration(getEnvAsInt("TUS_EXPIRY_HOURS", 24)) * time.Hour,
+
+		// Saga journal
+		JournalLease:         time.Duration(getEnvAsInt("JOURNAL_LEASE_SEC", 120)) * time.Second,
+		JournalMaxRecoveries: getEnvAsInt("JOURNAL_MAX_RECOVERIES", 5),
+
+		// GraphQL facade over the REST backends
+		GraphQLFacade:      getEnv("GRAPHQL_FACADE", "off"),
+		GraphQLMaxDepth:    getEnvAsInt("GRAPHQL_MAX_DEPTH", 8),
+		GraphQLMaxFields:   getEnvAsInt("GRAPHQL_MAX_FIELDS", 200),
+		GraphQLConcurrency: getEnvAsInt("GRAPHQL_CONCURRENCY", 8),
+
+		// Response transcoding
+		TranscodeFormats: getEnvAsList("TRANSCODE_FORMATS", "msgpack,protobuf"),
+
+		// Partial responses
+		FieldFiltering: getEnv("FIELD_FILTERING", "on"),
+
+		// Log scrubbing
+		LogRedaction:      getEnv("LOG_REDACTION", orString(file.Logging.Redaction, "on")),
+		LogRedactKeys:     getEnvAsList("LOG_REDACT_KEYS", orString(strings.Join(file.Logging.RedactKeys, ","), "password,passwd,secret,token,api_key,apikey,authorization,cookie")),
+		LogRedactionRules: file.Logging.Redact,
+
+		// Slow request and large response logging
+		SlowRequestThreshold:   time.Duration(getEnvAsInt("SLOW_REQUEST_MS", 2000)) * time.Millisecond,
+		LargeResponseThreshold: int64(getEnvAsInt("LARGE_RESPONSE_KB", 1024)) << 10,
+
+		// Latency breakdown headers
+		ServerTiming: getEnv("SERVER_TIMING", "on"),
+
+		// Service discovery
+		DiscoveryMode: getEnv("DISCOVERY_MODE", "static"),
+		K8sNamespace:  getEnv("K8S_NAMESPACE", ""),
+		K8sPortName:   getEnv("K8S_PORT_NAME", "http"),
+		ConsulAddr:    getEnv("CONSUL_ADDR", "http://consul:8500"),
+		ConsulToken:   getEnv("CONSUL_TOKEN", ""),
+		EtcdAddr:      getEnv("ETCD_ADDR", "http://etcd:2379"),
+		EtcdPrefix:    getEnv("ETCD_PREFIX", "/services"),
+
+		// Egress proxy for external calls
+		EgressProxyURL: getEnv("EGRESS_PROXY_URL", file.Egress.Proxy),
+		EgressRules:    file.Egress.Rules,
+
+		// Config file settings
+		ConfigFile:        configFile,
+		Upstreams:         file.extraUpstreams(),
+		RateLimitPolicies: file.RateLimit.Policies,
+		Routes:            file.Routes,
+		Synthetic:         file.Synthetic,
+		Fallbacks:         file.Fallbacks,
+		APIv2:             file.APIv2,
+		Deprecations:      file.Deprecations,
+		MiddlewareChains:  file.Middleware.Chains,
+		GroupChains:       file.Middleware.Groups,
+		RoutingRules:      file.RoutingRules,
+		HeaderRules:       file.Headers,
+		OpenAPISpecs:      file.OpenAPI,
+		ErrorFormat:       getEnv("ERROR_FORMAT", orString(file.Errors.Format, "problem")),
+		ProblemTypeBase:   getEnv("PROBLEM_TYPE_BASE", file.Errors.TypeBase),
+		ErrorMappings:     file.Errors.Mappings,
+		Degradation:       file.Degradation,
+		OutboxStream:      getEnv("OUTBOX_STREAM", orString(file.Outbox.Stream, "gateway:outbox")),
+		OutboxMaxLen:      getEnvAsInt("OUTBOX_MAX_LEN", 1000000),
+		OutboxMaxAttempts: getEnvAsInt("OUTBOX_MAX_ATTEMPTS", orInt(file.Outbox.MaxAttempts, 10)),
+		OutboxRetryAfter:  getEnvAsSeconds("OUTBOX_RETRY_SEC", orDuration(file.Outbox.RetryAfter, 30*time.Second)),
+		OutboxWorkers:     getEnvAsInt("OUTBOX_WORKERS", orInt(file.Outbox.Workers, 2)),
+		OutboxSinks:       file.outboxSinks(),
+		Mirrors:           file.Mirrors,
+		MirrorMaxInflight: getEnvAsInt("MIRROR_MAX_INFLIGHT", 100),
+
+		// Partner webhooks
+		WebhookTimeout: getEnvAsSeconds("WEBHOOK_TIMEOUT_SEC", 10*time.Second),
+
+		// Kafka event publishing
+		KafkaRESTURL:       getEnv("KAFKA_REST_URL", ""),
+		KafkaQueueSize:     getEnvAsInt("KAFKA_QUEUE_SIZE", 10000),
+		KafkaBatchSize:     getEnvAsInt("KAFKA_BATCH_SIZE", 500),
+		KafkaFlushInterval: time.Duration(getEnvAsInt("KAFKA_FLUSH_MS", 500)) * time.Millisecond,
+		EventsTopic:        getEnv("EVENTS_TOPIC", "client-events"),
+		EventsMaxBatch:     getEnvAsInt("EVENTS_MAX_BATCH", 100),
+
+		// Security event stream
+		SecurityEventsTopic: getEnv("SECURITY_EVENTS_TOPIC", "gateway-security"),
+
+		// Per-user request timelines
+		TimelineSamplePercent: getEnvAsInt("TIMELINE_SAMPLE_PERCENT", 0),
+		TimelineRetention:     time.Duration(getEnvAsInt("TIMELINE_RETENTION_SEC", 86400)) * time.Second,
+		TimelineMaxEntries:    getEnvAsInt("TIMELINE_MAX_ENTRIES", 1000),
+
+		BlueGreen:              file.BlueGreen,
+		BlueGreenErrorPercent:  getEnvAsInt("BLUE_GREEN_ERROR_PERCENT", 5),
+		BlueGreenMinRequests:   getEnvAsInt("BLUE_GREEN_MIN_REQUESTS", 50),
+		BlueGreenMonitorWindow: time.Duration(getEnvAsInt("BLUE_GREEN_MONITOR_SEC", 300)) * time.Second,
+
+		MaintenanceMessage:    getEnv("MAINTENANCE_MESSAGE", "Service is down for maintenance"),
+		MaintenanceRetryAfter: getEnvAsSeconds("MAINTENANCE_RETRY_AFTER_SEC", 5*time.Minute),
+
+		UpstreamProtocols: file.upstreamProtocols(),
+
+		Tenants:            file.Tenants,
+		TenantUnknownHosts: getEnv("TENANT_UNKNOWN_HOSTS", "default"),
+	}
+
+	// Secrets from an external store override the env values
+	if err := cfg.loadSecrets(); err != nil {
+		return nil, err
 	}
 
 	if err := cfg.Validate(); err != nil {
@@ -82,6 +829,50 @@ func Load() (*Config, error) {
 	return cfg, nil
 }
 
+// loadSecrets fetches secrets from the configured provider once at startup
+func (c *Config) loadSecrets() error {
+	provider, err := newSecretsProvider(c)
+	if err != nil {
+		return err
+	}
+
+	initial := map[string]string{
+		SecretJWT:             c.JWTSecret,
+		SecretRedisPassword:   c.RedisPassword,
+		SecretAdminToken:      c.AdminToken,
+		SecretReplaySigning:   c.ReplaySigningSecret,
+		SecretWebhooks:        c.WebhookSecrets,
+		SecretServiceTokens:   c.ServiceTokens,
+		SecretGatewaySigning:  c.GatewaySigningSecret,
+		SecretDownloadSigning: c.DownloadSigningSecret,
+	}
+	if provider != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+		defer cancel()
+
+		values, err := provider.Fetch(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to load secrets from %s: %w", c.SecretsProvider, err)
+		}
+		for name, value := range values {
+			if value != "" {
+				initial[name] = value
+			}
+		}
+	}
+
+	c.Secrets = newSecrets(initial, provider)
+	c.JWTSecret = initial[SecretJWT]
+	c.RedisPassword = initial[SecretRedisPassword]
+	c.AdminToken = initial[SecretAdminToken]
+	c.ReplaySigningSecret = initial[SecretReplaySigning]
+	c.WebhookSecrets = initial[SecretWebhooks]
+	c.ServiceTokens = initial[SecretServiceTokens]
+	c.GatewaySigningSecret = initial[SecretGatewaySigning]
+	c.DownloadSigningSecret = initial[SecretDownloadSigning]
+	return nil
+}
+
 func (c *Config) Validate() error {
 	if c.JWTSecret == "your-secret-key" && c.Environment == "production" {
 		return fmt.Errorf("JWT_SECRET must be set in production")
@@ -91,9 +882,899 @@ func (c *Config) Validate() error {
 		return fmt.Errorf("invalid port number: %d", c.Port)
 	}
 
+	switch c.DiscoveryMode {
+	case "static", "kubernetes", "consul", "etcd":
+	default:
+		return fmt.Errorf("invalid DISCOVERY_MODE: %s", c.DiscoveryMode)
+	}
+
+	upstreams := c.ServiceURLs()
+	for name, rawURL := range upstreams {
+		u, err := url.Parse(rawURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid URL for upstream %q: %s", name, rawURL)
+		}
+	}
+
+	if c.AdsRateLimitRPS <= 0 || c.AdsRateLimitBurst <= 0 || c.AdsBreakerFailures <= 0 {
+		return fmt.Errorf("ads rate limit and breaker settings must be positive")
+	}
+	if c.MessageRateLimitRPS <= 0 || c.MessageRateLimitBurst <= 0 {
+		return fmt.Errorf("MESSAGE_RATE_LIMIT_RPS and MESSAGE_RATE_LIMIT_BURST must be positive")
+	}
+
+	if c.SearchRateLimitRPS <= 0 || c.SearchRateLimitBurst <= 0 {
+		return fmt.Errorf("SEARCH_RATE_LIMIT_RPS and SEARCH_RATE_LIMIT_BURST must be positive")
+	}
+	if c.SearchQueryMaxLength <= 0 {
+		return fmt.Errorf("SEARCH_QUERY_MAX_LENGTH must be positive")
+	}
+	if c.LocationRateLimitRPS <= 0 || c.LocationRateLimitBurst <= 0 {
+		return fmt.Errorf("LOCATION_RATE_LIMIT_RPS and LOCATION_RATE_LIMIT_BURST must be positive")
+	}
+	if c.LocationMaxRadius <= 0 {
+		return fmt.Errorf("LOCATION_MAX_RADIUS_M must be positive")
+	}
+	switch c.ShopTLSMinVersion {
+	case "1.2", "1.3", "off":
+	default:
+		return fmt.Errorf("SHOP_TLS_MIN_VERSION must be 1.2, 1.3 or off")
+	}
+
+	if len(c.LiveIngestURLs) == 0 {
+		return fmt.Errorf("LIVE_INGEST_URLS must list at least one ingest endpoint")
+	}
+	for _, rawURL := range c.LiveIngestURLs {
+		u, err := url.Parse(rawURL)
+		if err != nil || u.Host == "" || (u.Scheme != "rtmp" && u.Scheme != "rtmps") {
+			return fmt.Errorf("invalid LIVE_INGEST_URLS entry: %s", rawURL)
+		}
+	}
+
+	if c.ReportsPerHour <= 0 || c.ReportsBurst <= 0 {
+		return fmt.Errorf("REPORTS_PER_HOUR and REPORTS_BURST must be positive")
+	}
+	if c.RecoveryEmailsPerHour <= 0 || c.RecoveryPerIPHour <= 0 {
+		return fmt.Errorf("RECOVERY_EMAILS_PER_HOUR and RECOVERY_PER_IP_HOUR must be positive")
+	}
+	for _, provider := range c.OAuthProviders {
+		switch provider {
+		case "google", "apple", "facebook":
+		default:
+			return fmt.Errorf("unknown OAuth provider %q (want google, apple or facebook)", provider)
+		}
+	}
+	if c.OAuthStateTTL <= 0 {
+		return fmt.Errorf("OAUTH_STATE_TTL_SEC must be positive")
+	}
+	if c.ExportMaxWait < 0 || c.ExportDownloadURLTTL <= 0 || c.ExportDownloadTimeout <= 0 {
+		return fmt.Errorf("EXPORT_DOWNLOAD_URL_TTL_SEC and EXPORT_DOWNLOAD_TIMEOUT_SEC must be positive and EXPORT_MAX_WAIT_SEC not negative")
+	}
+	if c.AccountDeletionStatusTTL <= 0 {
+		return fmt.Errorf("ACCOUNT_DELETION_STATUS_TTL_SEC must be positive")
+	}
+
+	if c.PresenceMaxConnections <= 0 || c.PresenceStreamTimeout <= 0 {
+		return fmt.Errorf("PRESENCE_MAX_CONNECTIONS and PRESENCE_STREAM_TIMEOUT_SEC must be positive")
+	}
+
+	if c.MaxDevicesPerUser <= 0 {
+		return fmt.Errorf("MAX_DEVICES_PER_USER must be positive")
+	}
+
+	if c.CrawlerVerification != "off" && c.CrawlerVerification != "enforce" {
+		return fmt.Errorf("CRAWLER_VERIFICATION must be off or enforce")
+	}
+	if c.CrawlerRateLimitRPS <= 0 || c.CrawlerRateLimitBurst <= 0 || c.CrawlerVerifyTTL <= 0 {
+		return fmt.Errorf("crawler rate limit and verification TTL must be positive")
+	}
+	for i, crawler := range c.Crawlers {
+		if crawler.Name == "" || crawler.UserAgent == "" || len(crawler.Domains) == 0 {
+			return fmt.Errorf("crawler %d: name, user_agent and domains are required", i)
+		}
+	}
+
+	if c.RedisCheckInterval <= 0 {
+		return fmt.Errorf("REDIS_CHECK_INTERVAL_SEC must be positive")
+	}
+	for feature, mode := range c.RedisPolicies {
+		switch feature {
+		case "dedup", "replay", "cache", "quota":
+		default:
+			return fmt.Errorf("redis policy: unknown feature %q", feature)
+		}
+		switch mode {
+		case "fail_open", "fail_closed", "memory":
+		default:
+			return fmt.Errorf("redis policy %s: invalid mode %q", feature, mode)
+		}
+	}
+
+	if c.ScrapeAnonMaxDepth < 0 || c.ScrapeStreak < 0 {
+		return fmt.Errorf("SCRAPE_ANON_MAX_DEPTH and SCRAPE_STREAK must not be negative")
+	}
+	if c.ScrapeStreak > 0 && (c.ScrapeStepInterval <= 0 || c.ScrapeDelay <= 0 || c.ScrapeMaxDelay < c.ScrapeDelay) {
+		return fmt.Errorf("scrape guard intervals must be positive and SCRAPE_MAX_DELAY_SEC at least SCRAPE_DELAY_MS")
+	}
+
+	if c.ReplayWindow <= 0 {
+		return fmt.Errorf("REPLAY_WINDOW_SEC must be positive")
+	}
+
+	if c.InternalPort < 0 || c.InternalPort > 65535 || (c.InternalPort != 0 && c.InternalPort == c.Port) {
+		return fmt.Errorf("invalid INTERNAL_PORT: %d", c.InternalPort)
+	}
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		return fmt.Errorf("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+	if c.TLSMinVersion != "1.2" && c.TLSMinVersion != "1.3" {
+		return fmt.Errorf("TLS_MIN_VERSION must be 1.2 or 1.3")
+	}
+	for _, name := range c.TLSCipherSuites {
+		if cipherSuiteID(name) == 0 {
+			return fmt.Errorf("unknown or insecure cipher suite in TLS_CIPHER_SUITES: %s", name)
+		}
+	}
+	if c.TLSReloadInterval <= 0 {
+		return fmt.Errorf("TLS_RELOAD_SEC must be positive")
+	}
+	if len(c.ACMEDomains) > 0 {
+		if c.TLSCertFile != "" {
+			return fmt.Errorf("ACME_DOMAINS and TLS_CERT_FILE are mutually exclusive")
+		}
+		if u, err := url.Parse(c.ACMEDirectoryURL); err != nil || u.Scheme != "https" || u.Host == "" {
+			return fmt.Errorf("ACME_DIRECTORY_URL must be an https URL")
+		}
+		if c.ACMECache != "redis" && !filepath.IsAbs(c.ACMECache) {
+			return fmt.Errorf("ACME_CACHE must be redis or an absolute directory path")
+		}
+		if c.ACMEHTTPAddr != "" {
+			if _, _, err := net.SplitHostPort(c.ACMEHTTPAddr); err != nil {
+				return fmt.Errorf("ACME_HTTP_ADDR must be host:port, e.g. :80")
+			}
+		}
+	}
+	if c.HTTP3Port < 0 || c.HTTP3Port > 65535 {
+		return fmt.Errorf("invalid HTTP3_PORT: %d", c.HTTP3Port)
+	}
+	if c.HTTP3Port > 0 {
+		if c.TLSCertFile == "" && len(c.ACMEDomains) == 0 {
+			return fmt.Errorf("HTTP3_PORT requires TLS_CERT_FILE or ACME_DOMAINS")
+		}
+		if c.HTTP3AltSvcMaxAge <= 0 {
+			return fmt.Errorf("HTTP3_ALT_SVC_MAX_AGE_SEC must be positive")
+		}
+	}
+
+	if (c.InternalTLSCertFile == "") != (c.InternalTLSKeyFile == "") {
+		return fmt.Errorf("INTERNAL_TLS_CERT_FILE and INTERNAL_TLS_KEY_FILE must be set together")
+	}
+	if c.InternalTLSClientCAFile != "" && c.InternalTLSCertFile == "" {
+		return fmt.Errorf("INTERNAL_TLS_CLIENT_CA_FILE requires INTERNAL_TLS_CERT_FILE")
+	}
+	if _, err := parseNamedSecrets(c.ServiceTokens); err != nil {
+		return fmt.Errorf("invalid INTERNAL_SERVICE_TOKENS: %w", err)
+	}
+
+	// The diagnostics and management listeners must name the interface they
+	// bind, so they are never exposed on every interface by accident
+	if c.DebugAddr != "" && !isInterfaceAddr(c.DebugAddr) {
+		return fmt.Errorf("DEBUG_ADDR must be host:port on an internal interface, e.g. 127.0.0.1:6060")
+	}
+	if c.AdminAddr != "" && !isInterfaceAddr(c.AdminAddr) {
+		return fmt.Errorf("ADMIN_ADDR must be host:port on an internal interface, e.g. 10.0.0.5:9090")
+	}
+	if c.AdminAddr != "" && c.AdminAddr == c.DebugAddr {
+		return fmt.Errorf("ADMIN_ADDR and DEBUG_ADDR must differ")
+	}
+	if c.PushMaxPerHour < 0 {
+		return fmt.Errorf("PUSH_DEVICE_MAX_PER_HOUR cannot be negative")
+	}
+	if c.PushCollapseWindow < 0 {
+		return fmt.Errorf("PUSH_COLLAPSE_WINDOW_SEC cannot be negative")
+	}
+	if c.CacheMaxStale < 0 {
+		return fmt.Errorf("CACHE_MAX_STALE_SEC cannot be negative")
+	}
+
+	if _, err := parseNamedSecrets(c.WebhookSecrets); err != nil {
+		return fmt.Errorf("invalid PAYMENT_WEBHOOK_SECRETS: %w", err)
+	}
+	if c.PaymentWebhookTolerance <= 0 || c.PaymentWebhookRetention <= 0 {
+		return fmt.Errorf("payment webhook tolerance and retention must be positive")
+	}
+
+	if c.ConsentDefault != "granted" && c.ConsentDefault != "denied" {
+		return fmt.Errorf("CONSENT_DEFAULT must be granted or denied")
+	}
+	if c.ConsentCookie == "" {
+		return fmt.Errorf("CONSENT_COOKIE must not be empty")
+	}
+
+	if c.RateLimitIPv6Prefix < 1 || c.RateLimitIPv6Prefix > 128 {
+		return fmt.Errorf("invalid RATE_LIMIT_IPV6_PREFIX: %d", c.RateLimitIPv6Prefix)
+	}
+
+	for name, policy := range c.RateLimitPolicies {
+		if policy.RPS <= 0 || policy.Burst <= 0 {
+			return fmt.Errorf("rate limit policy %q must have positive rps and burst", name)
+		}
+	}
+
+	seen := make(map[string]bool, len(c.Routes)+len(c.APIv2))
+	routes := make([]Route, 0, len(c.Routes)+len(c.APIv2))
+	routes = append(routes, c.Routes...)
+	for i, route := range c.APIv2 {
+		// v2 paths are relative to the /api/v2 group
+		if !strings.HasPrefix(route.Path, "/") {
+			return fmt.Errorf("api_v2 route %d: path must start with /: %q", i, route.Path)
+		}
+		route.Path = "/api/v2" + route.Path
+		routes = append(routes, route)
+	}
+	for i, route := range routes {
+		if !IsValidMethod(route.Method) {
+			return fmt.Errorf("route %d: invalid method %q", i, route.Method)
+		}
+		if !strings.HasPrefix(route.Path, "/") {
+			return fmt.Errorf("route %d: path must start with /: %q", i, route.Path)
+		}
+		if route.UpstreamPath != "" && !strings.HasPrefix(route.UpstreamPath, "/") {
+			return fmt.Errorf("route %s %s: upstream_path must start with /", route.Method, route.Path)
+		}
+		if _, ok := upstreams[route.Upstream]; !ok {
+			return fmt.Errorf("route %s %s: unknown upstream %q", route.Method, route.Path, route.Upstream)
+		}
+		if route.RateLimit != "" {
+			if _, ok := c.RateLimitPolicies[route.RateLimit]; !ok {
+				return fmt.Errorf("route %s %s: unknown rate limit policy %q", route.Method, route.Path, route.RateLimit)
+			}
+		}
+		if route.Chain != "" {
+			if _, ok := c.MiddlewareChains[route.Chain]; !ok {
+				return fmt.Errorf("route %s %s: unknown middleware chain %q", route.Method, route.Path, route.Chain)
+			}
+		}
+		if route.Timeout < 0 || route.DedupWindow < 0 || route.CacheTTL < 0 {
+			return fmt.Errorf("route %s %s: durations must not be negative", route.Method, route.Path)
+		}
+
+		id := strings.ToUpper(route.Method) + " " + route.Path
+		if seen[id] {
+			return fmt.Errorf("duplicate route: %s", id)
+		}
+		seen[id] = true
+	}
+
+	for i, notice := range c.Deprecations {
+		if !strings.HasPrefix(notice.Path, "/") {
+			return fmt.Errorf("deprecation %d: path must start with /: %q", i, notice.Path)
+		}
+		if notice.DeprecatedAt.IsZero() && notice.Sunset.IsZero() {
+			return fmt.Errorf("deprecation %s: deprecated_at or sunset is required", notice.Path)
+		}
+		if !notice.DeprecatedAt.IsZero() && !notice.Sunset.IsZero() && notice.Sunset.Before(notice.DeprecatedAt.Time) {
+			return fmt.Errorf("deprecation %s: sunset must not be before deprecated_at", notice.Path)
+		}
+	}
+
+	for endpoint, branches := range c.Degradation {
+		for call, branch := range branches {
+			if branch.Required && branch.Fallback != nil {
+				return fmt.Errorf("degradation %s.%s: a required call cannot have a fallback", endpoint, call)
+			}
+			if _, err := json.Marshal(branch.Fallback); err != nil {
+				return fmt.Errorf("degradation %s.%s: fallback is not serializable: %w", endpoint, call, err)
+			}
+		}
+	}
+
+	for i, rule := range c.HeaderRules {
+		if !strings.HasPrefix(rule.Path, "/") {
+			return fmt.Errorf("header rule %d: path must start with /: %q", i, rule.Path)
+		}
+		if rule.Variant != "" && rule.Experiment == "" {
+			return fmt.Errorf("header rule %s: variant requires experiment", rule.Path)
+		}
+		if rule.Experiment != "" || rule.Flag != "" {
+			request := rule.Request
+			if len(request.Rename)+len(request.Remove)+len(request.Set)+len(request.Add) > 0 {
+				return fmt.Errorf("header rule %s: experiment and flag rules can only edit responses", rule.Path)
+			}
+		}
+		for _, ops := range []HeaderOps{rule.Request, rule.Response} {
+			names := append([]string{}, ops.Remove...)
+			for from, to := range ops.Rename {
+				names = append(names, from, to)
+			}
+			for name := range ops.Set {
+				names = append(names, name)
+			}
+			for name := range ops.Add {
+				names = append(names, name)
+			}
+			for _, name := range names {
+				if !isValidHeaderName(name) {
+					return fmt.Errorf("header rule %s: invalid header name %q", rule.Path, name)
+				}
+			}
+		}
+	}
+
+	for name, path := range c.OpenAPISpecs {
+		if _, ok := upstreams[name]; !ok {
+			return fmt.Errorf("openapi: unknown upstream %q", name)
+		}
+		if path == "" {
+			return fmt.Errorf("openapi %q: spec path is required", name)
+		}
+	}
+
+	if c.TimelineSamplePercent < 0 || c.TimelineSamplePercent > 100 {
+		return fmt.Errorf("TIMELINE_SAMPLE_PERCENT must be between 0 and 100")
+	}
+	if c.TimelineRetention <= 0 || c.TimelineMaxEntries <= 0 {
+		return fmt.Errorf("TIMELINE_RETENTION_SEC and TIMELINE_MAX_ENTRIES must be positive")
+	}
+
+	if c.BatchMaxRequests > 0 && c.BatchConcurrency <= 0 {
+		return fmt.Errorf("BATCH_CONCURRENCY must be positive")
+	}
+	if c.UploadMaxBytes < 0 || c.UploadMaxPartBytes < 0 || c.UploadMaxParts < 0 {
+		return fmt.Errorf("upload limits must not be negative")
+	}
+	for prefix, size := range c.UploadTypeMaxBytes {
+		if size <= 0 {
+			return fmt.Errorf("UPLOAD_TYPE_MAX_MB for %s must be a positive number", prefix)
+		}
+	}
+	if c.SignedUploadTTL <= 0 || c.SignedUploadMaxBytes <= 0 {
+		return fmt.Errorf("SIGNED_UPLOAD_TTL_SEC and SIGNED_UPLOAD_MAX_MB must be positive")
+	}
+	if c.TusDir != "" && (c.TusMaxBytes <= 0 || c.TusExpiry <= 0) {
+		return fmt.Errorf("TUS_MAX_MB and TUS_EXPIRY_HOURS must be positive")
+	}
+	if c.JournalLease <= c.ProxyTimeout {
+		return fmt.Errorf("JOURNAL_LEASE_SEC must exceed the proxy timeout")
+	}
+	if c.JournalMaxRecoveries < 0 {
+		return fmt.Errorf("JOURNAL_MAX_RECOVERIES cannot be negative")
+	}
+
+	if c.GraphQLFacade != "off" && c.GraphQLFacade != "on" {
+		return fmt.Errorf("GRAPHQL_FACADE must be off or on")
+	}
+	if c.GraphQLMaxDepth <= 0 || c.GraphQLMaxFields <= 0 || c.GraphQLConcurrency <= 0 {
+		return fmt.Errorf("GraphQL depth, field and concurrency limits must be positive")
+	}
+
+	for _, format := range c.TranscodeFormats {
+		if format != "msgpack" && format != "protobuf" {
+			return fmt.Errorf("unknown transcode format %q: must be msgpack or protobuf", format)
+		}
+	}
+
+	if c.LogRedaction != "off" && c.LogRedaction != "on" {
+		return fmt.Errorf("invalid LOG_REDACTION: %s", c.LogRedaction)
+	}
+	for i, rule := range c.LogRedactionRules {
+		if rule.Name == "" {
+			return fmt.Errorf("redaction rule %d: name is required", i)
+		}
+		if _, err := regexp.Compile(rule.Pattern); err != nil || rule.Pattern == "" {
+			return fmt.Errorf("redaction rule %q: invalid pattern", rule.Name)
+		}
+	}
+
+	if c.SlowRequestThreshold < 0 || c.LargeResponseThreshold < 0 {
+		return fmt.Errorf("SLOW_REQUEST_MS and LARGE_RESPONSE_KB must not be negative")
+	}
+	if c.ServerTiming != "off" && c.ServerTiming != "on" {
+		return fmt.Errorf("SERVER_TIMING must be off or on")
+	}
+
+	if c.FieldFiltering != "off" && c.FieldFiltering != "on" {
+		return fmt.Errorf("FIELD_FILTERING must be off or on")
+	}
+
+	if c.OutboxStream == "" || c.OutboxMaxLen <= 0 || c.OutboxMaxAttempts <= 0 || c.OutboxRetryAfter <= 0 {
+		return fmt.Errorf("outbox stream, max length, max attempts and retry interval must be set")
+	}
+	if c.OutboxWorkers < 0 {
+		return fmt.Errorf("OUTBOX_WORKERS must not be negative")
+	}
+	for kind, sink := range c.OutboxSinks {
+		switch kind {
+		case "audit", "analytics", "push", "alert":
+		default:
+			return fmt.Errorf("outbox sink: unknown event kind %q", kind)
+		}
+		if _, ok := upstreams[sink.Upstream]; !ok {
+			return fmt.Errorf("outbox sink %s: unknown upstream %q", kind, sink.Upstream)
+		}
+		if !strings.HasPrefix(sink.Path, "/") {
+			return fmt.Errorf("outbox sink %s: path must start with /", kind)
+		}
+	}
+	if c.WebhookTimeout <= 0 {
+		return fmt.Errorf("WEBHOOK_TIMEOUT_SEC must be positive")
+	}
+	if c.KafkaRESTURL != "" {
+		u, err := url.Parse(c.KafkaRESTURL)
+		if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+			return fmt.Errorf("invalid KAFKA_REST_URL: %s", c.KafkaRESTURL)
+		}
+	}
+	if c.KafkaQueueSize <= 0 || c.KafkaBatchSize <= 0 || c.KafkaFlushInterval <= 0 {
+		return fmt.Errorf("KAFKA_QUEUE_SIZE, KAFKA_BATCH_SIZE and KAFKA_FLUSH_MS must be positive")
+	}
+	if c.EventsTopic == "" || c.EventsMaxBatch <= 0 {
+		return fmt.Errorf("EVENTS_TOPIC must be set and EVENTS_MAX_BATCH positive")
+	}
+
+	if c.ErrorFormat != "problem" && c.ErrorFormat != "legacy" {
+		return fmt.Errorf("invalid ERROR_FORMAT: %s", c.ErrorFormat)
+	}
+	for i, mapping := range c.ErrorMappings {
+		if _, ok := upstreams[mapping.Upstream]; !ok {
+			return fmt.Errorf("error mapping %d: unknown upstream %q", i, mapping.Upstream)
+		}
+		if mapping.Status != 0 && (mapping.Status < 400 || mapping.Status > 599) {
+			return fmt.Errorf("error mapping %d: status must be an error status", i)
+		}
+		if mapping.Code == "" && mapping.Title == "" && mapping.Detail == "" {
+			return fmt.Errorf("error mapping %d: one of code, title or detail is required", i)
+		}
+	}
+
+	for i, rule := range c.RoutingRules {
+		if _, ok := upstreams[rule.Upstream]; !ok {
+			return fmt.Errorf("routing rule %d: unknown upstream %q", i, rule.Upstream)
+		}
+		if (rule.Header == "") == (rule.Cookie == "") {
+			return fmt.Errorf("routing rule %d: exactly one of header or cookie is required", i)
+		}
+		u, err := url.Parse(rule.Target)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("routing rule %d: invalid target URL: %s", i, rule.Target)
+		}
+	}
+
+	mirrored := make(map[string]bool, len(c.Mirrors))
+	for i, mirror := range c.Mirrors {
+		if _, ok := upstreams[mirror.Upstream]; !ok {
+			return fmt.Errorf("mirror %d: unknown upstream %q", i, mirror.Upstream)
+		}
+		if mirrored[mirror.Upstream] {
+			return fmt.Errorf("mirror %d: upstream %q is already mirrored", i, mirror.Upstream)
+		}
+		mirrored[mirror.Upstream] = true
+		u, err := url.Parse(mirror.Target)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("mirror %d: invalid target URL: %s", i, mirror.Target)
+		}
+		if mirror.Percent <= 0 || mirror.Percent > 100 {
+			return fmt.Errorf("mirror %d: percent must be in (0, 100]", i)
+		}
+	}
+	if len(c.Mirrors) > 0 && c.MirrorMaxInflight <= 0 {
+		return fmt.Errorf("MIRROR_MAX_INFLIGHT must be positive")
+	}
+
+	for name, set := range c.BlueGreen {
+		if _, ok := upstreams[name]; !ok {
+			return fmt.Errorf("blue_green: unknown upstream %q", name)
+		}
+		for _, rawURL := range []string{set.Blue, set.Green} {
+			u, err := url.Parse(rawURL)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return fmt.Errorf("blue_green %q: invalid URL: %s", name, rawURL)
+			}
+		}
+	}
+	for name, protocol := range c.UpstreamProtocols {
+		baseURL, ok := upstreams[name]
+		if !ok {
+			return fmt.Errorf("upstream protocol: unknown upstream %q", name)
+		}
+		switch protocol {
+		case "http1", "h2":
+		case "h2c":
+			// Prior knowledge HTTP/2 is only spoken over plaintext
+			if u, err := url.Parse(baseURL); err != nil || u.Scheme != "http" {
+				return fmt.Errorf("upstream protocol %s: h2c needs an http:// upstream URL", name)
+			}
+		default:
+			return fmt.Errorf("upstream protocol %s: invalid protocol %q", name, protocol)
+		}
+	}
+	tenantNames := make(map[string]bool, len(c.Tenants))
+	tenantHosts := make(map[string]string)
+	for i, tenant := range c.Tenants {
+		if tenant.Name == "" || len(tenant.Hosts) == 0 {
+			return fmt.Errorf("tenant %d: name and hosts are required", i)
+		}
+		if tenantNames[tenant.Name] {
+			return fmt.Errorf("tenant %q is declared twice", tenant.Name)
+		}
+		tenantNames[tenant.Name] = true
+		for _, host := range tenant.Hosts {
+			host = strings.ToLower(host)
+			bare := strings.TrimPrefix(host, "*.")
+			if bare == "" || strings.ContainsAny(bare, "*:/ ") {
+				return fmt.Errorf("tenant %s: invalid host %q", tenant.Name, host)
+			}
+			if other, ok := tenantHosts[host]; ok {
+				return fmt.Errorf("tenant %s: host %q already belongs to tenant %s", tenant.Name, host, other)
+			}
+			tenantHosts[host] = tenant.Name
+		}
+		for name, rawURL := range tenant.Upstreams {
+			if _, ok := upstreams[name]; !ok {
+				return fmt.Errorf("tenant %s: unknown upstream %q", tenant.Name, name)
+			}
+			u, err := url.Parse(rawURL)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return fmt.Errorf("tenant %s: invalid URL for upstream %s: %s", tenant.Name, name, rawURL)
+			}
+		}
+		if tenant.RateLimit != "" {
+			if _, ok := c.RateLimitPolicies[tenant.RateLimit]; !ok {
+				return fmt.Errorf("tenant %s: unknown rate limit policy %q", tenant.Name, tenant.RateLimit)
+			}
+		}
+		switch tenant.Auth {
+		case "", "optional", "required":
+		default:
+			return fmt.Errorf("tenant %s: auth must be optional or required", tenant.Name)
+		}
+	}
+	if c.MaintenanceRetryAfter <= 0 {
+		return fmt.Errorf("MAINTENANCE_RETRY_AFTER_SEC must be positive")
+	}
+	if c.TenantUnknownHosts != "default" && c.TenantUnknownHosts != "reject" {
+		return fmt.Errorf("TENANT_UNKNOWN_HOSTS must be default or reject")
+	}
+	if c.BlueGreenErrorPercent < 1 || c.BlueGreenErrorPercent > 100 {
+		return fmt.Errorf("invalid BLUE_GREEN_ERROR_PERCENT: %d", c.BlueGreenErrorPercent)
+	}
+	if c.BlueGreenMinRequests <= 0 || c.BlueGreenMonitorWindow <= 0 {
+		return fmt.Errorf("BLUE_GREEN_MIN_REQUESTS and BLUE_GREEN_MONITOR_SEC must be positive")
+	}
+
+	for name, chain := range c.MiddlewareChains {
+		for i, spec := range chain {
+			if spec.Name == "" {
+				return fmt.Errorf("middleware chain %q entry %d: name is required", name, i)
+			}
+		}
+	}
+	for group, chain := range c.GroupChains {
+		if !strings.HasPrefix(group, "/") {
+			return fmt.Errorf("middleware group must be a path starting with /: %q", group)
+		}
+		if _, ok := c.MiddlewareChains[chain]; !ok {
+			return fmt.Errorf("middleware group %s: unknown chain %q", group, chain)
+		}
+	}
+
+	if c.EgressProxyURL != "" {
+		u, err := url.Parse(c.EgressProxyURL)
+		if err != nil || u.Host == "" {
+			return fmt.Errorf("invalid EGRESS_PROXY_URL")
+		}
+		switch u.Scheme {
+		case "http", "https", "socks5", "socks5h":
+		default:
+			return fmt.Errorf("unsupported egress proxy scheme: %s", u.Scheme)
+		}
+	}
+	for i, rule := range c.EgressRules {
+		if rule.Host == "" {
+			return fmt.Errorf("egress rule %d: host is required", i)
+		}
+		switch rule.Action {
+		case "direct", "deny":
+		case "proxy":
+			if c.EgressProxyURL == "" {
+				return fmt.Errorf("egress rule %s: action proxy requires an egress proxy", rule.Host)
+			}
+		default:
+			return fmt.Errorf("egress rule %s: invalid action %q", rule.Host, rule.Action)
+		}
+	}
+
+	for i, endpoint := range c.Synthetic {
+		if endpoint.Method != "" && !IsValidMethod(endpoint.Method) {
+			return fmt.Errorf("synthetic endpoint %d: invalid method %q", i, endpoint.Method)
+		}
+		if !strings.HasPrefix(endpoint.Path, "/") {
+			return fmt.Errorf("synthetic endpoint %d: path must start with /: %q", i, endpoint.Path)
+		}
+
+		bodies := 0
+		if endpoint.JSON != nil {
+			if _, err := json.Marshal(endpoint.JSON); err != nil {
+				return fmt.Errorf("synthetic endpoint %s: json is not serializable: %w", endpoint.Path, err)
+			}
+			bodies++
+		}
+		if endpoint.Body != "" {
+			bodies++
+		}
+		if endpoint.Redirect != "" {
+			bodies++
+		}
+		if bodies > 1 {
+			return fmt.Errorf("synthetic endpoint %s: only one of json, body or redirect may be set", endpoint.Path)
+		}
+		if endpoint.Status != 0 && (endpoint.Status < 100 || endpoint.Status > 599) {
+			return fmt.Errorf("synthetic endpoint %s: invalid status %d", endpoint.Path, endpoint.Status)
+		}
+		if endpoint.Redirect != "" && endpoint.Status != 0 &&
+			(endpoint.Status < http.StatusMultipleChoices || endpoint.Status > http.StatusPermanentRedirect) {
+			return fmt.Errorf("synthetic endpoint %s: redirect status must be 3xx, got %d", endpoint.Path, endpoint.Status)
+		}
+
+		method := endpoint.Method
+		if method == "" {
+			method = http.MethodGet
+		}
+		id := strings.ToUpper(method) + " " + endpoint.Path
+		if seen[id] {
+			return fmt.Errorf("duplicate route: %s", id)
+		}
+		seen[id] = true
+	}
+
+	if len(c.Fallbacks) > 0 && (c.FallbackBreakerFailures <= 0 || c.FallbackBreakerCooldown <= 0) {
+		return fmt.Errorf("FALLBACK_BREAKER_FAILURES and FALLBACK_BREAKER_COOLDOWN_SEC must be positive")
+	}
+	fallbacks := make(map[string]bool, len(c.Fallbacks))
+	for i, fallback := range c.Fallbacks {
+		if fallback.Method != "" && !IsValidMethod(fallback.Method) {
+			return fmt.Errorf("fallback %d: invalid method %q", i, fallback.Method)
+		}
+		if !strings.HasPrefix(fallback.Path, "/") {
+			return fmt.Errorf("fallback %d: path must start with /: %q", i, fallback.Path)
+		}
+		if _, ok := upstreams[fallback.Upstream]; !ok {
+			return fmt.Errorf("fallback %s: unknown upstream %q", fallback.Path, fallback.Upstream)
+		}
+		if fallback.JSON == nil {
+			return fmt.Errorf("fallback %s: json is required", fallback.Path)
+		}
+		if _, err := json.Marshal(fallback.JSON); err != nil {
+			return fmt.Errorf("fallback %s: json is not serializable: %w", fallback.Path, err)
+		}
+		if fallback.Status != 0 && (fallback.Status < 200 || fallback.Status > 599) {
+			return fmt.Errorf("fallback %s: invalid status %d", fallback.Path, fallback.Status)
+		}
+
+		method := fallback.Method
+		if method == "" {
+			method = http.MethodGet
+		}
+		id := strings.ToUpper(method) + " " + fallback.Path
+		if fallbacks[id] {
+			return fmt.Errorf("duplicate fallback: %s", id)
+		}
+		fallbacks[id] = true
+	}
+
 	return nil
 }
 
+// ServiceURLs returns the configured upstream base URL for each backend service
+func (c *Config) ServiceURLs() map[string]string {
+	urls := map[string]string{
+		"auth":         c.AuthServiceURL,
+		"media":        c.MediaServiceURL,
+		"post":         c.PostServiceURL,
+		"graph":        c.GraphServiceURL,
+		"newsfeed":     c.NewsfeedServiceURL,
+		"ads":          c.AdsServiceURL,
+		"billing":      c.BillingServiceURL,
+		"settings":     c.SettingsServiceURL,
+		"analytics":    c.AnalyticsServiceURL,
+		"notification": c.NotificationServiceURL,
+		"messaging":    c.MessagingServiceURL,
+		"story":        c.StoryServiceURL,
+		"search":       c.SearchServiceURL,
+		"reels":        c.ReelsServiceURL,
+		"live":         c.LiveServiceURL,
+		"trends":       c.TrendsServiceURL,
+		"moderation":   c.ModerationServiceURL,
+		"presence":     c.PresenceServiceURL,
+		"account":      c.AccountServiceURL,
+		"location":     c.LocationServiceURL,
+		"commerce":     c.CommerceServiceURL,
+	}
+	for name, url := range c.Upstreams {
+		urls[name] = url
+	}
+	return urls
+}
+
+// Fingerprint returns a short hash of the effective configuration (secrets
+// excluded) so operators can tell which config generation a replica runs
+func (c *Config) Fingerprint() string {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return "unknown"
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])[:12]
+}
+
+// EnabledFeatures lists the optional gateway features turned on by this configuration
+func (c *Config) EnabledFeatures() []string {
+	features := []string{}
+	if c.PrewarmInterval > 0 {
+		features = append(features, "upstream_prewarm")
+	}
+	if c.DedupWindow > 0 {
+		features = append(features, "write_dedup")
+	}
+	if c.CacheEncryptionKeys != "" {
+		features = append(features, "encrypted_cache")
+	}
+	if c.CacheMaxStale > 0 {
+		features = append(features, "serve_stale")
+	}
+	if c.CursorKeys != "" {
+		features = append(features, "cursor_pagination")
+	}
+	if len(c.Routes) > 0 {
+		features = append(features, "config_routes")
+	}
+	if len(c.APIv2) > 0 {
+		features = append(features, "api_v2")
+	}
+	if len(c.Deprecations) > 0 {
+		features = append(features, "deprecation_headers")
+	}
+	if c.DiscoveryMode != "static" {
+		features = append(features, "discovery_"+c.DiscoveryMode)
+	}
+	if c.SecretsProvider != "" {
+		features = append(features, "secrets_"+c.SecretsProvider)
+	}
+	if c.EgressProxyURL != "" {
+		features = append(features, "egress_proxy")
+	}
+	if len(c.Synthetic) > 0 {
+		features = append(features, "synthetic_endpoints")
+	}
+	if len(c.Fallbacks) > 0 {
+		features = append(features, "route_fallbacks")
+	}
+	if c.GatewaySigningSecret != "" {
+		features = append(features, "identity_signing")
+	}
+	if c.TLSCertFile != "" {
+		features = append(features, "tls")
+	}
+	if len(c.ACMEDomains) > 0 {
+		features = append(features, "acme")
+	}
+	if c.HTTP3Port > 0 {
+		features = append(features, "http3")
+	}
+	if c.DebugAddr != "" {
+		features = append(features, "diagnostics")
+	}
+	if c.AdminAddr != "" {
+		features = append(features, "management_listener")
+	}
+	if c.InternalPort > 0 {
+		features = append(features, "internal_plane")
+		if _, ok := c.OutboxSinks["push"]; ok {
+			features = append(features, "push_throttling")
+		}
+	}
+	if c.TusDir != "" {
+		features = append(features, "resumable_uploads")
+	}
+	if len(c.BlueGreen) > 0 {
+		features = append(features, "blue_green")
+	}
+	if len(c.Mirrors) > 0 {
+		features = append(features, "traffic_mirroring")
+	}
+	if len(c.Tenants) > 0 {
+		features = append(features, "tenants")
+	}
+	for _, protocol := range c.UpstreamProtocols {
+		if protocol == "h2c" {
+			features = append(features, "upstream_h2c")
+			break
+		}
+	}
+	if len(c.Degradation) > 0 {
+		features = append(features, "degradation_matrix")
+	}
+	if len(c.HeaderRules) > 0 {
+		features = append(features, "header_rules")
+	}
+	if c.ScrapeAnonMaxDepth > 0 || c.ScrapeStreak > 0 {
+		features = append(features, "scrape_guard")
+	}
+	if len(c.OpenAPISpecs) > 0 {
+		features = append(features, "openapi_validation")
+	}
+	if c.ErrorFormat == "problem" {
+		features = append(features, "problem_details")
+	}
+	if c.DocsSpecPath != "" {
+		features = append(features, "api_docs")
+	}
+	if c.BatchMaxRequests > 0 {
+		features = append(features, "batch")
+	}
+	if c.GraphQLFacade == "on" {
+		features = append(features, "graphql")
+	}
+	if len(c.TranscodeFormats) > 0 {
+		features = append(features, "transcoding")
+	}
+	if c.FieldFiltering == "on" {
+		features = append(features, "field_filtering")
+	}
+	if c.ServerTiming == "on" {
+		features = append(features, "server_timing")
+	}
+	if c.OutboxWorkers > 0 {
+		features = append(features, "outbox")
+	}
+	if c.KafkaRESTURL != "" {
+		features = append(features, "kafka_events")
+	}
+	if c.TimelineSamplePercent > 0 {
+		features = append(features, "user_timeline")
+	}
+	if c.CrawlerVerification == "enforce" {
+		features = append(features, "crawler_verification")
+	}
+	if len(c.RoutingRules) > 0 {
+		features = append(features, "routing_rules")
+	}
+	if len(c.MiddlewareChains) > 0 {
+		features = append(features, "middleware_chains")
+	}
+	if c.AdminToken != "" {
+		features = append(features, "admin_api")
+	}
+	return features
+}
+
+// TLSCipherSuiteIDs returns the configured cipher suites as crypto/tls IDs,
+// or nil for Go's defaults
+func (c *Config) TLSCipherSuiteIDs() []uint16 {
+	var ids []uint16
+	for _, name := range c.TLSCipherSuites {
+		ids = append(ids, cipherSuiteID(name))
+	}
+	return ids
+}
+
+// cipherSuiteID returns the ID of a secure cipher suite by its name, or 0
+func cipherSuiteID(name string) uint16 {
+	for _, suite := range tls.CipherSuites() {
+		if suite.Name == name {
+			return suite.ID
+		}
+	}
+	return 0
+}
+
+// isInterfaceAddr reports whether addr is host:port naming one interface,
+// not all of them
+func isInterfaceAddr(addr string) bool {
+	host, port, err := net.SplitHostPort(addr)
+	return err == nil && host != "" && host != "0.0.0.0" && host != "::" && port != ""
+}
+
 func getEnv(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
 		return value
@@ -114,3 +1795,70 @@ func getEnvAsInt(key string, defaultValue int) int {
 
 	return value
 }
+
+// getEnvAsList reads a comma-separated list, dropping empty items. Setting
+// the variable to "none" yields an empty list.
+func getEnvAsList(key, defaultValue string) []string {
+	var items []string
+	for _, item := range strings.Split(getEnv(key, defaultValue), ",") {
+		if item = strings.TrimSpace(item); item != "" && item != "none" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// getEnvAsSizes reads "key:MB,..." from the environment as byte sizes by
+// key. Malformed sizes are kept as -1 for Validate to reject.
+func getEnvAsSizes(key, defaultValue string) map[string]int64 {
+	sizes := make(map[string]int64)
+	for _, item := range getEnvAsList(key, defaultValue) {
+		name, value, _ := strings.Cut(item, ":")
+		mb, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil || mb <= 0 {
+			sizes[strings.TrimSpace(name)] = -1
+			continue
+		}
+		sizes[strings.TrimSpace(name)] = int64(mb) << 20
+	}
+	return sizes
+}
+
+// getEnvAsSeconds reads a whole number of seconds from the environment
+func getEnvAsSeconds(key string, defaultValue time.Duration) time.Duration {
+	valueStr := os.Getenv(key)
+	if valueStr == "" {
+		return defaultValue
+	}
+
+	value, err := strconv.Atoi(valueStr)
+	if err != nil {
+		return defaultValue
+	}
+
+	return time.Duration(value) * time.Second
+}
+
+// isValidHeaderName reports whether name is a valid HTTP header field name
+func isValidHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		if r > 127 || !strings.ContainsRune("!#$%&'*+-.^_`|~", r) && !('0' <= r && r <= '9' || 'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z') {
+			return false
+		}
+	}
+	return true
+}
+
+// IsValidMethod reports whether method, in any case, is an HTTP method routes
+// can be declared for
+func IsValidMethod(method string) bool {
+	switch strings.ToUpper(method) {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut,
+		http.MethodPatch, http.MethodDelete, http.MethodOptions:
+		return true
+	}
+	return false
+}
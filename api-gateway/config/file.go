@@ -0,0 +1,427 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// File is the structured configuration file format, accepted as YAML or TOML.
+// Every value can still be overridden by the matching environment variable.
+type File struct {
+	Environment  string                                  `yaml:"environment" toml:"environment"`
+	Port         int                                     `yaml:"port" toml:"port"`
+	Upstreams    map[string]string                       `yaml:"upstreams" toml:"upstreams"`
+	Protocols    map[string]string                       `yaml:"upstream_protocols" toml:"upstream_protocols"`
+	Timeouts     FileTimeouts                            `yaml:"timeouts" toml:"timeouts"`
+	Redis        FileRedis                               `yaml:"redis" toml:"redis"`
+	RateLimit    FileRateLimit                           `yaml:"rate_limit" toml:"rate_limit"`
+	Routes       []Route                                 `yaml:"routes" toml:"routes"`
+	APIv2        []Route                                 `yaml:"api_v2" toml:"api_v2"`
+	Deprecations []Deprecation                           `yaml:"deprecations" toml:"deprecations"`
+	Synthetic    []Synthetic                             `yaml:"synthetic" toml:"synthetic"`
+	Fallbacks    []Fallback                              `yaml:"fallbacks" toml:"fallbacks"`
+	Egress       FileEgress                              `yaml:"egress" toml:"egress"`
+	Middleware   FileMiddleware                          `yaml:"middleware" toml:"middleware"`
+	RoutingRules []RoutingRule                           `yaml:"routing_rules" toml:"routing_rules"`
+	Mirrors      []Mirror                                `yaml:"mirrors" toml:"mirrors"`
+	BlueGreen    map[string]BlueGreenSet                 `yaml:"blue_green" toml:"blue_green"`
+	Headers      []HeaderRule                            `yaml:"headers" toml:"headers"`
+	Degradation  map[string]map[string]DegradationBranch `yaml:"degradation" toml:"degradation"`
+	OpenAPI      map[string]string                       `yaml:"openapi" toml:"openapi"`
+	Errors       FileErrors                              `yaml:"errors" toml:"errors"`
+	Outbox       FileOutbox                              `yaml:"outbox" toml:"outbox"`
+	Crawlers     []Crawler                               `yaml:"crawlers" toml:"crawlers"`
+	Tenants      []Tenant                                `yaml:"tenants" toml:"tenants"`
+	Logging      FileLogging                             `yaml:"logging" toml:"logging"`
+}
+
+// FileLogging configures how personal data and credentials are scrubbed
+// from log output
+type FileLogging struct {
+	Redaction  string          `yaml:"redaction" toml:"redaction"`
+	RedactKeys []string        `yaml:"redact_keys" toml:"redact_keys"`
+	Redact     []RedactionRule `yaml:"redact" toml:"redact"`
+}
+
+// RedactionRule masks every match of a regular expression in log output with
+// Replacement, "[REDACTED]" when empty; it may refer to submatches as ${1}
+type RedactionRule struct {
+	Name        string `yaml:"name" toml:"name" json:"name"`
+	Pattern     string `yaml:"pattern" toml:"pattern" json:"pattern"`
+	Replacement string `yaml:"replacement" toml:"replacement" json:"replacement"`
+}
+
+// Crawler is a crawler verified in addition to the built-in ones: requests
+// whose User-Agent contains UserAgent must come from an IP reverse-resolving
+// under one of Domains. A crawler named like a built-in one replaces it.
+type Crawler struct {
+	Name      string   `yaml:"name" toml:"name" json:"name"`
+	UserAgent string   `yaml:"user_agent" toml:"user_agent" json:"user_agent"`
+	Domains   []string `yaml:"domains" toml:"domains" json:"domains"`
+}
+
+// Tenant is a white-label deployment served on its own hostnames, exact or
+// "*.example.com". Upstreams overrides upstream URLs by upstream name;
+// RateLimit names a rate limit policy applied on top of the gateway's; Auth
+// "required" demands a valid token on every request, and Audience only
+// accepts tokens whose aud claim names it.
+type Tenant struct {
+	Name      string            `yaml:"name" toml:"name" json:"name"`
+	Hosts     []string          `yaml:"hosts" toml:"hosts" json:"hosts"`
+	Upstreams map[string]string `yaml:"upstreams" toml:"upstreams" json:"upstreams"`
+	RateLimit string            `yaml:"rate_limit" toml:"rate_limit" json:"rate_limit"`
+	Auth      string            `yaml:"auth" toml:"auth" json:"auth"`
+	Audience  string            `yaml:"audience" toml:"audience" json:"audience"`
+}
+
+// RoutingRule sends requests to an upstream that carry a header or cookie
+// (optionally with a specific value) to an alternate URL instead
+type RoutingRule struct {
+	Upstream string `yaml:"upstream" toml:"upstream" json:"upstream"`
+	Header   string `yaml:"header" toml:"header" json:"header"`
+	Cookie   string `yaml:"cookie" toml:"cookie" json:"cookie"`
+	Value    string `yaml:"value" toml:"value" json:"value"`
+	Target   string `yaml:"target" toml:"target" json:"target"`
+}
+
+// BlueGreenSet is the pair of URLs an upstream is switched between
+type BlueGreenSet struct {
+	Blue  string `yaml:"blue" toml:"blue" json:"blue"`
+	Green string `yaml:"green" toml:"green" json:"green"`
+}
+
+// URL returns the URL of the "blue" or "green" set
+func (s BlueGreenSet) URL(color string) string {
+	if color == "green" {
+		return s.Green
+	}
+	return s.Blue
+}
+
+// Mirror copies a percentage of an upstream's requests to a shadow target
+type Mirror struct {
+	Upstream      string  `yaml:"upstream" toml:"upstream" json:"upstream"`
+	Target        string  `yaml:"target" toml:"target" json:"target"`
+	Percent       float64 `yaml:"percent" toml:"percent" json:"percent"`
+	IncludeWrites bool    `yaml:"include_writes" toml:"include_writes" json:"include_writes"`
+}
+
+// FileMiddleware declares named middleware chains and the route groups they apply to
+type FileMiddleware struct {
+	Chains map[string][]MiddlewareSpec `yaml:"chains" toml:"chains"`
+	Groups map[string]string           `yaml:"groups" toml:"groups"`
+}
+
+// MiddlewareSpec is one entry of a middleware chain: a registered middleware
+// name and its options
+type MiddlewareSpec struct {
+	Name    string            `yaml:"name" toml:"name" json:"name"`
+	Options map[string]string `yaml:"options" toml:"options" json:"options"`
+}
+
+// FileEgress configures how external calls leave the gateway
+type FileEgress struct {
+	Proxy string       `yaml:"proxy" toml:"proxy"`
+	Rules []EgressRule `yaml:"rules" toml:"rules"`
+}
+
+// EgressRule applies an action (proxy, direct or deny) to matching destination hosts
+type EgressRule struct {
+	Host   string `yaml:"host" toml:"host" json:"host"`
+	Action string `yaml:"action" toml:"action" json:"action"`
+}
+
+// FileTimeouts holds server and proxy timeouts
+type FileTimeouts struct {
+	Read  Duration `yaml:"read" toml:"read"`
+	Write Duration `yaml:"write" toml:"write"`
+	Idle  Duration `yaml:"idle" toml:"idle"`
+	Proxy Duration `yaml:"proxy" toml:"proxy"`
+}
+
+// FileRedis holds the Redis health check interval and the degradation policy
+// of each Redis-backed feature (fail_open, fail_closed or memory)
+type FileRedis struct {
+	CheckInterval Duration          `yaml:"check_interval" toml:"check_interval"`
+	Policies      map[string]string `yaml:"policies" toml:"policies"`
+}
+
+// FileOutbox configures the outbox of gateway-originated events and the
+// upstream each event kind is delivered to
+type FileOutbox struct {
+	Stream      string                `yaml:"stream" toml:"stream"`
+	MaxAttempts int                   `yaml:"max_attempts" toml:"max_attempts"`
+	RetryAfter  Duration              `yaml:"retry_after" toml:"retry_after"`
+	Workers     int                   `yaml:"workers" toml:"workers"`
+	Sinks       map[string]OutboxSink `yaml:"sinks" toml:"sinks"`
+}
+
+// OutboxSink is the upstream path events of one kind are POSTed to
+type OutboxSink struct {
+	Upstream string `yaml:"upstream" toml:"upstream" json:"upstream"`
+	Path     string `yaml:"path" toml:"path" json:"path"`
+}
+
+// FileErrors configures the problem details emitted for error responses
+type FileErrors struct {
+	Format   string         `yaml:"format" toml:"format"`
+	TypeBase string         `yaml:"type_base" toml:"type_base"`
+	Mappings []ErrorMapping `yaml:"mappings" toml:"mappings"`
+}
+
+// ErrorMapping translates an upstream's errors, selected by status and the
+// backend's own error code, into a gateway error code, title and detail
+type ErrorMapping struct {
+	Upstream string `yaml:"upstream" toml:"upstream" json:"upstream"`
+	Status   int    `yaml:"status" toml:"status" json:"status"`
+	Match    string `yaml:"match" toml:"match" json:"match"`
+	Code     string `yaml:"code" toml:"code" json:"code"`
+	Title    string `yaml:"title" toml:"title" json:"title"`
+	Detail   string `yaml:"detail" toml:"detail" json:"detail"`
+}
+
+// FileRateLimit holds the default rate limit and named per-route policies
+type FileRateLimit struct {
+	RPS        int                        `yaml:"rps" toml:"rps"`
+	Burst      int                        `yaml:"burst" toml:"burst"`
+	IPv6Prefix int                        `yaml:"ipv6_prefix" toml:"ipv6_prefix"`
+	Policies   map[string]RateLimitPolicy `yaml:"policies" toml:"policies"`
+}
+
+// RateLimitPolicy is a named token bucket configuration
+type RateLimitPolicy struct {
+	RPS   int `yaml:"rps" toml:"rps" json:"rps"`
+	Burst int `yaml:"burst" toml:"burst" json:"burst"`
+}
+
+// Route is a proxied route declared in the config file
+type Route struct {
+	Method       string   `yaml:"method" toml:"method" json:"method"`
+	Path         string   `yaml:"path" toml:"path" json:"path"`
+	Upstream     string   `yaml:"upstream" toml:"upstream" json:"upstream"`
+	Timeout      Duration `yaml:"timeout" toml:"timeout" json:"timeout"`
+	RateLimit    string   `yaml:"rate_limit" toml:"rate_limit" json:"rate_limit"`
+	DedupWindow  Duration `yaml:"dedup_window" toml:"dedup_window" json:"dedup_window"`
+	CacheTTL     Duration `yaml:"cache_ttl" toml:"cache_ttl" json:"cache_ttl"`
+	CachePerUser bool     `yaml:"cache_per_user" toml:"cache_per_user" json:"cache_per_user"`
+	Chain        string   `yaml:"chain" toml:"chain" json:"chain"`
+	UpstreamPath string   `yaml:"upstream_path" toml:"upstream_path" json:"upstream_path"`
+}
+
+// Synthetic is a static endpoint served directly by the gateway.
+// At most one of JSON, Body or Redirect may be set; with none the endpoint
+// answers Status (204 by default) without a body. Redirects need a 3xx
+// Status (302 by default).
+type Synthetic struct {
+	Method      string            `yaml:"method" toml:"method" json:"method"`
+	Path        string            `yaml:"path" toml:"path" json:"path"`
+	Status      int               `yaml:"status" toml:"status" json:"status"`
+	Headers     map[string]string `yaml:"headers" toml:"headers" json:"headers"`
+	JSON        interface{}       `yaml:"json" toml:"json" json:"json"`
+	Body        string            `yaml:"body" toml:"body" json:"body"`
+	ContentType string            `yaml:"content_type" toml:"content_type" json:"content_type"`
+	Redirect    string            `yaml:"redirect" toml:"redirect" json:"redirect"`
+}
+
+// Fallback is the static response of a proxied route while its upstream's
+// circuit breaker is open, e.g. an empty feed, in place of a 503
+type Fallback struct {
+	Method   string            `yaml:"method" toml:"method" json:"method"`
+	Path     string            `yaml:"path" toml:"path" json:"path"`
+	Upstream string            `yaml:"upstream" toml:"upstream" json:"upstream"`
+	Status   int               `yaml:"status" toml:"status" json:"status"`
+	Headers  map[string]string `yaml:"headers" toml:"headers" json:"headers"`
+	JSON     interface{}       `yaml:"json" toml:"json" json:"json"`
+}
+
+// DegradationBranch is the policy of one upstream call of an aggregation
+// endpoint: whether the endpoint fails without it, or the value used instead
+type DegradationBranch struct {
+	Required bool        `yaml:"required" toml:"required" json:"required"`
+	Fallback interface{} `yaml:"fallback" toml:"fallback" json:"fallback"`
+}
+
+// HeaderRule transforms request and response headers for routes under a path
+// prefix. A rule naming an experiment (and variant) or feature flag only edits
+// the responses of users in that bucket or with the flag on.
+type HeaderRule struct {
+	Path       string    `yaml:"path" toml:"path" json:"path"`
+	Experiment string    `yaml:"experiment" toml:"experiment" json:"experiment,omitempty"`
+	Variant    string    `yaml:"variant" toml:"variant" json:"variant,omitempty"`
+	Flag       string    `yaml:"flag" toml:"flag" json:"flag,omitempty"`
+	Request    HeaderOps `yaml:"request" toml:"request" json:"request"`
+	Response   HeaderOps `yaml:"response" toml:"response" json:"response"`
+}
+
+// HeaderOps are header edits, applied in the order rename, remove, set, add
+type HeaderOps struct {
+	Rename map[string]string `yaml:"rename" toml:"rename" json:"rename"`
+	Remove []string          `yaml:"remove" toml:"remove" json:"remove"`
+	Set    map[string]string `yaml:"set" toml:"set" json:"set"`
+	Add    map[string]string `yaml:"add" toml:"add" json:"add"`
+}
+
+// Deprecation marks routes under a path prefix as deprecated. Responses carry
+// Deprecation and Sunset headers, and Link points clients to migration docs.
+type Deprecation struct {
+	Path         string `yaml:"path" toml:"path" json:"path"`
+	DeprecatedAt Date   `yaml:"deprecated_at" toml:"deprecated_at" json:"deprecated_at"`
+	Sunset       Date   `yaml:"sunset" toml:"sunset" json:"sunset"`
+	Link         string `yaml:"link" toml:"link" json:"link"`
+}
+
+// Date is a point in time parsed from RFC 3339 timestamps or YYYY-MM-DD dates
+type Date struct {
+	time.Time
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler
+func (d *Date) UnmarshalText(text []byte) error {
+	for _, layout := range []string{time.RFC3339, time.DateOnly} {
+		if parsed, err := time.Parse(layout, string(text)); err == nil {
+			d.Time = parsed.UTC()
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid date %q: expected RFC 3339 or YYYY-MM-DD", string(text))
+}
+
+// Duration is a time.Duration parsed from strings such as "30s" or "2m"
+type Duration time.Duration
+
+// UnmarshalText implements encoding.TextUnmarshaler
+func (d *Duration) UnmarshalText(text []byte) error {
+	parsed, err := time.ParseDuration(string(text))
+	if err != nil {
+		return fmt.Errorf("invalid duration %q: %w", string(text), err)
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// Std returns the value as a time.Duration
+func (d Duration) Std() time.Duration {
+	return time.Duration(d)
+}
+
+// LoadFile reads a YAML (.yaml/.yml) or TOML (.toml) config file.
+// Unknown keys are rejected so typos don't silently fall back to defaults.
+func LoadFile(path string) (*File, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	file := &File{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		if err := dec.Decode(file); err != nil {
+			return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+		}
+	case ".toml":
+		dec := toml.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(file); err != nil {
+			return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported config file format: %s", path)
+	}
+
+	return file, nil
+}
+
+// upstream returns the file's URL for a service, or defaultValue if unset
+func (f *File) upstream(name, defaultValue string) string {
+	if url, ok := f.Upstreams[name]; ok && url != "" {
+		return url
+	}
+	return defaultValue
+}
+
+// redisPolicies returns the degradation policy of each Redis-backed feature:
+// the built-in defaults, overridden by the file, overridden by REDIS_POLICY_<FEATURE>
+func (f *File) redisPolicies() map[string]string {
+	policies := map[string]string{
+		"dedup":  "fail_open",
+		"replay": "fail_closed",
+		"cache":  "fail_open",
+		"quota":  "memory",
+	}
+	for feature, mode := range f.Redis.Policies {
+		policies[feature] = mode
+	}
+	for feature, mode := range policies {
+		policies[feature] = getEnv("REDIS_POLICY_"+strings.ToUpper(feature), mode)
+	}
+	return policies
+}
+
+// outboxSinks returns the sink of each outbox event kind: the built-in
+// defaults, overridden by the file
+func (f *File) outboxSinks() map[string]OutboxSink {
+	sinks := map[string]OutboxSink{
+		"audit":     {Upstream: "analytics", Path: "/api/v1/analytics/audit"},
+		"analytics": {Upstream: "analytics", Path: "/api/v1/analytics/events"},
+	}
+	for kind, sink := range f.Outbox.Sinks {
+		sinks[kind] = sink
+	}
+	return sinks
+}
+
+// upstreamProtocols returns the protocol of each upstream not on the
+// default: the file's upstream_protocols, overridden by UPSTREAM_PROTOCOLS
+// ("name:protocol,...")
+func (f *File) upstreamProtocols() map[string]string {
+	protocols := make(map[string]string)
+	for name, protocol := range f.Protocols {
+		protocols[name] = protocol
+	}
+	for _, item := range getEnvAsList("UPSTREAM_PROTOCOLS", "") {
+		name, protocol, _ := strings.Cut(item, ":")
+		protocols[strings.TrimSpace(name)] = strings.TrimSpace(protocol)
+	}
+	return protocols
+}
+
+// extraUpstreams returns upstreams other than the built-in services
+func (f *File) extraUpstreams() map[string]string {
+	extra := make(map[string]string)
+	for name, url := range f.Upstreams {
+		if !builtinServices[name] {
+			extra[name] = url
+		}
+	}
+	return extra
+}
+
+func orInt(value, defaultValue int) int {
+	if value != 0 {
+		return value
+	}
+	return defaultValue
+}
+
+func orString(value, defaultValue string) string {
+	if value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+func orDuration(value Duration, defaultValue time.Duration) time.Duration {
+	if value != 0 {
+		return value.Std()
+	}
+	return defaultValue
+}
@@ -0,0 +1,234 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Names of the secrets managed by a secrets provider. Providers return values
+// keyed by the same names as the environment variables they replace.
+const (
+	SecretJWT             = "JWT_SECRET"
+	SecretRedisPassword   = "REDIS_PASSWORD"
+	SecretAdminToken      = "ADMIN_API_TOKEN"
+	SecretReplaySigning   = "REPLAY_SIGNING_SECRET"
+	SecretWebhooks        = "PAYMENT_WEBHOOK_SECRETS"
+	SecretServiceTokens   = "INTERNAL_SERVICE_TOKENS"
+	SecretGatewaySigning  = "GATEWAY_SIGNING_SECRET"
+	SecretDownloadSigning = "DOWNLOAD_SIGNING_SECRET"
+)
+
+// SecretsProvider loads secret values from an external secret store
+type SecretsProvider interface {
+	Fetch(ctx context.Context) (map[string]string, error)
+}
+
+// Secrets holds the current and previous value of each secret. Values are
+// refreshed from the provider periodically, so rotated secrets take effect
+// without a restart while the previous value remains usable during the overlap.
+type Secrets struct {
+	mu       sync.RWMutex
+	current  map[string]string
+	previous map[string]string
+	provider SecretsProvider
+}
+
+func newSecrets(initial map[string]string, provider SecretsProvider) *Secrets {
+	return &Secrets{
+		current:  initial,
+		previous: make(map[string]string),
+		provider: provider,
+	}
+}
+
+// Get returns the current value of a secret
+func (s *Secrets) Get(name string) string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.current[name]
+}
+
+// Previous returns the value a secret had before its last rotation, if any
+func (s *Secrets) Previous(name string) string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.previous[name]
+}
+
+// Refresh fetches secrets from the provider and returns the names that changed
+func (s *Secrets) Refresh(ctx context.Context) ([]string, error) {
+	if s.provider == nil {
+		return nil, nil
+	}
+
+	values, err := s.provider.Fetch(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var changed []string
+	for name, value := range values {
+		if value == "" || s.current[name] == value {
+			continue
+		}
+		if old := s.current[name]; old != "" {
+			s.previous[name] = old
+		}
+		s.current[name] = value
+		changed = append(changed, name)
+	}
+	sort.Strings(changed)
+	return changed, nil
+}
+
+// Watch refreshes secrets every interval until ctx is cancelled
+func (s *Secrets) Watch(ctx context.Context, interval time.Duration, onChange func([]string), onError func(error)) {
+	if s.provider == nil || interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		changed, err := s.Refresh(ctx)
+		if err != nil {
+			onError(err)
+			continue
+		}
+		if len(changed) > 0 {
+			onChange(changed)
+		}
+	}
+}
+
+// newSecretsProvider creates the provider selected by SECRETS_PROVIDER
+func newSecretsProvider(c *Config) (SecretsProvider, error) {
+	switch c.SecretsProvider {
+	case "":
+		return nil, nil
+	case "vault":
+		return NewVaultProvider(c.VaultAddr, c.VaultToken, c.VaultSecretPath), nil
+	case "aws":
+		return NewAWSSecretsProvider(c.AWSRegion, c.AWSSecretID)
+	default:
+		return nil, fmt.Errorf("unknown SECRETS_PROVIDER: %s", c.SecretsProvider)
+	}
+}
+
+// JWTSecrets returns the JWT signing secrets currently accepted, newest first
+func (c *Config) JWTSecrets() []string {
+	secrets := []string{c.Secrets.Get(SecretJWT)}
+	if previous := c.Secrets.Previous(SecretJWT); previous != "" {
+		secrets = append(secrets, previous)
+	}
+	return secrets
+}
+
+// CurrentRedisPassword returns the current Redis password
+func (c *Config) CurrentRedisPassword() string {
+	return c.Secrets.Get(SecretRedisPassword)
+}
+
+// CurrentAdminToken returns the current admin API token
+func (c *Config) CurrentAdminToken() string {
+	return c.Secrets.Get(SecretAdminToken)
+}
+
+// CurrentGatewaySigningSecret returns the secret signing identity headers sent
+// upstream, or "" when signing is disabled
+func (c *Config) CurrentGatewaySigningSecret() string {
+	return c.Secrets.Get(SecretGatewaySigning)
+}
+
+// ReplaySigningSecrets returns the request signing secrets currently accepted,
+// newest first, or nil when signing is not configured
+func (c *Config) ReplaySigningSecrets() []string {
+	current := c.Secrets.Get(SecretReplaySigning)
+	if current == "" {
+		return nil
+	}
+
+	secrets := []string{current}
+	if previous := c.Secrets.Previous(SecretReplaySigning); previous != "" {
+		secrets = append(secrets, previous)
+	}
+	return secrets
+}
+
+// DownloadSigningSecrets returns the download URL signing secrets currently
+// accepted, newest first, or nil when download URLs are not configured
+func (c *Config) DownloadSigningSecrets() []string {
+	current := c.Secrets.Get(SecretDownloadSigning)
+	if current == "" {
+		return nil
+	}
+
+	secrets := []string{current}
+	if previous := c.Secrets.Previous(SecretDownloadSigning); previous != "" {
+		secrets = append(secrets, previous)
+	}
+	return secrets
+}
+
+// PaymentWebhookSecrets returns the webhook signing secrets currently accepted
+// for a payment provider, newest first, or nil when the provider is not configured
+func (c *Config) PaymentWebhookSecrets(provider string) []string {
+	var secrets []string
+	for _, raw := range []string{c.Secrets.Get(SecretWebhooks), c.Secrets.Previous(SecretWebhooks)} {
+		parsed, err := parseNamedSecrets(raw)
+		if err != nil {
+			continue
+		}
+		if secret := parsed[provider]; secret != "" {
+			secrets = append(secrets, secret)
+		}
+	}
+	return secrets
+}
+
+// InternalServiceTokens returns the tokens currently accepted from each
+// internal service, newest first
+func (c *Config) InternalServiceTokens() map[string][]string {
+	tokens := make(map[string][]string)
+	for _, raw := range []string{c.Secrets.Get(SecretServiceTokens), c.Secrets.Previous(SecretServiceTokens)} {
+		parsed, err := parseNamedSecrets(raw)
+		if err != nil {
+			continue
+		}
+		for service, token := range parsed {
+			tokens[service] = append(tokens[service], token)
+		}
+	}
+	return tokens
+}
+
+// parseNamedSecrets parses "name:secret,..." into a map by name
+func parseNamedSecrets(raw string) (map[string]string, error) {
+	secrets := make(map[string]string)
+	for _, pair := range strings.Split(raw, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		name, secret, ok := strings.Cut(pair, ":")
+		if !ok || name == "" || secret == "" {
+			return nil, fmt.Errorf("expected name:secret, got %q", name)
+		}
+		secrets[name] = secret
+	}
+	return secrets, nil
+}
@@ -0,0 +1,132 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+)
+
+// AWSSecretsProvider reads a JSON secret from AWS Secrets Manager, e.g.
+// {"JWT_SECRET": "...", "REDIS_PASSWORD": "..."}. Credentials come from the
+// standard AWS_ACCESS_KEY_ID / AWS_SECRET_ACCESS_KEY / AWS_SESSION_TOKEN variables.
+type AWSSecretsProvider struct {
+	client   *http.Client
+	region   string
+	secretID string
+}
+
+// NewAWSSecretsProvider creates an AWS Secrets Manager secrets provider
+func NewAWSSecretsProvider(region, secretID string) (*AWSSecretsProvider, error) {
+	if region == "" || secretID == "" {
+		return nil, fmt.Errorf("AWS_REGION and AWS_SECRET_ID are required for the aws secrets provider")
+	}
+
+	return &AWSSecretsProvider{
+		client:   &http.Client{Timeout: 10 * time.Second},
+		region:   region,
+		secretID: secretID,
+	}, nil
+}
+
+// Fetch implements SecretsProvider
+func (a *AWSSecretsProvider) Fetch(ctx context.Context) (map[string]string, error) {
+	payload, _ := json.Marshal(map[string]string{"SecretId": a.secretID})
+	host := "secretsmanager." + a.region + ".amazonaws.com"
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "https://"+host+"/", bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "secretsmanager.GetSecretValue")
+
+	if err := a.sign(req, host, payload, time.Now().UTC()); err != nil {
+		return nil, err
+	}
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("secrets manager request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("secrets manager returned %d for %s", resp.StatusCode, a.secretID)
+	}
+
+	var body struct {
+		SecretString string `json:"SecretString"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("failed to decode secrets manager response: %w", err)
+	}
+
+	values := make(map[string]string)
+	if err := json.Unmarshal([]byte(body.SecretString), &values); err != nil {
+		return nil, fmt.Errorf("secret %s is not a JSON object of strings: %w", a.secretID, err)
+	}
+	return values, nil
+}
+
+// sign adds an AWS Signature Version 4 Authorization header to req
+func (a *AWSSecretsProvider) sign(req *http.Request, host string, payload []byte, now time.Time) error {
+	accessKey := os.Getenv("AWS_ACCESS_KEY_ID")
+	secretKey := os.Getenv("AWS_SECRET_ACCESS_KEY")
+	if accessKey == "" || secretKey == "" {
+		return fmt.Errorf("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY must be set")
+	}
+
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	payloadHash := sha256Hex(payload)
+
+	req.Header.Set("Host", host)
+	req.Header.Set("X-Amz-Date", amzDate)
+	signedHeaders := "content-type;host;x-amz-date;x-amz-target"
+	canonicalHeaders := "content-type:" + req.Header.Get("Content-Type") + "\n" +
+		"host:" + host + "\n" +
+		"x-amz-date:" + amzDate + "\n" +
+		"x-amz-target:" + req.Header.Get("X-Amz-Target") + "\n"
+
+	if token := os.Getenv("AWS_SESSION_TOKEN"); token != "" {
+		req.Header.Set("X-Amz-Security-Token", token)
+		signedHeaders = "content-type;host;x-amz-date;x-amz-security-token;x-amz-target"
+		canonicalHeaders = "content-type:" + req.Header.Get("Content-Type") + "\n" +
+			"host:" + host + "\n" +
+			"x-amz-date:" + amzDate + "\n" +
+			"x-amz-security-token:" + token + "\n" +
+			"x-amz-target:" + req.Header.Get("X-Amz-Target") + "\n"
+	}
+
+	canonicalRequest := "POST\n/\n\n" + canonicalHeaders + "\n" + signedHeaders + "\n" + payloadHash
+	scope := date + "/" + a.region + "/secretsmanager/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	key := hmacSHA256([]byte("AWS4"+secretKey), date)
+	key = hmacSHA256(key, a.region)
+	key = hmacSHA256(key, "secretsmanager")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		accessKey, scope, signedHeaders, signature))
+	return nil
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,59 @@
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// VaultProvider reads secrets from a HashiCorp Vault KV v2 secret, e.g.
+// path "secret/data/api-gateway" with keys JWT_SECRET and REDIS_PASSWORD
+type VaultProvider struct {
+	client *http.Client
+	addr   string
+	token  string
+	path   string
+}
+
+// NewVaultProvider creates a Vault KV v2 secrets provider
+func NewVaultProvider(addr, token, path string) *VaultProvider {
+	return &VaultProvider{
+		client: &http.Client{Timeout: 10 * time.Second},
+		addr:   strings.TrimRight(addr, "/"),
+		token:  token,
+		path:   strings.Trim(path, "/"),
+	}
+}
+
+// Fetch implements SecretsProvider
+func (v *VaultProvider) Fetch(ctx context.Context) (map[string]string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, v.addr+"/v1/"+v.path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", v.token)
+
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("vault request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("vault returned %d for %s", resp.StatusCode, v.path)
+	}
+
+	var body struct {
+		Data struct {
+			Data map[string]string `json:"data"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("failed to decode vault secret: %w", err)
+	}
+
+	return body.Data.Data, nil
+}
@@ -0,0 +1,103 @@
+// Package diagnostics serves profiling and runtime diagnostics: pprof
+// profiles, expvar memory stats, goroutine dumps and GC stats. The handler
+// is meant for a listener of its own bound to an internal interface, never
+// for the public API.
+package diagnostics
+
+import (
+	"encoding/json"
+	"expvar"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"runtime/debug"
+	"time"
+)
+
+// recentPauses is how many of the latest GC pauses are reported
+const recentPauses = 16
+
+func init() {
+	expvar.Publish("goroutines", expvar.Func(func() any { return runtime.NumGoroutine() }))
+}
+
+// Handler serves:
+//
+//	/debug/pprof/      pprof index and profiles (heap, allocs, goroutine, block, mutex, ...)
+//	/debug/vars        expvar, including runtime.MemStats as "memstats"
+//	/debug/goroutines  full stack dump of every goroutine
+//	/debug/gc          GC and heap statistics as JSON
+func Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+	mux.HandleFunc("/debug/goroutines", goroutines)
+	mux.HandleFunc("/debug/gc", gcStats)
+	return mux
+}
+
+// goroutines writes the stacks of all goroutines, like panic output
+func goroutines(w http.ResponseWriter, r *http.Request) {
+	pprof.Handler("goroutine").ServeHTTP(w, withDebug(r, "2"))
+}
+
+// withDebug returns r asking pprof for the given debug output level
+func withDebug(r *http.Request, level string) *http.Request {
+	query := r.URL.Query()
+	query.Set("debug", level)
+	r2 := r.Clone(r.Context())
+	r2.URL.RawQuery = query.Encode()
+	return r2
+}
+
+// gcReport is the body of /debug/gc
+type gcReport struct {
+	NumGC         int64     `json:"num_gc"`
+	LastGC        time.Time `json:"last_gc"`
+	PauseTotalMs  float64   `json:"pause_total_ms"`
+	RecentPauseMs []float64 `json:"recent_pause_ms"`
+	GCCPUFraction float64   `json:"gc_cpu_fraction"`
+	HeapAlloc     uint64    `json:"heap_alloc_bytes"`
+	HeapInuse     uint64    `json:"heap_inuse_bytes"`
+	HeapObjects   uint64    `json:"heap_objects"`
+	NextGC        uint64    `json:"next_gc_bytes"`
+	Sys           uint64    `json:"sys_bytes"`
+	MemoryLimit   int64     `json:"memory_limit_bytes"`
+	Goroutines    int       `json:"goroutines"`
+}
+
+func gcStats(w http.ResponseWriter, r *http.Request) {
+	stats := debug.GCStats{Pause: make([]time.Duration, recentPauses)}
+	debug.ReadGCStats(&stats)
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	report := gcReport{
+		NumGC:         stats.NumGC,
+		LastGC:        stats.LastGC,
+		PauseTotalMs:  milliseconds(stats.PauseTotal),
+		RecentPauseMs: make([]float64, 0, len(stats.Pause)),
+		GCCPUFraction: mem.GCCPUFraction,
+		HeapAlloc:     mem.HeapAlloc,
+		HeapInuse:     mem.HeapInuse,
+		HeapObjects:   mem.HeapObjects,
+		NextGC:        mem.NextGC,
+		Sys:           mem.Sys,
+		MemoryLimit:   debug.SetMemoryLimit(-1),
+		Goroutines:    runtime.NumGoroutine(),
+	}
+	for _, pause := range stats.Pause {
+		report.RecentPauseMs = append(report.RecentPauseMs, milliseconds(pause))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
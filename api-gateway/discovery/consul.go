@@ -0,0 +1,116 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// consulServiceEntry is the subset of a /v1/health/service entry the gateway needs
+type consulServiceEntry struct {
+	Node struct {
+		Address string `json:"Address"`
+	} `json:"Node"`
+	Service struct {
+		Address string `json:"Address"`
+		Port    int    `json:"Port"`
+	} `json:"Service"`
+}
+
+// Consul watches passing instances of each upstream in the Consul catalog using
+// blocking queries, so registrations and deregistrations apply within seconds
+type Consul struct {
+	client *http.Client
+	addr   string
+	token  string
+	logger *zap.Logger
+}
+
+// NewConsul creates a Consul discovery provider for the agent at addr
+func NewConsul(addr, token string, logger *zap.Logger) *Consul {
+	return &Consul{
+		client: &http.Client{},
+		addr:   strings.TrimRight(addr, "/"),
+		token:  token,
+		logger: logger,
+	}
+}
+
+// Watch follows the healthy instances of every upstream's Consul service
+func (c *Consul) Watch(ctx context.Context, upstreams map[string]string, update UpdateFunc) {
+	watchAll(ctx, upstreams, func(ctx context.Context, baseURL, scheme, service string) {
+		index := "0"
+		retryLoop(ctx, c.logger, service, func() error {
+			endpoints, nextIndex, err := c.query(ctx, service, scheme, index)
+			if err != nil {
+				// Reset so the next attempt returns immediately
+				index = "0"
+				return err
+			}
+
+			if nextIndex != index {
+				update(baseURL, endpoints)
+			}
+			index = nextIndex
+			return nil
+		})
+	})
+}
+
+// query performs a blocking health query that returns once the service's
+// instances change (or after the wait time elapses)
+func (c *Consul) query(ctx context.Context, service, scheme, index string) ([]string, string, error) {
+	query := url.Values{
+		"passing": {"true"},
+		"index":   {index},
+		"wait":    {"30s"},
+	}
+	endpoint := fmt.Sprintf("%s/v1/health/service/%s?%s", c.addr, url.PathEscape(service), query.Encode())
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, index, err
+	}
+	if c.token != "" {
+		req.Header.Set("X-Consul-Token", c.token)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, index, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, index, fmt.Errorf("consul returned %d", resp.StatusCode)
+	}
+
+	var entries []consulServiceEntry
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return nil, index, fmt.Errorf("failed to decode consul response: %w", err)
+	}
+
+	endpoints := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		host := entry.Service.Address
+		if host == "" {
+			host = entry.Node.Address
+		}
+		endpoints = append(endpoints, scheme+"://"+net.JoinHostPort(host, strconv.Itoa(entry.Service.Port)))
+	}
+	sort.Strings(endpoints)
+
+	nextIndex := resp.Header.Get("X-Consul-Index")
+	if nextIndex == "" {
+		nextIndex = index
+	}
+	return endpoints, nextIndex, nil
+}
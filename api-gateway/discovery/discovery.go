@@ -0,0 +1,88 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/YeonwooSung/instagram/api-gateway/config"
+	"go.uber.org/zap"
+)
+
+// UpdateFunc receives the live endpoint base URLs for an upstream, identified
+// by its configured base URL
+type UpdateFunc func(upstream string, endpoints []string)
+
+// Provider watches a service registry for the backend instances of each upstream
+type Provider interface {
+	// Watch reports endpoint changes for every upstream until ctx is cancelled
+	Watch(ctx context.Context, upstreams map[string]string, update UpdateFunc)
+}
+
+// New creates the provider selected by DISCOVERY_MODE, or nil for static mode
+func New(cfg *config.Config, logger *zap.Logger) (Provider, error) {
+	switch cfg.DiscoveryMode {
+	case "static":
+		return nil, nil
+	case "kubernetes":
+		return NewKubernetes(cfg.K8sNamespace, cfg.K8sPortName, logger)
+	case "consul":
+		return NewConsul(cfg.ConsulAddr, cfg.ConsulToken, logger), nil
+	case "etcd":
+		return NewEtcd(cfg.EtcdAddr, cfg.EtcdPrefix, logger), nil
+	default:
+		return nil, fmt.Errorf("unknown discovery mode: %s", cfg.DiscoveryMode)
+	}
+}
+
+// watchAll runs watch for each upstream in its own goroutine. The registry
+// service name is the host of the configured URL (e.g. http://post-service:8002
+// is registered as "post-service").
+func watchAll(ctx context.Context, upstreams map[string]string, watch func(ctx context.Context, baseURL, scheme, service string)) {
+	var wg sync.WaitGroup
+	for _, baseURL := range upstreams {
+		u, err := url.Parse(baseURL)
+		if err != nil {
+			continue
+		}
+
+		wg.Add(1)
+		go func(baseURL, scheme, service string) {
+			defer wg.Done()
+			watch(ctx, baseURL, scheme, service)
+		}(baseURL, u.Scheme, u.Hostname())
+	}
+	wg.Wait()
+}
+
+// retryLoop calls run until ctx is cancelled, backing off exponentially (up to
+// 30s) after failures. run resets the backoff by returning nil.
+func retryLoop(ctx context.Context, logger *zap.Logger, service string, run func() error) {
+	backoff := time.Second
+	for {
+		err := run()
+		if ctx.Err() != nil {
+			return
+		}
+
+		if err != nil {
+			logger.Warn("Service discovery watch failed",
+				zap.String("service", service),
+				zap.Error(err),
+			)
+		} else {
+			backoff = time.Second
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		if err != nil && backoff < 30*time.Second {
+			backoff *= 2
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package discovery
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+type etcdKV struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+type etcdRangeResponse struct {
+	Header struct {
+		Revision string `json:"revision"`
+	} `json:"header"`
+	Kvs []etcdKV `json:"kvs"`
+}
+
+type etcdWatchResponse struct {
+	Result struct {
+		Events []struct {
+			Type string `json:"type"`
+			KV   etcdKV `json:"kv"`
+		} `json:"events"`
+	} `json:"result"`
+}
+
+// Etcd watches instance registrations stored in etcd through its v3 JSON
+// gateway. Instances register under <prefix>/<service>/<instance-id> with
+// their base URL (e.g. http://10.0.0.5:8002) as the value, ideally attached
+// to a lease so crashed instances expire automatically.
+type Etcd struct {
+	client *http.Client
+	addr   string
+	prefix string
+	logger *zap.Logger
+}
+
+// NewEtcd creates an etcd discovery provider for the cluster at addr
+func NewEtcd(addr, prefix string, logger *zap.Logger) *Etcd {
+	return &Etcd{
+		client: &http.Client{},
+		addr:   strings.TrimRight(addr, "/"),
+		prefix: strings.TrimRight(prefix, "/"),
+		logger: logger,
+	}
+}
+
+// Watch follows the registered instances of every upstream's service
+func (e *Etcd) Watch(ctx context.Context, upstreams map[string]string, update UpdateFunc) {
+	watchAll(ctx, upstreams, func(ctx context.Context, baseURL, scheme, service string) {
+		keyPrefix := e.prefix + "/" + service + "/"
+
+		retryLoop(ctx, e.logger, service, func() error {
+			instances := make(map[string]string)
+			publish := func() {
+				update(baseURL, sortedValues(instances))
+			}
+
+			revision, err := e.rangePrefix(ctx, keyPrefix, instances)
+			if err != nil {
+				return err
+			}
+			publish()
+			return e.watch(ctx, keyPrefix, revision+1, instances, publish)
+		})
+	})
+}
+
+func (e *Etcd) rangePrefix(ctx context.Context, keyPrefix string, instances map[string]string) (int64, error) {
+	resp, err := e.post(ctx, "/v3/kv/range", map[string]string{
+		"key":       encodeKey(keyPrefix),
+		"range_end": encodeKey(prefixEnd(keyPrefix)),
+	})
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	var result etcdRangeResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return 0, fmt.Errorf("failed to decode etcd range: %w", err)
+	}
+
+	for _, kv := range result.Kvs {
+		key, value, err := decodeKV(kv)
+		if err != nil {
+			return 0, err
+		}
+		instances[key] = value
+	}
+
+	revision, err := strconv.ParseInt(result.Header.Revision, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid etcd revision %q", result.Header.Revision)
+	}
+	return revision, nil
+}
+
+func (e *Etcd) watch(ctx context.Context, keyPrefix string, startRevision int64, instances map[string]string, publish func()) error {
+	resp, err := e.post(ctx, "/v3/watch", map[string]interface{}{
+		"create_request": map[string]interface{}{
+			"key":            encodeKey(keyPrefix),
+			"range_end":      encodeKey(prefixEnd(keyPrefix)),
+			"start_revision": strconv.FormatInt(startRevision, 10),
+		},
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var msg etcdWatchResponse
+		if err := dec.Decode(&msg); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return fmt.Errorf("etcd watch stream failed: %w", err)
+		}
+		if len(msg.Result.Events) == 0 {
+			continue
+		}
+
+		for _, event := range msg.Result.Events {
+			key, value, err := decodeKV(event.KV)
+			if err != nil {
+				return err
+			}
+			// PUT is the zero value and omitted by the JSON gateway
+			if event.Type == "DELETE" {
+				delete(instances, key)
+			} else {
+				instances[key] = value
+			}
+		}
+		publish()
+	}
+}
+
+func (e *Etcd) post(ctx context.Context, path string, body interface{}) (*http.Response, error) {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.addr+path, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("etcd returned %d", resp.StatusCode)
+	}
+	return resp, nil
+}
+
+func encodeKey(key string) string {
+	return base64.StdEncoding.EncodeToString([]byte(key))
+}
+
+func decodeKV(kv etcdKV) (string, string, error) {
+	key, err := base64.StdEncoding.DecodeString(kv.Key)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid etcd key: %w", err)
+	}
+	value, err := base64.StdEncoding.DecodeString(kv.Value)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid etcd value: %w", err)
+	}
+	return string(key), strings.TrimSpace(string(value)), nil
+}
+
+// prefixEnd returns the range end covering every key with the given prefix
+func prefixEnd(prefix string) string {
+	end := []byte(prefix)
+	end[len(end)-1]++
+	return string(end)
+}
+
+func sortedValues(m map[string]string) []string {
+	values := make([]string, 0, len(m))
+	for _, v := range m {
+		if v != "" {
+			values = append(values, v)
+		}
+	}
+	sort.Strings(values)
+	return values
+}
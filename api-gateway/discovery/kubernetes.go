@@ -0,0 +1,241 @@
+package discovery
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+const serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// endpointSlice is the subset of discovery.k8s.io/v1 EndpointSlice the gateway needs
+type endpointSlice struct {
+	Metadata struct {
+		Name            string `json:"name"`
+		ResourceVersion string `json:"resourceVersion"`
+	} `json:"metadata"`
+	Endpoints []struct {
+		Addresses  []string `json:"addresses"`
+		Conditions struct {
+			Ready *bool `json:"ready"`
+		} `json:"conditions"`
+	} `json:"endpoints"`
+	Ports []struct {
+		Name *string `json:"name"`
+		Port *int    `json:"port"`
+	} `json:"ports"`
+}
+
+type endpointSliceList struct {
+	Metadata struct {
+		ResourceVersion string `json:"resourceVersion"`
+	} `json:"metadata"`
+	Items []endpointSlice `json:"items"`
+}
+
+type watchEvent struct {
+	Type   string        `json:"type"`
+	Object endpointSlice `json:"object"`
+}
+
+// Kubernetes watches EndpointSlices of each upstream's Service using the
+// in-cluster service account and reports ready pod addresses
+type Kubernetes struct {
+	client    *http.Client
+	apiServer string
+	namespace string
+	portName  string
+	logger    *zap.Logger
+}
+
+// NewKubernetes creates an in-cluster EndpointSlice watcher. An empty namespace
+// defaults to the gateway's own namespace; portName selects the named port of
+// each slice (falling back to the first port).
+func NewKubernetes(namespace, portName string, logger *zap.Logger) (*Kubernetes, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, fmt.Errorf("kubernetes discovery requires running in-cluster")
+	}
+
+	caCert, err := os.ReadFile(serviceAccountDir + "/ca.crt")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read service account CA: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caCert) {
+		return nil, fmt.Errorf("invalid service account CA certificate")
+	}
+
+	if namespace == "" {
+		ns, err := os.ReadFile(serviceAccountDir + "/namespace")
+		if err != nil {
+			return nil, fmt.Errorf("failed to read service account namespace: %w", err)
+		}
+		namespace = strings.TrimSpace(string(ns))
+	}
+
+	return &Kubernetes{
+		client: &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{RootCAs: pool},
+			},
+		},
+		apiServer: "https://" + net.JoinHostPort(host, port),
+		namespace: namespace,
+		portName:  portName,
+		logger:    logger,
+	}, nil
+}
+
+// Watch follows the EndpointSlices of every upstream's Kubernetes Service and
+// reports ready pod addresses whenever they change
+func (k *Kubernetes) Watch(ctx context.Context, upstreams map[string]string, update UpdateFunc) {
+	watchAll(ctx, upstreams, func(ctx context.Context, baseURL, scheme, service string) {
+		retryLoop(ctx, k.logger, service, func() error {
+			slices := make(map[string]endpointSlice)
+			publish := func() {
+				update(baseURL, k.endpointURLs(scheme, slices))
+			}
+
+			resourceVersion, err := k.list(ctx, service, slices)
+			if err != nil {
+				return err
+			}
+			publish()
+			return k.watch(ctx, service, resourceVersion, slices, publish)
+		})
+	})
+}
+
+func (k *Kubernetes) list(ctx context.Context, service string, slices map[string]endpointSlice) (string, error) {
+	resp, err := k.get(ctx, service, url.Values{})
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var list endpointSliceList
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return "", fmt.Errorf("failed to decode endpoint slices: %w", err)
+	}
+
+	for _, slice := range list.Items {
+		slices[slice.Metadata.Name] = slice
+	}
+	return list.Metadata.ResourceVersion, nil
+}
+
+func (k *Kubernetes) watch(ctx context.Context, service, resourceVersion string, slices map[string]endpointSlice, publish func()) error {
+	resp, err := k.get(ctx, service, url.Values{
+		"watch":           {"1"},
+		"resourceVersion": {resourceVersion},
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var event watchEvent
+		if err := dec.Decode(&event); err != nil {
+			if errors.Is(err, io.EOF) {
+				// The API server ends watches periodically; re-list
+				return nil
+			}
+			return fmt.Errorf("watch stream failed: %w", err)
+		}
+
+		switch event.Type {
+		case "ADDED", "MODIFIED":
+			slices[event.Object.Metadata.Name] = event.Object
+		case "DELETED":
+			delete(slices, event.Object.Metadata.Name)
+		case "ERROR":
+			// Usually an expired resourceVersion; re-list
+			return fmt.Errorf("watch error event")
+		default:
+			continue
+		}
+		publish()
+	}
+}
+
+func (k *Kubernetes) get(ctx context.Context, service string, query url.Values) (*http.Response, error) {
+	query.Set("labelSelector", "kubernetes.io/service-name="+service)
+	endpoint := fmt.Sprintf("%s/apis/discovery.k8s.io/v1/namespaces/%s/endpointslices?%s",
+		k.apiServer, k.namespace, query.Encode())
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// Projected service account tokens rotate, so read it on every request
+	token, err := os.ReadFile(serviceAccountDir + "/token")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read service account token: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("kubernetes API returned %d", resp.StatusCode)
+	}
+	return resp, nil
+}
+
+// endpointURLs flattens the ready addresses of all slices into sorted base URLs
+func (k *Kubernetes) endpointURLs(scheme string, slices map[string]endpointSlice) []string {
+	seen := make(map[string]bool)
+	for _, slice := range slices {
+		port := k.slicePort(slice)
+		if port == 0 {
+			continue
+		}
+		for _, endpoint := range slice.Endpoints {
+			if endpoint.Conditions.Ready != nil && !*endpoint.Conditions.Ready {
+				continue
+			}
+			for _, addr := range endpoint.Addresses {
+				seen[scheme+"://"+net.JoinHostPort(addr, strconv.Itoa(port))] = true
+			}
+		}
+	}
+
+	urls := make([]string, 0, len(seen))
+	for u := range seen {
+		urls = append(urls, u)
+	}
+	sort.Strings(urls)
+	return urls
+}
+
+func (k *Kubernetes) slicePort(slice endpointSlice) int {
+	for _, port := range slice.Ports {
+		if port.Port != nil && port.Name != nil && *port.Name == k.portName {
+			return *port.Port
+		}
+	}
+	if len(slice.Ports) > 0 && slice.Ports[0].Port != nil {
+		return *slice.Ports[0].Port
+	}
+	return 0
+}
@@ -0,0 +1,106 @@
+// Package egress builds the HTTP clients for the gateway's calls to the
+// internet, partner webhook deliveries and ACME certificate orders, so they
+// leave through the configured egress proxy and per-destination rules.
+// Calls to internal upstreams use the proxy package's transports instead.
+package egress
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/YeonwooSung/instagram/api-gateway/config"
+)
+
+// Egress actions for outbound destinations
+const (
+	ActionProxy  = "proxy"
+	ActionDirect = "direct"
+	ActionDeny   = "deny"
+)
+
+// Policy decides how outbound calls to the internet leave the gateway:
+// through the egress proxy, directly, or not at all
+type Policy struct {
+	proxyURL      *url.URL
+	rules         []config.EgressRule
+	defaultAction string
+}
+
+// NewPolicy builds the egress policy from configuration
+func NewPolicy(cfg *config.Config) (*Policy, error) {
+	policy := &Policy{
+		rules:         cfg.EgressRules,
+		defaultAction: ActionDirect,
+	}
+
+	if cfg.EgressProxyURL != "" {
+		proxyURL, err := url.Parse(cfg.EgressProxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid egress proxy URL: %w", err)
+		}
+		policy.proxyURL = proxyURL
+		policy.defaultAction = ActionProxy
+	}
+
+	return policy, nil
+}
+
+// Action returns the action that applies to host; the first matching rule wins
+func (p *Policy) Action(host string) string {
+	host = strings.ToLower(host)
+	for _, rule := range p.rules {
+		if matchHost(rule.Host, host) {
+			return rule.Action
+		}
+	}
+	return p.defaultAction
+}
+
+// proxy implements http.Transport.Proxy for the policy
+func (p *Policy) proxy(req *http.Request) (*url.URL, error) {
+	switch p.Action(req.URL.Hostname()) {
+	case ActionDeny:
+		return nil, fmt.Errorf("egress to %s denied by policy", req.URL.Hostname())
+	case ActionProxy:
+		if p.proxyURL == nil {
+			return nil, fmt.Errorf("egress to %s requires a proxy but none is configured", req.URL.Hostname())
+		}
+		return p.proxyURL, nil
+	default:
+		return nil, nil
+	}
+}
+
+// NewClient returns an HTTP client for external calls that honours the
+// policy. HTTP(S) and SOCKS5 proxy URLs are supported.
+func (p *Policy) NewClient(timeout time.Duration) *http.Client {
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			Proxy: p.proxy,
+			DialContext: (&net.Dialer{
+				Timeout:   5 * time.Second,
+				KeepAlive: 30 * time.Second,
+			}).DialContext,
+			MaxIdleConnsPerHost: 8,
+			IdleConnTimeout:     90 * time.Second,
+			TLSHandshakeTimeout: 5 * time.Second,
+		},
+	}
+}
+
+// matchHost matches exact hosts and "*.example.com" wildcard patterns
+func matchHost(pattern, host string) bool {
+	pattern = strings.ToLower(pattern)
+	if pattern == "*" {
+		return true
+	}
+	if suffix, ok := strings.CutPrefix(pattern, "*."); ok {
+		return host == suffix || strings.HasSuffix(host, "."+suffix)
+	}
+	return pattern == host
+}
@@ -0,0 +1,222 @@
+// Package events publishes events to Kafka without putting Kafka on the
+// request path. The gateway reaches Kafka through a REST proxy speaking the
+// Confluent v2 records API, so it needs no broker client. Publishing only
+// queues a record in memory; a background worker sends queued records to
+// their topics in batches. Delivery is best effort: records are dropped, and
+// counted, when the queue is full or the REST proxy keeps failing, which
+// suits high-volume data such as clickstream events.
+package events
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/YeonwooSung/instagram/api-gateway/metrics"
+	"go.uber.org/zap"
+)
+
+// contentType is the REST proxy's embedded JSON format
+const contentType = "application/vnd.kafka.json.v2+json"
+
+// Record is one Kafka message. Records with the same key land on the same
+// partition, so their order is kept; records without one are spread out.
+type Record struct {
+	Key   string          `json:"key,omitempty"`
+	Value json.RawMessage `json:"value"`
+}
+
+// Options configures the publisher
+type Options struct {
+	// URL is the base URL of the Kafka REST proxy
+	URL string
+
+	// QueueSize caps the records waiting to be sent
+	QueueSize int
+
+	// BatchSize caps the records sent to a topic in one request
+	BatchSize int
+
+	// FlushInterval is the longest a record waits for its batch to fill
+	FlushInterval time.Duration
+
+	// Timeout bounds a single request to the REST proxy
+	Timeout time.Duration
+
+	// MaxAttempts is the number of requests made for a batch before it is dropped
+	MaxAttempts int
+}
+
+type message struct {
+	topic  string
+	record Record
+}
+
+// Publisher queues records and sends them to Kafka in the background
+type Publisher struct {
+	client *http.Client
+	opts   Options
+	queue  chan message
+	logger *zap.Logger
+}
+
+// New creates a publisher for the REST proxy at opts.URL. Records are only
+// sent while Run is running.
+func New(opts Options, logger *zap.Logger) *Publisher {
+	opts.URL = strings.TrimRight(opts.URL, "/")
+	return &Publisher{
+		client: &http.Client{Timeout: opts.Timeout},
+		opts:   opts,
+		queue:  make(chan message, opts.QueueSize),
+		logger: logger,
+	}
+}
+
+// Publish queues a record for topic without blocking. It reports false when
+// the record was dropped because the queue is full. A nil Publisher drops
+// every record, so callers need not check whether Kafka is set up.
+func (p *Publisher) Publish(topic string, record Record) bool {
+	if p == nil {
+		return false
+	}
+	select {
+	case p.queue <- message{topic: topic, record: record}:
+		return true
+	default:
+		metrics.Inc("gateway_events_published_total", "topic", topic, "result", "dropped")
+		return false
+	}
+}
+
+// Run sends queued records until ctx is cancelled, then sends what is left
+// in the queue before returning
+func (p *Publisher) Run(ctx context.Context) {
+	pending := make(map[string][]Record)
+	ticker := time.NewTicker(p.opts.FlushInterval)
+	defer ticker.Stop()
+
+	add := func(ctx context.Context, msg message) {
+		pending[msg.topic] = append(pending[msg.topic], msg.record)
+		if len(pending[msg.topic]) >= p.opts.BatchSize {
+			p.send(ctx, msg.topic, pending[msg.topic])
+			delete(pending, msg.topic)
+		}
+	}
+	flush := func(ctx context.Context) {
+		for topic, records := range pending {
+			p.send(ctx, topic, records)
+			delete(pending, topic)
+		}
+	}
+
+	for {
+		select {
+		case msg := <-p.queue:
+			add(ctx, msg)
+		case <-ticker.C:
+			flush(ctx)
+		case <-ctx.Done():
+			// Shutting down; one attempt each for what is left
+			drainCtx, cancel := context.WithTimeout(context.Background(), p.opts.Timeout)
+			defer cancel()
+			for {
+				select {
+				case msg := <-p.queue:
+					add(drainCtx, msg)
+				default:
+					flush(drainCtx)
+					return
+				}
+			}
+		}
+	}
+}
+
+// send produces records to topic, retrying failed requests with backoff
+// while ctx allows. Records that cannot be sent are dropped.
+func (p *Publisher) send(ctx context.Context, topic string, records []Record) {
+	body, err := json.Marshal(map[string][]Record{"records": records})
+	if err != nil {
+		p.fail(topic, records, err)
+		return
+	}
+
+	backoff := 100 * time.Millisecond
+	for attempt := 1; ; attempt++ {
+		failed, retry, err := p.produce(ctx, topic, body)
+		if err == nil {
+			metrics.Add("gateway_events_published_total", int64(len(records)-failed), "topic", topic, "result", "ok")
+			if failed > 0 {
+				metrics.Add("gateway_events_published_total", int64(failed), "topic", topic, "result", "failed")
+				p.logger.Warn("Kafka rejected events", zap.String("topic", topic), zap.Int("count", failed))
+			}
+			return
+		}
+		if !retry || attempt >= p.opts.MaxAttempts || ctx.Err() != nil {
+			p.fail(topic, records, err)
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// produce makes one request to the REST proxy. It returns the number of
+// records the proxy could not write, or an error and whether it is worth
+// retrying.
+func (p *Publisher) produce(ctx context.Context, topic string, body []byte) (int, bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.opts.URL+"/topics/"+url.PathEscape(topic), bytes.NewReader(body))
+	if err != nil {
+		return 0, false, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Accept", "application/vnd.kafka.v2+json, application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return 0, true, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(io.Discard, resp.Body)
+		err := fmt.Errorf("kafka rest proxy returned %d", resp.StatusCode)
+		return 0, resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests, err
+	}
+
+	var result struct {
+		Offsets []struct {
+			Error *string `json:"error"`
+		} `json:"offsets"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		// Written, but the proxy's answer is unreadable; retrying would duplicate
+		return 0, false, nil
+	}
+	failed := 0
+	for _, offset := range result.Offsets {
+		if offset.Error != nil {
+			failed++
+		}
+	}
+	return failed, false, nil
+}
+
+func (p *Publisher) fail(topic string, records []Record, err error) {
+	metrics.Add("gateway_events_published_total", int64(len(records)), "topic", topic, "result", "failed")
+	p.logger.Warn("Failed to publish events to Kafka",
+		zap.String("topic", topic),
+		zap.Int("count", len(records)),
+		zap.Error(err),
+	)
+}
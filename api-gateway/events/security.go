@@ -0,0 +1,68 @@
+package events
+
+import (
+	"encoding/json"
+	"sync/atomic"
+	"time"
+)
+
+// Security event types
+const (
+	SecurityLogin         = "login"
+	SecurityRateLimited   = "rate_limited"
+	SecurityAdminAction   = "admin_action"
+	SecurityBreakerOpened = "breaker_opened"
+	SecurityBreakerClosed = "breaker_closed"
+)
+
+// SecurityEvent is a structured record of gateway activity that detection
+// pipelines watch: sign-ins, clients cut off by rate limits, admin actions
+// and upstream circuit breakers tripping
+type SecurityEvent struct {
+	Type      string            `json:"type"`
+	Outcome   string            `json:"outcome,omitempty"`
+	UserID    string            `json:"user_id,omitempty"`
+	ClientIP  string            `json:"client_ip,omitempty"`
+	UserAgent string            `json:"user_agent,omitempty"`
+	Route     string            `json:"route,omitempty"`
+	Status    int               `json:"status,omitempty"`
+	RequestID string            `json:"request_id,omitempty"`
+	Details   map[string]string `json:"details,omitempty"`
+	At        time.Time         `json:"at"`
+}
+
+// securitySink is where Security sends events
+type securitySink struct {
+	publisher *Publisher
+	topic     string
+}
+
+var security atomic.Pointer[securitySink]
+
+// SetSecurityPublisher sends security events to topic through publisher.
+// Until it is called, or with a nil publisher, security events are discarded.
+func SetSecurityPublisher(publisher *Publisher, topic string) {
+	security.Store(&securitySink{publisher: publisher, topic: topic})
+}
+
+// Security publishes a security event. Like metrics it can be called from
+// anywhere and never blocks. Events are keyed by user, or by client IP, so
+// each actor's events stay in order.
+func Security(event SecurityEvent) {
+	sink := security.Load()
+	if sink == nil || sink.publisher == nil {
+		return
+	}
+	if event.At.IsZero() {
+		event.At = time.Now().UTC()
+	}
+	value, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	key := event.UserID
+	if key == "" {
+		key = event.ClientIP
+	}
+	sink.publisher.Publish(sink.topic, Record{Key: key, Value: value})
+}
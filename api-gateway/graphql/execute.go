@@ -0,0 +1,609 @@
+package graphql
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// Request is a GraphQL request as sent over HTTP
+type Request struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// Response is a GraphQL response. Data is left out when the request failed
+// before execution.
+type Response struct {
+	Data     interface{}
+	Errors   []*Error
+	executed bool
+}
+
+// Error is a request or field error. Path locates the field of a field error.
+type Error struct {
+	Message string        `json:"message"`
+	Path    []interface{} `json:"path,omitempty"`
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Limits bound the queries a schema executes
+type Limits struct {
+	// MaxDepth bounds the nesting of selections
+	MaxDepth int
+
+	// MaxFields bounds the fields selected, counting fragment fields each
+	// time they're spread
+	MaxFields int
+}
+
+func (r *Response) MarshalJSON() ([]byte, error) {
+	doc := make(map[string]interface{}, 2)
+	if r.executed {
+		doc["data"] = r.Data
+	}
+	if len(r.Errors) > 0 {
+		doc["errors"] = r.Errors
+	}
+	return json.Marshal(doc)
+}
+
+// Execute validates and runs a query against the schema. Fields are resolved
+// level by level: resolvers at one depth queue their loads, the loader
+// dispatches them together, and only then are the next level's fields
+// resolved. Field errors null the field and are listed in the response.
+func (s *Schema) Execute(ctx context.Context, req Request, loader *Loader, limits Limits) *Response {
+	doc, err := Parse(req.Query)
+	if err != nil {
+		return failed(err.Error())
+	}
+	op, err := selectOperation(doc, req.OperationName)
+	if err != nil {
+		return failed(err.Error())
+	}
+	if op.Kind != "query" {
+		return failed("only queries are supported")
+	}
+
+	e := &executor{schema: s, doc: doc, loader: loader}
+	if err := e.coerceVariables(op, req.Variables); err != nil {
+		return failed(err.Error())
+	}
+	v := &validator{executor: e, limits: limits, defined: make(map[string]bool)}
+	for _, def := range op.Variables {
+		v.defined[def.Name] = true
+	}
+	v.selections(s.Query, op.SelectionSet, 1, nil)
+	if len(v.errors) > 0 {
+		return &Response{Errors: v.errors}
+	}
+
+	data := e.execute(ctx, op)
+	return &Response{Data: data, Errors: e.errors, executed: true}
+}
+
+func failed(message string) *Response {
+	return &Response{Errors: []*Error{{Message: message}}}
+}
+
+func selectOperation(doc *Document, name string) (*Operation, error) {
+	if name == "" {
+		if len(doc.Operations) > 1 {
+			return nil, fmt.Errorf("operationName is required for documents with several operations")
+		}
+		return doc.Operations[0], nil
+	}
+	for _, op := range doc.Operations {
+		if op.Name == name {
+			return op, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown operation %q", name)
+}
+
+// orderedMap is a response object, keeping fields in selection order
+type orderedMap struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+func newOrderedMap() *orderedMap {
+	return &orderedMap{values: make(map[string]interface{})}
+}
+
+func (m *orderedMap) set(key string, value interface{}) {
+	if _, exists := m.values[key]; !exists {
+		m.keys = append(m.keys, key)
+	}
+	m.values[key] = value
+}
+
+func (m *orderedMap) MarshalJSON() ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, key := range m.keys {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		name, _ := json.Marshal(key)
+		value, err := json.Marshal(m.values[key])
+		if err != nil {
+			return nil, err
+		}
+		b.Write(name)
+		b.WriteByte(':')
+		b.Write(value)
+	}
+	b.WriteByte('}')
+	return b.Bytes(), nil
+}
+
+type executor struct {
+	schema    *Schema
+	doc       *Document
+	loader    *Loader
+	variables map[string]interface{}
+	errors    []*Error
+}
+
+// fieldTask is the resolution of one response key of one object
+type fieldTask struct {
+	parent *orderedMap
+	key    string
+	object *Object
+	fields []*Field
+	source interface{}
+	path   []interface{}
+	value  interface{}
+	err    error
+}
+
+func (e *executor) execute(ctx context.Context, op *Operation) *orderedMap {
+	root := newOrderedMap()
+	tasks := e.objectTasks(root, e.schema.Query, nil, op.SelectionSet, nil, nil)
+	for len(tasks) > 0 {
+		for _, task := range tasks {
+			task.value, task.err = e.resolve(ctx, task)
+		}
+		// Thunks may chain into further loads
+		for deferred := true; deferred; {
+			if e.loader.pendingLoads() {
+				e.loader.Dispatch(ctx)
+			}
+			deferred = false
+			for _, task := range tasks {
+				if thunk, ok := task.value.(Thunk); ok && task.err == nil {
+					task.value, task.err = thunk()
+					_, again := task.value.(Thunk)
+					deferred = deferred || again
+				}
+			}
+		}
+
+		var next []*fieldTask
+		for _, task := range tasks {
+			if task.err != nil {
+				e.fieldError(task.path, task.err.Error())
+				task.parent.set(task.key, nil)
+				continue
+			}
+			def := task.object.Fields[task.fields[0].Name]
+			if def == nil {
+				// __typename
+				task.parent.set(task.key, task.value)
+				continue
+			}
+			task.parent.set(task.key, e.complete(def.Type, task.fields, task.value, task.path, &next))
+		}
+		tasks = next
+	}
+	return root
+}
+
+// objectTasks creates the field tasks of an object, reserving its keys in
+// selection order
+func (e *executor) objectTasks(m *orderedMap, object *Object, source interface{}, selections []Selection, path []interface{}, tasks []*fieldTask) []*fieldTask {
+	keys, fields := e.collectFields(object, selections, nil, nil, nil)
+	for _, key := range keys {
+		m.set(key, nil)
+		tasks = append(tasks, &fieldTask{
+			parent: m,
+			key:    key,
+			object: object,
+			fields: fields[key],
+			source: source,
+			path:   appendPath(path, key),
+		})
+	}
+	return tasks
+}
+
+// collectFields groups the fields of a selection set by response key,
+// expanding fragments and applying @skip and @include
+func (e *executor) collectFields(object *Object, selections []Selection, keys []string, fields map[string][]*Field, visited map[string]bool) ([]string, map[string][]*Field) {
+	if fields == nil {
+		fields = make(map[string][]*Field)
+	}
+	for _, selection := range selections {
+		if !e.included(selection.directives()) {
+			continue
+		}
+		switch s := selection.(type) {
+		case *Field:
+			key := s.ResponseKey()
+			if _, seen := fields[key]; !seen {
+				keys = append(keys, key)
+			}
+			fields[key] = append(fields[key], s)
+		case *FragmentSpread:
+			if visited[s.Name] {
+				continue
+			}
+			if visited == nil {
+				visited = make(map[string]bool)
+			}
+			visited[s.Name] = true
+			fragment := e.doc.Fragments[s.Name]
+			keys, fields = e.collectFields(object, fragment.SelectionSet, keys, fields, visited)
+		case *InlineFragment:
+			keys, fields = e.collectFields(object, s.SelectionSet, keys, fields, visited)
+		}
+	}
+	return keys, fields
+}
+
+// included applies the @skip and @include directives
+func (e *executor) included(directives []*Directive) bool {
+	for _, directive := range directives {
+		if directive.Name != "skip" && directive.Name != "include" {
+			continue
+		}
+		condition, _ := e.literal(directive.Arguments["if"]).(bool)
+		if directive.Name == "skip" && condition || directive.Name == "include" && !condition {
+			return false
+		}
+	}
+	return true
+}
+
+func (e *executor) resolve(ctx context.Context, task *fieldTask) (interface{}, error) {
+	field := task.fields[0]
+	if field.Name == "__typename" {
+		return task.object.Name, nil
+	}
+	def := task.object.Fields[field.Name]
+	args, err := e.arguments(def, field)
+	if err != nil {
+		return nil, err
+	}
+	if def.Resolve != nil {
+		return def.Resolve(ResolveParams{Context: ctx, Source: task.source, Args: args, Loader: e.loader})
+	}
+	source, _ := task.source.(map[string]interface{})
+	key := def.Key
+	if key == "" {
+		key = field.Name
+	}
+	return source[key], nil
+}
+
+// complete converts a resolved value to its response value, queuing the
+// fields of objects for the next level
+func (e *executor) complete(t Type, fields []*Field, value interface{}, path []interface{}, next *[]*fieldTask) interface{} {
+	if nonNull, ok := t.(*NonNull); ok {
+		completed := e.complete(nonNull.Of, fields, value, path, next)
+		if completed == nil {
+			e.fieldError(path, fmt.Sprintf("non-null field resolved to null (%s)", t))
+		}
+		return completed
+	}
+	if value == nil {
+		return nil
+	}
+
+	switch t := t.(type) {
+	case *List:
+		items, ok := value.([]interface{})
+		if !ok {
+			e.fieldError(path, "expected a list")
+			return nil
+		}
+		list := make([]interface{}, len(items))
+		for i, item := range items {
+			list[i] = e.complete(t.Of, fields, item, appendPath(path, i), next)
+		}
+		return list
+	case *Scalar:
+		serialized, ok := t.Serialize(value)
+		if !ok {
+			e.fieldError(path, fmt.Sprintf("value can't be represented as %s", t.Name))
+			return nil
+		}
+		return serialized
+	case *Object:
+		var selections []Selection
+		for _, field := range fields {
+			selections = append(selections, field.SelectionSet...)
+		}
+		m := newOrderedMap()
+		*next = e.objectTasks(m, t, value, selections, path, *next)
+		return m
+	}
+	return nil
+}
+
+func (e *executor) fieldError(path []interface{}, message string) {
+	e.errors = append(e.errors, &Error{Message: message, Path: path})
+}
+
+// arguments coerces the field's arguments, applying defaults
+func (e *executor) arguments(def *FieldDef, field *Field) (map[string]interface{}, error) {
+	args := make(map[string]interface{}, len(def.Args))
+	for name, arg := range def.Args {
+		raw, given := field.Arguments[name]
+		value := e.literal(raw)
+		if !given || value == nil && isVariable(raw) {
+			if arg.Default != nil {
+				args[name] = arg.Default
+				continue
+			}
+		}
+		coerced, err := coerce(arg.Type, value)
+		if err != nil {
+			return nil, fmt.Errorf("argument %q: %v", name, err)
+		}
+		if coerced != nil {
+			args[name] = coerced
+		}
+	}
+	return args, nil
+}
+
+func isVariable(value Value) bool {
+	_, ok := value.(Variable)
+	return ok
+}
+
+// literal resolves variables in a query value
+func (e *executor) literal(value Value) interface{} {
+	switch v := value.(type) {
+	case Variable:
+		return e.variables[string(v)]
+	case Enum:
+		return string(v)
+	case []Value:
+		list := make([]interface{}, len(v))
+		for i, item := range v {
+			list[i] = e.literal(item)
+		}
+		return list
+	case map[string]Value:
+		object := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			object[key] = e.literal(item)
+		}
+		return object
+	}
+	return value
+}
+
+// coerce checks an input value against its type
+func coerce(t Type, value interface{}) (interface{}, error) {
+	if nonNull, ok := t.(*NonNull); ok {
+		if value == nil {
+			return nil, fmt.Errorf("expected a non-null %s", nonNull.Of)
+		}
+		return coerce(nonNull.Of, value)
+	}
+	if value == nil {
+		return nil, nil
+	}
+	switch t := t.(type) {
+	case *List:
+		items, ok := value.([]interface{})
+		if !ok {
+			items = []interface{}{value}
+		}
+		list := make([]interface{}, len(items))
+		for i, item := range items {
+			coerced, err := coerce(t.Of, item)
+			if err != nil {
+				return nil, err
+			}
+			list[i] = coerced
+		}
+		return list, nil
+	case *Scalar:
+		parsed, ok := t.Parse(value)
+		if !ok {
+			return nil, fmt.Errorf("expected %s", t.Name)
+		}
+		return parsed, nil
+	}
+	return nil, fmt.Errorf("unsupported input type %s", t)
+}
+
+// coerceVariables applies defaults and checks required variables are given.
+// Values are checked against argument types where they are used.
+func (e *executor) coerceVariables(op *Operation, given map[string]interface{}) error {
+	e.variables = make(map[string]interface{}, len(op.Variables))
+	for _, def := range op.Variables {
+		value, ok := given[def.Name]
+		if !ok && def.Default != nil {
+			value = e.literal(def.Default)
+		}
+		if value == nil && def.Type[len(def.Type)-1] == '!' {
+			return fmt.Errorf("variable $%s of type %s is required", def.Name, def.Type)
+		}
+		e.variables[def.Name] = normalizeVariable(value)
+	}
+	return nil
+}
+
+// normalizeVariable converts JSON integers to the int64 of query literals
+func normalizeVariable(value interface{}) interface{} {
+	switch v := value.(type) {
+	case float64:
+		if v == float64(int64(v)) {
+			return int64(v)
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = normalizeVariable(item)
+		}
+	}
+	return value
+}
+
+func appendPath(path []interface{}, element interface{}) []interface{} {
+	extended := make([]interface{}, len(path)+1)
+	copy(extended, path)
+	extended[len(path)] = element
+	return extended
+}
+
+// validator checks a query against the schema and limits before it runs
+type validator struct {
+	*executor
+	limits  Limits
+	defined map[string]bool
+	fields  int
+	errors  []*Error
+}
+
+func (v *validator) fail(format string, args ...interface{}) {
+	v.errors = append(v.errors, &Error{Message: fmt.Sprintf(format, args...)})
+}
+
+func (v *validator) selections(object *Object, selections []Selection, depth int, spreading []string) {
+	if v.limits.MaxDepth > 0 && depth > v.limits.MaxDepth {
+		v.fail("query exceeds the maximum depth of %d", v.limits.MaxDepth)
+		return
+	}
+	for _, selection := range selections {
+		if len(v.errors) > 0 {
+			return
+		}
+		for _, directive := range selection.directives() {
+			if directive.Name != "skip" && directive.Name != "include" {
+				v.fail("unknown directive @%s", directive.Name)
+			}
+			v.values(directive.Arguments)
+		}
+
+		switch s := selection.(type) {
+		case *Field:
+			v.field(object, s, depth, spreading)
+		case *FragmentSpread:
+			fragment := v.doc.Fragments[s.Name]
+			if fragment == nil {
+				v.fail("unknown fragment %q", s.Name)
+				return
+			}
+			for _, name := range spreading {
+				if name == s.Name {
+					v.fail("fragment %q spreads itself", s.Name)
+					return
+				}
+			}
+			if fragment.TypeCondition != object.Name {
+				v.fail("fragment %q on %s can't be spread on %s", s.Name, fragment.TypeCondition, object.Name)
+				return
+			}
+			v.selections(object, fragment.SelectionSet, depth, append(spreading, s.Name))
+		case *InlineFragment:
+			if s.TypeCondition != "" && s.TypeCondition != object.Name {
+				v.fail("inline fragment on %s can't be spread on %s", s.TypeCondition, object.Name)
+				return
+			}
+			v.selections(object, s.SelectionSet, depth, spreading)
+		}
+	}
+}
+
+func (v *validator) field(object *Object, field *Field, depth int, spreading []string) {
+	v.fields++
+	if v.limits.MaxFields > 0 && v.fields > v.limits.MaxFields {
+		v.fail("query selects more than %d fields", v.limits.MaxFields)
+		return
+	}
+	if field.Name == "__typename" {
+		if len(field.SelectionSet) > 0 || len(field.Arguments) > 0 {
+			v.fail("__typename takes no arguments or selections")
+		}
+		return
+	}
+	def := object.Fields[field.Name]
+	if def == nil {
+		v.fail("cannot query field %q on type %s (line %d)", field.Name, object.Name, field.Line)
+		return
+	}
+
+	for name := range field.Arguments {
+		if def.Args[name] == nil {
+			v.fail("unknown argument %q on field %s.%s", name, object.Name, field.Name)
+			return
+		}
+	}
+	v.values(field.Arguments)
+	for name, arg := range def.Args {
+		if _, nonNull := arg.Type.(*NonNull); nonNull && arg.Default == nil && field.Arguments[name] == nil {
+			v.fail("field %s.%s requires argument %q", object.Name, field.Name, name)
+			return
+		}
+	}
+
+	named := def.Type
+	for {
+		switch t := named.(type) {
+		case *List:
+			named = t.Of
+			continue
+		case *NonNull:
+			named = t.Of
+			continue
+		}
+		break
+	}
+	switch t := named.(type) {
+	case *Scalar:
+		if len(field.SelectionSet) > 0 {
+			v.fail("field %s.%s of type %s can't have a selection", object.Name, field.Name, t.Name)
+		}
+	case *Object:
+		if len(field.SelectionSet) == 0 {
+			v.fail("field %s.%s of type %s must have a selection", object.Name, field.Name, t.Name)
+			return
+		}
+		v.selections(t, field.SelectionSet, depth+1, spreading)
+	}
+}
+
+// values checks every variable used is defined by the operation
+func (v *validator) values(args map[string]Value) {
+	var check func(value Value)
+	check = func(value Value) {
+		switch value := value.(type) {
+		case Variable:
+			if !v.defined[string(value)] {
+				v.fail("variable $%s is not defined", value)
+			}
+		case []Value:
+			for _, item := range value {
+				check(item)
+			}
+		case map[string]Value:
+			for _, item := range value {
+				check(item)
+			}
+		}
+	}
+	for _, value := range args {
+		check(value)
+	}
+}
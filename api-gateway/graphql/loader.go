@@ -0,0 +1,82 @@
+package graphql
+
+import (
+	"context"
+	"sync"
+)
+
+// FetchFunc loads the value of one key
+type FetchFunc func(ctx context.Context, key string) (interface{}, error)
+
+// Loader batches the loads of one query: resolvers queue keys while a level
+// of the query is resolved, then every distinct key is fetched concurrently
+// in one dispatch. Each key is fetched at most once per query, however many
+// fields ask for it.
+type Loader struct {
+	fetch       FetchFunc
+	concurrency int
+
+	mu      sync.Mutex
+	loads   map[string]*load
+	pending []*load
+}
+
+type load struct {
+	key   string
+	value interface{}
+	err   error
+}
+
+// NewLoader creates a loader running at most concurrency fetches at a time
+func NewLoader(fetch FetchFunc, concurrency int) *Loader {
+	return &Loader{
+		fetch:       fetch,
+		concurrency: max(concurrency, 1),
+		loads:       make(map[string]*load),
+	}
+}
+
+// Load queues key for the next dispatch and returns a thunk of its value
+func (l *Loader) Load(key string) Thunk {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	ld, ok := l.loads[key]
+	if !ok {
+		ld = &load{key: key}
+		l.loads[key] = ld
+		l.pending = append(l.pending, ld)
+	}
+	return func() (interface{}, error) {
+		return ld.value, ld.err
+	}
+}
+
+// Dispatch fetches every queued key
+func (l *Loader) Dispatch(ctx context.Context) {
+	l.mu.Lock()
+	pending := l.pending
+	l.pending = nil
+	l.mu.Unlock()
+
+	slots := make(chan struct{}, l.concurrency)
+	var wg sync.WaitGroup
+	for _, ld := range pending {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(ld *load) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			ld.value, ld.err = l.fetch(ctx, ld.key)
+		}(ld)
+	}
+	wg.Wait()
+}
+
+// pendingLoads reports whether keys are queued
+func (l *Loader) pendingLoads() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.pending) > 0
+}
@@ -0,0 +1,527 @@
+// Package graphql executes GraphQL queries against a schema whose fields are
+// resolved by gateway code. It implements the query subset of the language:
+// operations, fields with aliases and arguments, variables, fragments, inline
+// fragments and the @skip and @include directives.
+package graphql
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Document is a parsed executable GraphQL document
+type Document struct {
+	Operations []*Operation
+	Fragments  map[string]*Fragment
+}
+
+// Operation is a query, mutation or subscription definition
+type Operation struct {
+	Kind         string
+	Name         string
+	Variables    []*VariableDefinition
+	SelectionSet []Selection
+}
+
+// VariableDefinition declares an operation variable
+type VariableDefinition struct {
+	Name    string
+	Type    string
+	Default Value
+}
+
+// Fragment is a named fragment definition
+type Fragment struct {
+	Name          string
+	TypeCondition string
+	SelectionSet  []Selection
+}
+
+// Selection is a *Field, *FragmentSpread or *InlineFragment
+type Selection interface {
+	directives() []*Directive
+}
+
+// Field selects a field of an object, under its alias when set
+type Field struct {
+	Alias        string
+	Name         string
+	Arguments    map[string]Value
+	Directives   []*Directive
+	SelectionSet []Selection
+	Line         int
+}
+
+// FragmentSpread includes a named fragment
+type FragmentSpread struct {
+	Name       string
+	Directives []*Directive
+}
+
+// InlineFragment includes selections, optionally for one type only
+type InlineFragment struct {
+	TypeCondition string
+	Directives    []*Directive
+	SelectionSet  []Selection
+}
+
+// Directive annotates a selection
+type Directive struct {
+	Name      string
+	Arguments map[string]Value
+}
+
+func (f *Field) directives() []*Directive          { return f.Directives }
+func (f *FragmentSpread) directives() []*Directive { return f.Directives }
+func (f *InlineFragment) directives() []*Directive { return f.Directives }
+
+// ResponseKey is the key of the field in the response
+func (f *Field) ResponseKey() string {
+	if f.Alias != "" {
+		return f.Alias
+	}
+	return f.Name
+}
+
+// Value is a literal or variable in a query. Variable is a reference to an
+// operation variable, List is []Value and Object is map[string]Value; the
+// other values are string, int64, float64, bool, Enum or nil.
+type Value interface{}
+
+// Variable references an operation variable by name
+type Variable string
+
+// Enum is an enum literal
+type Enum string
+
+// token kinds
+const (
+	tokenEOF = iota
+	tokenPunct
+	tokenName
+	tokenInt
+	tokenFloat
+	tokenString
+)
+
+type token struct {
+	kind  int
+	value string
+	line  int
+}
+
+type parser struct {
+	src  string
+	pos  int
+	line int
+	tok  token
+}
+
+// Parse parses an executable GraphQL document
+func Parse(src string) (doc *Document, err error) {
+	p := &parser{src: src, line: 1}
+	defer func() {
+		if r := recover(); r != nil {
+			syntax, ok := r.(syntaxError)
+			if !ok {
+				panic(r)
+			}
+			doc, err = nil, syntax
+		}
+	}()
+
+	p.next()
+	doc = &Document{Fragments: make(map[string]*Fragment)}
+	for p.tok.kind != tokenEOF {
+		if p.peekPunct("{") {
+			doc.Operations = append(doc.Operations, &Operation{Kind: "query", SelectionSet: p.selectionSet()})
+			continue
+		}
+		switch keyword := p.name(); keyword {
+		case "query", "mutation", "subscription":
+			doc.Operations = append(doc.Operations, p.operation(keyword))
+		case "fragment":
+			fragment := p.fragment()
+			if _, exists := doc.Fragments[fragment.Name]; exists {
+				p.fail("fragment %q is defined more than once", fragment.Name)
+			}
+			doc.Fragments[fragment.Name] = fragment
+		default:
+			p.fail("unexpected %q", keyword)
+		}
+	}
+	if len(doc.Operations) == 0 {
+		return nil, syntaxError{line: p.line, msg: "document has no operation"}
+	}
+	return doc, nil
+}
+
+// syntaxError reports a malformed document
+type syntaxError struct {
+	line int
+	msg  string
+}
+
+func (e syntaxError) Error() string {
+	return fmt.Sprintf("syntax error on line %d: %s", e.line, e.msg)
+}
+
+func (p *parser) fail(format string, args ...interface{}) {
+	panic(syntaxError{line: p.tok.line, msg: fmt.Sprintf(format, args...)})
+}
+
+func (p *parser) operation(kind string) *Operation {
+	op := &Operation{Kind: kind}
+	if p.tok.kind == tokenName {
+		op.Name = p.name()
+	}
+	if p.skipPunct("(") {
+		for !p.skipPunct(")") {
+			p.expectPunct("$")
+			def := &VariableDefinition{Name: p.name()}
+			p.expectPunct(":")
+			def.Type = p.typeRef()
+			if p.skipPunct("=") {
+				def.Default = p.value(true)
+			}
+			op.Variables = append(op.Variables, def)
+		}
+	}
+	p.directives()
+	op.SelectionSet = p.selectionSet()
+	return op
+}
+
+func (p *parser) fragment() *Fragment {
+	fragment := &Fragment{Name: p.name()}
+	if fragment.Name == "on" {
+		p.fail("fragment cannot be named \"on\"")
+	}
+	if p.name() != "on" {
+		p.fail("expected \"on\"")
+	}
+	fragment.TypeCondition = p.name()
+	p.directives()
+	fragment.SelectionSet = p.selectionSet()
+	return fragment
+}
+
+// typeRef reads a type reference such as [ID!]! as written
+func (p *parser) typeRef() string {
+	var ref string
+	if p.skipPunct("[") {
+		ref = "[" + p.typeRef() + "]"
+		p.expectPunct("]")
+	} else {
+		ref = p.name()
+	}
+	if p.skipPunct("!") {
+		ref += "!"
+	}
+	return ref
+}
+
+func (p *parser) selectionSet() []Selection {
+	p.expectPunct("{")
+	var selections []Selection
+	for !p.skipPunct("}") {
+		if p.tok.kind == tokenEOF {
+			p.fail("unterminated selection set")
+		}
+		if p.skipPunct("...") {
+			if p.tok.kind == tokenName && p.tok.value != "on" {
+				selections = append(selections, &FragmentSpread{Name: p.name(), Directives: p.directives()})
+				continue
+			}
+			inline := &InlineFragment{}
+			if p.tok.kind == tokenName {
+				p.name()
+				inline.TypeCondition = p.name()
+			}
+			inline.Directives = p.directives()
+			inline.SelectionSet = p.selectionSet()
+			selections = append(selections, inline)
+			continue
+		}
+		selections = append(selections, p.field())
+	}
+	if len(selections) == 0 {
+		p.fail("empty selection set")
+	}
+	return selections
+}
+
+func (p *parser) field() *Field {
+	field := &Field{Line: p.tok.line, Name: p.name()}
+	if p.skipPunct(":") {
+		field.Alias, field.Name = field.Name, p.name()
+	}
+	field.Arguments = p.arguments(false)
+	field.Directives = p.directives()
+	if p.peekPunct("{") {
+		field.SelectionSet = p.selectionSet()
+	}
+	return field
+}
+
+func (p *parser) arguments(constant bool) map[string]Value {
+	if !p.skipPunct("(") {
+		return nil
+	}
+	args := make(map[string]Value)
+	for !p.skipPunct(")") {
+		name := p.name()
+		p.expectPunct(":")
+		if _, exists := args[name]; exists {
+			p.fail("argument %q is given more than once", name)
+		}
+		args[name] = p.value(constant)
+	}
+	return args
+}
+
+func (p *parser) directives() []*Directive {
+	var directives []*Directive
+	for p.skipPunct("@") {
+		directives = append(directives, &Directive{Name: p.name(), Arguments: p.arguments(false)})
+	}
+	return directives
+}
+
+func (p *parser) value(constant bool) Value {
+	tok := p.tok
+	switch tok.kind {
+	case tokenPunct:
+		switch tok.value {
+		case "$":
+			if constant {
+				p.fail("variables are not allowed in default values")
+			}
+			p.next()
+			return Variable(p.name())
+		case "[":
+			p.next()
+			list := []Value{}
+			for !p.skipPunct("]") {
+				list = append(list, p.value(constant))
+			}
+			return list
+		case "{":
+			p.next()
+			object := make(map[string]Value)
+			for !p.skipPunct("}") {
+				name := p.name()
+				p.expectPunct(":")
+				object[name] = p.value(constant)
+			}
+			return object
+		}
+	case tokenInt:
+		p.next()
+		n, err := strconv.ParseInt(tok.value, 10, 64)
+		if err != nil {
+			p.fail("invalid integer %s", tok.value)
+		}
+		return n
+	case tokenFloat:
+		p.next()
+		f, _ := strconv.ParseFloat(tok.value, 64)
+		return f
+	case tokenString:
+		p.next()
+		return tok.value
+	case tokenName:
+		p.next()
+		switch tok.value {
+		case "true":
+			return true
+		case "false":
+			return false
+		case "null":
+			return nil
+		}
+		return Enum(tok.value)
+	}
+	p.fail("unexpected %q", tok.value)
+	return nil
+}
+
+func (p *parser) name() string {
+	if p.tok.kind != tokenName {
+		p.fail("expected a name, got %q", p.tok.value)
+	}
+	name := p.tok.value
+	p.next()
+	return name
+}
+
+func (p *parser) peekPunct(punct string) bool {
+	return p.tok.kind == tokenPunct && p.tok.value == punct
+}
+
+func (p *parser) skipPunct(punct string) bool {
+	if p.peekPunct(punct) {
+		p.next()
+		return true
+	}
+	return false
+}
+
+func (p *parser) expectPunct(punct string) {
+	if !p.skipPunct(punct) {
+		p.fail("expected %q, got %q", punct, p.tok.value)
+	}
+}
+
+// next reads the following token, skipping whitespace, commas and comments
+func (p *parser) next() {
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		if c == '\n' {
+			p.line++
+		}
+		if c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == ',' {
+			p.pos++
+			continue
+		}
+		if c == '#' {
+			for p.pos < len(p.src) && p.src[p.pos] != '\n' {
+				p.pos++
+			}
+			continue
+		}
+		break
+	}
+	p.tok = token{line: p.line}
+	if p.pos >= len(p.src) {
+		p.tok.kind = tokenEOF
+		p.tok.value = "<EOF>"
+		return
+	}
+
+	start := p.pos
+	c := p.src[p.pos]
+	switch {
+	case strings.HasPrefix(p.src[p.pos:], "..."):
+		p.pos += 3
+		p.tok.kind, p.tok.value = tokenPunct, "..."
+	case strings.IndexByte("!$()[]{}:=@|&", c) >= 0:
+		p.pos++
+		p.tok.kind, p.tok.value = tokenPunct, string(c)
+	case c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+		for p.pos < len(p.src) && isNameChar(p.src[p.pos]) {
+			p.pos++
+		}
+		p.tok.kind, p.tok.value = tokenName, p.src[start:p.pos]
+	case c == '-' || c >= '0' && c <= '9':
+		p.number()
+	case c == '"':
+		p.string()
+	default:
+		r, _ := utf8.DecodeRuneInString(p.src[p.pos:])
+		p.fail("unexpected character %q", r)
+	}
+}
+
+func isNameChar(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+func (p *parser) number() {
+	start := p.pos
+	p.tok.kind = tokenInt
+	if p.src[p.pos] == '-' {
+		p.pos++
+	}
+	digits := func() {
+		begin := p.pos
+		for p.pos < len(p.src) && p.src[p.pos] >= '0' && p.src[p.pos] <= '9' {
+			p.pos++
+		}
+		if p.pos == begin {
+			p.fail("invalid number %q", p.src[start:p.pos])
+		}
+	}
+	digits()
+	if p.pos < len(p.src) && p.src[p.pos] == '.' {
+		p.tok.kind = tokenFloat
+		p.pos++
+		digits()
+	}
+	if p.pos < len(p.src) && (p.src[p.pos] == 'e' || p.src[p.pos] == 'E') {
+		p.tok.kind = tokenFloat
+		p.pos++
+		if p.pos < len(p.src) && (p.src[p.pos] == '+' || p.src[p.pos] == '-') {
+			p.pos++
+		}
+		digits()
+	}
+	p.tok.value = p.src[start:p.pos]
+}
+
+func (p *parser) string() {
+	p.tok.kind = tokenString
+	if strings.HasPrefix(p.src[p.pos:], `"""`) {
+		end := strings.Index(p.src[p.pos+3:], `"""`)
+		if end < 0 {
+			p.fail("unterminated block string")
+		}
+		raw := p.src[p.pos+3 : p.pos+3+end]
+		p.line += strings.Count(raw, "\n")
+		p.pos += end + 6
+		p.tok.value = strings.TrimSpace(strings.ReplaceAll(raw, `\"""`, `"""`))
+		return
+	}
+
+	var b strings.Builder
+	p.pos++
+	for {
+		if p.pos >= len(p.src) || p.src[p.pos] == '\n' {
+			p.fail("unterminated string")
+		}
+		c := p.src[p.pos]
+		if c == '"' {
+			p.pos++
+			break
+		}
+		if c != '\\' {
+			b.WriteByte(c)
+			p.pos++
+			continue
+		}
+		if p.pos+1 >= len(p.src) {
+			p.fail("unterminated string")
+		}
+		escape := p.src[p.pos+1]
+		p.pos += 2
+		switch escape {
+		case '"', '\\', '/':
+			b.WriteByte(escape)
+		case 'b':
+			b.WriteByte('\b')
+		case 'f':
+			b.WriteByte('\f')
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 't':
+			b.WriteByte('\t')
+		case 'u':
+			if p.pos+4 > len(p.src) {
+				p.fail("invalid unicode escape")
+			}
+			code, err := strconv.ParseUint(p.src[p.pos:p.pos+4], 16, 32)
+			if err != nil {
+				p.fail("invalid unicode escape")
+			}
+			b.WriteRune(rune(code))
+			p.pos += 4
+		default:
+			p.fail("invalid escape \\%c", escape)
+		}
+	}
+	p.tok.value = b.String()
+}
@@ -0,0 +1,289 @@
+package graphql
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Type is a *Scalar, *Object, *List or *NonNull
+type Type interface {
+	String() string
+}
+
+// Scalar is a leaf type. Serialize converts a resolved value to its JSON
+// output, reporting false when the value can't be represented; Parse coerces
+// an argument value.
+type Scalar struct {
+	Name        string
+	Description string
+	Serialize   func(value interface{}) (interface{}, bool)
+	Parse       func(value interface{}) (interface{}, bool)
+}
+
+// Object is a type with fields
+type Object struct {
+	Name        string
+	Description string
+	Fields      map[string]*FieldDef
+}
+
+// List is a list of another type
+type List struct {
+	Of Type
+}
+
+// NonNull is a type whose values can't be null
+type NonNull struct {
+	Of Type
+}
+
+func (s *Scalar) String() string  { return s.Name }
+func (o *Object) String() string  { return o.Name }
+func (l *List) String() string    { return "[" + l.Of.String() + "]" }
+func (n *NonNull) String() string { return n.Of.String() + "!" }
+
+// FieldDef defines a field of an object. Fields without Resolve read the key
+// of the same name from a map[string]interface{} source, or Key when set.
+type FieldDef struct {
+	Type        Type
+	Description string
+	Args        map[string]*ArgDef
+	Key         string
+	Resolve     ResolveFunc
+}
+
+// ArgDef defines a field argument; Default applies when it's not given
+type ArgDef struct {
+	Type    Type
+	Default interface{}
+}
+
+// ResolveFunc resolves a field. It may return a Thunk to defer the value
+// until the loader has dispatched the calls queued by the current level.
+type ResolveFunc func(p ResolveParams) (interface{}, error)
+
+// ResolveParams are the inputs of a resolver
+type ResolveParams struct {
+	Context context.Context
+	Source  interface{}
+	Args    map[string]interface{}
+	Loader  *Loader
+}
+
+// Thunk is a deferred field value
+type Thunk func() (interface{}, error)
+
+// Schema is the root query type and the types reachable from it
+type Schema struct {
+	Query *Object
+}
+
+// Built-in scalars
+var (
+	String = &Scalar{
+		Name:      "String",
+		Serialize: serializeString,
+		Parse:     parseString,
+	}
+	ID = &Scalar{
+		Name:        "ID",
+		Description: "Opaque identifier, serialized as a string",
+		Serialize:   serializeString,
+		Parse: func(value interface{}) (interface{}, bool) {
+			if n, ok := value.(int64); ok {
+				return strconv.FormatInt(n, 10), true
+			}
+			return parseString(value)
+		},
+	}
+	Int = &Scalar{
+		Name: "Int",
+		Serialize: func(value interface{}) (interface{}, bool) {
+			switch v := value.(type) {
+			case int:
+				return int64(v), true
+			case int64:
+				return v, true
+			case float64:
+				if v == math.Trunc(v) && math.Abs(v) <= math.MaxInt32 {
+					return int64(v), true
+				}
+			case json.Number:
+				n, err := v.Int64()
+				return n, err == nil
+			}
+			return nil, false
+		},
+		Parse: func(value interface{}) (interface{}, bool) {
+			switch v := value.(type) {
+			case int64:
+				return v, v >= math.MinInt32 && v <= math.MaxInt32
+			case float64:
+				return int64(v), v == math.Trunc(v) && math.Abs(v) <= math.MaxInt32
+			}
+			return nil, false
+		},
+	}
+	Float = &Scalar{
+		Name: "Float",
+		Serialize: func(value interface{}) (interface{}, bool) {
+			switch v := value.(type) {
+			case float64:
+				return v, true
+			case int64:
+				return float64(v), true
+			case int:
+				return float64(v), true
+			}
+			return nil, false
+		},
+		Parse: func(value interface{}) (interface{}, bool) {
+			switch v := value.(type) {
+			case float64:
+				return v, true
+			case int64:
+				return float64(v), true
+			}
+			return nil, false
+		},
+	}
+	Boolean = &Scalar{
+		Name: "Boolean",
+		Serialize: func(value interface{}) (interface{}, bool) {
+			b, ok := value.(bool)
+			return b, ok
+		},
+		Parse: func(value interface{}) (interface{}, bool) {
+			b, ok := value.(bool)
+			return b, ok
+		},
+	}
+)
+
+func serializeString(value interface{}) (interface{}, bool) {
+	switch v := value.(type) {
+	case string:
+		return v, true
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	case int64:
+		return strconv.FormatInt(v, 10), true
+	case bool:
+		return strconv.FormatBool(v), true
+	}
+	return nil, false
+}
+
+func parseString(value interface{}) (interface{}, bool) {
+	s, ok := value.(string)
+	return s, ok
+}
+
+// SDL prints the schema in the schema definition language
+func (s *Schema) SDL() string {
+	objects := make(map[string]*Object)
+	scalars := make(map[string]*Scalar)
+	var walk func(t Type)
+	walk = func(t Type) {
+		switch t := t.(type) {
+		case *List:
+			walk(t.Of)
+		case *NonNull:
+			walk(t.Of)
+		case *Scalar:
+			scalars[t.Name] = t
+		case *Object:
+			if objects[t.Name] != nil {
+				return
+			}
+			objects[t.Name] = t
+			for _, field := range t.Fields {
+				walk(field.Type)
+				for _, arg := range field.Args {
+					walk(arg.Type)
+				}
+			}
+		}
+	}
+	walk(s.Query)
+
+	var b strings.Builder
+	names := make([]string, 0, len(scalars))
+	for name := range scalars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if builtinScalar(name) {
+			continue
+		}
+		writeDescription(&b, "", scalars[name].Description)
+		fmt.Fprintf(&b, "scalar %s\n\n", name)
+	}
+
+	names = names[:0]
+	for name := range objects {
+		if name != s.Query.Name {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range append([]string{s.Query.Name}, names...) {
+		object := objects[name]
+		writeDescription(&b, "", object.Description)
+		fmt.Fprintf(&b, "type %s {\n", name)
+		for _, fieldName := range sortedFields(object) {
+			field := object.Fields[fieldName]
+			writeDescription(&b, "  ", field.Description)
+			b.WriteString("  " + fieldName)
+			if len(field.Args) > 0 {
+				argNames := make([]string, 0, len(field.Args))
+				for argName := range field.Args {
+					argNames = append(argNames, argName)
+				}
+				sort.Strings(argNames)
+				args := make([]string, len(argNames))
+				for i, argName := range argNames {
+					arg := field.Args[argName]
+					args[i] = argName + ": " + arg.Type.String()
+					if arg.Default != nil {
+						def, _ := json.Marshal(arg.Default)
+						args[i] += " = " + string(def)
+					}
+				}
+				b.WriteString("(" + strings.Join(args, ", ") + ")")
+			}
+			b.WriteString(": " + field.Type.String() + "\n")
+		}
+		b.WriteString("}\n\n")
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+func builtinScalar(name string) bool {
+	switch name {
+	case "String", "ID", "Int", "Float", "Boolean":
+		return true
+	}
+	return false
+}
+
+func writeDescription(b *strings.Builder, indent, description string) {
+	if description != "" {
+		fmt.Fprintf(b, "%s%q\n", indent, description)
+	}
+}
+
+func sortedFields(object *Object) []string {
+	names := make([]string, 0, len(object.Fields))
+	for name := range object.Fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
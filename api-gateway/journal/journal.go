@@ -0,0 +1,370 @@
+// Package journal runs multi-step gateway orchestrations (sagas) that must
+// not be left half done. Every step's outcome is journaled in Redis before
+// the next one starts, together with a lease held by the replica running the
+// saga. When a replica crashes its lease runs out, and a recovery worker on
+// any replica picks the saga up: it resumes from the interrupted step when
+// that step can be retried, and otherwise compensates the completed steps in
+// reverse order. Redis should persist with AOF (appendfsync everysec or
+// always) for the journal to survive a Redis restart.
+package journal
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/YeonwooSung/instagram/api-gateway/metrics"
+	"github.com/YeonwooSung/instagram/api-gateway/state"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+const (
+	// activeKey indexes unfinished runs by lease expiry
+	activeKey = "gateway:journal:active"
+
+	// runKeyPrefix prefixes the journaled state of a run
+	runKeyPrefix = "gateway:journal:run:"
+
+	// recoveryBatch caps the expired runs claimed per recovery pass
+	recoveryBatch = 10
+
+	// compensationTimeout bounds compensating a failed run after its request ended
+	compensationTimeout = time.Minute
+)
+
+// runSchema versions Run as journaled in Redis
+var runSchema = state.NewSchema("journal_run", 1)
+
+// ErrUnavailable is returned by Execute when the journal can't be written,
+// before any step has run
+var ErrUnavailable = errors.New("journal unavailable")
+
+// Step is one step of a saga
+type Step struct {
+	Name string
+
+	// Do performs the step. It may record partial progress in run.State and
+	// Checkpoint it, so Undo knows what to clean up if Do fails midway.
+	Do func(ctx context.Context, run *Run) error
+
+	// Undo compensates the step, completed or partially done. It must be
+	// idempotent: it may run again after a crash. Nil when there is nothing
+	// to undo.
+	Undo func(ctx context.Context, run *Run) error
+
+	// Resumable steps are retried when recovering from a crash; the run is
+	// compensated instead when it stopped at a step that is not. A step is
+	// resumable when it is idempotent and needs nothing beyond run.State.
+	Resumable bool
+}
+
+// Saga is a named sequence of steps
+type Saga struct {
+	Name  string
+	Steps []Step
+}
+
+// Run is one execution of a saga
+type Run struct {
+	ID   string `json:"id"`
+	Saga string `json:"saga"`
+
+	// State is journaled with every step, and is all a recovered run has
+	State map[string]string `json:"state"`
+
+	// Step is the index of the step in progress; while compensating, of the
+	// next step to undo
+	Step         int  `json:"step"`
+	Compensating bool `json:"compensating,omitempty"`
+
+	Recoveries int       `json:"recoveries,omitempty"`
+	Lease      time.Time `json:"lease"`
+	StartedAt  time.Time `json:"started_at"`
+
+	// Input is handed to the steps of a run started by Execute, e.g. request
+	// bodies too large to journal. It is nil in recovered runs.
+	Input interface{} `json:"-"`
+
+	journal *Journal
+}
+
+// Checkpoint journals the run's current state, renewing its lease
+func (r *Run) Checkpoint(ctx context.Context) error {
+	return r.journal.save(ctx, r)
+}
+
+// Options configures the journal
+type Options struct {
+	// Lease is how long a run may go without a checkpoint before it is
+	// considered abandoned by a crashed replica; it must exceed the longest step
+	Lease time.Duration
+
+	// MaxRecoveries is how often a run is recovered before it is given up
+	// on and left for an operator
+	MaxRecoveries int
+}
+
+// Journal executes registered sagas and recovers interrupted ones
+type Journal struct {
+	redis  *redis.Client
+	opts   Options
+	sagas  map[string]Saga
+	logger *zap.Logger
+}
+
+// New creates a journal. Sagas must be registered before Recover is called.
+func New(redisClient *redis.Client, opts Options, logger *zap.Logger) *Journal {
+	return &Journal{
+		redis:  redisClient,
+		opts:   opts,
+		sagas:  make(map[string]Saga),
+		logger: logger,
+	}
+}
+
+// Register makes a saga available to Execute and recovery
+func (j *Journal) Register(saga Saga) {
+	j.sagas[saga.Name] = saga
+}
+
+// Execute runs a saga to completion. If a step fails, the completed steps are
+// compensated and the step's error returned; the returned run's State holds
+// what the steps recorded either way. Compensation outlives ctx, and what it
+// can't finish is left to recovery.
+func (j *Journal) Execute(ctx context.Context, name string, initial map[string]string, input interface{}) (*Run, error) {
+	saga, ok := j.sagas[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown saga %q", name)
+	}
+
+	run := &Run{
+		ID:        newRunID(),
+		Saga:      name,
+		State:     initial,
+		StartedAt: time.Now(),
+		Input:     input,
+		journal:   j,
+	}
+	if run.State == nil {
+		run.State = make(map[string]string)
+	}
+	if err := j.save(ctx, run); err != nil {
+		j.logger.Warn("Failed to journal saga", zap.String("saga", name), zap.Error(err))
+		return nil, ErrUnavailable
+	}
+
+	if err := j.proceed(ctx, saga, run); err != nil {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), compensationTimeout)
+		defer cancel()
+		if cerr := j.compensate(ctx, saga, run); cerr != nil {
+			j.logger.Warn("Saga compensation incomplete, left to recovery",
+				zap.String("saga", name),
+				zap.String("run_id", run.ID),
+				zap.Error(cerr),
+			)
+			metrics.Inc("gateway_journal_runs_total", "saga", name, "result", "compensation_pending")
+			return run, err
+		}
+		metrics.Inc("gateway_journal_runs_total", "saga", name, "result", "compensated")
+		return run, err
+	}
+	metrics.Inc("gateway_journal_runs_total", "saga", name, "result", "completed")
+	return run, nil
+}
+
+// proceed runs the remaining steps, journaling each completed one, and
+// forgets the run once all are done
+func (j *Journal) proceed(ctx context.Context, saga Saga, run *Run) error {
+	for run.Step < len(saga.Steps) {
+		step := saga.Steps[run.Step]
+		if err := step.Do(ctx, run); err != nil {
+			return fmt.Errorf("%s: %w", step.Name, err)
+		}
+		run.Step++
+		if run.Step < len(saga.Steps) {
+			if err := j.save(ctx, run); err != nil {
+				// Going on unjournaled would leave a crash unrecoverable
+				return fmt.Errorf("journal: %w", err)
+			}
+		}
+	}
+	j.forget(ctx, run)
+	return nil
+}
+
+// compensate undoes the run's steps in reverse, from the one it stopped at
+func (j *Journal) compensate(ctx context.Context, saga Saga, run *Run) error {
+	run.Compensating = true
+	run.Step = min(run.Step, len(saga.Steps)-1)
+	for run.Step >= 0 {
+		if err := j.save(ctx, run); err != nil {
+			return err
+		}
+		step := saga.Steps[run.Step]
+		if step.Undo != nil {
+			if err := step.Undo(ctx, run); err != nil {
+				return fmt.Errorf("%s: %w", step.Name, err)
+			}
+		}
+		run.Step--
+	}
+	j.forget(ctx, run)
+	return nil
+}
+
+// save journals the run and renews its lease in one transaction
+func (j *Journal) save(ctx context.Context, run *Run) error {
+	run.Lease = time.Now().Add(j.opts.Lease)
+	data, err := runSchema.Marshal(run)
+	if err != nil {
+		return err
+	}
+	pipe := j.redis.TxPipeline()
+	pipe.Set(ctx, runKeyPrefix+run.ID, data, 0)
+	pipe.ZAdd(ctx, activeKey, redis.Z{Score: float64(run.Lease.UnixMilli()), Member: run.ID})
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// forget drops a finished run from the journal. A failure only costs a
+// recovery pass finding nothing left to do.
+func (j *Journal) forget(ctx context.Context, run *Run) {
+	pipe := j.redis.TxPipeline()
+	pipe.Del(ctx, runKeyPrefix+run.ID)
+	pipe.ZRem(ctx, activeKey, run.ID)
+	if _, err := pipe.Exec(ctx); err != nil {
+		j.logger.Warn("Failed to clear finished saga", zap.String("run_id", run.ID), zap.Error(err))
+	}
+}
+
+// Recover resumes or compensates runs whose lease expired, until ctx is
+// cancelled. Every replica runs it; each expired run is claimed by one.
+func (j *Journal) Recover(ctx context.Context) {
+	ticker := time.NewTicker(max(j.opts.Lease/4, time.Second))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		expired, err := j.redis.ZRangeByScore(ctx, activeKey, &redis.ZRangeBy{
+			Min:   "-inf",
+			Max:   strconv.FormatInt(time.Now().UnixMilli(), 10),
+			Count: recoveryBatch,
+		}).Result()
+		if err != nil {
+			if !errors.Is(err, context.Canceled) {
+				j.logger.Warn("Failed to list expired sagas", zap.Error(err))
+			}
+			continue
+		}
+		for _, id := range expired {
+			run, err := j.claim(ctx, id)
+			if err != nil || run == nil {
+				continue
+			}
+			j.recover(ctx, run)
+		}
+	}
+}
+
+// claim takes over an expired run. It returns nil when the run finished or
+// another replica claimed or renewed it first.
+func (j *Journal) claim(ctx context.Context, id string) (*Run, error) {
+	key := runKeyPrefix + id
+	var run *Run
+	err := j.redis.Watch(ctx, func(tx *redis.Tx) error {
+		data, err := tx.Get(ctx, key).Bytes()
+		if errors.Is(err, redis.Nil) {
+			tx.ZRem(ctx, activeKey, id)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var stored Run
+		if err := runSchema.Unmarshal(data, &stored); err != nil {
+			j.logger.Error("Dropping unreadable saga journal entry", zap.String("run_id", id), zap.Error(err))
+			tx.ZRem(ctx, activeKey, id)
+			return nil
+		}
+		if time.Now().Before(stored.Lease) {
+			return nil
+		}
+
+		stored.journal = j
+		stored.Recoveries++
+		stored.Lease = time.Now().Add(j.opts.Lease)
+		data, err = runSchema.Marshal(&stored)
+		if err != nil {
+			return err
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, key, data, 0)
+			pipe.ZAdd(ctx, activeKey, redis.Z{Score: float64(stored.Lease.UnixMilli()), Member: id})
+			return nil
+		})
+		if err == nil {
+			run = &stored
+		}
+		return err
+	}, key)
+	if errors.Is(err, redis.TxFailedErr) {
+		return nil, nil
+	}
+	return run, err
+}
+
+// recover finishes a claimed run: forward if it stopped at a resumable step,
+// backward otherwise
+func (j *Journal) recover(ctx context.Context, run *Run) {
+	logger := j.logger.With(
+		zap.String("saga", run.Saga),
+		zap.String("run_id", run.ID),
+		zap.Int("step", run.Step),
+		zap.Int("recoveries", run.Recoveries),
+	)
+
+	saga, ok := j.sagas[run.Saga]
+	if !ok || run.Recoveries > j.opts.MaxRecoveries {
+		// Out of the index, so it is not retried; the state stays for an operator
+		logger.Error("Giving up on interrupted saga; its journaled state needs manual cleanup")
+		metrics.Inc("gateway_journal_recoveries_total", "saga", run.Saga, "result", "abandoned")
+		j.redis.ZRem(ctx, activeKey, run.ID)
+		return
+	}
+
+	if !run.Compensating && run.Step < len(saga.Steps) && saga.Steps[run.Step].Resumable {
+		err := j.proceed(ctx, saga, run)
+		if err == nil {
+			logger.Info("Resumed interrupted saga")
+			metrics.Inc("gateway_journal_recoveries_total", "saga", run.Saga, "result", "resumed")
+			return
+		}
+		logger.Warn("Resuming interrupted saga failed, compensating", zap.Error(err))
+	}
+
+	if err := j.compensate(ctx, saga, run); err != nil {
+		// The lease runs out again and a later pass retries
+		logger.Warn("Compensating interrupted saga failed", zap.Error(err))
+		metrics.Inc("gateway_journal_recoveries_total", "saga", run.Saga, "result", "failed")
+		return
+	}
+	logger.Info("Compensated interrupted saga")
+	metrics.Inc("gateway_journal_recoveries_total", "saga", run.Saga, "result", "compensated")
+}
+
+func newRunID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
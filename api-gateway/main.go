@@ -2,18 +2,41 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
+	"github.com/YeonwooSung/instagram/api-gateway/auditlog"
+	"github.com/YeonwooSung/instagram/api-gateway/cache"
+	"github.com/YeonwooSung/instagram/api-gateway/certs"
 	"github.com/YeonwooSung/instagram/api-gateway/config"
+	"github.com/YeonwooSung/instagram/api-gateway/diagnostics"
+	"github.com/YeonwooSung/instagram/api-gateway/discovery"
+	"github.com/YeonwooSung/instagram/api-gateway/egress"
+	"github.com/YeonwooSung/instagram/api-gateway/events"
+	"github.com/YeonwooSung/instagram/api-gateway/metrics"
 	"github.com/YeonwooSung/instagram/api-gateway/middleware"
+	"github.com/YeonwooSung/instagram/api-gateway/outbox"
+	"github.com/YeonwooSung/instagram/api-gateway/proxy"
+	"github.com/YeonwooSung/instagram/api-gateway/push"
+	"github.com/YeonwooSung/instagram/api-gateway/redact"
 	"github.com/YeonwooSung/instagram/api-gateway/router"
+	"github.com/YeonwooSung/instagram/api-gateway/settings"
+	"github.com/YeonwooSung/instagram/api-gateway/tenants"
+	"github.com/YeonwooSung/instagram/api-gateway/version"
+	"github.com/YeonwooSung/instagram/api-gateway/webhooks"
 	"github.com/gin-gonic/gin"
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+	"github.com/redis/go-redis/v9"
 	"go.uber.org/zap"
 )
 
@@ -31,6 +54,18 @@ func main() {
 	}
 	defer logger.Sync()
 
+	// Mask personal data and credentials in everything logged
+	if cfg.LogRedaction == "on" {
+		scrubber, err := redact.New(cfg)
+		if err != nil {
+			log.Fatalf("Failed to initialize log redaction: %v", err)
+		}
+		logger = logger.WithOptions(zap.WrapCore(scrubber.Wrap))
+	}
+
+	// Tag every log line with the config generation
+	logger = logger.With(zap.String("config_hash", cfg.Fingerprint()))
+
 	// Set Gin mode
 	if cfg.Environment == "production" {
 		gin.SetMode(gin.ReleaseMode)
@@ -39,24 +74,339 @@ func main() {
 	// Create Gin router
 	r := gin.New()
 
-	// Global middleware
+	// Global middleware; error responses are normalized outside Recovery so
+	// panics are reported as problem details too
+	r.Use(middleware.RequestID())
+	if cfg.ErrorFormat == "problem" {
+		upstreams := cfg.ServiceURLs()
+		mappings := make([]middleware.ErrorMapping, len(cfg.ErrorMappings))
+		for i, mapping := range cfg.ErrorMappings {
+			mappings[i] = middleware.ErrorMapping{
+				Upstream: upstreams[mapping.Upstream],
+				Status:   mapping.Status,
+				Match:    mapping.Match,
+				Code:     mapping.Code,
+				Title:    mapping.Title,
+				Detail:   mapping.Detail,
+			}
+		}
+		r.Use(middleware.Problems(middleware.ProblemOptions{
+			TypeBase: cfg.ProblemTypeBase,
+			Mappings: mappings,
+		}))
+	}
 	r.Use(gin.Recovery())
 	r.Use(middleware.Logger(logger))
+	r.Use(middleware.SlowRequests(cfg.SlowRequestThreshold, cfg.LargeResponseThreshold, logger))
+	if cfg.ServerTiming == "on" {
+		r.Use(middleware.ServerTiming(cfg.CurrentAdminToken))
+	}
+	if cfg.HTTP3Port > 0 {
+		r.Use(middleware.AltSvc(cfg.HTTP3Port, cfg.HTTP3AltSvcMaxAge))
+	}
 	r.Use(middleware.CORS())
+	if len(cfg.TranscodeFormats) > 0 {
+		r.Use(middleware.Transcode(cfg.TranscodeFormats))
+	}
+	if cfg.FieldFiltering == "on" {
+		r.Use(middleware.FilterFields())
+	}
+
+	// Background workers are stopped when this context is cancelled
+	bgCtx, stopBackground := context.WithCancel(context.Background())
+	defer stopBackground()
+
+	// Initialize Redis client for shared gateway state
+	redisClient := redis.NewClient(&redis.Options{
+		Addr: cfg.RedisAddr,
+		DB:   cfg.RedisDB,
+		// Read on every new connection so rotated passwords apply without a restart
+		CredentialsProvider: func() (string, string) {
+			return "", cfg.CurrentRedisPassword()
+		},
+	})
+	defer redisClient.Close()
 
-	// Health check endpoint
-	r.GET("/health", func(c *gin.Context) {
+	// Redis-backed features degrade per their policy while Redis is unreachable
+	redisPolicies := make(map[string]middleware.RedisMode, len(cfg.RedisPolicies))
+	for feature, mode := range cfg.RedisPolicies {
+		redisPolicies[feature] = middleware.RedisMode(mode)
+	}
+	redisHealth := middleware.NewRedisHealth(redisClient, redisPolicies, logger)
+	go redisHealth.Watch(bgCtx, cfg.RedisCheckInterval)
+
+	// Health check endpoint; the gateway stays live without Redis, degraded
+	health := func(c *gin.Context) {
+		redisStatus := "up"
+		if !redisHealth.Up() {
+			redisStatus = "down"
+		}
 		c.JSON(http.StatusOK, gin.H{
 			"status": "healthy",
+			"redis":  redisStatus,
 			"time":   time.Now().Format(time.RFC3339),
 		})
+	}
+	r.GET("/health", health)
+
+	// Management endpoints (metrics, health, admin API) are served on their
+	// own listener bound to an internal interface when ADMIN_ADDR is set
+	var management *gin.Engine
+	if cfg.AdminAddr != "" {
+		management = gin.New()
+		management.Use(middleware.RequestID())
+		management.Use(gin.Recovery())
+		management.Use(middleware.Logger(logger))
+		management.GET("/healthz", health)
+		management.GET("/metrics", metrics.Handler())
+	} else {
+		r.GET("/healthz", health)
+		r.GET("/metrics", metrics.Handler())
+	}
+
+	// Admin and security-sensitive operations go to the tamper-evident audit
+	// log, starting with this instance's configuration if it changed
+	auditLog := auditlog.New(redisClient, logger)
+	hostname, _ := os.Hostname()
+	auditCtx, cancelAudit := context.WithTimeout(bgCtx, 2*time.Second)
+	auditLog.RecordConfig(auditCtx, cfg.Fingerprint(), map[string]string{
+		"environment": cfg.Environment,
+		"commit":      version.Get().Commit,
 	})
+	cancelAudit()
+
+	// Periodically pick up rotated secrets
+	go cfg.Secrets.Watch(bgCtx, cfg.SecretsRefresh,
+		func(changed []string) {
+			logger.Info("Secrets rotated", zap.Strings("secrets", changed))
+			for _, name := range changed {
+				auditLog.Record(bgCtx, auditlog.Entry{
+					Category: auditlog.CategoryKey,
+					Action:   "key.rotated",
+					Actor:    "secrets_provider",
+					Target:   name,
+					Details:  map[string]string{"instance": hostname},
+				})
+			}
+		},
+		func(err error) {
+			logger.Warn("Failed to refresh secrets", zap.Error(err))
+		},
+	)
+
+	// Initialize response cache; per-user entries are encrypted at rest
+	var cacheCipher *cache.Cipher
+	if cfg.CacheEncryptionKeys != "" {
+		if cacheCipher, err = cache.NewCipher(cfg.CacheEncryptionKeys); err != nil {
+			logger.Fatal("Invalid cache encryption keys", zap.Error(err))
+		}
+	}
+	cacheStore := cache.NewStore(redisClient, cacheCipher)
+
+	// Pagination cursors are sealed so clients can't read or forge offsets
+	var cursorCipher *cache.Cipher
+	if cfg.CursorKeys != "" {
+		if cursorCipher, err = cache.NewCipher(cfg.CursorKeys); err != nil {
+			logger.Fatal("Invalid cursor keys", zap.Error(err))
+		}
+	}
 
 	// Initialize rate limiter
-	rateLimiter := middleware.NewRateLimiter(cfg.RateLimitRPS, cfg.RateLimitBurst)
+	rateLimiter := middleware.NewRateLimiter(cfg.RateLimitRPS, cfg.RateLimitBurst, cfg.RateLimitIPv6Prefix)
+
+	// White-label tenants matched by Host header, each with its own upstream
+	// URLs, rate limit and auth policy
+	if len(cfg.Tenants) > 0 {
+		limiters := make(map[string]*middleware.RateLimiter)
+		for _, tenant := range cfg.Tenants {
+			if policy, ok := cfg.RateLimitPolicies[tenant.RateLimit]; ok {
+				limiters[tenant.Name] = middleware.NewRateLimiter(policy.RPS, policy.Burst, cfg.RateLimitIPv6Prefix)
+			}
+		}
+		r.Use(middleware.Tenants(middleware.TenantOptions{
+			Resolver:      tenants.New(cfg),
+			RejectUnknown: cfg.TenantUnknownHosts == "reject",
+			Limiters:      limiters,
+			Secrets:       cfg.JWTSecrets,
+		}))
+	}
+
+	// Create proxy handler and keep upstream connections warm
+	proxyHandler := proxy.NewProxyHandler(cfg.ProxyTimeout, logger)
+
+	// Header/cookie routing rules for dogfooding alternate upstream versions
+	if len(cfg.RoutingRules) > 0 {
+		upstreams := cfg.ServiceURLs()
+		rules := make(map[string][]proxy.RoutingRule)
+		for _, rule := range cfg.RoutingRules {
+			baseURL := upstreams[rule.Upstream]
+			rules[baseURL] = append(rules[baseURL], proxy.RoutingRule{
+				Header: rule.Header,
+				Cookie: rule.Cookie,
+				Value:  rule.Value,
+				Target: rule.Target,
+			})
+		}
+		proxyHandler.SetRoutingRules(rules)
+	}
+
+	// Identity headers are signed so backends can verify they came from the gateway
+	proxyHandler.SetSigningSecret(cfg.CurrentGatewaySigningSecret)
+
+	// Shadow traffic for load-testing new service versions
+	if len(cfg.Mirrors) > 0 {
+		upstreams := cfg.ServiceURLs()
+		mirrors := make(map[string]proxy.Mirror, len(cfg.Mirrors))
+		for _, mirror := range cfg.Mirrors {
+			mirrors[upstreams[mirror.Upstream]] = proxy.Mirror{
+				Target:        mirror.Target,
+				Percent:       mirror.Percent,
+				IncludeWrites: mirror.IncludeWrites,
+			}
+		}
+		proxyHandler.SetMirrors(mirrors, cfg.MirrorMaxInflight)
+	}
+
+	// Upstreams that speak h2c, or only HTTP/1.1, get a transport of their own
+	if len(cfg.UpstreamProtocols) > 0 {
+		upstreams := cfg.ServiceURLs()
+		protocols := make(map[string]string, len(cfg.UpstreamProtocols))
+		for name, protocol := range cfg.UpstreamProtocols {
+			protocols[upstreams[name]] = protocol
+		}
+		proxyHandler.SetProtocols(protocols)
+	}
+
+	// Ads traffic gets its own pool and breaker so it can't degrade organic traffic
+	proxyHandler.Isolate(cfg.AdsServiceURL, proxy.IsolationOptions{
+		MaxConns:        cfg.AdsMaxConns,
+		BreakerFailures: cfg.AdsBreakerFailures,
+		BreakerCooldown: cfg.AdsBreakerCooldown,
+	})
+
+	// Routes with a fallback get a breaker on their upstream, and serve the
+	// fallback while it is open
+	if len(cfg.Fallbacks) > 0 {
+		upstreams := cfg.ServiceURLs()
+		fallbacks := make(map[string]proxy.Fallback, len(cfg.Fallbacks))
+		breakers := make(map[string]bool)
+		for _, fallback := range cfg.Fallbacks {
+			method := strings.ToUpper(fallback.Method)
+			if method == "" {
+				method = http.MethodGet
+			}
+			payload, _ := json.Marshal(fallback.JSON)
+			status := fallback.Status
+			if status == 0 {
+				status = http.StatusOK
+			}
+			fallbacks[method+" "+fallback.Path] = proxy.Fallback{
+				Status:  status,
+				Headers: fallback.Headers,
+				Payload: payload,
+			}
+
+			breakers[upstreams[fallback.Upstream]] = true
+		}
+		delete(breakers, cfg.AdsServiceURL)
+		for upstream := range breakers {
+			proxyHandler.Isolate(upstream, proxy.IsolationOptions{
+				BreakerFailures: cfg.FallbackBreakerFailures,
+				BreakerCooldown: cfg.FallbackBreakerCooldown,
+			})
+		}
+		proxyHandler.SetFallbacks(fallbacks)
+	}
+	go proxyHandler.Prewarm(bgCtx, cfg.ServiceURLs(), cfg.PrewarmInterval, cfg.PrewarmConns)
+
+	// Feed live backend addresses into the proxy's load balancer
+	discoveryProvider, err := discovery.New(cfg, logger)
+	if err != nil {
+		logger.Fatal("Failed to initialize service discovery", zap.Error(err))
+	}
+	if discoveryProvider != nil {
+		go discoveryProvider.Watch(bgCtx, cfg.ServiceURLs(), proxyHandler.SetEndpoints)
+	}
+
+	// Gateway-originated events are delivered at least once through a Redis stream
+	upstreams := cfg.ServiceURLs()
+	eventOutbox := outbox.New(redisClient, outbox.Options{
+		Stream:          cfg.OutboxStream,
+		MaxLen:          int64(cfg.OutboxMaxLen),
+		MaxAttempts:     int64(cfg.OutboxMaxAttempts),
+		RetryAfter:      cfg.OutboxRetryAfter,
+		DeliveryTimeout: cfg.ProxyTimeout,
+	}, logger)
+	for kind, sink := range cfg.OutboxSinks {
+		eventOutbox.Register(kind, outbox.UpstreamSink(proxyHandler.Send, upstreams[sink.Upstream], sink.Path))
+	}
+
+	// Partner webhooks leave through the egress policy, signed per subscription
+	egressPolicy, err := egress.NewPolicy(cfg)
+	if err != nil {
+		logger.Fatal("Failed to initialize egress policy", zap.Error(err))
+	}
+	webhookManager := webhooks.New(redisClient, eventOutbox, egressPolicy.NewClient(cfg.WebhookTimeout), logger)
+	eventOutbox.Register(outbox.KindWebhook, webhookManager.Sink())
+	eventOutbox.Run(bgCtx, cfg.OutboxWorkers)
+
+	// High-volume events go to Kafka in the background; the publisher
+	// outlives the servers, so events of requests finishing during shutdown
+	// are still sent
+	var eventPublisher *events.Publisher
+	publisherCtx, stopPublisher := context.WithCancel(context.Background())
+	publisherDone := make(chan struct{})
+	if cfg.KafkaRESTURL != "" {
+		eventPublisher = events.New(events.Options{
+			URL:           cfg.KafkaRESTURL,
+			QueueSize:     cfg.KafkaQueueSize,
+			BatchSize:     cfg.KafkaBatchSize,
+			FlushInterval: cfg.KafkaFlushInterval,
+			Timeout:       cfg.ProxyTimeout,
+			MaxAttempts:   3,
+		}, logger)
+		if cfg.SecurityEventsTopic != "none" {
+			events.SetSecurityPublisher(eventPublisher, cfg.SecurityEventsTopic)
+		}
+		go func() {
+			eventPublisher.Run(publisherCtx)
+			close(publisherDone)
+		}()
+	} else {
+		close(publisherDone)
+	}
+
+	// Notifications sent through the internal plane are throttled per device,
+	// honouring quiet hours from the users' cached notification settings
+	var pushThrottle *push.Throttle
+	if _, ok := cfg.OutboxSinks[outbox.KindPush]; ok && cfg.InternalPort > 0 {
+		settingsStore := settings.New(proxyHandler, cacheStore, cfg.SettingsServiceURL, cfg.SettingsCacheTTL, logger)
+		pushThrottle = push.New(redisClient, eventOutbox, settingsStore, push.Options{
+			MaxPerHour:     cfg.PushMaxPerHour,
+			CollapseWindow: cfg.PushCollapseWindow,
+		}, logger)
+		go pushThrottle.Run(bgCtx)
+	}
 
 	// Setup routes with middleware
-	router.SetupRoutes(r, cfg, logger, rateLimiter)
+	err = router.SetupRoutes(bgCtx, r, router.Dependencies{
+		Config:       cfg,
+		Logger:       logger,
+		RateLimiter:  rateLimiter,
+		ProxyHandler: proxyHandler,
+		Redis:        redisClient,
+		RedisHealth:  redisHealth,
+		CacheStore:   cacheStore,
+		CursorCipher: cursorCipher,
+		Outbox:       eventOutbox,
+		Events:       eventPublisher,
+		Webhooks:     webhookManager,
+		AuditLog:     auditLog,
+		Management:   management,
+	})
+	if err != nil {
+		logger.Fatal("Failed to set up routes", zap.Error(err))
+	}
 
 	// Create HTTP server
 	srv := &http.Server{
@@ -67,31 +417,213 @@ func main() {
 		IdleTimeout:  cfg.IdleTimeout,
 	}
 
+	// Terminate TLS with a certificate reloaded when its files change
+	if cfg.TLSCertFile != "" {
+		reloader, err := certs.NewReloader(cfg.TLSCertFile, cfg.TLSKeyFile, logger)
+		if err != nil {
+			logger.Fatal("Failed to load TLS certificate", zap.Error(err))
+		}
+		go reloader.Watch(bgCtx, cfg.TLSReloadInterval)
+		srv.TLSConfig = &tls.Config{
+			GetCertificate: reloader.GetCertificate,
+			MinVersion:     middleware.TLSVersions[cfg.TLSMinVersion],
+			CipherSuites:   cfg.TLSCipherSuiteIDs(),
+		}
+	}
+
+	// Or with certificates obtained and renewed automatically, shared by the
+	// replicas through the ACME cache
+	var acmeSrv *http.Server
+	if len(cfg.ACMEDomains) > 0 {
+		manager := certs.NewACMEManager(certs.ACMEOptions{
+			Domains:      cfg.ACMEDomains,
+			Email:        cfg.ACMEEmail,
+			DirectoryURL: cfg.ACMEDirectoryURL,
+			Cache:        cfg.ACMECache,
+			HTTPClient:   egressPolicy.NewClient(30 * time.Second),
+		}, redisClient, logger)
+		srv.TLSConfig = manager.TLSConfig()
+		srv.TLSConfig.MinVersion = middleware.TLSVersions[cfg.TLSMinVersion]
+		srv.TLSConfig.CipherSuites = cfg.TLSCipherSuiteIDs()
+
+		if cfg.ACMEHTTPAddr != "" {
+			// HTTP-01 challenges; everything else is redirected to HTTPS
+			acmeSrv = &http.Server{
+				Addr:              cfg.ACMEHTTPAddr,
+				Handler:           manager.HTTPHandler(nil),
+				ReadHeaderTimeout: 5 * time.Second,
+			}
+			go func() {
+				logger.Info("Starting ACME challenge listener", zap.String("addr", cfg.ACMEHTTPAddr))
+				if err := acmeSrv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+					logger.Fatal("Failed to start ACME challenge listener", zap.Error(err))
+				}
+			}()
+		}
+	}
+
 	// Start server in goroutine
 	go func() {
 		logger.Info("Starting API Gateway",
 			zap.Int("port", cfg.Port),
+			zap.Bool("tls", srv.TLSConfig != nil),
 			zap.String("environment", cfg.Environment),
+			zap.String("commit", version.Get().Commit),
 		)
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		var err error
+		if srv.TLSConfig != nil {
+			err = srv.ListenAndServeTLS("", "")
+		} else {
+			err = srv.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
 			logger.Fatal("Failed to start server", zap.Error(err))
 		}
 	}()
 
+	// Experimental HTTP/3 listener sharing the TLS configuration
+	var http3Srv *http3.Server
+	if cfg.HTTP3Port > 0 {
+		http3Srv = &http3.Server{
+			Addr:      fmt.Sprintf(":%d", cfg.HTTP3Port),
+			Handler:   r,
+			TLSConfig: srv.TLSConfig,
+			QuicConfig: &quic.Config{
+				MaxIdleTimeout: cfg.IdleTimeout,
+			},
+		}
+		go func() {
+			logger.Info("Starting HTTP/3 listener", zap.Int("port", cfg.HTTP3Port))
+			if err := http3Srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				logger.Fatal("Failed to start HTTP/3 listener", zap.Error(err))
+			}
+		}()
+	}
+
+	// Management listener
+	var managementSrv *http.Server
+	if cfg.AdminAddr != "" {
+		managementSrv = &http.Server{
+			Addr:         cfg.AdminAddr,
+			Handler:      management,
+			ReadTimeout:  cfg.ReadTimeout,
+			WriteTimeout: cfg.WriteTimeout,
+			IdleTimeout:  cfg.IdleTimeout,
+		}
+		go func() {
+			logger.Info("Starting management listener", zap.String("addr", cfg.AdminAddr))
+			if err := managementSrv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				logger.Fatal("Failed to start management listener", zap.Error(err))
+			}
+		}()
+	}
+
+	// Internal service-to-service plane on its own listener
+	var internalSrv *http.Server
+	if cfg.InternalPort > 0 {
+		internal := gin.New()
+		internal.Use(gin.Recovery())
+		internal.Use(middleware.Logger(logger))
+		router.SetupInternalRoutes(internal, router.Dependencies{
+			Config:       cfg,
+			Logger:       logger,
+			ProxyHandler: proxyHandler,
+			Redis:        redisClient,
+			Push:         pushThrottle,
+		})
+
+		internalSrv = &http.Server{
+			Addr:         fmt.Sprintf(":%d", cfg.InternalPort),
+			Handler:      internal,
+			ReadTimeout:  cfg.ReadTimeout,
+			WriteTimeout: cfg.WriteTimeout,
+			IdleTimeout:  cfg.IdleTimeout,
+		}
+		if cfg.InternalTLSClientCAFile != "" {
+			caPEM, err := os.ReadFile(cfg.InternalTLSClientCAFile)
+			if err != nil {
+				logger.Fatal("Failed to read internal client CA", zap.Error(err))
+			}
+			clientCAs := x509.NewCertPool()
+			if !clientCAs.AppendCertsFromPEM(caPEM) {
+				logger.Fatal("No certificates in internal client CA file")
+			}
+			// Callers without a certificate can still use a service token
+			internalSrv.TLSConfig = &tls.Config{
+				ClientCAs:  clientCAs,
+				ClientAuth: tls.VerifyClientCertIfGiven,
+				MinVersion: tls.VersionTLS12,
+			}
+		}
+
+		go func() {
+			logger.Info("Starting internal routing plane", zap.Int("port", cfg.InternalPort))
+			var err error
+			if cfg.InternalTLSCertFile != "" {
+				err = internalSrv.ListenAndServeTLS(cfg.InternalTLSCertFile, cfg.InternalTLSKeyFile)
+			} else {
+				err = internalSrv.ListenAndServe()
+			}
+			if err != nil && err != http.ErrServerClosed {
+				logger.Fatal("Failed to start internal server", zap.Error(err))
+			}
+		}()
+	}
+
+	// Profiling and runtime diagnostics on their own internal-only listener.
+	// Profiles run for up to a minute, so writes are not time-limited.
+	var debugSrv *http.Server
+	if cfg.DebugAddr != "" {
+		debugSrv = &http.Server{
+			Addr:              cfg.DebugAddr,
+			Handler:           diagnostics.Handler(),
+			ReadHeaderTimeout: 5 * time.Second,
+		}
+		go func() {
+			logger.Info("Starting diagnostics listener", zap.String("addr", cfg.DebugAddr))
+			if err := debugSrv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				logger.Fatal("Failed to start diagnostics listener", zap.Error(err))
+			}
+		}()
+	}
+
 	// Wait for interrupt signal to gracefully shutdown the server
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
 
 	logger.Info("Shutting down server...")
+	stopBackground()
 
 	// Graceful shutdown with timeout
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
+	// A profile being taken is not worth waiting for
+	if debugSrv != nil {
+		debugSrv.Close()
+	}
+	if acmeSrv != nil {
+		acmeSrv.Close()
+	}
+	if http3Srv != nil {
+		http3Srv.CloseGracefully(5 * time.Second)
+	}
+	if managementSrv != nil {
+		if err := managementSrv.Shutdown(ctx); err != nil {
+			logger.Warn("Management server forced to shutdown", zap.Error(err))
+		}
+	}
+	if internalSrv != nil {
+		if err := internalSrv.Shutdown(ctx); err != nil {
+			logger.Warn("Internal server forced to shutdown", zap.Error(err))
+		}
+	}
 	if err := srv.Shutdown(ctx); err != nil {
 		logger.Fatal("Server forced to shutdown", zap.Error(err))
 	}
+	stopPublisher()
+	<-publisherDone
 
 	logger.Info("Server exited")
 }
@@ -0,0 +1,129 @@
+package metrics
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+)
+
+type kind int
+
+const (
+	counterKind kind = iota
+	gaugeKind
+)
+
+// series is a single named metric with a fixed label set
+type series struct {
+	name   string
+	labels string
+	kind   kind
+	value  int64
+}
+
+var (
+	mu       sync.RWMutex
+	registry = make(map[string]*series)
+)
+
+// lookup returns the series for name and labels, creating it if needed.
+// Labels are given as alternating key/value pairs.
+func lookup(name string, k kind, labels []string) *series {
+	labelStr := formatLabels(labels)
+	id := name + labelStr
+
+	mu.RLock()
+	s, exists := registry[id]
+	mu.RUnlock()
+	if exists {
+		return s
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if s, exists = registry[id]; !exists {
+		s = &series{name: name, labels: labelStr, kind: k}
+		registry[id] = s
+	}
+	return s
+}
+
+// formatLabels renders key/value pairs in Prometheus label syntax
+func formatLabels(labels []string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+
+	parts := make([]string, 0, len(labels)/2)
+	for i := 0; i+1 < len(labels); i += 2 {
+		value := strings.ReplaceAll(labels[i+1], `"`, `\"`)
+		parts = append(parts, fmt.Sprintf(`%s="%s"`, labels[i], value))
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+// Inc increments a counter by one
+func Inc(name string, labels ...string) {
+	Add(name, 1, labels...)
+}
+
+// Add increments a counter by delta
+func Add(name string, delta int64, labels ...string) {
+	atomic.AddInt64(&lookup(name, counterKind, labels).value, delta)
+}
+
+// Set stores the current value of a gauge
+func Set(name string, value int64, labels ...string) {
+	atomic.StoreInt64(&lookup(name, gaugeKind, labels).value, value)
+}
+
+// Value returns the current value of a counter or gauge
+func Value(name string, labels ...string) int64 {
+	mu.RLock()
+	s, exists := registry[name+formatLabels(labels)]
+	mu.RUnlock()
+	if !exists {
+		return 0
+	}
+	return atomic.LoadInt64(&s.value)
+}
+
+// Handler exposes all metrics in the Prometheus text exposition format
+func Handler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		mu.RLock()
+		all := make([]*series, 0, len(registry))
+		for _, s := range registry {
+			all = append(all, s)
+		}
+		mu.RUnlock()
+
+		sort.Slice(all, func(i, j int) bool {
+			if all[i].name != all[j].name {
+				return all[i].name < all[j].name
+			}
+			return all[i].labels < all[j].labels
+		})
+
+		var b strings.Builder
+		lastName := ""
+		for _, s := range all {
+			if s.name != lastName {
+				typ := "counter"
+				if s.kind == gaugeKind {
+					typ = "gauge"
+				}
+				fmt.Fprintf(&b, "# TYPE %s %s\n", s.name, typ)
+				lastName = s.name
+			}
+			fmt.Fprintf(&b, "%s%s %d\n", s.name, s.labels, atomic.LoadInt64(&s.value))
+		}
+
+		c.Data(http.StatusOK, "text/plain; version=0.0.4", []byte(b.String()))
+	}
+}
@@ -0,0 +1,41 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AdminAuth middleware protects gateway management endpoints with a shared
+// operator token sent as "Authorization: Bearer <token>" or X-Admin-Token.
+// token is read per request so rotated tokens apply immediately; when it is
+// empty the protected endpoints are disabled.
+func AdminAuth(token func() string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		expected := token()
+		if expected == "" {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error": "Admin API is disabled",
+			})
+			c.Abort()
+			return
+		}
+
+		provided := c.GetHeader("X-Admin-Token")
+		if provided == "" {
+			provided = strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+		}
+
+		if subtle.ConstantTimeCompare([]byte(provided), []byte(expected)) != 1 {
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"error": "Invalid admin token",
+			})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
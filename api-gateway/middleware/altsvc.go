@@ -0,0 +1,21 @@
+package middleware
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AltSvc middleware advertises the HTTP/3 listener on the given UDP port to
+// clients connecting over TCP, who may switch to it for later requests and
+// keep using it for maxAge
+func AltSvc(port int, maxAge time.Duration) gin.HandlerFunc {
+	value := fmt.Sprintf(`h3=":%d"; ma=%d`, port, int(maxAge.Seconds()))
+	return func(c *gin.Context) {
+		if c.Request.ProtoMajor < 3 {
+			c.Header("Alt-Svc", value)
+		}
+		c.Next()
+	}
+}
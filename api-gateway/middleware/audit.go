@@ -0,0 +1,91 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/YeonwooSung/instagram/api-gateway/events"
+	"github.com/YeonwooSung/instagram/api-gateway/outbox"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// auditEvent is the payload of audit events for gateway management actions
+type auditEvent struct {
+	Action    string    `json:"action"`
+	Route     string    `json:"route"`
+	Path      string    `json:"path"`
+	Status    int       `json:"status"`
+	Actor     string    `json:"actor,omitempty"`
+	Role      string    `json:"role,omitempty"`
+	ClientIP  string    `json:"client_ip"`
+	RequestID string    `json:"request_id,omitempty"`
+	At        time.Time `json:"at"`
+}
+
+// Audit middleware publishes an audit event to the outbox for every
+// successful state-changing request. Reads and rejected requests are not
+// audited.
+func Audit(eventOutbox *outbox.Outbox, logger *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+
+		if c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead ||
+			c.Writer.Status() >= http.StatusBadRequest {
+			return
+		}
+		publishAudit(c, eventOutbox, "admin", logger)
+	}
+}
+
+// AuditAll middleware publishes an audit event for every request, reads and
+// rejected attempts included, keyed by trail. Routes where who looked at what
+// matters, such as moderation, use it instead of Audit; placed before JWTAuth
+// it also records requests without a valid token.
+func AuditAll(eventOutbox *outbox.Outbox, trail string, logger *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+		publishAudit(c, eventOutbox, trail, logger)
+	}
+}
+
+func publishAudit(c *gin.Context, eventOutbox *outbox.Outbox, key string, logger *zap.Logger) {
+	event := auditEvent{
+		Action:    c.Request.Method,
+		Route:     c.FullPath(),
+		Path:      c.Request.URL.Path,
+		Status:    c.Writer.Status(),
+		Role:      c.GetString("role"),
+		ClientIP:  c.ClientIP(),
+		RequestID: c.GetString("request_id"),
+		At:        time.Now().UTC(),
+	}
+	if userID, exists := c.Get("user_id"); exists {
+		event.Actor = fmt.Sprintf("%v", userID)
+	}
+	events.Security(events.SecurityEvent{
+		Type:      events.SecurityAdminAction,
+		UserID:    event.Actor,
+		ClientIP:  event.ClientIP,
+		UserAgent: c.Request.UserAgent(),
+		Route:     event.Route,
+		Status:    event.Status,
+		RequestID: event.RequestID,
+		Details:   map[string]string{"trail": key, "method": event.Action, "path": event.Path, "role": event.Role},
+		At:        event.At,
+	})
+
+	// The action already happened; don't lose its audit record to a
+	// client disconnecting
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(c.Request.Context()), time.Second)
+	defer cancel()
+	if err := eventOutbox.Publish(ctx, outbox.KindAudit, key, event); err != nil {
+		logger.Error("Failed to record audit event",
+			zap.String("route", event.Route),
+			zap.String("path", event.Path),
+			zap.Error(err),
+		)
+	}
+}
@@ -9,8 +9,9 @@ import (
 	"github.com/golang-jwt/jwt/v5"
 )
 
-// JWTAuth middleware validates JWT tokens
-func JWTAuth(jwtSecret string) gin.HandlerFunc {
+// JWTAuth middleware validates JWT tokens. secrets returns the accepted HMAC
+// secrets (current first), so rotated secrets apply without a restart.
+func JWTAuth(secrets func() []string) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
@@ -34,13 +35,7 @@ func JWTAuth(jwtSecret string) gin.HandlerFunc {
 		tokenString := parts[1]
 
 		// Parse and validate token
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			// Validate signing method
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-			}
-			return []byte(jwtSecret), nil
-		})
+		token, err := jwt.Parse(tokenString, hmacKeyFunc(secrets))
 
 		if err != nil {
 			c.JSON(http.StatusUnauthorized, gin.H{
@@ -67,6 +62,7 @@ func JWTAuth(jwtSecret string) gin.HandlerFunc {
 			if username, ok := claims["username"]; ok {
 				c.Set("username", username)
 			}
+			c.Set("claims", claims)
 		}
 
 		c.Next()
@@ -74,7 +70,7 @@ func JWTAuth(jwtSecret string) gin.HandlerFunc {
 }
 
 // OptionalJWTAuth is similar to JWTAuth but doesn't abort on missing/invalid token
-func OptionalJWTAuth(jwtSecret string) gin.HandlerFunc {
+func OptionalJWTAuth(secrets func() []string) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
@@ -90,12 +86,7 @@ func OptionalJWTAuth(jwtSecret string) gin.HandlerFunc {
 
 		tokenString := parts[1]
 
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-			}
-			return []byte(jwtSecret), nil
-		})
+		token, err := jwt.Parse(tokenString, hmacKeyFunc(secrets))
 
 		if err == nil && token.Valid {
 			if claims, ok := token.Claims.(jwt.MapClaims); ok {
@@ -105,9 +96,26 @@ func OptionalJWTAuth(jwtSecret string) gin.HandlerFunc {
 				if username, ok := claims["username"]; ok {
 					c.Set("username", username)
 				}
+				c.Set("claims", claims)
 			}
 		}
 
 		c.Next()
 	}
 }
+
+// hmacKeyFunc accepts HMAC-signed tokens verified by any of the current secrets
+func hmacKeyFunc(secrets func() []string) jwt.Keyfunc {
+	return func(token *jwt.Token) (interface{}, error) {
+		// Validate signing method
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+
+		keys := jwt.VerificationKeySet{}
+		for _, secret := range secrets() {
+			keys.Keys = append(keys.Keys, []byte(secret))
+		}
+		return keys, nil
+	}
+}
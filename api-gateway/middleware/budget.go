@@ -0,0 +1,57 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/YeonwooSung/instagram/api-gateway/accounting"
+	"github.com/YeonwooSung/instagram/api-gateway/metrics"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// ResourceBudget middleware accounts wall time, bytes processed and upstream
+// calls for each request and kills requests that exceed limits: in-flight
+// upstream calls are cancelled and the client gets a 503 with the usage.
+func ResourceBudget(limits accounting.Limits, logger *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, usage := accounting.Start(c.Request.Context(), limits)
+		defer usage.Stop()
+
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+
+		reason := usage.Exceeded()
+		if reason == "" {
+			return
+		}
+
+		snapshot := usage.Snapshot()
+		metrics.Inc("gateway_budget_exceeded_total", "route", c.FullPath(), "reason", reason)
+		logger.Warn("Request exceeded resource budget",
+			zap.String("path", c.Request.URL.Path),
+			zap.String("reason", reason),
+			zap.Duration("wall_time", snapshot.WallTime),
+			zap.Int64("bytes", snapshot.Bytes),
+			zap.Int64("upstream_calls", snapshot.UpstreamCalls),
+		)
+
+		if c.Writer.Written() {
+			return
+		}
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"error": "Request exceeded its resource budget",
+			"budget": gin.H{
+				"exceeded":       reason,
+				"wall_time_ms":   snapshot.WallTime.Milliseconds(),
+				"bytes":          snapshot.Bytes,
+				"upstream_calls": snapshot.UpstreamCalls,
+				"limits": gin.H{
+					"wall_time_ms":   limits.WallTime.Milliseconds(),
+					"bytes":          limits.Bytes,
+					"upstream_calls": limits.UpstreamCalls,
+				},
+			},
+		})
+		c.Abort()
+	}
+}
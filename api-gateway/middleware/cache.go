@@ -0,0 +1,303 @@
+package middleware
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/YeonwooSung/instagram/api-gateway/cache"
+	"github.com/YeonwooSung/instagram/api-gateway/metrics"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// CacheOptions configures response caching for a route
+type CacheOptions struct {
+	// TTL of cached entries; zero disables caching
+	TTL time.Duration
+
+	// PerUser caches a separate, encrypted entry per requester
+	PerUser bool
+}
+
+// ResponseCache caches successful GET responses in Redis
+type ResponseCache struct {
+	store    *cache.Store
+	redis    *RedisHealth
+	maxStale time.Duration
+	logger   *zap.Logger
+}
+
+// NewResponseCache creates a new response cache middleware factory. While
+// Redis is down it follows the cache degradation policy. Entries are kept
+// for maxStale past their TTL, to be served when the upstream fails.
+func NewResponseCache(store *cache.Store, redisHealth *RedisHealth, maxStale time.Duration, logger *zap.Logger) *ResponseCache {
+	return &ResponseCache{
+		store:    store,
+		redis:    redisHealth,
+		maxStale: maxStale,
+		logger:   logger,
+	}
+}
+
+// Cache middleware serves cached responses and stores fresh 200 responses.
+// Responses carry an ETag, and conditional requests whose If-None-Match
+// matches a fresh entry are answered 304 without reaching the upstream.
+// When the upstream fails and an expired entry is at most maxStale past its
+// TTL, the stale entry is served instead of the error, with a Warning header.
+func (rc *ResponseCache) Cache(opts CacheOptions) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// Range requests (media seeks) stream straight from the upstream
+		if opts.TTL <= 0 || c.Request.Method != http.MethodGet || c.GetHeader("Range") != "" {
+			c.Next()
+			return
+		}
+
+		// Private responses are only cached when they can be encrypted
+		owner := ""
+		if opts.PerUser {
+			if !rc.store.CanStorePrivate() {
+				c.Next()
+				return
+			}
+			owner = requesterKey(c)
+		}
+
+		key := cacheKey(c, owner)
+		route := c.FullPath()
+
+		// Degraded: skip caching, reject, or cache in this instance's memory
+		var memory kvStore
+		if !rc.redis.Up() {
+			store, mode, ok := rc.redis.degrade(RedisFeatureCache)
+			if !ok {
+				if mode == RedisFailClosed {
+					rejectUnavailable(c, "Cache unavailable")
+					return
+				}
+				c.Next()
+				return
+			}
+			memory = store
+		}
+
+		ifNoneMatch := c.GetHeader("If-None-Match")
+		cached, err := rc.get(c.Request.Context(), memory, key, owner)
+
+		// Entries past their TTL are only kept to be served stale
+		var stale *cache.Entry
+		if err == nil && time.Since(cached.StoredAt) >= opts.TTL {
+			if time.Since(cached.StoredAt) < opts.TTL+rc.maxStale {
+				stale = cached
+			}
+			err = cache.ErrMiss
+		}
+		if err == nil {
+			etag := cached.ETag
+			if etag == "" {
+				etag = cache.WeakETag(cached.Body)
+			}
+			c.Header("X-Cache", "HIT")
+			c.Header("ETag", etag)
+			if cache.ETagMatches(ifNoneMatch, etag) {
+				metrics.Inc("gateway_cache_requests_total", "route", route, "result", "not_modified")
+				c.Status(http.StatusNotModified)
+				c.Abort()
+				return
+			}
+			metrics.Inc("gateway_cache_requests_total", "route", route, "result", "hit")
+			c.Data(cached.Status, cached.ContentType, cached.Body)
+			c.Abort()
+			return
+		}
+		metrics.Inc("gateway_cache_requests_total", "route", route, "result", "miss")
+		c.Header("X-Cache", "MISS")
+
+		// Entries are served to every client alike, so they are stored
+		// uncompressed
+		c.Request.Header.Del("Accept-Encoding")
+
+		// A conditional miss fetches the full response so it can be cached,
+		// and holds it back to answer 304 if the client's copy is current.
+		// With a stale entry at hand the response is held back too, in case
+		// it is an error to replace.
+		var writer *bufferedWriter
+		var recorder *responseRecorder
+		var before http.Header
+		if ifNoneMatch != "" || stale != nil {
+			c.Request.Header.Del("If-None-Match")
+			before = c.Writer.Header().Clone()
+			writer = newBufferedWriter(c.Writer)
+			c.Writer = writer
+			defer func() { c.Writer = writer.ResponseWriter }()
+		} else {
+			recorder = newResponseRecorder(c.Writer)
+			c.Writer = recorder
+		}
+
+		c.Next()
+
+		status, header, body := c.Writer.Status(), c.Writer.Header(), []byte(nil)
+		if writer != nil {
+			body = writer.body.Bytes()
+		} else {
+			body = recorder.body.Bytes()
+		}
+
+		// The upstream failed, or the route fell back while it is down
+		if stale != nil && (status >= http.StatusInternalServerError || header.Get("X-Fallback") != "") {
+			rc.serveStale(writer, before, stale, route)
+			return
+		}
+
+		var entry *cache.Entry
+		// Fallbacks served while the upstream is down are never cached
+		if status == http.StatusOK && header.Get("Set-Cookie") == "" && header.Get("X-Fallback") == "" {
+			entry = &cache.Entry{
+				Status:      status,
+				ContentType: header.Get("Content-Type"),
+				Body:        body,
+				StoredAt:    time.Now(),
+				ETag:        header.Get("ETag"),
+			}
+			if entry.ETag == "" {
+				entry.ETag = cache.WeakETag(body)
+			}
+			if err := rc.set(c.Request.Context(), memory, key, owner, entry, opts.TTL+rc.maxStale); err != nil {
+				rc.logger.Warn("Failed to store cached response",
+					zap.Error(err),
+					zap.String("route", route),
+				)
+			}
+		}
+
+		if writer == nil {
+			return
+		}
+		if entry != nil {
+			header.Set("ETag", entry.ETag)
+			if cache.ETagMatches(ifNoneMatch, entry.ETag) {
+				header.Del("Content-Length")
+				writer.ResponseWriter.WriteHeader(http.StatusNotModified)
+				return
+			}
+		}
+		writer.flush(body)
+	}
+}
+
+// serveStale answers with a stale entry in place of the upstream's failed
+// response, restoring the headers set before the upstream was called
+func (rc *ResponseCache) serveStale(writer *bufferedWriter, header http.Header, entry *cache.Entry, route string) {
+	metrics.Inc("gateway_cache_requests_total", "route", route, "result", "stale")
+	rc.logger.Warn("Serving stale cached response",
+		zap.String("route", route),
+		zap.Int("upstream_status", writer.status),
+		zap.Duration("age", time.Since(entry.StoredAt)),
+	)
+
+	response := writer.Header()
+	for name := range response {
+		delete(response, name)
+	}
+	for name, values := range header {
+		response[name] = values
+	}
+	etag := entry.ETag
+	if etag == "" {
+		etag = cache.WeakETag(entry.Body)
+	}
+	response.Set("X-Cache", "STALE")
+	response.Set("ETag", etag)
+	response.Set("Age", strconv.Itoa(int(time.Since(entry.StoredAt).Seconds())))
+	response.Set("Warning", `110 - "Response is Stale"`)
+	response.Add("Warning", `111 - "Revalidation Failed"`)
+	response.Set("Content-Type", entry.ContentType)
+
+	writer.status = entry.Status
+	writer.flush(entry.Body)
+}
+
+// get reads an entry from Redis, or from memory when Redis is degraded
+func (rc *ResponseCache) get(ctx context.Context, memory kvStore, key, owner string) (*cache.Entry, error) {
+	if memory == nil {
+		entry, err := rc.store.Get(ctx, key, owner)
+		if err != nil && !errors.Is(err, cache.ErrMiss) {
+			rc.redis.report(err)
+		}
+		return entry, err
+	}
+
+	data, err := memory.Get(ctx, key)
+	if err != nil {
+		return nil, cache.ErrMiss
+	}
+	var entry cache.Entry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, cache.ErrMiss
+	}
+	return &entry, nil
+}
+
+// set stores an entry in Redis, or in memory when Redis is degraded
+func (rc *ResponseCache) set(ctx context.Context, memory kvStore, key, owner string, entry *cache.Entry, ttl time.Duration) error {
+	if memory == nil {
+		err := rc.store.Set(ctx, key, owner, entry, ttl)
+		if err != nil {
+			rc.redis.report(err)
+		}
+		return err
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return memory.Set(ctx, key, data, ttl)
+}
+
+// Invalidate drops the requester's cached copy of path, first page only,
+// once the wrapped request succeeds. Writes use it so a long-lived per-user
+// entry doesn't outlast the requester's own change.
+func (rc *ResponseCache) Invalidate(path string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+		if c.Writer.Status() >= http.StatusMultipleChoices || !rc.store.CanStorePrivate() {
+			return
+		}
+
+		key := pathKey(c, requesterKey(c), path, "")
+		if !rc.redis.Up() {
+			if memory, _, ok := rc.redis.degrade(RedisFeatureCache); ok {
+				memory.Del(c.Request.Context(), key)
+			}
+			return
+		}
+		if err := rc.store.Delete(c.Request.Context(), key); err != nil {
+			rc.redis.report(err)
+			rc.logger.Warn("Failed to invalidate cached response",
+				zap.Error(err),
+				zap.String("path", path),
+			)
+		}
+	}
+}
+
+// cacheKey derives the Redis key from the request URL and owner
+func cacheKey(c *gin.Context, owner string) string {
+	return pathKey(c, owner, c.Request.URL.Path, c.Request.URL.RawQuery)
+}
+
+// pathKey keeps tenants' entries apart, since their upstreams may differ
+func pathKey(c *gin.Context, owner, path, rawQuery string) string {
+	if tenant := c.GetString("tenant"); tenant != "" {
+		owner = "tenant:" + tenant + "|" + owner
+	}
+	sum := sha256.Sum256([]byte(owner + "|" + path + "?" + rawQuery))
+	return "gateway:cache:" + hex.EncodeToString(sum[:])
+}
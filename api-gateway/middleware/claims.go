@@ -0,0 +1,66 @@
+package middleware
+
+import (
+	"net/http"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// VerifiedBusiness middleware admits only verified business accounts, as
+// asserted by the auth service in the JWT (account_type "business" and
+// business_verified true). It must run after JWTAuth.
+func VerifiedBusiness() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims, _ := c.Get("claims")
+		mapClaims, _ := claims.(jwt.MapClaims)
+
+		accountType, _ := mapClaims["account_type"].(string)
+		verified, _ := mapClaims["business_verified"].(bool)
+		if accountType != "business" || !verified {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error": "Verified business account required",
+			})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// RequireRole middleware admits only users holding one of roles, as asserted
+// by the auth service in the JWT ("roles" list or a single "role"). The role
+// the user was admitted with is kept as "role" for audit events. It must run
+// after JWTAuth.
+func RequireRole(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims, _ := c.Get("claims")
+		mapClaims, _ := claims.(jwt.MapClaims)
+
+		var held []string
+		if role, ok := mapClaims["role"].(string); ok {
+			held = append(held, role)
+		}
+		if list, ok := mapClaims["roles"].([]interface{}); ok {
+			for _, role := range list {
+				if role, ok := role.(string); ok {
+					held = append(held, role)
+				}
+			}
+		}
+
+		for _, role := range roles {
+			if slices.Contains(held, role) {
+				c.Set("role", role)
+				c.Next()
+				return
+			}
+		}
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "Insufficient role",
+		})
+		c.Abort()
+	}
+}
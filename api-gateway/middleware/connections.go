@@ -0,0 +1,73 @@
+package middleware
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/YeonwooSung/instagram/api-gateway/metrics"
+	"github.com/gin-gonic/gin"
+)
+
+// ConnectionLimit caps the long-lived connections (WebSockets, event
+// streams) each user holds open through this instance, and counts them
+type ConnectionLimit struct {
+	name  string
+	max   int
+	mu    sync.Mutex
+	open  map[string]int
+	total int
+}
+
+// NewConnectionLimit creates a limit of max open connections per user,
+// reported as name in gateway_connections_open
+func NewConnectionLimit(name string, max int) *ConnectionLimit {
+	return &ConnectionLimit{
+		name: name,
+		max:  max,
+		open: make(map[string]int),
+	}
+}
+
+// Limit middleware refuses a user's connection with 429 while they already
+// hold max open. The handlers after it must block until the connection
+// closes, as the WebSocket and streaming proxies do.
+func (l *ConnectionLimit) Limit() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := requesterKey(c)
+		if !l.acquire(key) {
+			metrics.Inc("gateway_connections_refused_total", "channel", l.name)
+			c.JSON(http.StatusTooManyRequests, gin.H{
+				"error": "Too many open connections",
+			})
+			c.Abort()
+			return
+		}
+		defer l.release(key)
+
+		c.Next()
+	}
+}
+
+func (l *ConnectionLimit) acquire(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.open[key] >= l.max {
+		return false
+	}
+	l.open[key]++
+	l.total++
+	metrics.Set("gateway_connections_open", int64(l.total), "channel", l.name)
+	return true
+}
+
+func (l *ConnectionLimit) release(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.open[key]--; l.open[key] <= 0 {
+		delete(l.open, key)
+	}
+	l.total--
+	metrics.Set("gateway_connections_open", int64(l.total), "channel", l.name)
+}
@@ -0,0 +1,100 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/YeonwooSung/instagram/api-gateway/metrics"
+	"github.com/gin-gonic/gin"
+)
+
+// Consent categories a user can grant through the cookie banner
+const (
+	ConsentAnalytics = "analytics"
+	ConsentAds       = "ads"
+)
+
+// ConsentState is a request's normalized tracking consent
+type ConsentState struct {
+	Analytics bool
+	Ads       bool
+
+	// GPC is set when the browser sent a Global Privacy Control signal
+	GPC bool
+}
+
+// Granted reports whether category is consented to
+func (s ConsentState) Granted(category string) bool {
+	switch category {
+	case ConsentAnalytics:
+		return s.Analytics
+	case ConsentAds:
+		return s.Ads
+	}
+	return false
+}
+
+// Consent middleware parses the consent banner cookie (for example
+// "analytics:1|ads:0") and the Sec-GPC header, and forwards the result to
+// upstreams as X-Consent-Analytics, X-Consent-Ads ("granted" or "denied") and
+// X-Consent-GPC. Categories missing from the cookie fall back to
+// defaultGranted. GPC is an opt-out of sale and sharing, so it always denies
+// ads. Client-supplied X-Consent-* headers are dropped. The state is stored in
+// the context as "consent".
+func Consent(cookieName string, defaultGranted bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		for _, name := range []string{"X-Consent-Analytics", "X-Consent-Ads", "X-Consent-GPC"} {
+			c.Request.Header.Del(name)
+		}
+
+		state := ConsentState{Analytics: defaultGranted, Ads: defaultGranted}
+		if cookie, err := c.Cookie(cookieName); err == nil {
+			for _, part := range strings.Split(cookie, "|") {
+				category, value, _ := strings.Cut(part, ":")
+				granted := value == "1" || value == "granted"
+				switch strings.TrimSpace(category) {
+				case ConsentAnalytics:
+					state.Analytics = granted
+				case ConsentAds:
+					state.Ads = granted
+				}
+			}
+		}
+		if c.GetHeader("Sec-GPC") == "1" {
+			state.GPC = true
+			state.Ads = false
+		}
+
+		c.Request.Header.Set("X-Consent-Analytics", consentValue(state.Analytics))
+		c.Request.Header.Set("X-Consent-Ads", consentValue(state.Ads))
+		if state.GPC {
+			c.Request.Header.Set("X-Consent-GPC", "1")
+		}
+		c.Set("consent", state)
+		c.Next()
+	}
+}
+
+// RequireConsent middleware drops requests from users who have not consented
+// to category. They are answered with 204 so clients don't retry, and never
+// reach the upstream.
+func RequireConsent(category string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		state, _ := c.Get("consent")
+		if consent, ok := state.(ConsentState); ok && consent.Granted(category) {
+			c.Next()
+			return
+		}
+
+		metrics.Inc("gateway_consent_suppressed_total", "category", category, "route", c.FullPath())
+		c.Status(http.StatusNoContent)
+		c.Abort()
+	}
+}
+
+func consentValue(granted bool) string {
+	if granted {
+		return "granted"
+	}
+	return "denied"
+}
@@ -0,0 +1,143 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/YeonwooSung/instagram/api-gateway/metrics"
+	"github.com/YeonwooSung/instagram/api-gateway/state"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// dedupSchema versions dedupEntry as stored in Redis
+var dedupSchema = state.NewSchema("dedup_entry", 1)
+
+// dedupEntry is the stored outcome of the first request in a dedup window.
+// A zero Status marks a request that is still in flight.
+type dedupEntry struct {
+	Status      int    `json:"status"`
+	ContentType string `json:"content_type"`
+	Body        []byte `json:"body"`
+}
+
+// Deduplicator absorbs semantically identical writes (same requester, method,
+// path and body) submitted within a short window, e.g. double-tap likes
+type Deduplicator struct {
+	redis  *RedisHealth
+	logger *zap.Logger
+}
+
+// NewDeduplicator creates a new Redis-backed request deduplicator. While Redis
+// is down it follows the dedup degradation policy.
+func NewDeduplicator(redisHealth *RedisHealth, logger *zap.Logger) *Deduplicator {
+	return &Deduplicator{
+		redis:  redisHealth,
+		logger: logger,
+	}
+}
+
+// Dedup middleware replays the first response for duplicates arriving within
+// window, or rejects them with 409 while the first request is still in flight
+func (d *Deduplicator) Dedup(window time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if window <= 0 {
+			c.Next()
+			return
+		}
+
+		var bodyBytes []byte
+		if c.Request.Body != nil {
+			bodyBytes, _ = io.ReadAll(c.Request.Body)
+			c.Request.Body.Close()
+			c.Request.Body = io.NopCloser(bytes.NewReader(bodyBytes))
+		}
+
+		sum := sha256.Sum256([]byte(fmt.Sprintf("%s|%s|%s|%x",
+			requesterKey(c), c.Request.Method, c.Request.URL.Path, sha256.Sum256(bodyBytes))))
+		key := "gateway:dedup:" + hex.EncodeToString(sum[:])
+		ctx := c.Request.Context()
+
+		store, mode, ok := d.redis.store(RedisFeatureDedup)
+		if !ok {
+			d.degraded(c, mode)
+			return
+		}
+
+		pending, _ := dedupSchema.Marshal(dedupEntry{})
+		first, err := store.SetNX(ctx, key, pending, window)
+		if err != nil {
+			d.logger.Warn("Dedup store unavailable", zap.Error(err))
+			if store, mode, ok = d.redis.degrade(RedisFeatureDedup); !ok {
+				d.degraded(c, mode)
+				return
+			}
+			first, _ = store.SetNX(ctx, key, pending, window)
+		}
+
+		if !first {
+			metrics.Inc("gateway_dedup_hits_total", "route", c.FullPath())
+
+			var entry dedupEntry
+			raw, err := store.Get(ctx, key)
+			if err != nil || dedupSchema.Unmarshal(raw, &entry) != nil || entry.Status == 0 {
+				c.JSON(http.StatusConflict, gin.H{
+					"error": "Duplicate request in progress",
+				})
+				c.Abort()
+				return
+			}
+
+			c.Header("X-Deduplicated", "true")
+			c.Data(entry.Status, entry.ContentType, entry.Body)
+			c.Abort()
+			return
+		}
+
+		recorder := newResponseRecorder(c.Writer)
+		c.Writer = recorder
+
+		c.Next()
+
+		// Let clients retry immediately after upstream failures
+		if recorder.Status() >= http.StatusInternalServerError {
+			store.Del(ctx, key)
+			return
+		}
+
+		entry, _ := dedupSchema.Marshal(dedupEntry{
+			Status:      recorder.Status(),
+			ContentType: recorder.Header().Get("Content-Type"),
+			Body:        recorder.body.Bytes(),
+		})
+		store.Set(ctx, key, entry, window)
+	}
+}
+
+// degraded handles a request while the dedup store is unavailable: it passes
+// through undeduplicated (fail open) or is rejected (fail closed)
+func (d *Deduplicator) degraded(c *gin.Context, mode RedisMode) {
+	if mode == RedisFailClosed {
+		rejectUnavailable(c, "Duplicate protection unavailable")
+		return
+	}
+	c.Next()
+}
+
+// requesterKey identifies the caller: the verified user ID when available,
+// otherwise a hash of the bearer credential, otherwise the client IP
+func requesterKey(c *gin.Context) string {
+	if userID, exists := c.Get("user_id"); exists {
+		return fmt.Sprintf("user:%v", userID)
+	}
+	if authHeader := c.GetHeader("Authorization"); authHeader != "" {
+		sum := sha256.Sum256([]byte(authHeader))
+		return "token:" + hex.EncodeToString(sum[:8])
+	}
+	return "ip:" + c.ClientIP()
+}
@@ -0,0 +1,65 @@
+package middleware
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/YeonwooSung/instagram/api-gateway/metrics"
+	"github.com/gin-gonic/gin"
+)
+
+// Deprecation describes routes under Prefix nearing end-of-life. Either date
+// may be zero; Link points clients to migration documentation.
+type Deprecation struct {
+	Prefix       string
+	DeprecatedAt time.Time
+	Sunset       time.Time
+	Link         string
+}
+
+// Deprecations returns a middleware that adds Deprecation (RFC 9745) and
+// Sunset (RFC 8594) headers to responses for deprecated routes, so clients
+// can migrate before the routes are removed. The most specific prefix wins.
+func Deprecations(notices []Deprecation) gin.HandlerFunc {
+	sorted := make([]Deprecation, len(notices))
+	copy(sorted, notices)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return len(sorted[i].Prefix) > len(sorted[j].Prefix)
+	})
+
+	return func(c *gin.Context) {
+		path := c.Request.URL.Path
+		for _, notice := range sorted {
+			if !hasPathPrefix(path, notice.Prefix) {
+				continue
+			}
+
+			header := c.Writer.Header()
+			if !notice.DeprecatedAt.IsZero() {
+				header.Set("Deprecation", "@"+strconv.FormatInt(notice.DeprecatedAt.Unix(), 10))
+			}
+			if !notice.Sunset.IsZero() {
+				header.Set("Sunset", notice.Sunset.UTC().Format(http.TimeFormat))
+			}
+			if notice.Link != "" {
+				header.Add("Link", "<"+notice.Link+`>; rel="deprecation"`)
+			}
+
+			// Tracks who still calls deprecated routes
+			metrics.Inc("gateway_deprecated_requests_total", "prefix", notice.Prefix)
+			break
+		}
+		c.Next()
+	}
+}
+
+// hasPathPrefix reports whether path is prefix or lies beneath it
+func hasPathPrefix(path, prefix string) bool {
+	if !strings.HasPrefix(path, prefix) {
+		return false
+	}
+	return len(path) == len(prefix) || strings.HasSuffix(prefix, "/") || path[len(prefix)] == '/'
+}
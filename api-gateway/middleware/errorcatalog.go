@@ -0,0 +1,77 @@
+package middleware
+
+import (
+	"net/http"
+	"sort"
+)
+
+// retryableStatuses are the error statuses a client may retry as is, after
+// Retry-After when present
+var retryableStatuses = map[int]bool{
+	http.StatusRequestTimeout:     true,
+	http.StatusTooManyRequests:    true,
+	http.StatusBadGateway:         true,
+	http.StatusServiceUnavailable: true,
+	http.StatusGatewayTimeout:     true,
+}
+
+// ErrorCatalogEntry describes one error code of problem responses. Clients
+// look messages up by MessageKey in their own translations rather than
+// showing the English title.
+type ErrorCatalogEntry struct {
+	Code string `json:"code"`
+
+	// Status is zero for mapped codes that apply to any error status
+	Status     int    `json:"status,omitempty"`
+	Title      string `json:"title"`
+	Type       string `json:"type"`
+	Retryable  bool   `json:"retryable"`
+	MessageKey string `json:"message_key"`
+
+	// Mapped marks codes translated from a backend's errors by the error
+	// mapping tables
+	Mapped bool `json:"mapped,omitempty"`
+}
+
+// Catalog lists every error code the gateway answers with: the code of each
+// error status and the codes of the error mappings, ordered by status and
+// code. Codes passed through from backends unmapped are not listed.
+func (opts ProblemOptions) Catalog() []ErrorCatalogEntry {
+	var entries []ErrorCatalogEntry
+	seen := make(map[ErrorCatalogEntry]bool)
+	add := func(entry ErrorCatalogEntry) {
+		entry.Type = "about:blank"
+		if opts.TypeBase != "" {
+			entry.Type = opts.TypeBase + entry.Code
+		}
+		entry.Retryable = retryableStatuses[entry.Status]
+		entry.MessageKey = "errors." + entry.Code
+		if !seen[entry] {
+			seen[entry] = true
+			entries = append(entries, entry)
+		}
+	}
+
+	for status, code := range defaultCodes {
+		add(ErrorCatalogEntry{Code: code, Status: status, Title: http.StatusText(status)})
+	}
+	for _, mapping := range opts.Mappings {
+		if mapping.Code == "" {
+			continue
+		}
+		add(ErrorCatalogEntry{
+			Code:   mapping.Code,
+			Status: mapping.Status,
+			Title:  orDefault(mapping.Title, http.StatusText(mapping.Status)),
+			Mapped: true,
+		})
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Status != entries[j].Status {
+			return entries[i].Status < entries[j].Status
+		}
+		return entries[i].Code < entries[j].Code
+	})
+	return entries
+}
@@ -0,0 +1,190 @@
+package middleware
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/YeonwooSung/instagram/api-gateway/metrics"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Experiment is an A/B test users are deterministically bucketed into
+type Experiment struct {
+	Name     string    `json:"name"`
+	Variants []Variant `json:"variants"`
+
+	// Paths limits the experiment to request paths with these prefixes (all when empty)
+	Paths []string `json:"paths,omitempty"`
+
+	// AA marks an A/A test: its variants are served identically and never
+	// forwarded to backends, so any difference between their metrics is noise
+	// in the bucketing or the statistics built on them
+	AA bool `json:"aa,omitempty"`
+}
+
+// Variant is one arm of an experiment; Weight is its relative share of users
+type Variant struct {
+	Name   string `json:"name"`
+	Weight int    `json:"weight"`
+}
+
+// Experiments holds the active experiment definitions
+type Experiments struct {
+	mu          sync.RWMutex
+	experiments []Experiment
+}
+
+// NewExperiments creates an empty experiment set
+func NewExperiments() *Experiments {
+	return &Experiments{}
+}
+
+// Set replaces the active experiments
+func (e *Experiments) Set(experiments []Experiment) {
+	sort.Slice(experiments, func(i, j int) bool {
+		return experiments[i].Name < experiments[j].Name
+	})
+
+	e.mu.Lock()
+	e.experiments = experiments
+	e.mu.Unlock()
+}
+
+// Assign middleware buckets the authenticated user into every experiment
+// covering the request path, by a hash of user ID and experiment name, and
+// forwards the result as "X-Experiment: name=variant; ...". A/A experiments
+// are bucketed but not forwarded. The user comes from an earlier auth
+// middleware or, failing that, a valid bearer token; anonymous requests are
+// not bucketed. Client-supplied X-Experiment headers are always dropped.
+//
+// Each bucketed request is counted per experiment and variant in
+// gateway_experiment_requests_total{experiment,variant,class}, with its
+// latency summed in gateway_experiment_duration_ms_total.
+func (e *Experiments) Assign(secrets func() []string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Request.Header.Del("X-Experiment")
+
+		e.mu.RLock()
+		experiments := e.experiments
+		e.mu.RUnlock()
+
+		var matched []Experiment
+		for _, experiment := range experiments {
+			if experiment.covers(c.Request.URL.Path) {
+				matched = append(matched, experiment)
+			}
+		}
+		if len(matched) == 0 {
+			c.Next()
+			return
+		}
+
+		userID, ok := requestUserID(c, secrets)
+		if !ok {
+			c.Next()
+			return
+		}
+
+		assignments := make(map[string]string, len(matched))
+		parts := make([]string, 0, len(matched))
+		for _, experiment := range matched {
+			variant := experiment.bucket(userID)
+			assignments[experiment.Name] = variant
+			if !experiment.AA {
+				parts = append(parts, experiment.Name+"="+variant)
+			}
+		}
+
+		c.Set("experiments", assignments)
+		if len(parts) > 0 {
+			c.Request.Header.Set("X-Experiment", strings.Join(parts, "; "))
+		}
+		start := time.Now()
+		c.Next()
+
+		elapsed := time.Since(start).Milliseconds()
+		class := strconv.Itoa(c.Writer.Status()/100) + "xx"
+		for name, variant := range assignments {
+			metrics.Inc("gateway_experiment_requests_total", "experiment", name, "variant", variant, "class", class)
+			metrics.Add("gateway_experiment_duration_ms_total", elapsed, "experiment", name, "variant", variant)
+		}
+	}
+}
+
+func (x Experiment) covers(path string) bool {
+	if len(x.Paths) == 0 {
+		return true
+	}
+	for _, prefix := range x.Paths {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// bucket picks the user's variant; the same user always gets the same one
+// while the variants and weights are unchanged
+func (x Experiment) bucket(userID string) string {
+	total := 0
+	for _, variant := range x.Variants {
+		total += variant.Weight
+	}
+	if total <= 0 {
+		return x.Variants[0].Name
+	}
+
+	sum := sha256.Sum256([]byte(userID + ":" + x.Name))
+	point := int(binary.BigEndian.Uint64(sum[:8]) % uint64(total))
+	for _, variant := range x.Variants {
+		if point < variant.Weight {
+			return variant.Name
+		}
+		point -= variant.Weight
+	}
+	return x.Variants[len(x.Variants)-1].Name
+}
+
+// requestUserID returns the verified user ID of the request, if any. A
+// bearer token is only verified once per request.
+func requestUserID(c *gin.Context, secrets func() []string) (string, bool) {
+	if userID, exists := c.Get("user_id"); exists {
+		return fmt.Sprintf("%v", userID), true
+	}
+	if cached, exists := c.Get("token_user_id"); exists {
+		userID := cached.(string)
+		return userID, userID != ""
+	}
+
+	userID := tokenUserID(c.GetHeader("Authorization"), secrets)
+	c.Set("token_user_id", userID)
+	return userID, userID != ""
+}
+
+// tokenUserID returns the user_id claim of a valid bearer token, or ""
+func tokenUserID(authHeader string, secrets func() []string) string {
+	tokenString, ok := strings.CutPrefix(authHeader, "Bearer ")
+	if !ok {
+		return ""
+	}
+	token, err := jwt.Parse(tokenString, hmacKeyFunc(secrets))
+	if err != nil || !token.Valid {
+		return ""
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return ""
+	}
+	userID, ok := claims["user_id"]
+	if !ok {
+		return ""
+	}
+	return fmt.Sprintf("%v", userID)
+}
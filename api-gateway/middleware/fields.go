@@ -0,0 +1,159 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/YeonwooSung/instagram/api-gateway/metrics"
+	"github.com/gin-gonic/gin"
+)
+
+// maxFilterFields bounds the paths one fields parameter may select
+const maxFilterFields = 100
+
+// fieldTree is a parsed fields selection. A path ending at a key selects its
+// whole value, which is marked by a nil subtree.
+type fieldTree map[string]fieldTree
+
+// FilterFields middleware prunes successful JSON responses to the fields the
+// client lists in the "fields" query parameter, e.g.
+// ?fields=id,caption,author.username. Dotted paths select nested fields, and
+// arrays are filtered element by element, so "posts.id" keeps the id of every
+// post. The parameter is consumed by the gateway and not forwarded upstream.
+func FilterFields() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		query := c.Request.URL.Query()
+		raw, ok := query["fields"]
+		if !ok || c.GetHeader("Range") != "" {
+			c.Next()
+			return
+		}
+
+		tree, ok := parseFields(strings.Join(raw, ","))
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid fields parameter"})
+			c.Abort()
+			return
+		}
+
+		query.Del("fields")
+		c.Request.URL.RawQuery = query.Encode()
+
+		// The body is rewritten, so it must arrive uncompressed
+		c.Request.Header.Del("Accept-Encoding")
+
+		writer := newBufferedWriter(c.Writer)
+		c.Writer = writer
+		defer func() { c.Writer = writer.ResponseWriter }()
+
+		c.Next()
+
+		body := writer.body.Bytes()
+		contentType, _, _ := mime.ParseMediaType(writer.Header().Get("Content-Type"))
+		if writer.status < 200 || writer.status >= 300 || contentType != "application/json" || len(body) == 0 {
+			writer.flush(body)
+			return
+		}
+
+		filtered, err := filterJSON(json.RawMessage(body), tree)
+		if err != nil {
+			// Not valid JSON after all; pass it through as the upstream sent it
+			writer.flush(body)
+			return
+		}
+		metrics.Inc("gateway_field_filtered_responses_total")
+		writer.flush(filtered)
+	}
+}
+
+// parseFields parses a comma-separated list of dotted paths
+func parseFields(raw string) (fieldTree, bool) {
+	paths := strings.Split(raw, ",")
+	if len(paths) > maxFilterFields {
+		return nil, false
+	}
+
+	tree := fieldTree{}
+	for _, path := range paths {
+		path = strings.TrimSpace(path)
+		if path == "" {
+			return nil, false
+		}
+		node := tree
+		keys := strings.Split(path, ".")
+		for i, key := range keys {
+			if key == "" {
+				return nil, false
+			}
+			child, exists := node[key]
+			if exists && child == nil {
+				// The whole value is already selected
+				break
+			}
+			if i == len(keys)-1 {
+				node[key] = nil
+				break
+			}
+			if !exists {
+				child = fieldTree{}
+				node[key] = child
+			}
+			node = child
+		}
+	}
+	return tree, true
+}
+
+// filterJSON keeps the selected fields of objects, filtering each element of
+// arrays. Scalars are returned as they are.
+func filterJSON(data json.RawMessage, tree fieldTree) (json.RawMessage, error) {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 {
+		return data, nil
+	}
+
+	switch data[0] {
+	case '{':
+		var object map[string]json.RawMessage
+		if err := json.Unmarshal(data, &object); err != nil {
+			return nil, err
+		}
+		kept := make(map[string]json.RawMessage, len(tree))
+		for key, subtree := range tree {
+			value, ok := object[key]
+			if !ok {
+				continue
+			}
+			if subtree != nil {
+				var err error
+				if value, err = filterJSON(value, subtree); err != nil {
+					return nil, err
+				}
+			}
+			kept[key] = value
+		}
+		return json.Marshal(kept)
+	case '[':
+		var items []json.RawMessage
+		if err := json.Unmarshal(data, &items); err != nil {
+			return nil, err
+		}
+		for i, item := range items {
+			filtered, err := filterJSON(item, tree)
+			if err != nil {
+				return nil, err
+			}
+			items[i] = filtered
+		}
+		return json.Marshal(items)
+	}
+
+	if !json.Valid(data) {
+		return nil, errors.New("invalid JSON value")
+	}
+	return data, nil
+}
@@ -0,0 +1,132 @@
+package middleware
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Flag is a feature flag evaluated per user. A disabled flag is off for
+// everyone; an enabled flag is on for the listed users and for Percent percent
+// of the others, bucketed by a hash of user ID and flag name. At 100 percent
+// it is on for anonymous requests too.
+type Flag struct {
+	Name    string   `json:"name"`
+	Enabled bool     `json:"enabled"`
+	Percent int      `json:"percent"`
+	Users   []string `json:"users,omitempty"`
+}
+
+// Flags holds the feature flag definitions
+type Flags struct {
+	mu    sync.RWMutex
+	flags map[string]Flag
+}
+
+// NewFlags creates an empty flag store
+func NewFlags() *Flags {
+	return &Flags{flags: make(map[string]Flag)}
+}
+
+// Set replaces the flag definitions
+func (f *Flags) Set(flags []Flag) {
+	byName := make(map[string]Flag, len(flags))
+	for _, flag := range flags {
+		byName[flag.Name] = flag
+	}
+
+	f.mu.Lock()
+	f.flags = byName
+	f.mu.Unlock()
+}
+
+// Evaluate middleware evaluates every flag for the request's user and
+// forwards the results as "X-Feature-Flags: name=on; other=off". Results are
+// also stored in the context as "feature_flags" for Gate. Client-supplied
+// X-Feature-Flags headers are always dropped.
+func (f *Flags) Evaluate(secrets func() []string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Request.Header.Del("X-Feature-Flags")
+
+		f.mu.RLock()
+		flags := f.flags
+		f.mu.RUnlock()
+		if len(flags) == 0 {
+			c.Next()
+			return
+		}
+
+		userID, _ := requestUserID(c, secrets)
+		results := make(map[string]bool, len(flags))
+		parts := make([]string, 0, len(flags))
+		for name, flag := range flags {
+			on := flag.on(userID)
+			results[name] = on
+			state := "off"
+			if on {
+				state = "on"
+			}
+			parts = append(parts, name+"="+state)
+		}
+		sort.Strings(parts)
+
+		c.Set("feature_flags", results)
+		c.Request.Header.Set("X-Feature-Flags", strings.Join(parts, "; "))
+		c.Next()
+	}
+}
+
+// Gate middleware hides a route (404) from users the flag is off for. It
+// uses the result of Evaluate when available and evaluates the flag itself
+// otherwise; unknown flags are off.
+func (f *Flags) Gate(name string, secrets func() []string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var on bool
+		if results, exists := c.Get("feature_flags"); exists {
+			on = results.(map[string]bool)[name]
+		} else {
+			f.mu.RLock()
+			flag, ok := f.flags[name]
+			f.mu.RUnlock()
+			if ok {
+				userID, _ := requestUserID(c, secrets)
+				on = flag.on(userID)
+			}
+		}
+
+		if !on {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "Route not found",
+			})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// on reports whether the flag is on for userID ("" for anonymous requests)
+func (x Flag) on(userID string) bool {
+	if !x.Enabled {
+		return false
+	}
+	if x.Percent >= 100 {
+		return true
+	}
+	if userID == "" {
+		return false
+	}
+	for _, user := range x.Users {
+		if user == userID {
+			return true
+		}
+	}
+
+	sum := sha256.Sum256([]byte(userID + ":" + x.Name))
+	return int(binary.BigEndian.Uint64(sum[:8])%100) < x.Percent
+}
@@ -0,0 +1,69 @@
+package middleware
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/YeonwooSung/instagram/api-gateway/metrics"
+	"github.com/gin-gonic/gin"
+)
+
+// GeoQuery validates the "lat", "lng" and "radius" (meters) parameters of a
+// location query before it reaches the location service. Coordinates come in
+// pairs and must be within latitude and longitude bounds; required demands
+// them. The radius, if given, must be a whole number of meters up to
+// maxRadius. Coordinates are forwarded with at most 6 decimals (~0.1 m), so
+// equivalent queries look the same upstream.
+func GeoQuery(required bool, maxRadius int) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		query := c.Request.URL.Query()
+		rawLat, rawLng := query.Get("lat"), query.Get("lng")
+
+		if rawLat != "" || rawLng != "" || required {
+			lat, err := parseCoordinate(rawLat, 90)
+			if err != nil {
+				rejectGeo(c, "latitude", "lat "+err.Error())
+				return
+			}
+			lng, err := parseCoordinate(rawLng, 180)
+			if err != nil {
+				rejectGeo(c, "longitude", "lng "+err.Error())
+				return
+			}
+			query.Set("lat", strconv.FormatFloat(lat, 'f', -1, 64))
+			query.Set("lng", strconv.FormatFloat(lng, 'f', -1, 64))
+		}
+
+		if raw := query.Get("radius"); raw != "" {
+			radius, err := strconv.Atoi(raw)
+			if err != nil || radius <= 0 || radius > maxRadius {
+				rejectGeo(c, "radius", fmt.Sprintf("radius must be between 1 and %d meters", maxRadius))
+				return
+			}
+		}
+
+		c.Request.URL.RawQuery = query.Encode()
+		c.Next()
+	}
+}
+
+// parseCoordinate reads a latitude or longitude within ±bound degrees,
+// rounded to 6 decimals
+func parseCoordinate(raw string, bound float64) (float64, error) {
+	if raw == "" {
+		return 0, fmt.Errorf("is required")
+	}
+	value, err := strconv.ParseFloat(raw, 64)
+	if err != nil || math.IsNaN(value) || value < -bound || value > bound {
+		return 0, fmt.Errorf("must be a number between %g and %g", -bound, bound)
+	}
+	return math.Round(value*1e6) / 1e6, nil
+}
+
+func rejectGeo(c *gin.Context, reason, message string) {
+	metrics.Inc("gateway_geo_rejections_total", "reason", reason)
+	c.JSON(http.StatusBadRequest, gin.H{"error": message})
+	c.Abort()
+}
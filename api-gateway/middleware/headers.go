@@ -0,0 +1,213 @@
+package middleware
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/YeonwooSung/instagram/api-gateway/metrics"
+	"github.com/gin-gonic/gin"
+)
+
+// trustHeaderPrefixes mark headers upstreams trust as set by the gateway from
+// verified context: the authenticated caller (X-User-ID, X-Username, ...) and
+// internal routing metadata
+var trustHeaderPrefixes = []string{"X-User-", "X-Internal-"}
+
+// trustHeaderNames are the other headers only the gateway may set
+var trustHeaderNames = []string{"X-Username", "X-Calling-Service", "X-Gateway-Signature", "X-Privacy-Context"}
+
+// StripTrustHeaders middleware removes every trust header, and any gateway
+// signature, from inbound client requests. Only the gateway sets them again,
+// after authentication, so clients cannot impersonate other users.
+func StripTrustHeaders() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		for name := range c.Request.Header {
+			if match, ok := trustHeader(name); ok {
+				// Labelled by the matched name or prefix, since the rest of
+				// the header name is chosen by the client
+				metrics.Inc("gateway_trust_headers_stripped_total", "header", match)
+				c.Request.Header.Del(name)
+			}
+		}
+		c.Next()
+	}
+}
+
+func isTrustHeader(name string) bool {
+	_, ok := trustHeader(name)
+	return ok
+}
+
+// trustHeader returns the trust header name or prefix that name matches
+func trustHeader(name string) (string, bool) {
+	for _, trusted := range trustHeaderNames {
+		if strings.EqualFold(name, trusted) {
+			return trusted, true
+		}
+	}
+	for _, prefix := range trustHeaderPrefixes {
+		if len(name) >= len(prefix) && strings.EqualFold(name[:len(prefix)], prefix) {
+			return prefix, true
+		}
+	}
+	return "", false
+}
+
+// HeaderOps are header edits, applied in the order rename, remove, set, add
+type HeaderOps struct {
+	// Rename moves a header's values to another name
+	Rename map[string]string
+
+	// Remove deletes headers
+	Remove []string
+
+	// Set replaces a header's values with a static value
+	Set map[string]string
+
+	// Add appends a static value to a header
+	Add map[string]string
+}
+
+// HeaderTransform edits the request headers sent upstream and the response
+// headers sent to clients for requests under Prefix
+type HeaderTransform struct {
+	Prefix   string
+	Request  HeaderOps
+	Response HeaderOps
+
+	// Experiment (optionally with Variant) limits the response edits to users
+	// bucketed into it, and Flag to users with that feature flag on, so
+	// clients can adapt to backend experiments. Buckets and flags are only
+	// known further down the chain, so conditional transforms never edit
+	// requests.
+	Experiment string
+	Variant    string
+	Flag       string
+}
+
+// TransformHeaders returns a middleware applying every transform whose prefix
+// matches the request path, least specific first so narrower route groups can
+// override broader ones
+func TransformHeaders(transforms []HeaderTransform) gin.HandlerFunc {
+	sorted := make([]HeaderTransform, len(transforms))
+	copy(sorted, transforms)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return len(sorted[i].Prefix) < len(sorted[j].Prefix)
+	})
+
+	return func(c *gin.Context) {
+		var matched []HeaderTransform
+		for _, transform := range sorted {
+			if hasPathPrefix(c.Request.URL.Path, transform.Prefix) {
+				matched = append(matched, transform)
+			}
+		}
+		if len(matched) == 0 {
+			c.Next()
+			return
+		}
+
+		for _, transform := range matched {
+			if !transform.conditional() {
+				transform.Request.apply(c.Request.Header)
+			}
+		}
+
+		// Response edits must land before the headers are written
+		writer := &hookWriter{
+			ResponseWriter: c.Writer,
+			before: func(w gin.ResponseWriter) {
+				for _, transform := range matched {
+					if transform.applies(c) {
+						transform.Response.apply(w.Header())
+					}
+				}
+			},
+		}
+		c.Writer = writer
+		c.Next()
+
+		// Bodiless responses are written by gin after the chain returns
+		if !writer.ResponseWriter.Written() {
+			writer.runHook()
+		}
+	}
+}
+
+func (t HeaderTransform) conditional() bool {
+	return t.Experiment != "" || t.Flag != ""
+}
+
+// applies reports whether the request meets the transform's experiment and
+// flag conditions
+func (t HeaderTransform) applies(c *gin.Context) bool {
+	if t.Experiment != "" {
+		value, _ := c.Get("experiments")
+		assignments, _ := value.(map[string]string)
+		variant, bucketed := assignments[t.Experiment]
+		if !bucketed || (t.Variant != "" && variant != t.Variant) {
+			return false
+		}
+	}
+	if t.Flag != "" {
+		value, _ := c.Get("feature_flags")
+		flags, _ := value.(map[string]bool)
+		if !flags[t.Flag] {
+			return false
+		}
+	}
+	return true
+}
+
+func (ops HeaderOps) apply(header http.Header) {
+	for from, to := range ops.Rename {
+		if values := header.Values(from); len(values) > 0 {
+			header.Del(from)
+			header[http.CanonicalHeaderKey(to)] = values
+		}
+	}
+	for _, name := range ops.Remove {
+		header.Del(name)
+	}
+	for name, value := range ops.Set {
+		header.Set(name, value)
+	}
+	for name, value := range ops.Add {
+		header.Add(name, value)
+	}
+}
+
+// hookWriter calls before once, just before the response headers are written
+type hookWriter struct {
+	gin.ResponseWriter
+	before func(w gin.ResponseWriter)
+	done   bool
+}
+
+func (w *hookWriter) runHook() {
+	if !w.done {
+		w.done = true
+		w.before(w.ResponseWriter)
+	}
+}
+
+func (w *hookWriter) WriteHeaderNow() {
+	w.runHook()
+	w.ResponseWriter.WriteHeaderNow()
+}
+
+func (w *hookWriter) Write(b []byte) (int, error) {
+	w.runHook()
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *hookWriter) WriteString(s string) (int, error) {
+	w.runHook()
+	return w.ResponseWriter.WriteString(s)
+}
+
+func (w *hookWriter) Flush() {
+	w.runHook()
+	w.ResponseWriter.Flush()
+}
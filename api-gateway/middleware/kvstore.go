@@ -0,0 +1,157 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// memoryStoreLimit caps the keys a feature keeps in memory while Redis is down
+const memoryStoreLimit = 100000
+
+// errKeyNotFound is returned by kvStore.Get for missing or expired keys
+var errKeyNotFound = errors.New("key not found")
+
+// kvStore is the key-value subset Redis-backed middleware needs, so they can
+// fall back to instance memory while Redis is unavailable
+type kvStore interface {
+	SetNX(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error)
+	Get(ctx context.Context, key string) ([]byte, error)
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	Del(ctx context.Context, key string) error
+
+	// Incr adds one to the counter at key, created with ttl, and returns it
+	Incr(ctx context.Context, key string, ttl time.Duration) (int64, error)
+}
+
+// redisStore is the shared kvStore. Connection errors mark Redis down in
+// health so following requests degrade without waiting on Redis.
+type redisStore struct {
+	health *RedisHealth
+}
+
+func (s redisStore) SetNX(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error) {
+	ok, err := s.health.client.SetNX(ctx, key, value, ttl).Result()
+	return ok, s.check(err)
+}
+
+func (s redisStore) Get(ctx context.Context, key string) ([]byte, error) {
+	value, err := s.health.client.Get(ctx, key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, errKeyNotFound
+	}
+	return value, s.check(err)
+}
+
+func (s redisStore) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return s.check(s.health.client.Set(ctx, key, value, ttl).Err())
+}
+
+func (s redisStore) Del(ctx context.Context, key string) error {
+	return s.check(s.health.client.Del(ctx, key).Err())
+}
+
+// Incr creates the counter with its TTL if missing and increments it in one
+// transaction, so a counter never outlives its window
+func (s redisStore) Incr(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	pipe := s.health.client.TxPipeline()
+	pipe.SetNX(ctx, key, 0, ttl)
+	count := pipe.Incr(ctx, key)
+	_, err := pipe.Exec(ctx)
+	return count.Val(), s.check(err)
+}
+
+func (s redisStore) check(err error) error {
+	if err != nil && !errors.Is(err, context.Canceled) {
+		s.health.report(err)
+	}
+	return err
+}
+
+// memoryStore is an in-process kvStore with expiring keys, bounded to limit
+// keys. When full, expired keys are swept and then arbitrary keys evicted.
+type memoryStore struct {
+	mu    sync.Mutex
+	items map[string]memoryItem
+	limit int
+}
+
+type memoryItem struct {
+	value   []byte
+	expires time.Time
+}
+
+func newMemoryStore(limit int) *memoryStore {
+	return &memoryStore{items: make(map[string]memoryItem), limit: limit}
+}
+
+func (s *memoryStore) SetNX(_ context.Context, key string, value []byte, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if item, ok := s.items[key]; ok && time.Now().Before(item.expires) {
+		return false, nil
+	}
+	s.put(key, value, ttl)
+	return true, nil
+}
+
+func (s *memoryStore) Get(_ context.Context, key string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	item, ok := s.items[key]
+	if !ok || !time.Now().Before(item.expires) {
+		return nil, errKeyNotFound
+	}
+	return item.value, nil
+}
+
+func (s *memoryStore) Set(_ context.Context, key string, value []byte, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.put(key, value, ttl)
+	return nil
+}
+
+func (s *memoryStore) Del(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.items, key)
+	return nil
+}
+
+func (s *memoryStore) Incr(_ context.Context, key string, ttl time.Duration) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	item, ok := s.items[key]
+	if !ok || !time.Now().Before(item.expires) {
+		s.put(key, []byte("1"), ttl)
+		return 1, nil
+	}
+	count, _ := strconv.ParseInt(string(item.value), 10, 64)
+	count++
+	item.value = []byte(strconv.FormatInt(count, 10))
+	s.items[key] = item
+	return count, nil
+}
+
+func (s *memoryStore) put(key string, value []byte, ttl time.Duration) {
+	if _, exists := s.items[key]; !exists && len(s.items) >= s.limit {
+		now := time.Now()
+		for k, item := range s.items {
+			if !now.Before(item.expires) {
+				delete(s.items, k)
+			}
+		}
+		for k := range s.items {
+			if len(s.items) < s.limit {
+				break
+			}
+			delete(s.items, k)
+		}
+	}
+	s.items[key] = memoryItem{value: value, expires: time.Now().Add(ttl)}
+}
@@ -3,6 +3,7 @@ package middleware
 import (
 	"time"
 
+	"github.com/YeonwooSung/instagram/api-gateway/accounting"
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
 )
@@ -21,7 +22,7 @@ func Logger(logger *zap.Logger) gin.HandlerFunc {
 		latency := time.Since(start)
 
 		// Log request details
-		logger.Info("HTTP Request",
+		fields := []zap.Field{
 			zap.String("method", c.Request.Method),
 			zap.String("path", path),
 			zap.String("query", query),
@@ -30,7 +31,18 @@ func Logger(logger *zap.Logger) gin.HandlerFunc {
 			zap.String("client_ip", c.ClientIP()),
 			zap.String("user_agent", c.Request.UserAgent()),
 			zap.Int("body_size", c.Writer.Size()),
-		)
+			zap.String("request_id", c.GetString("request_id")),
+		}
+
+		// Resource usage of requests running under a budget
+		if usage := accounting.FromContext(c.Request.Context()); usage != nil {
+			snapshot := usage.Snapshot()
+			fields = append(fields,
+				zap.Int64("bytes_processed", snapshot.Bytes),
+				zap.Int64("upstream_calls", snapshot.UpstreamCalls),
+			)
+		}
+		logger.Info("HTTP Request", fields...)
 
 		// Log errors if any
 		if len(c.Errors) > 0 {
@@ -0,0 +1,167 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"time"
+
+	"github.com/YeonwooSung/instagram/api-gateway/metrics"
+	"github.com/gin-gonic/gin"
+)
+
+// maxOAuthFormBytes bounds the form read from a provider's POST callback
+const maxOAuthFormBytes = 64 << 10
+
+// OAuthProvider is a social login provider and the origins its callbacks come from
+type OAuthProvider struct {
+	Name    string
+	Origins []string
+}
+
+// DefaultOAuthProviders are the social login providers the gateway knows.
+// Apple posts its callback from its own origin (response_mode form_post);
+// the others redirect back with a GET.
+var DefaultOAuthProviders = []OAuthProvider{
+	{Name: "google", Origins: []string{"https://accounts.google.com"}},
+	{Name: "apple", Origins: []string{"https://appleid.apple.com"}},
+	{Name: "facebook", Origins: []string{"https://www.facebook.com", "https://m.facebook.com"}},
+}
+
+// OAuth protects social login against login CSRF: the gateway, not the auth
+// service, issues each flow's state parameter, binds it to the browser with a
+// cookie, and admits a callback only if its state matches that cookie and it
+// comes from the provider.
+type OAuth struct {
+	providers map[string]OAuthProvider
+	ttl       time.Duration
+}
+
+// NewOAuth creates the social login guard for providers; a flow must finish
+// within ttl of starting
+func NewOAuth(providers []OAuthProvider, ttl time.Duration) *OAuth {
+	byName := make(map[string]OAuthProvider, len(providers))
+	for _, provider := range providers {
+		byName[provider.Name] = provider
+	}
+	return &OAuth{providers: byName, ttl: ttl}
+}
+
+// Login middleware starts a flow for the :provider path parameter: it issues
+// a random state, sets it as a cookie scoped to the provider's routes, and
+// forwards it to the auth service as the "state" query parameter, replacing
+// any the client sent. Unknown providers get 404.
+func (o *OAuth) Login() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		provider, ok := o.providers[c.Param("provider")]
+		if !ok {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Unknown login provider"})
+			c.Abort()
+			return
+		}
+
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start login"})
+			c.Abort()
+			return
+		}
+		state := base64.RawURLEncoding.EncodeToString(b)
+		o.setState(c, provider.Name, state, int(o.ttl.Seconds()))
+
+		query := c.Request.URL.Query()
+		query.Set("state", state)
+		c.Request.URL.RawQuery = query.Encode()
+		c.Next()
+	}
+}
+
+// Callback middleware admits a provider's callback only if it has no foreign
+// Origin (or, without one, Referer) and its state, from the query or the
+// posted form, matches the flow's cookie. The cookie is cleared either way,
+// so a state is good for one callback.
+func (o *OAuth) Callback() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		provider, ok := o.providers[c.Param("provider")]
+		if !ok {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Unknown login provider"})
+			c.Abort()
+			return
+		}
+
+		if !provider.allows(c.Request) {
+			o.reject(c, provider.Name, "origin", "Login callback from an unexpected origin")
+			return
+		}
+
+		state := c.Query("state")
+		if c.Request.Method == http.MethodPost {
+			state = o.formState(c)
+		}
+		cookie, _ := c.Cookie(oauthCookie(provider.Name))
+		o.setState(c, provider.Name, "", -1)
+		if state == "" || cookie == "" || subtle.ConstantTimeCompare([]byte(state), []byte(cookie)) != 1 {
+			o.reject(c, provider.Name, "state", "Login session expired or invalid, please try again")
+			return
+		}
+
+		metrics.Inc("gateway_oauth_callbacks_total", "provider", provider.Name, "result", "accepted")
+		c.Next()
+	}
+}
+
+func (o *OAuth) reject(c *gin.Context, provider, reason, message string) {
+	metrics.Inc("gateway_oauth_callbacks_total", "provider", provider, "result", reason)
+	c.JSON(http.StatusForbidden, gin.H{"error": message})
+	c.Abort()
+}
+
+// setState sets, or with a negative maxAge clears, a provider's state
+// cookie. It is SameSite=None because Apple's callback is a cross-site POST.
+func (o *OAuth) setState(c *gin.Context, provider, state string, maxAge int) {
+	c.SetSameSite(http.SameSiteNoneMode)
+	c.SetCookie(oauthCookie(provider), state, maxAge, "/api/v1/auth/oauth/"+provider, "", true, true)
+}
+
+// formState reads the state from a posted form, leaving the body in place for
+// the upstream
+func (o *OAuth) formState(c *gin.Context) string {
+	if c.Request.Body == nil {
+		return ""
+	}
+	body, _ := io.ReadAll(io.LimitReader(c.Request.Body, maxOAuthFormBytes))
+	c.Request.Body = &primedBody{Reader: io.MultiReader(bytes.NewReader(body), c.Request.Body), body: c.Request.Body}
+
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		return ""
+	}
+	return form.Get("state")
+}
+
+// allows reports whether a callback request comes from the provider, judged
+// by its Origin, or its Referer when browsers send no Origin. Requests with
+// neither are judged by their state alone.
+func (p OAuthProvider) allows(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		referer, err := url.Parse(r.Header.Get("Referer"))
+		if err != nil {
+			return false
+		}
+		if referer.Host == "" {
+			return true
+		}
+		origin = referer.Scheme + "://" + referer.Host
+	}
+	return slices.Contains(p.Origins, origin)
+}
+
+func oauthCookie(provider string) string {
+	return "oauth_state_" + provider
+}
@@ -0,0 +1,204 @@
+package middleware
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/YeonwooSung/instagram/api-gateway/cache"
+	"github.com/YeonwooSung/instagram/api-gateway/metrics"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// CursorOptions configures cursor pagination for an offset-paginated route
+type CursorOptions struct {
+	// Items is the field of the upstream's JSON object holding the page; a
+	// response that is a JSON array is the page itself
+	Items string
+
+	// DefaultLimit and MaxLimit bound the page size clients ask for
+	DefaultLimit int
+	MaxLimit     int
+
+	// TTL is how long a cursor stays valid
+	TTL time.Duration
+}
+
+// cursorPayload is the sealed content of a cursor
+type cursorPayload struct {
+	Offset  int   `json:"o"`
+	Expires int64 `json:"e"`
+}
+
+// CursorPaginator exposes opaque cursors to clients of backends that only
+// support offset pagination, so the public API has one pagination contract
+// while backends migrate. Cursors are sealed so clients can neither read nor
+// forge the offsets behind them.
+type CursorPaginator struct {
+	cipher *cache.Cipher
+	logger *zap.Logger
+}
+
+// NewCursorPaginator creates a paginator sealing cursors with cipher
+func NewCursorPaginator(cipher *cache.Cipher, logger *zap.Logger) *CursorPaginator {
+	return &CursorPaginator{
+		cipher: cipher,
+		logger: logger,
+	}
+}
+
+// Paginate translates the "cursor" and "limit" query parameters into the
+// "offset" and "limit" the backend understands. One extra item is requested
+// to learn whether another page exists; it is trimmed from the response and
+// replaced by "next_cursor" (and the X-Next-Cursor header), null on the last page.
+func (p *CursorPaginator) Paginate(opts CursorOptions) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.Method != http.MethodGet {
+			c.Next()
+			return
+		}
+
+		query := c.Request.URL.Query()
+
+		limit := opts.DefaultLimit
+		if raw := query.Get("limit"); raw != "" {
+			n, err := strconv.Atoi(raw)
+			if err != nil || n <= 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+				c.Abort()
+				return
+			}
+			limit = min(n, opts.MaxLimit)
+		}
+
+		// A cursor only works on the path it was issued for
+		aad := []byte(c.Request.URL.Path)
+
+		offset := 0
+		if raw := query.Get("cursor"); raw != "" {
+			payload, ok := p.open(raw, aad)
+			if !ok {
+				metrics.Inc("gateway_pagination_cursors_total", "route", c.FullPath(), "result", "invalid")
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired cursor"})
+				c.Abort()
+				return
+			}
+			offset = payload.Offset
+		}
+
+		// Clients page with cursors only
+		query.Del("cursor")
+		query.Set("offset", strconv.Itoa(offset))
+		query.Set("limit", strconv.Itoa(limit+1))
+		c.Request.URL.RawQuery = query.Encode()
+
+		// The page is rewritten, so it must arrive uncompressed
+		c.Request.Header.Del("Accept-Encoding")
+
+		writer := newBufferedWriter(c.Writer)
+		c.Writer = writer
+		defer func() { c.Writer = writer.ResponseWriter }()
+
+		c.Next()
+
+		body := writer.body.Bytes()
+		if writer.Status() != http.StatusOK {
+			writer.flush(body)
+			return
+		}
+
+		next, err := p.seal(cursorPayload{
+			Offset:  offset + limit,
+			Expires: time.Now().Add(opts.TTL).Unix(),
+		}, aad)
+		if err != nil {
+			p.logger.Error("Failed to seal cursor", zap.Error(err))
+			writer.flush(body)
+			return
+		}
+
+		page, more, err := paginate(body, opts.Items, limit, next)
+		if err != nil {
+			// Unexpected shape; better an untrimmed page than none
+			p.logger.Warn("Failed to paginate upstream response",
+				zap.String("route", c.FullPath()),
+				zap.Error(err),
+			)
+			writer.flush(body)
+			return
+		}
+		if more {
+			writer.Header().Set("X-Next-Cursor", next)
+		}
+		metrics.Inc("gateway_pagination_cursors_total", "route", c.FullPath(), "result", "ok")
+		writer.flush(page)
+	}
+}
+
+// paginate trims the page in body to limit items and reports whether more
+// exist. Object responses get a "next_cursor" field; arrays only the header.
+func paginate(body []byte, field string, limit int, next string) ([]byte, bool, error) {
+	var items []json.RawMessage
+	if err := json.Unmarshal(body, &items); err == nil {
+		if len(items) <= limit {
+			return body, false, nil
+		}
+		page, err := json.Marshal(items[:limit])
+		return page, true, err
+	}
+
+	var envelope map[string]json.RawMessage
+	if err := json.Unmarshal(body, &envelope); err != nil {
+		return nil, false, err
+	}
+	if err := json.Unmarshal(envelope[field], &items); err != nil {
+		return nil, false, fmt.Errorf("field %q: %w", field, err)
+	}
+
+	more := len(items) > limit
+	envelope["next_cursor"] = json.RawMessage("null")
+	if more {
+		trimmed, err := json.Marshal(items[:limit])
+		if err != nil {
+			return nil, false, err
+		}
+		envelope[field] = trimmed
+		envelope["next_cursor"], _ = json.Marshal(next)
+	}
+
+	page, err := json.Marshal(envelope)
+	return page, more, err
+}
+
+func (p *CursorPaginator) seal(payload cursorPayload, aad []byte) (string, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return "", err
+	}
+	sealed, err := p.cipher.Seal(data, aad)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+func (p *CursorPaginator) open(cursor string, aad []byte) (cursorPayload, bool) {
+	var payload cursorPayload
+
+	sealed, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return payload, false
+	}
+	data, err := p.cipher.Open(sealed, aad)
+	if err != nil {
+		return payload, false
+	}
+	if err := json.Unmarshal(data, &payload); err != nil {
+		return payload, false
+	}
+	return payload, payload.Offset >= 0 && time.Now().Unix() < payload.Expires
+}
@@ -0,0 +1,180 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/YeonwooSung/instagram/api-gateway/metrics"
+	"github.com/gin-gonic/gin"
+)
+
+// pciRequestHeaders are the client headers forwarded on PCI-scrubbed routes;
+// gateway-set context headers are kept as well
+var pciRequestHeaders = []string{
+	"Accept",
+	"Accept-Encoding",
+	"Accept-Language",
+	"Authorization",
+	"Content-Length",
+	"Content-Type",
+	"Idempotency-Key",
+	"If-None-Match",
+	"Traceparent",
+	"Tracestate",
+	"User-Agent",
+	RequestIDHeader,
+}
+
+// pciGatewayHeaders are context headers the gateway sets itself
+var pciGatewayHeaders = []string{"X-Experiment", "X-Feature-Flags", "X-Privacy-Context", "X-Consent-"}
+
+// pciResponseHeaders reveal upstream software and are dropped from responses
+var pciResponseHeaders = []string{"Server", "X-Powered-By", "X-Runtime", "X-AspNet-Version"}
+
+// PCIScrub middleware keeps cardholder data and needless metadata off a
+// route group, in both directions. Requests keep only an allowlist of
+// headers, so cookies and arbitrary client headers never reach the upstream,
+// and are rejected with 400 if a header or query parameter carries what looks
+// like a card number: card data must go to the payment processor, never
+// through the gateway, where it would end up in logs. Responses lose headers
+// naming upstream software and are never stored by caches.
+func PCIScrub() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		for name := range c.Request.Header {
+			if !pciAllowed(name) {
+				c.Request.Header.Del(name)
+			}
+		}
+
+		for name, values := range c.Request.Header {
+			if !strings.EqualFold(name, "Authorization") && anyCardNumber(values) {
+				rejectCardData(c, "header")
+				return
+			}
+		}
+		for _, values := range c.Request.URL.Query() {
+			if anyCardNumber(values) {
+				rejectCardData(c, "query")
+				return
+			}
+		}
+
+		c.Writer = &scrubbedWriter{ResponseWriter: c.Writer}
+		c.Next()
+	}
+}
+
+func pciAllowed(name string) bool {
+	if isTrustHeader(name) {
+		return true
+	}
+	for _, allowed := range pciRequestHeaders {
+		if strings.EqualFold(name, allowed) {
+			return true
+		}
+	}
+	for _, prefix := range pciGatewayHeaders {
+		if len(name) >= len(prefix) && strings.EqualFold(name[:len(prefix)], prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func rejectCardData(c *gin.Context, where string) {
+	metrics.Inc("gateway_pci_rejections_total", "source", where)
+	c.JSON(http.StatusBadRequest, gin.H{
+		"error": "Card data must be sent to the payment processor, not the API",
+	})
+	c.Abort()
+}
+
+func anyCardNumber(values []string) bool {
+	for _, value := range values {
+		if containsCardNumber(value) {
+			return true
+		}
+	}
+	return false
+}
+
+// containsCardNumber reports whether s holds a run of 13-19 digits, possibly
+// grouped by spaces or dashes, that starts like a card number (3-6) and
+// passes the Luhn check. The leading digit keeps timestamps and most IDs
+// from matching.
+func containsCardNumber(s string) bool {
+	digits := make([]byte, 0, 19)
+	check := func() bool {
+		return len(digits) >= 13 && len(digits) <= 19 && digits[0] >= '3' && digits[0] <= '6' && luhn(digits)
+	}
+	for i := 0; i < len(s); i++ {
+		switch ch := s[i]; {
+		case ch >= '0' && ch <= '9':
+			digits = append(digits, ch)
+		case (ch == ' ' || ch == '-') && len(digits) > 0:
+		default:
+			if check() {
+				return true
+			}
+			digits = digits[:0]
+		}
+	}
+	return check()
+}
+
+func luhn(digits []byte) bool {
+	sum := 0
+	for i := range digits {
+		d := int(digits[len(digits)-1-i] - '0')
+		if i%2 == 1 {
+			if d *= 2; d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+	}
+	return sum%10 == 0
+}
+
+// scrubbedWriter strips pciResponseHeaders and forbids caching just before
+// the response header is written
+type scrubbedWriter struct {
+	gin.ResponseWriter
+	scrubbed bool
+}
+
+func (w *scrubbedWriter) scrub() {
+	if w.scrubbed {
+		return
+	}
+	w.scrubbed = true
+	for _, name := range pciResponseHeaders {
+		w.Header().Del(name)
+	}
+	w.Header().Set("Cache-Control", "no-store")
+}
+
+func (w *scrubbedWriter) WriteHeader(code int) {
+	w.scrub()
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *scrubbedWriter) WriteHeaderNow() {
+	w.scrub()
+	w.ResponseWriter.WriteHeaderNow()
+}
+
+func (w *scrubbedWriter) Write(b []byte) (int, error) {
+	w.scrub()
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *scrubbedWriter) WriteString(s string) (int, error) {
+	w.scrub()
+	return w.ResponseWriter.WriteString(s)
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController
+func (w *scrubbedWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
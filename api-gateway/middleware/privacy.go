@@ -0,0 +1,29 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// PrivacyLookup returns the X-Privacy-Context value of a user, or false when
+// it isn't known
+type PrivacyLookup func(c *gin.Context, userID string) (string, bool)
+
+// PrivacyContext middleware attaches the signed-in user's privacy settings to
+// read requests as X-Privacy-Context, so services can apply them without
+// asking the settings service. Anonymous requests, writes and users whose
+// settings can't be looked up get none. Clients can't send their own: it is
+// a trust header.
+func PrivacyContext(secrets func() []string, lookup PrivacyLookup) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead {
+			if userID, ok := requestUserID(c, secrets); ok {
+				if privacy, ok := lookup(c, userID); ok {
+					c.Request.Header.Set("X-Privacy-Context", privacy)
+				}
+			}
+		}
+		c.Next()
+	}
+}
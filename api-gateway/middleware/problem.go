@@ -0,0 +1,286 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/YeonwooSung/instagram/api-gateway/metrics"
+	"github.com/gin-gonic/gin"
+)
+
+// ProblemContentType is the media type of RFC 7807 problem details
+const ProblemContentType = "application/problem+json"
+
+// ErrorMapping translates an upstream's error into a gateway error code
+type ErrorMapping struct {
+	// Upstream is the base URL of the upstream the mapping applies to
+	Upstream string
+
+	// Status and Match (the backend's own error code) select the errors to
+	// map; zero and empty match any
+	Status int
+	Match  string
+
+	// Code, Title and Detail replace the problem's fields when set
+	Code   string
+	Title  string
+	Detail string
+}
+
+// ProblemOptions configures error normalization
+type ProblemOptions struct {
+	// TypeBase prefixes the error code to form the problem type URI;
+	// empty uses "about:blank"
+	TypeBase string
+
+	Mappings []ErrorMapping
+}
+
+// Problem is an RFC 7807 problem details document with the gateway's extensions
+type Problem struct {
+	Type      string `json:"type"`
+	Title     string `json:"title"`
+	Status    int    `json:"status"`
+	Detail    string `json:"detail,omitempty"`
+	Instance  string `json:"instance,omitempty"`
+	Code      string `json:"code"`
+	RequestID string `json:"request_id,omitempty"`
+
+	// Errors carries field-level errors, such as request validation failures
+	Errors json.RawMessage `json:"errors,omitempty"`
+
+	// Error repeats Detail for clients of the previous {"error": "..."} format
+	Error string `json:"error,omitempty"`
+}
+
+// defaultCodes are the error codes of statuses without a mapping, and the
+// statuses listed in the error catalog
+var defaultCodes = map[int]string{
+	http.StatusBadRequest:                   "bad_request",
+	http.StatusUnauthorized:                 "unauthorized",
+	http.StatusForbidden:                    "forbidden",
+	http.StatusNotFound:                     "not_found",
+	http.StatusMethodNotAllowed:             "method_not_allowed",
+	http.StatusRequestTimeout:               "request_timeout",
+	http.StatusConflict:                     "conflict",
+	http.StatusRequestEntityTooLarge:        "payload_too_large",
+	http.StatusUnsupportedMediaType:         "unsupported_media_type",
+	http.StatusRequestedRangeNotSatisfiable: "requested_range_not_satisfiable",
+	http.StatusUnprocessableEntity:          "validation_failed",
+	http.StatusTooManyRequests:              "rate_limited",
+	http.StatusInternalServerError:          "internal_error",
+	http.StatusBadGateway:                   "bad_gateway",
+	http.StatusServiceUnavailable:           "service_unavailable",
+	http.StatusGatewayTimeout:               "gateway_timeout",
+}
+
+// Problems middleware rewrites every error response, whether generated by the
+// gateway or returned by a backend, as application/problem+json with an error
+// code and the request ID. Backend errors are matched against the mapping
+// tables by upstream, status and the backend's own error code. Successful
+// responses are passed through untouched, and so are error responses whose
+// handler set "problem_passthrough" because their body is meant for clients
+// as is.
+func Problems(opts ProblemOptions) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		writer := &problemWriter{ResponseWriter: c.Writer}
+		c.Writer = writer
+		c.Next()
+		c.Writer = writer.ResponseWriter
+
+		if !writer.buffering {
+			return
+		}
+		if c.GetBool("problem_passthrough") {
+			writer.ResponseWriter.WriteHeader(writer.status)
+			writer.ResponseWriter.Write(writer.body.Bytes())
+			return
+		}
+
+		problem := opts.normalize(c, writer.status, writer.Header().Get("Content-Type"), writer.body.Bytes())
+		metrics.Inc("gateway_error_responses_total", "code", problem.Code, "status", strconv.Itoa(problem.Status))
+
+		body, _ := json.Marshal(problem)
+		header := writer.Header()
+		header.Set("Content-Type", ProblemContentType)
+		header.Set("Content-Length", strconv.Itoa(len(body)))
+		header.Del("Content-Encoding")
+		writer.ResponseWriter.WriteHeader(problem.Status)
+		if c.Request.Method != http.MethodHead {
+			writer.ResponseWriter.Write(body)
+		} else {
+			writer.ResponseWriter.WriteHeaderNow()
+		}
+	}
+}
+
+// normalize builds the problem document for an error response
+func (opts ProblemOptions) normalize(c *gin.Context, status int, contentType string, body []byte) *Problem {
+	problem := &Problem{
+		Status:   status,
+		Title:    http.StatusText(status),
+		Instance: c.Request.URL.Path,
+	}
+	if requestID, ok := c.Get("request_id"); ok {
+		problem.RequestID, _ = requestID.(string)
+	}
+
+	// Take what the response body says about the error, whatever its shape
+	var backendCode string
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	var fields map[string]json.RawMessage
+	if (mediaType == "application/json" || mediaType == ProblemContentType) && json.Unmarshal(body, &fields) == nil {
+		problem.Detail = firstString(fields, "detail", "message", "error_description", "error")
+		backendCode = firstString(fields, "code", "error_code")
+		if mediaType == ProblemContentType {
+			problem.Type = firstString(fields, "type")
+			problem.Title = orDefault(firstString(fields, "title"), problem.Title)
+		}
+
+		// {"error": {"code": ..., "message": ...}}
+		var nested map[string]json.RawMessage
+		if raw, ok := fields["error"]; ok && json.Unmarshal(raw, &nested) == nil {
+			problem.Detail = orDefault(problem.Detail, firstString(nested, "message", "detail"))
+			backendCode = orDefault(backendCode, firstString(nested, "code"))
+		}
+		if errs, ok := fields["errors"]; ok {
+			problem.Errors = errs
+		}
+	} else if mediaType == "text/plain" && len(body) > 0 && len(body) < 512 {
+		problem.Detail = strings.TrimSpace(string(body))
+	}
+
+	problem.Code = defaultCode(status)
+	if backendCode != "" && isCode(backendCode) {
+		problem.Code = strings.ToLower(backendCode)
+	}
+
+	if upstream, ok := c.Get("upstream"); ok {
+		for _, mapping := range opts.Mappings {
+			if mapping.Upstream != upstream ||
+				(mapping.Status != 0 && mapping.Status != status) ||
+				(mapping.Match != "" && mapping.Match != backendCode) {
+				continue
+			}
+			problem.Code = orDefault(mapping.Code, problem.Code)
+			problem.Title = orDefault(mapping.Title, problem.Title)
+			problem.Detail = orDefault(mapping.Detail, problem.Detail)
+			break
+		}
+	}
+
+	if problem.Type == "" {
+		problem.Type = "about:blank"
+		if opts.TypeBase != "" {
+			problem.Type = opts.TypeBase + problem.Code
+		}
+	}
+	problem.Error = problem.Detail
+	if problem.Error == "" {
+		problem.Error = problem.Title
+	}
+	return problem
+}
+
+// problemWriter passes successful responses through and holds error
+// responses back so they can be rewritten
+type problemWriter struct {
+	gin.ResponseWriter
+	status    int
+	buffering bool
+	body      bytes.Buffer
+}
+
+func (w *problemWriter) WriteHeader(code int) {
+	if code >= http.StatusBadRequest && !w.ResponseWriter.Written() {
+		w.status = code
+		w.buffering = true
+		return
+	}
+	w.buffering = false
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *problemWriter) WriteHeaderNow() {
+	if !w.buffering {
+		w.ResponseWriter.WriteHeaderNow()
+	}
+}
+
+func (w *problemWriter) Write(b []byte) (int, error) {
+	if w.buffering {
+		return w.body.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *problemWriter) WriteString(s string) (int, error) {
+	if w.buffering {
+		return w.body.WriteString(s)
+	}
+	return w.ResponseWriter.WriteString(s)
+}
+
+func (w *problemWriter) Flush() {
+	if !w.buffering {
+		w.ResponseWriter.Flush()
+	}
+}
+
+func (w *problemWriter) Status() int {
+	if w.buffering {
+		return w.status
+	}
+	return w.ResponseWriter.Status()
+}
+
+func (w *problemWriter) Written() bool {
+	return !w.buffering && w.ResponseWriter.Written()
+}
+
+// Unwrap exposes the connection's writer to http.ResponseController
+func (w *problemWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// firstString returns the first of keys holding a non-empty JSON string
+func firstString(fields map[string]json.RawMessage, keys ...string) string {
+	for _, key := range keys {
+		var value string
+		if raw, ok := fields[key]; ok && json.Unmarshal(raw, &value) == nil && value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+func defaultCode(status int) string {
+	if code, ok := defaultCodes[status]; ok {
+		return code
+	}
+	return strings.ReplaceAll(strings.ToLower(http.StatusText(status)), " ", "_")
+}
+
+// isCode accepts backend error codes that are safe to pass on as identifiers
+func isCode(code string) bool {
+	if len(code) > 64 {
+		return false
+	}
+	for _, r := range code {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '.' || r == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+func orDefault(value, defaultValue string) string {
+	if value != "" {
+		return value
+	}
+	return defaultValue
+}
@@ -0,0 +1,290 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/YeonwooSung/instagram/api-gateway/cache"
+	"github.com/YeonwooSung/instagram/api-gateway/metrics"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+const (
+	// quarantineStoreLimit caps the last known good responses kept in memory
+	quarantineStoreLimit = 10000
+
+	// maxQuarantineBody is the largest response kept as last known good
+	maxQuarantineBody = 256 << 10
+
+	// quarantineBaselineSamples is how many healthy samples establish a
+	// route's typical response size before sizes are checked
+	quarantineBaselineSamples = 20
+)
+
+// QuarantineOptions configures anomaly detection for a route
+type QuarantineOptions struct {
+	// SamplePercent of GET responses are checked (1-100)
+	SamplePercent int
+
+	// Window is how many recent samples are considered, and AnomalyPercent
+	// the share of them that must be anomalous to quarantine the route
+	Window         int
+	AnomalyPercent int
+
+	// SizeFactor flags responses this many times larger or smaller than the
+	// route's typical size
+	SizeFactor float64
+
+	// StaleTTL is how long last known good responses are kept
+	StaleTTL time.Duration
+}
+
+// ResponseCheck validates an upstream response, returning its problems. ok
+// is false when there was nothing to check the response against.
+type ResponseCheck func(method, path string, status int, contentType string, body []byte) (problems []string, ok bool)
+
+// QuarantineRecord is a quarantined route
+type QuarantineRecord struct {
+	Route  string    `json:"route"`
+	Reason string    `json:"reason"`
+	Since  time.Time `json:"since"`
+}
+
+// Quarantine watches a sample of the responses of guarded routes for signs of
+// a bad upstream deploy that status codes don't show: bodies failing their
+// response schema or sizes far from the route's norm. When too many recent
+// samples look wrong the route is quarantined: GET requests are answered with
+// the last known good response for the same requester and URL until an
+// operator acknowledges the quarantine.
+type Quarantine struct {
+	check  ResponseCheck
+	trip   func(record QuarantineRecord)
+	stale  *memoryStore
+	logger *zap.Logger
+
+	mu      sync.Mutex
+	watches map[string]*routeWatch
+	active  map[string]QuarantineRecord
+}
+
+// routeWatch is the recent sample history of one route
+type routeWatch struct {
+	samples   []bool
+	next      int
+	anomalies int
+	filled    int
+	reason    string
+
+	baseline float64
+	healthy  int
+}
+
+// NewQuarantine creates a quarantine checking responses with check, which may
+// be nil to check sizes only. trip is called, in its own goroutine, when a
+// route is quarantined.
+func NewQuarantine(check ResponseCheck, trip func(record QuarantineRecord), logger *zap.Logger) *Quarantine {
+	return &Quarantine{
+		check:   check,
+		trip:    trip,
+		stale:   newMemoryStore(quarantineStoreLimit),
+		logger:  logger,
+		watches: make(map[string]*routeWatch),
+		active:  make(map[string]QuarantineRecord),
+	}
+}
+
+// Set replaces the quarantined routes. Routes leaving quarantine start
+// over with an empty sample history.
+func (q *Quarantine) Set(records []QuarantineRecord) {
+	active := make(map[string]QuarantineRecord, len(records))
+	for _, record := range records {
+		active[record.Route] = record
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for route := range q.active {
+		if _, still := active[route]; !still {
+			delete(q.watches, route)
+			metrics.Set("gateway_quarantine_active", 0, "route", route)
+		}
+	}
+	for route := range active {
+		metrics.Set("gateway_quarantine_active", 1, "route", route)
+	}
+	q.active = active
+}
+
+// Active returns the quarantined routes
+func (q *Quarantine) Active() []QuarantineRecord {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	records := make([]QuarantineRecord, 0, len(q.active))
+	for _, record := range q.active {
+		records = append(records, record)
+	}
+	return records
+}
+
+// Guard middleware samples the route's responses and serves last known good
+// responses while the route is quarantined. Requests other than GET are
+// never affected.
+func (q *Quarantine) Guard(opts QuarantineOptions) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.Method != http.MethodGet {
+			c.Next()
+			return
+		}
+
+		route := c.FullPath()
+		key := "gateway:quarantine:" + cacheKey(c, requesterKey(c))
+
+		q.mu.Lock()
+		_, quarantined := q.active[route]
+		q.mu.Unlock()
+
+		if quarantined {
+			if entry, ok := q.lastGood(c.Request.Context(), key); ok {
+				metrics.Inc("gateway_quarantine_responses_total", "route", route, "result", "stale")
+				c.Header("X-Quarantine", "stale")
+				c.Header("Age", strconv.Itoa(int(time.Since(entry.StoredAt).Seconds())))
+				c.Data(entry.Status, entry.ContentType, entry.Body)
+				c.Abort()
+				return
+			}
+			// Nothing to fall back to; the upstream's answer beats none
+			metrics.Inc("gateway_quarantine_responses_total", "route", route, "result", "passthrough")
+			c.Header("X-Quarantine", "passthrough")
+			c.Next()
+			return
+		}
+
+		if rand.Intn(100) >= opts.SamplePercent {
+			c.Next()
+			return
+		}
+
+		recorder := newResponseRecorder(c.Writer)
+		c.Writer = recorder
+		c.Next()
+
+		if recorder.Status() != http.StatusOK {
+			return
+		}
+		contentType := recorder.Header().Get("Content-Type")
+		body := recorder.body.Bytes()
+
+		reason := q.inspect(c, contentType, body, opts)
+		if reason == "" {
+			if len(body) <= maxQuarantineBody {
+				entry := cache.Entry{
+					Status:      http.StatusOK,
+					ContentType: contentType,
+					Body:        body,
+					StoredAt:    time.Now(),
+				}
+				data, _ := json.Marshal(entry)
+				q.stale.Set(c.Request.Context(), key, data, opts.StaleTTL)
+			}
+			return
+		}
+
+		metrics.Inc("gateway_quarantine_anomalies_total", "route", route)
+		q.logger.Warn("Anomalous upstream response",
+			zap.String("route", route),
+			zap.String("path", c.Request.URL.Path),
+			zap.String("reason", reason),
+		)
+	}
+}
+
+// inspect checks a sampled response, records the outcome in the route's
+// history and quarantines the route when the history crosses the threshold.
+// It returns why the response is anomalous, or "" when it looks healthy.
+func (q *Quarantine) inspect(c *gin.Context, contentType string, body []byte, opts QuarantineOptions) string {
+	reason := ""
+	if q.check != nil {
+		if problems, ok := q.check(c.Request.Method, c.Request.URL.Path, http.StatusOK, contentType, body); ok && len(problems) > 0 {
+			reason = "schema: " + problems[0]
+		}
+	}
+
+	route := c.FullPath()
+	q.mu.Lock()
+	watch, ok := q.watches[route]
+	if !ok {
+		watch = &routeWatch{samples: make([]bool, opts.Window)}
+		q.watches[route] = watch
+	}
+
+	size := float64(len(body))
+	if reason == "" && watch.healthy >= quarantineBaselineSamples {
+		if size > watch.baseline*opts.SizeFactor || size*opts.SizeFactor < watch.baseline {
+			reason = fmt.Sprintf("size: %d bytes against a typical %.0f", len(body), watch.baseline)
+		}
+	}
+	if reason == "" {
+		// Moving average of healthy sizes, seeded by the first sample
+		if watch.healthy == 0 {
+			watch.baseline = size
+		} else {
+			watch.baseline += (size - watch.baseline) / quarantineBaselineSamples
+		}
+		watch.healthy++
+	}
+
+	// Ring buffer of the last Window outcomes
+	if watch.filled == len(watch.samples) && watch.samples[watch.next] {
+		watch.anomalies--
+	}
+	watch.samples[watch.next] = reason != ""
+	if reason != "" {
+		watch.anomalies++
+		watch.reason = reason
+	}
+	watch.next = (watch.next + 1) % len(watch.samples)
+	watch.filled = min(watch.filled+1, len(watch.samples))
+
+	_, quarantined := q.active[route]
+	trip := !quarantined && watch.filled == len(watch.samples) &&
+		watch.anomalies*100 >= opts.AnomalyPercent*len(watch.samples)
+	var record QuarantineRecord
+	if trip {
+		record = QuarantineRecord{
+			Route:  route,
+			Reason: fmt.Sprintf("%d of the last %d samples anomalous, latest %s", watch.anomalies, len(watch.samples), watch.reason),
+			Since:  time.Now(),
+		}
+		q.active[route] = record
+		metrics.Set("gateway_quarantine_active", 1, "route", route)
+	}
+	q.mu.Unlock()
+
+	if trip {
+		metrics.Inc("gateway_quarantine_trips_total", "route", route)
+		if q.trip != nil {
+			go q.trip(record)
+		}
+	}
+	return reason
+}
+
+// lastGood returns the last known good response stored under key
+func (q *Quarantine) lastGood(ctx context.Context, key string) (*cache.Entry, bool) {
+	data, err := q.stale.Get(ctx, key)
+	if err != nil {
+		return nil, false
+	}
+	var entry cache.Entry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, false
+	}
+	return &entry, true
+}
@@ -1,30 +1,74 @@
 package middleware
 
 import (
+	"fmt"
+	"net"
 	"net/http"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 
+	"github.com/YeonwooSung/instagram/api-gateway/events"
+	"github.com/YeonwooSung/instagram/api-gateway/metrics"
 	"github.com/gin-gonic/gin"
 	"golang.org/x/time/rate"
 )
 
-// RateLimiter implements per-IP rate limiting using token bucket algorithm
+// rejectionReportInterval is how often a client that keeps exceeding a limit
+// is reported as a security event
+const rejectionReportInterval = time.Minute
+
+// RateLimiter implements per-IP rate limiting using token bucket algorithm.
+// IPv6 clients are limited per network prefix, since a single allocation
+// gives a client enough addresses to rotate through per-address limits.
 type RateLimiter struct {
-	limiters map[string]*rate.Limiter
-	mu       sync.RWMutex
-	rps      int
-	burst    int
+	limiters   map[string]*rate.Limiter
+	reported   map[string]time.Time
+	swept      time.Time
+	mu         sync.RWMutex
+	limit      rate.Limit
+	burst      int
+	ipv6Prefix int
+}
+
+// NewRateLimiter creates a new rate limiter. IPv6 clients sharing the first
+// ipv6Prefix bits of their address share a bucket.
+func NewRateLimiter(rps, burst, ipv6Prefix int) *RateLimiter {
+	return &RateLimiter{
+		limiters:   make(map[string]*rate.Limiter),
+		reported:   make(map[string]time.Time),
+		limit:      rate.Limit(rps),
+		burst:      burst,
+		ipv6Prefix: ipv6Prefix,
+	}
 }
 
-// NewRateLimiter creates a new rate limiter
-func NewRateLimiter(rps, burst int) *RateLimiter {
+// NewRateLimiterPer creates a rate limiter allowing events per period, for
+// limits too low to give in requests per second
+func NewRateLimiterPer(events int, period time.Duration, burst, ipv6Prefix int) *RateLimiter {
 	return &RateLimiter{
-		limiters: make(map[string]*rate.Limiter),
-		rps:      rps,
-		burst:    burst,
+		limiters:   make(map[string]*rate.Limiter),
+		reported:   make(map[string]time.Time),
+		limit:      rate.Limit(float64(events) / period.Seconds()),
+		burst:      burst,
+		ipv6Prefix: ipv6Prefix,
 	}
 }
 
+// ClientKey returns the rate limit key for the client: its IPv4 address or
+// its IPv6 network prefix in CIDR notation
+func (rl *RateLimiter) ClientKey(c *gin.Context) string {
+	clientIP := c.ClientIP()
+	ip := net.ParseIP(clientIP)
+	if ip == nil || ip.To4() != nil {
+		return clientIP
+	}
+
+	mask := net.CIDRMask(rl.ipv6Prefix, 8*net.IPv6len)
+	return ip.Mask(mask).String() + "/" + strconv.Itoa(rl.ipv6Prefix)
+}
+
 // getLimiter returns a limiter for the given key (IP address)
 func (rl *RateLimiter) getLimiter(key string) *rate.Limiter {
 	rl.mu.Lock()
@@ -32,24 +76,92 @@ func (rl *RateLimiter) getLimiter(key string) *rate.Limiter {
 
 	limiter, exists := rl.limiters[key]
 	if !exists {
-		limiter = rate.NewLimiter(rate.Limit(rl.rps), rl.burst)
+		limiter = rate.NewLimiter(rl.limit, rl.burst)
 		rl.limiters[key] = limiter
 	}
 
 	return limiter
 }
 
-// RateLimit middleware enforces rate limiting per IP address
+// Allow reports whether a request for key is within the rate limit
+func (rl *RateLimiter) Allow(key string) bool {
+	if rl.getLimiter(key).Allow() {
+		metrics.Inc("gateway_ratelimit_requests_total", "result", "allowed")
+		return true
+	}
+
+	metrics.Inc("gateway_ratelimit_requests_total", "result", "rejected")
+	metrics.Inc("gateway_ratelimit_rejected_total", "key", keyKind(key))
+	rl.reportRejection(key)
+	return false
+}
+
+// keyKind names the kind of client a rate limit key identifies, a label of
+// bounded cardinality unlike the key itself
+func keyKind(key string) string {
+	switch {
+	case strings.HasPrefix(key, "user:"):
+		return "user"
+	case strings.HasPrefix(key, "conversation:"):
+		return "conversation"
+	case strings.HasPrefix(key, "crawler:"):
+		return "crawler"
+	case strings.Contains(key, "/"):
+		return "ipv6_prefix"
+	default:
+		return "ip"
+	}
+}
+
+// reportRejection emits a security event when a client is cut off by the
+// limit, and again every rejectionReportInterval while it keeps exceeding it
+func (rl *RateLimiter) reportRejection(key string) {
+	now := time.Now()
+	rl.mu.Lock()
+	if now.Sub(rl.reported[key]) < rejectionReportInterval {
+		rl.mu.Unlock()
+		return
+	}
+	rl.reported[key] = now
+
+	// Reports older than the interval no longer suppress anything, so drop
+	// them rather than remembering every client that was ever rejected
+	if now.Sub(rl.swept) >= rejectionReportInterval {
+		for client, at := range rl.reported {
+			if now.Sub(at) >= rejectionReportInterval {
+				delete(rl.reported, client)
+			}
+		}
+		rl.swept = now
+	}
+	rl.mu.Unlock()
+
+	event := events.SecurityEvent{
+		Type:    events.SecurityRateLimited,
+		Details: map[string]string{"client": key, "limit": strconv.FormatFloat(float64(rl.limit), 'g', -1, 64)},
+	}
+	if userID, ok := strings.CutPrefix(key, "user:"); ok {
+		event.UserID = userID
+	} else if net.ParseIP(key) != nil {
+		event.ClientIP = key
+	}
+	events.Security(event)
+}
+
+// RateLimit middleware enforces rate limiting per IP address. Verified
+// crawlers are limited by their crawler tier instead.
 func (rl *RateLimiter) RateLimit() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// Get client IP as the rate limit key
-		key := c.ClientIP()
+		if _, verified := c.Get("crawler"); verified {
+			c.Next()
+			return
+		}
 
-		// Get limiter for this client
-		limiter := rl.getLimiter(key)
+		// Get client IP (or IPv6 prefix) as the rate limit key
+		key := rl.ClientKey(c)
 
 		// Check if request is allowed
-		if !limiter.Allow() {
+		if !rl.Allow(key) {
 			c.JSON(http.StatusTooManyRequests, gin.H{
 				"error": "Rate limit exceeded",
 			})
@@ -69,16 +181,14 @@ func (rl *RateLimiter) UserRateLimit() gin.HandlerFunc {
 		var key string
 
 		if exists {
-			// Use user ID if authenticated
-			key = userID.(string)
+			// Use user ID if authenticated (numeric in most tokens)
+			key = fmt.Sprintf("user:%v", userID)
 		} else {
-			// Fall back to IP address
-			key = c.ClientIP()
+			// Fall back to IP address (or IPv6 prefix)
+			key = rl.ClientKey(c)
 		}
 
-		limiter := rl.getLimiter(key)
-
-		if !limiter.Allow() {
+		if !rl.Allow(key) {
 			c.JSON(http.StatusTooManyRequests, gin.H{
 				"error": "Rate limit exceeded",
 			})
@@ -89,3 +199,26 @@ func (rl *RateLimiter) UserRateLimit() gin.HandlerFunc {
 		c.Next()
 	}
 }
+
+// ConversationRateLimit middleware enforces rate limiting per user within
+// the conversation named by the param route parameter, so flooding one
+// conversation is cut off without limiting the user's others
+func (rl *RateLimiter) ConversationRateLimit(param string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		sender := rl.ClientKey(c)
+		if userID, exists := c.Get("user_id"); exists {
+			sender = fmt.Sprintf("user:%v", userID)
+		}
+		key := "conversation:" + c.Param(param) + ":" + sender
+
+		if !rl.Allow(key) {
+			c.JSON(http.StatusTooManyRequests, gin.H{
+				"error": "Too many messages in this conversation",
+			})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
@@ -0,0 +1,165 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/YeonwooSung/instagram/api-gateway/metrics"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+// RedisMode is how a Redis-backed feature behaves while Redis is unavailable
+type RedisMode string
+
+const (
+	// RedisFailOpen skips the feature, letting requests through unchecked
+	RedisFailOpen RedisMode = "fail_open"
+
+	// RedisFailClosed rejects requests that need the feature with 503
+	RedisFailClosed RedisMode = "fail_closed"
+
+	// RedisMemory keeps the feature's state in this instance's memory until
+	// Redis is back; state is not shared between gateway instances
+	RedisMemory RedisMode = "memory"
+)
+
+// Redis-backed features with a degradation policy
+const (
+	RedisFeatureDedup  = "dedup"
+	RedisFeatureReplay = "replay"
+	RedisFeatureCache  = "cache"
+	RedisFeatureQuota  = "quota"
+)
+
+// RedisHealth tracks Redis liveness and the degradation policy of each
+// Redis-backed feature. While Redis is down, features skip it immediately
+// instead of waiting on connection timeouts for every request.
+type RedisHealth struct {
+	client   *redis.Client
+	policies map[string]RedisMode
+	logger   *zap.Logger
+
+	down   atomic.Bool
+	memory map[string]*memoryStore
+	mu     sync.Mutex
+}
+
+// NewRedisHealth creates a Redis health tracker with the modes of each feature.
+// Features without a policy fail open.
+func NewRedisHealth(client *redis.Client, policies map[string]RedisMode, logger *zap.Logger) *RedisHealth {
+	metrics.Set("gateway_redis_up", 1)
+	return &RedisHealth{
+		client:   client,
+		policies: policies,
+		logger:   logger,
+		memory:   make(map[string]*memoryStore),
+	}
+}
+
+// Watch pings Redis every interval until ctx is cancelled
+func (h *RedisHealth) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		pingCtx, cancel := context.WithTimeout(ctx, interval)
+		err := h.client.Ping(pingCtx).Err()
+		cancel()
+		if ctx.Err() != nil {
+			return
+		}
+		h.report(err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Up reports whether Redis answered the last health check
+func (h *RedisHealth) Up() bool {
+	return !h.down.Load()
+}
+
+// Mode returns the degradation policy of a feature
+func (h *RedisHealth) Mode(feature string) RedisMode {
+	if mode, ok := h.policies[feature]; ok {
+		return mode
+	}
+	return RedisFailOpen
+}
+
+// report records a health check or request outcome and the degraded gauges
+func (h *RedisHealth) report(err error) {
+	down := err != nil && !errors.Is(err, redis.Nil)
+	if h.down.Swap(down) == down {
+		return
+	}
+
+	if down {
+		h.logger.Error("Redis unavailable, degrading dependent features", zap.Error(err))
+	} else {
+		h.logger.Info("Redis available again")
+
+		// State kept in memory meanwhile is dropped; Redis is authoritative again
+		h.mu.Lock()
+		h.memory = make(map[string]*memoryStore)
+		h.mu.Unlock()
+	}
+	metrics.Set("gateway_redis_up", boolGauge(!down))
+	for feature, mode := range h.policies {
+		metrics.Set("gateway_redis_degraded", boolGauge(down), "feature", feature, "mode", string(mode))
+	}
+}
+
+// store returns the key-value store a feature should use for this request:
+// Redis while it is up, or the feature's in-memory fallback. ok is false when
+// the feature must be skipped (fail open) or the request rejected (fail closed).
+func (h *RedisHealth) store(feature string) (kvStore, RedisMode, bool) {
+	if h.Up() {
+		return redisStore{health: h}, "", true
+	}
+	return h.degrade(feature)
+}
+
+// degrade returns the fallback store of a feature once Redis has failed
+func (h *RedisHealth) degrade(feature string) (kvStore, RedisMode, bool) {
+	mode := h.Mode(feature)
+	metrics.Inc("gateway_redis_degraded_requests_total", "feature", feature, "mode", string(mode))
+	if mode != RedisMemory {
+		return nil, mode, false
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	memory, ok := h.memory[feature]
+	if !ok {
+		memory = newMemoryStore(memoryStoreLimit)
+		h.memory[feature] = memory
+	}
+	return memory, mode, true
+}
+
+// rejectUnavailable answers a request whose fail-closed feature can't run
+func rejectUnavailable(c *gin.Context, message string) {
+	c.Header("Retry-After", "5")
+	c.JSON(http.StatusServiceUnavailable, gin.H{
+		"error": message,
+	})
+	c.Abort()
+}
+
+func boolGauge(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
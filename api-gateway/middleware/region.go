@@ -0,0 +1,59 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GlobalRegion is the region of requests without a known country
+const GlobalRegion = "global"
+
+// Region resolves the region a request is about and forwards it as the
+// "region" query parameter: the client's own choice if given, otherwise the
+// country the CDN put in header, otherwise GlobalRegion. As the parameter is
+// part of the URL, responses cached behind it are kept per region, and the
+// handful of spellings of a region share one entry.
+func Region(header string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		query := c.Request.URL.Query()
+
+		region := GlobalRegion
+		if requested := query.Get("region"); requested != "" {
+			var ok bool
+			if region, ok = normalizeRegion(requested); !ok {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "region must be a two-letter country code or global"})
+				c.Abort()
+				return
+			}
+		} else if header != "" {
+			// CDNs send placeholders such as XX or T1 for unknown countries
+			if country, ok := normalizeRegion(c.GetHeader(header)); ok && country != "XX" && country != "T1" {
+				region = country
+			}
+		}
+
+		query.Set("region", region)
+		c.Request.URL.RawQuery = query.Encode()
+		c.Next()
+	}
+}
+
+// normalizeRegion returns an ISO 3166-1 alpha-2 code in upper case, or GlobalRegion
+func normalizeRegion(region string) (string, bool) {
+	region = strings.TrimSpace(region)
+	if strings.EqualFold(region, GlobalRegion) {
+		return GlobalRegion, true
+	}
+	if len(region) != 2 {
+		return "", false
+	}
+	region = strings.ToUpper(region)
+	for _, r := range region {
+		if r < 'A' || r > 'Z' {
+			return "", false
+		}
+	}
+	return region, true
+}
@@ -0,0 +1,187 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/YeonwooSung/instagram/api-gateway/metrics"
+	"github.com/gin-gonic/gin"
+)
+
+// trackerIdleTTL is how long a client's pagination history is kept without requests
+const trackerIdleTTL = 10 * time.Minute
+
+// ScrapeGuardOptions configures detection of sequential deep pagination
+type ScrapeGuardOptions struct {
+	// AnonMaxDepth is the deepest page unauthenticated clients may fetch (0 for no cap)
+	AnonMaxDepth int
+
+	// Streak is the number of consecutive page steps, each within StepInterval
+	// of the last, after which a client is treated as a scraper
+	Streak       int
+	StepInterval time.Duration
+
+	// Delay is the hold added at the first anomalous step; it doubles with
+	// every further step up to MaxDelay
+	Delay    time.Duration
+	MaxDelay time.Duration
+}
+
+// ScrapeGuard shapes pagination traffic on list endpoints such as follower
+// lists and hashtag archives. It tracks how deep each client pages through
+// each route and how fast, delays clients walking pages in sequence at a
+// machine rate, and caps the depth unauthenticated and anomalous clients reach.
+type ScrapeGuard struct {
+	opts ScrapeGuardOptions
+
+	mu        sync.Mutex
+	trackers  map[string]*pageTracker
+	lastSweep time.Time
+}
+
+// pageTracker is one client's pagination state on one route
+type pageTracker struct {
+	depth  int
+	cursor string
+	streak int
+	seen   time.Time
+}
+
+// NewScrapeGuard creates a new pagination scraping guard
+func NewScrapeGuard(opts ScrapeGuardOptions) *ScrapeGuard {
+	return &ScrapeGuard{
+		opts:      opts,
+		trackers:  make(map[string]*pageTracker),
+		lastSweep: time.Now(),
+	}
+}
+
+// Guard middleware applies the scraping policy to GET requests. Page depth is
+// read from the page or offset/limit query parameters; for opaque cursors
+// (cursor, max_id) each new cursor counts as one page deeper.
+func (g *ScrapeGuard) Guard() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.Method != http.MethodGet {
+			c.Next()
+			return
+		}
+
+		userID, authenticated := c.Get("user_id")
+		key := "ip:" + c.ClientIP()
+		if authenticated {
+			key = fmt.Sprintf("user:%v", userID)
+		}
+		route := c.FullPath()
+
+		depth, delay := g.observe(key+" "+route, c.Request)
+		anomalous := delay > 0
+
+		if g.opts.AnonMaxDepth > 0 && depth > g.opts.AnonMaxDepth && (!authenticated || anomalous) {
+			metrics.Inc("gateway_scrape_guard_total", "route", route, "action", "truncated")
+			c.JSON(http.StatusForbidden, gin.H{
+				"error": "Pagination depth limit reached",
+			})
+			c.Abort()
+			return
+		}
+
+		if anomalous {
+			metrics.Inc("gateway_scrape_guard_total", "route", route, "action", "delayed")
+			timer := time.NewTimer(delay)
+			select {
+			case <-timer.C:
+			case <-c.Request.Context().Done():
+				timer.Stop()
+				c.Abort()
+				return
+			}
+		}
+
+		c.Next()
+	}
+}
+
+// observe records a request and returns its page depth and, once the client's
+// streak of rapid sequential steps marks it as a scraper, how long to hold it
+func (g *ScrapeGuard) observe(key string, req *http.Request) (int, time.Duration) {
+	now := time.Now()
+	query := req.URL.Query()
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if now.Sub(g.lastSweep) > trackerIdleTTL {
+		for k, tracker := range g.trackers {
+			if now.Sub(tracker.seen) > trackerIdleTTL {
+				delete(g.trackers, k)
+			}
+		}
+		g.lastSweep = now
+	}
+
+	tracker, exists := g.trackers[key]
+	if !exists {
+		tracker = &pageTracker{}
+		g.trackers[key] = tracker
+	}
+
+	cursor := query.Get("cursor")
+	if cursor == "" {
+		cursor = query.Get("max_id")
+	}
+	depth := pageDepth(query.Get("page"), query.Get("offset"), query.Get("limit"))
+	if depth == 0 {
+		switch {
+		case cursor == "":
+			depth = 1
+		case cursor == tracker.cursor:
+			depth = tracker.depth
+		default:
+			depth = tracker.depth + 1
+		}
+	}
+
+	// Only a step to the next page soon after the previous one extends the streak
+	if exists && depth > tracker.depth && now.Sub(tracker.seen) <= g.opts.StepInterval {
+		tracker.streak++
+	} else if depth <= 1 || now.Sub(tracker.seen) > g.opts.StepInterval {
+		tracker.streak = 0
+	}
+	tracker.depth, tracker.cursor, tracker.seen = depth, cursor, now
+
+	if g.opts.Streak == 0 || tracker.streak < g.opts.Streak {
+		return depth, 0
+	}
+	// The hold doesn't count toward the gap before the client's next step
+	delay := g.delay(tracker.streak - g.opts.Streak)
+	tracker.seen = now.Add(delay)
+	return depth, delay
+}
+
+// delay returns the hold for a client that is excess steps past the streak threshold
+func (g *ScrapeGuard) delay(excess int) time.Duration {
+	delay := g.opts.Delay
+	for i := 0; i < excess && delay < g.opts.MaxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, g.opts.MaxDelay)
+}
+
+// pageDepth returns the 1-based page number from page or offset/limit, or 0
+// when the request uses neither
+func pageDepth(page, offset, limit string) int {
+	if n, err := strconv.Atoi(page); err == nil && n > 0 {
+		return n
+	}
+	if n, err := strconv.Atoi(offset); err == nil && n >= 0 {
+		size, err := strconv.Atoi(limit)
+		if err != nil || size <= 0 {
+			size = 20
+		}
+		return n/size + 1
+	}
+	return 0
+}
@@ -0,0 +1,58 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/YeonwooSung/instagram/api-gateway/metrics"
+	"github.com/gin-gonic/gin"
+)
+
+// searchPunctuation is the punctuation found in handles, hashtags and place
+// names; anything else could be query syntax to the search cluster
+const searchPunctuation = "#@._-'&,"
+
+// SearchQuery validates the "q" parameter before a search reaches the search
+// cluster. It must be present, at most maxLength characters long, and made of
+// letters, digits, spaces and searchPunctuation, which keeps wildcards, quotes
+// and field operators out. The query is forwarded trimmed, with runs of
+// whitespace collapsed, so equivalent searches look the same upstream.
+func SearchQuery(maxLength int) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		query := c.Request.URL.Query()
+		q := strings.Join(strings.Fields(query.Get("q")), " ")
+
+		switch {
+		case q == "":
+			rejectSearch(c, "missing", "q is required")
+			return
+		case !utf8.ValidString(q):
+			rejectSearch(c, "characters", "q must be valid UTF-8")
+			return
+		case utf8.RuneCountInString(q) > maxLength:
+			rejectSearch(c, "length", fmt.Sprintf("q must be at most %d characters", maxLength))
+			return
+		}
+		for _, r := range q {
+			if unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsMark(r) || r == ' ' ||
+				strings.ContainsRune(searchPunctuation, r) {
+				continue
+			}
+			rejectSearch(c, "characters", fmt.Sprintf("q must not contain %q", r))
+			return
+		}
+
+		query.Set("q", q)
+		c.Request.URL.RawQuery = query.Encode()
+		c.Next()
+	}
+}
+
+func rejectSearch(c *gin.Context, reason, message string) {
+	metrics.Inc("gateway_search_rejections_total", "reason", reason)
+	c.JSON(http.StatusBadRequest, gin.H{"error": message})
+	c.Abort()
+}
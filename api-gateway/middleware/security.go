@@ -0,0 +1,43 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/YeonwooSung/instagram/api-gateway/events"
+	"github.com/gin-gonic/gin"
+)
+
+// LoginEvents middleware emits a security event for every sign-in attempt
+// through the route, with method naming how the user signed in (e.g.
+// "password" or "oauth"). The outcome is read from the response: "success",
+// "failure" for rejected credentials or state, or "error".
+func LoginEvents(method string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+
+		status := c.Writer.Status()
+		outcome := "error"
+		switch {
+		case status < http.StatusBadRequest:
+			// Social sign-ins end in a redirect back to the app
+			outcome = "success"
+		case status < http.StatusInternalServerError:
+			outcome = "failure"
+		}
+
+		details := map[string]string{"method": method}
+		if provider := c.Param("provider"); provider != "" {
+			details["provider"] = provider
+		}
+		events.Security(events.SecurityEvent{
+			Type:      events.SecurityLogin,
+			Outcome:   outcome,
+			ClientIP:  c.ClientIP(),
+			UserAgent: c.Request.UserAgent(),
+			Route:     c.FullPath(),
+			Status:    status,
+			RequestID: c.GetString("request_id"),
+			Details:   details,
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package middleware
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/YeonwooSung/instagram/api-gateway/timing"
+	"github.com/gin-gonic/gin"
+)
+
+// DebugTimingHeader carries the admin token to get the full timing breakdown
+const DebugTimingHeader = "X-Debug-Timing"
+
+// ServerTiming middleware adds a Server-Timing header splitting the response
+// time into gateway overhead, upstream time and time spent retrying upstream
+// connections. Requests carrying the admin token in X-Debug-Timing also get
+// each upstream call broken down into DNS, connect, TLS, wait and transfer.
+func ServerTiming(adminToken func() string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		timeline := timing.FromContext(c.Request.Context())
+		if timeline == nil {
+			var ctx context.Context
+			ctx, timeline = timing.Start(c.Request.Context())
+			c.Request = c.Request.WithContext(ctx)
+		}
+
+		debug := false
+		if provided := c.GetHeader(DebugTimingHeader); provided != "" {
+			expected := adminToken()
+			debug = expected != "" && subtle.ConstantTimeCompare([]byte(provided), []byte(expected)) == 1
+			c.Request.Header.Del(DebugTimingHeader)
+		}
+
+		writer := &hookWriter{
+			ResponseWriter: c.Writer,
+			before: func(w gin.ResponseWriter) {
+				w.Header().Set("Server-Timing", serverTiming(timeline.Breakdown(), debug))
+			},
+		}
+		c.Writer = writer
+		c.Next()
+
+		// Bodiless responses are written by gin after the chain returns
+		if !writer.ResponseWriter.Written() {
+			writer.runHook()
+		}
+	}
+}
+
+// serverTiming formats a breakdown as a Server-Timing header value
+func serverTiming(b timing.Breakdown, debug bool) string {
+	retries := ""
+	if b.Retries > 0 {
+		retries = strconv.Itoa(b.Retries) + " retries"
+	}
+	metrics := []string{
+		timingMetric("gateway", b.Gateway, ""),
+		timingMetric("upstream", b.Upstream, ""),
+		timingMetric("retry", b.Retry, retries),
+		timingMetric("total", b.Total, ""),
+	}
+	if debug {
+		for i, call := range b.Calls {
+			name := "upstream-" + strconv.Itoa(i+1)
+			metrics = append(metrics,
+				timingMetric(name, call.Total, fmt.Sprintf("%s attempts=%d", call.Upstream, call.Attempts)),
+				timingMetric(name+"-dns", call.DNS, ""),
+				timingMetric(name+"-connect", call.Connect, ""),
+				timingMetric(name+"-tls", call.TLS, ""),
+				timingMetric(name+"-wait", call.Wait, ""),
+				timingMetric(name+"-transfer", call.Transfer, ""),
+				timingMetric(name+"-retry", call.Retry, ""),
+			)
+		}
+	}
+	return strings.Join(metrics, ", ")
+}
+
+// timingMetric formats one metric with its duration in milliseconds
+func timingMetric(name string, d time.Duration, desc string) string {
+	metric := name + ";dur=" + strconv.FormatFloat(float64(d.Microseconds())/1000, 'f', -1, 64)
+	if desc != "" {
+		metric += ";desc=" + strconv.Quote(desc)
+	}
+	return metric
+}
@@ -0,0 +1,84 @@
+package middleware
+
+import (
+	"time"
+
+	"github.com/YeonwooSung/instagram/api-gateway/metrics"
+	"github.com/YeonwooSung/instagram/api-gateway/timing"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// SlowRequests middleware times every request and logs those slower than
+// latency, or with a response of at least size bytes, in full: where their
+// time went, upstream call by upstream call, and how often the transport
+// retried connections. Zero disables a threshold. It must run before anything
+// calling upstreams.
+func SlowRequests(latency time.Duration, size int64, logger *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, timeline := timing.Start(c.Request.Context())
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+
+		breakdown := timeline.Breakdown()
+		responseSize := int64(max(c.Writer.Size(), 0))
+		var reasons []string
+		if latency > 0 && breakdown.Total >= latency {
+			reasons = append(reasons, "latency")
+		}
+		if size > 0 && responseSize >= size {
+			reasons = append(reasons, "size")
+		}
+		if len(reasons) == 0 {
+			return
+		}
+
+		for _, reason := range reasons {
+			metrics.Inc("gateway_slow_requests_total", "route", c.FullPath(), "reason", reason)
+		}
+		logger.Warn("Slow or large request",
+			zap.Strings("reasons", reasons),
+			zap.String("method", c.Request.Method),
+			zap.String("path", c.Request.URL.Path),
+			zap.String("route", c.FullPath()),
+			zap.Int("status", c.Writer.Status()),
+			zap.String("upstream", c.GetString("upstream")),
+			zap.Int64("response_size", responseSize),
+			zap.Duration("latency", breakdown.Total),
+			zap.Duration("gateway_time", breakdown.Gateway),
+			zap.Duration("upstream_time", breakdown.Upstream),
+			zap.Duration("retry_time", breakdown.Retry),
+			zap.Int("retries", breakdown.Retries),
+			zap.Array("calls", callTimings(breakdown.Calls)),
+			zap.String("request_id", c.GetString("request_id")),
+		)
+	}
+}
+
+// callTimings logs the breakdown of each upstream call
+type callTimings []timing.CallTiming
+
+func (calls callTimings) MarshalLogArray(enc zapcore.ArrayEncoder) error {
+	for _, call := range calls {
+		if err := enc.AppendObject(callTiming(call)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type callTiming timing.CallTiming
+
+func (call callTiming) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	enc.AddString("upstream", call.Upstream)
+	enc.AddInt("attempts", call.Attempts)
+	enc.AddDuration("dns", call.DNS)
+	enc.AddDuration("connect", call.Connect)
+	enc.AddDuration("tls", call.TLS)
+	enc.AddDuration("wait", call.Wait)
+	enc.AddDuration("transfer", call.Transfer)
+	enc.AddDuration("retry", call.Retry)
+	enc.AddDuration("total", call.Total)
+	return nil
+}
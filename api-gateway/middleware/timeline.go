@@ -0,0 +1,204 @@
+package middleware
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/YeonwooSung/instagram/api-gateway/metrics"
+	"github.com/YeonwooSung/instagram/api-gateway/state"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+// timelineKeyPrefix prefixes the sorted set of each user's request summaries
+const timelineKeyPrefix = "gateway:timeline:"
+
+// timelineSchema versions TimelineEntry as stored in Redis
+var timelineSchema = state.NewSchema("timeline_entry", 1)
+
+// TimelineEntry summarizes one request made by a user: what was called, how
+// the gateway handled it and how long it took. Query strings and bodies are
+// never recorded.
+type TimelineEntry struct {
+	At        time.Time `json:"at"`
+	RequestID string    `json:"request_id"`
+	Method    string    `json:"method"`
+	Route     string    `json:"route,omitempty"`
+	Path      string    `json:"path"`
+	Status    int       `json:"status"`
+	LatencyMS float64   `json:"latency_ms"`
+	Upstream  string    `json:"upstream,omitempty"`
+	ErrorCode string    `json:"error_code,omitempty"`
+	Policies  []string  `json:"policies,omitempty"`
+}
+
+// TimelineOptions configures request timeline capture
+type TimelineOptions struct {
+	// SamplePercent is the share of users whose requests are captured; a
+	// sampled user has every request captured, not a sample of them
+	SamplePercent int
+
+	// Retention is how long entries are kept, and MaxEntries caps each user's timeline
+	Retention  time.Duration
+	MaxEntries int
+}
+
+// Timeline captures per-user request summaries in Redis so support can
+// reconstruct what an app did around a bug report
+type Timeline struct {
+	redis  *redis.Client
+	health *RedisHealth
+	opts   TimelineOptions
+	logger *zap.Logger
+}
+
+// NewTimeline creates a new request timeline recorder
+func NewTimeline(client *redis.Client, redisHealth *RedisHealth, opts TimelineOptions, logger *zap.Logger) *Timeline {
+	return &Timeline{
+		redis:  client,
+		health: redisHealth,
+		opts:   opts,
+		logger: logger,
+	}
+}
+
+// Record middleware captures a summary of each authenticated request of a
+// sampled user once it completes. Captures are written in the background and
+// skipped while Redis is down.
+func (t *Timeline) Record() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		userIDValue, exists := c.Get("user_id")
+		if !exists || !t.health.Up() {
+			return
+		}
+		userID := fmt.Sprintf("%v", userIDValue)
+		if !t.sampled(userID) {
+			return
+		}
+
+		entry := TimelineEntry{
+			At:        start.UTC(),
+			RequestID: c.GetString("request_id"),
+			Method:    c.Request.Method,
+			Route:     c.FullPath(),
+			Path:      c.Request.URL.Path,
+			Status:    c.Writer.Status(),
+			LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+			Upstream:  c.GetString("upstream"),
+			Policies:  appliedPolicies(c),
+		}
+		if entry.Status >= http.StatusBadRequest {
+			entry.ErrorCode = defaultCode(entry.Status)
+		}
+		go t.store(userID, entry)
+	}
+}
+
+// sampled reports whether a user's requests are captured; the same users
+// stay sampled while the percentage is unchanged
+func (t *Timeline) sampled(userID string) bool {
+	if t.opts.SamplePercent >= 100 {
+		return true
+	}
+	sum := sha256.Sum256([]byte("timeline:" + userID))
+	return binary.BigEndian.Uint64(sum[:8])%100 < uint64(t.opts.SamplePercent)
+}
+
+func (t *Timeline) store(userID string, entry TimelineEntry) {
+	body, err := timelineSchema.Marshal(entry)
+	if err != nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	key := timelineKeyPrefix + userID
+	cutoff := entry.At.Add(-t.opts.Retention).UnixMilli()
+	pipe := t.redis.Pipeline()
+	pipe.ZAdd(ctx, key, redis.Z{Score: float64(entry.At.UnixMilli()), Member: body})
+	pipe.ZRemRangeByScore(ctx, key, "-inf", "("+strconv.FormatInt(cutoff, 10))
+	pipe.ZRemRangeByRank(ctx, key, 0, int64(-t.opts.MaxEntries-1))
+	pipe.Expire(ctx, key, t.opts.Retention)
+	if _, err := pipe.Exec(ctx); err != nil {
+		t.health.report(err)
+		t.logger.Debug("Failed to record timeline entry", zap.Error(err))
+		return
+	}
+	metrics.Inc("gateway_timeline_entries_total")
+}
+
+// Query returns a user's captured requests between from and to, oldest
+// first, at most limit of them (the most recent ones)
+func (t *Timeline) Query(ctx context.Context, userID string, from, to time.Time, limit int) ([]TimelineEntry, error) {
+	members, err := t.redis.ZRevRangeByScore(ctx, timelineKeyPrefix+userID, &redis.ZRangeBy{
+		Min:   strconv.FormatInt(from.UnixMilli(), 10),
+		Max:   strconv.FormatInt(to.UnixMilli(), 10),
+		Count: int64(limit),
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]TimelineEntry, 0, len(members))
+	for i := len(members) - 1; i >= 0; i-- {
+		var entry TimelineEntry
+		if timelineSchema.Unmarshal([]byte(members[i]), &entry) == nil {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+// appliedPolicies lists the gateway decisions that shaped a request:
+// experiment variants, feature flags, the upstream target variant, caching,
+// deduplication and deprecation
+func appliedPolicies(c *gin.Context) []string {
+	var policies []string
+	if experiments, ok := c.Get("experiments"); ok {
+		if assignments, ok := experiments.(map[string]string); ok {
+			for name, variant := range assignments {
+				policies = append(policies, "experiment:"+name+"="+variant)
+			}
+		}
+	}
+	if flags, ok := c.Get("feature_flags"); ok {
+		if results, ok := flags.(map[string]bool); ok {
+			for name, on := range results {
+				state := "off"
+				if on {
+					state = "on"
+				}
+				policies = append(policies, "flag:"+name+"="+state)
+			}
+		}
+	}
+	sort.Strings(policies)
+
+	if variant := c.GetString("upstream_variant"); variant != "" {
+		policies = append(policies, "target:"+variant)
+	}
+	header := c.Writer.Header()
+	if cache := header.Get("X-Cache"); cache != "" {
+		policies = append(policies, "cache:"+cache)
+	}
+	if header.Get("X-Deduplicated") != "" {
+		policies = append(policies, "deduplicated")
+	}
+	if header.Get("Deprecation") != "" || header.Get("Sunset") != "" {
+		policies = append(policies, "deprecated")
+	}
+	if c.Writer.Status() == http.StatusTooManyRequests {
+		policies = append(policies, "rate_limited")
+	}
+	return policies
+}
@@ -0,0 +1,49 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Timeout middleware sets a request deadline that overrides the default proxy timeout
+func Timeout(timeout time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if timeout <= 0 {
+			c.Next()
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+		defer cancel()
+
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+	}
+}
+
+// LongLived middleware lets a streaming request run for up to timeout. Like
+// Timeout it sets the proxy deadline, and it also moves the connection's read
+// and write deadlines, which the server otherwise sets to its own timeouts
+// and which would cut off a video mid-stream.
+func LongLived(timeout time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if timeout <= 0 {
+			c.Next()
+			return
+		}
+
+		deadline := time.Now().Add(timeout)
+		controller := http.NewResponseController(c.Writer)
+		controller.SetReadDeadline(deadline)
+		controller.SetWriteDeadline(deadline)
+
+		ctx, cancel := context.WithDeadline(c.Request.Context(), deadline)
+		defer cancel()
+
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+	}
+}
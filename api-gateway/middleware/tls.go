@@ -0,0 +1,60 @@
+package middleware
+
+import (
+	"crypto/tls"
+	"net/http"
+	"strings"
+
+	"github.com/YeonwooSung/instagram/api-gateway/metrics"
+	"github.com/gin-gonic/gin"
+)
+
+// TLSVersions are the TLS versions a route group can require, by name
+var TLSVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// RequireTLS middleware admits only requests that reached the edge over TLS
+// minVersion or later, and tells browsers to stay on HTTPS (HSTS). Requests
+// the gateway terminated itself are judged by their connection. Behind a TLS
+// terminating load balancer, X-Forwarded-Proto must be https, and the version
+// is read from versionHeader, which the load balancer must set (e.g. "TLSv1.3"
+// or "1.3"); without one, the load balancer is trusted to enforce the version.
+// Other requests get 403.
+func RequireTLS(minVersion uint16, versionHeader string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var version uint16
+		switch {
+		case c.Request.TLS != nil:
+			version = c.Request.TLS.Version
+		case strings.EqualFold(c.GetHeader("X-Forwarded-Proto"), "https"):
+			version = minVersion
+			if versionHeader != "" {
+				raw := strings.TrimPrefix(strings.ToUpper(c.GetHeader(versionHeader)), "TLSV")
+				if version = TLSVersions[raw]; version == 0 {
+					// Older, or not reported
+					version = tls.VersionTLS10
+				}
+			}
+		}
+
+		if version == 0 {
+			rejectTLS(c, "plaintext", "HTTPS required")
+			return
+		}
+		if version < minVersion {
+			rejectTLS(c, "version", "A newer TLS version is required")
+			return
+		}
+
+		c.Header("Strict-Transport-Security", "max-age=63072000; includeSubDomains")
+		c.Next()
+	}
+}
+
+func rejectTLS(c *gin.Context, reason, message string) {
+	metrics.Inc("gateway_tls_rejections_total", "reason", reason)
+	c.JSON(http.StatusForbidden, gin.H{"error": message})
+	c.Abort()
+}
@@ -0,0 +1,248 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"mime"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/YeonwooSung/instagram/api-gateway/metrics"
+	"github.com/gin-gonic/gin"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Transcoding formats
+const (
+	FormatMsgpack  = "msgpack"
+	FormatProtobuf = "protobuf"
+)
+
+// transcodeMediaTypes are the Accept media types of each format
+var transcodeMediaTypes = map[string]string{
+	"application/msgpack":             FormatMsgpack,
+	"application/x-msgpack":           FormatMsgpack,
+	"application/vnd.msgpack":         FormatMsgpack,
+	"application/protobuf":            FormatProtobuf,
+	"application/x-protobuf":          FormatProtobuf,
+	"application/vnd.google.protobuf": FormatProtobuf,
+}
+
+// Transcode middleware re-encodes successful JSON responses for clients
+// whose Accept header prefers one of formats over JSON. MessagePack mirrors
+// the JSON document; Protobuf encodes it as a google.protobuf.Value, so
+// clients decode any response with the well-known type. Error responses and
+// non-JSON bodies are passed through unchanged.
+func Transcode(formats []string) gin.HandlerFunc {
+	enabled := make(map[string]bool, len(formats))
+	for _, format := range formats {
+		enabled[format] = true
+	}
+
+	return func(c *gin.Context) {
+		// Every response may differ by Accept, including the JSON ones
+		c.Header("Vary", "Accept")
+		mediaType, format := negotiateFormat(c.GetHeader("Accept"), enabled)
+		// Partial content can't be re-encoded
+		if format == "" || c.GetHeader("Upgrade") != "" || c.GetHeader("Range") != "" {
+			c.Next()
+			return
+		}
+
+		// The body is re-encoded, so it must arrive uncompressed
+		c.Request.Header.Del("Accept-Encoding")
+
+		writer := newBufferedWriter(c.Writer)
+		c.Writer = writer
+		c.Next()
+		c.Writer = writer.ResponseWriter
+
+		body := writer.body.Bytes()
+		header := writer.Header()
+		contentType, _, _ := mime.ParseMediaType(header.Get("Content-Type"))
+		if writer.status >= 200 && writer.status < 300 && contentType == "application/json" && len(body) > 0 {
+			encoded, err := transcode(format, body)
+			if err == nil {
+				metrics.Inc("gateway_transcoded_responses_total", "format", format)
+				header.Set("Content-Type", mediaType+transcodeParams(format))
+				header.Del("Content-Encoding")
+				body = encoded
+			}
+		}
+		writer.flush(body)
+	}
+}
+
+// negotiateFormat picks the enabled format the client prefers over JSON,
+// returning the media type it asked for. Equal preferences go to the range
+// listed first.
+func negotiateFormat(accept string, enabled map[string]bool) (string, string) {
+	if accept == "" || len(enabled) == 0 {
+		return "", ""
+	}
+
+	type candidate struct {
+		mediaType string
+		format    string
+		q         float64
+	}
+	var candidates []candidate
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if raw, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(raw, 64); err != nil {
+				continue
+			}
+		}
+		if q <= 0 {
+			continue
+		}
+		format := transcodeMediaTypes[mediaType]
+		switch {
+		case format != "" && enabled[format]:
+		case mediaType == "application/json" || mediaType == "application/*" || mediaType == "*/*":
+			format = ""
+		default:
+			continue
+		}
+		candidates = append(candidates, candidate{mediaType: mediaType, format: format, q: q})
+	}
+	if len(candidates) == 0 {
+		return "", ""
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].q > candidates[j].q
+	})
+	return candidates[0].mediaType, candidates[0].format
+}
+
+// transcodeParams names the message type of Protobuf responses
+func transcodeParams(format string) string {
+	if format == FormatProtobuf {
+		return "; proto=google.protobuf.Value"
+	}
+	return ""
+}
+
+func transcode(format string, body []byte) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+
+	switch format {
+	case FormatMsgpack:
+		return msgpack.Marshal(msgpackValue(value))
+	case FormatProtobuf:
+		var buf bytes.Buffer
+		protoValue(&buf, value)
+		return buf.Bytes(), nil
+	}
+	return nil, fmt.Errorf("unknown format %q", format)
+}
+
+// msgpackValue converts JSON numbers to integers where they are whole, so
+// they are packed in their compact integer forms
+func msgpackValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return n
+		}
+		f, _ := v.Float64()
+		return f
+	case []interface{}:
+		for i, item := range v {
+			v[i] = msgpackValue(item)
+		}
+	case map[string]interface{}:
+		for key, item := range v {
+			v[key] = msgpackValue(item)
+		}
+	}
+	return value
+}
+
+// Protobuf wire types
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+)
+
+// protoValue appends a JSON value encoded as a google.protobuf.Value:
+// null_value = 1, number_value = 2, string_value = 3, bool_value = 4,
+// struct_value = 5 and list_value = 6
+func protoValue(buf *bytes.Buffer, value interface{}) {
+	switch v := value.(type) {
+	case nil:
+		protoTag(buf, 1, wireVarint)
+		protoVarint(buf, 0)
+	case json.Number:
+		f, _ := v.Float64()
+		protoTag(buf, 2, wireFixed64)
+		var b [8]byte
+		binary.LittleEndian.PutUint64(b[:], math.Float64bits(f))
+		buf.Write(b[:])
+	case string:
+		protoBytes(buf, 3, []byte(v))
+	case bool:
+		protoTag(buf, 4, wireVarint)
+		if v {
+			protoVarint(buf, 1)
+		} else {
+			protoVarint(buf, 0)
+		}
+	case map[string]interface{}:
+		// google.protobuf.Struct: map<string, Value> fields = 1, in key order
+		// so equal documents encode identically
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		var fields bytes.Buffer
+		for _, key := range keys {
+			var entry, item bytes.Buffer
+			protoBytes(&entry, 1, []byte(key))
+			protoValue(&item, v[key])
+			protoBytes(&entry, 2, item.Bytes())
+			protoBytes(&fields, 1, entry.Bytes())
+		}
+		protoBytes(buf, 5, fields.Bytes())
+	case []interface{}:
+		// google.protobuf.ListValue: repeated Value values = 1
+		var values bytes.Buffer
+		for _, item := range v {
+			var encoded bytes.Buffer
+			protoValue(&encoded, item)
+			protoBytes(&values, 1, encoded.Bytes())
+		}
+		protoBytes(buf, 6, values.Bytes())
+	}
+}
+
+func protoTag(buf *bytes.Buffer, field, wireType int) {
+	protoVarint(buf, uint64(field<<3|wireType))
+}
+
+func protoVarint(buf *bytes.Buffer, v uint64) {
+	var b [binary.MaxVarintLen64]byte
+	buf.Write(b[:binary.PutUvarint(b[:], v)])
+}
+
+func protoBytes(buf *bytes.Buffer, field int, data []byte) {
+	protoTag(buf, field, wireBytes)
+	protoVarint(buf, uint64(len(data)))
+	buf.Write(data)
+}
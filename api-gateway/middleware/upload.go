@@ -0,0 +1,485 @@
+package middleware
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/YeonwooSung/instagram/api-gateway/metrics"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// maxPartHeaderBytes bounds the headers of one multipart part
+	maxPartHeaderBytes = 16 << 10
+
+	// sniffBytes is how much of a file's content its media type is sniffed from
+	sniffBytes = 512
+
+	// maxPrimeBytes bounds how much of a body is read ahead to check its first
+	// file's media type before the request is passed on
+	maxPrimeBytes = 64 << 10
+)
+
+// UploadLimits bounds uploads, multipart/form-data or a single file as the
+// body. Zero leaves a limit off.
+type UploadLimits struct {
+	// MaxBytes bounds the whole request body
+	MaxBytes int64
+
+	// MaxPartBytes bounds the content of any one part, such as a file
+	MaxPartBytes int64
+
+	// MaxParts bounds the number of parts
+	MaxParts int
+
+	// MediaTypes, when set, restricts files to content sniffed as one of
+	// these media types or type prefixes such as "image/"
+	MediaTypes []string
+
+	// TypeMaxBytes bounds files by media type or type prefix; the longest
+	// matching prefix applies. Declared sizes are checked against the
+	// declared type, and content against the sniffed one.
+	TypeMaxBytes map[string]int64
+}
+
+// checksContent reports whether files are sniffed
+func (l UploadLimits) checksContent() bool {
+	return len(l.MediaTypes) > 0 || len(l.TypeMaxBytes) > 0
+}
+
+// allows reports whether files of mediaType may be uploaded
+func (l UploadLimits) allows(mediaType string) bool {
+	return len(l.MediaTypes) == 0 || slices.ContainsFunc(l.MediaTypes, func(allowed string) bool {
+		return strings.HasPrefix(mediaType, allowed)
+	})
+}
+
+// typeLimit returns the size limit of files of mediaType, or 0 for none
+func (l UploadLimits) typeLimit(mediaType string) int64 {
+	var limit int64
+	longest := -1
+	for prefix, max := range l.TypeMaxBytes {
+		if strings.HasPrefix(mediaType, prefix) && len(prefix) > longest {
+			limit, longest = max, len(prefix)
+		}
+	}
+	return limit
+}
+
+// checkContent sniffs the media type of a file from its first bytes, failing
+// types not allowed and content contradicting the declared type (an image
+// declared but a video sent, say). Declarations too generic to contradict,
+// such as application/octet-stream, are not compared.
+func (l UploadLimits) checkContent(declared string, head []byte) (string, error) {
+	mediaType := sniffMediaType(head)
+	if !l.allows(mediaType) {
+		return mediaType, &UnsupportedMediaError{MediaType: mediaType}
+	}
+	sniffedKind, _, _ := strings.Cut(mediaType, "/")
+	declaredKind, _, _ := strings.Cut(declared, "/")
+	if (declaredKind == "image" || declaredKind == "video") && declaredKind != sniffedKind {
+		return mediaType, &UnsupportedMediaError{MediaType: mediaType, Declared: declared}
+	}
+	return mediaType, nil
+}
+
+// UnsupportedMediaError fails the read of an upload whose file content is not
+// an allowed media type, or not the declared one
+type UnsupportedMediaError struct {
+	MediaType string
+	Declared  string
+}
+
+func (e *UnsupportedMediaError) Error() string {
+	if e.Declared != "" {
+		return fmt.Sprintf("file content is %s, but was declared as %s", e.MediaType, e.Declared)
+	}
+	return fmt.Sprintf("file content is %s, which is not an allowed media type", e.MediaType)
+}
+
+// StatusCode is the status an upload failing with the error is answered with
+func (e *UnsupportedMediaError) StatusCode() int {
+	return http.StatusUnsupportedMediaType
+}
+
+// LimitUploads middleware rejects uploads exceeding limits with 413 as early
+// as the size is known. A Content-Length over MaxBytes is rejected before any
+// of the body is read. Multipart bodies are parsed incrementally as they are
+// read on: a part declaring a Content-Length over MaxPartBytes fails at its
+// headers, and a part, part count or body growing past its limit fails as
+// soon as it does. Reads then fail with *http.MaxBytesError, which the proxy
+// answers with 413 without waiting for the rest of the upload.
+//
+// With MediaTypes or TypeMaxBytes set, the first bytes of every file are
+// sniffed, and a file of another type, or contradicting its declared type,
+// fails reads with *UnsupportedMediaError (415); a file growing past the
+// limit of its type fails like any other limit. The body is read ahead up to
+// its first file, so an upload of a single invalid file is rejected before
+// it reaches the handler. A body that is not multipart is taken for a single
+// file of its Content-Type, checked likewise before the handler runs.
+func LimitUploads(limits UploadLimits) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if limits.MaxBytes > 0 && c.Request.ContentLength > limits.MaxBytes {
+			rejectUpload(c, "body")
+			return
+		}
+
+		mediaType, params, err := mime.ParseMediaType(c.GetHeader("Content-Type"))
+		if err != nil || mediaType != "multipart/form-data" {
+			if limits.checksContent() {
+				preflightFile(c, mediaType, limits)
+				return
+			}
+			if limits.MaxBytes > 0 && c.Request.Body != nil {
+				c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limits.MaxBytes)
+			}
+			c.Next()
+			return
+		}
+		boundary := params["boundary"]
+		if boundary == "" {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "Multipart boundary missing",
+			})
+			c.Abort()
+			return
+		}
+
+		guard := newMultipartGuard(c.Request.Body, boundary, limits)
+		c.Request.Body = guard
+		if limits.checksContent() {
+			primed, err := guard.prime()
+			var unsupported *UnsupportedMediaError
+			switch {
+			case errors.As(err, &unsupported):
+				rejectMedia(c, guard.exceeded, unsupported)
+				return
+			case guard.exceeded != "":
+				rejectUpload(c, guard.exceeded)
+				return
+			}
+			c.Request.Body = &primedBody{Reader: io.MultiReader(bytes.NewReader(primed), guard), body: guard}
+		}
+		c.Next()
+		if guard.exceeded != "" {
+			metrics.Inc("gateway_upload_rejections_total", "limit", guard.exceeded)
+		}
+	}
+}
+
+// preflightFile checks a body holding a single file of mediaType: its type
+// and declared size before reading any of it, then its sniffed content
+func preflightFile(c *gin.Context, mediaType string, limits UploadLimits) {
+	if !limits.allows(mediaType) {
+		metrics.Inc("gateway_upload_rejections_total", "limit", "media_type")
+		c.Header("Connection", "close")
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{
+			"error": "Content-Type is not an allowed media type",
+		})
+		c.Abort()
+		return
+	}
+	limit := limits.typeLimit(mediaType)
+	if limit > 0 && c.Request.ContentLength > limit {
+		rejectUpload(c, "type")
+		return
+	}
+
+	head := make([]byte, sniffBytes)
+	n, err := io.ReadFull(c.Request.Body, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Failed to read request body",
+		})
+		c.Abort()
+		return
+	}
+	head = head[:n]
+	sniffed, err := limits.checkContent(mediaType, head)
+	var unsupported *UnsupportedMediaError
+	if errors.As(err, &unsupported) {
+		rejectMedia(c, "media_type", unsupported)
+		return
+	}
+
+	// The rest is bounded by the body limit and the sniffed type's
+	if sniffedLimit := limits.typeLimit(sniffed); sniffedLimit > 0 && (limit == 0 || sniffedLimit < limit) {
+		limit = sniffedLimit
+	}
+	if limits.MaxBytes > 0 && (limit == 0 || limits.MaxBytes < limit) {
+		limit = limits.MaxBytes
+	}
+	body := c.Request.Body
+	c.Request.Body = &primedBody{Reader: io.MultiReader(bytes.NewReader(head), body), body: body}
+	if limit > 0 {
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limit)
+	}
+	c.Next()
+}
+
+// rejectMedia answers an upload whose content failed its media type check
+func rejectMedia(c *gin.Context, limit string, err *UnsupportedMediaError) {
+	metrics.Inc("gateway_upload_rejections_total", "limit", limit)
+	c.Header("Connection", "close")
+	c.JSON(http.StatusUnsupportedMediaType, gin.H{
+		"error": err.Error(),
+	})
+	c.Abort()
+}
+
+func rejectUpload(c *gin.Context, limit string) {
+	metrics.Inc("gateway_upload_rejections_total", "limit", limit)
+	// The rest of the upload is never read, so don't keep the connection
+	c.Header("Connection", "close")
+	c.JSON(http.StatusRequestEntityTooLarge, gin.H{
+		"error": "Upload too large",
+	})
+	c.Abort()
+}
+
+// multipartGuard passes a multipart body through while tracking its parts,
+// failing the read that takes it past a limit
+type multipartGuard struct {
+	body   io.ReadCloser
+	limits UploadLimits
+	delim  []byte
+
+	// pending holds bytes not yet scanned: the start of a possible delimiter
+	// or part headers not yet complete
+	pending   []byte
+	total     int64
+	parts     int
+	partBytes int64
+	inHeaders bool
+	done      bool
+
+	// sniff collects the start of the current part's content while its
+	// media type is to be checked; sniffed is set once a file was checked.
+	// declared is the part's declared media type and typeLimit the size
+	// limit of its sniffed one.
+	sniffing  bool
+	sniff     []byte
+	sniffed   bool
+	declared  string
+	typeLimit int64
+
+	exceeded string
+	err      error
+}
+
+func newMultipartGuard(body io.ReadCloser, boundary string, limits UploadLimits) *multipartGuard {
+	return &multipartGuard{
+		body:   body,
+		limits: limits,
+		delim:  []byte("\r\n--" + boundary),
+		// The first delimiter may open the body without a preceding CRLF
+		pending: []byte("\r\n"),
+	}
+}
+
+func (g *multipartGuard) Read(p []byte) (int, error) {
+	if g.err != nil {
+		return 0, g.err
+	}
+	n, err := g.body.Read(p)
+	g.total += int64(n)
+	if g.limits.MaxBytes > 0 && g.total > g.limits.MaxBytes {
+		g.fail("body", g.limits.MaxBytes)
+	} else if !g.done {
+		g.scan(p[:n])
+	}
+	if g.err != nil {
+		return 0, g.err
+	}
+	return n, err
+}
+
+func (g *multipartGuard) Close() error {
+	return g.body.Close()
+}
+
+func (g *multipartGuard) fail(limit string, size int64) {
+	g.exceeded = limit
+	g.err = &http.MaxBytesError{Limit: size}
+}
+
+// prime reads the body up to and including the sniffed start of its first
+// file, returning what it read for the body to be replayed from
+func (g *multipartGuard) prime() ([]byte, error) {
+	var primed []byte
+	buf := make([]byte, 4096)
+	for !g.sniffed && !g.done && len(primed) < maxPrimeBytes {
+		n, err := g.Read(buf)
+		primed = append(primed, buf[:n]...)
+		if err != nil {
+			if err == io.EOF {
+				err = nil
+			}
+			return primed, err
+		}
+	}
+	return primed, nil
+}
+
+// scan advances the part state machine over the next chunk of the body
+func (g *multipartGuard) scan(data []byte) {
+	g.pending = append(g.pending, data...)
+	buf := g.pending
+	for g.err == nil && !g.done {
+		if g.inHeaders {
+			end := bytes.Index(buf, []byte("\r\n\r\n"))
+			if end < 0 {
+				if len(buf) > maxPartHeaderBytes {
+					g.fail("part_headers", maxPartHeaderBytes)
+				}
+				break
+			}
+			g.checkPartHeaders(buf[:end])
+			buf = buf[end+4:]
+			g.inHeaders = false
+			g.partBytes = 0
+			continue
+		}
+
+		i := bytes.Index(buf, g.delim)
+		if i < 0 {
+			// Everything but a possible delimiter prefix is part content
+			if keep := len(g.delim) - 1; len(buf) > keep {
+				g.addPartContent(buf[:len(buf)-keep], false)
+				buf = buf[len(buf)-keep:]
+			}
+			break
+		}
+		if g.addPartContent(buf[:i], true); g.err != nil {
+			break
+		}
+		// The delimiter is followed by "--" on the last one
+		after := buf[i+len(g.delim):]
+		if len(after) < 2 {
+			buf = buf[i:]
+			break
+		}
+		if after[0] == '-' && after[1] == '-' {
+			g.done = true
+			break
+		}
+		g.parts++
+		if g.limits.MaxParts > 0 && g.parts > g.limits.MaxParts {
+			g.fail("parts", int64(g.limits.MaxParts))
+			break
+		}
+		buf = after
+		g.inHeaders = true
+	}
+	g.pending = append(g.pending[:0], buf...)
+}
+
+// addPartContent counts content of the current part, last being the end of
+// it; the preamble before the first part isn't one
+func (g *multipartGuard) addPartContent(content []byte, last bool) {
+	if g.parts == 0 {
+		return
+	}
+	g.partBytes += int64(len(content))
+	if g.limits.MaxPartBytes > 0 && g.partBytes > g.limits.MaxPartBytes {
+		g.fail("part", g.limits.MaxPartBytes)
+		return
+	}
+
+	if g.sniffing {
+		g.sniff = append(g.sniff, content[:min(len(content), sniffBytes-len(g.sniff))]...)
+		if len(g.sniff) < sniffBytes && !last {
+			return
+		}
+		g.sniffing = false
+		g.sniffed = true
+		mediaType, err := g.limits.checkContent(g.declared, g.sniff)
+		if err != nil {
+			g.exceeded = "media_type"
+			g.err = err
+			return
+		}
+		g.typeLimit = g.limits.typeLimit(mediaType)
+	}
+	if g.typeLimit > 0 && g.partBytes > g.typeLimit {
+		g.fail("type", g.typeLimit)
+	}
+}
+
+// checkPartHeaders fails parts declaring a size over the part limit or the
+// limit of their declared type, and starts sniffing the content of file parts
+func (g *multipartGuard) checkPartHeaders(headers []byte) {
+	g.declared, g.typeLimit = "", 0
+	size := int64(-1)
+	file := false
+	for _, line := range strings.Split(string(headers), "\r\n") {
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+		switch {
+		case strings.EqualFold(name, "Content-Length"):
+			if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+				size = n
+			}
+		case strings.EqualFold(name, "Content-Type"):
+			g.declared, _, _ = mime.ParseMediaType(value)
+		case strings.EqualFold(name, "Content-Disposition"):
+			if _, params, err := mime.ParseMediaType(value); err == nil {
+				_, file = params["filename"]
+			}
+		}
+	}
+
+	if g.limits.MaxPartBytes > 0 && size > g.limits.MaxPartBytes {
+		g.fail("part", g.limits.MaxPartBytes)
+		return
+	}
+	if !file || !g.limits.checksContent() {
+		return
+	}
+	if limit := g.limits.typeLimit(g.declared); limit > 0 && size > limit {
+		g.fail("type", limit)
+		return
+	}
+	g.sniffing = true
+	g.sniff = g.sniff[:0]
+}
+
+// sniffMediaType detects the media type of a file from its first bytes. ISO
+// media files (MP4, QuickTime, HEIF) are told apart by their brand;
+// http.DetectContentType only knows the "mp4" ones.
+func sniffMediaType(data []byte) string {
+	if len(data) >= 12 && string(data[4:8]) == "ftyp" {
+		switch brand := string(data[8:12]); brand {
+		case "heic", "heix", "heim", "heis", "hevc", "hevx", "mif1", "msf1":
+			return "image/heic"
+		case "avif", "avis":
+			return "image/avif"
+		case "qt  ":
+			return "video/quicktime"
+		case "isom", "iso2", "avc1", "M4V ", "3gp4", "3gp5", "3g2a":
+			return "video/mp4"
+		}
+	}
+	return http.DetectContentType(data)
+}
+
+// primedBody replays the bytes read ahead of the handler before the rest of
+// the body
+type primedBody struct {
+	io.Reader
+	body io.Closer
+}
+
+func (b *primedBody) Close() error {
+	return b.body.Close()
+}
@@ -0,0 +1,57 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"go.uber.org/zap"
+)
+
+// WebhookPublisher queues webhooks of an event to the partners subscribed to
+// an account, like webhooks.Manager.Publish
+type WebhookPublisher func(ctx context.Context, eventType, accountID string, data json.RawMessage) error
+
+// WebhookEvent middleware publishes eventType for the signed-in user once
+// the request succeeded, if they have a business account; partner
+// integrations only follow business accounts. The JSON response, such as the
+// created post, is the event's data. It must run after (optional) JWT auth.
+func WebhookEvent(publish WebhookPublisher, eventType string, logger *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims, _ := c.Get("claims")
+		mapClaims, _ := claims.(jwt.MapClaims)
+		userID, exists := c.Get("user_id")
+		if accountType, _ := mapClaims["account_type"].(string); accountType != "business" || !exists {
+			c.Next()
+			return
+		}
+
+		recorder := newResponseRecorder(c.Writer)
+		c.Writer = recorder
+		c.Next()
+
+		if c.Writer.Status() < http.StatusOK || c.Writer.Status() >= http.StatusMultipleChoices {
+			return
+		}
+		var data json.RawMessage
+		if json.Valid(recorder.body.Bytes()) {
+			data = recorder.body.Bytes()
+		}
+
+		// The change already happened; don't lose its webhooks to a client
+		// disconnecting
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(c.Request.Context()), time.Second)
+		defer cancel()
+		if err := publish(ctx, eventType, fmt.Sprintf("%v", userID), data); err != nil {
+			logger.Error("Failed to queue webhooks",
+				zap.String("event", eventType),
+				zap.String("route", c.FullPath()),
+				zap.Error(err),
+			)
+		}
+	}
+}
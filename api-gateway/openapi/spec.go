@@ -0,0 +1,350 @@
+// Package openapi validates requests against the OpenAPI 3 specs of the
+// backend services before they are proxied, and responses against the
+// schemas the specs declare for them.
+package openapi
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Spec is the subset of an OpenAPI 3 document the gateway validates against:
+// path templates, operation parameters, JSON request bodies and JSON responses
+type Spec struct {
+	Name       string
+	Paths      map[string]*PathItem `yaml:"paths"`
+	Components Components           `yaml:"components"`
+
+	routes []*route
+}
+
+// Components holds the reusable objects $refs may point to
+type Components struct {
+	Schemas       map[string]*Schema      `yaml:"schemas"`
+	Parameters    map[string]*Parameter   `yaml:"parameters"`
+	RequestBodies map[string]*RequestBody `yaml:"requestBodies"`
+	Responses     map[string]*Response    `yaml:"responses"`
+}
+
+// PathItem is the set of operations on one path template
+type PathItem struct {
+	Parameters []*Parameter `yaml:"parameters"`
+	Get        *Operation   `yaml:"get"`
+	Put        *Operation   `yaml:"put"`
+	Post       *Operation   `yaml:"post"`
+	Delete     *Operation   `yaml:"delete"`
+	Patch      *Operation   `yaml:"patch"`
+	Head       *Operation   `yaml:"head"`
+	Options    *Operation   `yaml:"options"`
+}
+
+// Operation is one method of a path
+type Operation struct {
+	Parameters  []*Parameter         `yaml:"parameters"`
+	RequestBody *RequestBody         `yaml:"requestBody"`
+	Responses   map[string]*Response `yaml:"responses"`
+}
+
+// Parameter is a path, query, header or cookie parameter
+type Parameter struct {
+	Ref      string  `yaml:"$ref"`
+	Name     string  `yaml:"name"`
+	In       string  `yaml:"in"`
+	Required bool    `yaml:"required"`
+	Explode  *bool   `yaml:"explode"`
+	Schema   *Schema `yaml:"schema"`
+}
+
+// RequestBody declares the accepted request media types
+type RequestBody struct {
+	Ref      string               `yaml:"$ref"`
+	Required bool                 `yaml:"required"`
+	Content  map[string]MediaType `yaml:"content"`
+}
+
+// Response declares the media types of one response status
+type Response struct {
+	Ref     string               `yaml:"$ref"`
+	Content map[string]MediaType `yaml:"content"`
+}
+
+// MediaType is the schema of one request or response media type
+type MediaType struct {
+	Schema *Schema `yaml:"schema"`
+}
+
+// Schema is the subset of JSON Schema keywords the validator enforces
+type Schema struct {
+	Ref                  string             `yaml:"$ref"`
+	Type                 string             `yaml:"type"`
+	Nullable             bool               `yaml:"nullable"`
+	Enum                 []interface{}      `yaml:"enum"`
+	Properties           map[string]*Schema `yaml:"properties"`
+	Required             []string           `yaml:"required"`
+	AdditionalProperties *Additional        `yaml:"additionalProperties"`
+	Items                *Schema            `yaml:"items"`
+	Minimum              *float64           `yaml:"minimum"`
+	Maximum              *float64           `yaml:"maximum"`
+	MinLength            *int               `yaml:"minLength"`
+	MaxLength            *int               `yaml:"maxLength"`
+	MinItems             *int               `yaml:"minItems"`
+	MaxItems             *int               `yaml:"maxItems"`
+	Pattern              string             `yaml:"pattern"`
+	AllOf                []*Schema          `yaml:"allOf"`
+	AnyOf                []*Schema          `yaml:"anyOf"`
+	OneOf                []*Schema          `yaml:"oneOf"`
+
+	pattern *regexp.Regexp
+}
+
+// Additional is additionalProperties: either a boolean or a schema
+type Additional struct {
+	Allowed bool
+	Schema  *Schema
+}
+
+// UnmarshalYAML accepts both forms of additionalProperties
+func (a *Additional) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		return node.Decode(&a.Allowed)
+	}
+	a.Allowed = true
+	return node.Decode(&a.Schema)
+}
+
+// route is a compiled path template with the operations served on it
+type route struct {
+	template string
+	segments []string
+	literals int
+	item     *PathItem
+}
+
+// Load reads an OpenAPI 3 spec in YAML or JSON and compiles its paths and patterns
+func Load(name, path string) (*Spec, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read spec %s: %w", path, err)
+	}
+
+	spec := &Spec{Name: name}
+	if err := yaml.Unmarshal(data, spec); err != nil {
+		return nil, fmt.Errorf("parse spec %s: %w", path, err)
+	}
+
+	for template, item := range spec.Paths {
+		if !strings.HasPrefix(template, "/") || item == nil {
+			return nil, fmt.Errorf("spec %s: invalid path %q", path, template)
+		}
+		r := &route{template: template, segments: strings.Split(strings.Trim(template, "/"), "/"), item: item}
+		for _, segment := range r.segments {
+			if !isParamSegment(segment) {
+				r.literals++
+			}
+		}
+		spec.routes = append(spec.routes, r)
+	}
+
+	if err := spec.compilePatterns(); err != nil {
+		return nil, fmt.Errorf("spec %s: %w", path, err)
+	}
+	return spec, nil
+}
+
+// compilePatterns compiles the pattern keyword of every schema in the spec
+func (s *Spec) compilePatterns() error {
+	seen := make(map[*Schema]bool)
+	var walk func(schema *Schema) error
+	walk = func(schema *Schema) error {
+		if schema == nil || seen[schema] {
+			return nil
+		}
+		seen[schema] = true
+		if schema.Pattern != "" {
+			re, err := regexp.Compile(schema.Pattern)
+			if err != nil {
+				return fmt.Errorf("invalid pattern %q: %w", schema.Pattern, err)
+			}
+			schema.pattern = re
+		}
+		children := []*Schema{schema.Items}
+		for _, property := range schema.Properties {
+			children = append(children, property)
+		}
+		if schema.AdditionalProperties != nil {
+			children = append(children, schema.AdditionalProperties.Schema)
+		}
+		children = append(children, schema.AllOf...)
+		children = append(children, schema.AnyOf...)
+		children = append(children, schema.OneOf...)
+		for _, child := range children {
+			if err := walk(child); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for _, schema := range s.Components.Schemas {
+		if err := walk(schema); err != nil {
+			return err
+		}
+	}
+	for _, param := range s.Components.Parameters {
+		if err := walk(param.Schema); err != nil {
+			return err
+		}
+	}
+	for _, body := range s.Components.RequestBodies {
+		for _, media := range body.Content {
+			if err := walk(media.Schema); err != nil {
+				return err
+			}
+		}
+	}
+	for _, response := range s.Components.Responses {
+		for _, media := range response.Content {
+			if err := walk(media.Schema); err != nil {
+				return err
+			}
+		}
+	}
+	for _, item := range s.Paths {
+		for _, param := range item.Parameters {
+			if err := walk(param.Schema); err != nil {
+				return err
+			}
+		}
+		for _, op := range item.operations() {
+			for _, param := range op.Parameters {
+				if err := walk(param.Schema); err != nil {
+					return err
+				}
+			}
+			if op.RequestBody != nil {
+				for _, media := range op.RequestBody.Content {
+					if err := walk(media.Schema); err != nil {
+						return err
+					}
+				}
+			}
+			for _, response := range op.Responses {
+				if response == nil {
+					continue
+				}
+				for _, media := range response.Content {
+					if err := walk(media.Schema); err != nil {
+						return err
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// operation returns the operation for an HTTP method, or nil
+func (p *PathItem) operation(method string) *Operation {
+	switch method {
+	case "GET":
+		return p.Get
+	case "PUT":
+		return p.Put
+	case "POST":
+		return p.Post
+	case "DELETE":
+		return p.Delete
+	case "PATCH":
+		return p.Patch
+	case "HEAD":
+		return p.Head
+	case "OPTIONS":
+		return p.Options
+	}
+	return nil
+}
+
+func (p *PathItem) operations() []*Operation {
+	var ops []*Operation
+	for _, op := range []*Operation{p.Get, p.Put, p.Post, p.Delete, p.Patch, p.Head, p.Options} {
+		if op != nil {
+			ops = append(ops, op)
+		}
+	}
+	return ops
+}
+
+// match finds the most specific path template matching path and returns it
+// with the path parameter values
+func (s *Spec) match(path string) (*route, map[string]string) {
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	var best *route
+	var bestParams map[string]string
+
+	for _, r := range s.routes {
+		if len(r.segments) != len(segments) || (best != nil && r.literals <= best.literals) {
+			continue
+		}
+		params := make(map[string]string)
+		matched := true
+		for i, segment := range r.segments {
+			if isParamSegment(segment) {
+				params[segment[1:len(segment)-1]] = segments[i]
+			} else if segment != segments[i] {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			best, bestParams = r, params
+		}
+	}
+	return best, bestParams
+}
+
+// resolveSchema follows $refs to components.schemas
+func (s *Spec) resolveSchema(schema *Schema) *Schema {
+	for depth := 0; schema != nil && schema.Ref != "" && depth < maxRefDepth; depth++ {
+		schema = s.Components.Schemas[strings.TrimPrefix(schema.Ref, "#/components/schemas/")]
+	}
+	return schema
+}
+
+func (s *Spec) resolveParameter(param *Parameter) *Parameter {
+	if param != nil && param.Ref != "" {
+		return s.Components.Parameters[strings.TrimPrefix(param.Ref, "#/components/parameters/")]
+	}
+	return param
+}
+
+func (s *Spec) resolveRequestBody(body *RequestBody) *RequestBody {
+	if body != nil && body.Ref != "" {
+		return s.Components.RequestBodies[strings.TrimPrefix(body.Ref, "#/components/requestBodies/")]
+	}
+	return body
+}
+
+// resolveResponse returns the response declared for status: the exact code,
+// then its range ("2XX"), then "default"
+func (s *Spec) resolveResponse(op *Operation, status int) *Response {
+	code := strconv.Itoa(status)
+	for _, key := range []string{code, code[:1] + "XX", "default"} {
+		response := op.Responses[key]
+		if response != nil && response.Ref != "" {
+			response = s.Components.Responses[strings.TrimPrefix(response.Ref, "#/components/responses/")]
+		}
+		if response != nil {
+			return response
+		}
+	}
+	return nil
+}
+
+func isParamSegment(segment string) bool {
+	return len(segment) > 2 && segment[0] == '{' && segment[len(segment)-1] == '}'
+}
@@ -0,0 +1,455 @@
+package openapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/YeonwooSung/instagram/api-gateway/metrics"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+const (
+	// maxValidatedBody is the largest request body checked against its
+	// schema; bigger bodies are left for the upstream to validate
+	maxValidatedBody = 1 << 20
+
+	// maxErrors caps the field errors reported for one request
+	maxErrors = 20
+
+	// maxRefDepth bounds $ref chains and schema nesting
+	maxRefDepth = 32
+)
+
+// FieldError is one validation failure, reported to the client
+type FieldError struct {
+	// Location is "path", "query", "header", "cookie" or "body"
+	Location string `json:"location"`
+
+	// Field is the parameter name, or the JSON path within the body
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Validator checks requests against the specs of every backend service
+type Validator struct {
+	specs  []*Spec
+	logger *zap.Logger
+}
+
+// NewValidator creates a validator over the given specs. Their path templates
+// are matched against the path the gateway received.
+func NewValidator(specs []*Spec, logger *zap.Logger) *Validator {
+	return &Validator{specs: specs, logger: logger}
+}
+
+// Middleware rejects requests that don't match their operation in a spec with
+// 422 and the list of field errors. Requests outside every spec pass through.
+func (v *Validator) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		spec, r, params := v.match(c.Request.URL.Path)
+		if r == nil {
+			c.Next()
+			return
+		}
+		op := r.item.operation(c.Request.Method)
+		if op == nil {
+			c.Next()
+			return
+		}
+
+		// Read the body up to the validation limit and put it back for the proxy
+		var body []byte
+		bodyComplete := true
+		if c.Request.Body != nil && c.Request.Body != http.NoBody {
+			var err error
+			body, err = io.ReadAll(io.LimitReader(c.Request.Body, maxValidatedBody+1))
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read request body"})
+				c.Abort()
+				return
+			}
+			bodyComplete = len(body) <= maxValidatedBody
+			c.Request.Body = readCloser{io.MultiReader(bytes.NewReader(body), c.Request.Body), c.Request.Body}
+		}
+
+		errs := spec.validate(c.Request, r.item, op, params, body, bodyComplete)
+		if len(errs) == 0 {
+			c.Next()
+			return
+		}
+
+		metrics.Inc("gateway_validation_failures_total", "spec", spec.Name, "path", r.template)
+		v.logger.Debug("Request failed validation",
+			zap.String("spec", spec.Name),
+			zap.String("path", r.template),
+			zap.Int("errors", len(errs)),
+		)
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"error":  "Request validation failed",
+			"errors": errs,
+		})
+		c.Abort()
+	}
+}
+
+// ValidateResponse checks a JSON response against the schema its operation
+// declares for status. It reports false when no spec declares one, so there
+// was nothing to check.
+func (v *Validator) ValidateResponse(method, path string, status int, contentType string, body []byte) ([]FieldError, bool) {
+	spec, r, _ := v.match(path)
+	if r == nil {
+		return nil, false
+	}
+	op := r.item.operation(method)
+	if op == nil {
+		return nil, false
+	}
+	response := spec.resolveResponse(op, status)
+	if response == nil {
+		return nil, false
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	media, declared := response.Content[mediaType]
+	if !declared || !isJSON(mediaType) || media.Schema == nil {
+		return nil, false
+	}
+
+	var errs errorList
+	var value interface{}
+	if err := json.Unmarshal(body, &value); err != nil {
+		errs.add("body", "", "invalid JSON: %v", err)
+		return errs, true
+	}
+	spec.validateValue(media.Schema, value, "body", "", &errs, 0)
+	return errs, true
+}
+
+// match finds the most specific path template across all specs
+func (v *Validator) match(path string) (*Spec, *route, map[string]string) {
+	var bestSpec *Spec
+	var best *route
+	var bestParams map[string]string
+	for _, spec := range v.specs {
+		if r, params := spec.match(path); r != nil && (best == nil || r.literals > best.literals) {
+			bestSpec, best, bestParams = spec, r, params
+		}
+	}
+	return bestSpec, best, bestParams
+}
+
+// readCloser reads from the buffered prefix and the rest of the original body
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// errorList collects field errors up to maxErrors
+type errorList []FieldError
+
+func (l *errorList) add(location, field, format string, args ...interface{}) {
+	if len(*l) < maxErrors {
+		*l = append(*l, FieldError{Location: location, Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+}
+
+func (s *Spec) validate(req *http.Request, item *PathItem, op *Operation, pathParams map[string]string, body []byte, bodyComplete bool) []FieldError {
+	var errs errorList
+
+	// Operation parameters override path-level ones with the same name and location
+	var params []*Parameter
+	index := make(map[string]int)
+	for _, list := range [][]*Parameter{item.Parameters, op.Parameters} {
+		for _, param := range list {
+			if param = s.resolveParameter(param); param == nil {
+				continue
+			}
+			if i, ok := index[param.In+":"+param.Name]; ok {
+				params[i] = param
+				continue
+			}
+			index[param.In+":"+param.Name] = len(params)
+			params = append(params, param)
+		}
+	}
+
+	query := req.URL.Query()
+	for _, param := range params {
+		var values []string
+		switch param.In {
+		case "path":
+			if value, ok := pathParams[param.Name]; ok {
+				if unescaped, err := url.PathUnescape(value); err == nil {
+					value = unescaped
+				}
+				values = []string{value}
+			}
+		case "query":
+			values = query[param.Name]
+		case "header":
+			values = req.Header.Values(param.Name)
+		case "cookie":
+			if cookie, err := req.Cookie(param.Name); err == nil {
+				values = []string{cookie.Value}
+			}
+		default:
+			continue
+		}
+
+		if len(values) == 0 {
+			if param.Required || param.In == "path" {
+				errs.add(param.In, param.Name, "is required")
+			}
+			continue
+		}
+		s.validateParameter(param, values, &errs)
+	}
+
+	requestBody := s.resolveRequestBody(op.RequestBody)
+	if requestBody == nil {
+		return errs
+	}
+	if len(body) == 0 {
+		if requestBody.Required {
+			errs.add("body", "", "request body is required")
+		}
+		return errs
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	media, declared := requestBody.Content[mediaType]
+	if !declared {
+		if _, any := requestBody.Content["*/*"]; !any && len(requestBody.Content) > 0 {
+			errs.add("header", "Content-Type", "unsupported media type %q", mediaType)
+		}
+		return errs
+	}
+	if !isJSON(mediaType) || media.Schema == nil || !bodyComplete {
+		return errs
+	}
+
+	var value interface{}
+	if err := json.Unmarshal(body, &value); err != nil {
+		errs.add("body", "", "invalid JSON: %v", err)
+		return errs
+	}
+	s.validateValue(media.Schema, value, "body", "", &errs, 0)
+	return errs
+}
+
+// validateParameter coerces the raw values to the parameter's schema type and validates them
+func (s *Spec) validateParameter(param *Parameter, values []string, errs *errorList) {
+	schema := s.resolveSchema(param.Schema)
+	if schema == nil {
+		return
+	}
+
+	if schema.Type == "array" {
+		// form style: repeated values when exploded (the default), otherwise comma-separated
+		if param.Explode != nil && !*param.Explode {
+			values = strings.Split(values[0], ",")
+		}
+		items := make([]interface{}, len(values))
+		itemSchema := s.resolveSchema(schema.Items)
+		for i, raw := range values {
+			coerced, ok := coerce(itemSchema, raw)
+			if !ok {
+				errs.add(param.In, fmt.Sprintf("%s[%d]", param.Name, i), "must be %s", typeName(itemSchema))
+				return
+			}
+			items[i] = coerced
+		}
+		s.validateValue(schema, items, param.In, param.Name, errs, 0)
+		return
+	}
+
+	value, ok := coerce(schema, values[0])
+	if !ok {
+		errs.add(param.In, param.Name, "must be %s", typeName(schema))
+		return
+	}
+	s.validateValue(schema, value, param.In, param.Name, errs, 0)
+}
+
+// coerce converts a raw parameter string to the JSON type of schema
+func coerce(schema *Schema, raw string) (interface{}, bool) {
+	if schema == nil {
+		return raw, true
+	}
+	switch schema.Type {
+	case "integer":
+		n, err := strconv.ParseInt(raw, 10, 64)
+		return float64(n), err == nil
+	case "number":
+		n, err := strconv.ParseFloat(raw, 64)
+		return n, err == nil
+	case "boolean":
+		b, err := strconv.ParseBool(raw)
+		return b, err == nil
+	}
+	return raw, true
+}
+
+// validateValue checks a decoded JSON value against schema, recording
+// failures at field (a JSON path such as "tags[2]" or "author.name")
+func (s *Spec) validateValue(schema *Schema, value interface{}, location, field string, errs *errorList, depth int) {
+	schema = s.resolveSchema(schema)
+	if schema == nil || depth > maxRefDepth {
+		return
+	}
+
+	for _, sub := range schema.AllOf {
+		s.validateValue(sub, value, location, field, errs, depth+1)
+	}
+	if len(schema.AnyOf) > 0 && s.countMatches(schema.AnyOf, value, depth) == 0 {
+		errs.add(location, field, "must match at least one allowed schema")
+	}
+	if len(schema.OneOf) > 0 && s.countMatches(schema.OneOf, value, depth) != 1 {
+		errs.add(location, field, "must match exactly one allowed schema")
+	}
+
+	if value == nil {
+		if schema.Type != "" && !schema.Nullable {
+			errs.add(location, field, "must not be null")
+		}
+		return
+	}
+
+	if len(schema.Enum) > 0 && !inEnum(schema.Enum, value) {
+		errs.add(location, field, "must be one of %v", schema.Enum)
+	}
+
+	switch schema.Type {
+	case "object":
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			errs.add(location, field, "must be an object")
+			return
+		}
+		for _, name := range schema.Required {
+			if _, present := object[name]; !present {
+				errs.add(location, joinField(field, name), "is required")
+			}
+		}
+		for name, property := range object {
+			if propertySchema, declared := schema.Properties[name]; declared {
+				s.validateValue(propertySchema, property, location, joinField(field, name), errs, depth+1)
+			} else if additional := schema.AdditionalProperties; additional != nil {
+				if !additional.Allowed {
+					errs.add(location, joinField(field, name), "is not allowed")
+				} else if additional.Schema != nil {
+					s.validateValue(additional.Schema, property, location, joinField(field, name), errs, depth+1)
+				}
+			}
+		}
+	case "array":
+		items, ok := value.([]interface{})
+		if !ok {
+			errs.add(location, field, "must be an array")
+			return
+		}
+		if schema.MinItems != nil && len(items) < *schema.MinItems {
+			errs.add(location, field, "must have at least %d items", *schema.MinItems)
+		}
+		if schema.MaxItems != nil && len(items) > *schema.MaxItems {
+			errs.add(location, field, "must have at most %d items", *schema.MaxItems)
+		}
+		for i, item := range items {
+			s.validateValue(schema.Items, item, location, fmt.Sprintf("%s[%d]", field, i), errs, depth+1)
+		}
+	case "string":
+		str, ok := value.(string)
+		if !ok {
+			errs.add(location, field, "must be a string")
+			return
+		}
+		length := utf8.RuneCountInString(str)
+		if schema.MinLength != nil && length < *schema.MinLength {
+			errs.add(location, field, "must be at least %d characters", *schema.MinLength)
+		}
+		if schema.MaxLength != nil && length > *schema.MaxLength {
+			errs.add(location, field, "must be at most %d characters", *schema.MaxLength)
+		}
+		if schema.pattern != nil && !schema.pattern.MatchString(str) {
+			errs.add(location, field, "must match pattern %s", schema.Pattern)
+		}
+	case "integer", "number":
+		n, ok := value.(float64)
+		if !ok {
+			errs.add(location, field, "must be %s", typeName(schema))
+			return
+		}
+		if schema.Type == "integer" && n != math.Trunc(n) {
+			errs.add(location, field, "must be an integer")
+			return
+		}
+		if schema.Minimum != nil && n < *schema.Minimum {
+			errs.add(location, field, "must be at least %v", *schema.Minimum)
+		}
+		if schema.Maximum != nil && n > *schema.Maximum {
+			errs.add(location, field, "must be at most %v", *schema.Maximum)
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			errs.add(location, field, "must be a boolean")
+		}
+	}
+}
+
+// countMatches returns how many of schemas value satisfies
+func (s *Spec) countMatches(schemas []*Schema, value interface{}, depth int) int {
+	matches := 0
+	for _, sub := range schemas {
+		var errs errorList
+		s.validateValue(sub, value, "", "", &errs, depth+1)
+		if len(errs) == 0 {
+			matches++
+		}
+	}
+	return matches
+}
+
+func inEnum(enum []interface{}, value interface{}) bool {
+	for _, allowed := range enum {
+		// YAML decodes integers as int, JSON as float64
+		if n, ok := allowed.(int); ok {
+			allowed = float64(n)
+		}
+		if reflect.DeepEqual(allowed, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func typeName(schema *Schema) string {
+	switch {
+	case schema == nil || schema.Type == "":
+		return "a value"
+	case schema.Type == "integer" || schema.Type == "array" || schema.Type == "object":
+		return "an " + schema.Type
+	}
+	return "a " + schema.Type
+}
+
+func joinField(parent, name string) string {
+	if parent == "" {
+		return name
+	}
+	return parent + "." + name
+}
+
+func isJSON(mediaType string) bool {
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
@@ -0,0 +1,421 @@
+// Package outbox delivers side effects originated by the gateway (audit
+// events, webhooks, analytics, push notifications) through a persistent Redis
+// stream. Publishing only appends to the stream; workers deliver each event
+// to the sink registered for its kind and acknowledge it afterwards, so
+// events survive gateway crashes and are delivered at least once. Events that
+// keep failing are moved to a dead-letter stream for inspection and replay.
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/YeonwooSung/instagram/api-gateway/metrics"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+// Event kinds delivered through the outbox
+const (
+	KindAudit     = "audit"
+	KindWebhook   = "webhook"
+	KindAnalytics = "analytics"
+	KindPush      = "push"
+	KindAlert     = "alert"
+)
+
+// consumerGroup is the stream consumer group shared by all gateway instances
+const consumerGroup = "gateway"
+
+// ErrNoSink is returned when publishing an event kind without a sink
+var ErrNoSink = errors.New("no outbox sink for event kind")
+
+// Event is one side effect waiting for delivery. ID is the stream entry ID;
+// it is stable across redeliveries, so sinks can use it to deduplicate.
+type Event struct {
+	ID        string          `json:"id"`
+	Kind      string          `json:"kind"`
+	Key       string          `json:"key,omitempty"`
+	Payload   json.RawMessage `json:"payload"`
+	CreatedAt time.Time       `json:"created_at"`
+	Attempts  int64           `json:"attempts"`
+}
+
+// DeadLetter is an event that exhausted its delivery attempts
+type DeadLetter struct {
+	Event
+	DeadID   string    `json:"dead_id"`
+	Error    string    `json:"error"`
+	FailedAt time.Time `json:"failed_at"`
+}
+
+// Sink delivers events of one kind. Returning an error leaves the event
+// pending for a retry, unless it is wrapped with Permanent.
+type Sink interface {
+	Deliver(ctx context.Context, event Event) error
+}
+
+// SinkFunc adapts a function to a Sink
+type SinkFunc func(ctx context.Context, event Event) error
+
+// Deliver calls f
+func (f SinkFunc) Deliver(ctx context.Context, event Event) error {
+	return f(ctx, event)
+}
+
+type permanentError struct{ err error }
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+// Permanent marks a delivery error that retrying cannot fix; the event is
+// dead-lettered immediately
+func Permanent(err error) error {
+	return permanentError{err: err}
+}
+
+// IsPermanent reports whether err was marked with Permanent
+func IsPermanent(err error) bool {
+	var permanent permanentError
+	return errors.As(err, &permanent)
+}
+
+// Options configures the outbox stream and its workers
+type Options struct {
+	// Stream is the Redis stream key; dead letters go to "<Stream>:dead"
+	Stream string
+
+	// MaxLen caps the approximate length of both streams
+	MaxLen int64
+
+	// MaxAttempts is the number of deliveries before an event is dead-lettered
+	MaxAttempts int64
+
+	// RetryAfter is how long a failed or unacknowledged event stays pending
+	// before a worker claims it again
+	RetryAfter time.Duration
+
+	// DeliveryTimeout bounds a single delivery
+	DeliveryTimeout time.Duration
+}
+
+// Outbox publishes events to the stream and runs the delivery workers
+type Outbox struct {
+	redis  *redis.Client
+	opts   Options
+	sinks  map[string]Sink
+	logger *zap.Logger
+}
+
+// New creates an outbox on the given Redis client. Sinks must be registered
+// before Run is called.
+func New(client *redis.Client, opts Options, logger *zap.Logger) *Outbox {
+	return &Outbox{
+		redis:  client,
+		opts:   opts,
+		sinks:  make(map[string]Sink),
+		logger: logger,
+	}
+}
+
+// Register sets the sink of an event kind
+func (o *Outbox) Register(kind string, sink Sink) {
+	o.sinks[kind] = sink
+}
+
+// Kinds returns the event kinds with a sink
+func (o *Outbox) Kinds() []string {
+	kinds := make([]string, 0, len(o.sinks))
+	for kind := range o.sinks {
+		kinds = append(kinds, kind)
+	}
+	return kinds
+}
+
+// Publish appends an event to the outbox. key identifies the subject of the
+// event (a user, a webhook endpoint) for sinks that need ordering or routing.
+// A nil Outbox discards events, so callers need not check whether it is set up.
+func (o *Outbox) Publish(ctx context.Context, kind, key string, payload interface{}) error {
+	if o == nil {
+		return nil
+	}
+	if _, ok := o.sinks[kind]; !ok {
+		metrics.Inc("gateway_outbox_published_total", "kind", kind, "result", "no_sink")
+		return fmt.Errorf("%w: %s", ErrNoSink, kind)
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	err = o.redis.XAdd(ctx, &redis.XAddArgs{
+		Stream: o.opts.Stream,
+		MaxLen: o.opts.MaxLen,
+		Approx: true,
+		Values: map[string]interface{}{
+			"kind":       kind,
+			"key":        key,
+			"payload":    body,
+			"created_at": time.Now().UnixMilli(),
+		},
+	}).Err()
+	if err != nil {
+		metrics.Inc("gateway_outbox_published_total", "kind", kind, "result", "error")
+		return err
+	}
+	metrics.Inc("gateway_outbox_published_total", "kind", kind, "result", "ok")
+	return nil
+}
+
+// Run starts the given number of delivery workers, which run until ctx is
+// cancelled. Each worker reads new events and reclaims events left pending by
+// failed deliveries or crashed instances.
+func (o *Outbox) Run(ctx context.Context, workers int) {
+	host, _ := os.Hostname()
+	for i := 0; i < workers; i++ {
+		go o.work(ctx, fmt.Sprintf("%s-%d-%d", host, os.Getpid(), i))
+	}
+}
+
+func (o *Outbox) work(ctx context.Context, consumer string) {
+	ready := false
+	for ctx.Err() == nil {
+		if !ready {
+			if err := o.ensureGroup(ctx); err != nil {
+				o.pause(ctx, err)
+				continue
+			}
+			ready = true
+		}
+
+		claimed, _, err := o.redis.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+			Stream:   o.opts.Stream,
+			Group:    consumerGroup,
+			Consumer: consumer,
+			MinIdle:  o.opts.RetryAfter,
+			Start:    "0-0",
+			Count:    32,
+		}).Result()
+		if err != nil {
+			// The stream may have been deleted along with its group
+			ready = false
+			o.pause(ctx, err)
+			continue
+		}
+		for _, msg := range claimed {
+			o.deliver(ctx, msg, o.attempts(ctx, msg.ID))
+		}
+
+		streams, err := o.redis.XReadGroup(ctx, &redis.XReadGroupArgs{
+			Group:    consumerGroup,
+			Consumer: consumer,
+			Streams:  []string{o.opts.Stream, ">"},
+			Count:    32,
+			Block:    2 * time.Second,
+		}).Result()
+		if err != nil {
+			if !errors.Is(err, redis.Nil) {
+				o.pause(ctx, err)
+			}
+			continue
+		}
+		for _, stream := range streams {
+			for _, msg := range stream.Messages {
+				o.deliver(ctx, msg, 1)
+			}
+		}
+	}
+}
+
+// ensureGroup creates the stream and consumer group on first use
+func (o *Outbox) ensureGroup(ctx context.Context) error {
+	err := o.redis.XGroupCreateMkStream(ctx, o.opts.Stream, consumerGroup, "0").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return err
+	}
+	return nil
+}
+
+// pause backs off after a Redis error
+func (o *Outbox) pause(ctx context.Context, err error) {
+	if ctx.Err() != nil {
+		return
+	}
+	o.logger.Warn("Outbox worker failed to read stream", zap.Error(err))
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+	}
+}
+
+// attempts returns how often a pending event has been delivered
+func (o *Outbox) attempts(ctx context.Context, id string) int64 {
+	pending, err := o.redis.XPendingExt(ctx, &redis.XPendingExtArgs{
+		Stream: o.opts.Stream,
+		Group:  consumerGroup,
+		Start:  id,
+		End:    id,
+		Count:  1,
+	}).Result()
+	if err != nil || len(pending) == 0 {
+		return 1
+	}
+	return pending[0].RetryCount
+}
+
+// deliver hands one event to its sink and acknowledges it on success. Failed
+// events stay pending until reclaimed, or are dead-lettered once out of
+// attempts.
+func (o *Outbox) deliver(ctx context.Context, msg redis.XMessage, attempts int64) {
+	event := parseEvent(msg)
+	event.Attempts = attempts
+
+	var err error
+	if sink, ok := o.sinks[event.Kind]; !ok {
+		err = Permanent(fmt.Errorf("%w: %s", ErrNoSink, event.Kind))
+	} else {
+		deliverCtx, cancel := context.WithTimeout(ctx, o.opts.DeliveryTimeout)
+		err = sink.Deliver(deliverCtx, event)
+		cancel()
+	}
+	if ctx.Err() != nil {
+		// Shutting down; the event stays pending for another instance
+		return
+	}
+
+	if err == nil {
+		metrics.Inc("gateway_outbox_delivered_total", "kind", event.Kind, "result", "ok")
+		o.ack(ctx, event.ID)
+		return
+	}
+
+	var permanent permanentError
+	if !errors.As(err, &permanent) && attempts < o.opts.MaxAttempts {
+		metrics.Inc("gateway_outbox_delivered_total", "kind", event.Kind, "result", "retry")
+		o.logger.Warn("Outbox delivery failed, will retry",
+			zap.String("id", event.ID),
+			zap.String("kind", event.Kind),
+			zap.Int64("attempts", attempts),
+			zap.Error(err),
+		)
+		return
+	}
+
+	metrics.Inc("gateway_outbox_delivered_total", "kind", event.Kind, "result", "dead")
+	o.logger.Error("Outbox event dead-lettered",
+		zap.String("id", event.ID),
+		zap.String("kind", event.Kind),
+		zap.Int64("attempts", attempts),
+		zap.Error(err),
+	)
+	err = o.redis.XAdd(ctx, &redis.XAddArgs{
+		Stream: o.deadStream(),
+		MaxLen: o.opts.MaxLen,
+		Approx: true,
+		Values: map[string]interface{}{
+			"id":         event.ID,
+			"kind":       event.Kind,
+			"key":        event.Key,
+			"payload":    []byte(event.Payload),
+			"created_at": event.CreatedAt.UnixMilli(),
+			"attempts":   attempts,
+			"error":      err.Error(),
+			"failed_at":  time.Now().UnixMilli(),
+		},
+	}).Err()
+	if err != nil {
+		// Leave it pending rather than lose it
+		o.logger.Error("Failed to dead-letter outbox event", zap.String("id", event.ID), zap.Error(err))
+		return
+	}
+	o.ack(ctx, event.ID)
+}
+
+func (o *Outbox) ack(ctx context.Context, id string) {
+	pipe := o.redis.TxPipeline()
+	pipe.XAck(ctx, o.opts.Stream, consumerGroup, id)
+	pipe.XDel(ctx, o.opts.Stream, id)
+	if _, err := pipe.Exec(ctx); err != nil {
+		o.logger.Warn("Failed to acknowledge outbox event", zap.String("id", id), zap.Error(err))
+	}
+}
+
+func (o *Outbox) deadStream() string {
+	return o.opts.Stream + ":dead"
+}
+
+// Stats reports the number of undelivered and dead-lettered events
+func (o *Outbox) Stats(ctx context.Context) (backlog, dead int64, err error) {
+	pipe := o.redis.Pipeline()
+	backlogCmd := pipe.XLen(ctx, o.opts.Stream)
+	deadCmd := pipe.XLen(ctx, o.deadStream())
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		return 0, 0, err
+	}
+	return backlogCmd.Val(), deadCmd.Val(), nil
+}
+
+// DeadLetters returns up to count dead-lettered events, newest first
+func (o *Outbox) DeadLetters(ctx context.Context, count int64) ([]DeadLetter, error) {
+	msgs, err := o.redis.XRevRangeN(ctx, o.deadStream(), "+", "-", count).Result()
+	if err != nil {
+		return nil, err
+	}
+	letters := make([]DeadLetter, 0, len(msgs))
+	for _, msg := range msgs {
+		letters = append(letters, parseDeadLetter(msg))
+	}
+	return letters, nil
+}
+
+// Replay publishes a dead-lettered event again and removes it from the
+// dead-letter stream. It reports false when no such dead letter exists.
+func (o *Outbox) Replay(ctx context.Context, deadID string) (bool, error) {
+	msgs, err := o.redis.XRangeN(ctx, o.deadStream(), deadID, deadID, 1).Result()
+	if err != nil || len(msgs) == 0 {
+		return false, err
+	}
+	letter := parseDeadLetter(msgs[0])
+	if err := o.Publish(ctx, letter.Kind, letter.Key, letter.Payload); err != nil {
+		return false, err
+	}
+	return true, o.redis.XDel(ctx, o.deadStream(), deadID).Err()
+}
+
+func parseEvent(msg redis.XMessage) Event {
+	return Event{
+		ID:        msg.ID,
+		Kind:      field(msg, "kind"),
+		Key:       field(msg, "key"),
+		Payload:   json.RawMessage(field(msg, "payload")),
+		CreatedAt: millis(field(msg, "created_at")),
+	}
+}
+
+func parseDeadLetter(msg redis.XMessage) DeadLetter {
+	event := parseEvent(msg)
+	event.ID = field(msg, "id")
+	event.Attempts, _ = strconv.ParseInt(field(msg, "attempts"), 10, 64)
+	return DeadLetter{
+		Event:    event,
+		DeadID:   msg.ID,
+		Error:    field(msg, "error"),
+		FailedAt: millis(field(msg, "failed_at")),
+	}
+}
+
+func field(msg redis.XMessage, name string) string {
+	value, _ := msg.Values[name].(string)
+	return value
+}
+
+func millis(value string) time.Time {
+	ms, _ := strconv.ParseInt(value, 10, 64)
+	return time.UnixMilli(ms).UTC()
+}
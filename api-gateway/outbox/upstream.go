@@ -0,0 +1,40 @@
+package outbox
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+// SendFunc performs a request against an upstream, like proxy.ProxyHandler.Send
+type SendFunc func(ctx context.Context, method, upstream, path string, header http.Header, body []byte) (int, []byte, error)
+
+// UpstreamSink delivers events by POSTing their payload to a path on an
+// upstream service. The event ID is sent as the Idempotency-Key so the
+// service can discard redeliveries. 4xx responses other than 408 and 429 are
+// permanent failures.
+func UpstreamSink(send SendFunc, upstream, path string) Sink {
+	return SinkFunc(func(ctx context.Context, event Event) error {
+		header := http.Header{}
+		header.Set("Content-Type", "application/json")
+		header.Set("Idempotency-Key", event.ID)
+		header.Set("X-Event-ID", event.ID)
+		header.Set("X-Event-Kind", event.Kind)
+		if event.Key != "" {
+			header.Set("X-Event-Key", event.Key)
+		}
+
+		status, _, err := send(ctx, http.MethodPost, upstream, path, header, event.Payload)
+		if err != nil {
+			return err
+		}
+		if status >= 200 && status < 300 {
+			return nil
+		}
+		err = fmt.Errorf("upstream returned %d", status)
+		if status >= 400 && status < 500 && status != http.StatusRequestTimeout && status != http.StatusTooManyRequests {
+			return Permanent(err)
+		}
+		return err
+	})
+}
@@ -0,0 +1,65 @@
+package proxy
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/YeonwooSung/instagram/api-gateway/metrics"
+	"go.uber.org/zap"
+)
+
+// endpointSet is the live set of backend addresses for one upstream
+type endpointSet struct {
+	endpoints []string
+	next      uint64
+}
+
+// balancer spreads requests for an upstream across its discovered endpoints.
+// Upstreams are keyed by their configured base URL; when no endpoints have
+// been discovered the configured URL itself is used.
+type balancer struct {
+	mu   sync.RWMutex
+	sets map[string]*endpointSet
+}
+
+func newBalancer() *balancer {
+	return &balancer{sets: make(map[string]*endpointSet)}
+}
+
+// pick returns the base URL to use for the next request to upstream (round robin)
+func (b *balancer) pick(upstream string) string {
+	b.mu.RLock()
+	set, exists := b.sets[upstream]
+	b.mu.RUnlock()
+	if !exists || len(set.endpoints) == 0 {
+		return upstream
+	}
+
+	n := atomic.AddUint64(&set.next, 1)
+	return set.endpoints[(n-1)%uint64(len(set.endpoints))]
+}
+
+// SetEndpoints replaces the live backend addresses (base URLs) for an upstream.
+// An empty list falls back to the configured URL.
+func (p *ProxyHandler) SetEndpoints(upstream string, endpoints []string) {
+	p.balancer.mu.Lock()
+	p.balancer.sets[upstream] = &endpointSet{endpoints: endpoints}
+	p.balancer.mu.Unlock()
+
+	metrics.Set("gateway_upstream_endpoints", int64(len(endpoints)), "upstream", upstream)
+	p.logger.Info("Upstream endpoints updated",
+		zap.String("upstream", upstream),
+		zap.Strings("endpoints", endpoints),
+	)
+}
+
+// Endpoints returns the live backend addresses for an upstream
+func (p *ProxyHandler) Endpoints(upstream string) []string {
+	p.balancer.mu.RLock()
+	defer p.balancer.mu.RUnlock()
+
+	if set, exists := p.balancer.sets[upstream]; exists && len(set.endpoints) > 0 {
+		return append([]string(nil), set.endpoints...)
+	}
+	return []string{upstream}
+}
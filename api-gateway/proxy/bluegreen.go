@@ -0,0 +1,67 @@
+package proxy
+
+import (
+	"sync/atomic"
+
+	"go.uber.org/zap"
+)
+
+// targetStats counts proxied requests and failures per resolved target URL
+type targetStats struct {
+	requests atomic.Uint64
+	errors   atomic.Uint64
+}
+
+// SetActiveTarget sends all of upstream's traffic (after routing rules and
+// canaries) to target, e.g. the live color of a blue-green deployment
+func (p *ProxyHandler) SetActiveTarget(upstream, target string) {
+	p.mu.Lock()
+	if p.active == nil {
+		p.active = make(map[string]string)
+	}
+	previous := p.active[upstream]
+	p.active[upstream] = target
+	p.mu.Unlock()
+
+	if previous != target {
+		p.logger.Info("Active upstream target switched",
+			zap.String("upstream", upstream),
+			zap.String("from", previous),
+			zap.String("to", target),
+		)
+	}
+}
+
+// activeTarget returns the target traffic for upstream currently goes to
+func (p *ProxyHandler) activeTarget(upstream string) string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if target, ok := p.active[upstream]; ok {
+		return target
+	}
+	return upstream
+}
+
+// TargetStats returns the requests proxied to target since startup and how
+// many of them failed (transport errors or 5xx responses)
+func (p *ProxyHandler) TargetStats(target string) (requests, errors uint64) {
+	value, ok := p.targets.Load(target)
+	if !ok {
+		return 0, 0
+	}
+	stats := value.(*targetStats)
+	return stats.requests.Load(), stats.errors.Load()
+}
+
+// recordTarget counts the outcome of a request proxied to target
+func (p *ProxyHandler) recordTarget(target string, success bool) {
+	value, ok := p.targets.Load(target)
+	if !ok {
+		value, _ = p.targets.LoadOrStore(target, &targetStats{})
+	}
+	stats := value.(*targetStats)
+	stats.requests.Add(1)
+	if !success {
+		stats.errors.Add(1)
+	}
+}
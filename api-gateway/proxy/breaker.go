@@ -0,0 +1,127 @@
+package proxy
+
+import (
+	"sync"
+	"time"
+
+	"github.com/YeonwooSung/instagram/api-gateway/events"
+	"github.com/YeonwooSung/instagram/api-gateway/metrics"
+)
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+// breaker is a consecutive-failure circuit breaker for one upstream. After
+// failures consecutive errors it opens for cooldown, then lets a single probe
+// request through (half-open) to decide whether to close again.
+type breaker struct {
+	upstream string
+	failures int
+	cooldown time.Duration
+
+	mu          sync.Mutex
+	state       breakerState
+	consecutive int
+	openedAt    time.Time
+	probing     bool
+}
+
+func newBreaker(upstream string, failures int, cooldown time.Duration) *breaker {
+	b := &breaker{
+		upstream: upstream,
+		failures: failures,
+		cooldown: cooldown,
+	}
+	b.setState(breakerClosed)
+	return b
+}
+
+// allow reports whether a request may be sent to the upstream
+func (b *breaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case breakerOpen:
+		if time.Since(b.openedAt) < b.cooldown {
+			metrics.Inc("gateway_breaker_rejections_total", "upstream", b.upstream)
+			return false
+		}
+		b.setState(breakerHalfOpen)
+		b.probing = true
+		return true
+	case breakerHalfOpen:
+		if b.probing {
+			metrics.Inc("gateway_breaker_rejections_total", "upstream", b.upstream)
+			return false
+		}
+		b.probing = true
+		return true
+	default:
+		return true
+	}
+}
+
+// record reports the outcome of an allowed request
+func (b *breaker) record(success bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+	if success {
+		b.consecutive = 0
+		if b.state != breakerClosed {
+			b.setState(breakerClosed)
+		}
+		return
+	}
+
+	b.consecutive++
+	if b.state == breakerHalfOpen || b.consecutive >= b.failures {
+		b.openedAt = time.Now()
+		b.setState(breakerOpen)
+	}
+}
+
+// release ends an allowed request without an outcome, such as one whose
+// client body failed, so a half-open breaker admits the next probe
+func (b *breaker) release() {
+	b.mu.Lock()
+	b.probing = false
+	b.mu.Unlock()
+}
+
+// retryAfter returns how long until an open breaker admits a probe
+func (b *breaker) retryAfter() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if remaining := b.cooldown - time.Since(b.openedAt); remaining > 0 {
+		return remaining
+	}
+	return 0
+}
+
+func (b *breaker) setState(state breakerState) {
+	previous := b.state
+	b.state = state
+	metrics.Set("gateway_breaker_state", int64(state), "upstream", b.upstream)
+
+	switch {
+	case state == breakerOpen && previous != breakerOpen:
+		events.Security(events.SecurityEvent{
+			Type:    events.SecurityBreakerOpened,
+			Details: map[string]string{"upstream": b.upstream, "cooldown": b.cooldown.String()},
+		})
+	case state == breakerClosed && previous != breakerClosed:
+		events.Security(events.SecurityEvent{
+			Type:    events.SecurityBreakerClosed,
+			Details: map[string]string{"upstream": b.upstream},
+		})
+	}
+}
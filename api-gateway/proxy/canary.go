@@ -0,0 +1,84 @@
+package proxy
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/YeonwooSung/instagram/api-gateway/metrics"
+	"github.com/YeonwooSung/instagram/api-gateway/tenants"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// Canary sends a percentage of an upstream's traffic to another version of it
+type Canary struct {
+	URL    string `json:"url"`
+	Weight int    `json:"weight"`
+}
+
+// SetCanaries replaces the canary configuration. Keys are the configured base
+// URLs of the upstreams being split.
+func (p *ProxyHandler) SetCanaries(canaries map[string]Canary) {
+	p.mu.Lock()
+	p.canaries = canaries
+	p.mu.Unlock()
+
+	for upstream, canary := range canaries {
+		p.logger.Info("Canary configured",
+			zap.String("upstream", upstream),
+			zap.String("canary", canary.URL),
+			zap.Int("weight", canary.Weight),
+		)
+	}
+}
+
+// route returns the upstream a request should go to: the tenant's own URL for
+// it, the target of a matching routing rule, the canary for requests whose sticky bucket falls within its
+// weight, otherwise the upstream's active target (itself unless switched)
+func (p *ProxyHandler) route(c *gin.Context, upstream string) string {
+	// A tenant's own upstream takes its traffic whole
+	if target := tenants.FromContext(c.Request.Context()).Upstream(upstream); target != upstream {
+		c.Set("upstream_variant", "tenant")
+		return target
+	}
+
+	if target, ok := p.matchRule(c, upstream); ok {
+		c.Set("upstream_variant", "rule")
+		return target
+	}
+
+	p.mu.RLock()
+	canary, exists := p.canaries[upstream]
+	p.mu.RUnlock()
+	if !exists || canary.Weight <= 0 {
+		return p.activeTarget(upstream)
+	}
+
+	variant, target := "stable", p.activeTarget(upstream)
+	if canaryBucket(c, upstream) < canary.Weight {
+		variant, target = "canary", canary.URL
+	}
+
+	c.Set("upstream_variant", variant)
+	metrics.Inc("gateway_canary_requests_total", "upstream", upstream, "variant", variant)
+	return target
+}
+
+// canaryBucket maps the caller to a stable bucket in [0, 100) per upstream so
+// the same user keeps hitting the same version during a rollout
+func canaryBucket(c *gin.Context, upstream string) int {
+	var key string
+	if userID, exists := c.Get("user_id"); exists {
+		key = fmt.Sprintf("user:%v", userID)
+	} else if authHeader := c.GetHeader("Authorization"); authHeader != "" {
+		sum := sha256.Sum256([]byte(authHeader))
+		key = "token:" + hex.EncodeToString(sum[:8])
+	} else {
+		key = "ip:" + c.ClientIP()
+	}
+
+	sum := sha256.Sum256([]byte(upstream + "|" + key))
+	return int(binary.BigEndian.Uint32(sum[:4]) % 100)
+}
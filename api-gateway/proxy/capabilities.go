@@ -0,0 +1,202 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/YeonwooSung/instagram/api-gateway/cache"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// capabilitiesTTL is how long a successful /capabilities probe is trusted
+	capabilitiesTTL = 5 * time.Minute
+
+	// capabilitiesFailureTTL is how long a failed probe is cached before retrying
+	capabilitiesFailureTTL = 30 * time.Second
+)
+
+// Capabilities are the optional HTTP features an upstream advertises at GET /capabilities.
+// Features an upstream does not support are synthesized by the gateway.
+type Capabilities struct {
+	ETag     bool `json:"etag"`
+	Protobuf bool `json:"protobuf"`
+	Head     bool `json:"head"`
+	Range    bool `json:"range"`
+}
+
+type capabilityEntry struct {
+	caps    Capabilities
+	expires time.Time
+}
+
+// capabilityCache caches capability probes per upstream base URL
+type capabilityCache struct {
+	mu      sync.RWMutex
+	entries map[string]capabilityEntry
+}
+
+func newCapabilityCache() *capabilityCache {
+	return &capabilityCache{entries: make(map[string]capabilityEntry)}
+}
+
+// capabilitiesFor returns the cached capabilities of an upstream, probing it when stale.
+// Upstreams without a /capabilities endpoint are treated as supporting nothing.
+func (p *ProxyHandler) capabilitiesFor(ctx context.Context, baseURL string) Capabilities {
+	p.capabilities.mu.RLock()
+	entry, exists := p.capabilities.entries[baseURL]
+	p.capabilities.mu.RUnlock()
+	if exists && time.Now().Before(entry.expires) {
+		return entry.caps
+	}
+
+	caps, err := p.probeCapabilities(ctx, baseURL)
+	ttl := capabilitiesTTL
+	if err != nil {
+		ttl = capabilitiesFailureTTL
+	}
+
+	p.capabilities.mu.Lock()
+	p.capabilities.entries[baseURL] = capabilityEntry{caps: caps, expires: time.Now().Add(ttl)}
+	p.capabilities.mu.Unlock()
+
+	return caps
+}
+
+func (p *ProxyHandler) probeCapabilities(ctx context.Context, baseURL string) (Capabilities, error) {
+	var caps Capabilities
+
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, baseURL+"/capabilities", nil)
+	if err != nil {
+		return caps, err
+	}
+
+	resp, err := p.upstreamClient(baseURL).Do(req)
+	if err != nil {
+		return caps, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		// No capability endpoint: a definitive answer, cache it normally
+		return caps, nil
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&caps); err != nil {
+		return Capabilities{}, fmt.Errorf("invalid capabilities document: %w", err)
+	}
+	return caps, nil
+}
+
+// synthesizeResponse emulates ETag and Range support for upstreams that lack it.
+// It returns the status and body to send to the client.
+func synthesizeResponse(c *gin.Context, caps Capabilities, status int, body []byte) (int, []byte) {
+	if c.Request.Method != http.MethodGet && c.Request.Method != http.MethodHead {
+		return status, body
+	}
+	if status != http.StatusOK {
+		return status, body
+	}
+
+	header := c.Writer.Header()
+
+	if !caps.ETag && header.Get("ETag") == "" {
+		etag := cache.WeakETag(body)
+		header.Set("ETag", etag)
+
+		if cache.ETagMatches(c.GetHeader("If-None-Match"), etag) {
+			header.Del("Content-Length")
+			return http.StatusNotModified, nil
+		}
+	}
+
+	if !caps.Range {
+		rangeHeader := c.GetHeader("Range")
+		if rangeHeader == "" {
+			return status, body
+		}
+
+		// A client whose If-Range validator is out of date gets the whole
+		// current body rather than a range of it
+		if ifRange := c.GetHeader("If-Range"); ifRange != "" &&
+			!ifRangeMatches(ifRange, header.Get("ETag"), header.Get("Last-Modified")) {
+			return status, body
+		}
+
+		start, end, ok := parseByteRange(rangeHeader, len(body))
+		header.Set("Accept-Ranges", "bytes")
+		header.Del("Content-Length")
+		if !ok {
+			header.Set("Content-Range", fmt.Sprintf("bytes */%d", len(body)))
+			return http.StatusRequestedRangeNotSatisfiable, nil
+		}
+
+		header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(body)))
+		return http.StatusPartialContent, body[start : end+1]
+	}
+
+	return status, body
+}
+
+// ifRangeMatches compares an If-Range validator with the response's. Entity
+// tags use the strong comparison (RFC 9110 section 13.1.5): a weak tag on
+// either side never matches, since a weakly equal body may differ byte for
+// byte and a range of it would splice two representations.
+func ifRangeMatches(ifRange, etag, lastModified string) bool {
+	if strings.HasPrefix(ifRange, "W/") || strings.HasPrefix(ifRange, `"`) {
+		return !strings.HasPrefix(ifRange, "W/") && !strings.HasPrefix(etag, "W/") && ifRange == etag
+	}
+	return lastModified != "" && ifRange == lastModified
+}
+
+// parseByteRange parses a single "bytes=start-end" range against a body of size bytes
+func parseByteRange(header string, size int) (int, int, bool) {
+	spec, found := strings.CutPrefix(header, "bytes=")
+	if !found || strings.Contains(spec, ",") || size == 0 {
+		return 0, 0, false
+	}
+
+	startStr, endStr, found := strings.Cut(strings.TrimSpace(spec), "-")
+	if !found {
+		return 0, 0, false
+	}
+
+	// Suffix range: last N bytes
+	if startStr == "" {
+		n, err := strconv.Atoi(endStr)
+		if err != nil || n <= 0 {
+			return 0, 0, false
+		}
+		if n > size {
+			n = size
+		}
+		return size - n, size - 1, true
+	}
+
+	start, err := strconv.Atoi(startStr)
+	if err != nil || start < 0 || start >= size {
+		return 0, 0, false
+	}
+
+	end := size - 1
+	if endStr != "" {
+		end, err = strconv.Atoi(endStr)
+		if err != nil || end < start {
+			return 0, 0, false
+		}
+		if end >= size {
+			end = size - 1
+		}
+	}
+
+	return start, end, true
+}
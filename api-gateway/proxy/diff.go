@@ -0,0 +1,266 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"math/rand"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/YeonwooSung/instagram/api-gateway/metrics"
+)
+
+const (
+	// maxDiffBody caps how much of a candidate response is read
+	maxDiffBody = 10 << 20
+
+	// maxDifferences caps the differing fields reported per request
+	maxDifferences = 10
+)
+
+// Comparison sends a sample of an upstream's GET traffic to a candidate
+// upstream as well and diffs the responses, to check that a rewritten service
+// answers like the current one before it is promoted. Clients always get the
+// current upstream's response.
+type Comparison struct {
+	// Candidate is the base URL of the upstream under test
+	Candidate string `json:"candidate"`
+
+	// Percent of requests compared (0-100)
+	Percent float64 `json:"percent"`
+
+	// Ignore lists volatile JSON fields left out of the diff. A bare name
+	// matches the key at any depth; a dotted path such as "items.*.updated_at"
+	// matches one location, with "*" standing for any key or array index.
+	Ignore []string `json:"ignore,omitempty"`
+
+	// Paths limits the comparison to routes with these prefixes (all when empty)
+	Paths []string `json:"paths,omitempty"`
+}
+
+// DiffResult is the outcome of one compared request
+type DiffResult struct {
+	// Route is the gin route pattern and Path the request path
+	Route string
+	Path  string
+
+	// Result is "match", "mismatch" or "error" (candidate unreachable)
+	Result string
+
+	// Differences lists the differing fields ("status" for the status code,
+	// "body" for non-JSON bodies)
+	Differences []string
+}
+
+// DiffReporter receives the result of each comparison for the upstream with
+// the given base URL
+type DiffReporter func(upstream string, result DiffResult)
+
+// SetComparisons replaces the response comparisons, keyed by the configured
+// base URL of the upstream being compared. Results go to report. At most
+// maxInflight candidate requests run at once; samples beyond that are skipped.
+func (p *ProxyHandler) SetComparisons(comparisons map[string]Comparison, report DiffReporter, maxInflight int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.comparisons = comparisons
+	p.diffReport = report
+	if p.diffSlots == nil || cap(p.diffSlots) != maxInflight {
+		p.diffSlots = make(chan struct{}, maxInflight)
+	}
+}
+
+// compare replays a sampled GET request against the upstream's candidate and
+// diffs the candidate's response with the one the client received. It never
+// blocks the caller.
+func (p *ProxyHandler) compare(upstream, route string, req *http.Request, resp *http.Response, respBody []byte) {
+	p.mu.RLock()
+	comparison, ok := p.comparisons[upstream]
+	report := p.diffReport
+	slots := p.diffSlots
+	p.mu.RUnlock()
+
+	if !ok || req.Method != http.MethodGet || rand.Float64()*100 >= comparison.Percent {
+		return
+	}
+	if !comparison.applies(req.URL.Path) || resp.Header.Get("Content-Encoding") != "" {
+		return
+	}
+
+	select {
+	case slots <- struct{}{}:
+	default:
+		metrics.Inc("gateway_diff_requests_total", "upstream", upstream, "result", "dropped")
+		return
+	}
+
+	target := comparison.Candidate + req.URL.RequestURI()
+	header := req.Header.Clone()
+	header.Set("X-Shadow-Request", "1")
+	header.Del("Accept-Encoding")
+	status := resp.StatusCode
+
+	go func() {
+		defer func() { <-slots }()
+
+		// Detached from the client request so the comparison outlives the response
+		ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+		defer cancel()
+
+		result := DiffResult{Route: route, Path: req.URL.Path, Result: "error"}
+		defer func() {
+			metrics.Inc("gateway_diff_requests_total", "upstream", upstream, "result", result.Result)
+			if report != nil {
+				report(upstream, result)
+			}
+		}()
+
+		candidateReq, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+		if err != nil {
+			return
+		}
+		candidateReq.Header = header
+
+		candidateResp, err := p.client.Do(candidateReq)
+		if err != nil {
+			return
+		}
+		defer candidateResp.Body.Close()
+		candidateBody, err := io.ReadAll(io.LimitReader(candidateResp.Body, maxDiffBody))
+		if err != nil {
+			return
+		}
+
+		result.Differences = diffResponses(status, respBody, candidateResp.StatusCode, candidateBody, comparison.Ignore)
+		result.Result = "match"
+		if len(result.Differences) > 0 {
+			result.Result = "mismatch"
+		}
+	}()
+}
+
+// applies reports whether requests for path are compared
+func (c Comparison) applies(path string) bool {
+	if len(c.Paths) == 0 {
+		return true
+	}
+	for _, prefix := range c.Paths {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// diffResponses returns where two responses differ. JSON bodies are compared
+// structurally, so key order and formatting don't count.
+func diffResponses(status int, body []byte, candidateStatus int, candidateBody []byte, ignore []string) []string {
+	var differences []string
+	if status != candidateStatus {
+		differences = append(differences, "status")
+	}
+
+	var current, candidate interface{}
+	if json.Unmarshal(body, &current) != nil || json.Unmarshal(candidateBody, &candidate) != nil {
+		if !bytes.Equal(body, candidateBody) {
+			differences = append(differences, "body")
+		}
+		return differences
+	}
+
+	diffValues(nil, current, candidate, ignore, &differences)
+	return differences
+}
+
+// diffValues appends the dotted paths at which a and b differ
+func diffValues(path []string, a, b interface{}, ignore []string, out *[]string) {
+	if len(*out) >= maxDifferences || ignored(path, ignore) {
+		return
+	}
+
+	switch av := a.(type) {
+	case map[string]interface{}:
+		bv, ok := b.(map[string]interface{})
+		if !ok {
+			break
+		}
+		keys := make([]string, 0, len(av)+len(bv))
+		for key := range av {
+			keys = append(keys, key)
+		}
+		for key := range bv {
+			if _, seen := av[key]; !seen {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			diffValues(append(path, key), av[key], bv[key], ignore, out)
+		}
+		return
+
+	case []interface{}:
+		bv, ok := b.([]interface{})
+		if !ok {
+			break
+		}
+		for i := 0; i < len(av) || i < len(bv); i++ {
+			var ai, bi interface{}
+			if i < len(av) {
+				ai = av[i]
+			}
+			if i < len(bv) {
+				bi = bv[i]
+			}
+			diffValues(append(path, strconv.Itoa(i)), ai, bi, ignore, out)
+		}
+		return
+	}
+
+	if !reflect.DeepEqual(a, b) {
+		*out = append(*out, fieldPath(path))
+	}
+}
+
+// ignored reports whether the field at path matches an ignore pattern
+func ignored(path []string, ignore []string) bool {
+	if len(path) == 0 {
+		return false
+	}
+	for _, pattern := range ignore {
+		if !strings.Contains(pattern, ".") {
+			if path[len(path)-1] == pattern {
+				return true
+			}
+			continue
+		}
+
+		segments := strings.Split(pattern, ".")
+		if len(segments) != len(path) {
+			continue
+		}
+		match := true
+		for i, segment := range segments {
+			if segment != "*" && segment != path[i] {
+				match = false
+				break
+			}
+		}
+		if match {
+			return true
+		}
+	}
+	return false
+}
+
+func fieldPath(path []string) string {
+	if len(path) == 0 {
+		return "body"
+	}
+	return strings.Join(path, ".")
+}
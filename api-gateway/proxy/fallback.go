@@ -0,0 +1,49 @@
+package proxy
+
+import (
+	"github.com/YeonwooSung/instagram/api-gateway/metrics"
+	"github.com/gin-gonic/gin"
+)
+
+// FallbackHeader marks responses served from a route's fallback
+const FallbackHeader = "X-Fallback"
+
+// Fallback is the static response of a route while its upstream's circuit
+// breaker is open, so clients degrade gracefully instead of seeing a 503
+type Fallback struct {
+	Status  int
+	Headers map[string]string
+
+	// Payload is the JSON body, sent as is
+	Payload []byte
+}
+
+// SetFallbacks replaces the route fallbacks, keyed by method and route path
+// ("GET /api/v1/feed/"). Only upstreams with a circuit breaker (see Isolate)
+// ever fall back.
+func (p *ProxyHandler) SetFallbacks(fallbacks map[string]Fallback) {
+	p.mu.Lock()
+	p.fallbacks = fallbacks
+	p.mu.Unlock()
+}
+
+// serveFallback answers the request with its route's fallback, reporting
+// whether the route has one
+func (p *ProxyHandler) serveFallback(c *gin.Context, upstream string) bool {
+	route := c.FullPath()
+	p.mu.RLock()
+	f, ok := p.fallbacks[c.Request.Method+" "+route]
+	p.mu.RUnlock()
+	if !ok {
+		return false
+	}
+
+	metrics.Inc("gateway_fallback_responses_total", "route", route, "upstream", upstream)
+	for key, value := range f.Headers {
+		c.Header(key, value)
+	}
+	c.Header(FallbackHeader, "true")
+	c.Set("problem_passthrough", true)
+	c.Data(f.Status, "application/json; charset=utf-8", f.Payload)
+	return true
+}
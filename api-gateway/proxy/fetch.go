@@ -0,0 +1,94 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/YeonwooSung/instagram/api-gateway/accounting"
+	"github.com/YeonwooSung/instagram/api-gateway/tenants"
+	"github.com/YeonwooSung/instagram/api-gateway/timing"
+)
+
+// ErrBreakerOpen is returned for calls to an isolated upstream whose breaker is open
+var ErrBreakerOpen = errors.New("upstream circuit breaker open")
+
+// Fetch performs a GET against an upstream on behalf of a response the gateway
+// composes itself. It shares the proxy's connection pool, endpoint balancing
+// and default timeout.
+func (p *ProxyHandler) Fetch(ctx context.Context, upstream, path string, header http.Header) (int, []byte, error) {
+	return p.Send(ctx, http.MethodGet, upstream, path, header, nil)
+}
+
+// Send performs a request with an optional body against an upstream, like Fetch
+func (p *ProxyHandler) Send(ctx context.Context, method, upstream, path string, header http.Header, body []byte) (int, []byte, error) {
+	var reqBody io.Reader
+	if body != nil {
+		reqBody = bytes.NewReader(body)
+	}
+	return p.SendStream(ctx, method, upstream, path, header, reqBody, int64(len(body)))
+}
+
+// SendStream is Send with a body read from body as the request goes out, for
+// payloads too large to hold in memory. size is the body's length, or -1 when
+// unknown.
+func (p *ProxyHandler) SendStream(ctx context.Context, method, upstream, path string, header http.Header, body io.Reader, size int64) (int, []byte, error) {
+	if _, hasDeadline := ctx.Deadline(); !hasDeadline {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.timeout)
+		defer cancel()
+	}
+
+	if _, ok := p.inMaintenance(upstream); ok {
+		return 0, nil, ErrMaintenance
+	}
+
+	usage := accounting.FromContext(ctx)
+	if err := usage.Call(); err != nil {
+		return 0, nil, err
+	}
+
+	req, err := http.NewRequestWithContext(
+		withConnTrace(ctx, upstream),
+		method,
+		p.balancer.pick(tenants.FromContext(ctx).Upstream(upstream))+path,
+		body,
+	)
+	if err != nil {
+		return 0, nil, err
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+	p.copyHeaders(header, req.Header)
+	p.sign(req)
+
+	client := p.upstreamClient(upstream)
+	isolated := p.isolationFor(upstream, tenants.FromContext(ctx).Upstream(upstream))
+	if isolated != nil {
+		if !isolated.breaker.allow() {
+			return 0, nil, ErrBreakerOpen
+		}
+		client = isolated.client
+	}
+
+	call := timing.FromContext(ctx).Begin(upstream)
+	defer call.End()
+	resp, err := client.Do(req.WithContext(call.Trace(req.Context())))
+	if isolated != nil {
+		isolated.breaker.record(err == nil && resp.StatusCode < http.StatusInternalServerError)
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	usage.AddBytes(int(max(size, 0)) + len(respBody))
+	if err != nil {
+		return 0, nil, err
+	}
+	return resp.StatusCode, respBody, nil
+}
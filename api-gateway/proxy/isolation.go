@@ -0,0 +1,84 @@
+package proxy
+
+import (
+	"net/http"
+	"time"
+)
+
+// IsolationOptions configures a dedicated connection pool and circuit breaker
+// for one upstream
+type IsolationOptions struct {
+	// MaxConns caps concurrent connections to the upstream (0 for no cap;
+	// not applied to h2c upstreams)
+	MaxConns int
+
+	// BreakerFailures is the number of consecutive failures that open the breaker
+	BreakerFailures int
+
+	// BreakerCooldown is how long the breaker stays open before probing again
+	BreakerCooldown time.Duration
+}
+
+// isolatedUpstream is an upstream with its own pool and breaker
+type isolatedUpstream struct {
+	client  *http.Client
+	breaker *breaker
+	opts    IsolationOptions
+}
+
+// Isolate gives an upstream its own connection pool and circuit breaker so
+// that its failures or load cannot exhaust resources shared with other
+// upstreams. Call it before serving traffic.
+func (p *ProxyHandler) Isolate(upstream string, opts IsolationOptions) {
+	isolated := p.newIsolated(upstream, p.protocol(upstream), opts)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.isolated == nil {
+		p.isolated = make(map[string]*isolatedUpstream)
+	}
+	p.isolated[upstream] = isolated
+}
+
+func (p *ProxyHandler) newIsolated(upstream, protocol string, opts IsolationOptions) *isolatedUpstream {
+	return &isolatedUpstream{
+		client: &http.Client{
+			Transport:     newTransport(protocol, opts.MaxConns),
+			CheckRedirect: p.client.CheckRedirect,
+		},
+		breaker: newBreaker(upstream, opts.BreakerFailures, opts.BreakerCooldown),
+		opts:    opts,
+	}
+}
+
+// isolation returns the dedicated pool and breaker for an upstream, if any
+func (p *ProxyHandler) isolation(upstream string) *isolatedUpstream {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.isolated[upstream]
+}
+
+// isolationFor returns the pool and breaker for requests to upstream that go
+// to target instead, such as a tenant's own URL for it. Targets of an
+// isolated upstream get a pool and breaker of their own, created on first use
+// with the upstream's options, so one tenant's failing upstream cannot open
+// the breaker for everyone else.
+func (p *ProxyHandler) isolationFor(upstream, target string) *isolatedUpstream {
+	shared := p.isolation(upstream)
+	if shared == nil || target == upstream {
+		return shared
+	}
+	if isolated := p.isolation(target); isolated != nil {
+		return isolated
+	}
+
+	isolated := p.newIsolated(target, p.protocol(upstream), shared.opts)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if existing, ok := p.isolated[target]; ok {
+		return existing
+	}
+	p.isolated[target] = isolated
+	return isolated
+}
@@ -0,0 +1,59 @@
+package proxy
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/YeonwooSung/instagram/api-gateway/metrics"
+	"github.com/gin-gonic/gin"
+)
+
+// ErrMaintenance is returned for calls to an upstream in maintenance
+var ErrMaintenance = errors.New("upstream in maintenance")
+
+// Maintenance is what requests for an upstream in maintenance get instead
+type Maintenance struct {
+	// Payload is the JSON body of the 503 response
+	Payload []byte
+
+	// RetryAfter is sent in the Retry-After header
+	RetryAfter time.Duration
+
+	// Verbatim sends Payload as is rather than as a problem document
+	Verbatim bool
+}
+
+// SetMaintenance replaces the upstreams in maintenance, by upstream URL
+func (p *ProxyHandler) SetMaintenance(maintenance map[string]Maintenance) {
+	p.mu.Lock()
+	p.maintenance = maintenance
+	p.mu.Unlock()
+}
+
+// inMaintenance returns the maintenance response of an upstream, if it is in
+// maintenance
+func (p *ProxyHandler) inMaintenance(upstream string) (Maintenance, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	m, ok := p.maintenance[upstream]
+	return m, ok
+}
+
+// serveMaintenance answers the request for an upstream in maintenance with
+// its 503, reporting whether it did
+func (p *ProxyHandler) serveMaintenance(c *gin.Context, upstream string) bool {
+	m, ok := p.inMaintenance(upstream)
+	if !ok {
+		return false
+	}
+	metrics.Inc("gateway_maintenance_requests_total", "upstream", upstream)
+	if m.Verbatim {
+		c.Set("problem_passthrough", true)
+	}
+	c.Header("Retry-After", strconv.Itoa(int(m.RetryAfter.Seconds())))
+	c.Data(http.StatusServiceUnavailable, "application/json; charset=utf-8", m.Payload)
+	c.Abort()
+	return true
+}
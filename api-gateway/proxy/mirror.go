@@ -0,0 +1,88 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math/rand"
+	"net/http"
+
+	"github.com/YeonwooSung/instagram/api-gateway/metrics"
+	"go.uber.org/zap"
+)
+
+// Mirror asynchronously copies a share of an upstream's traffic to a shadow
+// upstream, e.g. to load-test a rewritten service with real requests.
+// Shadow responses are discarded.
+type Mirror struct {
+	// Target is the shadow upstream base URL
+	Target string
+
+	// Percent of requests copied (0-100)
+	Percent float64
+
+	// IncludeWrites also mirrors non-GET/HEAD requests
+	IncludeWrites bool
+}
+
+// SetMirrors replaces the traffic mirrors, keyed by the configured base URL of
+// the upstream whose traffic they copy. At most maxInflight shadow requests run
+// at once; copies beyond that are dropped rather than queued.
+func (p *ProxyHandler) SetMirrors(mirrors map[string]Mirror, maxInflight int) {
+	p.mu.Lock()
+	p.mirrors = mirrors
+	p.mirrorSlots = make(chan struct{}, maxInflight)
+	p.mu.Unlock()
+}
+
+// mirror sends a copy of an outgoing proxy request to the upstream's shadow, if
+// the request is sampled. It never blocks the caller.
+func (p *ProxyHandler) mirror(upstream string, req *http.Request, body []byte) {
+	p.mu.RLock()
+	m, ok := p.mirrors[upstream]
+	slots := p.mirrorSlots
+	p.mu.RUnlock()
+
+	if !ok || rand.Float64()*100 >= m.Percent {
+		return
+	}
+	if !m.IncludeWrites && req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return
+	}
+
+	select {
+	case slots <- struct{}{}:
+	default:
+		metrics.Inc("gateway_mirror_requests_total", "upstream", upstream, "result", "dropped")
+		return
+	}
+
+	target := m.Target + req.URL.RequestURI()
+	header := req.Header.Clone()
+	header.Set("X-Shadow-Request", "1")
+
+	go func() {
+		defer func() { <-slots }()
+
+		// Detached from the client request so the copy outlives the response
+		ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+		defer cancel()
+
+		shadowReq, err := http.NewRequestWithContext(ctx, req.Method, target, bytes.NewReader(body))
+		if err != nil {
+			return
+		}
+		shadowReq.Header = header
+
+		resp, err := p.client.Do(shadowReq)
+		if err != nil {
+			p.logger.Debug("Mirror request failed", zap.String("target", target), zap.Error(err))
+			metrics.Inc("gateway_mirror_requests_total", "upstream", upstream, "result", "error")
+			return
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+
+		metrics.Inc("gateway_mirror_requests_total", "upstream", upstream, "result", "sent")
+	}()
+}
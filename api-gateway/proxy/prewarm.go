@@ -0,0 +1,98 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Prewarm periodically pings each upstream to keep a pool of warm connections
+// (and resumable TLS sessions) ready. Healthy upstreams receive conns parallel
+// pings so the pool holds that many idle connections; unhealthy upstreams get
+// a single probe until they recover. It blocks until ctx is cancelled.
+func (p *ProxyHandler) Prewarm(ctx context.Context, upstreams map[string]string, interval time.Duration, conns int) {
+	if interval <= 0 {
+		return
+	}
+
+	healthy := make(map[string]bool, len(upstreams))
+	for name := range upstreams {
+		healthy[name] = true
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		for name, baseURL := range upstreams {
+			n := 1
+			if healthy[name] {
+				n = conns
+			}
+
+			// Warm each discovered endpoint, not just the service address
+			ok := true
+			for _, endpoint := range p.Endpoints(baseURL) {
+				ok = p.ping(ctx, baseURL, endpoint, n) && ok
+			}
+			if ok != healthy[name] {
+				p.logger.Info("Upstream health changed",
+					zap.String("upstream", name),
+					zap.Bool("healthy", ok),
+				)
+			}
+			healthy[name] = ok
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ping sends n concurrent lightweight HEAD requests to an endpoint's health
+// route and reports whether all of them succeeded. Each round is bounded by
+// the proxy timeout so an upstream that never answers cannot stall prewarming.
+func (p *ProxyHandler) ping(ctx context.Context, upstream, endpoint string, n int) bool {
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		failed bool
+	)
+
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			req, err := http.NewRequestWithContext(withConnTrace(ctx, upstream), http.MethodHead, endpoint+"/health", nil)
+			if err != nil {
+				mu.Lock()
+				failed = true
+				mu.Unlock()
+				return
+			}
+
+			resp, err := p.upstreamClient(upstream).Do(req)
+			if err != nil || resp.StatusCode >= http.StatusInternalServerError {
+				mu.Lock()
+				failed = true
+				mu.Unlock()
+			}
+			if resp != nil {
+				resp.Body.Close()
+			}
+		}()
+	}
+	wg.Wait()
+
+	return !failed
+}
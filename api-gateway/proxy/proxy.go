@@ -2,136 +2,429 @@ package proxy
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"mime"
 	"net/http"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/YeonwooSung/instagram/api-gateway/accounting"
+	"github.com/YeonwooSung/instagram/api-gateway/tenants"
+	"github.com/YeonwooSung/instagram/api-gateway/timing"
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
 )
 
+// streamChunkSize is the most a streamed response is buffered before flushing
+const streamChunkSize = 32 << 10
+
+// maxLoggedErrorBody is how much of an upstream error response is logged
+const maxLoggedErrorBody = 2 << 10
+
 // ProxyHandler handles reverse proxy requests to backend services
 type ProxyHandler struct {
-	client  *http.Client
-	logger  *zap.Logger
-	timeout time.Duration
+	client       *http.Client
+	logger       *zap.Logger
+	timeout      time.Duration
+	capabilities *capabilityCache
+	balancer     *balancer
+
+	mu       sync.RWMutex
+	canaries map[string]Canary
+	rules    map[string][]RoutingRule
+	isolated map[string]*isolatedUpstream
+
+	protocols map[string]string
+	clients   map[string]*http.Client
+
+	maintenance map[string]Maintenance
+	fallbacks   map[string]Fallback
+
+	mirrors     map[string]Mirror
+	mirrorSlots chan struct{}
+
+	comparisons map[string]Comparison
+	diffReport  DiffReporter
+	diffSlots   chan struct{}
+
+	active  map[string]string
+	targets sync.Map
+
+	signingSecret func() string
 }
 
 // NewProxyHandler creates a new proxy handler
 func NewProxyHandler(timeout time.Duration, logger *zap.Logger) *ProxyHandler {
 	return &ProxyHandler{
 		client: &http.Client{
-			Timeout: timeout,
+			Transport: newTransport(ProtocolHTTP2, 0),
 			CheckRedirect: func(req *http.Request, via []*http.Request) error {
 				return http.ErrUseLastResponse
 			},
 		},
-		logger:  logger,
-		timeout: timeout,
+		logger:       logger,
+		timeout:      timeout,
+		capabilities: newCapabilityCache(),
+		balancer:     newBalancer(),
 	}
 }
 
 // ProxyRequest forwards the request to the target service
 func (p *ProxyHandler) ProxyRequest(targetURL string) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// Build target URL
-		target := targetURL + c.Request.URL.Path
-		if c.Request.URL.RawQuery != "" {
-			target += "?" + c.Request.URL.RawQuery
-		}
+		p.forward(c, targetURL, false)
+	}
+}
 
-		// Read request body
-		var bodyBytes []byte
-		if c.Request.Body != nil {
-			bodyBytes, _ = io.ReadAll(c.Request.Body)
-			c.Request.Body.Close()
-		}
+// StreamRequest forwards the request like ProxyRequest, but streams the
+// request body to the target as it arrives instead of reading it first, for
+// uploads too large to hold in memory. The client is read only as fast as the
+// target reads, and a body failing midway aborts the upstream request.
+// Streamed bodies are not mirrored.
+func (p *ProxyHandler) StreamRequest(targetURL string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		p.forward(c, targetURL, true)
+	}
+}
 
-		// Create new request
-		proxyReq, err := http.NewRequestWithContext(
-			c.Request.Context(),
-			c.Request.Method,
-			target,
-			bytes.NewReader(bodyBytes),
-		)
-		if err != nil {
-			p.logger.Error("Failed to create proxy request",
-				zap.Error(err),
-				zap.String("target", target),
-			)
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"error": "Failed to create request",
+// forward proxies one request, reading its body up front unless streamBody
+func (p *ProxyHandler) forward(c *gin.Context, targetURL string, streamBody bool) {
+	if p.serveMaintenance(c, targetURL) {
+		return
+	}
+
+	// Requests over their resource budget are answered by the budget
+	// middleware, before they can take an isolated upstream's breaker probe
+	usage := accounting.FromContext(c.Request.Context())
+	if usage.Call() != nil {
+		return
+	}
+
+	// Isolated upstreams use their own pool and fail fast while their breaker is open
+	// A tenant's own URL for the upstream has a breaker of its own
+	client := p.upstreamClient(targetURL)
+	isolated := p.isolationFor(targetURL, tenants.FromContext(c.Request.Context()).Upstream(targetURL))
+	recorded := false
+	if isolated != nil {
+		if !isolated.breaker.allow() {
+			if p.serveFallback(c, targetURL) {
+				return
+			}
+			retryAfter := int(isolated.breaker.retryAfter().Seconds()) + 1
+			c.Header("Retry-After", strconv.Itoa(retryAfter))
+			c.JSON(http.StatusServiceUnavailable, gin.H{
+				"error": "Service temporarily unavailable",
 			})
 			return
 		}
+		client = isolated.client
+
+		// Requests that end without an outcome, e.g. because the client's
+		// body failed, must not hold the half-open probe
+		defer func() {
+			if !recorded {
+				isolated.breaker.release()
+			}
+		}()
+	}
 
-		// Copy headers
-		p.copyHeaders(c.Request.Header, proxyReq.Header)
+	// Apply header/cookie routing rules, then canary splits
+	upstream := targetURL
+	targetURL = p.route(c, targetURL)
+	c.Set("upstream", upstream)
 
-		// Add/override headers
-		proxyReq.Header.Set("X-Forwarded-For", c.ClientIP())
-		proxyReq.Header.Set("X-Forwarded-Proto", "http")
-		proxyReq.Header.Set("X-Real-IP", c.ClientIP())
+	// Build target URL against a live endpoint of the upstream
+	target := p.balancer.pick(targetURL) + c.Request.URL.Path
+	if c.Request.URL.RawQuery != "" {
+		target += "?" + c.Request.URL.RawQuery
+	}
 
-		// Add user context if available
-		if userID, exists := c.Get("user_id"); exists {
-			proxyReq.Header.Set("X-User-ID", fmt.Sprintf("%v", userID))
-		}
-		if username, exists := c.Get("username"); exists {
-			proxyReq.Header.Set("X-Username", fmt.Sprintf("%v", username))
+	// Read request body; a body cut off by a size limit is rejected
+	// without reading further
+	var bodyBytes []byte
+	var streamed *streamedBody
+	var body io.Reader = bytes.NewReader(nil)
+	if streamBody && c.Request.Body != nil {
+		streamed = &streamedBody{body: c.Request.Body}
+		body = streamed
+	} else if c.Request.Body != nil {
+		var err error
+		bodyBytes, err = io.ReadAll(c.Request.Body)
+		c.Request.Body.Close()
+		if err != nil {
+			rejectBody(c, err)
+			return
 		}
+		body = bytes.NewReader(bodyBytes)
+	}
 
-		// Send request
-		start := time.Now()
-		resp, err := p.client.Do(proxyReq)
-		latency := time.Since(start)
+	// Apply the default timeout unless the route already set a deadline
+	ctx := c.Request.Context()
+	if _, hasDeadline := ctx.Deadline(); !hasDeadline {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.timeout)
+		defer cancel()
+	}
 
-		if err != nil {
-			p.logger.Error("Proxy request failed",
-				zap.Error(err),
-				zap.String("target", target),
-				zap.Duration("latency", latency),
-			)
-			c.JSON(http.StatusBadGateway, gin.H{
-				"error": "Service unavailable",
-			})
+	// Detect which optional features the upstream supports
+	caps := p.capabilitiesFor(ctx, targetURL)
+	c.Set("upstream_capabilities", caps)
+
+	// Upstreams without HEAD support get a GET; net/http drops the body
+	method := c.Request.Method
+	if method == http.MethodHead && !caps.Head {
+		method = http.MethodGet
+	}
+
+	// Create new request
+	proxyReq, err := http.NewRequestWithContext(
+		withConnTrace(ctx, targetURL),
+		method,
+		target,
+		body,
+	)
+	if err != nil {
+		p.logger.Error("Failed to create proxy request",
+			zap.Error(err),
+			zap.String("target", target),
+		)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to create request",
+		})
+		return
+	}
+	if streamed != nil {
+		proxyReq.ContentLength = c.Request.ContentLength
+	}
+
+	// Copy headers
+	p.copyHeaders(c.Request.Header, proxyReq.Header)
+
+	// Add/override headers
+	proxyReq.Header.Set("X-Forwarded-For", c.ClientIP())
+	proto := "http"
+	if c.Request.TLS != nil {
+		proto = "https"
+	}
+	proxyReq.Header.Set("X-Forwarded-Proto", proto)
+	proxyReq.Header.Set("X-Real-IP", c.ClientIP())
+
+	// Only ask for protobuf from upstreams that can produce it; MessagePack
+	// is always transcoded from JSON at the gateway
+	accept := proxyReq.Header.Get("Accept")
+	if (!caps.Protobuf && strings.Contains(accept, "protobuf")) || strings.Contains(accept, "msgpack") {
+		proxyReq.Header.Set("Accept", "application/json")
+	}
+
+	// Range is synthesized from the full body for upstreams without support
+	if !caps.Range {
+		proxyReq.Header.Del("Range")
+	}
+
+	// Add user context if available
+	if userID, exists := c.Get("user_id"); exists {
+		proxyReq.Header.Set("X-User-ID", fmt.Sprintf("%v", userID))
+	}
+	if username, exists := c.Get("username"); exists {
+		proxyReq.Header.Set("X-Username", fmt.Sprintf("%v", username))
+	}
+	p.sign(proxyReq)
+
+	// Copy a sample of the traffic to the upstream's shadow, if any
+	if streamed == nil {
+		p.mirror(upstream, proxyReq, bodyBytes)
+	}
+
+	// Send request
+	start := time.Now()
+	call := timing.FromContext(ctx).Begin(upstream)
+	defer call.End()
+	resp, err := client.Do(proxyReq.WithContext(call.Trace(proxyReq.Context())))
+	latency := time.Since(start)
+	if streamed != nil {
+		usage.AddBytes(int(streamed.read))
+		if err != nil && streamed.err != nil && streamed.err != io.EOF {
+			// The client's body failed, not the upstream
+			rejectBody(c, streamed.err)
 			return
 		}
-		defer resp.Body.Close()
+	}
+	success := err == nil && resp.StatusCode < http.StatusInternalServerError
+	if isolated != nil {
+		isolated.breaker.record(success)
+		recorded = true
+	}
+	p.recordTarget(targetURL, success)
 
-		// Read response body
-		respBody, err := io.ReadAll(resp.Body)
-		if err != nil {
-			p.logger.Error("Failed to read response body",
-				zap.Error(err),
-				zap.String("target", target),
-			)
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"error": "Failed to read response",
-			})
+	if err != nil {
+		if usage.Exceeded() != "" {
 			return
 		}
+		p.logger.Error("Proxy request failed",
+			zap.Error(err),
+			zap.String("target", target),
+			zap.Duration("latency", latency),
+		)
+		c.JSON(http.StatusBadGateway, gin.H{
+			"error": "Service unavailable",
+		})
+		return
+	}
+	defer resp.Body.Close()
 
-		// Log response
-		p.logger.Debug("Proxy response",
+	// Partial content (media seeks), event streams and file downloads are
+	// streamed as they arrive: buffering would hold playback back until the
+	// whole range was read, events until the stream ended, and archives in
+	// memory whole
+	if resp.StatusCode == http.StatusPartialContent || isEventStream(resp) || isAttachment(resp) {
+		usage.AddBytes(len(bodyBytes))
+		p.stream(c, resp, usage)
+		return
+	}
+
+	// Read response body
+	respBody, err := io.ReadAll(resp.Body)
+	usage.AddBytes(len(bodyBytes) + len(respBody))
+	if usage.Exceeded() != "" {
+		return
+	}
+	if err != nil {
+		p.logger.Error("Failed to read response body",
+			zap.Error(err),
+			zap.String("target", target),
+		)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to read response",
+		})
+		return
+	}
+
+	// Log response
+	p.logger.Debug("Proxy response",
+		zap.String("target", target),
+		zap.Int("status", resp.StatusCode),
+		zap.Duration("latency", latency),
+		zap.Int("response_size", len(respBody)),
+	)
+
+	// Server errors are logged with the start of their payload; personal data
+	// and credentials in it are masked by the log scrubber
+	if resp.StatusCode >= http.StatusInternalServerError {
+		p.logger.Warn("Upstream error response",
 			zap.String("target", target),
 			zap.Int("status", resp.StatusCode),
-			zap.Duration("latency", latency),
-			zap.Int("response_size", len(respBody)),
+			zap.ByteString("body", respBody[:min(len(respBody), maxLoggedErrorBody)]),
 		)
+	}
+
+	// Copy response headers
+	for key, values := range resp.Header {
+		for _, value := range values {
+			c.Writer.Header().Add(key, value)
+		}
+	}
+
+	// Diff a sample of the responses against a candidate upstream, if any
+	p.compare(upstream, c.FullPath(), proxyReq, resp, respBody)
 
-		// Copy response headers
-		for key, values := range resp.Header {
-			for _, value := range values {
-				c.Writer.Header().Add(key, value)
+	// Emulate ETag/Range for upstreams that lack them
+	status, respBody := synthesizeResponse(c, caps, resp.StatusCode, respBody)
+
+	// Send response
+	c.Data(status, resp.Header.Get("Content-Type"), respBody)
+}
+
+// stream copies an upstream response to the client chunk by chunk, flushing
+// each one so the client can start using the bytes it has
+func (p *ProxyHandler) stream(c *gin.Context, resp *http.Response, usage *accounting.Usage) {
+	for key, values := range resp.Header {
+		for _, value := range values {
+			c.Writer.Header().Add(key, value)
+		}
+	}
+	c.Status(resp.StatusCode)
+	c.Writer.WriteHeaderNow()
+
+	buf := make([]byte, streamChunkSize)
+	for {
+		n, err := resp.Body.Read(buf)
+		if n > 0 {
+			usage.AddBytes(n)
+			if _, err := c.Writer.Write(buf[:n]); err != nil {
+				// The client went away, typically to seek elsewhere
+				return
+			}
+			c.Writer.Flush()
+		}
+		if err != nil {
+			if err != io.EOF {
+				p.logger.Warn("Streamed upstream response cut short",
+					zap.String("path", c.Request.URL.Path),
+					zap.Error(err),
+				)
 			}
+			return
 		}
+	}
+}
+
+// isEventStream reports whether resp is a server-sent event stream
+func isEventStream(resp *http.Response) bool {
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	return mediaType == "text/event-stream"
+}
+
+// isAttachment reports whether resp is a file download
+func isAttachment(resp *http.Response) bool {
+	disposition, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Disposition"))
+	return disposition == "attachment"
+}
 
-		// Send response
-		c.Data(resp.StatusCode, resp.Header.Get("Content-Type"), respBody)
+// streamedBody passes a client's request body to the upstream request,
+// remembering how much was read and why reading stopped
+type streamedBody struct {
+	body io.ReadCloser
+	read int64
+	err  error
+}
+
+func (b *streamedBody) Read(p []byte) (int, error) {
+	n, err := b.body.Read(p)
+	b.read += int64(n)
+	if err != nil {
+		b.err = err
+	}
+	return n, err
+}
+
+// rejectBody answers a request whose body could not be read. A body cut off
+// by a size limit is answered 413 and one rejected by its content with the
+// status its error carries (a StatusCode method), without reading further.
+func rejectBody(c *gin.Context, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		c.Header("Connection", "close")
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{
+			"error": "Request body too large",
+		})
+		return
+	}
+	var rejected interface{ StatusCode() int }
+	if errors.As(err, &rejected) {
+		c.Header("Connection", "close")
+		c.JSON(rejected.StatusCode(), gin.H{
+			"error": err.Error(),
+		})
+		return
 	}
+	c.JSON(http.StatusBadRequest, gin.H{
+		"error": "Failed to read request body",
+	})
 }
 
 // copyHeaders copies HTTP headers from source to destination
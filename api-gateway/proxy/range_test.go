@@ -0,0 +1,198 @@
+package proxy
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/YeonwooSung/instagram/api-gateway/cache"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+var rangeBody = []byte("0123456789abcdef")
+
+const rangeModified = "Thu, 01 Oct 2026 00:00:00 GMT"
+
+// rangeUpstream serves rangeBody at /media/clip. With supportsRange it
+// advertises Range support and answers ranges itself, with a strong ETag;
+// otherwise it always sends the whole body, with a Last-Modified date, and the
+// gateway synthesizes ranges.
+func rangeUpstream(t *testing.T, supportsRange bool) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/capabilities", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if supportsRange {
+			w.Write([]byte(`{"etag": true, "range": true}`))
+		} else {
+			w.Write([]byte(`{}`))
+		}
+	})
+	mux.HandleFunc("/media/clip", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "video/mp4")
+		if supportsRange {
+			w.Header().Set("ETag", `"v2"`)
+			http.ServeContent(w, r, "clip.mp4", time.Time{}, bytes.NewReader(rangeBody))
+			return
+		}
+		w.Header().Set("Last-Modified", rangeModified)
+		w.Write(rangeBody)
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestRangeRequests(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	weakETag := cache.WeakETag(rangeBody)
+
+	tests := []struct {
+		name          string
+		supportsRange bool
+		header        map[string]string
+		status        int
+		contentRange  string
+		body          string
+	}{
+		{
+			name:          "streamed single range",
+			supportsRange: true,
+			header:        map[string]string{"Range": "bytes=2-5"},
+			status:        http.StatusPartialContent,
+			contentRange:  "bytes 2-5/16",
+			body:          "2345",
+		},
+		{
+			name:          "streamed open-ended range",
+			supportsRange: true,
+			header:        map[string]string{"Range": "bytes=12-"},
+			status:        http.StatusPartialContent,
+			contentRange:  "bytes 12-15/16",
+			body:          "cdef",
+		},
+		{
+			name:          "streamed suffix range",
+			supportsRange: true,
+			header:        map[string]string{"Range": "bytes=-3"},
+			status:        http.StatusPartialContent,
+			contentRange:  "bytes 13-15/16",
+			body:          "def",
+		},
+		{
+			name:          "streamed unsatisfiable range",
+			supportsRange: true,
+			header:        map[string]string{"Range": "bytes=100-"},
+			status:        http.StatusRequestedRangeNotSatisfiable,
+			contentRange:  "bytes */16",
+		},
+		{
+			name:          "streamed If-Range current",
+			supportsRange: true,
+			header:        map[string]string{"Range": "bytes=0-3", "If-Range": `"v2"`},
+			status:        http.StatusPartialContent,
+			contentRange:  "bytes 0-3/16",
+			body:          "0123",
+		},
+		{
+			name:          "streamed If-Range stale",
+			supportsRange: true,
+			header:        map[string]string{"Range": "bytes=0-3", "If-Range": `"v1"`},
+			status:        http.StatusOK,
+			body:          string(rangeBody),
+		},
+		{
+			name:         "synthesized single range",
+			header:       map[string]string{"Range": "bytes=2-5"},
+			status:       http.StatusPartialContent,
+			contentRange: "bytes 2-5/16",
+			body:         "2345",
+		},
+		{
+			name:         "synthesized range past the end",
+			header:       map[string]string{"Range": "bytes=10-99"},
+			status:       http.StatusPartialContent,
+			contentRange: "bytes 10-15/16",
+			body:         "abcdef",
+		},
+		{
+			name:         "synthesized suffix range",
+			header:       map[string]string{"Range": "bytes=-3"},
+			status:       http.StatusPartialContent,
+			contentRange: "bytes 13-15/16",
+			body:         "def",
+		},
+		{
+			name:         "synthesized suffix longer than the body",
+			header:       map[string]string{"Range": "bytes=-100"},
+			status:       http.StatusPartialContent,
+			contentRange: "bytes 0-15/16",
+			body:         string(rangeBody),
+		},
+		{
+			name:         "synthesized unsatisfiable range",
+			header:       map[string]string{"Range": "bytes=16-"},
+			status:       http.StatusRequestedRangeNotSatisfiable,
+			contentRange: "bytes */16",
+		},
+		{
+			name:         "synthesized multiple ranges",
+			header:       map[string]string{"Range": "bytes=0-1,4-5"},
+			status:       http.StatusRequestedRangeNotSatisfiable,
+			contentRange: "bytes */16",
+		},
+		{
+			name:   "synthesized If-Range weak ETag",
+			header: map[string]string{"Range": "bytes=0-3", "If-Range": weakETag},
+			status: http.StatusOK,
+			body:   string(rangeBody),
+		},
+		{
+			name:         "synthesized If-Range current date",
+			header:       map[string]string{"Range": "bytes=0-3", "If-Range": rangeModified},
+			status:       http.StatusPartialContent,
+			contentRange: "bytes 0-3/16",
+			body:         "0123",
+		},
+		{
+			name:   "synthesized If-Range stale",
+			header: map[string]string{"Range": "bytes=0-3", "If-Range": `W/"stale"`},
+			status: http.StatusOK,
+			body:   string(rangeBody),
+		},
+		{
+			name:   "synthesized without Range",
+			status: http.StatusOK,
+			body:   string(rangeBody),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := rangeUpstream(t, tt.supportsRange)
+			p := NewProxyHandler(5*time.Second, zap.NewNop())
+			r := gin.New()
+			r.GET("/media/*path", p.ProxyRequest(upstream.URL))
+
+			req := httptest.NewRequest(http.MethodGet, "/media/clip", nil)
+			for name, value := range tt.header {
+				req.Header.Set(name, value)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d", w.Code, tt.status)
+			}
+			if got := w.Header().Get("Content-Range"); got != tt.contentRange {
+				t.Errorf("Content-Range = %q, want %q", got, tt.contentRange)
+			}
+			if tt.status != http.StatusRequestedRangeNotSatisfiable && w.Body.String() != tt.body {
+				t.Errorf("body = %q, want %q", w.Body.String(), tt.body)
+			}
+		})
+	}
+}
@@ -0,0 +1,58 @@
+package proxy
+
+import (
+	"github.com/YeonwooSung/instagram/api-gateway/metrics"
+	"github.com/gin-gonic/gin"
+)
+
+// RoutingRule sends requests carrying a header or cookie to an alternate
+// upstream URL, e.g. X-Env: staging or a beta cookie for dogfooding
+type RoutingRule struct {
+	// Header or Cookie names the request attribute to match (exactly one is set)
+	Header string
+	Cookie string
+
+	// Value must equal the attribute; empty matches any non-empty value
+	Value string
+
+	// Target is the alternate upstream base URL
+	Target string
+}
+
+// matches reports whether the request satisfies the rule's predicate
+func (r RoutingRule) matches(c *gin.Context) bool {
+	var actual string
+	if r.Header != "" {
+		actual = c.GetHeader(r.Header)
+	} else if cookie, err := c.Cookie(r.Cookie); err == nil {
+		actual = cookie
+	}
+
+	if actual == "" {
+		return false
+	}
+	return r.Value == "" || actual == r.Value
+}
+
+// SetRoutingRules replaces the routing rules. Keys are the configured base
+// URLs of the upstreams the rules apply to; the first matching rule wins.
+func (p *ProxyHandler) SetRoutingRules(rules map[string][]RoutingRule) {
+	p.mu.Lock()
+	p.rules = rules
+	p.mu.Unlock()
+}
+
+// matchRule returns the target of the first rule matching the request
+func (p *ProxyHandler) matchRule(c *gin.Context, upstream string) (string, bool) {
+	p.mu.RLock()
+	rules := p.rules[upstream]
+	p.mu.RUnlock()
+
+	for _, rule := range rules {
+		if rule.matches(c) {
+			metrics.Inc("gateway_routing_rule_matches_total", "upstream", upstream, "target", rule.Target)
+			return rule.Target, true
+		}
+	}
+	return "", false
+}
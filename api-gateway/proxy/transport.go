@@ -0,0 +1,146 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"strconv"
+	"time"
+
+	"github.com/YeonwooSung/instagram/api-gateway/metrics"
+	"golang.org/x/net/http2"
+)
+
+const (
+	// maxIdleConnsPerHost bounds the warm connection pool kept for each upstream
+	maxIdleConnsPerHost = 64
+
+	// tlsSessionCacheSize is the number of TLS sessions kept for resumption
+	tlsSessionCacheSize = 256
+
+	// http2PingInterval is how long an HTTP/2 connection may be silent before
+	// it is pinged, and http2PingTimeout how long the ping may go unanswered
+	// before the connection is dropped
+	http2PingInterval = 30 * time.Second
+	http2PingTimeout  = 15 * time.Second
+)
+
+// Protocols spoken to upstreams
+const (
+	// ProtocolHTTP1 is HTTP/1.1 only
+	ProtocolHTTP1 = "http1"
+
+	// ProtocolHTTP2 negotiates HTTP/2 over TLS, falling back to HTTP/1.1;
+	// plaintext upstreams are spoken to in HTTP/1.1. It is the default.
+	ProtocolHTTP2 = "h2"
+
+	// ProtocolH2C is HTTP/2 over plaintext with prior knowledge, for
+	// internal upstreams known to support it
+	ProtocolH2C = "h2c"
+)
+
+// newTransport builds the upstream transport for a protocol. HTTP/1.1 uses
+// keep-alive pooling and TLS session resumption so bursty traffic reuses
+// existing connections; HTTP/2 multiplexes requests over one connection per
+// endpoint, pinged while idle so a dead one is noticed before it is used.
+// maxConns caps connections per endpoint (0 for no cap) and does not apply
+// to h2c.
+func newTransport(protocol string, maxConns int) http.RoundTripper {
+	dialer := &net.Dialer{
+		Timeout:   5 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+
+	if protocol == ProtocolH2C {
+		return &http2.Transport{
+			AllowHTTP: true,
+			DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+				return dialer.DialContext(ctx, network, addr)
+			},
+			ReadIdleTimeout: http2PingInterval,
+			PingTimeout:     http2PingTimeout,
+		}
+	}
+
+	transport := &http.Transport{
+		Proxy:               http.ProxyFromEnvironment,
+		DialContext:         dialer.DialContext,
+		MaxIdleConns:        maxIdleConnsPerHost * 8,
+		MaxIdleConnsPerHost: maxIdleConnsPerHost,
+		MaxConnsPerHost:     maxConns,
+		IdleConnTimeout:     90 * time.Second,
+		TLSHandshakeTimeout: 5 * time.Second,
+		TLSClientConfig: &tls.Config{
+			ClientSessionCache: tls.NewLRUClientSessionCache(tlsSessionCacheSize),
+		},
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+	if protocol == ProtocolHTTP2 {
+		// Only fails if the transport already speaks HTTP/2
+		if h2, err := http2.ConfigureTransports(transport); err == nil {
+			h2.ReadIdleTimeout = http2PingInterval
+			h2.PingTimeout = http2PingTimeout
+		}
+	}
+	return transport
+}
+
+// SetProtocols sets the protocol spoken to upstreams, by upstream URL; the
+// others use ProtocolHTTP2. Call it before Isolate and before serving
+// traffic.
+func (p *ProxyHandler) SetProtocols(protocols map[string]string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.protocols = make(map[string]string, len(protocols))
+	p.clients = make(map[string]*http.Client, len(protocols))
+	for upstream, protocol := range protocols {
+		p.protocols[upstream] = protocol
+		p.clients[upstream] = &http.Client{
+			Transport:     newTransport(protocol, 0),
+			CheckRedirect: p.client.CheckRedirect,
+		}
+	}
+}
+
+// protocol returns the protocol spoken to an upstream
+func (p *ProxyHandler) protocol(upstream string) string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if protocol, ok := p.protocols[upstream]; ok {
+		return protocol
+	}
+	return ProtocolHTTP2
+}
+
+// upstreamClient returns the shared client for an upstream's protocol
+func (p *ProxyHandler) upstreamClient(upstream string) *http.Client {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if client, ok := p.clients[upstream]; ok {
+		return client
+	}
+	return p.client
+}
+
+// withConnTrace records whether the upstream connection was reused from the pool
+func withConnTrace(ctx context.Context, upstream string) context.Context {
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			metrics.Inc("gateway_upstream_connections_total",
+				"upstream", upstream,
+				"reused", strconv.FormatBool(info.Reused),
+			)
+		},
+		TLSHandshakeDone: func(state tls.ConnectionState, err error) {
+			if err == nil {
+				metrics.Inc("gateway_upstream_tls_handshakes_total",
+					"upstream", upstream,
+					"resumed", strconv.FormatBool(state.DidResume),
+				)
+			}
+		},
+	})
+}
@@ -0,0 +1,167 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/YeonwooSung/instagram/api-gateway/metrics"
+	"github.com/YeonwooSung/instagram/api-gateway/tenants"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// openWebSockets counts tunnels currently open across upstreams
+var openWebSockets atomic.Int64
+
+// ProxyWebSocket tunnels a WebSocket connection to the target service. The
+// handshake is forwarded like any request, with the user's identity added;
+// once the target switches protocols, frames are copied both ways untouched
+// until either side closes. Middleware that buffers responses must not run
+// on WebSocket routes.
+func (p *ProxyHandler) ProxyWebSocket(targetURL string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !strings.EqualFold(c.GetHeader("Upgrade"), "websocket") {
+			c.Header("Upgrade", "websocket")
+			c.JSON(http.StatusUpgradeRequired, gin.H{
+				"error": "WebSocket upgrade required",
+			})
+			return
+		}
+
+		if p.serveMaintenance(c, targetURL) {
+			return
+		}
+
+		isolated := p.isolationFor(targetURL, tenants.FromContext(c.Request.Context()).Upstream(targetURL))
+		client := p.client
+		if isolated != nil {
+			if !isolated.breaker.allow() {
+				c.JSON(http.StatusServiceUnavailable, gin.H{
+					"error": "Service temporarily unavailable",
+				})
+				return
+			}
+			client = isolated.client
+		}
+		// The upgrade needs HTTP/1.1, which an h2c client can't speak; the
+		// other transports fall back to it for upgrades on their own
+		if p.protocol(targetURL) == ProtocolH2C {
+			client = p.client
+		}
+
+		upstream := targetURL
+		c.Set("upstream", upstream)
+		target := p.balancer.pick(p.route(c, targetURL)) + c.Request.URL.Path
+		if c.Request.URL.RawQuery != "" {
+			target += "?" + c.Request.URL.RawQuery
+		}
+
+		// The tunnel outlives the proxy timeout, which only bounds the handshake
+		ctx, cancel := context.WithCancel(context.WithoutCancel(c.Request.Context()))
+		defer cancel()
+		handshake := time.AfterFunc(p.timeout, cancel)
+
+		proxyReq, err := http.NewRequestWithContext(withConnTrace(ctx, upstream), http.MethodGet, target, nil)
+		if err != nil {
+			handshake.Stop()
+			if isolated != nil {
+				isolated.breaker.release()
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "Failed to create request",
+			})
+			return
+		}
+		p.copyHeaders(c.Request.Header, proxyReq.Header)
+		proxyReq.Header.Set("Connection", "Upgrade")
+		proxyReq.Header.Set("Upgrade", "websocket")
+		proxyReq.Header.Set("X-Forwarded-For", c.ClientIP())
+		proxyReq.Header.Set("X-Real-IP", c.ClientIP())
+		if userID, exists := c.Get("user_id"); exists {
+			proxyReq.Header.Set("X-User-ID", fmt.Sprintf("%v", userID))
+		}
+		if username, exists := c.Get("username"); exists {
+			proxyReq.Header.Set("X-Username", fmt.Sprintf("%v", username))
+		}
+		p.sign(proxyReq)
+
+		resp, err := client.Do(proxyReq)
+		handshake.Stop()
+		if isolated != nil {
+			isolated.breaker.record(err == nil && resp.StatusCode < http.StatusInternalServerError)
+		}
+		if err != nil {
+			p.logger.Error("WebSocket handshake failed", zap.Error(err), zap.String("target", target))
+			metrics.Inc("gateway_websocket_connections_total", "upstream", upstream, "result", "failed")
+			c.JSON(http.StatusBadGateway, gin.H{
+				"error": "Service unavailable",
+			})
+			return
+		}
+		defer resp.Body.Close()
+
+		// A refused upgrade is an ordinary response
+		if resp.StatusCode != http.StatusSwitchingProtocols {
+			metrics.Inc("gateway_websocket_connections_total", "upstream", upstream, "result", "refused")
+			body, _ := io.ReadAll(resp.Body)
+			for key, values := range resp.Header {
+				for _, value := range values {
+					c.Writer.Header().Add(key, value)
+				}
+			}
+			c.Data(resp.StatusCode, resp.Header.Get("Content-Type"), body)
+			return
+		}
+		upstreamConn, ok := resp.Body.(io.ReadWriteCloser)
+		if !ok {
+			c.JSON(http.StatusBadGateway, gin.H{
+				"error": "Service unavailable",
+			})
+			return
+		}
+
+		clientConn, clientBuf, err := c.Writer.Hijack()
+		if err != nil {
+			p.logger.Error("Failed to take over WebSocket connection", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "WebSocket not supported",
+			})
+			return
+		}
+		defer clientConn.Close()
+		// The server's read and write timeouts would cut the tunnel
+		clientConn.SetDeadline(time.Time{})
+
+		// Relay the target's handshake response as is
+		resp.Body = nil
+		if err := resp.Write(clientConn); err != nil {
+			return
+		}
+
+		metrics.Inc("gateway_websocket_connections_total", "upstream", upstream, "result", "opened")
+		metrics.Set("gateway_websocket_open", openWebSockets.Add(1))
+		defer func() {
+			metrics.Set("gateway_websocket_open", openWebSockets.Add(-1))
+		}()
+
+		// Either direction ending closes both connections, ending the other
+		done := make(chan struct{}, 2)
+		go func() {
+			io.Copy(upstreamConn, clientBuf)
+			done <- struct{}{}
+		}()
+		go func() {
+			io.Copy(clientConn, upstreamConn)
+			done <- struct{}{}
+		}()
+		<-done
+		clientConn.Close()
+		upstreamConn.Close()
+		<-done
+	}
+}
@@ -0,0 +1,316 @@
+// Package push throttles push notifications per device before they are
+// handed to the push dispatcher: frequency caps, the user's quiet hours and
+// collapsing of bursts of similar notifications into one summary.
+package push
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/YeonwooSung/instagram/api-gateway/metrics"
+	"github.com/YeonwooSung/instagram/api-gateway/outbox"
+	"github.com/YeonwooSung/instagram/api-gateway/settings"
+	"github.com/YeonwooSung/instagram/api-gateway/state"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+const (
+	// dueKey is the sorted set of collapsed bursts by the time their summary is due
+	dueKey = "gateway:push:due"
+
+	// flushInterval is how often due summaries are sent
+	flushInterval = time.Second
+
+	// flushBatch caps the summaries claimed per flush
+	flushBatch = 100
+)
+
+// heldSchema versions the latest Notification of a burst as held in Redis
+var heldSchema = state.NewSchema("push_held", 1)
+
+// Decisions on a notification
+const (
+	Sent       = "sent"
+	Collapsed  = "collapsed"
+	Throttled  = "throttled"
+	QuietHours = "quiet_hours"
+)
+
+// PriorityHigh notifications (security alerts, direct messages) bypass
+// throttling entirely
+const PriorityHigh = "high"
+
+// Notification is one push notification for one device
+type Notification struct {
+	UserID   string `json:"user_id"`
+	DeviceID string `json:"device_id"`
+	Type     string `json:"type"`
+	Priority string `json:"priority,omitempty"`
+
+	// CollapseKey groups notifications that can be summarized, e.g.
+	// "post:123:likes"; Summary is the text of the summary, with {count}
+	// standing for the number of notifications it replaces
+	CollapseKey string `json:"collapse_key,omitempty"`
+	Summary     string `json:"summary,omitempty"`
+
+	Title string          `json:"title"`
+	Body  string          `json:"body"`
+	Data  json.RawMessage `json:"data,omitempty"`
+
+	// Collapsed is the number of notifications a summary replaces
+	Collapsed int `json:"collapsed,omitempty"`
+}
+
+// Options configures throttling
+type Options struct {
+	// MaxPerHour caps the notifications one device gets per clock hour (0: no cap)
+	MaxPerHour int
+
+	// CollapseWindow is how long after a notification similar ones are held
+	// and summarized (0: no collapsing)
+	CollapseWindow time.Duration
+}
+
+// Throttle decides which notifications reach the push dispatcher. Accepted
+// notifications are published to the outbox as push events. State is kept in
+// Redis, so every gateway instance applies the same caps; while Redis is
+// unavailable notifications are sent unthrottled.
+type Throttle struct {
+	redis    *redis.Client
+	events   *outbox.Outbox
+	settings *settings.Store
+	opts     Options
+	logger   *zap.Logger
+}
+
+// New creates a throttle reading quiet hours from store
+func New(redisClient *redis.Client, events *outbox.Outbox, store *settings.Store, opts Options, logger *zap.Logger) *Throttle {
+	return &Throttle{
+		redis:    redisClient,
+		events:   events,
+		settings: store,
+		opts:     opts,
+		logger:   logger,
+	}
+}
+
+// Submit applies quiet hours, collapsing and the frequency cap to n, in that
+// order, and publishes it if it passes. It returns the decision.
+func (t *Throttle) Submit(ctx context.Context, n Notification) (string, error) {
+	decision, err := t.decide(ctx, n)
+	if err != nil {
+		// Better an extra notification than a lost one
+		t.logger.Warn("Push throttling unavailable, sending unthrottled", zap.Error(err))
+		decision = Sent
+	}
+	metrics.Inc("gateway_push_notifications_total", "type", n.Type, "result", decision)
+	if decision != Sent {
+		return decision, nil
+	}
+	return Sent, t.events.Publish(ctx, outbox.KindPush, n.DeviceID, n)
+}
+
+func (t *Throttle) decide(ctx context.Context, n Notification) (string, error) {
+	if n.Priority == PriorityHigh {
+		return Sent, nil
+	}
+	if t.quiet(ctx, n.UserID, time.Now()) {
+		return QuietHours, nil
+	}
+
+	if n.CollapseKey != "" && t.opts.CollapseWindow > 0 {
+		// The first notification of a burst goes out and opens the window;
+		// the rest are held for one summary when it closes
+		key := burstKey(n.DeviceID, n.CollapseKey)
+		opened, err := t.redis.SetNX(ctx, key+":open", 1, t.opts.CollapseWindow).Result()
+		if err != nil {
+			return "", err
+		}
+		if !opened {
+			held, err := heldSchema.Marshal(n)
+			if err != nil {
+				return "", err
+			}
+			pipe := t.redis.TxPipeline()
+			pipe.HIncrBy(ctx, key, "count", 1)
+			pipe.HSet(ctx, key, "latest", held)
+			pipe.Expire(ctx, key, 2*t.opts.CollapseWindow)
+			pipe.ZAddNX(ctx, dueKey, redis.Z{
+				Score:  float64(time.Now().Add(t.opts.CollapseWindow).UnixMilli()),
+				Member: key,
+			})
+			if _, err := pipe.Exec(ctx); err != nil {
+				return "", err
+			}
+			return Collapsed, nil
+		}
+	}
+
+	return t.capped(ctx, n.DeviceID)
+}
+
+// capped counts a notification against the device's hourly cap
+func (t *Throttle) capped(ctx context.Context, deviceID string) (string, error) {
+	if t.opts.MaxPerHour <= 0 {
+		return Sent, nil
+	}
+	hour := time.Now().UTC().Truncate(time.Hour)
+	key := fmt.Sprintf("gateway:push:cap:%s:%d", deviceID, hour.Unix())
+	pipe := t.redis.TxPipeline()
+	count := pipe.Incr(ctx, key)
+	pipe.Expire(ctx, key, time.Hour)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return "", err
+	}
+	if count.Val() > int64(t.opts.MaxPerHour) {
+		return Throttled, nil
+	}
+	return Sent, nil
+}
+
+// Run sends the summaries of collapsed bursts as their windows close, until
+// ctx is cancelled. Every instance runs it; each summary is claimed by one.
+func (t *Throttle) Run(ctx context.Context) {
+	if t.opts.CollapseWindow <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := t.flush(ctx); err != nil && !errors.Is(err, context.Canceled) {
+			t.logger.Warn("Failed to flush collapsed push notifications", zap.Error(err))
+		}
+	}
+}
+
+func (t *Throttle) flush(ctx context.Context) error {
+	due, err := t.redis.ZRangeByScore(ctx, dueKey, &redis.ZRangeBy{
+		Min:   "-inf",
+		Max:   strconv.FormatInt(time.Now().UnixMilli(), 10),
+		Count: flushBatch,
+	}).Result()
+	if err != nil {
+		return err
+	}
+
+	for _, key := range due {
+		claimed, err := t.redis.ZRem(ctx, dueKey, key).Result()
+		if err != nil {
+			return err
+		}
+		if claimed == 0 {
+			continue // another instance got it
+		}
+
+		pipe := t.redis.TxPipeline()
+		held := pipe.HGetAll(ctx, key)
+		pipe.Del(ctx, key)
+		if _, err := pipe.Exec(ctx); err != nil {
+			return err
+		}
+		count, _ := strconv.Atoi(held.Val()["count"])
+		var n Notification
+		if count == 0 || heldSchema.Unmarshal([]byte(held.Val()["latest"]), &n) != nil {
+			continue
+		}
+		t.summarize(ctx, n, count)
+	}
+	return nil
+}
+
+// summarize sends one notification in place of the count held ones, latest
+// being the last of them
+func (t *Throttle) summarize(ctx context.Context, latest Notification, count int) {
+	summary := latest
+	summary.Collapsed = count
+	if count > 1 && latest.Summary != "" {
+		summary.Body = strings.ReplaceAll(latest.Summary, "{count}", strconv.Itoa(count))
+	}
+
+	decision := Sent
+	if t.quiet(ctx, latest.UserID, time.Now()) {
+		decision = QuietHours
+	} else if d, err := t.capped(ctx, latest.DeviceID); err == nil {
+		decision = d
+	}
+	metrics.Inc("gateway_push_summaries_total", "type", latest.Type, "result", decision)
+	if decision != Sent {
+		return
+	}
+	if err := t.events.Publish(ctx, outbox.KindPush, latest.DeviceID, summary); err != nil {
+		t.logger.Warn("Failed to publish push summary", zap.String("device_id", latest.DeviceID), zap.Error(err))
+	}
+}
+
+// quietHours is the part of the notification settings the throttle reads:
+// local "HH:MM" times in an IANA time zone. A range may cross midnight.
+type quietHours struct {
+	QuietHours *struct {
+		Start    string `json:"start"`
+		End      string `json:"end"`
+		Timezone string `json:"timezone"`
+	} `json:"quiet_hours"`
+}
+
+// quiet reports whether now is within the user's quiet hours. Unknown
+// settings mean no quiet hours.
+func (t *Throttle) quiet(ctx context.Context, userID string, now time.Time) bool {
+	if t.settings == nil || userID == "" {
+		return false
+	}
+	header := http.Header{}
+	header.Set("X-User-ID", userID)
+	header.Set("X-Calling-Service", "gateway")
+	entry, _, err := t.settings.Get(ctx, userID, settings.SectionNotifications, header)
+	if err != nil || entry.Status != http.StatusOK {
+		return false
+	}
+
+	var prefs quietHours
+	if json.Unmarshal(entry.Body, &prefs) != nil || prefs.QuietHours == nil {
+		return false
+	}
+	start, okStart := minuteOfDay(prefs.QuietHours.Start)
+	end, okEnd := minuteOfDay(prefs.QuietHours.End)
+	if !okStart || !okEnd || start == end {
+		return false
+	}
+	if loc, err := time.LoadLocation(prefs.QuietHours.Timezone); err == nil {
+		now = now.In(loc)
+	} else {
+		now = now.UTC()
+	}
+
+	minute := now.Hour()*60 + now.Minute()
+	if start < end {
+		return minute >= start && minute < end
+	}
+	return minute >= start || minute < end
+}
+
+// minuteOfDay parses "HH:MM"
+func minuteOfDay(value string) (int, bool) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, false
+	}
+	return t.Hour()*60 + t.Minute(), true
+}
+
+func burstKey(deviceID, collapseKey string) string {
+	return "gateway:push:burst:" + deviceID + ":" + collapseKey
+}
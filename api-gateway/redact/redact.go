@@ -0,0 +1,176 @@
+// Package redact scrubs personal data and credentials from log output. It
+// wraps the zap core so every entry, whichever package logs it, has emails,
+// phone numbers, tokens and passwords masked in its message and fields before
+// it is written, including upstream payloads and access log query strings.
+package redact
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/YeonwooSung/instagram/api-gateway/config"
+	"github.com/YeonwooSung/instagram/api-gateway/metrics"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// Masked replaces values whose key names a secret, and matches of rules
+// without a replacement of their own
+const Masked = "[REDACTED]"
+
+// rule masks every match of pattern with replacement, which may refer to
+// submatches like regexp.ReplaceAllString
+type rule struct {
+	name        string
+	pattern     *regexp.Regexp
+	replacement string
+}
+
+// builtinRules are applied after secret key-value pairs, so a password that
+// looks like an email is masked whole, and before the configured rules
+var builtinRules = []rule{
+	{name: "bearer", pattern: regexp.MustCompile(`(?i)\bbearer\s+[A-Za-z0-9._~+/=-]+`), replacement: "Bearer " + Masked},
+	{name: "jwt", pattern: regexp.MustCompile(`\beyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`), replacement: "[TOKEN]"},
+	{name: "email", pattern: regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`), replacement: "[EMAIL]"},
+	{name: "phone", pattern: regexp.MustCompile(`\+\d{1,3}[\s.-]?\(?\d{1,4}\)?(?:[\s.-]?\d{2,4}){2,4}\b|\(?\b\d{3}\)?[\s.-]\d{3}[\s.-]\d{4}\b`), replacement: "[PHONE]"},
+}
+
+// Scrubber masks sensitive data in log entries
+type Scrubber struct {
+	keys  []string
+	rules []rule
+}
+
+// New builds a scrubber from the configured secret keys and extra patterns
+func New(cfg *config.Config) (*Scrubber, error) {
+	s := &Scrubber{}
+	quoted := make([]string, 0, len(cfg.LogRedactKeys))
+	for _, key := range cfg.LogRedactKeys {
+		s.keys = append(s.keys, strings.ToLower(key))
+		quoted = append(quoted, regexp.QuoteMeta(key))
+	}
+
+	// key=value, key: value and "key":"value" pairs in free text, JSON and
+	// query strings, for keys ending in one of the secret keys
+	if len(quoted) > 0 {
+		s.rules = append(s.rules, rule{
+			name:        "secret_value",
+			pattern:     regexp.MustCompile(`(?i)([\w-]*(?:` + strings.Join(quoted, "|") + `)"?\s*[:=]\s*"?)(?:bearer\s+)?[^"&\s,;}]+`),
+			replacement: "${1}" + Masked,
+		})
+	}
+	s.rules = append(s.rules, builtinRules...)
+
+	for _, configured := range cfg.LogRedactionRules {
+		pattern, err := regexp.Compile(configured.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("redaction rule %q: %w", configured.Name, err)
+		}
+		replacement := configured.Replacement
+		if replacement == "" {
+			replacement = Masked
+		}
+		s.rules = append(s.rules, rule{name: configured.Name, pattern: pattern, replacement: replacement})
+	}
+	return s, nil
+}
+
+// String returns s with every rule applied
+func (s *Scrubber) String(value string) string {
+	for _, r := range s.rules {
+		if r.pattern.MatchString(value) {
+			value = r.pattern.ReplaceAllString(value, r.replacement)
+			metrics.Inc("gateway_log_redactions_total", "rule", r.name)
+		}
+	}
+	return value
+}
+
+// secretKey reports whether a field key names a secret: it is one of the
+// secret keys, or ends in one after an underscore (access_token)
+func (s *Scrubber) secretKey(key string) bool {
+	key = strings.ToLower(key)
+	for _, secret := range s.keys {
+		if key == secret || strings.HasSuffix(key, "_"+secret) {
+			return true
+		}
+	}
+	return false
+}
+
+// Fields returns fields with sensitive values masked. Structured values are
+// scrubbed through their JSON encoding.
+func (s *Scrubber) Fields(fields []zapcore.Field) []zapcore.Field {
+	scrubbed := make([]zapcore.Field, len(fields))
+	for i, field := range fields {
+		scrubbed[i] = s.field(field)
+	}
+	return scrubbed
+}
+
+func (s *Scrubber) field(field zapcore.Field) zapcore.Field {
+	switch field.Type {
+	case zapcore.StringType, zapcore.ByteStringType, zapcore.ErrorType, zapcore.StringerType,
+		zapcore.ReflectType, zapcore.ArrayMarshalerType, zapcore.ObjectMarshalerType:
+	default:
+		// Numbers, times, durations, binary and namespaces carry nothing to mask
+		return field
+	}
+	if s.secretKey(field.Key) {
+		return zap.String(field.Key, Masked)
+	}
+
+	switch field.Type {
+	case zapcore.StringType:
+		return zap.String(field.Key, s.String(field.String))
+	case zapcore.ByteStringType:
+		return zap.String(field.Key, s.String(string(field.Interface.([]byte))))
+	case zapcore.ErrorType:
+		return zap.String(field.Key, s.String(field.Interface.(error).Error()))
+	case zapcore.StringerType:
+		return zap.String(field.Key, s.String(fmt.Sprint(field.Interface)))
+	}
+
+	// Encode structured values as the production JSON encoder would, then
+	// scrub that
+	buf, err := zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig()).EncodeEntry(zapcore.Entry{}, []zapcore.Field{field})
+	if err != nil {
+		return zap.String(field.Key, Masked)
+	}
+	defer buf.Free()
+	var encoded map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(s.String(buf.String())), &encoded); err != nil {
+		return zap.String(field.Key, Masked)
+	}
+	return zap.Reflect(field.Key, encoded[field.Key])
+}
+
+// Wrap returns core with every entry scrubbed; use it with zap.WrapCore
+func (s *Scrubber) Wrap(core zapcore.Core) zapcore.Core {
+	return &scrubbingCore{Core: core, scrubber: s}
+}
+
+type scrubbingCore struct {
+	zapcore.Core
+	scrubber *Scrubber
+}
+
+func (c *scrubbingCore) With(fields []zapcore.Field) zapcore.Core {
+	return &scrubbingCore{Core: c.Core.With(c.scrubber.Fields(fields)), scrubber: c.scrubber}
+}
+
+func (c *scrubbingCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	// The wrapped core decides on level and sampling; the entry is then
+	// written through this one
+	if c.Core.Check(entry, nil) == nil {
+		return checked
+	}
+	return checked.AddCore(entry, c)
+}
+
+func (c *scrubbingCore) Write(entry zapcore.Entry, fields []zapcore.Field) error {
+	entry.Message = c.scrubber.String(entry.Message)
+	return c.Core.Write(entry, c.scrubber.Fields(fields))
+}
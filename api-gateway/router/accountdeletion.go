@@ -0,0 +1,228 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/YeonwooSung/instagram/api-gateway/config"
+	"github.com/YeonwooSung/instagram/api-gateway/journal"
+	"github.com/YeonwooSung/instagram/api-gateway/metrics"
+	"github.com/YeonwooSung/instagram/api-gateway/proxy"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+const (
+	// accountDeletionSaga deletes an account's data from every service
+	accountDeletionSaga = "account_deletion"
+
+	// deletionKeyPrefix prefixes the deletion status hash of a user
+	deletionKeyPrefix = "gateway:account_deletion:"
+)
+
+// deletionServices are the services holding account data, in the order they
+// are cleared. The auth service goes last, so the user's identity outlives
+// their data until everything else is gone.
+var deletionServices = []string{"post", "media", "graph", "newsfeed", "auth"}
+
+// accountDeletion deletes accounts across services as a journaled saga. The
+// account is deactivated first, so nothing new is created while the services
+// clear their data; if a service fails, the account is reactivated and the
+// user may try again, the data already deleted staying deleted. Progress per
+// service is kept in Redis for the status endpoint.
+type accountDeletion struct {
+	cfg     *config.Config
+	proxy   *proxy.ProxyHandler
+	redis   *redis.Client
+	journal *journal.Journal
+	logger  *zap.Logger
+}
+
+// saga deactivates the account, then deletes its data service by service.
+// Deletions are idempotent and resumed after a crash; only deactivation can
+// be undone.
+func (h *accountDeletion) saga() journal.Saga {
+	steps := []journal.Step{
+		{Name: "deactivate", Do: h.deactivate, Undo: h.reactivate, Resumable: true},
+	}
+	for _, service := range deletionServices {
+		steps = append(steps, journal.Step{
+			Name:      "delete_" + service,
+			Do:        h.deleteFrom(service),
+			Resumable: true,
+		})
+	}
+	return journal.Saga{Name: accountDeletionSaga, Steps: steps}
+}
+
+// start begins deleting the user's account and answers 202 with its status.
+// A deletion already running is reported, not started again.
+func (h *accountDeletion) start(c *gin.Context) {
+	userID, ok := bffUserID(c)
+	if !ok {
+		return
+	}
+
+	key := deletionKeyPrefix + userID
+	started, err := h.claim(c.Request.Context(), key)
+	if err != nil {
+		h.logger.Warn("Failed to record account deletion", zap.Error(err))
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Account deletion unavailable"})
+		return
+	}
+
+	if started {
+		// The saga outlives the request; a crash midway is left to recovery
+		go func() {
+			_, err := h.journal.Execute(context.Background(), accountDeletionSaga, map[string]string{"user_id": userID}, nil)
+			if errors.Is(err, journal.ErrUnavailable) {
+				h.finish(context.Background(), userID, "failed")
+			} else if err != nil {
+				h.logger.Warn("Account deletion failed", zap.String("user_id", userID), zap.Error(err))
+			}
+		}()
+	}
+
+	c.Header("Location", "/api/v1/account/deletion")
+	h.respond(c, http.StatusAccepted, key)
+}
+
+// status answers with the progress of the user's latest account deletion
+func (h *accountDeletion) status(c *gin.Context) {
+	userID, ok := bffUserID(c)
+	if !ok {
+		return
+	}
+	h.respond(c, http.StatusOK, deletionKeyPrefix+userID)
+}
+
+// claim records a new deletion unless one is running, in which case it
+// reports false
+func (h *accountDeletion) claim(ctx context.Context, key string) (bool, error) {
+	started := false
+	err := h.redis.Watch(ctx, func(tx *redis.Tx) error {
+		status, err := tx.HGet(ctx, key, "status").Result()
+		if err != nil && !errors.Is(err, redis.Nil) {
+			return err
+		}
+		if status == "running" {
+			return nil
+		}
+
+		fields := map[string]interface{}{
+			"status":      "running",
+			"started_at":  time.Now().UTC().Format(time.RFC3339),
+			"finished_at": "",
+		}
+		for _, service := range deletionServices {
+			fields["service:"+service] = "pending"
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.HSet(ctx, key, fields)
+			pipe.Expire(ctx, key, h.cfg.AccountDeletionStatusTTL)
+			return nil
+		})
+		started = err == nil
+		return err
+	}, key)
+	if errors.Is(err, redis.TxFailedErr) {
+		return false, nil
+	}
+	return started, err
+}
+
+// respond writes the deletion status kept at key
+func (h *accountDeletion) respond(c *gin.Context, code int, key string) {
+	fields, err := h.redis.HGetAll(c.Request.Context(), key).Result()
+	if err != nil {
+		h.logger.Warn("Failed to read account deletion", zap.Error(err))
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Account deletion unavailable"})
+		return
+	}
+	if len(fields) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No account deletion requested"})
+		return
+	}
+
+	services := gin.H{}
+	for name, value := range fields {
+		if service, ok := strings.CutPrefix(name, "service:"); ok {
+			services[service] = value
+		}
+	}
+	status := gin.H{
+		"status":     fields["status"],
+		"started_at": fields["started_at"],
+		"services":   services,
+	}
+	if fields["finished_at"] != "" {
+		status["finished_at"] = fields["finished_at"]
+	}
+	c.Header("Cache-Control", "no-store")
+	c.JSON(code, status)
+}
+
+// deactivate signs the user out everywhere and blocks new activity
+func (h *accountDeletion) deactivate(ctx context.Context, run *journal.Run) error {
+	return h.send(ctx, run, http.MethodPost, h.cfg.AuthServiceURL, "/api/v1/auth/account/deactivate")
+}
+
+// reactivate restores a deactivated account after a failed deletion
+func (h *accountDeletion) reactivate(ctx context.Context, run *journal.Run) error {
+	if err := h.send(ctx, run, http.MethodPost, h.cfg.AuthServiceURL, "/api/v1/auth/account/reactivate"); err != nil {
+		return err
+	}
+	h.finish(ctx, run.State["user_id"], "failed")
+	return nil
+}
+
+// deleteFrom deletes the user's data from a service and records it as done.
+// The last service's step also marks the whole deletion completed.
+func (h *accountDeletion) deleteFrom(service string) func(context.Context, *journal.Run) error {
+	path := "/api/v1/account"
+	if service == "auth" {
+		path = "/api/v1/auth/account"
+	}
+	return func(ctx context.Context, run *journal.Run) error {
+		upstream := h.cfg.ServiceURLs()[service]
+		if err := h.send(ctx, run, http.MethodDelete, upstream, path); err != nil {
+			return err
+		}
+
+		key := deletionKeyPrefix + run.State["user_id"]
+		if err := h.redis.HSet(ctx, key, "service:"+service, "deleted").Err(); err != nil {
+			h.logger.Warn("Failed to record account deletion progress", zap.Error(err))
+		}
+		if service == deletionServices[len(deletionServices)-1] {
+			h.finish(ctx, run.State["user_id"], "completed")
+		}
+		return nil
+	}
+}
+
+// send calls a service on the user's behalf; data already gone is success
+func (h *accountDeletion) send(ctx context.Context, run *journal.Run, method, upstream, path string) error {
+	status, _, err := h.proxy.Send(ctx, method, upstream, path, serviceHeader(run), nil)
+	if err != nil {
+		return err
+	}
+	if status >= http.StatusMultipleChoices && status != http.StatusNotFound {
+		return fmt.Errorf("%s %s: upstream answered %d", method, path, status)
+	}
+	return nil
+}
+
+// finish records the outcome of the user's deletion
+func (h *accountDeletion) finish(ctx context.Context, userID, result string) {
+	metrics.Inc("gateway_account_deletions_total", "result", result)
+	key := deletionKeyPrefix + userID
+	err := h.redis.HSet(ctx, key, "status", result, "finished_at", time.Now().UTC().Format(time.RFC3339)).Err()
+	if err != nil {
+		h.logger.Warn("Failed to record account deletion outcome", zap.String("user_id", userID), zap.Error(err))
+	}
+}
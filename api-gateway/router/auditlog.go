@@ -0,0 +1,64 @@
+package router
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/YeonwooSung/instagram/api-gateway/auditlog"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// maxAuditLogEntries caps the audit log entries returned at once
+const maxAuditLogEntries = 500
+
+// auditLogAdmin lets operators read and verify the audit log
+type auditLogAdmin struct {
+	log    *auditlog.Log
+	logger *zap.Logger
+}
+
+func (a *auditLogAdmin) registerAdmin(admin *gin.RouterGroup) {
+	admin.GET("/audit-log", a.list)
+	admin.GET("/audit-log/verify", a.verify)
+}
+
+// list pages through the log newest first; ?before=<seq> continues after the
+// last page
+func (a *auditLogAdmin) list(c *gin.Context) {
+	limit, err := strconv.ParseInt(c.DefaultQuery("limit", "100"), 10, 64)
+	if err != nil || limit <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be a positive integer"})
+		return
+	}
+	before, err := strconv.ParseInt(c.DefaultQuery("before", "0"), 10, 64)
+	if err != nil || before < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "before must be a sequence number"})
+		return
+	}
+
+	entries, err := a.log.Entries(c.Request.Context(), before, min(limit, maxAuditLogEntries))
+	if err != nil {
+		a.logger.Warn("Failed to read audit log", zap.Error(err))
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Audit log unavailable"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"entries": entries})
+}
+
+// verify checks the whole hash chain
+func (a *auditLogAdmin) verify(c *gin.Context) {
+	result, err := a.log.Verify(c.Request.Context())
+	if err != nil {
+		a.logger.Warn("Failed to verify audit log", zap.Error(err))
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Audit log unavailable"})
+		return
+	}
+	if !result.Valid {
+		a.logger.Error("Audit log chain is broken",
+			zap.Int64("broken_at", result.BrokenAt),
+			zap.String("reason", result.Reason),
+		)
+	}
+	c.JSON(http.StatusOK, result)
+}
@@ -0,0 +1,273 @@
+package router
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/YeonwooSung/instagram/api-gateway/metrics"
+	"github.com/YeonwooSung/instagram/api-gateway/middleware"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+const (
+	// batchPath is where batches are accepted; sub-requests may not target it
+	batchPath = "/api/v1/batch"
+
+	// batchMaxBodyBytes bounds the batch request, including every item body
+	batchMaxBodyBytes = 1 << 20
+
+	// batchMaxResponseBytes bounds each buffered sub-response
+	batchMaxResponseBytes = 1 << 20
+)
+
+// batchForwardHeaders are the caller's headers every sub-request inherits, so
+// sub-requests authenticate and rate limit as the caller
+var batchForwardHeaders = []string{
+	"Authorization",
+	"Cookie",
+	"Accept",
+	"Accept-Language",
+	"User-Agent",
+	"X-Forwarded-For",
+	"X-Real-IP",
+}
+
+// batchResponseHeaders are the sub-response headers returned with each item
+var batchResponseHeaders = []string{
+	"Content-Type",
+	"Location",
+	"ETag",
+	"Last-Modified",
+	"Cache-Control",
+	"Retry-After",
+	"Deprecation",
+	"Sunset",
+}
+
+var batchMethods = map[string]bool{
+	http.MethodGet:    true,
+	http.MethodPost:   true,
+	http.MethodPut:    true,
+	http.MethodPatch:  true,
+	http.MethodDelete: true,
+}
+
+var errBatchResponseTooLarge = errors.New("batch sub-response too large")
+
+// batchRequest is one sub-request of a batch. A body is sent as JSON.
+type batchRequest struct {
+	Method  string            `json:"method"`
+	Path    string            `json:"path"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    json.RawMessage   `json:"body,omitempty"`
+}
+
+// batchResponse is the outcome of one sub-request. JSON bodies are embedded
+// as is, any other body as a string.
+type batchResponse struct {
+	Status  int               `json:"status"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    json.RawMessage   `json:"body,omitempty"`
+}
+
+// batchHandler executes several API calls sent in one round trip. Each
+// sub-request is dispatched through the gateway's own router, so it passes
+// the same middleware, authentication, rate limits and routing as if the
+// client had sent it directly.
+type batchHandler struct {
+	engine      *gin.Engine
+	maxRequests int
+	concurrency int
+	logger      *zap.Logger
+}
+
+// serve runs the sub-requests of a batch concurrently, at most concurrency at
+// a time, and answers with their responses in request order. The batch
+// itself succeeds even when some of its items fail.
+func (h *batchHandler) serve(c *gin.Context) {
+	var requests []batchRequest
+	body := http.MaxBytesReader(c.Writer, c.Request.Body, batchMaxBodyBytes)
+	if err := json.NewDecoder(body).Decode(&requests); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Batch too large"})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Batch must be a JSON array of requests"})
+		return
+	}
+	if len(requests) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Batch is empty"})
+		return
+	}
+	if len(requests) > h.maxRequests {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Batch exceeds " + strconv.Itoa(h.maxRequests) + " requests",
+		})
+		return
+	}
+	for i := range requests {
+		if err := validateBatchRequest(&requests[i]); err != "" {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "Request " + strconv.Itoa(i) + ": " + err,
+			})
+			return
+		}
+	}
+
+	responses := make([]batchResponse, len(requests))
+	slots := make(chan struct{}, h.concurrency)
+	var wg sync.WaitGroup
+	for i := range requests {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(i int) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			responses[i] = h.execute(c, i, &requests[i])
+		}(i)
+	}
+	wg.Wait()
+
+	metrics.Inc("gateway_batch_requests_total")
+	c.JSON(http.StatusOK, responses)
+}
+
+// validateBatchRequest normalizes a sub-request, returning why it is invalid
+func validateBatchRequest(req *batchRequest) string {
+	req.Method = strings.ToUpper(req.Method)
+	if req.Method == "" {
+		req.Method = http.MethodGet
+	}
+	if !batchMethods[req.Method] {
+		return "unsupported method " + req.Method
+	}
+	if !strings.HasPrefix(req.Path, "/api/") || strings.HasPrefix(req.Path, "//") {
+		return "path must start with /api/"
+	}
+
+	// Check the path the router will see: decoded and cleaned, so that
+	// "/api/v1/%62atch" or "/api/v1/x/../batch" cannot nest a batch
+	u, err := url.ParseRequestURI(req.Path)
+	if err != nil {
+		return "invalid path"
+	}
+	routed := path.Clean(u.Path)
+	if !strings.HasPrefix(routed, "/api/") {
+		return "path must start with /api/"
+	}
+	if routed == batchPath {
+		return "batches cannot be nested"
+	}
+	return ""
+}
+
+// execute dispatches one sub-request through the router and buffers its response
+func (h *batchHandler) execute(c *gin.Context, index int, req *batchRequest) batchResponse {
+	var body io.Reader
+	if len(req.Body) > 0 {
+		body = bytes.NewReader(req.Body)
+	}
+	sub, err := http.NewRequestWithContext(c.Request.Context(), req.Method, req.Path, body)
+	if err != nil {
+		return batchError(http.StatusBadRequest, "Invalid request")
+	}
+	sub.RequestURI = req.Path
+	sub.Host = c.Request.Host
+	sub.RemoteAddr = c.Request.RemoteAddr
+	sub.Proto, sub.ProtoMajor, sub.ProtoMinor = c.Request.Proto, c.Request.ProtoMajor, c.Request.ProtoMinor
+
+	for _, name := range batchForwardHeaders {
+		if values := c.Request.Header.Values(name); len(values) > 0 {
+			sub.Header[name] = values
+		}
+	}
+	for name, value := range req.Headers {
+		sub.Header.Set(name, value)
+	}
+	if body != nil {
+		sub.Header.Set("Content-Type", "application/json")
+	}
+	// Sub-requests are traceable back to their batch
+	sub.Header.Set(middleware.RequestIDHeader, c.GetString("request_id")+"."+strconv.Itoa(index))
+
+	w := &batchWriter{header: make(http.Header)}
+	h.engine.ServeHTTP(w, sub)
+	if w.overflow {
+		h.logger.Warn("Batch sub-response too large",
+			zap.String("method", req.Method),
+			zap.String("path", req.Path),
+		)
+		return batchError(http.StatusBadGateway, "Response too large for a batch")
+	}
+
+	response := batchResponse{Status: w.status, Headers: make(map[string]string)}
+	if response.Status == 0 {
+		response.Status = http.StatusOK
+	}
+	for _, name := range batchResponseHeaders {
+		if value := w.header.Get(name); value != "" {
+			response.Headers[name] = value
+		}
+	}
+	if w.body.Len() > 0 {
+		if json.Valid(w.body.Bytes()) {
+			response.Body = w.body.Bytes()
+		} else {
+			response.Body, _ = json.Marshal(w.body.String())
+		}
+	}
+	return response
+}
+
+func batchError(status int, message string) batchResponse {
+	body, _ := json.Marshal(gin.H{"error": message})
+	return batchResponse{
+		Status:  status,
+		Headers: map[string]string{"Content-Type": "application/json"},
+		Body:    body,
+	}
+}
+
+// batchWriter buffers a sub-response in memory, up to batchMaxResponseBytes
+type batchWriter struct {
+	header   http.Header
+	status   int
+	body     bytes.Buffer
+	overflow bool
+}
+
+func (w *batchWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *batchWriter) WriteHeader(code int) {
+	if w.status == 0 && code >= 200 {
+		w.status = code
+	}
+}
+
+func (w *batchWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if w.body.Len()+len(b) > batchMaxResponseBytes {
+		w.overflow = true
+		return 0, errBatchResponseTooLarge
+	}
+	return w.body.Write(b)
+}
+
+// Flush is a no-op; streamed responses are buffered like any other
+func (w *batchWriter) Flush() {}
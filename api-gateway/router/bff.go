@@ -0,0 +1,238 @@
+package router
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/YeonwooSung/instagram/api-gateway/aggregate"
+	"github.com/YeonwooSung/instagram/api-gateway/config"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+const (
+	// bffCommentsPageSize is the number of comments on the post screen
+	bffCommentsPageSize = 20
+
+	// bffMaxMedia caps the media fetched for one post (carousel size)
+	bffMaxMedia = 10
+)
+
+// bffHandler serves screen-shaped composite endpoints: one client round trip
+// fans out to several services in parallel and returns their responses as
+// sections of a single document
+type bffHandler struct {
+	cfg        *config.Config
+	aggregator *aggregate.Aggregator
+	logger     *zap.Logger
+}
+
+// bffResponse is a composite screen payload. Sections whose call failed are
+// left out and listed in unavailable; sections served from a configured
+// fallback are listed in degraded.
+type bffResponse struct {
+	Sections    map[string]json.RawMessage
+	Unavailable []string
+	Degraded    []string
+	GeneratedAt time.Time
+}
+
+func (r *bffResponse) MarshalJSON() ([]byte, error) {
+	doc := make(map[string]interface{}, len(r.Sections)+3)
+	for name, section := range r.Sections {
+		doc[name] = section
+	}
+	if len(r.Unavailable) > 0 {
+		doc["unavailable"] = r.Unavailable
+	}
+	if len(r.Degraded) > 0 {
+		doc["degraded"] = r.Degraded
+	}
+	doc["generated_at"] = r.GeneratedAt
+	return json.Marshal(doc)
+}
+
+// home serves everything the app needs on open: the caller's profile, the
+// first feed page, their follower stats and unread notification counts
+func (h *bffHandler) home(c *gin.Context) {
+	userID, ok := bffUserID(c)
+	if !ok {
+		return
+	}
+
+	feedPath := "/api/v1/feed"
+	if c.Request.URL.RawQuery != "" {
+		feedPath += "?" + c.Request.URL.RawQuery
+	}
+	h.serve(c, "home", []aggregate.Call{
+		{Name: "profile", Upstream: h.cfg.AuthServiceURL, Path: "/api/v1/auth/me"},
+		{Name: "feed", Upstream: h.cfg.NewsfeedServiceURL, Path: feedPath},
+		{Name: "graph_stats", Upstream: h.cfg.GraphServiceURL, Path: "/api/v1/graph/stats/" + url.PathEscape(userID)},
+		{Name: "notification_counts", Upstream: h.cfg.NotificationServiceURL, Path: "/api/v1/notifications/counts"},
+	})
+}
+
+// postDetail serves the post screen: the post, its first page of comments and
+// like count, then the author's profile and the post's media, which depend on
+// the post's fields. A missing post answers with the post service's status.
+func (h *bffHandler) postDetail(c *gin.Context) {
+	ctx, header := c.Request.Context(), aggregate.ForwardHeaders(c)
+	id := url.PathEscape(c.Param("id"))
+
+	calls := []aggregate.Call{
+		{Name: "post", Upstream: h.cfg.PostServiceURL, Path: "/api/v1/posts/" + id},
+		{Name: "comments", Upstream: h.cfg.PostServiceURL, Path: "/api/v1/posts/" + id + "/comments?page=1&page_size=" + strconv.Itoa(bffCommentsPageSize)},
+		{Name: "like_count", Upstream: h.cfg.PostServiceURL, Path: "/api/v1/posts/" + id + "/likes/count"},
+	}
+	results, err := h.aggregator.Fetch(ctx, "post_detail", header, calls)
+	if err != nil {
+		h.logger.Warn("Composite endpoint failed", zap.String("endpoint", "post_detail"), zap.Error(err))
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Upstream unavailable"})
+		return
+	}
+
+	post := results["post"]
+	if !post.OK() {
+		if post.Err == nil && post.Status >= 400 && post.Status < 500 {
+			c.Data(post.Status, "application/json", post.Body)
+			return
+		}
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Upstream unavailable"})
+		return
+	}
+	var fields struct {
+		UserID   json.RawMessage `json:"user_id"`
+		AuthorID json.RawMessage `json:"author_id"`
+		MediaIDs []string        `json:"media_ids"`
+		MediaID  string          `json:"media_id"`
+	}
+	json.Unmarshal(post.Body, &fields)
+
+	response := newBFFResponse()
+	response.collect(calls, results)
+
+	var dependent []aggregate.Call
+	if author := rawID(fields.UserID, fields.AuthorID); author != "" {
+		dependent = append(dependent, aggregate.Call{
+			Name: "author", Upstream: h.cfg.AuthServiceURL, Path: "/api/v1/auth/users/" + url.PathEscape(author),
+		})
+	}
+	mediaIDs := fields.MediaIDs
+	if len(mediaIDs) == 0 && fields.MediaID != "" {
+		mediaIDs = []string{fields.MediaID}
+	}
+	mediaIDs = mediaIDs[:min(len(mediaIDs), bffMaxMedia)]
+	for i, mediaID := range mediaIDs {
+		dependent = append(dependent, aggregate.Call{
+			Name: "media." + strconv.Itoa(i), Upstream: h.cfg.MediaServiceURL, Path: "/api/v1/media/" + url.PathEscape(mediaID),
+		})
+	}
+	if len(dependent) == 0 {
+		h.write(c, response)
+		return
+	}
+
+	results, err = h.aggregator.Fetch(ctx, "post_detail", header, dependent)
+	if err != nil {
+		h.logger.Warn("Composite endpoint failed", zap.String("endpoint", "post_detail"), zap.Error(err))
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Upstream unavailable"})
+		return
+	}
+	response.collect(dependent[:len(dependent)-len(mediaIDs)], results)
+
+	// Media come back as one list in the post's order; any missing item
+	// marks the whole section unavailable
+	if len(mediaIDs) > 0 {
+		media := make([]json.RawMessage, 0, len(mediaIDs))
+		for _, call := range dependent[len(dependent)-len(mediaIDs):] {
+			if result := results[call.Name]; result.OK() && json.Valid(result.Body) {
+				media = append(media, result.Body)
+			}
+		}
+		if len(media) < len(mediaIDs) {
+			response.Unavailable = append(response.Unavailable, "media")
+		}
+		response.Sections["media"], _ = json.Marshal(media)
+	}
+	h.write(c, response)
+}
+
+// rawID returns the first of the JSON string or number IDs that is set
+func rawID(values ...json.RawMessage) string {
+	for _, value := range values {
+		var id interface{}
+		if json.Unmarshal(value, &id) != nil {
+			continue
+		}
+		switch id := id.(type) {
+		case string:
+			if id != "" {
+				return id
+			}
+		case float64:
+			return strconv.FormatFloat(id, 'f', -1, 64)
+		}
+	}
+	return ""
+}
+
+// serve runs the calls of a composite endpoint and writes each successful
+// JSON response as the section of the same name. The response is 502 only
+// when a required call or every call failed.
+func (h *bffHandler) serve(c *gin.Context, endpoint string, calls []aggregate.Call) {
+	results, err := h.aggregator.Fetch(c.Request.Context(), endpoint, aggregate.ForwardHeaders(c), calls)
+	if err != nil {
+		h.logger.Warn("Composite endpoint failed", zap.String("endpoint", endpoint), zap.Error(err))
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Upstream unavailable"})
+		return
+	}
+
+	response := newBFFResponse()
+	response.collect(calls, results)
+	if len(response.Sections) == 0 {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Upstream unavailable"})
+		return
+	}
+	h.write(c, response)
+}
+
+func (h *bffHandler) write(c *gin.Context, response *bffResponse) {
+	c.Header("Cache-Control", "private, no-store")
+	c.JSON(http.StatusOK, response)
+}
+
+func newBFFResponse() *bffResponse {
+	return &bffResponse{
+		Sections:    make(map[string]json.RawMessage),
+		GeneratedAt: time.Now().UTC(),
+	}
+}
+
+// collect adds the results of calls as sections of the same name
+func (r *bffResponse) collect(calls []aggregate.Call, results map[string]aggregate.Result) {
+	for _, call := range calls {
+		result := results[call.Name]
+		if !result.OK() || !json.Valid(result.Body) {
+			r.Unavailable = append(r.Unavailable, call.Name)
+			continue
+		}
+		r.Sections[call.Name] = result.Body
+		if result.Fallback {
+			r.Degraded = append(r.Degraded, call.Name)
+		}
+	}
+}
+
+// bffUserID returns the verified caller, answering 401 when there is none
+func bffUserID(c *gin.Context) (string, bool) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Token has no user"})
+		return "", false
+	}
+	return fmt.Sprintf("%v", userID), true
+}
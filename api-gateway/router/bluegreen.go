@@ -0,0 +1,350 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/YeonwooSung/instagram/api-gateway/config"
+	"github.com/YeonwooSung/instagram/api-gateway/metrics"
+	"github.com/YeonwooSung/instagram/api-gateway/proxy"
+	"github.com/YeonwooSung/instagram/api-gateway/state"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+const (
+	blueGreenKey     = "gateway:bluegreen"
+	blueGreenChannel = "gateway:bluegreen:changed"
+
+	colorBlue  = "blue"
+	colorGreen = "green"
+
+	// blueGreenCheckInterval is how often a monitored switch's error rate is checked
+	blueGreenCheckInterval = 5 * time.Second
+)
+
+// errSwitchConflict means the state changed while a switch was being applied
+var errSwitchConflict = errors.New("blue-green state changed concurrently")
+
+// blueGreenStateSchema versions blueGreenState as stored in Redis
+var blueGreenStateSchema = state.NewSchema("blue_green_state", 1)
+
+// blueGreenState is the active color of one upstream. Monitor is set by a
+// switch and cleared by a rollback, so a rollback is never itself rolled back.
+type blueGreenState struct {
+	Active     string    `json:"active"`
+	SwitchedAt time.Time `json:"switched_at"`
+	Monitor    bool      `json:"monitor"`
+	RolledBack bool      `json:"rolled_back"`
+}
+
+// blueGreen switches upstreams between their blue and green URL sets. The
+// active color is stored in Redis so every replica flips together. After a
+// switch each replica watches the error rate of the new target for the
+// monitoring window and any of them can roll the switch back.
+type blueGreen struct {
+	redis    *redis.Client
+	proxy    *proxy.ProxyHandler
+	sets     map[string]config.BlueGreenSet
+	upstream map[string]string
+	cfg      *config.Config
+	logger   *zap.Logger
+
+	mu       sync.Mutex
+	monitors map[string]context.CancelFunc
+	seen     map[string]time.Time
+}
+
+func newBlueGreen(
+	redisClient *redis.Client,
+	proxyHandler *proxy.ProxyHandler,
+	cfg *config.Config,
+	logger *zap.Logger,
+) *blueGreen {
+	b := &blueGreen{
+		redis:    redisClient,
+		proxy:    proxyHandler,
+		sets:     cfg.BlueGreen,
+		upstream: cfg.ServiceURLs(),
+		cfg:      cfg,
+		logger:   logger,
+		monitors: make(map[string]context.CancelFunc),
+		seen:     make(map[string]time.Time),
+	}
+
+	// Blue serves until Redis says otherwise
+	for name, set := range b.sets {
+		b.proxy.SetActiveTarget(b.upstream[name], set.Blue)
+	}
+	return b
+}
+
+// sync applies the stored colors until ctx is cancelled
+func (b *blueGreen) sync(ctx context.Context) {
+	if len(b.sets) == 0 {
+		return
+	}
+
+	b.load(ctx)
+
+	sub := b.redis.Subscribe(ctx, blueGreenChannel)
+	defer sub.Close()
+
+	ticker := time.NewTicker(dynamicReloadInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-sub.Channel():
+		case <-ticker.C:
+		}
+		b.load(ctx)
+	}
+}
+
+// load applies the active color of every upstream and starts monitoring
+// switches that are still within their window
+func (b *blueGreen) load(ctx context.Context) {
+	raw, err := b.redis.HGetAll(ctx, blueGreenKey).Result()
+	if err != nil {
+		b.logger.Warn("Failed to load blue-green state", zap.Error(err))
+		return
+	}
+
+	for name, set := range b.sets {
+		data, ok := raw[name]
+		if !ok {
+			continue
+		}
+		var st blueGreenState
+		if err := blueGreenStateSchema.Unmarshal([]byte(data), &st); err != nil {
+			b.logger.Warn("Skipping invalid blue-green state", zap.String("upstream", name), zap.Error(err))
+			continue
+		}
+
+		b.proxy.SetActiveTarget(b.upstream[name], set.URL(st.Active))
+		green := int64(0)
+		if st.Active == colorGreen {
+			green = 1
+		}
+		metrics.Set("gateway_bluegreen_green_active", green, "upstream", name)
+
+		b.mu.Lock()
+		isNew := !b.seen[name].Equal(st.SwitchedAt)
+		b.seen[name] = st.SwitchedAt
+		if previous, ok := b.monitors[name]; ok && isNew {
+			previous()
+			delete(b.monitors, name)
+		}
+		b.mu.Unlock()
+
+		if isNew && st.Monitor && time.Since(st.SwitchedAt) < b.cfg.BlueGreenMonitorWindow {
+			b.startMonitor(ctx, name, st)
+		}
+	}
+}
+
+// startMonitor watches the new target's error rate until the window ends
+func (b *blueGreen) startMonitor(ctx context.Context, name string, st blueGreenState) {
+	deadline := st.SwitchedAt.Add(b.cfg.BlueGreenMonitorWindow)
+	monitorCtx, cancel := context.WithDeadline(ctx, deadline)
+
+	b.mu.Lock()
+	b.monitors[name] = cancel
+	b.mu.Unlock()
+
+	target := b.sets[name].URL(st.Active)
+	baseRequests, baseErrors := b.proxy.TargetStats(target)
+
+	go func() {
+		defer cancel()
+
+		ticker := time.NewTicker(blueGreenCheckInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-monitorCtx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			requests, errs := b.proxy.TargetStats(target)
+			requests -= baseRequests
+			errs -= baseErrors
+			if requests < uint64(b.cfg.BlueGreenMinRequests) ||
+				errs*100 <= requests*uint64(b.cfg.BlueGreenErrorPercent) {
+				continue
+			}
+
+			b.logger.Warn("Error rate exceeded after blue-green switch, rolling back",
+				zap.String("upstream", name),
+				zap.String("target", target),
+				zap.Uint64("requests", requests),
+				zap.Uint64("errors", errs),
+			)
+			err := b.apply(monitorCtx, name, func(current blueGreenState) (blueGreenState, error) {
+				// Only roll back the switch being monitored
+				if !current.SwitchedAt.Equal(st.SwitchedAt) {
+					return current, errSwitchConflict
+				}
+				return blueGreenState{
+					Active:     opposite(st.Active),
+					SwitchedAt: time.Now().UTC(),
+					RolledBack: true,
+				}, nil
+			})
+			if err != nil && !errors.Is(err, errSwitchConflict) {
+				b.logger.Error("Blue-green rollback failed", zap.String("upstream", name), zap.Error(err))
+				continue
+			}
+			if err == nil {
+				metrics.Inc("gateway_bluegreen_rollbacks_total", "upstream", name)
+			}
+			return
+		}
+	}()
+}
+
+// apply atomically replaces an upstream's state with update(current) and
+// notifies every replica
+func (b *blueGreen) apply(ctx context.Context, name string, update func(blueGreenState) (blueGreenState, error)) error {
+	err := b.redis.Watch(ctx, func(tx *redis.Tx) error {
+		current := blueGreenState{Active: colorBlue}
+		data, err := tx.HGet(ctx, blueGreenKey, name).Bytes()
+		if err != nil && !errors.Is(err, redis.Nil) {
+			return err
+		}
+		if err == nil {
+			if err := blueGreenStateSchema.Unmarshal(data, &current); err != nil {
+				return err
+			}
+		}
+
+		next, err := update(current)
+		if err != nil {
+			return err
+		}
+		encoded, err := blueGreenStateSchema.Marshal(next)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.HSet(ctx, blueGreenKey, name, encoded)
+			return nil
+		})
+		return err
+	}, blueGreenKey)
+	if errors.Is(err, redis.TxFailedErr) {
+		return errSwitchConflict
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := b.redis.Publish(ctx, blueGreenChannel, name).Err(); err != nil {
+		b.logger.Warn("Failed to publish blue-green change", zap.Error(err))
+	}
+	return nil
+}
+
+func (b *blueGreen) registerAdmin(admin *gin.RouterGroup) {
+	admin.GET("/bluegreen", b.list)
+	admin.POST("/bluegreen/:upstream/switch", b.switchColor)
+}
+
+func (b *blueGreen) list(c *gin.Context) {
+	raw, err := b.redis.HGetAll(c.Request.Context(), blueGreenKey).Result()
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Route store unavailable"})
+		return
+	}
+
+	upstreams := make(map[string]gin.H, len(b.sets))
+	for name, set := range b.sets {
+		st := blueGreenState{Active: colorBlue}
+		if data, ok := raw[name]; ok {
+			blueGreenStateSchema.Unmarshal([]byte(data), &st)
+		}
+		upstreams[name] = gin.H{
+			"active":      st.Active,
+			"url":         set.URL(st.Active),
+			"blue":        set.Blue,
+			"green":       set.Green,
+			"switched_at": st.SwitchedAt,
+			"rolled_back": st.RolledBack,
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"upstreams": upstreams})
+}
+
+// switchColor flips an upstream to the given color (the other one by default)
+// and starts monitoring it for automatic rollback
+func (b *blueGreen) switchColor(c *gin.Context) {
+	name := c.Param("upstream")
+	if _, ok := b.sets[name]; !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Upstream has no blue-green sets"})
+		return
+	}
+
+	var req struct {
+		Target string `json:"target"`
+	}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid switch request"})
+			return
+		}
+	}
+	if req.Target != "" && req.Target != colorBlue && req.Target != colorGreen {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Target must be blue or green"})
+		return
+	}
+
+	var next blueGreenState
+	err := b.apply(c.Request.Context(), name, func(current blueGreenState) (blueGreenState, error) {
+		target := req.Target
+		if target == "" {
+			target = opposite(current.Active)
+		}
+		next = blueGreenState{
+			Active:     target,
+			SwitchedAt: time.Now().UTC(),
+			Monitor:    target != current.Active,
+		}
+		return next, nil
+	})
+	if errors.Is(err, errSwitchConflict) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Concurrent switch in progress"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Route store unavailable"})
+		return
+	}
+
+	b.logger.Info("Blue-green switch",
+		zap.String("upstream", name),
+		zap.String("active", next.Active),
+	)
+	c.JSON(http.StatusOK, gin.H{
+		"upstream":    name,
+		"active":      next.Active,
+		"url":         b.sets[name].URL(next.Active),
+		"switched_at": next.SwitchedAt,
+	})
+}
+
+func opposite(color string) string {
+	if color == colorGreen {
+		return colorBlue
+	}
+	return colorGreen
+}
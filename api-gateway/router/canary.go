@@ -0,0 +1,118 @@
+package router
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+
+	"github.com/YeonwooSung/instagram/api-gateway/proxy"
+	"github.com/YeonwooSung/instagram/api-gateway/state"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+const dynamicCanariesKey = "gateway:canaries"
+
+// canarySchema versions proxy.Canary as stored in Redis
+var canarySchema = state.NewSchema("canary", 1)
+
+// loadCanaries reads canary splits (keyed by upstream name) from Redis and
+// hands them to the proxy keyed by the upstream's base URL
+func (d *dynamicRoutes) loadCanaries(ctx context.Context) error {
+	raw, err := d.redis.HGetAll(ctx, dynamicCanariesKey).Result()
+	if err != nil {
+		return err
+	}
+
+	canaries := make(map[string]proxy.Canary, len(raw))
+	for name, data := range raw {
+		var canary proxy.Canary
+		if err := canarySchema.Unmarshal([]byte(data), &canary); err != nil {
+			d.logger.Warn("Skipping invalid canary", zap.String("upstream", name), zap.Error(err))
+			continue
+		}
+		upstreamURL, ok := d.upstreamURL(name)
+		if !ok {
+			d.logger.Warn("Skipping canary for unknown upstream", zap.String("upstream", name))
+			continue
+		}
+		canaries[upstreamURL] = canary
+	}
+
+	d.proxy.SetCanaries(canaries)
+	return nil
+}
+
+func (d *dynamicRoutes) listCanaries(c *gin.Context) {
+	raw, err := d.redis.HGetAll(c.Request.Context(), dynamicCanariesKey).Result()
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Route store unavailable"})
+		return
+	}
+
+	canaries := make(map[string]proxy.Canary, len(raw))
+	for name, data := range raw {
+		var canary proxy.Canary
+		if canarySchema.Unmarshal([]byte(data), &canary) == nil {
+			canaries[name] = canary
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"canaries": canaries})
+}
+
+// putCanary starts or adjusts a rollout: weight percent of the upstream's
+// traffic goes to url, sticky per user
+func (d *dynamicRoutes) putCanary(c *gin.Context) {
+	name := c.Param("upstream")
+	if _, ok := d.upstreamURL(name); !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Unknown upstream"})
+		return
+	}
+
+	var canary proxy.Canary
+	if err := c.ShouldBindJSON(&canary); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid canary definition"})
+		return
+	}
+	u, err := url.Parse(canary.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid canary URL"})
+		return
+	}
+	if canary.Weight < 0 || canary.Weight > 100 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Weight must be between 0 and 100"})
+		return
+	}
+
+	data, _ := canarySchema.Marshal(canary)
+	if err := d.redis.HSet(c.Request.Context(), dynamicCanariesKey, name, data).Err(); err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Route store unavailable"})
+		return
+	}
+	d.notify(c.Request.Context())
+
+	d.logger.Info("Canary saved",
+		zap.String("upstream", name),
+		zap.String("canary", canary.URL),
+		zap.Int("weight", canary.Weight),
+	)
+	c.JSON(http.StatusOK, gin.H{"upstream": name, "url": canary.URL, "weight": canary.Weight})
+}
+
+// deleteCanary ends a rollout, sending all traffic back to the stable upstream
+func (d *dynamicRoutes) deleteCanary(c *gin.Context) {
+	name := c.Param("upstream")
+	removed, err := d.redis.HDel(c.Request.Context(), dynamicCanariesKey, name).Result()
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Route store unavailable"})
+		return
+	}
+	if removed == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Canary not found"})
+		return
+	}
+	d.notify(c.Request.Context())
+
+	d.logger.Info("Canary deleted", zap.String("upstream", name))
+	c.Status(http.StatusNoContent)
+}
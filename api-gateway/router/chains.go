@@ -0,0 +1,396 @@
+package router
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/YeonwooSung/instagram/api-gateway/accounting"
+	"github.com/YeonwooSung/instagram/api-gateway/config"
+	"github.com/YeonwooSung/instagram/api-gateway/middleware"
+	"github.com/YeonwooSung/instagram/api-gateway/scripting"
+	"github.com/gin-gonic/gin"
+)
+
+// middlewareFactory builds a middleware from the options configured for it in a chain
+type middlewareFactory func(opts middlewareOptions) (gin.HandlerFunc, error)
+
+// middlewareRegistry resolves the middleware chains declared in config. Each
+// middleware usable in a chain is registered under a name with a factory.
+type middlewareRegistry struct {
+	factories      map[string]middlewareFactory
+	chains         map[string][]gin.HandlerFunc
+	groups         map[string]string
+	policyLimiters map[string]*middleware.RateLimiter
+}
+
+func newMiddlewareRegistry(
+	deps Dependencies,
+	deduplicator *middleware.Deduplicator,
+	responseCache *middleware.ResponseCache,
+	flags *middleware.Flags,
+	quarantine *middleware.Quarantine,
+) *middlewareRegistry {
+	cfg := deps.Config
+
+	// One limiter per named policy, shared by every route and chain that references it
+	policyLimiters := make(map[string]*middleware.RateLimiter, len(cfg.RateLimitPolicies))
+	for name, policy := range cfg.RateLimitPolicies {
+		policyLimiters[name] = middleware.NewRateLimiter(policy.RPS, policy.Burst, cfg.RateLimitIPv6Prefix)
+	}
+
+	m := &middlewareRegistry{
+		factories:      make(map[string]middlewareFactory),
+		chains:         make(map[string][]gin.HandlerFunc),
+		groups:         cfg.GroupChains,
+		policyLimiters: policyLimiters,
+	}
+
+	m.register("rate_limit", func(opts middlewareOptions) (gin.HandlerFunc, error) {
+		limiter, err := m.limiter(deps.RateLimiter, opts)
+		if err != nil {
+			return nil, err
+		}
+		return limiter.RateLimit(), nil
+	})
+	m.register("user_rate_limit", func(opts middlewareOptions) (gin.HandlerFunc, error) {
+		limiter, err := m.limiter(deps.RateLimiter, opts)
+		if err != nil {
+			return nil, err
+		}
+		return limiter.UserRateLimit(), nil
+	})
+	m.register("jwt_auth", func(opts middlewareOptions) (gin.HandlerFunc, error) {
+		return middleware.JWTAuth(cfg.JWTSecrets), opts.done()
+	})
+	m.register("optional_jwt_auth", func(opts middlewareOptions) (gin.HandlerFunc, error) {
+		return middleware.OptionalJWTAuth(cfg.JWTSecrets), opts.done()
+	})
+	m.register("admin_auth", func(opts middlewareOptions) (gin.HandlerFunc, error) {
+		return middleware.AdminAuth(cfg.CurrentAdminToken), opts.done()
+	})
+	m.register("timeout", func(opts middlewareOptions) (gin.HandlerFunc, error) {
+		timeout, err := opts.duration("timeout", cfg.ProxyTimeout)
+		if err != nil {
+			return nil, err
+		}
+		return middleware.Timeout(timeout), opts.done()
+	})
+	m.register("dedup", func(opts middlewareOptions) (gin.HandlerFunc, error) {
+		window, err := opts.duration("window", cfg.DedupWindow)
+		if err != nil {
+			return nil, err
+		}
+		return deduplicator.Dedup(window), opts.done()
+	})
+
+	replayGuard := middleware.NewReplayGuard(deps.RedisHealth, cfg.ReplaySigningSecrets, deps.Logger)
+	m.register("replay_protection", func(opts middlewareOptions) (gin.HandlerFunc, error) {
+		window, err := opts.duration("window", cfg.ReplayWindow)
+		if err != nil {
+			return nil, err
+		}
+		if window <= 0 {
+			return nil, fmt.Errorf("option window must be positive")
+		}
+		return replayGuard.Protect(window), opts.done()
+	})
+	m.register("cache", func(opts middlewareOptions) (gin.HandlerFunc, error) {
+		ttl, err := opts.duration("ttl", 0)
+		if err != nil {
+			return nil, err
+		}
+		if ttl <= 0 {
+			return nil, fmt.Errorf("option ttl is required")
+		}
+		perUser, err := opts.bool("per_user")
+		if err != nil {
+			return nil, err
+		}
+		return responseCache.Cache(middleware.CacheOptions{TTL: ttl, PerUser: perUser}), opts.done()
+	})
+	m.register("quarantine", func(opts middlewareOptions) (gin.HandlerFunc, error) {
+		samplePercent, err := opts.int("sample_percent")
+		if err != nil {
+			return nil, err
+		}
+		window, err := opts.int("window")
+		if err != nil {
+			return nil, err
+		}
+		anomalyPercent, err := opts.int("anomaly_percent")
+		if err != nil {
+			return nil, err
+		}
+		sizeFactor, err := opts.int("size_factor")
+		if err != nil {
+			return nil, err
+		}
+		staleTTL, err := opts.duration("stale_ttl", time.Hour)
+		if err != nil {
+			return nil, err
+		}
+		if samplePercent == 0 {
+			samplePercent = 10
+		}
+		if window == 0 {
+			window = 20
+		}
+		if anomalyPercent == 0 {
+			anomalyPercent = 50
+		}
+		if sizeFactor == 0 {
+			sizeFactor = 5
+		}
+		if samplePercent > 100 || anomalyPercent > 100 || sizeFactor < 2 || staleTTL <= 0 {
+			return nil, fmt.Errorf("percentages must be at most 100, size_factor at least 2 and stale_ttl positive")
+		}
+		return quarantine.Guard(middleware.QuarantineOptions{
+			SamplePercent:  int(samplePercent),
+			Window:         int(window),
+			AnomalyPercent: int(anomalyPercent),
+			SizeFactor:     float64(sizeFactor),
+			StaleTTL:       staleTTL,
+		}), opts.done()
+	})
+	m.register("resource_budget", func(opts middlewareOptions) (gin.HandlerFunc, error) {
+		wallTime, err := opts.duration("wall_time", 0)
+		if err != nil {
+			return nil, err
+		}
+		maxBytes, err := opts.int("max_bytes")
+		if err != nil {
+			return nil, err
+		}
+		maxCalls, err := opts.int("max_upstream_calls")
+		if err != nil {
+			return nil, err
+		}
+		limits := accounting.Limits{WallTime: wallTime, Bytes: maxBytes, UpstreamCalls: maxCalls}
+		return middleware.ResourceBudget(limits, deps.Logger), opts.done()
+	})
+	m.register("feature_flag", func(opts middlewareOptions) (gin.HandlerFunc, error) {
+		flag := opts.take("flag")
+		if flag == "" {
+			return nil, fmt.Errorf("option flag is required")
+		}
+		return flags.Gate(flag, cfg.JWTSecrets), opts.done()
+	})
+	m.register("cursor_pagination", func(opts middlewareOptions) (gin.HandlerFunc, error) {
+		if deps.CursorCipher == nil {
+			return nil, fmt.Errorf("CURSOR_KEYS is required")
+		}
+		items := opts.take("items")
+		if items == "" {
+			items = "items"
+		}
+		limit, err := opts.int("limit")
+		if err != nil {
+			return nil, err
+		}
+		maxLimit, err := opts.int("max_limit")
+		if err != nil {
+			return nil, err
+		}
+		ttl, err := opts.duration("ttl", 24*time.Hour)
+		if err != nil {
+			return nil, err
+		}
+		if limit == 0 {
+			limit = 20
+		}
+		if maxLimit == 0 {
+			maxLimit = 100
+		}
+		if limit > maxLimit || ttl <= 0 {
+			return nil, fmt.Errorf("limit must not exceed max_limit and ttl must be positive")
+		}
+		paginator := middleware.NewCursorPaginator(deps.CursorCipher, deps.Logger)
+		return paginator.Paginate(middleware.CursorOptions{
+			Items:        items,
+			DefaultLimit: int(limit),
+			MaxLimit:     int(maxLimit),
+			TTL:          ttl,
+		}), opts.done()
+	})
+	m.register("consent_required", func(opts middlewareOptions) (gin.HandlerFunc, error) {
+		category := opts.take("category")
+		if category != middleware.ConsentAnalytics && category != middleware.ConsentAds {
+			return nil, fmt.Errorf("option category must be analytics or ads")
+		}
+		return middleware.RequireConsent(category), opts.done()
+	})
+	m.register("scrape_guard", func(opts middlewareOptions) (gin.HandlerFunc, error) {
+		maxDepth, err := opts.int("max_depth")
+		if err != nil {
+			return nil, err
+		}
+		streak, err := opts.int("streak")
+		if err != nil {
+			return nil, err
+		}
+		interval, err := opts.duration("interval", cfg.ScrapeStepInterval)
+		if err != nil {
+			return nil, err
+		}
+		delay, err := opts.duration("delay", cfg.ScrapeDelay)
+		if err != nil {
+			return nil, err
+		}
+		maxDelay, err := opts.duration("max_delay", cfg.ScrapeMaxDelay)
+		if err != nil {
+			return nil, err
+		}
+		if maxDepth == 0 {
+			maxDepth = int64(cfg.ScrapeAnonMaxDepth)
+		}
+		if streak == 0 {
+			streak = int64(cfg.ScrapeStreak)
+		}
+		if streak > 0 && (interval <= 0 || delay <= 0 || maxDelay < delay) {
+			return nil, fmt.Errorf("interval and delay must be positive and max_delay at least delay")
+		}
+		guard := middleware.NewScrapeGuard(middleware.ScrapeGuardOptions{
+			AnonMaxDepth: int(maxDepth),
+			Streak:       int(streak),
+			StepInterval: interval,
+			Delay:        delay,
+			MaxDelay:     maxDelay,
+		})
+		return guard.Guard(), opts.done()
+	})
+	m.register("script", func(opts middlewareOptions) (gin.HandlerFunc, error) {
+		file := opts.take("file")
+		if file == "" {
+			return nil, fmt.Errorf("option file is required")
+		}
+		timeout, err := opts.duration("timeout", 10*time.Millisecond)
+		if err != nil {
+			return nil, err
+		}
+		if err := opts.done(); err != nil {
+			return nil, err
+		}
+		script, err := scripting.Load(file, timeout, deps.Logger)
+		if err != nil {
+			return nil, err
+		}
+		return script.Middleware(), nil
+	})
+
+	return m
+}
+
+// register makes a middleware available to config chains under name
+func (m *middlewareRegistry) register(name string, factory middlewareFactory) {
+	m.factories[name] = factory
+}
+
+// build instantiates every configured chain, failing on unknown middleware or options
+func (m *middlewareRegistry) build(chains map[string][]config.MiddlewareSpec) error {
+	for name, specs := range chains {
+		handlers := make([]gin.HandlerFunc, 0, len(specs))
+		for _, spec := range specs {
+			factory, ok := m.factories[spec.Name]
+			if !ok {
+				return fmt.Errorf("middleware chain %q: unknown middleware %q", name, spec.Name)
+			}
+
+			opts := make(middlewareOptions, len(spec.Options))
+			for key, value := range spec.Options {
+				opts[key] = value
+			}
+			handler, err := factory(opts)
+			if err != nil {
+				return fmt.Errorf("middleware chain %q: %s: %w", name, spec.Name, err)
+			}
+			handlers = append(handlers, handler)
+		}
+		m.chains[name] = handlers
+	}
+	return nil
+}
+
+// chain returns the handlers of a named chain (nil for an empty name)
+func (m *middlewareRegistry) chain(name string) []gin.HandlerFunc {
+	return m.chains[name]
+}
+
+// group returns the chain configured for a route group path, or defaults
+// when the config does not declare one
+func (m *middlewareRegistry) group(path string, defaults ...gin.HandlerFunc) []gin.HandlerFunc {
+	if name, ok := m.groups[path]; ok {
+		return m.chains[name]
+	}
+	return defaults
+}
+
+// limiter returns the limiter for the "policy" option, or the default limiter
+func (m *middlewareRegistry) limiter(defaultLimiter *middleware.RateLimiter, opts middlewareOptions) (*middleware.RateLimiter, error) {
+	policy := opts.take("policy")
+	if err := opts.done(); err != nil {
+		return nil, err
+	}
+	if policy == "" {
+		return defaultLimiter, nil
+	}
+
+	limiter, ok := m.policyLimiters[policy]
+	if !ok {
+		return nil, fmt.Errorf("unknown rate limit policy %q", policy)
+	}
+	return limiter, nil
+}
+
+// middlewareOptions are the string options of one chain entry. Factories take
+// the options they understand; anything left over is rejected by done.
+type middlewareOptions map[string]string
+
+func (o middlewareOptions) take(key string) string {
+	value := o[key]
+	delete(o, key)
+	return value
+}
+
+func (o middlewareOptions) duration(key string, defaultValue time.Duration) (time.Duration, error) {
+	value := o.take(key)
+	if value == "" {
+		return defaultValue, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("option %s: invalid duration %q", key, value)
+	}
+	return d, nil
+}
+
+func (o middlewareOptions) int(key string) (int64, error) {
+	value := o.take(key)
+	if value == "" {
+		return 0, nil
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("option %s: invalid number %q", key, value)
+	}
+	return n, nil
+}
+
+func (o middlewareOptions) bool(key string) (bool, error) {
+	value := o.take(key)
+	if value == "" {
+		return false, nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("option %s: invalid boolean %q", key, value)
+	}
+	return b, nil
+}
+
+func (o middlewareOptions) done() error {
+	for key := range o {
+		return fmt.Errorf("unknown option %q", key)
+	}
+	return nil
+}
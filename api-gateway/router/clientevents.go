@@ -0,0 +1,140 @@
+package router
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/YeonwooSung/instagram/api-gateway/config"
+	"github.com/YeonwooSung/instagram/api-gateway/events"
+	"github.com/YeonwooSung/instagram/api-gateway/metrics"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// clientEventsMaxBodyBytes bounds a batch of client events
+	clientEventsMaxBodyBytes = 512 << 10
+
+	// clientEventMaxAge is how old an event may be when it arrives; clients
+	// holding events while offline send them late
+	clientEventMaxAge = 7 * 24 * time.Hour
+
+	// clientEventMaxSkew is how far ahead of the gateway a client clock may run
+	clientEventMaxSkew = 5 * time.Minute
+
+	// clientEventMaxSessionID bounds the session ID
+	clientEventMaxSessionID = 128
+)
+
+// clientEventType is the form of an event type, e.g. "feed.impression"
+var clientEventType = regexp.MustCompile(`^[a-z][a-z0-9_.]{0,63}$`)
+
+// clientEvent is one event as sent by a client
+type clientEvent struct {
+	Type       string          `json:"type"`
+	Timestamp  string          `json:"timestamp"`
+	SessionID  string          `json:"session_id,omitempty"`
+	Properties json.RawMessage `json:"properties,omitempty"`
+}
+
+// publishedEvent is a client event as published to Kafka, along with what
+// the gateway knows about the request that carried it
+type publishedEvent struct {
+	clientEvent
+	UserID     string    `json:"user_id,omitempty"`
+	RequestID  string    `json:"request_id,omitempty"`
+	ReceivedAt time.Time `json:"received_at"`
+}
+
+// clientEvents ingests batches of client analytics events (impressions,
+// taps, screen views) and publishes them to Kafka instead of proxying them,
+// so tracking never waits on a backend. A batch is validated as a whole and
+// rejected with 400 if any event is malformed; accepted events are queued
+// and the client gets 202 at once.
+type clientEvents struct {
+	cfg       *config.Config
+	publisher *events.Publisher
+}
+
+// ingest validates a batch of events and queues it for Kafka
+func (h *clientEvents) ingest(c *gin.Context) {
+	if h.publisher == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Event ingestion unavailable"})
+		return
+	}
+
+	var batch struct {
+		Events []clientEvent `json:"events"`
+	}
+	decoder := json.NewDecoder(http.MaxBytesReader(c.Writer, c.Request.Body, clientEventsMaxBodyBytes))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&batch); err != nil {
+		h.reject(c, "malformed", "Body must be {\"events\": [...]} of at most 512 KB")
+		return
+	}
+	if len(batch.Events) == 0 || len(batch.Events) > h.cfg.EventsMaxBatch {
+		h.reject(c, "batch_size", fmt.Sprintf("events must hold 1 to %d events", h.cfg.EventsMaxBatch))
+		return
+	}
+
+	now := time.Now().UTC()
+	for i, event := range batch.Events {
+		if err := validateClientEvent(event, now); err != nil {
+			h.reject(c, "schema", fmt.Sprintf("events[%d]: %s", i, err))
+			return
+		}
+	}
+
+	userID := c.GetString("user_id")
+	accepted := 0
+	for _, event := range batch.Events {
+		value, err := json.Marshal(publishedEvent{
+			clientEvent: event,
+			UserID:      userID,
+			RequestID:   c.GetString("request_id"),
+			ReceivedAt:  now,
+		})
+		if err != nil {
+			continue
+		}
+		// Keyed by user, or by session for signed-out users, so each one's
+		// events stay in order
+		key := userID
+		if key == "" {
+			key = event.SessionID
+		}
+		if h.publisher.Publish(h.cfg.EventsTopic, events.Record{Key: key, Value: value}) {
+			accepted++
+		}
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"accepted": accepted})
+}
+
+func (h *clientEvents) reject(c *gin.Context, reason, message string) {
+	metrics.Inc("gateway_events_rejected_total", "reason", reason)
+	c.JSON(http.StatusBadRequest, gin.H{"error": message})
+}
+
+// validateClientEvent checks one event against the event schema
+func validateClientEvent(event clientEvent, now time.Time) error {
+	if !clientEventType.MatchString(event.Type) {
+		return fmt.Errorf("type must be lowercase letters, digits, '_' and '.', starting with a letter")
+	}
+	timestamp, err := time.Parse(time.RFC3339, event.Timestamp)
+	if err != nil {
+		return fmt.Errorf("timestamp must be an RFC 3339 time")
+	}
+	if timestamp.Before(now.Add(-clientEventMaxAge)) || timestamp.After(now.Add(clientEventMaxSkew)) {
+		return fmt.Errorf("timestamp is out of range")
+	}
+	if len(event.SessionID) > clientEventMaxSessionID {
+		return fmt.Errorf("session_id is longer than %d characters", clientEventMaxSessionID)
+	}
+	if len(event.Properties) > 0 && event.Properties[0] != '{' {
+		return fmt.Errorf("properties must be an object")
+	}
+	return nil
+}
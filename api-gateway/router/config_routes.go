@@ -0,0 +1,98 @@
+package router
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/YeonwooSung/instagram/api-gateway/config"
+	"github.com/YeonwooSung/instagram/api-gateway/middleware"
+	"github.com/YeonwooSung/instagram/api-gateway/proxy"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// setupConfigRoutes registers proxied routes declared in the config file on r.
+// A route conflicting with one already registered is a config error.
+func setupConfigRoutes(
+	r gin.IRoutes,
+	routes []config.Route,
+	cfg *config.Config,
+	logger *zap.Logger,
+	rateLimiter *middleware.RateLimiter,
+	proxyHandler *proxy.ProxyHandler,
+	deduplicator *middleware.Deduplicator,
+	responseCache *middleware.ResponseCache,
+	chains *middlewareRegistry,
+) error {
+	upstreams := cfg.ServiceURLs()
+
+	for _, route := range routes {
+		limiter := rateLimiter
+		if route.RateLimit != "" {
+			limiter = chains.policyLimiters[route.RateLimit]
+		}
+
+		handlers := []gin.HandlerFunc{limiter.RateLimit()}
+		handlers = append(handlers, chains.chain(route.Chain)...)
+		if route.Timeout > 0 {
+			handlers = append(handlers, middleware.Timeout(route.Timeout.Std()))
+		}
+		if route.DedupWindow > 0 {
+			handlers = append(handlers, deduplicator.Dedup(route.DedupWindow.Std()))
+		}
+		if route.CacheTTL > 0 {
+			handlers = append(handlers, responseCache.Cache(middleware.CacheOptions{
+				TTL:     route.CacheTTL.Std(),
+				PerUser: route.CachePerUser,
+			}))
+		}
+		if route.UpstreamPath != "" {
+			handlers = append(handlers, rewritePath(route.UpstreamPath))
+		}
+		handlers = append(handlers, proxyHandler.ProxyRequest(upstreams[route.Upstream]))
+
+		if err := handle(r, strings.ToUpper(route.Method), route.Path, handlers...); err != nil {
+			return fmt.Errorf("config route: %w", err)
+		}
+
+		logger.Info("Registered config route",
+			zap.String("method", route.Method),
+			zap.String("path", route.Path),
+			zap.String("upstream", route.Upstream),
+		)
+	}
+	return nil
+}
+
+// handle registers a route on r. gin panics on a method and path that
+// conflict with a registered route, e.g. a built-in one; that is returned as
+// an error instead.
+func handle(r gin.IRoutes, method, path string, handlers ...gin.HandlerFunc) (err error) {
+	defer func() {
+		if conflict := recover(); conflict != nil {
+			err = fmt.Errorf("%s %s: %v", method, path, conflict)
+		}
+	}()
+	r.Handle(method, path, handlers...)
+	return nil
+}
+
+// rewritePath returns a middleware that replaces the request path with
+// template before proxying. ":name" and "*name" segments are filled in from
+// the route's parameters, so with template "/v2/posts/:id" the route
+// "/api/v2/posts/:id" forwards "/api/v2/posts/42" as "/v2/posts/42".
+func rewritePath(template string) gin.HandlerFunc {
+	segments := strings.Split(template, "/")
+	return func(c *gin.Context) {
+		path := make([]string, len(segments))
+		for i, segment := range segments {
+			if len(segment) > 1 && (segment[0] == ':' || segment[0] == '*') {
+				segment = strings.TrimPrefix(c.Param(segment[1:]), "/")
+			}
+			path[i] = segment
+		}
+		c.Request.URL.Path = strings.Join(path, "/")
+		c.Request.URL.RawPath = ""
+		c.Next()
+	}
+}
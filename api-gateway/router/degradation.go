@@ -0,0 +1,44 @@
+package router
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+
+	"github.com/YeonwooSung/instagram/api-gateway/aggregate"
+	"github.com/YeonwooSung/instagram/api-gateway/config"
+)
+
+// aggregationCalls lists the upstream calls of each aggregation endpoint that
+// the degradation matrix may reference
+var aggregationCalls = map[string][]string{
+	"insights":    {"post_stats", "posts", "graph_stats", "feed_stats"},
+	"home":        {"profile", "feed", "graph_stats", "notification_counts"},
+	"post_detail": {"post", "comments", "like_count", "author"},
+}
+
+// degradationPolicies converts the configured degradation matrix into
+// aggregation policies, rejecting unknown endpoints and calls
+func degradationPolicies(matrix map[string]map[string]config.DegradationBranch) (map[string]aggregate.Policy, error) {
+	policies := make(map[string]aggregate.Policy, len(matrix))
+	for endpoint, branches := range matrix {
+		calls, ok := aggregationCalls[endpoint]
+		if !ok {
+			return nil, fmt.Errorf("degradation: unknown aggregation endpoint %q", endpoint)
+		}
+
+		policy := make(aggregate.Policy, len(branches))
+		for name, branch := range branches {
+			if !slices.Contains(calls, name) {
+				return nil, fmt.Errorf("degradation %s: unknown call %q", endpoint, name)
+			}
+			var fallback json.RawMessage
+			if branch.Fallback != nil {
+				fallback, _ = json.Marshal(branch.Fallback)
+			}
+			policy[name] = aggregate.Branch{Required: branch.Required, Fallback: fallback}
+		}
+		policies[endpoint] = policy
+	}
+	return policies, nil
+}
@@ -0,0 +1,207 @@
+package router
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/YeonwooSung/instagram/api-gateway/config"
+	"github.com/YeonwooSung/instagram/api-gateway/metrics"
+	"github.com/YeonwooSung/instagram/api-gateway/proxy"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+// fcmToken matches Firebase registration tokens
+var fcmToken = regexp.MustCompile(`^[A-Za-z0-9_:-]+$`)
+
+// deviceReservationPrefix prefixes the sorted set of each user's tokens being
+// registered, scored by when their reservation expires
+const deviceReservationPrefix = "gateway:devices:pending:"
+
+// deviceReservationTTL is how long a registered token keeps its reservation,
+// long enough for it to show up in the device list of any registration that
+// raced it
+const deviceReservationTTL = time.Minute
+
+// reserveDevice counts the listed tokens (ARGV[5..]) and the user's other
+// live reservations against the limit (ARGV[3]) and reserves a slot for the
+// token (ARGV[4]) for ARGV[2] milliseconds if one is free, so concurrent
+// registrations cannot both take the last slot. ARGV[1] is the current time
+// in milliseconds.
+var reserveDevice = redis.NewScript(`
+local now = tonumber(ARGV[1])
+redis.call("ZREMRANGEBYSCORE", KEYS[1], "-inf", now)
+local listed = {}
+for i = 5, #ARGV do
+	listed[ARGV[i]] = true
+end
+local count = #ARGV - 4
+for _, token in ipairs(redis.call("ZRANGE", KEYS[1], 0, -1)) do
+	if not listed[token] and token ~= ARGV[4] then
+		count = count + 1
+	end
+end
+if count >= tonumber(ARGV[3]) then
+	return 0
+end
+redis.call("ZADD", KEYS[1], now + tonumber(ARGV[2]), ARGV[4])
+redis.call("PEXPIRE", KEYS[1], ARGV[2])
+return 1`)
+
+// devices registers push tokens with the notification service. Malformed
+// tokens would only fail later, at send time, and every registered device is
+// pushed to, so the gateway checks the token format and caps how many devices
+// a user has before the service stores one.
+type devices struct {
+	cfg    *config.Config
+	proxy  *proxy.ProxyHandler
+	redis  *redis.Client
+	logger *zap.Logger
+}
+
+// deviceRegistration is a push token a client registers
+type deviceRegistration struct {
+	Platform string `json:"platform"`
+	Token    string `json:"token"`
+	AppID    string `json:"app_id,omitempty"`
+}
+
+// register validates the token, checks the user's device count, and passes
+// the registration to the notification service. Registering a token the user
+// already has refreshes it and doesn't count against the limit.
+func (h *devices) register(c *gin.Context) {
+	userID, ok := bffUserID(c)
+	if !ok {
+		return
+	}
+
+	var req deviceRegistration
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid device registration"})
+		return
+	}
+	req.Platform = strings.ToLower(req.Platform)
+	if err := validPushToken(req.Platform, req.Token); err != nil {
+		h.reject(c, "token", http.StatusBadRequest, err.Error())
+		return
+	}
+	if req.Platform == "apns" {
+		req.Token = strings.ToLower(req.Token)
+	}
+
+	header := http.Header{}
+	header.Set("X-User-ID", userID)
+	header.Set("X-Calling-Service", "gateway")
+
+	status, resp, err := h.proxy.Send(c.Request.Context(), http.MethodGet, h.cfg.NotificationServiceURL, "/api/v1/devices", header, nil)
+	if err != nil || status != http.StatusOK {
+		h.logger.Warn("Failed to list devices", zap.Error(err), zap.Int("status", status))
+		metrics.Inc("gateway_device_registrations_total", "result", "unavailable")
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to register device"})
+		return
+	}
+	tokens, ok := registeredTokens(resp)
+	if !ok {
+		h.logger.Warn("Notification service returned an unreadable device list")
+		metrics.Inc("gateway_device_registrations_total", "result", "unavailable")
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to register device"})
+		return
+	}
+	if !tokens[req.Token] {
+		if !h.reserve(c.Request.Context(), userID, req.Token, tokens) {
+			h.reject(c, "limit", http.StatusConflict, fmt.Sprintf("At most %d devices can be registered; unregister one first", h.cfg.MaxDevicesPerUser))
+			return
+		}
+	}
+
+	body, _ := json.Marshal(req)
+	header.Set("Content-Type", "application/json")
+	status, resp, err = h.proxy.Send(c.Request.Context(), http.MethodPost, h.cfg.NotificationServiceURL, "/api/v1/devices", header, body)
+	if (err != nil || status >= http.StatusMultipleChoices) && !tokens[req.Token] {
+		h.redis.ZRem(context.Background(), deviceReservationPrefix+userID, req.Token)
+	}
+	if err != nil {
+		h.logger.Warn("Failed to register device", zap.Error(err))
+		metrics.Inc("gateway_device_registrations_total", "result", "unavailable")
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to register device"})
+		return
+	}
+	if status < http.StatusMultipleChoices {
+		metrics.Inc("gateway_device_registrations_total", "result", "registered")
+	} else {
+		metrics.Inc("gateway_device_registrations_total", "result", "refused")
+	}
+	c.Data(status, "application/json; charset=utf-8", resp)
+}
+
+// reserve takes a device slot for token if the user has one free, counting
+// the listed tokens and registrations still in flight. Without Redis only the
+// listed tokens are counted.
+func (h *devices) reserve(ctx context.Context, userID, token string, listed map[string]bool) bool {
+	args := []interface{}{time.Now().UnixMilli(), deviceReservationTTL.Milliseconds(), h.cfg.MaxDevicesPerUser, token}
+	for t := range listed {
+		args = append(args, t)
+	}
+	reserved, err := reserveDevice.Run(ctx, h.redis, []string{deviceReservationPrefix + userID}, args...).Int()
+	if err != nil {
+		h.logger.Warn("Failed to reserve a device slot", zap.Error(err))
+		return len(listed) < h.cfg.MaxDevicesPerUser
+	}
+	return reserved == 1
+}
+
+func (h *devices) reject(c *gin.Context, reason string, status int, message string) {
+	metrics.Inc("gateway_device_registrations_total", "result", reason)
+	c.JSON(status, gin.H{"error": message})
+}
+
+// validPushToken checks a token has the shape its platform issues: APNs
+// device tokens are hex (32 bytes today, longer allowed), FCM tokens are
+// URL-safe strings
+func validPushToken(platform, token string) error {
+	switch platform {
+	case "apns":
+		raw, err := hex.DecodeString(token)
+		if err != nil || len(raw) < 32 || len(raw) > 100 {
+			return fmt.Errorf("token is not a valid APNs device token")
+		}
+	case "fcm":
+		if len(token) < 32 || len(token) > 4096 || !fcmToken.MatchString(token) {
+			return fmt.Errorf("token is not a valid FCM registration token")
+		}
+	default:
+		return fmt.Errorf("platform must be apns or fcm")
+	}
+	return nil
+}
+
+// registeredTokens reads the tokens from the notification service's device
+// list, given either as an array or as a "devices" field
+func registeredTokens(resp []byte) (map[string]bool, bool) {
+	type device struct {
+		Token string `json:"token"`
+	}
+	var list []device
+	if err := json.Unmarshal(resp, &list); err != nil {
+		var wrapped struct {
+			Devices []device `json:"devices"`
+		}
+		if err := json.Unmarshal(resp, &wrapped); err != nil {
+			return nil, false
+		}
+		list = wrapped.Devices
+	}
+
+	tokens := make(map[string]bool, len(list))
+	for _, d := range list {
+		tokens[d.Token] = true
+	}
+	return tokens, true
+}
@@ -0,0 +1,230 @@
+package router
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/YeonwooSung/instagram/api-gateway/proxy"
+	"github.com/YeonwooSung/instagram/api-gateway/state"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+const (
+	dynamicDiffsKey = "gateway:diffs"
+
+	// Results are aggregated across replicas under these per-upstream keys
+	diffStatsKeyPrefix      = "gateway:diffs:stats:"
+	diffMismatchesKeyPrefix = "gateway:diffs:mismatches:"
+
+	// diffMaxInflight caps concurrent candidate requests per replica
+	diffMaxInflight = 50
+
+	// diffRecentMismatches is how many mismatch samples are kept per upstream
+	diffRecentMismatches = 20
+)
+
+// comparisonSchema versions proxy.Comparison as stored in Redis
+var comparisonSchema = state.NewSchema("comparison", 1)
+
+// diffMismatch is a recorded sample of a mismatching response
+type diffMismatch struct {
+	Route       string    `json:"route"`
+	Path        string    `json:"path"`
+	Differences []string  `json:"differences"`
+	At          time.Time `json:"at"`
+}
+
+// loadComparisons reads response comparisons (keyed by upstream name) from
+// Redis and hands them to the proxy keyed by the upstream's base URL
+func (d *dynamicRoutes) loadComparisons(ctx context.Context) error {
+	raw, err := d.redis.HGetAll(ctx, dynamicDiffsKey).Result()
+	if err != nil {
+		return err
+	}
+
+	comparisons := make(map[string]proxy.Comparison, len(raw))
+	names := make(map[string]string, len(raw))
+	for name, data := range raw {
+		var comparison proxy.Comparison
+		if err := comparisonSchema.Unmarshal([]byte(data), &comparison); err != nil {
+			d.logger.Warn("Skipping invalid comparison", zap.String("upstream", name), zap.Error(err))
+			continue
+		}
+		upstreamURL, ok := d.upstreamURL(name)
+		if !ok {
+			d.logger.Warn("Skipping comparison for unknown upstream", zap.String("upstream", name))
+			continue
+		}
+		comparisons[upstreamURL] = comparison
+		names[upstreamURL] = name
+	}
+
+	d.proxy.SetComparisons(comparisons, func(upstream string, result proxy.DiffResult) {
+		d.recordDiff(names[upstream], result)
+	}, diffMaxInflight)
+	return nil
+}
+
+// recordDiff adds a comparison result to the upstream's shared counters and
+// keeps a sample of recent mismatches
+func (d *dynamicRoutes) recordDiff(name string, result proxy.DiffResult) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	pipe := d.redis.TxPipeline()
+	pipe.HIncrBy(ctx, diffStatsKeyPrefix+name, result.Result, 1)
+	if result.Result == "mismatch" {
+		sample, _ := json.Marshal(diffMismatch{
+			Route:       result.Route,
+			Path:        result.Path,
+			Differences: result.Differences,
+			At:          time.Now().UTC(),
+		})
+		pipe.LPush(ctx, diffMismatchesKeyPrefix+name, sample)
+		pipe.LTrim(ctx, diffMismatchesKeyPrefix+name, 0, diffRecentMismatches-1)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		d.logger.Debug("Failed to record comparison result", zap.String("upstream", name), zap.Error(err))
+	}
+}
+
+// listDiffs reports every running comparison with its mismatch rate and
+// recent mismatching requests
+func (d *dynamicRoutes) listDiffs(c *gin.Context) {
+	ctx := c.Request.Context()
+	raw, err := d.redis.HGetAll(ctx, dynamicDiffsKey).Result()
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Route store unavailable"})
+		return
+	}
+
+	diffs := make(map[string]gin.H, len(raw))
+	for name, data := range raw {
+		var comparison proxy.Comparison
+		if comparisonSchema.Unmarshal([]byte(data), &comparison) != nil {
+			continue
+		}
+
+		counts, err := d.redis.HGetAll(ctx, diffStatsKeyPrefix+name).Result()
+		if err != nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Route store unavailable"})
+			return
+		}
+		samples, err := d.redis.LRange(ctx, diffMismatchesKeyPrefix+name, 0, -1).Result()
+		if err != nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Route store unavailable"})
+			return
+		}
+
+		matched, _ := strconv.ParseInt(counts["match"], 10, 64)
+		mismatched, _ := strconv.ParseInt(counts["mismatch"], 10, 64)
+		failed, _ := strconv.ParseInt(counts["error"], 10, 64)
+		rate := 0.0
+		if compared := matched + mismatched; compared > 0 {
+			rate = float64(mismatched) / float64(compared)
+		}
+
+		mismatches := make([]diffMismatch, 0, len(samples))
+		for _, sample := range samples {
+			var mismatch diffMismatch
+			if json.Unmarshal([]byte(sample), &mismatch) == nil {
+				mismatches = append(mismatches, mismatch)
+			}
+		}
+
+		diffs[name] = gin.H{
+			"comparison":    comparison,
+			"matched":       matched,
+			"mismatched":    mismatched,
+			"errors":        failed,
+			"mismatch_rate": rate,
+			"mismatches":    mismatches,
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"diffs": diffs})
+}
+
+// putDiff starts comparing a sample of the upstream's GET responses with a
+// candidate upstream. Results of any earlier comparison are reset.
+func (d *dynamicRoutes) putDiff(c *gin.Context) {
+	name := c.Param("upstream")
+	if _, ok := d.upstreamURL(name); !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Unknown upstream"})
+		return
+	}
+
+	var comparison proxy.Comparison
+	if err := c.ShouldBindJSON(&comparison); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid comparison definition"})
+		return
+	}
+	u, err := url.Parse(comparison.Candidate)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid candidate URL"})
+		return
+	}
+	if comparison.Percent <= 0 || comparison.Percent > 100 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Percent must be in (0, 100]"})
+		return
+	}
+	for _, path := range comparison.Paths {
+		if !strings.HasPrefix(path, "/") {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Paths must start with /"})
+			return
+		}
+	}
+	for _, field := range comparison.Ignore {
+		if field == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Ignored fields must not be empty"})
+			return
+		}
+	}
+
+	ctx := c.Request.Context()
+	data, _ := comparisonSchema.Marshal(comparison)
+	_, err = d.redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, diffStatsKeyPrefix+name, diffMismatchesKeyPrefix+name)
+		pipe.HSet(ctx, dynamicDiffsKey, name, data)
+		return nil
+	})
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Route store unavailable"})
+		return
+	}
+	d.notify(ctx)
+
+	d.logger.Info("Response comparison started",
+		zap.String("upstream", name),
+		zap.String("candidate", comparison.Candidate),
+		zap.Float64("percent", comparison.Percent),
+	)
+	c.JSON(http.StatusOK, gin.H{"upstream": name, "comparison": comparison})
+}
+
+// deleteDiff stops a comparison and discards its results
+func (d *dynamicRoutes) deleteDiff(c *gin.Context) {
+	ctx := c.Request.Context()
+	name := c.Param("upstream")
+
+	removed, err := d.redis.HDel(ctx, dynamicDiffsKey, name).Result()
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Route store unavailable"})
+		return
+	}
+	if removed == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Comparison not found"})
+		return
+	}
+	d.redis.Del(ctx, diffStatsKeyPrefix+name, diffMismatchesKeyPrefix+name)
+	d.notify(ctx)
+
+	d.logger.Info("Response comparison stopped", zap.String("upstream", name))
+	c.Status(http.StatusNoContent)
+}
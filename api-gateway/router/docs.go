@@ -0,0 +1,293 @@
+package router
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/YeonwooSung/instagram/api-gateway/config"
+	"github.com/YeonwooSung/instagram/api-gateway/middleware"
+	"github.com/YeonwooSung/instagram/api-gateway/proxy"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// swaggerUIVersion pins the Swagger UI bundle loaded by /api/docs
+const swaggerUIVersion = "5.17.14"
+
+// swaggerUIPage renders Swagger UI against the merged document
+var swaggerUIPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <title>Instagram API</title>
+  <link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@` + swaggerUIVersion + `/swagger-ui.css">
+</head>
+<body>
+  <div id="swagger-ui"></div>
+  <script src="https://unpkg.com/swagger-ui-dist@` + swaggerUIVersion + `/swagger-ui-bundle.js" crossorigin></script>
+  <script>
+    window.onload = () => {
+      window.ui = SwaggerUIBundle({url: "/api/docs/openapi.json", dom_id: "#swagger-ui"});
+    };
+  </script>
+</body>
+</html>
+`
+
+// setupDocsRoutes serves Swagger UI at /api/docs over one OpenAPI document
+// merged from every upstream's spec and the gateway's error catalog
+func setupDocsRoutes(r *gin.Engine, cfg *config.Config, catalog []middleware.ErrorCatalogEntry, proxyHandler *proxy.ProxyHandler, logger *zap.Logger) {
+	if cfg.DocsSpecPath == "" {
+		return
+	}
+
+	docs := &apiDocs{
+		upstreams: cfg.ServiceURLs(),
+		specPath:  cfg.DocsSpecPath,
+		ttl:       cfg.DocsCacheTTL,
+		catalog:   catalog,
+		proxy:     proxyHandler,
+		logger:    logger,
+	}
+	r.GET("/api/docs", func(c *gin.Context) {
+		c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(swaggerUIPage))
+	})
+	r.GET("/api/docs/openapi.json", docs.handler)
+}
+
+// apiDocs caches the merged OpenAPI document. Upstreams whose spec can't be
+// fetched are left out and listed under x-unavailable-services.
+type apiDocs struct {
+	upstreams map[string]string
+	specPath  string
+	ttl       time.Duration
+	catalog   []middleware.ErrorCatalogEntry
+	proxy     *proxy.ProxyHandler
+	logger    *zap.Logger
+
+	mu        sync.Mutex
+	body      []byte
+	fetchedAt time.Time
+}
+
+func (d *apiDocs) handler(c *gin.Context) {
+	c.Header("Cache-Control", fmt.Sprintf("public, max-age=%d", int(d.ttl.Seconds())))
+	c.Data(http.StatusOK, "application/json", d.get(c.Request.Context()))
+}
+
+func (d *apiDocs) get(ctx context.Context) []byte {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.body != nil && time.Since(d.fetchedAt) < d.ttl {
+		return d.body
+	}
+
+	merged := d.merge(ctx)
+	body, err := json.Marshal(merged)
+	if err != nil {
+		d.logger.Warn("Failed to encode merged API docs", zap.Error(err))
+		return d.body
+	}
+	d.body, d.fetchedAt = body, time.Now()
+	return body
+}
+
+// merge fetches every upstream's spec and combines them into one document.
+// Paths are prefixed with the spec's server base path, operations without
+// tags are tagged with the service name, and components are namespaced by
+// service so identically named schemas don't collide.
+func (d *apiDocs) merge(ctx context.Context) map[string]interface{} {
+	names := make([]string, 0, len(d.upstreams))
+	for name := range d.upstreams {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	// Several services may share one upstream URL; fetch each URL once
+	specs := make(map[string]map[string]interface{})
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, name := range names {
+		baseURL := d.upstreams[name]
+		if _, seen := specs[baseURL]; seen {
+			continue
+		}
+		specs[baseURL] = nil
+		wg.Add(1)
+		go func(name, baseURL string) {
+			defer wg.Done()
+			spec, err := d.fetch(ctx, baseURL)
+			if err != nil {
+				d.logger.Warn("Failed to fetch API docs", zap.String("service", name), zap.Error(err))
+				return
+			}
+			mu.Lock()
+			specs[baseURL] = spec
+			mu.Unlock()
+		}(name, baseURL)
+	}
+	wg.Wait()
+
+	paths := make(map[string]interface{})
+	components := make(map[string]map[string]interface{})
+	tags := []interface{}{}
+	unavailable := []string{}
+	merged := make(map[string]string)
+
+	for _, name := range names {
+		baseURL := d.upstreams[name]
+		spec := specs[baseURL]
+		if spec == nil {
+			unavailable = append(unavailable, name)
+			continue
+		}
+		if _, done := merged[baseURL]; done {
+			continue
+		}
+		merged[baseURL] = name
+
+		spec = namespaceRefs(spec, name).(map[string]interface{})
+		if kinds, ok := spec["components"].(map[string]interface{}); ok {
+			for kind, entries := range kinds {
+				entries, ok := entries.(map[string]interface{})
+				if !ok {
+					continue
+				}
+				if components[kind] == nil {
+					components[kind] = make(map[string]interface{})
+				}
+				for entry, value := range entries {
+					components[kind][name+"."+entry] = value
+				}
+			}
+		}
+
+		prefix := serverBasePath(spec)
+		specPaths, _ := spec["paths"].(map[string]interface{})
+		for path, item := range specPaths {
+			if operations, ok := item.(map[string]interface{}); ok {
+				for _, op := range operations {
+					if op, ok := op.(map[string]interface{}); ok && op["tags"] == nil {
+						op["tags"] = []interface{}{name}
+					}
+				}
+			}
+			paths[prefix+path] = item
+		}
+		tags = append(tags, map[string]interface{}{"name": name})
+	}
+
+	doc := map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "Instagram API",
+			"version": "1.0.0",
+		},
+		"paths":                  paths,
+		"tags":                   tags,
+		"x-unavailable-services": unavailable,
+	}
+	if len(d.catalog) > 0 {
+		if components["schemas"] == nil {
+			components["schemas"] = make(map[string]interface{})
+		}
+		components["schemas"]["gateway.Problem"] = problemSchema(d.catalog)
+		doc["x-error-catalog"] = d.catalog
+	}
+	if len(components) > 0 {
+		doc["components"] = components
+	}
+	return doc
+}
+
+// problemSchema describes the gateway's problem details responses, with the
+// catalog's codes as the code enum
+func problemSchema(catalog []middleware.ErrorCatalogEntry) map[string]interface{} {
+	var codes []interface{}
+	seen := make(map[string]bool)
+	for _, entry := range catalog {
+		if !seen[entry.Code] {
+			seen[entry.Code] = true
+			codes = append(codes, entry.Code)
+		}
+	}
+	str := map[string]interface{}{"type": "string"}
+	return map[string]interface{}{
+		"type":     "object",
+		"required": []interface{}{"type", "title", "status", "code"},
+		"properties": map[string]interface{}{
+			"type":       str,
+			"title":      str,
+			"status":     map[string]interface{}{"type": "integer"},
+			"detail":     str,
+			"instance":   str,
+			"code":       map[string]interface{}{"type": "string", "enum": codes, "description": "See x-error-catalog"},
+			"request_id": str,
+			"errors":     map[string]interface{}{},
+			"error":      map[string]interface{}{"type": "string", "deprecated": true},
+		},
+	}
+}
+
+// fetch retrieves and decodes one upstream's OpenAPI document
+func (d *apiDocs) fetch(ctx context.Context, baseURL string) (map[string]interface{}, error) {
+	status, body, err := d.proxy.Fetch(ctx, baseURL, d.specPath, http.Header{"Accept": {"application/json"}})
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("upstream returned %d", status)
+	}
+	var spec map[string]interface{}
+	if err := json.Unmarshal(body, &spec); err != nil {
+		return nil, err
+	}
+	return spec, nil
+}
+
+// serverBasePath returns the path of the spec's first server URL, which the
+// gateway serves the spec's paths under
+func serverBasePath(spec map[string]interface{}) string {
+	servers, _ := spec["servers"].([]interface{})
+	if len(servers) == 0 {
+		return ""
+	}
+	server, _ := servers[0].(map[string]interface{})
+	raw, _ := server["url"].(string)
+	u, err := url.Parse(raw)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSuffix(u.Path, "/")
+}
+
+// namespaceRefs rewrites "#/components/<kind>/<name>" references to the
+// service-namespaced component names
+func namespaceRefs(value interface{}, service string) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			if ref, ok := child.(string); ok && key == "$ref" && strings.HasPrefix(ref, "#/components/") {
+				parts := strings.SplitN(strings.TrimPrefix(ref, "#/components/"), "/", 2)
+				if len(parts) == 2 {
+					v[key] = "#/components/" + parts[0] + "/" + service + "." + parts[1]
+				}
+				continue
+			}
+			v[key] = namespaceRefs(child, service)
+		}
+	case []interface{}:
+		for i, child := range v {
+			v[i] = namespaceRefs(child, service)
+		}
+	}
+	return value
+}
@@ -0,0 +1,438 @@
+package router
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/YeonwooSung/instagram/api-gateway/config"
+	"github.com/YeonwooSung/instagram/api-gateway/middleware"
+	"github.com/YeonwooSung/instagram/api-gateway/proxy"
+	"github.com/YeonwooSung/instagram/api-gateway/state"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+const (
+	dynamicRoutesKey    = "gateway:routes"
+	dynamicUpstreamsKey = "gateway:upstreams"
+	dynamicChannel      = "gateway:routes:changed"
+
+	// dynamicReloadInterval bounds staleness if a change notification is missed
+	dynamicReloadInterval = 30 * time.Second
+)
+
+// dynamicRouteSchema versions DynamicRoute as stored in Redis
+var dynamicRouteSchema = state.NewSchema("dynamic_route", 1)
+
+// DynamicRoute is a proxied route managed at runtime through the admin API.
+// Path segments starting with ":" match any single segment and a trailing
+// "/*" matches any remainder. An empty method or "*" matches every method.
+type DynamicRoute struct {
+	ID       string `json:"id"`
+	Method   string `json:"method"`
+	Path     string `json:"path"`
+	Upstream string `json:"upstream"`
+}
+
+// dynamicRoutes serves routes registered at runtime. Gin cannot remove routes,
+// so they are matched in the NoRoute handler after static routes. The table is
+// persisted in Redis and kept in sync across replicas via pub/sub.
+type dynamicRoutes struct {
+	redis   *redis.Client
+	static  map[string]string
+	proxy   *proxy.ProxyHandler
+	limiter *middleware.RateLimiter
+	logger  *zap.Logger
+
+	experiments *middleware.Experiments
+	flags       *middleware.Flags
+
+	mu        sync.RWMutex
+	routes    []DynamicRoute
+	upstreams map[string]string
+}
+
+func newDynamicRoutes(
+	redisClient *redis.Client,
+	static map[string]string,
+	proxyHandler *proxy.ProxyHandler,
+	limiter *middleware.RateLimiter,
+	experiments *middleware.Experiments,
+	flags *middleware.Flags,
+	logger *zap.Logger,
+) *dynamicRoutes {
+	return &dynamicRoutes{
+		redis:       redisClient,
+		static:      static,
+		proxy:       proxyHandler,
+		limiter:     limiter,
+		experiments: experiments,
+		flags:       flags,
+		logger:      logger,
+		upstreams:   make(map[string]string),
+	}
+}
+
+// load replaces the in-memory table with the routes and upstreams stored in Redis
+func (d *dynamicRoutes) load(ctx context.Context) error {
+	rawRoutes, err := d.redis.HGetAll(ctx, dynamicRoutesKey).Result()
+	if err != nil {
+		return err
+	}
+	upstreams, err := d.redis.HGetAll(ctx, dynamicUpstreamsKey).Result()
+	if err != nil {
+		return err
+	}
+
+	routes := make([]DynamicRoute, 0, len(rawRoutes))
+	for id, raw := range rawRoutes {
+		var route DynamicRoute
+		if err := dynamicRouteSchema.Unmarshal([]byte(raw), &route); err != nil {
+			d.logger.Warn("Skipping invalid dynamic route", zap.String("id", id), zap.Error(err))
+			continue
+		}
+		routes = append(routes, route)
+	}
+
+	// Most specific (longest) patterns first
+	sort.Slice(routes, func(i, j int) bool {
+		if len(routes[i].Path) != len(routes[j].Path) {
+			return len(routes[i].Path) > len(routes[j].Path)
+		}
+		return routes[i].ID < routes[j].ID
+	})
+
+	d.mu.Lock()
+	d.routes = routes
+	d.upstreams = upstreams
+	d.mu.Unlock()
+
+	if err := d.loadCanaries(ctx); err != nil {
+		return err
+	}
+	if err := d.loadComparisons(ctx); err != nil {
+		return err
+	}
+	if err := d.loadExperiments(ctx); err != nil {
+		return err
+	}
+	return d.loadFlags(ctx)
+}
+
+// sync keeps the table current until ctx is cancelled
+func (d *dynamicRoutes) sync(ctx context.Context) {
+	if err := d.load(ctx); err != nil {
+		d.logger.Warn("Failed to load dynamic routes", zap.Error(err))
+	}
+
+	sub := d.redis.Subscribe(ctx, dynamicChannel)
+	defer sub.Close()
+
+	ticker := time.NewTicker(dynamicReloadInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-sub.Channel():
+		case <-ticker.C:
+		}
+
+		if err := d.load(ctx); err != nil {
+			d.logger.Warn("Failed to reload dynamic routes", zap.Error(err))
+		}
+	}
+}
+
+// notify tells every replica (including this one) to reload the table
+func (d *dynamicRoutes) notify(ctx context.Context) {
+	if err := d.redis.Publish(ctx, dynamicChannel, "changed").Err(); err != nil {
+		d.logger.Warn("Failed to publish route change", zap.Error(err))
+	}
+	if err := d.load(ctx); err != nil {
+		d.logger.Warn("Failed to reload dynamic routes", zap.Error(err))
+	}
+}
+
+// upstreamURL resolves an upstream name against dynamic then static upstreams
+func (d *dynamicRoutes) upstreamURL(name string) (string, bool) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if u, ok := d.upstreams[name]; ok {
+		return u, true
+	}
+	u, ok := d.static[name]
+	return u, ok
+}
+
+// match returns the upstream URL of the first dynamic route matching the request
+func (d *dynamicRoutes) match(method, path string) (string, bool) {
+	d.mu.RLock()
+	var matched *DynamicRoute
+	for i := range d.routes {
+		route := &d.routes[i]
+		if route.Method != "" && route.Method != "*" && route.Method != method {
+			continue
+		}
+		if matchPath(route.Path, path) {
+			matched = route
+			break
+		}
+	}
+	d.mu.RUnlock()
+
+	if matched == nil {
+		return "", false
+	}
+	return d.upstreamURL(matched.Upstream)
+}
+
+// dispatch proxies requests matching a dynamic route and aborts; otherwise it
+// passes control to the next NoRoute handler
+func (d *dynamicRoutes) dispatch() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		upstreamURL, ok := d.match(c.Request.Method, c.Request.URL.Path)
+		if !ok {
+			c.Next()
+			return
+		}
+
+		if !d.limiter.Allow(d.limiter.ClientKey(c)) {
+			c.JSON(http.StatusTooManyRequests, gin.H{
+				"error": "Rate limit exceeded",
+			})
+			c.Abort()
+			return
+		}
+
+		d.proxy.ProxyRequest(upstreamURL)(c)
+		c.Abort()
+	}
+}
+
+// matchPath matches path against a pattern with ":param" and trailing "*" segments
+func matchPath(pattern, path string) bool {
+	patternSegs := strings.Split(strings.Trim(pattern, "/"), "/")
+	pathSegs := strings.Split(strings.Trim(path, "/"), "/")
+
+	for i, seg := range patternSegs {
+		if seg == "*" && i == len(patternSegs)-1 {
+			return true
+		}
+		if i >= len(pathSegs) {
+			return false
+		}
+		if strings.HasPrefix(seg, ":") {
+			continue
+		}
+		if seg != pathSegs[i] {
+			return false
+		}
+	}
+	return len(patternSegs) == len(pathSegs)
+}
+
+// ==================== Admin handlers ====================
+
+func (d *dynamicRoutes) registerAdmin(admin *gin.RouterGroup) {
+	admin.GET("/routes", d.listRoutes)
+	admin.POST("/routes", d.createRoute)
+	admin.PUT("/routes/:id", d.updateRoute)
+	admin.DELETE("/routes/:id", d.deleteRoute)
+
+	admin.GET("/upstreams", d.listUpstreams)
+	admin.PUT("/upstreams/:name", d.putUpstream)
+	admin.DELETE("/upstreams/:name", d.deleteUpstream)
+
+	admin.GET("/canaries", d.listCanaries)
+	admin.PUT("/canaries/:upstream", d.putCanary)
+	admin.DELETE("/canaries/:upstream", d.deleteCanary)
+
+	admin.GET("/diffs", d.listDiffs)
+	admin.PUT("/diffs/:upstream", d.putDiff)
+	admin.DELETE("/diffs/:upstream", d.deleteDiff)
+
+	admin.GET("/experiments", d.listExperiments)
+	admin.PUT("/experiments/:name", d.putExperiment)
+	admin.DELETE("/experiments/:name", d.deleteExperiment)
+
+	admin.GET("/flags", d.listFlags)
+	admin.PUT("/flags/:name", d.putFlag)
+	admin.DELETE("/flags/:name", d.deleteFlag)
+}
+
+func (d *dynamicRoutes) listRoutes(c *gin.Context) {
+	d.mu.RLock()
+	routes := append([]DynamicRoute(nil), d.routes...)
+	d.mu.RUnlock()
+
+	c.JSON(http.StatusOK, gin.H{"routes": routes})
+}
+
+func (d *dynamicRoutes) createRoute(c *gin.Context) {
+	var route DynamicRoute
+	if err := c.ShouldBindJSON(&route); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid route definition"})
+		return
+	}
+
+	idBytes := make([]byte, 8)
+	if _, err := rand.Read(idBytes); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate route ID"})
+		return
+	}
+	route.ID = hex.EncodeToString(idBytes)
+
+	d.saveRoute(c, route, http.StatusCreated)
+}
+
+func (d *dynamicRoutes) updateRoute(c *gin.Context) {
+	var route DynamicRoute
+	if err := c.ShouldBindJSON(&route); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid route definition"})
+		return
+	}
+	route.ID = c.Param("id")
+
+	exists, err := d.redis.HExists(c.Request.Context(), dynamicRoutesKey, route.ID).Result()
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Route store unavailable"})
+		return
+	}
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Route not found"})
+		return
+	}
+
+	d.saveRoute(c, route, http.StatusOK)
+}
+
+func (d *dynamicRoutes) saveRoute(c *gin.Context, route DynamicRoute, status int) {
+	route.Method = strings.ToUpper(route.Method)
+	if route.Method != "" && route.Method != "*" && !config.IsValidMethod(route.Method) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid method"})
+		return
+	}
+	if !strings.HasPrefix(route.Path, "/") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Path must start with /"})
+		return
+	}
+	if _, ok := d.upstreamURL(route.Upstream); !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown upstream"})
+		return
+	}
+
+	data, _ := dynamicRouteSchema.Marshal(route)
+	if err := d.redis.HSet(c.Request.Context(), dynamicRoutesKey, route.ID, data).Err(); err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Route store unavailable"})
+		return
+	}
+	d.notify(c.Request.Context())
+
+	d.logger.Info("Dynamic route saved",
+		zap.String("id", route.ID),
+		zap.String("method", route.Method),
+		zap.String("path", route.Path),
+		zap.String("upstream", route.Upstream),
+	)
+	c.JSON(status, route)
+}
+
+func (d *dynamicRoutes) deleteRoute(c *gin.Context) {
+	id := c.Param("id")
+	removed, err := d.redis.HDel(c.Request.Context(), dynamicRoutesKey, id).Result()
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Route store unavailable"})
+		return
+	}
+	if removed == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Route not found"})
+		return
+	}
+	d.notify(c.Request.Context())
+
+	d.logger.Info("Dynamic route deleted", zap.String("id", id))
+	c.Status(http.StatusNoContent)
+}
+
+func (d *dynamicRoutes) listUpstreams(c *gin.Context) {
+	d.mu.RLock()
+	dynamic := make(map[string]string, len(d.upstreams))
+	for name, u := range d.upstreams {
+		dynamic[name] = u
+	}
+	d.mu.RUnlock()
+
+	c.JSON(http.StatusOK, gin.H{
+		"static":  d.static,
+		"dynamic": dynamic,
+	})
+}
+
+func (d *dynamicRoutes) putUpstream(c *gin.Context) {
+	name := c.Param("name")
+	if _, builtin := d.static[name]; builtin {
+		c.JSON(http.StatusConflict, gin.H{"error": "Static upstreams are managed by configuration"})
+		return
+	}
+
+	var body struct {
+		URL string `json:"url" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "url is required"})
+		return
+	}
+	u, err := url.Parse(body.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid upstream URL"})
+		return
+	}
+
+	if err := d.redis.HSet(c.Request.Context(), dynamicUpstreamsKey, name, body.URL).Err(); err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Route store unavailable"})
+		return
+	}
+	d.notify(c.Request.Context())
+
+	d.logger.Info("Dynamic upstream saved", zap.String("name", name), zap.String("url", body.URL))
+	c.JSON(http.StatusOK, gin.H{"name": name, "url": body.URL})
+}
+
+func (d *dynamicRoutes) deleteUpstream(c *gin.Context) {
+	name := c.Param("name")
+
+	d.mu.RLock()
+	for _, route := range d.routes {
+		if route.Upstream == name {
+			d.mu.RUnlock()
+			c.JSON(http.StatusConflict, gin.H{"error": "Upstream is referenced by route " + route.ID})
+			return
+		}
+	}
+	d.mu.RUnlock()
+
+	removed, err := d.redis.HDel(c.Request.Context(), dynamicUpstreamsKey, name).Result()
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Route store unavailable"})
+		return
+	}
+	if removed == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Upstream not found"})
+		return
+	}
+	d.notify(c.Request.Context())
+
+	d.logger.Info("Dynamic upstream deleted", zap.String("name", name))
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,35 @@
+package router
+
+import (
+	"net/http"
+
+	"github.com/YeonwooSung/instagram/api-gateway/config"
+	"github.com/YeonwooSung/instagram/api-gateway/middleware"
+	"github.com/gin-gonic/gin"
+)
+
+// errorCatalog lists the error codes of problem responses, including those of
+// the configured backend error mappings
+func errorCatalog(cfg *config.Config) []middleware.ErrorCatalogEntry {
+	opts := middleware.ProblemOptions{TypeBase: cfg.ProblemTypeBase}
+	for _, mapping := range cfg.ErrorMappings {
+		opts.Mappings = append(opts.Mappings, middleware.ErrorMapping{
+			Status: mapping.Status,
+			Code:   mapping.Code,
+			Title:  mapping.Title,
+		})
+	}
+	return opts.Catalog()
+}
+
+// serveErrorCatalog serves the error catalog for client teams to generate
+// their error handling and translations from
+func serveErrorCatalog(api *gin.RouterGroup, catalog []middleware.ErrorCatalogEntry) {
+	api.GET("/errors/catalog", func(c *gin.Context) {
+		c.Header("Cache-Control", "public, max-age=3600")
+		c.JSON(http.StatusOK, gin.H{
+			"content_type": middleware.ProblemContentType,
+			"errors":       catalog,
+		})
+	})
+}
@@ -0,0 +1,140 @@
+package router
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/YeonwooSung/instagram/api-gateway/middleware"
+	"github.com/YeonwooSung/instagram/api-gateway/state"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+const dynamicExperimentsKey = "gateway:experiments"
+
+// experimentSchema versions middleware.Experiment as stored in Redis
+var experimentSchema = state.NewSchema("experiment", 1)
+
+// experimentName keeps experiment, variant and flag names safe to carry in a header
+var experimentName = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+
+// loadExperiments reads experiment definitions from Redis into the middleware
+func (d *dynamicRoutes) loadExperiments(ctx context.Context) error {
+	raw, err := d.redis.HGetAll(ctx, dynamicExperimentsKey).Result()
+	if err != nil {
+		return err
+	}
+
+	experiments := make([]middleware.Experiment, 0, len(raw))
+	for name, data := range raw {
+		var experiment middleware.Experiment
+		if err := experimentSchema.Unmarshal([]byte(data), &experiment); err != nil {
+			d.logger.Warn("Skipping invalid experiment", zap.String("name", name), zap.Error(err))
+			continue
+		}
+		experiments = append(experiments, experiment)
+	}
+
+	d.experiments.Set(experiments)
+	return nil
+}
+
+func (d *dynamicRoutes) listExperiments(c *gin.Context) {
+	raw, err := d.redis.HGetAll(c.Request.Context(), dynamicExperimentsKey).Result()
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Route store unavailable"})
+		return
+	}
+
+	experiments := make([]middleware.Experiment, 0, len(raw))
+	for _, data := range raw {
+		var experiment middleware.Experiment
+		if experimentSchema.Unmarshal([]byte(data), &experiment) == nil {
+			experiments = append(experiments, experiment)
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"experiments": experiments})
+}
+
+// putExperiment creates or replaces an experiment. Changing variants or
+// weights moves users between buckets, so adjust running experiments with care.
+func (d *dynamicRoutes) putExperiment(c *gin.Context) {
+	var experiment middleware.Experiment
+	if err := c.ShouldBindJSON(&experiment); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid experiment definition"})
+		return
+	}
+	experiment.Name = c.Param("name")
+
+	if !experimentName.MatchString(experiment.Name) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid experiment name"})
+		return
+	}
+	if experiment.AA && len(experiment.Variants) == 0 {
+		experiment.Variants = aaVariants()
+	}
+	if len(experiment.Variants) < 2 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "At least two variants are required"})
+		return
+	}
+	seen := make(map[string]bool, len(experiment.Variants))
+	for _, variant := range experiment.Variants {
+		if !experimentName.MatchString(variant.Name) || seen[variant.Name] {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Variant names must be unique and valid"})
+			return
+		}
+		if variant.Weight <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Variant weights must be positive"})
+			return
+		}
+		seen[variant.Name] = true
+	}
+	for _, path := range experiment.Paths {
+		if !strings.HasPrefix(path, "/") {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Paths must start with /"})
+			return
+		}
+	}
+
+	data, _ := experimentSchema.Marshal(experiment)
+	if err := d.redis.HSet(c.Request.Context(), dynamicExperimentsKey, experiment.Name, data).Err(); err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Route store unavailable"})
+		return
+	}
+	d.notify(c.Request.Context())
+
+	d.logger.Info("Experiment saved",
+		zap.String("name", experiment.Name),
+		zap.Int("variants", len(experiment.Variants)),
+		zap.Bool("aa", experiment.AA),
+	)
+	c.JSON(http.StatusOK, experiment)
+}
+
+// aaVariants are the default buckets of an A/A experiment: two halves of
+// the traffic served identically
+func aaVariants() []middleware.Variant {
+	return []middleware.Variant{
+		{Name: "a1", Weight: 50},
+		{Name: "a2", Weight: 50},
+	}
+}
+
+func (d *dynamicRoutes) deleteExperiment(c *gin.Context) {
+	name := c.Param("name")
+	removed, err := d.redis.HDel(c.Request.Context(), dynamicExperimentsKey, name).Result()
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Route store unavailable"})
+		return
+	}
+	if removed == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Experiment not found"})
+		return
+	}
+	d.notify(c.Request.Context())
+
+	d.logger.Info("Experiment deleted", zap.String("name", name))
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,164 @@
+package router
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/YeonwooSung/instagram/api-gateway/config"
+	"github.com/YeonwooSung/instagram/api-gateway/metrics"
+	"github.com/YeonwooSung/instagram/api-gateway/proxy"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// exportPollInterval is how often a waiting status request re-reads the job
+const exportPollInterval = time.Second
+
+// accountExports tracks account data exports. The account service runs each
+// export job across the other services and assembles the archive; the gateway
+// lets clients wait on a job without hammering it, and hands out short-lived
+// signed download URLs so the archive can be fetched by a browser or download
+// manager that has no bearer token.
+type accountExports struct {
+	cfg    *config.Config
+	proxy  *proxy.ProxyHandler
+	logger *zap.Logger
+}
+
+// status answers with the export job's status. With ?wait=<seconds> (capped
+// at EXPORT_MAX_WAIT_SEC) it holds the request until the job is ready or
+// failed, or the wait is over. Ready jobs get a signed "download_url".
+func (h *accountExports) status(c *gin.Context) {
+	userID, ok := bffUserID(c)
+	if !ok {
+		return
+	}
+
+	var wait time.Duration
+	if raw := c.Query("wait"); raw != "" {
+		seconds, err := strconv.Atoi(raw)
+		if err != nil || seconds < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "wait must be a number of seconds"})
+			return
+		}
+		wait = min(time.Duration(seconds)*time.Second, h.cfg.ExportMaxWait)
+	}
+	deadline := time.Now().Add(wait)
+
+	header := http.Header{}
+	header.Set("X-User-ID", userID)
+	header.Set("X-Calling-Service", "gateway")
+	path := "/api/v1/account/export/" + url.PathEscape(c.Param("job_id"))
+
+	for {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), h.cfg.ProxyTimeout)
+		status, resp, err := h.proxy.Send(ctx, http.MethodGet, h.cfg.AccountServiceURL, path, header, nil)
+		cancel()
+		if err != nil {
+			h.logger.Warn("Failed to read export job", zap.Error(err))
+			c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to read export status"})
+			return
+		}
+		if status != http.StatusOK {
+			c.Data(status, "application/json; charset=utf-8", resp)
+			return
+		}
+
+		var job map[string]json.RawMessage
+		if json.Unmarshal(resp, &job) != nil {
+			h.logger.Warn("Account service returned an unreadable export job")
+			c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to read export status"})
+			return
+		}
+		var state string
+		json.Unmarshal(job["status"], &state)
+
+		done := state == "ready" || state == "failed"
+		if done || !time.Now().Add(exportPollInterval).Before(deadline) {
+			if state == "ready" {
+				h.addDownloadURL(c, job, userID)
+			}
+			c.Header("Cache-Control", "no-store")
+			c.JSON(http.StatusOK, job)
+			return
+		}
+
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case <-time.After(exportPollInterval):
+		}
+	}
+}
+
+// addDownloadURL adds a download URL for the archive, signed for the user
+// and valid for EXPORT_DOWNLOAD_URL_TTL_SEC, unless no signing secret is set
+func (h *accountExports) addDownloadURL(c *gin.Context, job map[string]json.RawMessage, userID string) {
+	secrets := h.cfg.DownloadSigningSecrets()
+	if len(secrets) == 0 {
+		return
+	}
+
+	jobID := c.Param("job_id")
+	expires := time.Now().Add(h.cfg.ExportDownloadURLTTL).Unix()
+	query := url.Values{}
+	query.Set("user", userID)
+	query.Set("expires", strconv.FormatInt(expires, 10))
+	query.Set("sig", exportSignature(secrets[0], jobID, userID, expires))
+
+	job["download_url"], _ = json.Marshal("/api/v1/account/export/" + url.PathEscape(jobID) + "/download?" + query.Encode())
+	job["download_expires_at"], _ = json.Marshal(time.Unix(expires, 0).UTC())
+}
+
+// download checks the signed URL and streams the archive from the account
+// service on behalf of the user it was signed for
+func (h *accountExports) download(c *gin.Context) {
+	secrets := h.cfg.DownloadSigningSecrets()
+	if len(secrets) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Export downloads are not enabled"})
+		c.Abort()
+		return
+	}
+
+	jobID, userID := c.Param("job_id"), c.Query("user")
+	expires, err := strconv.ParseInt(c.Query("expires"), 10, 64)
+	if err != nil || userID == "" || time.Now().Unix() > expires {
+		h.reject(c, "expired", "Download link expired, request a new one")
+		return
+	}
+	valid := false
+	for _, secret := range secrets {
+		valid = valid || hmac.Equal([]byte(c.Query("sig")), []byte(exportSignature(secret, jobID, userID, expires)))
+	}
+	if !valid {
+		h.reject(c, "signature", "Invalid download link")
+		return
+	}
+
+	metrics.Inc("gateway_export_downloads_total", "result", "accepted")
+	c.Set("user_id", userID)
+	c.Request.URL.Path = "/api/v1/account/export/" + jobID + "/archive"
+	c.Request.URL.RawPath = ""
+	c.Request.URL.RawQuery = ""
+	c.Next()
+}
+
+func (h *accountExports) reject(c *gin.Context, reason, message string) {
+	metrics.Inc("gateway_export_downloads_total", "result", reason)
+	c.JSON(http.StatusForbidden, gin.H{"error": message})
+	c.Abort()
+}
+
+// exportSignature signs a download of a job's archive by a user until expires
+func exportSignature(secret, jobID, userID string, expires int64) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(jobID + "\n" + userID + "\n" + strconv.FormatInt(expires, 10)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,104 @@
+package router
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/YeonwooSung/instagram/api-gateway/middleware"
+	"github.com/YeonwooSung/instagram/api-gateway/state"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+const dynamicFlagsKey = "gateway:flags"
+
+// flagSchema versions middleware.Flag as stored in Redis
+var flagSchema = state.NewSchema("feature_flag", 1)
+
+// loadFlags reads feature flag definitions from Redis into the middleware
+func (d *dynamicRoutes) loadFlags(ctx context.Context) error {
+	raw, err := d.redis.HGetAll(ctx, dynamicFlagsKey).Result()
+	if err != nil {
+		return err
+	}
+
+	flags := make([]middleware.Flag, 0, len(raw))
+	for name, data := range raw {
+		var flag middleware.Flag
+		if err := flagSchema.Unmarshal([]byte(data), &flag); err != nil {
+			d.logger.Warn("Skipping invalid feature flag", zap.String("name", name), zap.Error(err))
+			continue
+		}
+		flags = append(flags, flag)
+	}
+
+	d.flags.Set(flags)
+	return nil
+}
+
+func (d *dynamicRoutes) listFlags(c *gin.Context) {
+	raw, err := d.redis.HGetAll(c.Request.Context(), dynamicFlagsKey).Result()
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Route store unavailable"})
+		return
+	}
+
+	flags := make([]middleware.Flag, 0, len(raw))
+	for _, data := range raw {
+		var flag middleware.Flag
+		if flagSchema.Unmarshal([]byte(data), &flag) == nil {
+			flags = append(flags, flag)
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"flags": flags})
+}
+
+// putFlag creates or updates a flag, e.g. {"enabled": true, "percent": 10}
+func (d *dynamicRoutes) putFlag(c *gin.Context) {
+	var flag middleware.Flag
+	if err := c.ShouldBindJSON(&flag); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid flag definition"})
+		return
+	}
+	flag.Name = c.Param("name")
+
+	if !experimentName.MatchString(flag.Name) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid flag name"})
+		return
+	}
+	if flag.Percent < 0 || flag.Percent > 100 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Percent must be between 0 and 100"})
+		return
+	}
+
+	data, _ := flagSchema.Marshal(flag)
+	if err := d.redis.HSet(c.Request.Context(), dynamicFlagsKey, flag.Name, data).Err(); err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Route store unavailable"})
+		return
+	}
+	d.notify(c.Request.Context())
+
+	d.logger.Info("Feature flag saved",
+		zap.String("name", flag.Name),
+		zap.Bool("enabled", flag.Enabled),
+		zap.Int("percent", flag.Percent),
+	)
+	c.JSON(http.StatusOK, flag)
+}
+
+func (d *dynamicRoutes) deleteFlag(c *gin.Context) {
+	name := c.Param("name")
+	removed, err := d.redis.HDel(c.Request.Context(), dynamicFlagsKey, name).Result()
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Route store unavailable"})
+		return
+	}
+	if removed == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Flag not found"})
+		return
+	}
+	d.notify(c.Request.Context())
+
+	d.logger.Info("Feature flag deleted", zap.String("name", name))
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,326 @@
+package router
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/YeonwooSung/instagram/api-gateway/aggregate"
+	"github.com/YeonwooSung/instagram/api-gateway/config"
+	"github.com/YeonwooSung/instagram/api-gateway/graphql"
+	"github.com/YeonwooSung/instagram/api-gateway/metrics"
+	"github.com/YeonwooSung/instagram/api-gateway/proxy"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+const (
+	// graphqlMaxRequestBytes bounds a GraphQL request body
+	graphqlMaxRequestBytes = 64 << 10
+
+	// graphqlMaxPageSize caps the pageSize argument of list fields
+	graphqlMaxPageSize = 50
+)
+
+// graphqlFacade serves a GraphQL schema stitched over the REST backends.
+// Every field is resolved with the same upstream GETs the REST routes proxy,
+// through the proxy's connection pool, and the GETs of one query level are
+// coalesced and sent together.
+type graphqlFacade struct {
+	cfg    *config.Config
+	proxy  *proxy.ProxyHandler
+	schema *graphql.Schema
+	logger *zap.Logger
+}
+
+func newGraphQLFacade(cfg *config.Config, proxyHandler *proxy.ProxyHandler, logger *zap.Logger) *graphqlFacade {
+	g := &graphqlFacade{cfg: cfg, proxy: proxyHandler, logger: logger}
+	g.schema = g.buildSchema()
+	return g
+}
+
+// buildSchema declares the types of the facade. Fields map to the snake_case
+// keys of the backends' JSON; relations are resolved with further GETs.
+func (g *graphqlFacade) buildSchema() *graphql.Schema {
+	pageArgs := map[string]*graphql.ArgDef{
+		"page":     {Type: graphql.Int, Default: int64(1)},
+		"pageSize": {Type: graphql.Int, Default: int64(20)},
+	}
+
+	stats := &graphql.Object{
+		Name: "UserStats",
+		Fields: map[string]*graphql.FieldDef{
+			"followersCount": {Type: graphql.Int, Key: "followers_count"},
+			"followingCount": {Type: graphql.Int, Key: "following_count"},
+			"postsCount":     {Type: graphql.Int, Key: "posts_count"},
+		},
+	}
+	user := &graphql.Object{
+		Name: "User",
+		Fields: map[string]*graphql.FieldDef{
+			"id":                {Type: graphql.ID, Resolve: sourceKey("id", "user_id")},
+			"username":          {Type: graphql.String},
+			"fullName":          {Type: graphql.String, Key: "full_name"},
+			"bio":               {Type: graphql.String},
+			"profilePictureUrl": {Type: graphql.String, Key: "profile_picture_url"},
+			"isPrivate":         {Type: graphql.Boolean, Key: "is_private"},
+			"isVerified":        {Type: graphql.Boolean, Key: "is_verified"},
+			"createdAt":         {Type: graphql.String, Key: "created_at"},
+		},
+	}
+	post := &graphql.Object{
+		Name: "Post",
+		Fields: map[string]*graphql.FieldDef{
+			"id":            {Type: graphql.ID, Resolve: sourceKey("id", "post_id")},
+			"caption":       {Type: graphql.String},
+			"location":      {Type: graphql.String},
+			"createdAt":     {Type: graphql.String, Key: "created_at"},
+			"likesCount":    {Type: graphql.Int, Key: "likes_count"},
+			"commentsCount": {Type: graphql.Int, Key: "comments_count"},
+			"mediaIds": {Type: &graphql.List{Of: graphql.ID}, Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				source, _ := p.Source.(map[string]interface{})
+				if ids, ok := source["media_ids"].([]interface{}); ok {
+					return ids, nil
+				}
+				if id := sourceID(source, "media_id"); id != "" {
+					return []interface{}{id}, nil
+				}
+				return nil, nil
+			}},
+		},
+	}
+	comment := &graphql.Object{
+		Name: "Comment",
+		Fields: map[string]*graphql.FieldDef{
+			"id":        {Type: graphql.ID, Resolve: sourceKey("id", "comment_id")},
+			"text":      {Type: graphql.String, Resolve: sourceKey("text", "content")},
+			"createdAt": {Type: graphql.String, Key: "created_at"},
+		},
+	}
+
+	// Relations between the types
+	author := &graphql.FieldDef{Type: user, Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+		id := sourceID(p.Source, "user_id", "author_id")
+		if id == "" {
+			return nil, nil
+		}
+		return g.load(p, g.cfg.AuthServiceURL, "/api/v1/auth/users/"+url.PathEscape(id), nil), nil
+	}}
+	user.Fields["stats"] = &graphql.FieldDef{Type: stats, Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+		return g.load(p, g.cfg.GraphServiceURL, "/api/v1/graph/stats/"+url.PathEscape(sourceID(p.Source, "id", "user_id")), nil), nil
+	}}
+	user.Fields["posts"] = &graphql.FieldDef{Type: &graphql.List{Of: post}, Args: pageArgs, Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+		path := "/api/v1/posts/user/" + url.PathEscape(sourceID(p.Source, "id", "user_id")) + pageQuery(p.Args)
+		return g.load(p, g.cfg.PostServiceURL, path, listOf("posts", "items")), nil
+	}}
+	post.Fields["author"] = author
+	post.Fields["likeCount"] = &graphql.FieldDef{Type: graphql.Int, Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+		path := "/api/v1/posts/" + url.PathEscape(sourceID(p.Source, "id", "post_id")) + "/likes/count"
+		return g.load(p, g.cfg.PostServiceURL, path, countOf("count", "like_count", "likes_count")), nil
+	}}
+	post.Fields["comments"] = &graphql.FieldDef{Type: &graphql.List{Of: comment}, Args: pageArgs, Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+		path := "/api/v1/posts/" + url.PathEscape(sourceID(p.Source, "id", "post_id")) + "/comments" + pageQuery(p.Args)
+		return g.load(p, g.cfg.PostServiceURL, path, listOf("comments", "items")), nil
+	}}
+	comment.Fields["author"] = author
+
+	query := &graphql.Object{
+		Name: "Query",
+		Fields: map[string]*graphql.FieldDef{
+			"me": {Type: user, Description: "The caller's profile", Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return g.load(p, g.cfg.AuthServiceURL, "/api/v1/auth/me", nil), nil
+			}},
+			"user": {
+				Type: user,
+				Args: map[string]*graphql.ArgDef{"id": {Type: &graphql.NonNull{Of: graphql.ID}}},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return g.load(p, g.cfg.AuthServiceURL, "/api/v1/auth/users/"+url.PathEscape(p.Args["id"].(string)), nil), nil
+				},
+			},
+			"post": {
+				Type: post,
+				Args: map[string]*graphql.ArgDef{"id": {Type: &graphql.NonNull{Of: graphql.ID}}},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return g.load(p, g.cfg.PostServiceURL, "/api/v1/posts/"+url.PathEscape(p.Args["id"].(string)), nil), nil
+				},
+			},
+			"feed": {
+				Type:        &graphql.List{Of: post},
+				Description: "The caller's news feed",
+				Args:        pageArgs,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return g.load(p, g.cfg.NewsfeedServiceURL, "/api/v1/feed"+pageQuery(p.Args), listOf("posts", "items", "feed")), nil
+				},
+			},
+		},
+	}
+	return &graphql.Schema{Query: query}
+}
+
+// load queues a GET for the current level and returns its decoded JSON,
+// reshaped by transform when set
+func (g *graphqlFacade) load(p graphql.ResolveParams, upstream, path string, transform func(interface{}) interface{}) graphql.Thunk {
+	thunk := p.Loader.Load(upstream + " " + path)
+	return func() (interface{}, error) {
+		value, err := thunk()
+		if err != nil || value == nil || transform == nil {
+			return value, err
+		}
+		return transform(value), nil
+	}
+}
+
+// fetcher returns the loader fetch of one GraphQL request. A missing
+// resource resolves to null; other failures are field errors.
+func (g *graphqlFacade) fetcher(header http.Header) graphql.FetchFunc {
+	return func(ctx context.Context, key string) (interface{}, error) {
+		upstream, path, _ := strings.Cut(key, " ")
+		status, body, err := g.proxy.Fetch(ctx, upstream, path, header)
+		if err != nil {
+			g.logger.Warn("GraphQL upstream call failed", zap.String("path", path), zap.Error(err))
+			return nil, errors.New("upstream unavailable")
+		}
+		switch {
+		case status == http.StatusNotFound:
+			return nil, nil
+		case status == http.StatusUnauthorized || status == http.StatusForbidden:
+			return nil, errors.New("not authorized")
+		case status < 200 || status >= 300:
+			return nil, errors.New("upstream returned " + strconv.Itoa(status))
+		}
+		var value interface{}
+		if err := json.Unmarshal(body, &value); err != nil {
+			return nil, errors.New("upstream returned invalid JSON")
+		}
+		return value, nil
+	}
+}
+
+// serve executes a query sent as JSON in a POST body or in the query string
+// of a GET. Query errors are reported in the GraphQL response with 200.
+func (g *graphqlFacade) serve(c *gin.Context) {
+	var req graphql.Request
+	if c.Request.Method == http.MethodGet {
+		req.Query = c.Query("query")
+		req.OperationName = c.Query("operationName")
+		if variables := c.Query("variables"); variables != "" {
+			if err := json.Unmarshal([]byte(variables), &req.Variables); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "variables must be a JSON object"})
+				return
+			}
+		}
+	} else {
+		body, err := io.ReadAll(io.LimitReader(c.Request.Body, graphqlMaxRequestBytes+1))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read request body"})
+			return
+		}
+		if len(body) > graphqlMaxRequestBytes {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "GraphQL request too large"})
+			return
+		}
+		if err := json.Unmarshal(body, &req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Body must be a JSON GraphQL request"})
+			return
+		}
+	}
+	if req.Query == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "query is required"})
+		return
+	}
+
+	loader := graphql.NewLoader(g.fetcher(aggregate.ForwardHeaders(c)), g.cfg.GraphQLConcurrency)
+	response := g.schema.Execute(c.Request.Context(), req, loader, graphql.Limits{
+		MaxDepth:  g.cfg.GraphQLMaxDepth,
+		MaxFields: g.cfg.GraphQLMaxFields,
+	})
+
+	result := "ok"
+	if len(response.Errors) > 0 {
+		result = "error"
+	}
+	metrics.Inc("gateway_graphql_requests_total", "result", result)
+	c.Header("Cache-Control", "private, no-store")
+	c.JSON(http.StatusOK, response)
+}
+
+// serveSchema returns the schema in the schema definition language
+func (g *graphqlFacade) serveSchema(c *gin.Context) {
+	c.Data(http.StatusOK, "text/plain; charset=utf-8", []byte(g.schema.SDL()))
+}
+
+// sourceKey resolves a field from the first of keys set on the source
+func sourceKey(keys ...string) graphql.ResolveFunc {
+	return func(p graphql.ResolveParams) (interface{}, error) {
+		source, _ := p.Source.(map[string]interface{})
+		for _, key := range keys {
+			if value, ok := source[key]; ok && value != nil {
+				return value, nil
+			}
+		}
+		return nil, nil
+	}
+}
+
+// sourceID returns the first of keys set on a source object as an ID string
+func sourceID(source interface{}, keys ...string) string {
+	object, _ := source.(map[string]interface{})
+	for _, key := range keys {
+		switch id := object[key].(type) {
+		case string:
+			if id != "" {
+				return id
+			}
+		case float64:
+			return strconv.FormatFloat(id, 'f', -1, 64)
+		}
+	}
+	return ""
+}
+
+// pageQuery renders the page arguments as the backends' query string
+func pageQuery(args map[string]interface{}) string {
+	page, _ := args["page"].(int64)
+	pageSize, _ := args["pageSize"].(int64)
+	return "?page=" + strconv.FormatInt(max(page, 1), 10) +
+		"&page_size=" + strconv.FormatInt(min(max(pageSize, 1), graphqlMaxPageSize), 10)
+}
+
+// listOf extracts a list from a response that is either the list itself or
+// an object holding it under one of keys
+func listOf(keys ...string) func(interface{}) interface{} {
+	return func(value interface{}) interface{} {
+		if list, ok := value.([]interface{}); ok {
+			return list
+		}
+		object, _ := value.(map[string]interface{})
+		for _, key := range keys {
+			if list, ok := object[key].([]interface{}); ok {
+				return list
+			}
+		}
+		return nil
+	}
+}
+
+// countOf extracts a count from a response that is either the number itself
+// or an object holding it under one of keys
+func countOf(keys ...string) func(interface{}) interface{} {
+	return func(value interface{}) interface{} {
+		if n, ok := value.(float64); ok {
+			return n
+		}
+		object, _ := value.(map[string]interface{})
+		for _, key := range keys {
+			if n, ok := object[key].(float64); ok {
+				return n
+			}
+		}
+		return nil
+	}
+}
@@ -0,0 +1,278 @@
+package router
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/YeonwooSung/instagram/api-gateway/aggregate"
+	"github.com/YeonwooSung/instagram/api-gateway/cache"
+	"github.com/YeonwooSung/instagram/api-gateway/config"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+const (
+	topPostsLimit = 5
+
+	// followerHistoryDays is how long daily follower snapshots are kept
+	followerHistoryDays = 31
+)
+
+// creatorInsights is the response of /api/v1/insights/me
+type creatorInsights struct {
+	UserID      string          `json:"user_id"`
+	Reach       *insightsReach  `json:"reach,omitempty"`
+	Followers   *followerStats  `json:"followers,omitempty"`
+	TopPosts    []topPost       `json:"top_posts"`
+	Feed        json.RawMessage `json:"feed,omitempty"`
+	Unavailable []string        `json:"unavailable,omitempty"`
+	Degraded    []string        `json:"degraded,omitempty"`
+	GeneratedAt time.Time       `json:"generated_at"`
+}
+
+// insightsReach is derived from the post service's per-user stats
+type insightsReach struct {
+	TotalPosts        int     `json:"total_posts"`
+	TotalViews        int     `json:"total_views"`
+	TotalLikes        int     `json:"total_likes"`
+	TotalComments     int     `json:"total_comments"`
+	AvgEngagementRate float64 `json:"avg_engagement_rate"`
+}
+
+type followerStats struct {
+	Count     int  `json:"count"`
+	Following int  `json:"following"`
+	Growth7d  *int `json:"growth_7d"`
+	Growth30d *int `json:"growth_30d"`
+}
+
+type topPost struct {
+	ID           string `json:"id"`
+	Caption      string `json:"caption,omitempty"`
+	LikeCount    int    `json:"like_count"`
+	CommentCount int    `json:"comment_count"`
+	ViewCount    int    `json:"view_count"`
+}
+
+// insightsHandler serves creator insights aggregated from the post, graph and
+// newsfeed services. Results are cached per user (encrypted) and refreshed in
+// the background once older than the refresh interval, so the screen never
+// waits on the upstreams after the first load.
+type insightsHandler struct {
+	cfg        *config.Config
+	aggregator *aggregate.Aggregator
+	store      *cache.Store
+	redis      *redis.Client
+	logger     *zap.Logger
+	ctx        context.Context
+
+	refreshing sync.Map
+}
+
+func (h *insightsHandler) me(c *gin.Context) {
+	userIDValue, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Token has no user"})
+		return
+	}
+	userID := fmt.Sprintf("%v", userIDValue)
+	key, owner := "gateway:insights:"+userID, "user:"+userID
+	header := aggregate.ForwardHeaders(c)
+
+	if entry, err := h.store.Get(c.Request.Context(), key, owner); err == nil {
+		age := time.Since(entry.StoredAt)
+		if age > h.cfg.InsightsRefresh {
+			h.refreshAsync(userID, header)
+		}
+		c.Header("X-Cache", "HIT")
+		c.Header("Age", strconv.Itoa(int(age.Seconds())))
+		c.Data(entry.Status, entry.ContentType, entry.Body)
+		return
+	}
+
+	body, err := h.refresh(c.Request.Context(), userID, header)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Insights unavailable"})
+		return
+	}
+	c.Header("X-Cache", "MISS")
+	c.Data(http.StatusOK, "application/json; charset=utf-8", body)
+}
+
+// refreshAsync rebuilds a user's insights in the background, at most once at a time
+func (h *insightsHandler) refreshAsync(userID string, header http.Header) {
+	if _, running := h.refreshing.LoadOrStore(userID, struct{}{}); running {
+		return
+	}
+
+	go func() {
+		defer h.refreshing.Delete(userID)
+		if _, err := h.refresh(h.ctx, userID, header); err != nil {
+			h.logger.Warn("Background insights refresh failed", zap.String("user_id", userID), zap.Error(err))
+		}
+	}()
+}
+
+// refresh aggregates fresh insights and caches them when encryption is available
+func (h *insightsHandler) refresh(ctx context.Context, userID string, header http.Header) ([]byte, error) {
+	insights, err := h.build(ctx, userID, header)
+	if err != nil {
+		return nil, err
+	}
+	body, err := json.Marshal(insights)
+	if err != nil {
+		return nil, err
+	}
+
+	// Partial results are kept only until the next refresh is due
+	ttl := h.cfg.InsightsCacheTTL
+	if len(insights.Unavailable) > 0 || len(insights.Degraded) > 0 {
+		ttl = h.cfg.InsightsRefresh
+	}
+	if h.store.CanStorePrivate() && ttl > 0 {
+		entry := &cache.Entry{
+			Status:      http.StatusOK,
+			ContentType: "application/json; charset=utf-8",
+			Body:        body,
+			StoredAt:    time.Now(),
+		}
+		if err := h.store.Set(ctx, "gateway:insights:"+userID, "user:"+userID, entry, ttl); err != nil {
+			h.logger.Warn("Failed to cache insights", zap.Error(err))
+		}
+	}
+	return body, nil
+}
+
+func (h *insightsHandler) build(ctx context.Context, userID string, header http.Header) (*creatorInsights, error) {
+	id := url.PathEscape(userID)
+	results, err := h.aggregator.Fetch(ctx, "insights", header, []aggregate.Call{
+		{Name: "post_stats", Upstream: h.cfg.PostServiceURL, Path: "/api/v1/posts/user/" + id + "/stats"},
+		{Name: "posts", Upstream: h.cfg.PostServiceURL, Path: "/api/v1/posts?page_size=100&user_id=" + url.QueryEscape(userID)},
+		{Name: "graph_stats", Upstream: h.cfg.GraphServiceURL, Path: "/api/v1/graph/stats/" + id},
+		{Name: "feed_stats", Upstream: h.cfg.NewsfeedServiceURL, Path: "/api/v1/feed/stats"},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	insights := &creatorInsights{
+		UserID:      userID,
+		TopPosts:    []topPost{},
+		GeneratedAt: time.Now().UTC(),
+	}
+
+	var reach insightsReach
+	if err := results["post_stats"].Decode(&reach); err == nil {
+		insights.Reach = &reach
+	} else {
+		insights.Unavailable = append(insights.Unavailable, "reach")
+	}
+
+	var posts struct {
+		Posts []struct {
+			topPost
+			MongoID string `json:"_id"`
+		} `json:"posts"`
+	}
+	if err := results["posts"].Decode(&posts); err == nil {
+		for _, post := range posts.Posts {
+			if post.ID == "" {
+				post.ID = post.MongoID
+			}
+			insights.TopPosts = append(insights.TopPosts, post.topPost)
+		}
+		sort.SliceStable(insights.TopPosts, func(i, j int) bool {
+			a, b := insights.TopPosts[i], insights.TopPosts[j]
+			return a.LikeCount+a.CommentCount > b.LikeCount+b.CommentCount
+		})
+		if len(insights.TopPosts) > topPostsLimit {
+			insights.TopPosts = insights.TopPosts[:topPostsLimit]
+		}
+	} else {
+		insights.Unavailable = append(insights.Unavailable, "top_posts")
+	}
+
+	var graph struct {
+		FollowerCount  int `json:"follower_count"`
+		FollowingCount int `json:"following_count"`
+	}
+	if err := results["graph_stats"].Decode(&graph); err == nil {
+		followers := &followerStats{Count: graph.FollowerCount, Following: graph.FollowingCount}
+		// Fallback counts must not enter the follower history
+		if !results["graph_stats"].Fallback {
+			followers.Growth7d, followers.Growth30d = h.followerGrowth(ctx, userID, graph.FollowerCount)
+		}
+		insights.Followers = followers
+	} else {
+		insights.Unavailable = append(insights.Unavailable, "followers")
+	}
+
+	if feed := results["feed_stats"]; feed.OK() && json.Valid(feed.Body) {
+		insights.Feed = feed.Body
+	} else {
+		insights.Unavailable = append(insights.Unavailable, "feed")
+	}
+
+	if len(insights.Unavailable) == 4 {
+		return nil, fmt.Errorf("all insights sources failed")
+	}
+
+	// Sections built from configured fallbacks rather than live data
+	for _, name := range aggregationCalls["insights"] {
+		if results[name].Fallback {
+			insights.Degraded = append(insights.Degraded, name)
+		}
+	}
+	return insights, nil
+}
+
+// followerGrowth records today's follower count and compares it with the
+// oldest snapshot in the last 7 and 30 days. Growth is nil until history exists.
+func (h *insightsHandler) followerGrowth(ctx context.Context, userID string, count int) (*int, *int) {
+	key := "gateway:insights:followers:" + userID
+	now := time.Now().UTC()
+	today := now.Format("2006-01-02")
+
+	pipe := h.redis.TxPipeline()
+	pipe.HSet(ctx, key, today, count)
+	pipe.Expire(ctx, key, followerHistoryDays*24*time.Hour)
+	history := pipe.HGetAll(ctx, key)
+	if _, err := pipe.Exec(ctx); err != nil {
+		h.logger.Warn("Failed to record follower snapshot", zap.Error(err))
+		return nil, nil
+	}
+
+	dates := make([]string, 0, len(history.Val()))
+	for date := range history.Val() {
+		if date < now.AddDate(0, 0, -followerHistoryDays).Format("2006-01-02") {
+			h.redis.HDel(ctx, key, date)
+			continue
+		}
+		dates = append(dates, date)
+	}
+	sort.Strings(dates)
+
+	growthSince := func(days int) *int {
+		cutoff := now.AddDate(0, 0, -days).Format("2006-01-02")
+		for _, date := range dates {
+			if date >= cutoff && date < today {
+				previous, err := strconv.Atoi(history.Val()[date])
+				if err != nil {
+					return nil
+				}
+				growth := count - previous
+				return &growth
+			}
+		}
+		return nil
+	}
+	return growthSince(7), growthSince(30)
+}
@@ -0,0 +1,92 @@
+package router
+
+import (
+	"net/http"
+
+	"github.com/YeonwooSung/instagram/api-gateway/metrics"
+	"github.com/YeonwooSung/instagram/api-gateway/middleware"
+	"github.com/YeonwooSung/instagram/api-gateway/push"
+	"github.com/gin-gonic/gin"
+)
+
+// internalStrippedHeaders are never forwarded on the internal plane: browser
+// state has no place in service-to-service calls, and forwarding chains are
+// rebuilt by the proxy
+var internalStrippedHeaders = []string{
+	"Cookie",
+	"X-Forwarded-For",
+	"X-Forwarded-Host",
+	"X-Forwarded-Proto",
+	"X-Real-IP",
+	"X-Service-Token",
+}
+
+// SetupInternalRoutes configures the internal routing plane served on its own
+// listener. Services call /internal/v1/:service/<path> and the gateway proxies
+// <path> to that upstream. Callers authenticate with mTLS or a service token,
+// and public rate limits do not apply.
+func SetupInternalRoutes(r *gin.Engine, deps Dependencies) {
+	cfg := deps.Config
+	upstreams := cfg.ServiceURLs()
+
+	// Trust headers are stripped as on the public plane: a service token
+	// must not buy a gateway-signed identity of the caller's choosing
+	internal := r.Group("/internal/v1",
+		middleware.StripTrustHeaders(),
+		middleware.ServiceAuth(cfg.InternalServiceTokens),
+	)
+
+	// Services send notifications through the gateway, which throttles them
+	// per device before they reach the push dispatcher
+	if deps.Push != nil {
+		internal.POST("/push", pushHandler(deps.Push))
+	}
+
+	internal.Any("/:service/*path", func(c *gin.Context) {
+		target, ok := upstreams[c.Param("service")]
+		if !ok {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "Unknown service",
+			})
+			return
+		}
+
+		caller := c.GetString("calling_service")
+		for _, header := range internalStrippedHeaders {
+			c.Request.Header.Del(header)
+		}
+		c.Request.Header.Set("X-Calling-Service", caller)
+
+		// Forward the remainder of the path unchanged
+		c.Request.URL.Path = c.Param("path")
+		c.Request.URL.RawPath = ""
+
+		metrics.Inc("gateway_internal_requests_total", "caller", caller, "service", c.Param("service"))
+		deps.ProxyHandler.ProxyRequest(target)(c)
+	})
+
+	r.NoRoute(func(c *gin.Context) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Route not found",
+		})
+	})
+}
+
+// pushHandler accepts a notification for throttling. 202 means the gateway
+// took it over, whatever the decision; callers don't retry dropped ones.
+func pushHandler(throttle *push.Throttle) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var n push.Notification
+		if err := c.ShouldBindJSON(&n); err != nil || n.DeviceID == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid notification"})
+			return
+		}
+
+		decision, err := throttle.Submit(c.Request.Context(), n)
+		if err != nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Failed to queue notification"})
+			return
+		}
+		c.JSON(http.StatusAccepted, gin.H{"result": decision})
+	}
+}
@@ -0,0 +1,83 @@
+package router
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/YeonwooSung/instagram/api-gateway/config"
+	"github.com/YeonwooSung/instagram/api-gateway/metrics"
+	"github.com/YeonwooSung/instagram/api-gateway/proxy"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// maxLiveStartBytes bounds the broadcast settings a client sends on start
+const maxLiveStartBytes = 64 << 10
+
+// liveStreams starts broadcasts. The live service creates the broadcast and
+// mints its stream key; the gateway only lets authenticated users start one,
+// and hands them the RTMP ingest endpoints to push the stream to.
+type liveStreams struct {
+	cfg    *config.Config
+	proxy  *proxy.ProxyHandler
+	logger *zap.Logger
+}
+
+// start creates a broadcast for the user and answers with the live
+// service's response (stream ID and key) plus "ingest_urls"
+func (h *liveStreams) start(c *gin.Context) {
+	userID, ok := bffUserID(c)
+	if !ok {
+		return
+	}
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxLiveStartBytes)
+	body, err := c.GetRawData()
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Broadcast settings too large"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read broadcast settings"})
+		return
+	}
+	if len(body) == 0 {
+		body = []byte("{}")
+	} else if !json.Valid(body) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid broadcast settings"})
+		return
+	}
+
+	header := http.Header{}
+	header.Set("Content-Type", "application/json")
+	header.Set("X-User-ID", userID)
+	header.Set("X-Calling-Service", "gateway")
+
+	status, resp, err := h.proxy.Send(c.Request.Context(), http.MethodPost, h.cfg.LiveServiceURL, "/api/v1/live", header, body)
+	if err != nil {
+		h.logger.Warn("Failed to start broadcast", zap.Error(err))
+		metrics.Inc("gateway_live_starts_total", "result", "unavailable")
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to start broadcast"})
+		return
+	}
+	if status >= http.StatusMultipleChoices {
+		metrics.Inc("gateway_live_starts_total", "result", "refused")
+		c.Data(status, "application/json; charset=utf-8", resp)
+		return
+	}
+
+	var broadcast map[string]json.RawMessage
+	if json.Unmarshal(resp, &broadcast) != nil || len(broadcast["stream_key"]) == 0 {
+		h.logger.Warn("Live service returned no stream key", zap.Int("status", status))
+		metrics.Inc("gateway_live_starts_total", "result", "unavailable")
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to start broadcast"})
+		return
+	}
+	broadcast["ingest_urls"], _ = json.Marshal(h.cfg.LiveIngestURLs)
+
+	metrics.Inc("gateway_live_starts_total", "result", "started")
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusCreated, broadcast)
+}
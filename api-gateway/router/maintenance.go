@@ -0,0 +1,233 @@
+package router
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/YeonwooSung/instagram/api-gateway/config"
+	"github.com/YeonwooSung/instagram/api-gateway/metrics"
+	"github.com/YeonwooSung/instagram/api-gateway/proxy"
+	"github.com/YeonwooSung/instagram/api-gateway/state"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+const (
+	maintenanceKey     = "gateway:maintenance"
+	maintenanceChannel = "gateway:maintenance:changed"
+)
+
+// maintenanceStateSchema versions maintenanceState as stored in Redis
+var maintenanceStateSchema = state.NewSchema("maintenance_state", 1)
+
+// maintenanceState is one service in maintenance. Payload, when set, is the
+// whole 503 body; otherwise the body is built from Message.
+type maintenanceState struct {
+	Message    string          `json:"message,omitempty"`
+	Payload    json.RawMessage `json:"payload,omitempty"`
+	RetryAfter int             `json:"retry_after"`
+	StartedAt  time.Time       `json:"started_at"`
+}
+
+// maintenance puts services into maintenance: the proxy answers their
+// routes with a 503 instead of calling them. The services in maintenance are
+// stored in Redis so every replica short-circuits them together.
+type maintenance struct {
+	redis    *redis.Client
+	proxy    *proxy.ProxyHandler
+	upstream map[string]string
+	cfg      *config.Config
+	logger   *zap.Logger
+}
+
+func newMaintenance(
+	redisClient *redis.Client,
+	proxyHandler *proxy.ProxyHandler,
+	cfg *config.Config,
+	logger *zap.Logger,
+) *maintenance {
+	return &maintenance{
+		redis:    redisClient,
+		proxy:    proxyHandler,
+		upstream: cfg.ServiceURLs(),
+		cfg:      cfg,
+		logger:   logger,
+	}
+}
+
+// sync applies the stored maintenance windows until ctx is cancelled
+func (m *maintenance) sync(ctx context.Context) {
+	m.load(ctx)
+
+	sub := m.redis.Subscribe(ctx, maintenanceChannel)
+	defer sub.Close()
+
+	ticker := time.NewTicker(dynamicReloadInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-sub.Channel():
+		case <-ticker.C:
+		}
+		m.load(ctx)
+	}
+}
+
+// load hands the services in maintenance to the proxy. While Redis is
+// unreachable the last known set is kept.
+func (m *maintenance) load(ctx context.Context) {
+	raw, err := m.redis.HGetAll(ctx, maintenanceKey).Result()
+	if err != nil {
+		m.logger.Warn("Failed to load maintenance state", zap.Error(err))
+		return
+	}
+
+	responses := make(map[string]proxy.Maintenance, len(raw))
+	for name, upstream := range m.upstream {
+		data, ok := raw[name]
+		if !ok {
+			metrics.Set("gateway_maintenance_active", 0, "service", name)
+			continue
+		}
+		var st maintenanceState
+		if err := maintenanceStateSchema.Unmarshal([]byte(data), &st); err != nil {
+			m.logger.Warn("Skipping invalid maintenance state", zap.String("service", name), zap.Error(err))
+			continue
+		}
+		responses[upstream] = proxy.Maintenance{
+			Payload:    m.payload(name, st),
+			RetryAfter: time.Duration(st.RetryAfter) * time.Second,
+			Verbatim:   len(st.Payload) > 0,
+		}
+		metrics.Set("gateway_maintenance_active", 1, "service", name)
+	}
+	m.proxy.SetMaintenance(responses)
+}
+
+// payload returns the 503 body of a service in maintenance
+func (m *maintenance) payload(name string, st maintenanceState) []byte {
+	if len(st.Payload) > 0 {
+		return st.Payload
+	}
+	message := st.Message
+	if message == "" {
+		message = m.cfg.MaintenanceMessage
+	}
+	body, _ := json.Marshal(gin.H{
+		"error":       message,
+		"service":     name,
+		"maintenance": true,
+		"retry_after": st.RetryAfter,
+	})
+	return body
+}
+
+func (m *maintenance) registerAdmin(admin *gin.RouterGroup) {
+	admin.GET("/maintenance", m.list)
+	admin.PUT("/maintenance/:service", m.start)
+	admin.DELETE("/maintenance/:service", m.end)
+}
+
+func (m *maintenance) list(c *gin.Context) {
+	raw, err := m.redis.HGetAll(c.Request.Context(), maintenanceKey).Result()
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Route store unavailable"})
+		return
+	}
+
+	services := make(map[string]maintenanceState, len(raw))
+	for name, data := range raw {
+		var st maintenanceState
+		if maintenanceStateSchema.Unmarshal([]byte(data), &st) == nil {
+			services[name] = st
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"services": services})
+}
+
+// start puts a service into maintenance, or updates its response if it
+// already is
+func (m *maintenance) start(c *gin.Context) {
+	name := c.Param("service")
+	if _, ok := m.upstream[name]; !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Unknown service"})
+		return
+	}
+
+	var req struct {
+		Message    string          `json:"message"`
+		Payload    json.RawMessage `json:"payload"`
+		RetryAfter *int            `json:"retry_after"`
+	}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid maintenance request"})
+			return
+		}
+	}
+	if len(req.Payload) > 0 && !json.Valid(req.Payload) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Payload must be JSON"})
+		return
+	}
+	st := maintenanceState{
+		Message:    req.Message,
+		Payload:    req.Payload,
+		RetryAfter: int(m.cfg.MaintenanceRetryAfter.Seconds()),
+		StartedAt:  time.Now().UTC(),
+	}
+	if req.RetryAfter != nil {
+		if *req.RetryAfter <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "retry_after must be positive"})
+			return
+		}
+		st.RetryAfter = *req.RetryAfter
+	}
+
+	encoded, err := maintenanceStateSchema.Marshal(st)
+	if err == nil {
+		err = m.redis.HSet(c.Request.Context(), maintenanceKey, name, encoded).Err()
+	}
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Route store unavailable"})
+		return
+	}
+	m.changed(c.Request.Context())
+
+	m.logger.Info("Service maintenance started",
+		zap.String("service", name),
+		zap.Int("retry_after", st.RetryAfter),
+	)
+	c.JSON(http.StatusOK, gin.H{"service": name, "maintenance": st})
+}
+
+// end takes a service out of maintenance
+func (m *maintenance) end(c *gin.Context) {
+	name := c.Param("service")
+	removed, err := m.redis.HDel(c.Request.Context(), maintenanceKey, name).Result()
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Route store unavailable"})
+		return
+	}
+	if removed == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Service is not in maintenance"})
+		return
+	}
+	m.changed(c.Request.Context())
+
+	m.logger.Info("Service maintenance ended", zap.String("service", name))
+	c.JSON(http.StatusOK, gin.H{"service": name, "maintenance": false})
+}
+
+// changed applies the change locally and tells the other replicas
+func (m *maintenance) changed(ctx context.Context) {
+	m.load(ctx)
+	if err := m.redis.Publish(ctx, maintenanceChannel, "").Err(); err != nil {
+		m.logger.Warn("Failed to publish maintenance change", zap.Error(err))
+	}
+}
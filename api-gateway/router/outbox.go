@@ -0,0 +1,69 @@
+package router
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/YeonwooSung/instagram/api-gateway/outbox"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// maxDeadLetters caps the dead letters listed by the admin API
+const maxDeadLetters = 500
+
+// outboxAdmin exposes the outbox backlog and dead letters to operators
+type outboxAdmin struct {
+	outbox *outbox.Outbox
+	logger *zap.Logger
+}
+
+func (o *outboxAdmin) registerAdmin(admin *gin.RouterGroup) {
+	admin.GET("/outbox", o.stats)
+	admin.GET("/outbox/dead", o.listDead)
+	admin.POST("/outbox/dead/:id/replay", o.replay)
+}
+
+func (o *outboxAdmin) stats(c *gin.Context) {
+	backlog, dead, err := o.outbox.Stats(c.Request.Context())
+	if err != nil {
+		o.logger.Warn("Failed to read outbox stats", zap.Error(err))
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Outbox unavailable"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"backlog": backlog,
+		"dead":    dead,
+		"kinds":   o.outbox.Kinds(),
+	})
+}
+
+func (o *outboxAdmin) listDead(c *gin.Context) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || limit <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be a positive integer"})
+		return
+	}
+	letters, err := o.outbox.DeadLetters(c.Request.Context(), int64(min(limit, maxDeadLetters)))
+	if err != nil {
+		o.logger.Warn("Failed to read outbox dead letters", zap.Error(err))
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Outbox unavailable"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"dead_letters": letters})
+}
+
+// replay requeues a dead-lettered event, e.g. once its sink is fixed
+func (o *outboxAdmin) replay(c *gin.Context) {
+	found, err := o.outbox.Replay(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		o.logger.Warn("Failed to replay outbox dead letter", zap.String("id", c.Param("id")), zap.Error(err))
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Outbox unavailable"})
+		return
+	}
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Dead letter not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"replayed": c.Param("id")})
+}
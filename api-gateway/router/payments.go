@@ -0,0 +1,222 @@
+package router
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/YeonwooSung/instagram/api-gateway/config"
+	"github.com/YeonwooSung/instagram/api-gateway/metrics"
+	"github.com/YeonwooSung/instagram/api-gateway/proxy"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+const (
+	// maxWebhookBody caps payment webhook payloads read by the gateway
+	maxWebhookBody = 1 << 20
+
+	webhookDone = "done"
+)
+
+// Lease values are only released or completed by the holder that set them,
+// so a delivery whose lease expired cannot clobber a newer attempt.
+var (
+	releaseWebhookLease = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+
+	completeWebhookLease = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("SET", KEYS[1], ARGV[2], "PX", ARGV[3])
+end
+return false`)
+)
+
+// paymentWebhooks ingests payment provider webhooks. Signatures are verified
+// at the gateway (Stripe-style "t=<unix>,v1=<hex hmac>" over "<t>.<body>"),
+// and each event is forwarded to the billing service exactly once: a Redis
+// lease guards concurrent deliveries, and events are only marked done after
+// billing accepted them, so failed forwards are retried by the provider.
+type paymentWebhooks struct {
+	cfg    *config.Config
+	proxy  *proxy.ProxyHandler
+	redis  *redis.Client
+	logger *zap.Logger
+}
+
+func (h *paymentWebhooks) receive(c *gin.Context) {
+	provider := c.Param("provider")
+	secrets := h.cfg.PaymentWebhookSecrets(provider)
+	if len(secrets) == 0 {
+		h.respond(c, "unknown", "unknown_provider", http.StatusNotFound, "Unknown payment provider")
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxWebhookBody+1))
+	if err != nil {
+		h.respond(c, provider, "bad_request", http.StatusBadRequest, "Failed to read request body")
+		return
+	}
+	if len(body) > maxWebhookBody {
+		h.respond(c, provider, "too_large", http.StatusRequestEntityTooLarge, "Webhook payload too large")
+		return
+	}
+
+	if !h.validSignature(c.GetHeader(webhookSignatureHeader(provider)), body, secrets) {
+		h.respond(c, provider, "invalid_signature", http.StatusUnauthorized, "Invalid webhook signature")
+		return
+	}
+
+	var event struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(body, &event); err != nil || event.ID == "" {
+		h.respond(c, provider, "invalid_event", http.StatusBadRequest, "Webhook event id is required")
+		return
+	}
+
+	ctx := c.Request.Context()
+	key := "gateway:payments:webhook:" + provider + ":" + event.ID
+	lease, err := newLeaseToken()
+	if err != nil {
+		h.respond(c, provider, "store_unavailable", http.StatusServiceUnavailable, "Webhook ingestion unavailable")
+		return
+	}
+
+	// The lease outlives the forward, so a slow billing call is never duplicated
+	acquired, err := h.redis.SetNX(ctx, key, lease, 2*h.cfg.ProxyTimeout).Result()
+	if err != nil {
+		h.logger.Error("Webhook idempotency store unavailable", zap.Error(err))
+		h.respond(c, provider, "store_unavailable", http.StatusServiceUnavailable, "Webhook ingestion unavailable")
+		return
+	}
+	if !acquired {
+		state, err := h.redis.Get(ctx, key).Result()
+		if err == nil && state == webhookDone {
+			metrics.Inc("gateway_payment_webhooks_total", "provider", provider, "result", "duplicate")
+			c.JSON(http.StatusOK, gin.H{"received": true, "duplicate": true})
+			return
+		}
+		h.respond(c, provider, "in_progress", http.StatusConflict, "Webhook event is being processed")
+		return
+	}
+
+	header := http.Header{}
+	header.Set("Content-Type", "application/json")
+	header.Set("Idempotency-Key", provider+":"+event.ID)
+	header.Set("X-Webhook-Provider", provider)
+	header.Set("X-Webhook-Event-ID", event.ID)
+
+	status, _, err := h.proxy.Send(ctx, http.MethodPost, h.cfg.BillingServiceURL,
+		"/api/v1/billing/webhooks/"+provider, header, body)
+	if err != nil || status < 200 || status >= 300 {
+		h.logger.Warn("Billing rejected payment webhook",
+			zap.String("provider", provider),
+			zap.String("event_id", event.ID),
+			zap.Int("status", status),
+			zap.Error(err),
+		)
+		// Release with a fresh context so the provider's retry is not blocked
+		// by the lease when the request was cancelled
+		releaseCtx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		releaseWebhookLease.Run(releaseCtx, h.redis, []string{key}, lease)
+
+		h.respond(c, provider, "forward_failed", http.StatusBadGateway, "Failed to deliver webhook")
+		return
+	}
+
+	retention := strconv.FormatInt(h.cfg.PaymentWebhookRetention.Milliseconds(), 10)
+	err = completeWebhookLease.Run(ctx, h.redis, []string{key}, lease, webhookDone, retention).Err()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		h.logger.Error("Failed to mark payment webhook delivered",
+			zap.String("provider", provider),
+			zap.String("event_id", event.ID),
+			zap.Error(err),
+		)
+	}
+
+	metrics.Inc("gateway_payment_webhooks_total", "provider", provider, "result", "delivered")
+	c.JSON(http.StatusOK, gin.H{"received": true})
+}
+
+// validSignature checks a "t=<unix>,v1=<hex>" signature header against the
+// accepted secrets, rejecting timestamps outside the configured tolerance
+func (h *paymentWebhooks) validSignature(header string, body []byte, secrets []string) bool {
+	var timestamp string
+	var signatures [][]byte
+	for _, part := range strings.Split(header, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			if sig, err := hex.DecodeString(value); err == nil {
+				signatures = append(signatures, sig)
+			}
+		}
+	}
+
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || len(signatures) == 0 {
+		return false
+	}
+	tolerance := h.cfg.PaymentWebhookTolerance
+	if skew := time.Since(time.Unix(unix, 0)); skew > tolerance || skew < -tolerance {
+		return false
+	}
+
+	for _, secret := range secrets {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte(timestamp + "."))
+		mac.Write(body)
+		expected := mac.Sum(nil)
+		for _, sig := range signatures {
+			if hmac.Equal(sig, expected) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (h *paymentWebhooks) respond(c *gin.Context, provider, result string, status int, message string) {
+	metrics.Inc("gateway_payment_webhooks_total", "provider", provider, "result", result)
+	c.JSON(status, gin.H{
+		"error": message,
+	})
+	c.Abort()
+}
+
+// webhookSignatureHeader is Stripe-Signature for Stripe and Webhook-Signature
+// for other providers signing the same way
+func webhookSignatureHeader(provider string) string {
+	if provider == "stripe" {
+		return "Stripe-Signature"
+	}
+	return "Webhook-Signature"
+}
+
+func newLeaseToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,248 @@
+package router
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strings"
+
+	"github.com/YeonwooSung/instagram/api-gateway/config"
+	"github.com/YeonwooSung/instagram/api-gateway/journal"
+	"github.com/YeonwooSung/instagram/api-gateway/proxy"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+const (
+	// publishSaga creates a post together with its media
+	publishSaga = "post_with_media"
+
+	// publishMaxMedia caps the media of one post (carousel size)
+	publishMaxMedia = 10
+
+	// publishFormMemory is how much of a publish form is held in memory;
+	// larger files spill to temporary files
+	publishFormMemory = 32 << 20
+)
+
+// upstreamError carries a failed upstream response back to the client
+type upstreamError struct {
+	status int
+	body   []byte
+}
+
+func (e *upstreamError) Error() string {
+	return fmt.Sprintf("upstream answered %d", e.status)
+}
+
+// publisher creates posts with media in one request. Uploading the media and
+// creating the post referencing them is a journaled saga, so a failed post or
+// a gateway crash midway never leaves orphaned media behind.
+type publisher struct {
+	cfg     *config.Config
+	proxy   *proxy.ProxyHandler
+	journal *journal.Journal
+	logger  *zap.Logger
+}
+
+// saga uploads the media, then creates the post. Uploads need the request's
+// files, so a run interrupted there is compensated by deleting what was
+// uploaded; post creation is idempotent per run and resumed.
+func (p *publisher) saga() journal.Saga {
+	return journal.Saga{
+		Name: publishSaga,
+		Steps: []journal.Step{
+			{Name: "upload_media", Do: p.uploadMedia, Undo: p.deleteMedia},
+			{Name: "create_post", Do: p.createPost, Resumable: true},
+		},
+	}
+}
+
+// publish handles a multipart form with the post's fields as JSON in "post"
+// and its files in "media"
+func (p *publisher) publish(c *gin.Context) {
+	userID, ok := bffUserID(c)
+	if !ok {
+		return
+	}
+
+	if err := c.Request.ParseMultipartForm(publishFormMemory); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid publish form"})
+		return
+	}
+	defer c.Request.MultipartForm.RemoveAll()
+
+	post := c.Request.FormValue("post")
+	var fields map[string]interface{}
+	if json.Unmarshal([]byte(post), &fields) != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "post must be a JSON object"})
+		return
+	}
+	files := c.Request.MultipartForm.File["media"]
+	if len(files) == 0 || len(files) > publishMaxMedia {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Between 1 and %d media files are required", publishMaxMedia)})
+		return
+	}
+
+	run, err := p.journal.Execute(c.Request.Context(), publishSaga, map[string]string{
+		"user_id": userID,
+		"post":    post,
+	}, files)
+	var upstream *upstreamError
+	switch {
+	case err == nil:
+		c.Data(http.StatusCreated, "application/json; charset=utf-8", []byte(run.State["response"]))
+	case errors.Is(err, journal.ErrUnavailable):
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Publishing unavailable"})
+	case errors.As(err, &upstream):
+		c.Data(upstream.status, "application/json; charset=utf-8", upstream.body)
+	default:
+		p.logger.Warn("Failed to publish post", zap.Error(err))
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to publish post"})
+	}
+}
+
+// uploadMedia uploads the files one by one, checkpointing each media ID so a
+// failure midway deletes the ones already uploaded
+func (p *publisher) uploadMedia(ctx context.Context, run *journal.Run) error {
+	files, _ := run.Input.([]*multipart.FileHeader)
+	if files == nil {
+		return errors.New("media files are not available")
+	}
+
+	for _, file := range files {
+		body, contentType, err := mediaUploadBody(file)
+		if err != nil {
+			return err
+		}
+		header := serviceHeader(run)
+		header.Set("Content-Type", contentType)
+		status, resp, err := p.proxy.Send(ctx, http.MethodPost, p.cfg.MediaServiceURL, "/api/v1/media/upload", header, body)
+		if err != nil {
+			return err
+		}
+		if status >= http.StatusMultipleChoices {
+			return &upstreamError{status: status, body: resp}
+		}
+
+		id := uploadedMediaID(resp)
+		if id == "" {
+			return errors.New("media service returned no media ID")
+		}
+
+		run.State["media_ids"] = strings.Join(append(mediaIDs(run), id), ",")
+		if err := run.Checkpoint(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteMedia removes the uploaded media; already deleted ones are fine
+func (p *publisher) deleteMedia(ctx context.Context, run *journal.Run) error {
+	for _, id := range mediaIDs(run) {
+		status, _, err := p.proxy.Send(ctx, http.MethodDelete, p.cfg.MediaServiceURL, "/api/v1/media/"+url.PathEscape(id), serviceHeader(run), nil)
+		if err != nil {
+			return err
+		}
+		if status >= http.StatusMultipleChoices && status != http.StatusNotFound {
+			return fmt.Errorf("deleting media %s: upstream answered %d", id, status)
+		}
+	}
+	return nil
+}
+
+// createPost creates the post referencing the uploaded media. The run ID is
+// the idempotency key, so a resumed run can't create the post twice.
+func (p *publisher) createPost(ctx context.Context, run *journal.Run) error {
+	var fields map[string]interface{}
+	if err := json.Unmarshal([]byte(run.State["post"]), &fields); err != nil {
+		return err
+	}
+	fields["media_ids"] = mediaIDs(run)
+	body, _ := json.Marshal(fields)
+
+	header := serviceHeader(run)
+	header.Set("Content-Type", "application/json")
+	header.Set("Idempotency-Key", run.ID)
+	status, resp, err := p.proxy.Send(ctx, http.MethodPost, p.cfg.PostServiceURL, "/api/v1/posts", header, body)
+	if err != nil {
+		return err
+	}
+	if status >= http.StatusMultipleChoices {
+		return &upstreamError{status: status, body: resp}
+	}
+	run.State["response"] = string(resp)
+	return nil
+}
+
+// serviceHeader identifies the user to services; recovered runs act on the
+// journaled user, not a client token
+func serviceHeader(run *journal.Run) http.Header {
+	header := http.Header{}
+	header.Set("X-User-ID", run.State["user_id"])
+	header.Set("X-Calling-Service", "gateway")
+	return header
+}
+
+func mediaIDs(run *journal.Run) []string {
+	if run.State["media_ids"] == "" {
+		return nil
+	}
+	return strings.Split(run.State["media_ids"], ",")
+}
+
+// uploadedMediaID reads the ID from the media service's upload response
+func uploadedMediaID(resp []byte) string {
+	var uploaded struct {
+		ID      json.RawMessage `json:"id"`
+		MediaID json.RawMessage `json:"media_id"`
+	}
+	json.Unmarshal(resp, &uploaded)
+	id := strings.Trim(string(uploaded.ID), `"`)
+	if id == "" {
+		id = strings.Trim(string(uploaded.MediaID), `"`)
+	}
+	if id == "null" {
+		return ""
+	}
+	return id
+}
+
+// mediaUploadBody wraps one file in the multipart form the media service's
+// upload endpoint takes
+func mediaUploadBody(file *multipart.FileHeader) ([]byte, string, error) {
+	src, err := file.Open()
+	if err != nil {
+		return nil, "", err
+	}
+	defer src.Close()
+
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="file"; filename=%q`, file.Filename))
+	header.Set("Content-Type", file.Header.Get("Content-Type"))
+	if header.Get("Content-Type") == "" {
+		header.Set("Content-Type", "application/octet-stream")
+	}
+
+	var buf bytes.Buffer
+	form := multipart.NewWriter(&buf)
+	part, err := form.CreatePart(header)
+	if err != nil {
+		return nil, "", err
+	}
+	if _, err := io.Copy(part, src); err != nil {
+		return nil, "", err
+	}
+	if err := form.Close(); err != nil {
+		return nil, "", err
+	}
+	return buf.Bytes(), form.FormDataContentType(), nil
+}
@@ -0,0 +1,172 @@
+package router
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/YeonwooSung/instagram/api-gateway/middleware"
+	"github.com/YeonwooSung/instagram/api-gateway/outbox"
+	"github.com/YeonwooSung/instagram/api-gateway/state"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+const (
+	quarantineKey     = "gateway:quarantine"
+	quarantineChannel = "gateway:quarantine:changed"
+)
+
+// quarantineRecordSchema versions middleware.QuarantineRecord as stored in Redis
+var quarantineRecordSchema = state.NewSchema("quarantine_record", 1)
+
+// quarantines shares route quarantines across replicas. A replica that
+// detects an anomalous upstream stores the quarantine in Redis, so every
+// replica serves the route from its last known good responses, and raises an
+// alert. The quarantine holds until an operator acknowledges it through the
+// admin API.
+type quarantines struct {
+	redis  *redis.Client
+	guard  *middleware.Quarantine
+	events *outbox.Outbox
+	logger *zap.Logger
+}
+
+func newQuarantines(
+	redisClient *redis.Client,
+	check middleware.ResponseCheck,
+	events *outbox.Outbox,
+	logger *zap.Logger,
+) *quarantines {
+	q := &quarantines{
+		redis:  redisClient,
+		events: events,
+		logger: logger,
+	}
+	q.guard = middleware.NewQuarantine(check, q.tripped, logger)
+	return q
+}
+
+// sync applies the stored quarantines until ctx is cancelled
+func (q *quarantines) sync(ctx context.Context) {
+	q.load(ctx)
+
+	sub := q.redis.Subscribe(ctx, quarantineChannel)
+	defer sub.Close()
+
+	ticker := time.NewTicker(dynamicReloadInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-sub.Channel():
+		case <-ticker.C:
+		}
+		q.load(ctx)
+	}
+}
+
+// load replaces the quarantined routes with those stored in Redis
+func (q *quarantines) load(ctx context.Context) {
+	raw, err := q.redis.HGetAll(ctx, quarantineKey).Result()
+	if err != nil {
+		q.logger.Warn("Failed to load route quarantines", zap.Error(err))
+		return
+	}
+
+	records := make([]middleware.QuarantineRecord, 0, len(raw))
+	for route, data := range raw {
+		var record middleware.QuarantineRecord
+		if err := quarantineRecordSchema.Unmarshal([]byte(data), &record); err != nil {
+			q.logger.Warn("Skipping invalid quarantine", zap.String("route", route), zap.Error(err))
+			continue
+		}
+		records = append(records, record)
+	}
+	q.guard.Set(records)
+}
+
+// tripped stores a quarantine this replica detected, tells the others and
+// raises the alert. The first replica to trip a route wins.
+func (q *quarantines) tripped(record middleware.QuarantineRecord) {
+	q.logger.Error("Route quarantined after anomalous upstream responses; serving last known good responses until acknowledged",
+		zap.String("route", record.Route),
+		zap.String("reason", record.Reason),
+	)
+
+	// Detached from the request that happened to trip the route
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	data, _ := quarantineRecordSchema.Marshal(record)
+	stored, err := q.redis.HSetNX(ctx, quarantineKey, record.Route, data).Result()
+	if err != nil {
+		q.logger.Warn("Failed to store route quarantine", zap.String("route", record.Route), zap.Error(err))
+		return
+	}
+	if !stored {
+		return
+	}
+	if err := q.redis.Publish(ctx, quarantineChannel, record.Route).Err(); err != nil {
+		q.logger.Warn("Failed to publish route quarantine", zap.Error(err))
+	}
+
+	if q.events != nil && slices.Contains(q.events.Kinds(), outbox.KindAlert) {
+		alert := gin.H{
+			"alert":  "route_quarantined",
+			"route":  record.Route,
+			"reason": record.Reason,
+			"since":  record.Since,
+		}
+		if err := q.events.Publish(ctx, outbox.KindAlert, record.Route, alert); err != nil {
+			q.logger.Warn("Failed to publish quarantine alert", zap.Error(err))
+		}
+	}
+}
+
+// ==================== Admin handlers ====================
+
+func (q *quarantines) registerAdmin(admin *gin.RouterGroup) {
+	admin.GET("/quarantines", q.list)
+	admin.DELETE("/quarantines", q.acknowledge)
+}
+
+func (q *quarantines) list(c *gin.Context) {
+	records := q.guard.Active()
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].Route < records[j].Route
+	})
+	c.JSON(http.StatusOK, gin.H{"quarantines": records})
+}
+
+// acknowledge lifts the quarantine of the route given in ?route=, once an
+// operator has dealt with the upstream. Detection starts over on every replica.
+func (q *quarantines) acknowledge(c *gin.Context) {
+	route := c.Query("route")
+	if route == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "route is required"})
+		return
+	}
+
+	removed, err := q.redis.HDel(c.Request.Context(), quarantineKey, route).Result()
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Quarantine store unavailable"})
+		return
+	}
+	if removed == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Route is not quarantined"})
+		return
+	}
+	if err := q.redis.Publish(c.Request.Context(), quarantineChannel, route).Err(); err != nil {
+		q.logger.Warn("Failed to publish route quarantine change", zap.Error(err))
+	}
+	q.load(c.Request.Context())
+
+	q.logger.Info("Route quarantine acknowledged", zap.String("route", route))
+	c.Status(http.StatusNoContent)
+}
@@ -1,40 +1,247 @@
 package router
 
 import (
+	"context"
 	"net/http"
+	"slices"
+	"sort"
+	"time"
 
+	"github.com/YeonwooSung/instagram/api-gateway/aggregate"
+	"github.com/YeonwooSung/instagram/api-gateway/auditlog"
+	"github.com/YeonwooSung/instagram/api-gateway/cache"
 	"github.com/YeonwooSung/instagram/api-gateway/config"
+	"github.com/YeonwooSung/instagram/api-gateway/events"
+	"github.com/YeonwooSung/instagram/api-gateway/journal"
 	"github.com/YeonwooSung/instagram/api-gateway/middleware"
+	"github.com/YeonwooSung/instagram/api-gateway/openapi"
+	"github.com/YeonwooSung/instagram/api-gateway/outbox"
 	"github.com/YeonwooSung/instagram/api-gateway/proxy"
+	"github.com/YeonwooSung/instagram/api-gateway/push"
+	"github.com/YeonwooSung/instagram/api-gateway/settings"
+	"github.com/YeonwooSung/instagram/api-gateway/tus"
+	"github.com/YeonwooSung/instagram/api-gateway/version"
+	"github.com/YeonwooSung/instagram/api-gateway/webhooks"
 	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
 	"go.uber.org/zap"
 )
 
-// SetupRoutes configures all routes for the API Gateway
-func SetupRoutes(
-	r *gin.Engine,
-	cfg *config.Config,
-	logger *zap.Logger,
-	rateLimiter *middleware.RateLimiter,
-) {
-	// Create proxy handler
-	proxyHandler := proxy.NewProxyHandler(cfg.ProxyTimeout, logger)
+// Dependencies are the shared components routes are wired with
+type Dependencies struct {
+	Config       *config.Config
+	Logger       *zap.Logger
+	RateLimiter  *middleware.RateLimiter
+	ProxyHandler *proxy.ProxyHandler
+	Redis        *redis.Client
+	RedisHealth  *middleware.RedisHealth
+	CacheStore   *cache.Store
+	CursorCipher *cache.Cipher
+	Outbox       *outbox.Outbox
+	Events       *events.Publisher
+	Push         *push.Throttle
+	Webhooks     *webhooks.Manager
+	AuditLog     *auditlog.Log
 
-	// API version group
-	api := r.Group("/api/v1")
+	// Management engine serving the admin API off the public listener; the
+	// admin API is served with the public routes when nil
+	Management *gin.Engine
+}
+
+// SetupRoutes configures all routes for the API Gateway. Background workers
+// started for the routes run until ctx is cancelled.
+func SetupRoutes(ctx context.Context, r *gin.Engine, deps Dependencies) error {
+	cfg, logger := deps.Config, deps.Logger
+	rateLimiter, proxyHandler := deps.RateLimiter, deps.ProxyHandler
+	redisClient := deps.Redis
+
+	// Absorbs accidental double submissions on idempotent-by-intent writes
+	deduplicator := middleware.NewDeduplicator(deps.RedisHealth, logger)
+	dedup := deduplicator.Dedup(cfg.DedupWindow)
+
+	// Caches read-heavy GET responses in Redis, served stale while an upstream fails
+	responseCache := middleware.NewResponseCache(deps.CacheStore, deps.RedisHealth, cfg.CacheMaxStale, logger)
+
+	// Feature flags gate routes per user; definitions are managed through the admin API
+	flags := middleware.NewFlags()
+
+	// Backends' OpenAPI specs validate requests, and sampled responses of
+	// quarantine-guarded routes
+	var validator *openapi.Validator
+	if len(cfg.OpenAPISpecs) > 0 {
+		names := make([]string, 0, len(cfg.OpenAPISpecs))
+		for name := range cfg.OpenAPISpecs {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		specs := make([]*openapi.Spec, 0, len(names))
+		for _, name := range names {
+			spec, err := openapi.Load(name, cfg.OpenAPISpecs[name])
+			if err != nil {
+				return err
+			}
+			specs = append(specs, spec)
+		}
+		validator = openapi.NewValidator(specs, logger)
+	}
+
+	// Routes whose upstream starts returning anomalous responses are served
+	// from their last known good responses until acknowledged
+	var responseCheck middleware.ResponseCheck
+	if validator != nil {
+		responseCheck = func(method, path string, status int, contentType string, body []byte) ([]string, bool) {
+			errs, ok := validator.ValidateResponse(method, path, status, contentType, body)
+			problems := make([]string, len(errs))
+			for i, e := range errs {
+				problems[i] = e.Field + " " + e.Message
+			}
+			return problems, ok
+		}
+	}
+	quarantine := newQuarantines(redisClient, responseCheck, deps.Outbox, logger)
+	go quarantine.sync(ctx)
+
+	// Middleware chains declared in config, applied per route group
+	chains := newMiddlewareRegistry(deps, deduplicator, responseCache, flags, quarantine.guard)
+	if err := chains.build(cfg.MiddlewareChains); err != nil {
+		return err
+	}
+
+	// Identity and internal headers are only ever set by the gateway, so they
+	// are stripped from client requests before any configured rules
+	r.Use(middleware.StripTrustHeaders())
+
+	// Self-declared crawlers are verified by reverse DNS before any rate limit
+	if cfg.CrawlerVerification == "enforce" {
+		crawlers := slices.Clone(middleware.DefaultCrawlers)
+		for _, extra := range cfg.Crawlers {
+			crawler := middleware.Crawler{Name: extra.Name, UserAgent: extra.UserAgent, Domains: extra.Domains}
+			if i := slices.IndexFunc(crawlers, func(c middleware.Crawler) bool { return c.Name == extra.Name }); i >= 0 {
+				crawlers[i] = crawler
+			} else {
+				crawlers = append(crawlers, crawler)
+			}
+		}
+		verifier := middleware.NewCrawlerVerifier(crawlers, cfg.CrawlerRateLimitRPS, cfg.CrawlerRateLimitBurst, cfg.CrawlerVerifyTTL, logger)
+		r.Use(verifier.Verify())
+	}
+
+	// Request summaries of sampled users, looked up by support through the admin API
+	var timeline *middleware.Timeline
+	if cfg.TimelineSamplePercent > 0 {
+		timeline = middleware.NewTimeline(redisClient, deps.RedisHealth, middleware.TimelineOptions{
+			SamplePercent: cfg.TimelineSamplePercent,
+			Retention:     cfg.TimelineRetention,
+			MaxEntries:    cfg.TimelineMaxEntries,
+		}, logger)
+		r.Use(timeline.Record())
+	}
+
+	var transforms []middleware.HeaderTransform
+	for _, rule := range cfg.HeaderRules {
+		transforms = append(transforms, middleware.HeaderTransform{
+			Prefix:     rule.Path,
+			Request:    middleware.HeaderOps(rule.Request),
+			Response:   middleware.HeaderOps(rule.Response),
+			Experiment: rule.Experiment,
+			Variant:    rule.Variant,
+			Flag:       rule.Flag,
+		})
+	}
+	if len(transforms) > 0 {
+		r.Use(middleware.TransformHeaders(transforms))
+	}
+
+	// Normalized tracking consent (banner cookie and GPC) for every upstream
+	r.Use(middleware.Consent(cfg.ConsentCookie, cfg.ConsentDefault == "granted"))
 
-	// Apply rate limiting to all API routes
-	api.Use(rateLimiter.RateLimit())
+	// Deprecation and Sunset headers for routes nearing end-of-life
+	if len(cfg.Deprecations) > 0 {
+		notices := make([]middleware.Deprecation, len(cfg.Deprecations))
+		for i, notice := range cfg.Deprecations {
+			notices[i] = middleware.Deprecation{
+				Prefix:       notice.Path,
+				DeprecatedAt: notice.DeprecatedAt.Time,
+				Sunset:       notice.Sunset.Time,
+				Link:         notice.Link,
+			}
+		}
+		r.Use(middleware.Deprecations(notices))
+	}
+
+	// Requests matching a backend's OpenAPI spec are validated before proxying
+	if validator != nil {
+		r.Use(validator.Middleware())
+	}
+
+	// API version group; rate limited unless the config declares its chain
+	api := r.Group("/api/v1", chains.group("/api/v1", rateLimiter.RateLimit())...)
+
+	// A/B experiment buckets forwarded to backends; definitions are managed
+	// through the admin API
+	experiments := middleware.NewExperiments()
+	api.Use(experiments.Assign(cfg.JWTSecrets), flags.Evaluate(cfg.JWTSecrets))
+
+	// Signed-in users' privacy settings travel with their reads, from the
+	// per-user settings cache also serving the settings routes
+	settingsStore := settings.New(proxyHandler, deps.CacheStore, cfg.SettingsServiceURL, cfg.SettingsCacheTTL, logger)
+	api.Use(middleware.PrivacyContext(cfg.JWTSecrets, func(c *gin.Context, userID string) (string, bool) {
+		header := aggregate.ForwardHeaders(c)
+		header.Set("X-User-ID", userID)
+		privacy, ok := settingsStore.CachedPrivacy(c.Request.Context(), userID, header)
+		return privacy.Context(), ok
+	}))
+
+	// Follower lists and hashtag archives are shaped against bulk harvesting;
+	// the optional token tells signed-in users from anonymous clients
+	optionalAuth := middleware.OptionalJWTAuth(cfg.JWTSecrets)
+	scrapeGuard := middleware.NewScrapeGuard(middleware.ScrapeGuardOptions{
+		AnonMaxDepth: cfg.ScrapeAnonMaxDepth,
+		Streak:       cfg.ScrapeStreak,
+		StepInterval: cfg.ScrapeStepInterval,
+		Delay:        cfg.ScrapeDelay,
+		MaxDelay:     cfg.ScrapeMaxDelay,
+	}).Guard()
 
 	// ==================== Auth Service Routes ====================
 	// All auth routes - service handles authentication internally
-	auth := api.Group("/auth")
+	auth := api.Group("/auth", chains.group("/api/v1/auth")...)
 	{
 		// Public routes
 		auth.POST("/register", proxyHandler.ProxyRequest(cfg.AuthServiceURL))
-		auth.POST("/login", proxyHandler.ProxyRequest(cfg.AuthServiceURL))
+		auth.POST("/login", middleware.LoginEvents("password"), proxyHandler.ProxyRequest(cfg.AuthServiceURL))
 		auth.POST("/refresh", proxyHandler.ProxyRequest(cfg.AuthServiceURL))
 
+		// Password reset and email verification. Requests that send email are
+		// capped per address and per client across all instances, so nobody
+		// can bomb an inbox through them; token submissions per client.
+		quotas := middleware.NewQuotas(deps.RedisHealth, rateLimiter.ClientKey, logger)
+		recoveryEmails := func(name string) gin.HandlerFunc {
+			return quotas.Enforce(
+				middleware.QuotaRule{Name: name + "_email", Field: "email", Limit: cfg.RecoveryEmailsPerHour, Window: time.Hour},
+				middleware.QuotaRule{Name: name + "_client", Limit: cfg.RecoveryPerIPHour, Window: time.Hour},
+			)
+		}
+		recoveryTokens := quotas.Enforce(middleware.QuotaRule{Name: "recovery_token_client", Limit: cfg.RecoveryPerIPHour, Window: time.Hour})
+		auth.POST("/password-reset/request", recoveryEmails("password_reset"), proxyHandler.ProxyRequest(cfg.AuthServiceURL))
+		auth.POST("/password-reset/confirm", recoveryTokens, proxyHandler.ProxyRequest(cfg.AuthServiceURL))
+		auth.POST("/verify-email", recoveryTokens, proxyHandler.ProxyRequest(cfg.AuthServiceURL))
+		auth.POST("/verify-email/resend", recoveryEmails("verify_email"), proxyHandler.ProxyRequest(cfg.AuthServiceURL))
+
+		// Social login; the gateway owns the state parameter so a forged
+		// callback can't sign a victim into someone else's account
+		var providers []middleware.OAuthProvider
+		for _, provider := range middleware.DefaultOAuthProviders {
+			if slices.Contains(cfg.OAuthProviders, provider.Name) {
+				providers = append(providers, provider)
+			}
+		}
+		oauth := middleware.NewOAuth(providers, cfg.OAuthStateTTL)
+		auth.GET("/oauth/:provider/login", oauth.Login(), proxyHandler.ProxyRequest(cfg.AuthServiceURL))
+		auth.GET("/oauth/:provider/callback", middleware.LoginEvents("oauth"), oauth.Callback(), proxyHandler.ProxyRequest(cfg.AuthServiceURL))
+		auth.POST("/oauth/:provider/callback", middleware.LoginEvents("oauth"), oauth.Callback(), proxyHandler.ProxyRequest(cfg.AuthServiceURL))
+
 		// Protected routes (service validates JWT)
 		auth.GET("/profile", proxyHandler.ProxyRequest(cfg.AuthServiceURL))
 		auth.GET("/me", proxyHandler.ProxyRequest(cfg.AuthServiceURL))
@@ -43,12 +250,51 @@ func SetupRoutes(
 		auth.PUT("/password", proxyHandler.ProxyRequest(cfg.AuthServiceURL))
 	}
 
+	// Multipart bodies of upload routes are checked against the size limits
+	// while they stream in
+	uploadLimits := middleware.LimitUploads(middleware.UploadLimits{
+		MaxBytes:     cfg.UploadMaxBytes,
+		MaxPartBytes: cfg.UploadMaxPartBytes,
+		MaxParts:     cfg.UploadMaxParts,
+		MediaTypes:   cfg.UploadMediaTypes,
+		TypeMaxBytes: cfg.UploadTypeMaxBytes,
+	})
+
+	// Multi-step orchestrations are journaled, and ones interrupted by a
+	// crash are resumed or compensated by whichever replica notices first
+	sagas := journal.New(redisClient, journal.Options{
+		Lease:         cfg.JournalLease,
+		MaxRecoveries: cfg.JournalMaxRecoveries,
+	}, logger)
+	publish := &publisher{cfg: cfg, proxy: proxyHandler, journal: sagas, logger: logger}
+	sagas.Register(publish.saga())
+
+	// Resumable uploads are kept in a directory until complete
+	var uploads *resumableUploads
+	if cfg.TusDir != "" {
+		store, err := tus.NewStore(cfg.TusDir, cfg.TusExpiry, logger)
+		if err != nil {
+			return err
+		}
+		go store.Sweep(ctx)
+		uploads = &resumableUploads{cfg: cfg, store: store, proxy: proxyHandler, journal: sagas, logger: logger}
+		sagas.Register(uploads.saga())
+	}
+	deletion := &accountDeletion{cfg: cfg, proxy: proxyHandler, redis: redisClient, journal: sagas, logger: logger}
+	sagas.Register(deletion.saga())
+	go sagas.Recover(ctx)
+
 	// ==================== Media Service Routes ====================
 	// All media routes - service handles authentication internally
-	media := api.Group("/media")
+	media := api.Group("/media", chains.group("/api/v1/media")...)
 	{
-		// Upload media
-		media.POST("/upload", proxyHandler.ProxyRequest(cfg.MediaServiceURL))
+		// Upload media, streamed to the service as it arrives; oversized uploads
+		// and files that aren't images or videos are rejected as soon as they show it
+		media.POST("/upload", uploadLimits, proxyHandler.StreamRequest(cfg.MediaServiceURL))
+
+		// Signed URL to upload straight to storage, bypassing the gateway
+		signed := &signedUploads{cfg: cfg, proxy: proxyHandler, logger: logger}
+		media.POST("/upload-urls", middleware.JWTAuth(cfg.JWTSecrets), signed.mint)
 
 		// Get media
 		media.GET("/:id", proxyHandler.ProxyRequest(cfg.MediaServiceURL))
@@ -58,40 +304,55 @@ func SetupRoutes(
 
 		// Get user's media
 		media.GET("/user/:user_id", proxyHandler.ProxyRequest(cfg.MediaServiceURL))
+
+		// Resumable uploads (tus protocol), handed to the media service once complete
+		if uploads != nil {
+			tusUploads := media.Group("/uploads", middleware.JWTAuth(cfg.JWTSecrets), uploads.versioned)
+			tusUploads.POST("", uploads.create)
+			tusUploads.HEAD("/:upload_id", uploads.head)
+			tusUploads.PATCH("/:upload_id", uploads.patch)
+			tusUploads.DELETE("/:upload_id", uploads.terminate)
+			media.GET("/uploads/:upload_id", middleware.JWTAuth(cfg.JWTSecrets), uploads.progress)
+		}
 	}
 
 	// ==================== Post Service Routes ====================
 	// All post routes - service handles authentication internally
-	posts := api.Group("/posts")
+	posts := api.Group("/posts", chains.group("/api/v1/posts")...)
 	{
 		// Read operations
 		posts.GET("/:id", proxyHandler.ProxyRequest(cfg.PostServiceURL))
 		posts.GET("", proxyHandler.ProxyRequest(cfg.PostServiceURL))
 		posts.GET("/user/:user_id", proxyHandler.ProxyRequest(cfg.PostServiceURL))
-		posts.GET("/hashtag/:hashtag", proxyHandler.ProxyRequest(cfg.PostServiceURL))
+		posts.GET("/hashtag/:hashtag", optionalAuth, scrapeGuard, proxyHandler.ProxyRequest(cfg.PostServiceURL))
+
+		// Write operations (service validates JWT). New posts of business
+		// accounts are sent to subscribed partners as webhooks.
+		postCreated := middleware.WebhookEvent(deps.Webhooks.Publish, webhooks.EventPostCreated, logger)
+		posts.POST("", optionalAuth, postCreated, proxyHandler.ProxyRequest(cfg.PostServiceURL))
 
-		// Write operations (service validates JWT)
-		posts.POST("", proxyHandler.ProxyRequest(cfg.PostServiceURL))
+		// Post and media in one request, as a journaled saga
+		posts.POST("/with-media", middleware.JWTAuth(cfg.JWTSecrets), postCreated, uploadLimits, publish.publish)
 		posts.PUT("/:id", proxyHandler.ProxyRequest(cfg.PostServiceURL))
 		posts.DELETE("/:id", proxyHandler.ProxyRequest(cfg.PostServiceURL))
 
 		// Like/unlike
-		posts.POST("/:id/like", proxyHandler.ProxyRequest(cfg.PostServiceURL))
-		posts.DELETE("/:id/like", proxyHandler.ProxyRequest(cfg.PostServiceURL))
+		posts.POST("/:id/like", dedup, proxyHandler.ProxyRequest(cfg.PostServiceURL))
+		posts.DELETE("/:id/like", dedup, proxyHandler.ProxyRequest(cfg.PostServiceURL))
 
 		// Comments
-		posts.POST("/:id/comments", proxyHandler.ProxyRequest(cfg.PostServiceURL))
+		posts.POST("/:id/comments", dedup, proxyHandler.ProxyRequest(cfg.PostServiceURL))
 		posts.GET("/:id/comments", proxyHandler.ProxyRequest(cfg.PostServiceURL))
 		posts.DELETE("/:id/comments/:comment_id", proxyHandler.ProxyRequest(cfg.PostServiceURL))
 	}
 
 	// ==================== Graph Service Routes ====================
 	// All graph routes - service handles authentication internally
-	graph := api.Group("/graph")
+	graph := api.Group("/graph", chains.group("/api/v1/graph")...)
 	{
 		// Follow/unfollow
-		graph.POST("/follow/:user_id", proxyHandler.ProxyRequest(cfg.GraphServiceURL))
-		graph.DELETE("/follow/:user_id", proxyHandler.ProxyRequest(cfg.GraphServiceURL))
+		graph.POST("/follow/:user_id", dedup, proxyHandler.ProxyRequest(cfg.GraphServiceURL))
+		graph.DELETE("/follow/:user_id", dedup, proxyHandler.ProxyRequest(cfg.GraphServiceURL))
 
 		// Follow requests (for private accounts)
 		graph.GET("/follow-requests", proxyHandler.ProxyRequest(cfg.GraphServiceURL))
@@ -99,8 +360,8 @@ func SetupRoutes(
 		graph.POST("/follow-requests/:request_id/reject", proxyHandler.ProxyRequest(cfg.GraphServiceURL))
 
 		// Get followers/following
-		graph.GET("/followers/:user_id", proxyHandler.ProxyRequest(cfg.GraphServiceURL))
-		graph.GET("/following/:user_id", proxyHandler.ProxyRequest(cfg.GraphServiceURL))
+		graph.GET("/followers/:user_id", optionalAuth, scrapeGuard, proxyHandler.ProxyRequest(cfg.GraphServiceURL))
+		graph.GET("/following/:user_id", optionalAuth, scrapeGuard, proxyHandler.ProxyRequest(cfg.GraphServiceURL))
 
 		// Check relationship
 		graph.GET("/relationship/:user_id", proxyHandler.ProxyRequest(cfg.GraphServiceURL))
@@ -114,10 +375,11 @@ func SetupRoutes(
 
 	// ==================== Newsfeed Service Routes ====================
 	// All feed routes - service handles authentication internally
-	feed := api.Group("/feed")
+	feed := api.Group("/feed", chains.group("/api/v1/feed")...)
 	{
 		// Get personalized feed
-		feed.GET("", proxyHandler.ProxyRequest(cfg.NewsfeedServiceURL))
+		feed.GET("", responseCache.Cache(middleware.CacheOptions{TTL: cfg.FeedCacheTTL, PerUser: true}),
+			proxyHandler.ProxyRequest(cfg.NewsfeedServiceURL))
 
 		// Refresh feed
 		feed.POST("/refresh", proxyHandler.ProxyRequest(cfg.NewsfeedServiceURL))
@@ -126,9 +388,477 @@ func SetupRoutes(
 		feed.GET("/stats", proxyHandler.ProxyRequest(cfg.NewsfeedServiceURL))
 	}
 
+	// ==================== Messaging Service Routes ====================
+	// Direct messages; the gateway needs the verified user ID to limit how
+	// fast one user can post into one conversation
+	conversationLimiter := middleware.NewRateLimiter(cfg.MessageRateLimitRPS, cfg.MessageRateLimitBurst, cfg.RateLimitIPv6Prefix)
+	conversationLimit := conversationLimiter.ConversationRateLimit("thread_id")
+	messages := api.Group("/messages", middleware.JWTAuth(cfg.JWTSecrets))
+	messages.Use(chains.group("/api/v1/messages")...)
+	{
+		// Threads
+		messages.GET("/threads", proxyHandler.ProxyRequest(cfg.MessagingServiceURL))
+		messages.POST("/threads", dedup, proxyHandler.ProxyRequest(cfg.MessagingServiceURL))
+
+		// Message history
+		messages.GET("/threads/:thread_id", proxyHandler.ProxyRequest(cfg.MessagingServiceURL))
+		messages.GET("/threads/:thread_id/messages", proxyHandler.ProxyRequest(cfg.MessagingServiceURL))
+
+		// Send a message
+		messages.POST("/threads/:thread_id/messages", conversationLimit, dedup, proxyHandler.ProxyRequest(cfg.MessagingServiceURL))
+
+		// Typing indicator and read receipt
+		messages.POST("/threads/:thread_id/typing", conversationLimit, proxyHandler.ProxyRequest(cfg.MessagingServiceURL))
+		messages.POST("/threads/:thread_id/read", proxyHandler.ProxyRequest(cfg.MessagingServiceURL))
+
+		// Realtime channel: new messages, typing and receipts pushed over a WebSocket
+		messages.GET("/ws", proxyHandler.ProxyWebSocket(cfg.MessagingServiceURL))
+	}
+
+	// ==================== Story Service Routes ====================
+	// The tray is read on every app open, so it's cached per user for long;
+	// the user's own writes drop their cached tray so they see them at once
+	stories := api.Group("/stories", middleware.JWTAuth(cfg.JWTSecrets))
+	stories.Use(chains.group("/api/v1/stories")...)
+	{
+		trayChanged := responseCache.Invalidate("/api/v1/stories/tray")
+
+		// Stories tray of followed accounts, with seen state
+		stories.GET("/tray", responseCache.Cache(middleware.CacheOptions{TTL: cfg.StoryTrayCacheTTL, PerUser: true}),
+			proxyHandler.ProxyRequest(cfg.StoryServiceURL))
+
+		// Post and delete a story
+		stories.POST("", trayChanged, dedup, proxyHandler.ProxyRequest(cfg.StoryServiceURL))
+		stories.DELETE("/:story_id", trayChanged, proxyHandler.ProxyRequest(cfg.StoryServiceURL))
+
+		// Mark viewed, and who viewed (owner only, enforced by the service)
+		stories.POST("/:story_id/view", trayChanged, proxyHandler.ProxyRequest(cfg.StoryServiceURL))
+		stories.GET("/:story_id/viewers", proxyHandler.ProxyRequest(cfg.StoryServiceURL))
+	}
+
+	// ==================== Search Service Routes ====================
+	// Search-as-you-type sends a request per keystroke; a per-user limit of
+	// its own and query validation keep that off the search cluster
+	searchLimiter := middleware.NewRateLimiter(cfg.SearchRateLimitRPS, cfg.SearchRateLimitBurst, cfg.RateLimitIPv6Prefix)
+	search := api.Group("/search", middleware.JWTAuth(cfg.JWTSecrets), middleware.SearchQuery(cfg.SearchQueryMaxLength))
+	search.Use(chains.group("/api/v1/search", searchLimiter.UserRateLimit())...)
+	{
+		search.GET("/top", proxyHandler.ProxyRequest(cfg.SearchServiceURL))
+		search.GET("/users", proxyHandler.ProxyRequest(cfg.SearchServiceURL))
+		search.GET("/hashtags", proxyHandler.ProxyRequest(cfg.SearchServiceURL))
+		search.GET("/places", proxyHandler.ProxyRequest(cfg.SearchServiceURL))
+	}
+
+	// ==================== Shop Routes ====================
+	// Product catalogs, product tags and checkout. Card data never passes
+	// through the gateway: the commerce service hands out sessions of the
+	// payment processor. The group still only takes modern TLS, and its
+	// headers are scrubbed both ways so nothing cardholder-related leaks.
+	shopGuards := []gin.HandlerFunc{middleware.PCIScrub()}
+	if minVersion, ok := middleware.TLSVersions[cfg.ShopTLSMinVersion]; ok {
+		shopGuards = append([]gin.HandlerFunc{middleware.RequireTLS(minVersion, cfg.TLSVersionHeader)}, shopGuards...)
+	}
+	shop := api.Group("/shop", shopGuards...)
+	shop.Use(chains.group("/api/v1/shop")...)
+	{
+		shop.GET("/catalogs/:catalog_id", optionalAuth, proxyHandler.ProxyRequest(cfg.CommerceServiceURL))
+		shop.GET("/catalogs/:catalog_id/products", optionalAuth, proxyHandler.ProxyRequest(cfg.CommerceServiceURL))
+		shop.GET("/products/:product_id", optionalAuth, proxyHandler.ProxyRequest(cfg.CommerceServiceURL))
+
+		// Products tagged on a post; only verified businesses tag products
+		shop.GET("/posts/:post_id/products", optionalAuth, proxyHandler.ProxyRequest(cfg.CommerceServiceURL))
+		shop.PUT("/posts/:post_id/products", middleware.JWTAuth(cfg.JWTSecrets), middleware.VerifiedBusiness(),
+			dedup, proxyHandler.ProxyRequest(cfg.CommerceServiceURL))
+
+		// Checkout sessions at the payment processor
+		shop.POST("/checkout/sessions", middleware.JWTAuth(cfg.JWTSecrets), dedup,
+			proxyHandler.ProxyRequest(cfg.CommerceServiceURL))
+	}
+
+	// ==================== Location Service Routes ====================
+	// Places and geotagged posts. Geo queries are costly for the location
+	// service, so they are validated and get a per-user limit of their own.
+	geoLimit := middleware.NewRateLimiter(cfg.LocationRateLimitRPS, cfg.LocationRateLimitBurst, cfg.RateLimitIPv6Prefix).UserRateLimit()
+	locations := api.Group("/locations", optionalAuth)
+	locations.Use(chains.group("/api/v1/locations")...)
+	{
+		locations.GET("/search", middleware.SearchQuery(cfg.SearchQueryMaxLength),
+			middleware.GeoQuery(false, cfg.LocationMaxRadius), geoLimit, proxyHandler.ProxyRequest(cfg.LocationServiceURL))
+		locations.GET("/nearby", middleware.GeoQuery(true, cfg.LocationMaxRadius), geoLimit,
+			proxyHandler.ProxyRequest(cfg.LocationServiceURL))
+		locations.GET("/:location_id", proxyHandler.ProxyRequest(cfg.LocationServiceURL))
+
+		// Location archives are shaped against bulk harvesting like hashtags
+		locations.GET("/:location_id/posts", scrapeGuard, proxyHandler.ProxyRequest(cfg.LocationServiceURL))
+	}
+
+	// ==================== Reels Service Routes ====================
+	// Reels can be watched signed out; players seek with Range requests,
+	// streamed as they arrive, and may take far longer than an API call
+	reels := api.Group("/reels", optionalAuth)
+	reels.Use(chains.group("/api/v1/reels")...)
+	{
+		// Reels feed and a reel's metadata
+		reels.GET("", proxyHandler.ProxyRequest(cfg.ReelsServiceURL))
+		reels.GET("/:reel_id", proxyHandler.ProxyRequest(cfg.ReelsServiceURL))
+
+		// Video, by byte range
+		reels.GET("/:reel_id/video", middleware.LongLived(cfg.ReelsStreamTimeout), proxyHandler.ProxyRequest(cfg.ReelsServiceURL))
+
+		// Publish a reel's metadata once its video is uploaded through media
+		reels.POST("", middleware.JWTAuth(cfg.JWTSecrets), dedup, proxyHandler.ProxyRequest(cfg.ReelsServiceURL))
+
+		// Likes and shares
+		reels.POST("/:reel_id/like", middleware.JWTAuth(cfg.JWTSecrets), dedup, proxyHandler.ProxyRequest(cfg.ReelsServiceURL))
+		reels.DELETE("/:reel_id/like", middleware.JWTAuth(cfg.JWTSecrets), dedup, proxyHandler.ProxyRequest(cfg.ReelsServiceURL))
+		reels.POST("/:reel_id/share", middleware.JWTAuth(cfg.JWTSecrets), dedup, proxyHandler.ProxyRequest(cfg.ReelsServiceURL))
+	}
+
+	// ==================== Live Service Routes ====================
+	// Broadcasts are started through the gateway so only authenticated users
+	// get a stream key; watching works signed out
+	live := api.Group("/live", optionalAuth)
+	live.Use(chains.group("/api/v1/live")...)
+	{
+		// Start a broadcast: stream key and RTMP ingest endpoints
+		broadcasts := &liveStreams{cfg: cfg, proxy: proxyHandler, logger: logger}
+		live.POST("", middleware.JWTAuth(cfg.JWTSecrets), dedup, broadcasts.start)
+
+		// End a broadcast (the broadcaster only, enforced by the service)
+		live.POST("/:stream_id/stop", middleware.JWTAuth(cfg.JWTSecrets), dedup, proxyHandler.ProxyRequest(cfg.LiveServiceURL))
+
+		// Broadcast details, viewers and comments
+		live.GET("/:stream_id", proxyHandler.ProxyRequest(cfg.LiveServiceURL))
+		live.GET("/:stream_id/viewers", proxyHandler.ProxyRequest(cfg.LiveServiceURL))
+		live.GET("/:stream_id/comments", proxyHandler.ProxyRequest(cfg.LiveServiceURL))
+		live.POST("/:stream_id/comments", middleware.JWTAuth(cfg.JWTSecrets), dedup, proxyHandler.ProxyRequest(cfg.LiveServiceURL))
+	}
+
+	// ==================== Trends Routes ====================
+	// Trends are the same for everyone in a region, so one cached copy per
+	// region serves all of its users
+	trends := api.Group("/trends", middleware.Region(cfg.RegionHeader))
+	trends.Use(chains.group("/api/v1/trends", responseCache.Cache(middleware.CacheOptions{TTL: cfg.TrendsCacheTTL}))...)
+	{
+		trends.GET("/hashtags", proxyHandler.ProxyRequest(cfg.TrendsServiceURL))
+		trends.GET("/audio", proxyHandler.ProxyRequest(cfg.TrendsServiceURL))
+
+		// Everything trending in the region
+		trends.GET("/regional", proxyHandler.ProxyRequest(cfg.TrendsServiceURL))
+	}
+
+	// ==================== Report Routes ====================
+	// Reports feed human review queues, so they are only taken from signed-in
+	// users, and few per user, to keep mass reporting from flooding reviewers.
+	// Neither can be replaced by a configured chain.
+	reportLimiter := middleware.NewRateLimiterPer(cfg.ReportsPerHour, time.Hour, cfg.ReportsBurst, cfg.RateLimitIPv6Prefix)
+	reportLimit := reportLimiter.UserRateLimit()
+	reports := api.Group("/reports", middleware.JWTAuth(cfg.JWTSecrets))
+	reports.Use(chains.group("/api/v1/reports")...)
+	{
+		reports.POST("/posts/:post_id", reportLimit, dedup, proxyHandler.ProxyRequest(cfg.ModerationServiceURL))
+		reports.POST("/comments/:comment_id", reportLimit, dedup, proxyHandler.ProxyRequest(cfg.ModerationServiceURL))
+		reports.POST("/users/:user_id", reportLimit, dedup, proxyHandler.ProxyRequest(cfg.ModerationServiceURL))
+
+		// The user's own reports and their review status
+		reports.GET("", proxyHandler.ProxyRequest(cfg.ModerationServiceURL))
+	}
+
+	// ==================== Presence Service Routes ====================
+	// Online status. Each open channel holds a connection on this instance
+	// and one on the presence service, so users get only a few at a time.
+	presenceChannels := middleware.NewConnectionLimit("presence", cfg.PresenceMaxConnections).Limit()
+	presence := api.Group("/presence", middleware.JWTAuth(cfg.JWTSecrets))
+	presence.Use(chains.group("/api/v1/presence")...)
+	{
+		// Status of a batch of users (?user_ids=) or one user
+		presence.GET("", proxyHandler.ProxyRequest(cfg.PresenceServiceURL))
+		presence.GET("/users/:user_id", proxyHandler.ProxyRequest(cfg.PresenceServiceURL))
+
+		// Keep the user shown as active from clients without a channel open
+		presence.POST("/heartbeat", proxyHandler.ProxyRequest(cfg.PresenceServiceURL))
+
+		// Status changes of followed users, over a WebSocket or as server-sent events
+		presence.GET("/ws", presenceChannels, proxyHandler.ProxyWebSocket(cfg.PresenceServiceURL))
+		presence.GET("/events", presenceChannels, middleware.LongLived(cfg.PresenceStreamTimeout),
+			proxyHandler.ProxyRequest(cfg.PresenceServiceURL))
+	}
+
+	// ==================== Push Device Routes ====================
+	// APNs and FCM tokens, kept by the notification service
+	devicesGroup := api.Group("/devices", middleware.JWTAuth(cfg.JWTSecrets))
+	devicesGroup.Use(chains.group("/api/v1/devices")...)
+	{
+		pushDevices := &devices{cfg: cfg, proxy: proxyHandler, redis: redisClient, logger: logger}
+		devicesGroup.GET("", proxyHandler.ProxyRequest(cfg.NotificationServiceURL))
+		devicesGroup.POST("", dedup, pushDevices.register)
+		devicesGroup.DELETE("/:device_id", proxyHandler.ProxyRequest(cfg.NotificationServiceURL))
+	}
+
+	// ==================== Account Routes ====================
+	// Data exports (GDPR). Downloads are authorized by their signed URL
+	// rather than a token, so they can be fetched straight from a browser.
+	// Deletion clears the account from every service as a journaled saga.
+	account := api.Group("/account", chains.group("/api/v1/account")...)
+	{
+		account.DELETE("", middleware.JWTAuth(cfg.JWTSecrets), dedup, deletion.start)
+		account.GET("/deletion", middleware.JWTAuth(cfg.JWTSecrets), deletion.status)
+
+		exports := &accountExports{cfg: cfg, proxy: proxyHandler, logger: logger}
+		account.POST("/export", middleware.JWTAuth(cfg.JWTSecrets), dedup, proxyHandler.ProxyRequest(cfg.AccountServiceURL))
+		account.GET("/export/:job_id", middleware.JWTAuth(cfg.JWTSecrets),
+			middleware.LongLived(cfg.ExportMaxWait+cfg.ProxyTimeout), exports.status)
+		account.GET("/export/:job_id/download", exports.download,
+			middleware.LongLived(cfg.ExportDownloadTimeout), proxyHandler.ProxyRequest(cfg.AccountServiceURL))
+	}
+
+	// ==================== Ads Service Routes ====================
+	// Outside the /api/v1 group so ads never draw on the organic rate limit;
+	// only verified business accounts may reach the ads service
+	adsLimiter := middleware.NewRateLimiter(cfg.AdsRateLimitRPS, cfg.AdsRateLimitBurst, cfg.RateLimitIPv6Prefix)
+	ads := r.Group("/api/v1/ads", middleware.JWTAuth(cfg.JWTSecrets), middleware.VerifiedBusiness())
+	ads.Use(chains.group("/api/v1/ads", adsLimiter.UserRateLimit())...)
+	{
+		ads.Any("", proxyHandler.ProxyRequest(cfg.AdsServiceURL))
+		ads.Any("/*path", proxyHandler.ProxyRequest(cfg.AdsServiceURL))
+	}
+
+	// ==================== Analytics Routes ====================
+	// Events from users who haven't consented to analytics are dropped here
+	analytics := api.Group("/analytics", chains.group("/api/v1/analytics")...)
+	{
+		analytics.POST("/events", middleware.RequireConsent(middleware.ConsentAnalytics),
+			proxyHandler.ProxyRequest(cfg.AnalyticsServiceURL))
+	}
+
+	// ==================== Client Event Routes ====================
+	// Batched clickstream events go straight to Kafka; signed-in users'
+	// events carry their user ID
+	clientEventsHandler := &clientEvents{cfg: cfg, publisher: deps.Events}
+	clientEventsGroup := api.Group("/events", chains.group("/api/v1/events")...)
+	{
+		clientEventsGroup.POST("", optionalAuth, middleware.RequireConsent(middleware.ConsentAnalytics),
+			clientEventsHandler.ingest)
+	}
+
+	// ==================== Settings Routes ====================
+	// Account settings and preferences; reads are cached per user, so the
+	// gateway needs the verified user ID
+	prefs := &settingsHandler{
+		store:    settingsStore,
+		proxy:    proxyHandler,
+		upstream: cfg.SettingsServiceURL,
+	}
+	settingsGroup := api.Group("/settings", middleware.JWTAuth(cfg.JWTSecrets))
+	settingsGroup.Use(chains.group("/api/v1/settings")...)
+	{
+		// Notification preferences
+		settingsGroup.GET("/notifications", prefs.get(settings.SectionNotifications))
+		settingsGroup.PUT("/notifications", prefs.write(settings.SectionNotifications))
+
+		// Privacy settings, as a whole or one control at a time: private
+		// account, story visibility, comment and mention controls
+		settingsGroup.GET("/privacy", prefs.get(settings.SectionPrivacy))
+		settingsGroup.PUT("/privacy", prefs.write(settings.SectionPrivacy))
+		for _, control := range []string{"/account", "/stories", "/comments", "/mentions"} {
+			settingsGroup.GET("/privacy"+control, proxyHandler.ProxyRequest(cfg.SettingsServiceURL))
+			settingsGroup.PUT("/privacy"+control, prefs.write(settings.SectionPrivacy))
+		}
+
+		// Blocked accounts
+		settingsGroup.GET("/blocked", prefs.get(settings.SectionBlocked))
+		settingsGroup.POST("/blocked/:user_id", dedup, prefs.write(settings.SectionBlocked))
+		settingsGroup.DELETE("/blocked/:user_id", dedup, prefs.write(settings.SectionBlocked))
+
+		// Language
+		settingsGroup.GET("/language", prefs.get(settings.SectionLanguage))
+		settingsGroup.PUT("/language", prefs.write(settings.SectionLanguage))
+	}
+
+	// ==================== Notification Service Routes ====================
+	// Inbox routes - service handles authentication internally. Preferences
+	// are the settings section, served through its cache.
+	notifications := api.Group("/notifications", chains.group("/api/v1/notifications")...)
+	{
+		// List notifications
+		notifications.GET("", proxyHandler.ProxyRequest(cfg.NotificationServiceURL))
+
+		// Unread counts
+		notifications.GET("/counts", proxyHandler.ProxyRequest(cfg.NotificationServiceURL))
+
+		// Mark read
+		notifications.POST("/:notification_id/read", dedup, proxyHandler.ProxyRequest(cfg.NotificationServiceURL))
+		notifications.POST("/read-all", dedup, proxyHandler.ProxyRequest(cfg.NotificationServiceURL))
+
+		// Preferences
+		notifications.GET("/preferences", middleware.JWTAuth(cfg.JWTSecrets),
+			prefs.at("/api/v1/settings/notifications", prefs.get(settings.SectionNotifications)))
+		notifications.PUT("/preferences", middleware.JWTAuth(cfg.JWTSecrets),
+			prefs.at("/api/v1/settings/notifications", prefs.write(settings.SectionNotifications)))
+	}
+
+	// ==================== Insights Routes ====================
+	// Aggregated across services; the gateway needs the verified user ID.
+	// Which upstream calls may fail, and their fallbacks, come from config.
+	policies, err := degradationPolicies(cfg.Degradation)
+	if err != nil {
+		return err
+	}
+	aggregator := aggregate.New(proxyHandler, policies, logger)
+	insights := &insightsHandler{
+		cfg:        cfg,
+		aggregator: aggregator,
+		store:      deps.CacheStore,
+		redis:      redisClient,
+		logger:     logger,
+		ctx:        ctx,
+	}
+	insightsGroup := api.Group("/insights", chains.group("/api/v1/insights")...)
+	{
+		insightsGroup.GET("/me", middleware.JWTAuth(cfg.JWTSecrets), insights.me)
+
+		// Detailed analytics are for creator and business accounts only
+		creatorAnalytics := []gin.HandlerFunc{
+			middleware.JWTAuth(cfg.JWTSecrets),
+			middleware.RequireRole("creator", "business"),
+			responseCache.Cache(middleware.CacheOptions{TTL: cfg.InsightsRefresh, PerUser: true}),
+			proxyHandler.ProxyRequest(cfg.AnalyticsServiceURL),
+		}
+		insightsGroup.GET("/posts/:post_id/reach", creatorAnalytics...)
+		insightsGroup.GET("/profile-visits", creatorAnalytics...)
+		insightsGroup.GET("/followers/growth", creatorAnalytics...)
+	}
+
+	// ==================== BFF Routes ====================
+	// Screen-shaped composites fanned out to several services in parallel
+	bff := &bffHandler{
+		cfg:        cfg,
+		aggregator: aggregator,
+		logger:     logger,
+	}
+	bffGroup := api.Group("/bff", chains.group("/api/v1/bff")...)
+	{
+		bffGroup.GET("/home", middleware.JWTAuth(cfg.JWTSecrets), bff.home)
+		bffGroup.GET("/posts/:id", middleware.OptionalJWTAuth(cfg.JWTSecrets), bff.postDetail)
+	}
+
+	// ==================== Batch Routes ====================
+	// Several API calls in one round trip, each dispatched through this router
+	if cfg.BatchMaxRequests > 0 {
+		batch := &batchHandler{
+			engine:      r,
+			maxRequests: cfg.BatchMaxRequests,
+			concurrency: cfg.BatchConcurrency,
+			logger:      logger,
+		}
+		api.Group("/batch", chains.group("/api/v1/batch")...).POST("", batch.serve)
+	}
+
+	// ==================== Payment Routes ====================
+	// Provider webhooks are authenticated by signature, not JWT
+	payments := &paymentWebhooks{
+		cfg:    cfg,
+		proxy:  proxyHandler,
+		redis:  redisClient,
+		logger: logger,
+	}
+	paymentsGroup := api.Group("/payments", chains.group("/api/v1/payments")...)
+	{
+		paymentsGroup.POST("/webhooks/:provider", payments.receive)
+	}
+
 	// ==================== Admin Routes ====================
-	// Admin routes - authentication handled here for gateway management
-	admin := api.Group("/admin")
+	// Admin routes - authentication handled here for gateway management. With
+	// a management listener they are served there, not on the public API.
+	admin := api.Group("/admin", chains.group("/api/v1/admin")...)
+	if deps.Management != nil {
+		admin = deps.Management.Group("/api/v1/admin", chains.group("/api/v1/admin")...)
+	}
+
+	// Authenticated management actions are recorded as audit events, and
+	// every call, refused ones included, in the tamper-evident audit log
+	adminAuth := []gin.HandlerFunc{
+		middleware.AuditLog(deps.AuditLog, auditlog.CategoryAdmin),
+		middleware.AdminAuth(cfg.CurrentAdminToken),
+		middleware.Audit(deps.Outbox, logger),
+	}
+	routeAdminAuth := []gin.HandlerFunc{
+		middleware.AuditLog(deps.AuditLog, auditlog.CategoryRoute),
+		middleware.AdminAuth(cfg.CurrentAdminToken),
+		middleware.Audit(deps.Outbox, logger),
+	}
+
+	// Runtime route management
+	dynamic := newDynamicRoutes(redisClient, cfg.ServiceURLs(), proxyHandler, rateLimiter, experiments, flags, logger)
+	go dynamic.sync(ctx)
+	dynamic.registerAdmin(admin.Group("", routeAdminAuth...))
+
+	// Blue-green switchover with automatic rollback
+	switches := newBlueGreen(redisClient, proxyHandler, cfg, logger)
+	go switches.sync(ctx)
+	switches.registerAdmin(admin.Group("", routeAdminAuth...))
+
+	// Per-service maintenance mode, short-circuiting the service's routes
+	maintenanceMode := newMaintenance(redisClient, proxyHandler, cfg, logger)
+	go maintenanceMode.sync(ctx)
+	maintenanceMode.registerAdmin(admin.Group("", routeAdminAuth...))
+
+	// Per-user request timelines for support investigations
+	if timeline != nil {
+		timelines := &timelineAdmin{timeline: timeline, logger: logger}
+		timelines.registerAdmin(admin.Group("", adminAuth...))
+	}
+
+	// Route quarantines raised by anomalous upstream responses
+	quarantine.registerAdmin(admin.Group("", adminAuth...))
+
+	// Outbox backlog and dead-letter replay
+	if deps.Outbox != nil {
+		outboxes := &outboxAdmin{outbox: deps.Outbox, logger: logger}
+		outboxes.registerAdmin(admin.Group("", adminAuth...))
+	}
+
+	// Partner webhook subscriptions
+	if deps.Webhooks != nil {
+		hooks := &webhookAdmin{manager: deps.Webhooks, logger: logger}
+		hooks.registerAdmin(admin.Group("", adminAuth...))
+	}
+
+	// Audit log of admin and security-sensitive operations
+	if deps.AuditLog != nil {
+		auditLogs := &auditLogAdmin{log: deps.AuditLog, logger: logger}
+		auditLogs.registerAdmin(admin.Group("", adminAuth...))
+	}
+
+	// Moderation console for staff, signed in with their own accounts: every
+	// request, including reads and refused ones, is audited with who made it
+	moderation := admin.Group("/moderation",
+		middleware.AuditLog(deps.AuditLog, auditlog.CategoryAdmin),
+		middleware.AuditAll(deps.Outbox, "moderation", logger),
+		middleware.JWTAuth(cfg.JWTSecrets),
+		middleware.RequireRole("admin", "moderator"),
+	)
+	{
+		adminOnly := middleware.RequireRole("admin")
+
+		// Review queue of reported content
+		moderation.GET("/queue", proxyHandler.ProxyRequest(cfg.ModerationServiceURL))
+		moderation.GET("/queue/:report_id", proxyHandler.ProxyRequest(cfg.ModerationServiceURL))
+		moderation.POST("/queue/:report_id/resolve", dedup, proxyHandler.ProxyRequest(cfg.ModerationServiceURL))
+
+		// Take content down; reversing a take-down is for admins
+		moderation.POST("/takedowns", dedup, proxyHandler.ProxyRequest(cfg.ModerationServiceURL))
+		moderation.DELETE("/takedowns/:takedown_id", adminOnly, proxyHandler.ProxyRequest(cfg.ModerationServiceURL))
+
+		// Bans are for admins
+		moderation.POST("/bans", adminOnly, dedup, proxyHandler.ProxyRequest(cfg.ModerationServiceURL))
+		moderation.DELETE("/bans/:user_id", adminOnly, proxyHandler.ProxyRequest(cfg.ModerationServiceURL))
+
+		// Appeals against take-downs and bans
+		moderation.GET("/appeals", proxyHandler.ProxyRequest(cfg.ModerationServiceURL))
+		moderation.POST("/appeals/:appeal_id/decision", dedup, proxyHandler.ProxyRequest(cfg.ModerationServiceURL))
+	}
+
 	{
 		// Gateway stats (public for monitoring)
 		admin.GET("/stats", func(c *gin.Context) {
@@ -138,24 +868,78 @@ func SetupRoutes(
 			})
 		})
 
+		// Build and config generation info
+		admin.GET("/version", func(c *gin.Context) {
+			info := version.Get()
+			c.JSON(http.StatusOK, gin.H{
+				"commit":      info.Commit,
+				"build_time":  info.BuildTime,
+				"go_version":  info.GoVersion,
+				"features":    cfg.EnabledFeatures(),
+				"config_hash": cfg.Fingerprint(),
+			})
+		})
+
 		// Service health checks (public for monitoring)
 		admin.GET("/health/services", func(c *gin.Context) {
 			c.JSON(http.StatusOK, gin.H{
-				"services": gin.H{
-					"auth":     cfg.AuthServiceURL,
-					"media":    cfg.MediaServiceURL,
-					"post":     cfg.PostServiceURL,
-					"graph":    cfg.GraphServiceURL,
-					"newsfeed": cfg.NewsfeedServiceURL,
-				},
+				"services": cfg.ServiceURLs(),
 			})
 		})
 	}
 
+	// ==================== Well-known Routes ====================
+	setupWellKnownRoutes(r, cfg, logger)
+
+	// ==================== Error Catalog ====================
+	// Error codes are only issued in the problem details format
+	var catalog []middleware.ErrorCatalogEntry
+	if cfg.ErrorFormat == "problem" {
+		catalog = errorCatalog(cfg)
+		serveErrorCatalog(api, catalog)
+	}
+
+	// ==================== API Docs ====================
+	setupDocsRoutes(r, cfg, catalog, proxyHandler, logger)
+
+	// ==================== GraphQL ====================
+	// Optional facade over the REST backends for web clients
+	if cfg.GraphQLFacade == "on" {
+		facade := newGraphQLFacade(cfg, proxyHandler, logger)
+		gql := r.Group("/api/graphql", chains.group("/api/graphql", rateLimiter.RateLimit())...)
+		gql.Use(middleware.OptionalJWTAuth(cfg.JWTSecrets))
+		{
+			gql.GET("", facade.serve)
+			gql.POST("", facade.serve)
+			gql.GET("/schema", facade.serveSchema)
+		}
+	}
+
+	// ==================== API v2 Routes ====================
+	// Declared in the config file; each endpoint may map to a different
+	// upstream service or path than its v1 counterpart. Config routes apply
+	// their own rate limit.
+	v2 := r.Group("/api/v2", chains.group("/api/v2")...)
+	v2.Use(experiments.Assign(cfg.JWTSecrets), flags.Evaluate(cfg.JWTSecrets))
+	if err := setupConfigRoutes(v2, cfg.APIv2, cfg, logger, rateLimiter, proxyHandler, deduplicator, responseCache, chains); err != nil {
+		return err
+	}
+
+	// ==================== Config File Routes ====================
+	if err := setupConfigRoutes(r, cfg.Routes, cfg, logger, rateLimiter, proxyHandler, deduplicator, responseCache, chains); err != nil {
+		return err
+	}
+	if err := setupSyntheticRoutes(r, cfg, logger); err != nil {
+		return err
+	}
+
 	// ==================== Catch-all Routes ====================
-	r.NoRoute(func(c *gin.Context) {
+	// Dynamic routes are matched first since gin routes cannot change at runtime
+	r.NoRoute(dynamic.dispatch(), func(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{
 			"error": "Route not found",
 		})
 	})
+
+	return nil
 }
@@ -0,0 +1,73 @@
+package router
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/YeonwooSung/instagram/api-gateway/aggregate"
+	"github.com/YeonwooSung/instagram/api-gateway/proxy"
+	"github.com/YeonwooSung/instagram/api-gateway/settings"
+	"github.com/gin-gonic/gin"
+)
+
+// settingsHandler serves /api/v1/settings. Reads go through the per-user
+// settings cache shared with other edge features; successful writes
+// invalidate the sections they change.
+type settingsHandler struct {
+	store    *settings.Store
+	proxy    *proxy.ProxyHandler
+	upstream string
+}
+
+// get serves a settings section from the cache, falling back to the service
+func (h *settingsHandler) get(section string) gin.HandlerFunc {
+	passthrough := h.proxy.ProxyRequest(h.upstream)
+	return func(c *gin.Context) {
+		// Paginated or filtered reads bypass the cache
+		if c.Request.URL.RawQuery != "" {
+			passthrough(c)
+			return
+		}
+
+		userID := fmt.Sprintf("%v", c.MustGet("user_id"))
+		entry, hit, err := h.store.Get(c.Request.Context(), userID, section, aggregate.ForwardHeaders(c))
+		if err != nil {
+			c.JSON(http.StatusBadGateway, gin.H{
+				"error": "Service unavailable",
+			})
+			return
+		}
+
+		if hit {
+			c.Header("X-Cache", "HIT")
+			c.Header("Age", strconv.Itoa(int(time.Since(entry.StoredAt).Seconds())))
+		} else {
+			c.Header("X-Cache", "MISS")
+		}
+		c.Data(entry.Status, entry.ContentType, entry.Body)
+	}
+}
+
+// at serves a settings route under another path, forwarding the request as
+// if it had been made to path
+func (h *settingsHandler) at(path string, handler gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Request.URL.Path = path
+		handler(c)
+	}
+}
+
+// write proxies a change and invalidates the affected sections once it succeeded
+func (h *settingsHandler) write(sections ...string) gin.HandlerFunc {
+	forward := h.proxy.ProxyRequest(h.upstream)
+	return func(c *gin.Context) {
+		forward(c)
+
+		if status := c.Writer.Status(); status >= 200 && status < 300 {
+			userID := fmt.Sprintf("%v", c.MustGet("user_id"))
+			h.store.Invalidate(c.Request.Context(), userID, sections...)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package router
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/YeonwooSung/instagram/api-gateway/config"
+	"github.com/YeonwooSung/instagram/api-gateway/metrics"
+	"github.com/YeonwooSung/instagram/api-gateway/proxy"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// signedUploads mints signed URLs for uploading media straight to object
+// storage, so huge files never pass through the gateway. The media service
+// holds the storage credentials and signs; the gateway decides who may upload
+// what, as for uploads it proxies.
+type signedUploads struct {
+	cfg    *config.Config
+	proxy  *proxy.ProxyHandler
+	logger *zap.Logger
+}
+
+// signedUploadRequest describes the file a client is about to upload
+type signedUploadRequest struct {
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size"`
+}
+
+// mint answers with the media service's signed URL, valid for
+// SIGNED_UPLOAD_TTL_SEC and bound to the declared type and size
+func (h *signedUploads) mint(c *gin.Context) {
+	userID, ok := bffUserID(c)
+	if !ok {
+		return
+	}
+
+	var req signedUploadRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid upload request"})
+		return
+	}
+	mediaType, _, err := mime.ParseMediaType(req.ContentType)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "content_type is required"})
+		return
+	}
+	if !allowedMediaType(h.cfg.UploadMediaTypes, mediaType) {
+		h.reject(c, "media_type", http.StatusUnsupportedMediaType, fmt.Sprintf("%s is not an allowed media type", mediaType))
+		return
+	}
+	if req.Size <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "size is required"})
+		return
+	}
+	if req.Size > h.cfg.SignedUploadMaxBytes {
+		h.reject(c, "size", http.StatusRequestEntityTooLarge, "Upload too large")
+		return
+	}
+
+	body, _ := json.Marshal(gin.H{
+		"filename":     req.Filename,
+		"content_type": mediaType,
+		"size":         req.Size,
+		"expires_in":   int(h.cfg.SignedUploadTTL.Seconds()),
+	})
+	header := http.Header{}
+	header.Set("Content-Type", "application/json")
+	header.Set("X-User-ID", userID)
+	header.Set("X-Calling-Service", "gateway")
+
+	status, resp, err := h.proxy.Send(c.Request.Context(), http.MethodPost, h.cfg.MediaServiceURL, "/api/v1/media/upload-urls", header, body)
+	if err != nil {
+		h.logger.Warn("Failed to mint signed upload URL", zap.Error(err))
+		metrics.Inc("gateway_signed_uploads_total", "result", "unavailable")
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to create upload URL"})
+		return
+	}
+	if status >= http.StatusMultipleChoices {
+		metrics.Inc("gateway_signed_uploads_total", "result", "refused")
+		c.Data(status, "application/json; charset=utf-8", resp)
+		return
+	}
+
+	var signed struct {
+		URL string `json:"url"`
+	}
+	if json.Unmarshal(resp, &signed) != nil || signed.URL == "" {
+		h.logger.Warn("Media service returned no signed upload URL", zap.Int("status", status))
+		metrics.Inc("gateway_signed_uploads_total", "result", "unavailable")
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to create upload URL"})
+		return
+	}
+	metrics.Inc("gateway_signed_uploads_total", "result", "minted")
+	c.Header("Cache-Control", "no-store")
+	c.Data(http.StatusCreated, "application/json; charset=utf-8", resp)
+}
+
+func (h *signedUploads) reject(c *gin.Context, reason string, status int, message string) {
+	metrics.Inc("gateway_signed_uploads_total", "result", reason)
+	c.JSON(status, gin.H{"error": message})
+}
+
+// allowedMediaType reports whether mediaType is one of types or starts with
+// one of them; no types allows any
+func allowedMediaType(types []string, mediaType string) bool {
+	if len(types) == 0 {
+		return true
+	}
+	for _, allowed := range types {
+		if strings.HasPrefix(mediaType, allowed) {
+			return true
+		}
+	}
+	return false
+}
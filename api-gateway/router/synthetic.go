@@ -0,0 +1,76 @@
+package router
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/YeonwooSung/instagram/api-gateway/config"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// setupSyntheticRoutes registers static endpoints declared in the config file,
+// served directly by the gateway without a backend
+func setupSyntheticRoutes(r *gin.Engine, cfg *config.Config, logger *zap.Logger) error {
+	for _, endpoint := range cfg.Synthetic {
+		method := strings.ToUpper(endpoint.Method)
+		if method == "" {
+			method = http.MethodGet
+		}
+
+		if err := handle(r, method, endpoint.Path, syntheticHandler(endpoint)); err != nil {
+			return fmt.Errorf("synthetic endpoint: %w", err)
+		}
+
+		logger.Info("Registered synthetic endpoint",
+			zap.String("method", method),
+			zap.String("path", endpoint.Path),
+		)
+	}
+	return nil
+}
+
+func syntheticHandler(endpoint config.Synthetic) gin.HandlerFunc {
+	// Encode once at startup; Validate already checked the payload
+	var jsonBody []byte
+	if endpoint.JSON != nil {
+		jsonBody, _ = json.Marshal(endpoint.JSON)
+	}
+
+	return func(c *gin.Context) {
+		for key, value := range endpoint.Headers {
+			c.Header(key, value)
+		}
+
+		switch {
+		case endpoint.Redirect != "":
+			status := endpoint.Status
+			if status == 0 {
+				status = http.StatusFound
+			}
+			c.Redirect(status, endpoint.Redirect)
+
+		case jsonBody != nil:
+			c.Data(statusOr(endpoint.Status, http.StatusOK), "application/json", jsonBody)
+
+		case endpoint.Body != "":
+			contentType := endpoint.ContentType
+			if contentType == "" {
+				contentType = "text/plain; charset=utf-8"
+			}
+			c.Data(statusOr(endpoint.Status, http.StatusOK), contentType, []byte(endpoint.Body))
+
+		default:
+			c.Status(statusOr(endpoint.Status, http.StatusNoContent))
+		}
+	}
+}
+
+func statusOr(status, defaultStatus int) int {
+	if status != 0 {
+		return status
+	}
+	return defaultStatus
+}
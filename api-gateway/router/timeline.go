@@ -0,0 +1,70 @@
+package router
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/YeonwooSung/instagram/api-gateway/middleware"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// maxTimelineEntries caps the request summaries returned by one query
+const maxTimelineEntries = 1000
+
+// timelineAdmin lets support engineers look up a user's recent requests
+type timelineAdmin struct {
+	timeline *middleware.Timeline
+	logger   *zap.Logger
+}
+
+func (t *timelineAdmin) registerAdmin(admin *gin.RouterGroup) {
+	admin.GET("/users/:user_id/timeline", t.query)
+}
+
+// query returns a user's captured requests. from and to are RFC 3339 times
+// and default to the last hour.
+func (t *timelineAdmin) query(c *gin.Context) {
+	to := time.Now()
+	from := to.Add(-time.Hour)
+	var err error
+	if raw := c.Query("to"); raw != "" {
+		if to, err = time.Parse(time.RFC3339, raw); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "to must be an RFC 3339 time"})
+			return
+		}
+		if c.Query("from") == "" {
+			from = to.Add(-time.Hour)
+		}
+	}
+	if raw := c.Query("from"); raw != "" {
+		if from, err = time.Parse(time.RFC3339, raw); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "from must be an RFC 3339 time"})
+			return
+		}
+	}
+	if !from.Before(to) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "from must be before to"})
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "200"))
+	if err != nil || limit <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be a positive integer"})
+		return
+	}
+
+	userID := c.Param("user_id")
+	entries, err := t.timeline.Query(c.Request.Context(), userID, from, to, min(limit, maxTimelineEntries))
+	if err != nil {
+		t.logger.Warn("Failed to read user timeline", zap.String("user_id", userID), zap.Error(err))
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Timeline unavailable"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"user_id":  userID,
+		"from":     from.UTC(),
+		"to":       to.UTC(),
+		"requests": entries,
+	})
+}
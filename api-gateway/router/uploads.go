@@ -0,0 +1,369 @@
+package router
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/YeonwooSung/instagram/api-gateway/config"
+	"github.com/YeonwooSung/instagram/api-gateway/journal"
+	"github.com/YeonwooSung/instagram/api-gateway/metrics"
+	"github.com/YeonwooSung/instagram/api-gateway/proxy"
+	"github.com/YeonwooSung/instagram/api-gateway/tus"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+const (
+	// tusVersion is the tus protocol version spoken
+	tusVersion = "1.0.0"
+
+	// tusOffsetType is the content type of PATCH chunks
+	tusOffsetType = "application/offset+octet-stream"
+
+	// uploadFinalizeSaga hands a complete upload to the media service
+	uploadFinalizeSaga = "upload_finalize"
+)
+
+// resumableUploads implements the tus resumable upload protocol for media.
+// Chunks are stored at the gateway, so a client that loses its connection
+// resumes from the last byte received instead of starting over; the complete
+// file is then streamed to the media service's regular upload endpoint.
+type resumableUploads struct {
+	cfg     *config.Config
+	store   *tus.Store
+	proxy   *proxy.ProxyHandler
+	journal *journal.Journal
+	logger  *zap.Logger
+}
+
+// saga forwards the file, then records the resulting media ID. Both steps
+// work from the stored upload alone, so a crash resumes them; the upload ID
+// is the idempotency key of the media upload.
+func (u *resumableUploads) saga() journal.Saga {
+	return journal.Saga{
+		Name: uploadFinalizeSaga,
+		Steps: []journal.Step{
+			{Name: "forward", Do: u.forward, Undo: u.discard, Resumable: true},
+			{Name: "record", Do: u.record, Resumable: true},
+		},
+	}
+}
+
+// versioned checks the client's protocol version and sets the response's
+// Tus-Resumable header
+func (u *resumableUploads) versioned(c *gin.Context) {
+	c.Header("Tus-Resumable", tusVersion)
+	if c.GetHeader("Tus-Resumable") != tusVersion {
+		c.Header("Tus-Version", tusVersion)
+		c.JSON(http.StatusPreconditionFailed, gin.H{"error": "Unsupported tus version"})
+		c.Abort()
+		return
+	}
+	c.Next()
+}
+
+// create starts an upload of Upload-Length bytes
+func (u *resumableUploads) create(c *gin.Context) {
+	userID, ok := bffUserID(c)
+	if !ok {
+		return
+	}
+	length, err := strconv.ParseInt(c.GetHeader("Upload-Length"), 10, 64)
+	if err != nil || length <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Upload-Length is required"})
+		return
+	}
+	if length > u.cfg.TusMaxBytes {
+		c.Header("Tus-Max-Size", strconv.FormatInt(u.cfg.TusMaxBytes, 10))
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Upload too large"})
+		return
+	}
+	metadata, ok := parseUploadMetadata(c.GetHeader("Upload-Metadata"))
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Upload-Metadata"})
+		return
+	}
+
+	upload, err := u.store.Create(userID, length, metadata)
+	if err != nil {
+		u.logger.Error("Failed to create upload", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create upload"})
+		return
+	}
+	metrics.Inc("gateway_resumable_uploads_total", "event", "created")
+
+	c.Header("Location", strings.TrimSuffix(c.Request.URL.Path, "/")+"/"+upload.ID)
+	c.Header("Upload-Expires", uploadExpiry(upload.ExpiresAt))
+	c.Status(http.StatusCreated)
+}
+
+// head reports how much of the upload has arrived
+func (u *resumableUploads) head(c *gin.Context) {
+	upload, ok := u.owned(c)
+	if !ok {
+		return
+	}
+	c.Header("Cache-Control", "no-store")
+	c.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	c.Header("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	c.Header("Upload-Expires", uploadExpiry(upload.ExpiresAt))
+	c.Status(http.StatusOK)
+}
+
+// progress reports an upload as JSON, including the media it became
+func (u *resumableUploads) progress(c *gin.Context) {
+	upload, ok := u.owned(c)
+	if !ok {
+		return
+	}
+	status := "uploading"
+	switch {
+	case upload.MediaID != "":
+		status = "complete"
+	case upload.Complete():
+		status = "processing"
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"id":         upload.ID,
+		"offset":     upload.Offset,
+		"length":     upload.Length,
+		"status":     status,
+		"media_id":   upload.MediaID,
+		"expires_at": upload.ExpiresAt,
+	})
+}
+
+// patch appends a chunk. The chunk completing the upload also hands the file
+// to the media service and returns the media ID in X-Media-ID; if that fails,
+// an empty PATCH at the final offset retries it.
+func (u *resumableUploads) patch(c *gin.Context) {
+	if c.ContentType() != tusOffsetType {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "Chunks must be " + tusOffsetType})
+		return
+	}
+	offset, err := strconv.ParseInt(c.GetHeader("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Upload-Offset is required"})
+		return
+	}
+	upload, ok := u.owned(c)
+	if !ok {
+		return
+	}
+	if upload.MediaID != "" {
+		c.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+		c.Header("X-Media-ID", upload.MediaID)
+		c.Status(http.StatusNoContent)
+		return
+	}
+
+	newOffset, err := u.store.Append(upload.ID, offset, c.Request.Body)
+	if newOffset > offset {
+		metrics.Add("gateway_resumable_upload_bytes_total", newOffset-offset)
+	}
+	switch {
+	case errors.Is(err, tus.ErrOffsetMismatch):
+		c.JSON(http.StatusConflict, gin.H{"error": "Upload-Offset does not match the upload"})
+		return
+	case errors.Is(err, tus.ErrLocked):
+		c.JSON(http.StatusLocked, gin.H{"error": "Upload is being written by another request"})
+		return
+	case errors.Is(err, tus.ErrTooLarge):
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Chunk exceeds Upload-Length"})
+		return
+	case err != nil:
+		// The client resumes from what arrived
+		u.logger.Info("Upload chunk cut short", zap.String("upload_id", upload.ID), zap.Int64("offset", newOffset), zap.Error(err))
+		metrics.Inc("gateway_resumable_uploads_total", "event", "interrupted")
+		c.Header("Upload-Offset", strconv.FormatInt(newOffset, 10))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Chunk interrupted"})
+		return
+	}
+	c.Header("Upload-Offset", strconv.FormatInt(newOffset, 10))
+	c.Header("Upload-Expires", uploadExpiry(upload.ExpiresAt))
+
+	if newOffset < upload.Length {
+		c.Status(http.StatusNoContent)
+		return
+	}
+
+	run, err := u.journal.Execute(c.Request.Context(), uploadFinalizeSaga, map[string]string{
+		"upload_id": upload.ID,
+		"user_id":   upload.Owner,
+	}, nil)
+	var upstream *upstreamError
+	switch {
+	case err == nil:
+		metrics.Inc("gateway_resumable_uploads_total", "event", "completed")
+		c.Header("X-Media-ID", run.State["media_id"])
+		c.Status(http.StatusNoContent)
+	case errors.Is(err, journal.ErrUnavailable):
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Upload processing unavailable"})
+	case errors.As(err, &upstream):
+		c.Data(upstream.status, "application/json; charset=utf-8", upstream.body)
+	default:
+		u.logger.Warn("Failed to hand over upload", zap.String("upload_id", upload.ID), zap.Error(err))
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to process upload"})
+	}
+}
+
+// terminate abandons an upload
+func (u *resumableUploads) terminate(c *gin.Context) {
+	upload, ok := u.owned(c)
+	if !ok {
+		return
+	}
+	if err := u.store.Remove(upload.ID); err != nil && !errors.Is(err, tus.ErrNotFound) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete upload"})
+		return
+	}
+	metrics.Inc("gateway_resumable_uploads_total", "event", "terminated")
+	c.Status(http.StatusNoContent)
+}
+
+// owned returns the upload named in the path if it belongs to the caller,
+// answering 404 otherwise
+func (u *resumableUploads) owned(c *gin.Context) (*tus.Upload, bool) {
+	userID, ok := bffUserID(c)
+	if !ok {
+		return nil, false
+	}
+	upload, err := u.store.Get(c.Param("upload_id"))
+	if err == nil && upload.Owner != userID {
+		err = tus.ErrNotFound
+	}
+	if errors.Is(err, tus.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Upload not found"})
+		return nil, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read upload"})
+		return nil, false
+	}
+	return upload, true
+}
+
+// forward streams the complete file to the media service as a multipart
+// upload, without holding it in memory
+func (u *resumableUploads) forward(ctx context.Context, run *journal.Run) error {
+	upload, err := u.store.Get(run.State["upload_id"])
+	if err != nil {
+		return err
+	}
+	if upload.MediaID != "" {
+		run.State["media_id"] = upload.MediaID
+		return nil
+	}
+	data, err := u.store.Open(upload.ID)
+	if err != nil {
+		return err
+	}
+	defer data.Close()
+
+	filename := upload.Metadata["filename"]
+	if filename == "" {
+		filename = upload.ID
+	}
+	part := textproto.MIMEHeader{}
+	part.Set("Content-Disposition", fmt.Sprintf(`form-data; name="file"; filename=%q`, filename))
+	part.Set("Content-Type", upload.Metadata["filetype"])
+	if part.Get("Content-Type") == "" {
+		part.Set("Content-Type", "application/octet-stream")
+	}
+
+	// The multipart framing around the file is built up front so the
+	// request carries a Content-Length
+	var head bytes.Buffer
+	form := multipart.NewWriter(&head)
+	if _, err := form.CreatePart(part); err != nil {
+		return err
+	}
+	tail := "\r\n--" + form.Boundary() + "--\r\n"
+	body := io.MultiReader(&head, data, strings.NewReader(tail))
+	size := int64(head.Len()) + upload.Length + int64(len(tail))
+
+	header := serviceHeader(run)
+	header.Set("Content-Type", form.FormDataContentType())
+	header.Set("Idempotency-Key", upload.ID)
+
+	// A large file takes longer than an ordinary request
+	ctx, cancel := context.WithTimeout(ctx, u.cfg.JournalLease)
+	defer cancel()
+	status, resp, err := u.proxy.SendStream(ctx, http.MethodPost, u.cfg.MediaServiceURL, "/api/v1/media/upload", header, body, size)
+	if err != nil {
+		return err
+	}
+	if status >= http.StatusMultipleChoices {
+		return &upstreamError{status: status, body: resp}
+	}
+
+	id := uploadedMediaID(resp)
+	if id == "" {
+		return errors.New("media service returned no media ID")
+	}
+	run.State["media_id"] = id
+	return nil
+}
+
+// discard deletes the media a forwarded upload became when it can't be
+// recorded; the upload stays complete, so handing it over can be retried
+func (u *resumableUploads) discard(ctx context.Context, run *journal.Run) error {
+	id := run.State["media_id"]
+	if id == "" {
+		return nil
+	}
+	status, _, err := u.proxy.Send(ctx, http.MethodDelete, u.cfg.MediaServiceURL, "/api/v1/media/"+url.PathEscape(id), serviceHeader(run), nil)
+	if err != nil {
+		return err
+	}
+	if status >= http.StatusMultipleChoices && status != http.StatusNotFound {
+		return fmt.Errorf("deleting media %s: upstream answered %d", id, status)
+	}
+	return nil
+}
+
+// record marks the upload finished and frees its data
+func (u *resumableUploads) record(ctx context.Context, run *journal.Run) error {
+	err := u.store.Finish(run.State["upload_id"], run.State["media_id"])
+	if errors.Is(err, tus.ErrNotFound) {
+		// Expired or terminated meanwhile; the media exists regardless
+		return nil
+	}
+	return err
+}
+
+// parseUploadMetadata decodes "key base64value,key2 base64value2"
+func parseUploadMetadata(header string) (map[string]string, bool) {
+	metadata := make(map[string]string)
+	if strings.TrimSpace(header) == "" {
+		return metadata, true
+	}
+	for _, pair := range strings.Split(header, ",") {
+		key, encoded, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" {
+			return nil, false
+		}
+		value, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, false
+		}
+		metadata[key] = string(value)
+	}
+	return metadata, true
+}
+
+// uploadExpiry formats an expiry for the Upload-Expires header
+func uploadExpiry(t time.Time) string {
+	return t.UTC().Format(http.TimeFormat)
+}
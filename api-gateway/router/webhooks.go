@@ -0,0 +1,88 @@
+package router
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/YeonwooSung/instagram/api-gateway/webhooks"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// webhookAdmin manages partner webhook subscriptions. The signing secret is
+// shown once, when the subscription is created.
+type webhookAdmin struct {
+	manager *webhooks.Manager
+	logger  *zap.Logger
+}
+
+func (w *webhookAdmin) registerAdmin(admin *gin.RouterGroup) {
+	admin.GET("/webhooks", w.list)
+	admin.POST("/webhooks", w.create)
+	admin.GET("/webhooks/:id", w.get)
+	admin.DELETE("/webhooks/:id", w.delete)
+}
+
+func (w *webhookAdmin) list(c *gin.Context) {
+	subs, err := w.manager.List(c.Request.Context())
+	if err != nil {
+		w.unavailable(c, "Failed to list webhook subscriptions", err)
+		return
+	}
+	for i := range subs {
+		subs[i].Secret = ""
+	}
+	c.JSON(http.StatusOK, gin.H{"subscriptions": subs, "events": webhooks.Events})
+}
+
+func (w *webhookAdmin) create(c *gin.Context) {
+	var sub webhooks.Subscription
+	if err := c.ShouldBindJSON(&sub); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid subscription: " + err.Error()})
+		return
+	}
+	if err := sub.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid subscription: " + err.Error()})
+		return
+	}
+
+	sub, err := w.manager.Register(c.Request.Context(), sub)
+	if err != nil {
+		w.unavailable(c, "Failed to register webhook subscription", err)
+		return
+	}
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusCreated, sub)
+}
+
+func (w *webhookAdmin) get(c *gin.Context) {
+	sub, err := w.manager.Get(c.Request.Context(), c.Param("id"))
+	if errors.Is(err, webhooks.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Webhook subscription not found"})
+		return
+	}
+	if err != nil {
+		w.unavailable(c, "Failed to read webhook subscription", err)
+		return
+	}
+	sub.Secret = ""
+	c.JSON(http.StatusOK, sub)
+}
+
+func (w *webhookAdmin) delete(c *gin.Context) {
+	err := w.manager.Delete(c.Request.Context(), c.Param("id"))
+	if errors.Is(err, webhooks.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Webhook subscription not found"})
+		return
+	}
+	if err != nil {
+		w.unavailable(c, "Failed to delete webhook subscription", err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"deleted": c.Param("id")})
+}
+
+func (w *webhookAdmin) unavailable(c *gin.Context, message string, err error) {
+	w.logger.Warn(message, zap.Error(err))
+	c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Webhooks unavailable"})
+}
@@ -0,0 +1,152 @@
+package router
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/YeonwooSung/instagram/api-gateway/config"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// setupWellKnownRoutes serves security.txt, change-password and the auth
+// service's JWKS document under /.well-known
+func setupWellKnownRoutes(r *gin.Engine, cfg *config.Config, logger *zap.Logger) {
+	wellKnown := r.Group("/.well-known")
+
+	// RFC 9116 security.txt, only when a contact is configured
+	if cfg.SecurityContact != "" {
+		expires := time.Now().UTC().AddDate(1, 0, 0).Format(time.RFC3339)
+		var b strings.Builder
+		fmt.Fprintf(&b, "Contact: %s\n", cfg.SecurityContact)
+		fmt.Fprintf(&b, "Expires: %s\n", expires)
+		if cfg.SecurityPolicyURL != "" {
+			fmt.Fprintf(&b, "Policy: %s\n", cfg.SecurityPolicyURL)
+		}
+		fmt.Fprintf(&b, "Preferred-Languages: en\n")
+		securityTxt := []byte(b.String())
+
+		wellKnown.GET("/security.txt", func(c *gin.Context) {
+			c.Data(http.StatusOK, "text/plain; charset=utf-8", securityTxt)
+		})
+	}
+
+	// Lets password managers find the password change page
+	wellKnown.GET("/change-password", func(c *gin.Context) {
+		c.Redirect(http.StatusFound, cfg.ChangePasswordURL)
+	})
+
+	// JWKS passthrough from the auth service
+	jwks := &jwksCache{
+		url:    cfg.AuthServiceURL + "/.well-known/jwks.json",
+		ttl:    cfg.JWKSCacheTTL,
+		client: &http.Client{Timeout: cfg.ProxyTimeout},
+		logger: logger,
+	}
+	wellKnown.GET("/jwks.json", jwks.handler)
+}
+
+// jwksRetryBackoff is how long after a failed refresh the JWKS is not
+// fetched again; the last good copy is served meanwhile
+const jwksRetryBackoff = 5 * time.Second
+
+// jwksCache caches the auth service's JWKS document and keeps serving the
+// last good copy if the auth service is unavailable. One request at a time
+// refreshes it; the others are served the stale copy, or wait for the
+// refresh when there is none yet.
+type jwksCache struct {
+	url    string
+	ttl    time.Duration
+	client *http.Client
+	logger *zap.Logger
+
+	mu         sync.Mutex
+	body       []byte
+	fetchedAt  time.Time
+	refreshing chan struct{}
+	err        error
+	retryAt    time.Time
+}
+
+func (j *jwksCache) handler(c *gin.Context) {
+	body, err := j.get()
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{
+			"error": "Service unavailable",
+		})
+		return
+	}
+
+	c.Header("Cache-Control", fmt.Sprintf("public, max-age=%d", int(j.ttl.Seconds())))
+	c.Data(http.StatusOK, "application/json", body)
+}
+
+func (j *jwksCache) get() ([]byte, error) {
+	j.mu.Lock()
+	if j.body != nil && time.Since(j.fetchedAt) < j.ttl {
+		body := j.body
+		j.mu.Unlock()
+		return body, nil
+	}
+	if time.Now().Before(j.retryAt) {
+		return j.last()
+	}
+	if done := j.refreshing; done != nil {
+		if j.body != nil {
+			return j.last()
+		}
+		j.mu.Unlock()
+		<-done
+		j.mu.Lock()
+		return j.last()
+	}
+
+	done := make(chan struct{})
+	j.refreshing = done
+	j.mu.Unlock()
+
+	body, err := j.fetch()
+
+	j.mu.Lock()
+	j.refreshing = nil
+	close(done)
+	if err != nil {
+		j.logger.Warn("Failed to refresh JWKS", zap.Error(err))
+		j.err = err
+		j.retryAt = time.Now().Add(jwksRetryBackoff)
+		return j.last()
+	}
+	j.body, j.fetchedAt, j.err = body, time.Now(), nil
+	return j.last()
+}
+
+// last returns the last good copy, or the error of the last refresh if there
+// is none, and unlocks j.mu
+func (j *jwksCache) last() ([]byte, error) {
+	body, err := j.body, j.err
+	j.mu.Unlock()
+	if body != nil {
+		return body, nil
+	}
+	if err == nil {
+		err = fmt.Errorf("JWKS not fetched yet")
+	}
+	return nil, err
+}
+
+func (j *jwksCache) fetch() ([]byte, error) {
+	resp, err := j.client.Get(j.url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("auth service returned %d", resp.StatusCode)
+	}
+	return io.ReadAll(resp.Body)
+}
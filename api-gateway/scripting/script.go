@@ -0,0 +1,330 @@
+// Package scripting runs operator-supplied Lua policies at request and
+// response time, sandboxed with a time limit and capped call and value
+// stacks. Heap use is not limited: gopher-lua has no allocation hook, so a
+// script building huge strings or tables can exhaust gateway memory before
+// its timeout fires. Only load scripts you trust.
+package scripting
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/YeonwooSung/instagram/api-gateway/metrics"
+	"github.com/gin-gonic/gin"
+	lua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/parse"
+	"go.uber.org/zap"
+)
+
+// Limits applied to every script VM; they bound the stacks, not the heap
+const (
+	callStackSize   = 64
+	registrySize    = 1024
+	registryMaxSize = 64 * 1024
+)
+
+// Script is a compiled Lua policy. It may define on_request(req) and
+// on_response(resp); both can call gateway.set_header(name, value) and
+// gateway.del_header(name), and on_request can call
+// gateway.reject(status, message) to answer the request itself.
+type Script struct {
+	name    string
+	proto   *lua.FunctionProto
+	timeout time.Duration
+	logger  *zap.Logger
+
+	onRequest  bool
+	onResponse bool
+	pool       sync.Pool
+}
+
+// sandbox is one Lua VM with the gateway module bound to its current invocation
+type sandbox struct {
+	L   *lua.LState
+	inv *invocation
+}
+
+// invocation collects the actions a script requested during one hook call
+type invocation struct {
+	allowReject bool
+	setHeaders  [][2]string
+	delHeaders  []string
+	rejected    bool
+	status      int
+	message     string
+}
+
+// Load compiles the Lua script at path. Each hook call may run for at most timeout.
+func Load(path string, timeout time.Duration, logger *zap.Logger) (*Script, error) {
+	source, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer source.Close()
+
+	chunk, err := parse.Parse(source, path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	proto, err := lua.Compile(chunk, path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile %s: %w", path, err)
+	}
+
+	s := &Script{
+		name:    path,
+		proto:   proto,
+		timeout: timeout,
+		logger:  logger,
+	}
+
+	// Run the chunk once to validate it and discover which hooks it defines
+	sb, err := s.newSandbox()
+	if err != nil {
+		return nil, err
+	}
+	s.onRequest = sb.L.GetGlobal("on_request").Type() == lua.LTFunction
+	s.onResponse = sb.L.GetGlobal("on_response").Type() == lua.LTFunction
+	if !s.onRequest && !s.onResponse {
+		sb.L.Close()
+		return nil, fmt.Errorf("%s defines neither on_request nor on_response", path)
+	}
+	s.pool.Put(sb)
+
+	return s, nil
+}
+
+// newSandbox creates a VM with only safe libraries and runs the script's top level
+func (s *Script) newSandbox() (*sandbox, error) {
+	L := lua.NewState(lua.Options{
+		SkipOpenLibs:    true,
+		CallStackSize:   callStackSize,
+		RegistrySize:    registrySize,
+		RegistryMaxSize: registryMaxSize,
+	})
+	sb := &sandbox{L: L, inv: &invocation{}}
+
+	for _, lib := range []struct {
+		name string
+		open lua.LGFunction
+	}{
+		{lua.BaseLibName, lua.OpenBase},
+		{lua.TabLibName, lua.OpenTable},
+		{lua.StringLibName, lua.OpenString},
+		{lua.MathLibName, lua.OpenMath},
+	} {
+		L.Push(L.NewFunction(lib.open))
+		L.Push(lua.LString(lib.name))
+		L.Call(1, 0)
+	}
+
+	// No file, module or code loading, and no cheap ways to allocate huge strings
+	for _, name := range []string{"dofile", "loadfile", "load", "loadstring", "require", "module", "collectgarbage"} {
+		L.SetGlobal(name, lua.LNil)
+	}
+	if str, ok := L.GetGlobal(lua.StringLibName).(*lua.LTable); ok {
+		str.RawSetString("rep", lua.LNil)
+	}
+
+	gateway := L.NewTable()
+	L.SetFuncs(gateway, map[string]lua.LGFunction{
+		"set_header": func(L *lua.LState) int {
+			sb.inv.setHeaders = append(sb.inv.setHeaders, [2]string{L.CheckString(1), L.CheckString(2)})
+			return 0
+		},
+		"del_header": func(L *lua.LState) int {
+			sb.inv.delHeaders = append(sb.inv.delHeaders, L.CheckString(1))
+			return 0
+		},
+		"reject": func(L *lua.LState) int {
+			if !sb.inv.allowReject {
+				L.RaiseError("gateway.reject is only available in on_request")
+			}
+			status := L.CheckInt(1)
+			if status < 400 || status > 599 {
+				L.ArgError(1, "status must be 4xx or 5xx")
+			}
+			sb.inv.rejected = true
+			sb.inv.status = status
+			sb.inv.message = L.OptString(2, http.StatusText(status))
+			return 0
+		},
+	})
+	L.SetGlobal("gateway", gateway)
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+	L.SetContext(ctx)
+	defer L.RemoveContext()
+
+	L.Push(L.NewFunctionFromProto(s.proto))
+	if err := L.PCall(0, 0, nil); err != nil {
+		L.Close()
+		return nil, fmt.Errorf("failed to run %s: %w", s.name, err)
+	}
+	return sb, nil
+}
+
+// call runs hook with arg in a pooled VM. VMs that fail are discarded since
+// an aborted script may leave them in an inconsistent state.
+func (s *Script) call(ctx context.Context, hook string, arg func(L *lua.LState) lua.LValue, inv *invocation) error {
+	sb, _ := s.pool.Get().(*sandbox)
+	if sb == nil {
+		var err error
+		if sb, err = s.newSandbox(); err != nil {
+			return err
+		}
+	}
+	sb.inv = inv
+
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	sb.L.SetContext(ctx)
+
+	err := sb.L.CallByParam(lua.P{
+		Fn:      sb.L.GetGlobal(hook),
+		NRet:    0,
+		Protect: true,
+	}, arg(sb.L))
+
+	sb.L.RemoveContext()
+	sb.inv = &invocation{}
+	if err != nil {
+		sb.L.Close()
+		return err
+	}
+	s.pool.Put(sb)
+	return nil
+}
+
+// Middleware runs the script's hooks around the rest of the chain. Script
+// errors and timeouts are logged and the request continues unchanged.
+func (s *Script) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if s.onRequest {
+			inv := &invocation{allowReject: true}
+			err := s.call(c.Request.Context(), "on_request", func(L *lua.LState) lua.LValue {
+				return requestTable(L, c)
+			}, inv)
+			if err != nil {
+				s.fail(c, "on_request", err)
+			} else {
+				for _, name := range inv.delHeaders {
+					c.Request.Header.Del(name)
+				}
+				for _, header := range inv.setHeaders {
+					c.Request.Header.Set(header[0], header[1])
+				}
+				if inv.rejected {
+					metrics.Inc("gateway_script_rejections_total", "script", s.name)
+					c.JSON(inv.status, gin.H{
+						"error": inv.message,
+					})
+					c.Abort()
+					return
+				}
+			}
+		}
+
+		if s.onResponse {
+			c.Writer = &hookWriter{ResponseWriter: c.Writer, before: func(w gin.ResponseWriter) {
+				inv := &invocation{}
+				err := s.call(c.Request.Context(), "on_response", func(L *lua.LState) lua.LValue {
+					return responseTable(L, w)
+				}, inv)
+				if err != nil {
+					s.fail(c, "on_response", err)
+					return
+				}
+				for _, name := range inv.delHeaders {
+					w.Header().Del(name)
+				}
+				for _, header := range inv.setHeaders {
+					w.Header().Set(header[0], header[1])
+				}
+			}}
+		}
+
+		c.Next()
+	}
+}
+
+func (s *Script) fail(c *gin.Context, hook string, err error) {
+	metrics.Inc("gateway_script_errors_total", "script", s.name, "hook", hook)
+	s.logger.Warn("Script hook failed",
+		zap.String("script", s.name),
+		zap.String("hook", hook),
+		zap.String("path", c.Request.URL.Path),
+		zap.Error(err),
+	)
+}
+
+// requestTable exposes the request to on_request
+func requestTable(L *lua.LState, c *gin.Context) lua.LValue {
+	req := L.NewTable()
+	req.RawSetString("method", lua.LString(c.Request.Method))
+	req.RawSetString("path", lua.LString(c.Request.URL.Path))
+	req.RawSetString("query", lua.LString(c.Request.URL.RawQuery))
+	req.RawSetString("client_ip", lua.LString(c.ClientIP()))
+	if userID, exists := c.Get("user_id"); exists {
+		req.RawSetString("user_id", lua.LString(fmt.Sprintf("%v", userID)))
+	}
+	req.RawSetString("headers", headerTable(L, c.Request.Header))
+	return req
+}
+
+// responseTable exposes the response status and headers to on_response
+func responseTable(L *lua.LState, w gin.ResponseWriter) lua.LValue {
+	resp := L.NewTable()
+	resp.RawSetString("status", lua.LNumber(w.Status()))
+	resp.RawSetString("headers", headerTable(L, w.Header()))
+	return resp
+}
+
+// headerTable maps lower-cased header names to their first value
+func headerTable(L *lua.LState, header http.Header) *lua.LTable {
+	headers := L.NewTable()
+	for name := range header {
+		headers.RawSetString(strings.ToLower(name), lua.LString(header.Get(name)))
+	}
+	return headers
+}
+
+// hookWriter calls before once, just before the response headers are written
+type hookWriter struct {
+	gin.ResponseWriter
+	before func(w gin.ResponseWriter)
+	done   bool
+}
+
+func (w *hookWriter) runHook() {
+	if !w.done {
+		w.done = true
+		w.before(w.ResponseWriter)
+	}
+}
+
+func (w *hookWriter) WriteHeaderNow() {
+	w.runHook()
+	w.ResponseWriter.WriteHeaderNow()
+}
+
+func (w *hookWriter) Write(b []byte) (int, error) {
+	w.runHook()
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *hookWriter) WriteString(s string) (int, error) {
+	w.runHook()
+	return w.ResponseWriter.WriteString(s)
+}
+
+func (w *hookWriter) Flush() {
+	w.runHook()
+	w.ResponseWriter.Flush()
+}
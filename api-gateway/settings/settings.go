@@ -0,0 +1,156 @@
+// Package settings reads users' account settings and preferences from the
+// settings service through a per-user cache, for the /api/v1/settings routes
+// and for edge features that need them (push dispatch, localization).
+package settings
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/YeonwooSung/instagram/api-gateway/cache"
+	"github.com/YeonwooSung/instagram/api-gateway/metrics"
+	"github.com/YeonwooSung/instagram/api-gateway/proxy"
+	"go.uber.org/zap"
+)
+
+// Settings sections, each served at /api/v1/settings/<section>
+const (
+	SectionNotifications = "notifications"
+	SectionPrivacy       = "privacy"
+	SectionBlocked       = "blocked"
+	SectionLanguage      = "language"
+)
+
+// Store fetches settings sections per user and caches successful responses,
+// encrypted per user, until they are invalidated by a write or expire
+type Store struct {
+	proxy    *proxy.ProxyHandler
+	cache    *cache.Store
+	upstream string
+	ttl      time.Duration
+	logger   *zap.Logger
+}
+
+// New creates a settings store backed by the settings service at upstream
+func New(proxyHandler *proxy.ProxyHandler, cacheStore *cache.Store, upstream string, ttl time.Duration, logger *zap.Logger) *Store {
+	return &Store{
+		proxy:    proxyHandler,
+		cache:    cacheStore,
+		upstream: upstream,
+		ttl:      ttl,
+		logger:   logger,
+	}
+}
+
+// Get returns a user's settings section. header carries the credentials the
+// settings service authenticates the lookup with. hit reports whether the
+// entry came from the cache; non-200 responses are returned but not cached.
+func (s *Store) Get(ctx context.Context, userID, section string, header http.Header) (entry *cache.Entry, hit bool, err error) {
+	key, owner := cacheKey(userID, section), "user:"+userID
+	if s.cacheable() {
+		if entry, err := s.cache.Get(ctx, key, owner); err == nil {
+			metrics.Inc("gateway_settings_lookups_total", "section", section, "result", "hit")
+			return entry, true, nil
+		}
+	}
+	metrics.Inc("gateway_settings_lookups_total", "section", section, "result", "miss")
+
+	status, body, err := s.proxy.Fetch(ctx, s.upstream, "/api/v1/settings/"+section, header)
+	if err != nil {
+		return nil, false, err
+	}
+	entry = &cache.Entry{
+		Status:      status,
+		ContentType: "application/json; charset=utf-8",
+		Body:        body,
+		StoredAt:    time.Now(),
+	}
+
+	if status == http.StatusOK && s.cacheable() {
+		if err := s.cache.Set(ctx, key, owner, entry, s.ttl); err != nil {
+			s.logger.Warn("Failed to cache settings", zap.String("section", section), zap.Error(err))
+		}
+	}
+	return entry, false, nil
+}
+
+// Language returns the user's preferred language, or "" when unknown
+func (s *Store) Language(ctx context.Context, userID string, header http.Header) string {
+	entry, _, err := s.Get(ctx, userID, SectionLanguage, header)
+	if err != nil || entry.Status != http.StatusOK {
+		return ""
+	}
+	var prefs struct {
+		Language string `json:"language"`
+	}
+	if json.Unmarshal(entry.Body, &prefs) != nil {
+		return ""
+	}
+	return prefs.Language
+}
+
+// Privacy is what other services need of a user's privacy settings
+type Privacy struct {
+	PrivateAccount  bool   `json:"private_account"`
+	StoryVisibility string `json:"story_visibility"`
+	Comments        string `json:"comments"`
+	Mentions        string `json:"mentions"`
+}
+
+// Context renders the settings as the X-Privacy-Context header value, e.g.
+// "private=true; stories=close_friends; comments=followers; mentions=off"
+func (p Privacy) Context() string {
+	parts := []string{"private=" + strconv.FormatBool(p.PrivateAccount)}
+	for _, part := range []struct{ name, value string }{
+		{"stories", p.StoryVisibility},
+		{"comments", p.Comments},
+		{"mentions", p.Mentions},
+	} {
+		if part.value != "" {
+			parts = append(parts, part.name+"="+part.value)
+		}
+	}
+	return strings.Join(parts, "; ")
+}
+
+// CachedPrivacy returns the user's privacy settings if they can be kept in
+// the cache. Without a cache, a lookup on every read would double the
+// settings service's traffic, so none is made.
+func (s *Store) CachedPrivacy(ctx context.Context, userID string, header http.Header) (Privacy, bool) {
+	var privacy Privacy
+	if !s.cacheable() {
+		return privacy, false
+	}
+	entry, _, err := s.Get(ctx, userID, SectionPrivacy, header)
+	if err != nil || entry.Status != http.StatusOK || json.Unmarshal(entry.Body, &privacy) != nil {
+		return privacy, false
+	}
+	return privacy, true
+}
+
+// Invalidate drops cached sections of a user after they changed
+func (s *Store) Invalidate(ctx context.Context, userID string, sections ...string) {
+	if len(sections) == 0 {
+		return
+	}
+	keys := make([]string, 0, len(sections))
+	for _, section := range sections {
+		keys = append(keys, cacheKey(userID, section))
+	}
+	if err := s.cache.Delete(ctx, keys...); err != nil {
+		s.logger.Warn("Failed to invalidate cached settings", zap.String("user_id", userID), zap.Error(err))
+	}
+}
+
+func (s *Store) cacheable() bool {
+	return s.ttl > 0 && s.cache.CanStorePrivate()
+}
+
+func cacheKey(userID, section string) string {
+	return fmt.Sprintf("gateway:settings:%s:%s", userID, section)
+}
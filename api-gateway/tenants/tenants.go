@@ -0,0 +1,103 @@
+// Package tenants serves white-label deployments off one gateway. Requests
+// are matched to a tenant by their Host header; a tenant may send some
+// upstreams' traffic to URLs of its own, and is limited and authenticated by
+// its own policy on top of the gateway's.
+package tenants
+
+import (
+	"context"
+	"net"
+	"strings"
+
+	"github.com/YeonwooSung/instagram/api-gateway/config"
+)
+
+// Tenant is a resolved tenant. A nil Tenant is the default deployment, so
+// callers need not check whether the request matched one.
+type Tenant struct {
+	Name      string
+	RateLimit string
+	Auth      string
+	Audience  string
+
+	// upstreams maps shared upstream URLs to the tenant's own
+	upstreams map[string]string
+}
+
+// Upstream returns the URL the tenant's traffic for upstream goes to
+func (t *Tenant) Upstream(upstream string) string {
+	if t == nil {
+		return upstream
+	}
+	if target, ok := t.upstreams[upstream]; ok {
+		return target
+	}
+	return upstream
+}
+
+// Resolver matches hosts to tenants
+type Resolver struct {
+	exact    map[string]*Tenant
+	wildcard map[string]*Tenant
+}
+
+// New builds a resolver from the configured tenants. Hosts are either exact
+// ("partner-api.example.com") or a wildcard of one label
+// ("*.partner.example.com").
+func New(cfg *config.Config) *Resolver {
+	upstreams := cfg.ServiceURLs()
+	r := &Resolver{
+		exact:    make(map[string]*Tenant),
+		wildcard: make(map[string]*Tenant),
+	}
+	for _, configured := range cfg.Tenants {
+		tenant := &Tenant{
+			Name:      configured.Name,
+			RateLimit: configured.RateLimit,
+			Auth:      configured.Auth,
+			Audience:  configured.Audience,
+			upstreams: make(map[string]string, len(configured.Upstreams)),
+		}
+		for name, target := range configured.Upstreams {
+			tenant.upstreams[upstreams[name]] = strings.TrimSuffix(target, "/")
+		}
+		for _, host := range configured.Hosts {
+			host = strings.ToLower(host)
+			if suffix, ok := strings.CutPrefix(host, "*."); ok {
+				r.wildcard[suffix] = tenant
+			} else {
+				r.exact[host] = tenant
+			}
+		}
+	}
+	return r
+}
+
+// Resolve returns the tenant serving host, which may carry a port, or nil
+// for the default deployment
+func (r *Resolver) Resolve(host string) *Tenant {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if tenant, ok := r.exact[host]; ok {
+		return tenant
+	}
+	if _, parent, ok := strings.Cut(host, "."); ok {
+		return r.wildcard[parent]
+	}
+	return nil
+}
+
+type contextKey struct{}
+
+// WithTenant returns ctx carrying the request's tenant
+func WithTenant(ctx context.Context, tenant *Tenant) context.Context {
+	return context.WithValue(ctx, contextKey{}, tenant)
+}
+
+// FromContext returns the tenant of the request of ctx, or nil
+func FromContext(ctx context.Context) *Tenant {
+	tenant, _ := ctx.Value(contextKey{}).(*Tenant)
+	return tenant
+}
@@ -0,0 +1,214 @@
+// Package timing breaks down where a request's time goes: in the gateway
+// itself, waiting on upstreams, or retrying them. Upstream calls are traced
+// phase by phase (DNS, connect, TLS, waiting for the first byte, transfer),
+// and connection attempts the transport retried are counted.
+package timing
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http/httptrace"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Timeline collects the upstream calls of one request. A nil Timeline
+// records nothing, so callers need not check whether timing is enabled.
+type Timeline struct {
+	start time.Time
+
+	mu    sync.Mutex
+	calls []*Call
+}
+
+// Call is one upstream call of a request
+type Call struct {
+	upstream string
+	start    time.Time
+
+	mu           sync.Mutex
+	end          time.Time
+	attempts     int
+	attemptStart time.Time
+	dnsStart     time.Time
+	connectStart time.Time
+	tlsStart     time.Time
+	wroteRequest time.Time
+	firstByte    time.Time
+	dns          time.Duration
+	connect      time.Duration
+	tls          time.Duration
+}
+
+// CallTiming is the breakdown of one upstream call. Retry is the time spent
+// on attempts before the last one.
+type CallTiming struct {
+	Upstream string
+	Attempts int
+	DNS      time.Duration
+	Connect  time.Duration
+	TLS      time.Duration
+	Wait     time.Duration
+	Transfer time.Duration
+	Retry    time.Duration
+	Total    time.Duration
+}
+
+// Breakdown splits a request's time so far. Upstream is the wall time with
+// at least one upstream call in flight, so parallel calls are not counted
+// twice; Gateway is the rest.
+type Breakdown struct {
+	Total    time.Duration
+	Gateway  time.Duration
+	Upstream time.Duration
+	Retry    time.Duration
+	Retries  int
+	Calls    []CallTiming
+}
+
+type contextKey struct{}
+
+// Start begins timing a request
+func Start(ctx context.Context) (context.Context, *Timeline) {
+	t := &Timeline{start: time.Now()}
+	return context.WithValue(ctx, contextKey{}, t), t
+}
+
+// FromContext returns the timeline of the request of ctx, or nil
+func FromContext(ctx context.Context) *Timeline {
+	t, _ := ctx.Value(contextKey{}).(*Timeline)
+	return t
+}
+
+// Begin starts timing a call to upstream; call End when it is done
+func (t *Timeline) Begin(upstream string) *Call {
+	if t == nil {
+		return nil
+	}
+	call := &Call{upstream: upstream, start: time.Now()}
+	t.mu.Lock()
+	t.calls = append(t.calls, call)
+	t.mu.Unlock()
+	return call
+}
+
+// Trace returns ctx with a client trace recording the call's phases, on top
+// of any trace ctx already carries
+func (c *Call) Trace(ctx context.Context) context.Context {
+	if c == nil {
+		return ctx
+	}
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GetConn: func(string) {
+			c.mu.Lock()
+			c.attempts++
+			c.attemptStart = time.Now()
+			c.mu.Unlock()
+		},
+		DNSStart:             func(httptrace.DNSStartInfo) { c.mark(&c.dnsStart) },
+		DNSDone:              func(httptrace.DNSDoneInfo) { c.add(&c.dns, &c.dnsStart) },
+		ConnectStart:         func(string, string) { c.mark(&c.connectStart) },
+		ConnectDone:          func(string, string, error) { c.add(&c.connect, &c.connectStart) },
+		TLSHandshakeStart:    func() { c.mark(&c.tlsStart) },
+		TLSHandshakeDone:     func(tls.ConnectionState, error) { c.add(&c.tls, &c.tlsStart) },
+		WroteRequest:         func(httptrace.WroteRequestInfo) { c.mark(&c.wroteRequest) },
+		GotFirstResponseByte: func() { c.mark(&c.firstByte) },
+	})
+}
+
+func (c *Call) mark(at *time.Time) {
+	c.mu.Lock()
+	*at = time.Now()
+	c.mu.Unlock()
+}
+
+func (c *Call) add(total *time.Duration, since *time.Time) {
+	c.mu.Lock()
+	if !since.IsZero() {
+		*total += time.Since(*since)
+	}
+	c.mu.Unlock()
+}
+
+// End marks the call done, its response read or streamed
+func (c *Call) End() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	if c.end.IsZero() {
+		c.end = time.Now()
+	}
+	c.mu.Unlock()
+}
+
+// timing returns the call's breakdown, treating a call still in flight as
+// ending at now
+func (c *Call) timing(now time.Time) (CallTiming, time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	end := c.end
+	if end.IsZero() {
+		end = now
+	}
+	ct := CallTiming{
+		Upstream: c.upstream,
+		Attempts: c.attempts,
+		DNS:      c.dns,
+		Connect:  c.connect,
+		TLS:      c.tls,
+		Total:    end.Sub(c.start),
+	}
+	if c.attempts > 1 {
+		ct.Retry = c.attemptStart.Sub(c.start)
+	}
+	if !c.wroteRequest.IsZero() {
+		waitEnd := end
+		if !c.firstByte.IsZero() {
+			waitEnd = c.firstByte
+			ct.Transfer = end.Sub(c.firstByte)
+		}
+		ct.Wait = waitEnd.Sub(c.wroteRequest)
+	}
+	return ct, end
+}
+
+// Breakdown returns the request's time split so far
+func (t *Timeline) Breakdown() Breakdown {
+	if t == nil {
+		return Breakdown{}
+	}
+	now := time.Now()
+	b := Breakdown{Total: now.Sub(t.start)}
+
+	t.mu.Lock()
+	calls := slices.Clone(t.calls)
+	t.mu.Unlock()
+
+	type span struct{ start, end time.Time }
+	spans := make([]span, 0, len(calls))
+	for _, call := range calls {
+		ct, end := call.timing(now)
+		b.Calls = append(b.Calls, ct)
+		b.Retry += ct.Retry
+		b.Retries += max(ct.Attempts-1, 0)
+		spans = append(spans, span{call.start, end})
+	}
+
+	// Union of the calls' spans
+	slices.SortFunc(spans, func(x, y span) int { return x.start.Compare(y.start) })
+	var covered span
+	for _, s := range spans {
+		if s.start.After(covered.end) {
+			b.Upstream += covered.end.Sub(covered.start)
+			covered = s
+		} else if s.end.After(covered.end) {
+			covered.end = s.end
+		}
+	}
+	b.Upstream += covered.end.Sub(covered.start)
+	b.Gateway = max(b.Total-b.Upstream, 0)
+	return b
+}
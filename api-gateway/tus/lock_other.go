@@ -0,0 +1,11 @@
+//go:build !unix
+
+package tus
+
+import "os"
+
+// lockFile is a no-op where flock is unavailable; only writers in this
+// process are excluded
+func lockFile(f *os.File) error {
+	return nil
+}
@@ -0,0 +1,21 @@
+//go:build unix
+
+package tus
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// lockFile takes an exclusive lock on f that other processes, including
+// replicas sharing the directory over a network volume with lock support,
+// respect. It fails with ErrLocked instead of waiting. The lock is released
+// when f is closed.
+func lockFile(f *os.File) error {
+	err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if errors.Is(err, syscall.EWOULDBLOCK) {
+		return ErrLocked
+	}
+	return err
+}
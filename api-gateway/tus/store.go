@@ -0,0 +1,297 @@
+// Package tus stores resumable uploads received through the tus protocol
+// (https://tus.io/protocols/resumable-upload) until they are complete and
+// handed to the media service. Each upload is a data file growing with every
+// chunk and an info file describing it; the data file's size is the upload's
+// offset, so a chunk cut short by a network blip keeps what arrived.
+package tus
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// sweepInterval is how often expired uploads are deleted
+const sweepInterval = 10 * time.Minute
+
+var (
+	// ErrNotFound is returned for unknown, expired or removed uploads
+	ErrNotFound = errors.New("upload not found")
+
+	// ErrOffsetMismatch is returned when a chunk does not start where the upload ends
+	ErrOffsetMismatch = errors.New("upload offset mismatch")
+
+	// ErrTooLarge is returned when a chunk would grow an upload past its length
+	ErrTooLarge = errors.New("chunk exceeds upload length")
+
+	// ErrLocked is returned while another chunk of the same upload is being written
+	ErrLocked = errors.New("upload is locked by another request")
+)
+
+// idPattern keeps upload IDs safe to use as file names
+var idPattern = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+// Upload describes one resumable upload
+type Upload struct {
+	ID        string            `json:"id"`
+	Owner     string            `json:"owner"`
+	Length    int64             `json:"length"`
+	Metadata  map[string]string `json:"metadata,omitempty"`
+	CreatedAt time.Time         `json:"created_at"`
+	ExpiresAt time.Time         `json:"expires_at"`
+
+	// MediaID is set once the complete upload was handed to the media service
+	MediaID string `json:"media_id,omitempty"`
+
+	// Offset is the number of bytes received; it is not stored but read
+	// from the data file
+	Offset int64 `json:"-"`
+}
+
+// Complete reports whether every byte of the upload has arrived
+func (u *Upload) Complete() bool {
+	return u.Offset == u.Length
+}
+
+// Store keeps uploads in a directory. Gateway replicas behind a load balancer
+// must share the directory (e.g. a network volume), as a client resuming an
+// upload may reach any of them.
+type Store struct {
+	dir    string
+	ttl    time.Duration
+	logger *zap.Logger
+
+	mu      sync.Mutex
+	writing map[string]bool
+}
+
+// NewStore creates a store in dir, creating the directory if needed. Uploads
+// expire ttl after creation.
+func NewStore(dir string, ttl time.Duration, logger *zap.Logger) (*Store, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create upload directory: %w", err)
+	}
+	return &Store{
+		dir:     dir,
+		ttl:     ttl,
+		logger:  logger,
+		writing: make(map[string]bool),
+	}, nil
+}
+
+// Create starts an upload of length bytes for owner
+func (s *Store) Create(owner string, length int64, metadata map[string]string) (*Upload, error) {
+	id := make([]byte, 16)
+	rand.Read(id)
+	now := time.Now()
+	upload := &Upload{
+		ID:        hex.EncodeToString(id),
+		Owner:     owner,
+		Length:    length,
+		Metadata:  metadata,
+		CreatedAt: now,
+		ExpiresAt: now.Add(s.ttl),
+	}
+
+	data, err := os.OpenFile(s.dataPath(upload.ID), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	data.Close()
+	if err := s.writeInfo(upload); err != nil {
+		os.Remove(s.dataPath(upload.ID))
+		return nil, err
+	}
+	return upload, nil
+}
+
+// Get returns an upload with its current offset
+func (s *Store) Get(id string) (*Upload, error) {
+	if !idPattern.MatchString(id) {
+		return nil, ErrNotFound
+	}
+	raw, err := os.ReadFile(s.infoPath(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var upload Upload
+	if err := json.Unmarshal(raw, &upload); err != nil {
+		return nil, err
+	}
+	if time.Now().After(upload.ExpiresAt) {
+		return nil, ErrNotFound
+	}
+
+	if upload.MediaID != "" {
+		// The data was handed over and deleted
+		upload.Offset = upload.Length
+		return &upload, nil
+	}
+	stat, err := os.Stat(s.dataPath(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	upload.Offset = stat.Size()
+	return &upload, nil
+}
+
+// Append writes a chunk starting at offset, returning the new offset. Bytes
+// that arrived before body failed are kept, and the error returned with them.
+// A chunk of an upload another request, on any replica, is writing fails
+// with ErrLocked.
+func (s *Store) Append(id string, offset int64, body io.Reader) (int64, error) {
+	s.mu.Lock()
+	if s.writing[id] {
+		s.mu.Unlock()
+		return 0, ErrLocked
+	}
+	s.writing[id] = true
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.writing, id)
+		s.mu.Unlock()
+	}()
+
+	if !idPattern.MatchString(id) {
+		return 0, ErrNotFound
+	}
+	data, err := os.OpenFile(s.dataPath(id), os.O_WRONLY|os.O_APPEND, 0o600)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, ErrNotFound
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer data.Close()
+
+	// Replicas share the directory: the offset is checked and the chunk
+	// written under a lock on the data file they all respect
+	if err := lockFile(data); err != nil {
+		return 0, err
+	}
+
+	upload, err := s.Get(id)
+	if err != nil {
+		return 0, err
+	}
+	if offset != upload.Offset {
+		return upload.Offset, ErrOffsetMismatch
+	}
+
+	// One byte past the remaining length tells an oversized chunk apart
+	remaining := upload.Length - upload.Offset
+	written, err := io.Copy(data, io.LimitReader(body, remaining+1))
+	if written > remaining {
+		data.Truncate(upload.Length)
+		return upload.Length, ErrTooLarge
+	}
+	return offset + written, err
+}
+
+// Open returns the data of a complete upload
+func (s *Store) Open(id string) (*os.File, error) {
+	if !idPattern.MatchString(id) {
+		return nil, ErrNotFound
+	}
+	return os.Open(s.dataPath(id))
+}
+
+// Finish records the media an upload became and deletes its data
+func (s *Store) Finish(id, mediaID string) error {
+	upload, err := s.Get(id)
+	if err != nil {
+		return err
+	}
+	upload.MediaID = mediaID
+	if err := s.writeInfo(upload); err != nil {
+		return err
+	}
+	if err := os.Remove(s.dataPath(id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// Remove deletes an upload
+func (s *Store) Remove(id string) error {
+	if !idPattern.MatchString(id) {
+		return ErrNotFound
+	}
+	os.Remove(s.dataPath(id))
+	if err := os.Remove(s.infoPath(id)); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return ErrNotFound
+		}
+		return err
+	}
+	return nil
+}
+
+// Sweep deletes expired uploads until ctx is cancelled
+func (s *Store) Sweep(ctx context.Context) {
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		entries, err := os.ReadDir(s.dir)
+		if err != nil {
+			s.logger.Warn("Failed to list uploads", zap.Error(err))
+			continue
+		}
+		for _, entry := range entries {
+			id, ok := strings.CutSuffix(entry.Name(), ".info")
+			if !ok || !idPattern.MatchString(id) {
+				continue
+			}
+			if _, err := s.Get(id); errors.Is(err, ErrNotFound) {
+				s.Remove(id)
+			}
+		}
+	}
+}
+
+// writeInfo replaces the info file atomically
+func (s *Store) writeInfo(upload *Upload) error {
+	raw, err := json.Marshal(upload)
+	if err != nil {
+		return err
+	}
+	tmp := s.infoPath(upload.ID) + ".tmp"
+	if err := os.WriteFile(tmp, raw, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.infoPath(upload.ID))
+}
+
+func (s *Store) dataPath(id string) string {
+	return filepath.Join(s.dir, id+".bin")
+}
+
+func (s *Store) infoPath(id string) string {
+	return filepath.Join(s.dir, id+".info")
+}
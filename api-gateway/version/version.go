@@ -0,0 +1,46 @@
+package version
+
+import (
+	"runtime"
+	"runtime/debug"
+)
+
+// Build metadata, overridden at build time via
+// -ldflags "-X github.com/YeonwooSung/instagram/api-gateway/version.Commit=..."
+var (
+	Commit    = "unknown"
+	BuildTime = "unknown"
+)
+
+// Info describes the running gateway binary
+type Info struct {
+	Commit    string `json:"commit"`
+	BuildTime string `json:"build_time"`
+	GoVersion string `json:"go_version"`
+}
+
+// Get returns build information, falling back to VCS data embedded by the Go toolchain
+func Get() Info {
+	info := Info{
+		Commit:    Commit,
+		BuildTime: BuildTime,
+		GoVersion: runtime.Version(),
+	}
+
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range bi.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				if info.Commit == "unknown" {
+					info.Commit = setting.Value
+				}
+			case "vcs.time":
+				if info.BuildTime == "unknown" {
+					info.BuildTime = setting.Value
+				}
+			}
+		}
+	}
+
+	return info
+}
@@ -0,0 +1,336 @@
+// Package webhooks delivers events to third-party integrations. Partners
+// subscribe a callback URL to events of a business account through the admin
+// API; when such an event happens the gateway queues one webhook per
+// subscription in the outbox and delivers it signed with the subscription's
+// secret. Failed deliveries are retried by the outbox and end up in its
+// dead-letter stream, from where operators can replay them.
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/YeonwooSung/instagram/api-gateway/metrics"
+	"github.com/YeonwooSung/instagram/api-gateway/outbox"
+	"github.com/YeonwooSung/instagram/api-gateway/state"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+const (
+	// subscriptionsKey is the hash of subscriptions by ID
+	subscriptionsKey = "gateway:webhooks"
+
+	// accountKeyPrefix prefixes the set of subscription IDs of an account
+	accountKeyPrefix = "gateway:webhooks:account:"
+
+	// SignatureHeader carries "t=<unix>,v1=<hex HMAC-SHA256 of t.body>"
+	SignatureHeader = "Webhook-Signature"
+
+	// attemptsPerDelivery is how often a delivery is tried before it is left
+	// to the outbox to retry later
+	attemptsPerDelivery = 3
+)
+
+// Webhook event types
+const (
+	EventPostCreated = "post.created"
+)
+
+// Events are the event types partners can subscribe to
+var Events = []string{EventPostCreated}
+
+// subscriptionSchema versions Subscription as stored in Redis
+var subscriptionSchema = state.NewSchema("webhook_subscription", 1)
+
+// ErrNotFound is returned for an unknown subscription
+var ErrNotFound = errors.New("webhook subscription not found")
+
+// Subscription is a partner's callback URL for events of one business account
+type Subscription struct {
+	ID        string    `json:"id"`
+	Partner   string    `json:"partner"`
+	URL       string    `json:"url"`
+	AccountID string    `json:"account_id"`
+	Events    []string  `json:"events"`
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Validate checks a subscription request before it is registered
+func (s *Subscription) Validate() error {
+	if s.Partner == "" || s.AccountID == "" {
+		return fmt.Errorf("partner and account_id are required")
+	}
+	u, err := url.Parse(s.URL)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("url must be an https URL")
+	}
+	if len(s.Events) == 0 {
+		return fmt.Errorf("events must name at least one event")
+	}
+	for _, event := range s.Events {
+		if !slices.Contains(Events, event) {
+			return fmt.Errorf("unknown event %q", event)
+		}
+	}
+	return nil
+}
+
+// delivery is the outbox payload of one webhook to one subscription
+type delivery struct {
+	SubscriptionID string          `json:"subscription_id"`
+	Type           string          `json:"type"`
+	AccountID      string          `json:"account_id"`
+	OccurredAt     time.Time       `json:"occurred_at"`
+	Data           json.RawMessage `json:"data,omitempty"`
+}
+
+// Manager keeps webhook subscriptions in Redis and delivers their webhooks
+type Manager struct {
+	redis  *redis.Client
+	outbox *outbox.Outbox
+	client *http.Client
+	logger *zap.Logger
+}
+
+// New creates a webhook manager. Webhooks are sent with client, which should
+// honour the egress policy; the manager must be registered as the outbox
+// sink of webhook events.
+func New(client *redis.Client, eventOutbox *outbox.Outbox, httpClient *http.Client, logger *zap.Logger) *Manager {
+	return &Manager{
+		redis:  client,
+		outbox: eventOutbox,
+		client: httpClient,
+		logger: logger,
+	}
+}
+
+// Register stores a new subscription with a fresh ID and signing secret
+func (m *Manager) Register(ctx context.Context, sub Subscription) (Subscription, error) {
+	if err := sub.Validate(); err != nil {
+		return Subscription{}, err
+	}
+	sub.ID = "wh_" + randomHex(12)
+	sub.Secret = "whsec_" + randomHex(32)
+	sub.CreatedAt = time.Now().UTC()
+
+	body, err := subscriptionSchema.Marshal(sub)
+	if err != nil {
+		return Subscription{}, err
+	}
+	_, err = m.redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, subscriptionsKey, sub.ID, body)
+		pipe.SAdd(ctx, accountKeyPrefix+sub.AccountID, sub.ID)
+		return nil
+	})
+	if err != nil {
+		return Subscription{}, err
+	}
+	return sub, nil
+}
+
+// Get returns a subscription, secret included
+func (m *Manager) Get(ctx context.Context, id string) (Subscription, error) {
+	raw, err := m.redis.HGet(ctx, subscriptionsKey, id).Result()
+	if errors.Is(err, redis.Nil) {
+		return Subscription{}, ErrNotFound
+	}
+	if err != nil {
+		return Subscription{}, err
+	}
+	var sub Subscription
+	if err := subscriptionSchema.Unmarshal([]byte(raw), &sub); err != nil {
+		return Subscription{}, err
+	}
+	return sub, nil
+}
+
+// List returns every subscription, secrets included
+func (m *Manager) List(ctx context.Context) ([]Subscription, error) {
+	all, err := m.redis.HGetAll(ctx, subscriptionsKey).Result()
+	if err != nil {
+		return nil, err
+	}
+	subs := make([]Subscription, 0, len(all))
+	for _, raw := range all {
+		var sub Subscription
+		if subscriptionSchema.Unmarshal([]byte(raw), &sub) == nil {
+			subs = append(subs, sub)
+		}
+	}
+	slices.SortFunc(subs, func(a, b Subscription) int { return a.CreatedAt.Compare(b.CreatedAt) })
+	return subs, nil
+}
+
+// Delete removes a subscription; webhooks already queued for it are dropped
+func (m *Manager) Delete(ctx context.Context, id string) error {
+	sub, err := m.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	_, err = m.redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HDel(ctx, subscriptionsKey, id)
+		pipe.SRem(ctx, accountKeyPrefix+sub.AccountID, id)
+		return nil
+	})
+	return err
+}
+
+// Publish queues a webhook of eventType for every subscription of accountID
+// to that event. A nil Manager publishes nothing.
+func (m *Manager) Publish(ctx context.Context, eventType, accountID string, data json.RawMessage) error {
+	if m == nil {
+		return nil
+	}
+	ids, err := m.redis.SMembers(ctx, accountKeyPrefix+accountID).Result()
+	if err != nil || len(ids) == 0 {
+		return err
+	}
+	raws, err := m.redis.HMGet(ctx, subscriptionsKey, ids...).Result()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	for _, raw := range raws {
+		s, ok := raw.(string)
+		if !ok {
+			continue
+		}
+		var sub Subscription
+		if subscriptionSchema.Unmarshal([]byte(s), &sub) != nil || !slices.Contains(sub.Events, eventType) {
+			continue
+		}
+		err := m.outbox.Publish(ctx, outbox.KindWebhook, sub.ID, delivery{
+			SubscriptionID: sub.ID,
+			Type:           eventType,
+			AccountID:      accountID,
+			OccurredAt:     now,
+			Data:           data,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Sink returns the outbox sink delivering webhook events
+func (m *Manager) Sink() outbox.Sink {
+	return outbox.SinkFunc(m.deliver)
+}
+
+// deliver sends one webhook, retrying transient failures with backoff. The
+// outbox event ID is the webhook ID, stable across redeliveries, so partners
+// can discard duplicates.
+func (m *Manager) deliver(ctx context.Context, event outbox.Event) error {
+	var d delivery
+	if err := json.Unmarshal(event.Payload, &d); err != nil {
+		return outbox.Permanent(err)
+	}
+	sub, err := m.Get(ctx, d.SubscriptionID)
+	if errors.Is(err, ErrNotFound) {
+		metrics.Inc("gateway_webhooks_delivered_total", "event", d.Type, "result", "unsubscribed")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"id":          event.ID,
+		"type":        d.Type,
+		"account_id":  d.AccountID,
+		"occurred_at": d.OccurredAt,
+		"data":        d.Data,
+	})
+	if err != nil {
+		return outbox.Permanent(err)
+	}
+
+	backoff := time.Second
+	for attempt := 1; ; attempt++ {
+		err = m.send(ctx, sub, event.ID, d.Type, body)
+		if err == nil || outbox.IsPermanent(err) || attempt == attemptsPerDelivery {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+
+	result := "ok"
+	if err != nil {
+		result = "failed"
+		m.logger.Warn("Webhook delivery failed",
+			zap.String("subscription", sub.ID),
+			zap.String("partner", sub.Partner),
+			zap.String("event", d.Type),
+			zap.Error(err),
+		)
+	}
+	metrics.Inc("gateway_webhooks_delivered_total", "event", d.Type, "result", result)
+	return err
+}
+
+// send makes one signed request to the subscription's URL. Client errors
+// other than 408 and 429 are permanent.
+func (m *Manager) send(ctx context.Context, sub Subscription, id, eventType string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sub.URL, bytes.NewReader(body))
+	if err != nil {
+		return outbox.Permanent(err)
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "InstagramGateway-Webhooks/1.0")
+	req.Header.Set("Webhook-ID", id)
+	req.Header.Set("Webhook-Event", eventType)
+	req.Header.Set(SignatureHeader, "t="+timestamp+",v1="+Sign(sub.Secret, timestamp, body))
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	err = fmt.Errorf("partner endpoint returned %d", resp.StatusCode)
+	if resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusRequestTimeout && resp.StatusCode != http.StatusTooManyRequests {
+		return outbox.Permanent(err)
+	}
+	return err
+}
+
+// Sign computes the hex HMAC-SHA256 of "<timestamp>.<body>" with secret
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}